@@ -0,0 +1,149 @@
+// package collectd provides a udp listener that understands collectd's
+// binary network protocol and writes the values it receives to a
+// single database. Every value list is written to the series
+// <plugin>.<type> with the host, plugin_instance and type_instance
+// columns, the values are stored in the columns named after the data
+// sources in types.db
+package collectd
+
+import (
+	"configuration"
+	"coordinator"
+	"net"
+	"protocol"
+	"strings"
+
+	log "code.google.com/p/log4go"
+)
+
+// the maximum size of a udp datagram
+const MAX_PACKET_SIZE = 64 * 1024
+
+type Server struct {
+	listenAddress string
+	database      string
+	typesDb       TypesDb
+	parser        *PacketParser
+	coordinator   coordinator.Coordinator
+	conn          *net.UDPConn
+	shutdown      chan bool
+}
+
+func NewServer(config *configuration.Configuration, coord coordinator.Coordinator) (*Server, error) {
+	self := &Server{}
+	self.listenAddress = config.CollectdPortString()
+	self.database = config.CollectdDatabase
+	self.coordinator = coord
+	self.shutdown = make(chan bool, 1)
+	if !config.CollectdEnabled {
+		return self, nil
+	}
+
+	var err error
+	self.typesDb = TypesDb{}
+	if config.CollectdTypesDb != "" {
+		self.typesDb, err = LoadTypesDb(config.CollectdTypesDb)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var passwords map[string]string
+	if config.CollectdAuthFile != "" {
+		passwords, err = LoadAuthFile(config.CollectdAuthFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	self.parser, err = NewPacketParser(config.CollectdSecurityLevel, passwords)
+	if err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+func (self *Server) ListenAndServe() {
+	addr, err := net.ResolveUDPAddr("udp", self.listenAddress)
+	if err != nil {
+		log.Error("CollectdServer: ResolveUDPAddr: ", err)
+		return
+	}
+	self.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Error("CollectdServer: Listen: ", err)
+		return
+	}
+	self.Serve(self.conn)
+}
+
+func (self *Server) Serve(conn *net.UDPConn) {
+	defer func() { self.shutdown <- true }()
+
+	buffer := make([]byte, MAX_PACKET_SIZE)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if strings.Contains(err.Error(), "closed network") {
+				return
+			}
+			log.Error("CollectdServer: Read: ", err)
+			continue
+		}
+		valueLists, err := self.parser.Parse(buffer[:n])
+		if err != nil {
+			log.Warn("CollectdServer: Dropping packet from %s: %s", addr, err)
+			continue
+		}
+		if err := self.writeValueLists(valueLists); err != nil {
+			log.Error("CollectdServer: Cannot write points: %s", err)
+		}
+	}
+}
+
+func (self *Server) Close() {
+	if self.conn != nil {
+		log.Info("CollectdServer: Closing collectd server")
+		self.conn.Close()
+		<-self.shutdown
+	}
+}
+
+func (self *Server) writeValueLists(valueLists []*ValueList) error {
+	if len(valueLists) == 0 {
+		return nil
+	}
+	series := make([]*protocol.Series, 0, len(valueLists))
+	for _, valueList := range valueLists {
+		series = append(series, self.toSeries(valueList))
+	}
+
+	return coordinator.WriteInputSeries(self.coordinator, self.database, series)
+}
+
+func (self *Server) toSeries(valueList *ValueList) *protocol.Series {
+	name := valueList.Plugin + "." + valueList.Type
+	fields := []string{"host", "plugin_instance", "type_instance"}
+	values := []*protocol.FieldValue{
+		&protocol.FieldValue{StringValue: protocol.String(valueList.Host)},
+		&protocol.FieldValue{StringValue: protocol.String(valueList.PluginInstance)},
+		&protocol.FieldValue{StringValue: protocol.String(valueList.TypeInstance)},
+	}
+
+	fields = append(fields, self.typesDb.columns(valueList.Type, len(valueList.Values))...)
+	for _, value := range valueList.Values {
+		switch x := value.(type) {
+		case int64:
+			values = append(values, &protocol.FieldValue{Int64Value: &x})
+		case float64:
+			values = append(values, &protocol.FieldValue{DoubleValue: &x})
+		}
+	}
+
+	timestamp := valueList.Time
+	return &protocol.Series{
+		Name:   &name,
+		Fields: fields,
+		Points: []*protocol.Point{
+			&protocol.Point{Timestamp: &timestamp, Values: values},
+		},
+	}
+}
@@ -0,0 +1,301 @@
+package collectd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// The part types of collectd's binary network protocol, see
+// https://collectd.org/wiki/index.php/Binary_protocol
+const (
+	PART_HOST            = 0x0000
+	PART_TIME            = 0x0001
+	PART_PLUGIN          = 0x0002
+	PART_PLUGIN_INSTANCE = 0x0003
+	PART_TYPE            = 0x0004
+	PART_TYPE_INSTANCE   = 0x0005
+	PART_VALUES          = 0x0006
+	PART_INTERVAL        = 0x0007
+	PART_TIME_HR         = 0x0008
+	PART_INTERVAL_HR     = 0x0009
+	PART_MESSAGE         = 0x0100
+	PART_SEVERITY        = 0x0101
+	PART_SIGNATURE       = 0x0200
+	PART_ENCRYPTION      = 0x0210
+)
+
+// data source types
+const (
+	TYPE_COUNTER  = 0
+	TYPE_GAUGE    = 1
+	TYPE_DERIVE   = 2
+	TYPE_ABSOLUTE = 3
+)
+
+// security levels, sign accepts signed and encrypted packets while
+// encrypt only accepts encrypted packets
+const (
+	SECURITY_NONE    = "none"
+	SECURITY_SIGN    = "sign"
+	SECURITY_ENCRYPT = "encrypt"
+)
+
+const (
+	PART_HEADER_LENGTH = 4
+	SIGNATURE_LENGTH   = sha256.Size
+	IV_LENGTH          = aes.BlockSize
+)
+
+// A set of values sent by one plugin, the values are either int64 or
+// float64. Time is in microseconds.
+type ValueList struct {
+	Host           string
+	Plugin         string
+	PluginInstance string
+	Type           string
+	TypeInstance   string
+	Time           int64
+	Values         []interface{}
+}
+
+type PacketParser struct {
+	securityLevel string
+	passwords     map[string]string
+}
+
+func NewPacketParser(securityLevel string, passwords map[string]string) (*PacketParser, error) {
+	switch securityLevel {
+	case "":
+		securityLevel = SECURITY_NONE
+	case SECURITY_NONE, SECURITY_SIGN, SECURITY_ENCRYPT:
+	default:
+		return nil, fmt.Errorf("Unknown collectd security level %s", securityLevel)
+	}
+	if securityLevel != SECURITY_NONE && len(passwords) == 0 {
+		return nil, fmt.Errorf("collectd security level %s requires an auth file", securityLevel)
+	}
+	return &PacketParser{securityLevel, passwords}, nil
+}
+
+// Loads the passwords from a collectd auth file, which has one
+// "username: password" pair per line
+func LoadAuthFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	passwords := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid line in collectd auth file: %s", line)
+		}
+		passwords[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return passwords, scanner.Err()
+}
+
+type parseState struct {
+	valueList *ValueList
+	signed    bool
+	encrypted bool
+	results   []*ValueList
+}
+
+func (self *PacketParser) Parse(packet []byte) ([]*ValueList, error) {
+	state := &parseState{valueList: &ValueList{}}
+	if err := self.parse(packet, state); err != nil {
+		return nil, err
+	}
+	return state.results, nil
+}
+
+func (self *PacketParser) parse(packet []byte, state *parseState) error {
+	for len(packet) > 0 {
+		if len(packet) < PART_HEADER_LENGTH {
+			return fmt.Errorf("Truncated part header")
+		}
+		partType := binary.BigEndian.Uint16(packet[0:2])
+		length := int(binary.BigEndian.Uint16(packet[2:4]))
+		if length < PART_HEADER_LENGTH || length > len(packet) {
+			return fmt.Errorf("Invalid length %d for part 0x%04x", length, partType)
+		}
+		content := packet[PART_HEADER_LENGTH:length]
+		rest := packet[length:]
+
+		switch partType {
+		case PART_SIGNATURE:
+			// the signature covers the rest of the packet
+			return self.verifySignature(content, rest, state)
+		case PART_ENCRYPTION:
+			if err := self.decrypt(content, state); err != nil {
+				return err
+			}
+		case PART_HOST:
+			state.valueList.Host = parseString(content)
+		case PART_PLUGIN:
+			state.valueList.Plugin = parseString(content)
+		case PART_PLUGIN_INSTANCE:
+			state.valueList.PluginInstance = parseString(content)
+		case PART_TYPE:
+			state.valueList.Type = parseString(content)
+		case PART_TYPE_INSTANCE:
+			state.valueList.TypeInstance = parseString(content)
+		case PART_TIME:
+			if len(content) != 8 {
+				return fmt.Errorf("Invalid time part")
+			}
+			state.valueList.Time = int64(binary.BigEndian.Uint64(content)) * 1000000
+		case PART_TIME_HR:
+			if len(content) != 8 {
+				return fmt.Errorf("Invalid time part")
+			}
+			// high resolution times are in units of 2^-30 seconds
+			t := binary.BigEndian.Uint64(content)
+			state.valueList.Time = int64(t>>30)*1000000 + int64((t&(1<<30-1))*1000000>>30)
+		case PART_VALUES:
+			if !self.isAllowed(state) {
+				return fmt.Errorf("Received values that aren't signed or encrypted with security level %s", self.securityLevel)
+			}
+			values, err := parseValues(content)
+			if err != nil {
+				return err
+			}
+			valueList := *state.valueList
+			valueList.Values = values
+			state.results = append(state.results, &valueList)
+		}
+		packet = rest
+	}
+	return nil
+}
+
+func (self *PacketParser) isAllowed(state *parseState) bool {
+	switch self.securityLevel {
+	case SECURITY_SIGN:
+		return state.signed || state.encrypted
+	case SECURITY_ENCRYPT:
+		return state.encrypted
+	}
+	return true
+}
+
+// the signature part has the HMAC-SHA256 of the username followed by
+// the rest of the packet, then the username
+func (self *PacketParser) verifySignature(content, rest []byte, state *parseState) error {
+	if len(content) <= SIGNATURE_LENGTH {
+		return fmt.Errorf("Invalid signature part")
+	}
+	signature := content[:SIGNATURE_LENGTH]
+	username := content[SIGNATURE_LENGTH:]
+	password, ok := self.passwords[string(username)]
+	if !ok {
+		if self.securityLevel == SECURITY_NONE {
+			return self.parse(rest, state)
+		}
+		return fmt.Errorf("Unknown collectd user %s", username)
+	}
+
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write(username)
+	mac.Write(rest)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return fmt.Errorf("Invalid signature for collectd user %s", username)
+	}
+	state.signed = true
+	return self.parse(rest, state)
+}
+
+// the encryption part has the length of the username, the username,
+// the IV and the encrypted data. The data is encrypted with AES-256 in
+// OFB mode using the SHA-256 of the password as the key, and starts
+// with the SHA-1 of the parts that follow it
+func (self *PacketParser) decrypt(content []byte, state *parseState) error {
+	if len(content) < 2 {
+		return fmt.Errorf("Invalid encryption part")
+	}
+	usernameLength := int(binary.BigEndian.Uint16(content[0:2]))
+	if len(content) < 2+usernameLength+IV_LENGTH+sha1.Size {
+		return fmt.Errorf("Invalid encryption part")
+	}
+	username := string(content[2 : 2+usernameLength])
+	iv := content[2+usernameLength : 2+usernameLength+IV_LENGTH]
+	encrypted := content[2+usernameLength+IV_LENGTH:]
+
+	password, ok := self.passwords[username]
+	if !ok {
+		return fmt.Errorf("Unknown collectd user %s", username)
+	}
+	key := sha256.Sum256([]byte(password))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewOFB(block, iv).XORKeyStream(decrypted, encrypted)
+
+	checksum := sha1.Sum(decrypted[sha1.Size:])
+	if !bytes.Equal(checksum[:], decrypted[:sha1.Size]) {
+		return fmt.Errorf("Cannot decrypt packet from collectd user %s", username)
+	}
+
+	encryptedState := &parseState{valueList: state.valueList, signed: state.signed, encrypted: true}
+	err = self.parse(decrypted[sha1.Size:], encryptedState)
+	state.results = append(state.results, encryptedState.results...)
+	return err
+}
+
+// strings are null terminated
+func parseString(content []byte) string {
+	return string(bytes.TrimRight(content, "\x00"))
+}
+
+// the values part has the number of values, the type of every value
+// and then the values themselves
+func parseValues(content []byte) ([]interface{}, error) {
+	if len(content) < 2 {
+		return nil, fmt.Errorf("Invalid values part")
+	}
+	count := int(binary.BigEndian.Uint16(content[0:2]))
+	if len(content) != 2+count*9 {
+		return nil, fmt.Errorf("Invalid values part, expected %d values", count)
+	}
+
+	types := content[2 : 2+count]
+	reader := bytes.NewReader(content[2+count:])
+	values := make([]interface{}, 0, count)
+	for _, t := range types {
+		data := make([]byte, 8)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		switch t {
+		case TYPE_COUNTER, TYPE_DERIVE, TYPE_ABSOLUTE:
+			values = append(values, int64(binary.BigEndian.Uint64(data)))
+		case TYPE_GAUGE:
+			// gauges are the only values in little endian
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(data)))
+		default:
+			return nil, fmt.Errorf("Unknown data source type %d", t)
+		}
+	}
+	return values, nil
+}
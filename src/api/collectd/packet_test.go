@@ -0,0 +1,167 @@
+package collectd
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	. "launchpad.net/gocheck"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type PacketSuite struct{}
+
+var _ = Suite(&PacketSuite{})
+
+func part(partType uint16, content []byte) []byte {
+	buffer := bytes.NewBuffer(nil)
+	binary.Write(buffer, binary.BigEndian, partType)
+	binary.Write(buffer, binary.BigEndian, uint16(len(content)+PART_HEADER_LENGTH))
+	buffer.Write(content)
+	return buffer.Bytes()
+}
+
+func stringPart(partType uint16, s string) []byte {
+	return part(partType, append([]byte(s), 0))
+}
+
+func numberPart(partType uint16, n uint64) []byte {
+	content := make([]byte, 8)
+	binary.BigEndian.PutUint64(content, n)
+	return part(partType, content)
+}
+
+func valuesPart(gauge float64, derive int64) []byte {
+	buffer := bytes.NewBuffer(nil)
+	binary.Write(buffer, binary.BigEndian, uint16(2))
+	buffer.Write([]byte{TYPE_GAUGE, TYPE_DERIVE})
+	binary.Write(buffer, binary.LittleEndian, math.Float64bits(gauge))
+	binary.Write(buffer, binary.BigEndian, derive)
+	return part(PART_VALUES, buffer.Bytes())
+}
+
+func samplePacket() []byte {
+	packet := stringPart(PART_HOST, "server1")
+	packet = append(packet, numberPart(PART_TIME_HR, 1400000000<<30|1<<29)...)
+	packet = append(packet, stringPart(PART_PLUGIN, "interface")...)
+	packet = append(packet, stringPart(PART_PLUGIN_INSTANCE, "eth0")...)
+	packet = append(packet, stringPart(PART_TYPE, "if_octets")...)
+	packet = append(packet, valuesPart(1.5, 10)...)
+	packet = append(packet, stringPart(PART_PLUGIN_INSTANCE, "eth1")...)
+	return append(packet, valuesPart(2.5, -20)...)
+}
+
+func signedPacket(username, password string, packet []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(username))
+	mac.Write(packet)
+	signature := append(mac.Sum(nil), []byte(username)...)
+	return append(part(PART_SIGNATURE, signature), packet...)
+}
+
+func encryptedPacket(username, password string, packet []byte) []byte {
+	checksum := sha1.Sum(packet)
+	plaintext := append(checksum[:], packet...)
+	key := sha256.Sum256([]byte(password))
+	block, _ := aes.NewCipher(key[:])
+	iv := bytes.Repeat([]byte{1}, IV_LENGTH)
+	encrypted := make([]byte, len(plaintext))
+	cipher.NewOFB(block, iv).XORKeyStream(encrypted, plaintext)
+
+	buffer := bytes.NewBuffer(nil)
+	binary.Write(buffer, binary.BigEndian, uint16(len(username)))
+	buffer.WriteString(username)
+	buffer.Write(iv)
+	buffer.Write(encrypted)
+	return part(PART_ENCRYPTION, buffer.Bytes())
+}
+
+func (self *PacketSuite) assertSamplePacket(c *C, valueLists []*ValueList) {
+	c.Assert(valueLists, HasLen, 2)
+	c.Assert(valueLists[0].Host, Equals, "server1")
+	c.Assert(valueLists[0].Plugin, Equals, "interface")
+	c.Assert(valueLists[0].PluginInstance, Equals, "eth0")
+	c.Assert(valueLists[0].Type, Equals, "if_octets")
+	c.Assert(valueLists[0].Time, Equals, int64(1400000000500000))
+	c.Assert(valueLists[0].Values, DeepEquals, []interface{}{1.5, int64(10)})
+	c.Assert(valueLists[1].PluginInstance, Equals, "eth1")
+	c.Assert(valueLists[1].Values, DeepEquals, []interface{}{2.5, int64(-20)})
+}
+
+func (self *PacketSuite) TestParsingPacket(c *C) {
+	parser, err := NewPacketParser("", nil)
+	c.Assert(err, IsNil)
+	valueLists, err := parser.Parse(samplePacket())
+	c.Assert(err, IsNil)
+	self.assertSamplePacket(c, valueLists)
+}
+
+func (self *PacketSuite) TestParsingTruncatedPacket(c *C) {
+	parser, err := NewPacketParser(SECURITY_NONE, nil)
+	c.Assert(err, IsNil)
+	packet := samplePacket()
+	_, err = parser.Parse(packet[:len(packet)-3])
+	c.Assert(err, NotNil)
+}
+
+func (self *PacketSuite) TestSignedPackets(c *C) {
+	parser, err := NewPacketParser(SECURITY_SIGN, map[string]string{"user": "secret"})
+	c.Assert(err, IsNil)
+
+	valueLists, err := parser.Parse(signedPacket("user", "secret", samplePacket()))
+	c.Assert(err, IsNil)
+	self.assertSamplePacket(c, valueLists)
+
+	_, err = parser.Parse(signedPacket("user", "wrong", samplePacket()))
+	c.Assert(err, NotNil)
+	_, err = parser.Parse(samplePacket())
+	c.Assert(err, NotNil)
+}
+
+func (self *PacketSuite) TestEncryptedPackets(c *C) {
+	parser, err := NewPacketParser(SECURITY_ENCRYPT, map[string]string{"user": "secret"})
+	c.Assert(err, IsNil)
+
+	valueLists, err := parser.Parse(encryptedPacket("user", "secret", samplePacket()))
+	c.Assert(err, IsNil)
+	self.assertSamplePacket(c, valueLists)
+
+	_, err = parser.Parse(encryptedPacket("user", "wrong", samplePacket()))
+	c.Assert(err, NotNil)
+	_, err = parser.Parse(signedPacket("user", "secret", samplePacket()))
+	c.Assert(err, NotNil)
+}
+
+func (self *PacketSuite) TestSecurityLevelRequiresPasswords(c *C) {
+	_, err := NewPacketParser(SECURITY_SIGN, nil)
+	c.Assert(err, NotNil)
+	_, err = NewPacketParser("foo", nil)
+	c.Assert(err, NotNil)
+}
+
+func (self *PacketSuite) TestParsingTypesDb(c *C) {
+	typesDb, err := ParseTypesDb(strings.NewReader(`
+# a comment
+if_octets rx:DERIVE:0:U, tx:DERIVE:0:U
+load shortterm:GAUGE:0:5000, midterm:GAUGE:0:5000, longterm:GAUGE:0:5000
+`))
+	c.Assert(err, IsNil)
+	c.Assert(typesDb, HasLen, 2)
+	c.Assert(typesDb.columns("if_octets", 2), DeepEquals, []string{"rx", "tx"})
+	c.Assert(typesDb.columns("load", 3), DeepEquals, []string{"shortterm", "midterm", "longterm"})
+	c.Assert(typesDb.columns("unknown", 1), DeepEquals, []string{"value"})
+	c.Assert(typesDb.columns("unknown", 2), DeepEquals, []string{"value0", "value1"})
+
+	_, err = ParseTypesDb(strings.NewReader("if_octets rx:FOO:0:U"))
+	c.Assert(err, NotNil)
+}
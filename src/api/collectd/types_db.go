@@ -0,0 +1,86 @@
+package collectd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The data sources of every type in collectd's types.db. The names of
+// the data sources are used as the columns of the values.
+type TypesDb map[string][]*DataSource
+
+type DataSource struct {
+	Name string
+	Type uint8
+}
+
+func LoadTypesDb(path string) (TypesDb, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTypesDb(f)
+}
+
+// Parses types.db, it has one type per line that looks like:
+//
+//	if_octets rx:DERIVE:0:U, tx:DERIVE:0:U
+func ParseTypesDb(r io.Reader) (TypesDb, error) {
+	typesDb := make(TypesDb)
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("types.db line %d: expected a type and its data sources", lineNumber)
+		}
+		dataSources := make([]*DataSource, 0, len(fields)-1)
+		for _, field := range fields[1:] {
+			parts := strings.Split(strings.TrimSuffix(field, ","), ":")
+			if len(parts) != 4 {
+				return nil, fmt.Errorf("types.db line %d: invalid data source '%s'", lineNumber, field)
+			}
+			dataSourceType, ok := dataSourceTypes[parts[1]]
+			if !ok {
+				return nil, fmt.Errorf("types.db line %d: unknown data source type '%s'", lineNumber, parts[1])
+			}
+			dataSources = append(dataSources, &DataSource{parts[0], dataSourceType})
+		}
+		typesDb[fields[0]] = dataSources
+	}
+	return typesDb, scanner.Err()
+}
+
+var dataSourceTypes = map[string]uint8{
+	"COUNTER":  TYPE_COUNTER,
+	"GAUGE":    TYPE_GAUGE,
+	"DERIVE":   TYPE_DERIVE,
+	"ABSOLUTE": TYPE_ABSOLUTE,
+}
+
+// returns the column names of the values of the given type
+func (self TypesDb) columns(typeName string, count int) []string {
+	columns := make([]string, count)
+	dataSources := self[typeName]
+	for i := range columns {
+		switch {
+		case len(dataSources) == count:
+			columns[i] = dataSources[i].Name
+		case count == 1:
+			columns[i] = "value"
+		default:
+			columns[i] = fmt.Sprintf("value%d", i)
+		}
+	}
+	return columns
+}
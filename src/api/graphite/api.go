@@ -14,49 +14,57 @@ package graphite
 
 import (
 	"bufio"
-	"cluster"
-	. "common"
 	"configuration"
 	"coordinator"
+	"encoding/binary"
+	"io"
 	"net"
 	"protocol"
+	"strings"
 	"time"
 
 	log "code.google.com/p/log4go"
 )
 
 type Server struct {
-	listenAddress string
-	database      string
-	coordinator   coordinator.Coordinator
-	clusterConfig *cluster.ClusterConfiguration
-	conn          net.Listener
-	user          *cluster.ClusterAdmin
-	shutdown      chan bool
+	listenAddress       string
+	pickleListenAddress string
+	pickleMaxSize       int
+	database            string
+	templates           []*Template
+	coordinator         coordinator.Coordinator
+	conn                net.Listener
+	pickleConn          net.Listener
+	shutdown            chan bool
 }
 
 // TODO: check that database exists and create it if not
-func NewServer(config *configuration.Configuration, coord coordinator.Coordinator, clusterConfig *cluster.ClusterConfiguration) *Server {
+func NewServer(config *configuration.Configuration, coord coordinator.Coordinator) (*Server, error) {
+	templates, err := ParseTemplates(config.GraphiteTemplates)
+	if err != nil {
+		return nil, err
+	}
 	self := &Server{}
 	self.listenAddress = config.GraphitePortString()
+	self.pickleListenAddress = config.GraphitePicklePortString()
+	self.pickleMaxSize = config.GraphitePickleMaxSize
 	self.database = config.GraphiteDatabase
+	self.templates = templates
 	self.coordinator = coord
 	self.shutdown = make(chan bool, 1)
-	self.clusterConfig = clusterConfig
-	return self
-}
-
-// getAuth assures that the user property is a user with access to the graphite database
-// only call this function after everything (i.e. Raft) is initialized, so that there's at least 1 admin user
-func (self *Server) getAuth() {
-	// just use any (the first) of the list of admins.
-	names := self.clusterConfig.GetClusterAdmins()
-	self.user = self.clusterConfig.GetClusterAdmin(names[0])
+	return self, nil
 }
 
 func (self *Server) ListenAndServe() {
-	self.getAuth()
 	var err error
+	if self.pickleListenAddress != "" {
+		self.pickleConn, err = net.Listen("tcp", self.pickleListenAddress)
+		if err != nil {
+			log.Error("GraphiteServer: Listen: ", err)
+			return
+		}
+		go self.servePickle(self.pickleConn)
+	}
 	if self.listenAddress != "" {
 		self.conn, err = net.Listen("tcp", self.listenAddress)
 		if err != nil {
@@ -82,7 +90,24 @@ func (self *Server) Serve(listener net.Listener) {
 	}
 }
 
+func (self *Server) servePickle(listener net.Listener) {
+	for {
+		conn_in, err := listener.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "closed network") {
+				return
+			}
+			log.Error("GraphiteServer: Accept: ", err)
+			continue
+		}
+		go self.handlePickleClient(conn_in)
+	}
+}
+
 func (self *Server) Close() {
+	if self.pickleConn != nil {
+		self.pickleConn.Close()
+	}
 	if self.conn != nil {
 		log.Info("GraphiteServer: Closing graphite server")
 		self.conn.Close()
@@ -96,20 +121,9 @@ func (self *Server) Close() {
 }
 
 func (self *Server) writePoints(series *protocol.Series) error {
-	serie := []*protocol.Series{series}
-	err := self.coordinator.WriteSeriesData(self.user, self.database, serie)
+	err := coordinator.WriteInputSeries(self.coordinator, self.database, []*protocol.Series{series})
 	if err != nil {
-		switch err.(type) {
-		case AuthorizationError:
-			// user information got stale, get a fresh one (this should happen rarely)
-			self.getAuth()
-			err = self.coordinator.WriteSeriesData(self.user, self.database, serie)
-			if err != nil {
-				log.Warn("GraphiteServer: failed to write series after getting new auth: %s\n", err.Error())
-			}
-		default:
-			log.Warn("GraphiteServer: failed write series: %s\n", err.Error())
-		}
+		log.Warn("GraphiteServer: failed write series: %s\n", err.Error())
 	}
 	return err
 }
@@ -124,26 +138,51 @@ func (self *Server) handleClient(conn net.Conn) {
 			log.Error(err)
 			return
 		}
-		values := []*protocol.FieldValue{}
-		if graphiteMetric.isInt {
-			values = append(values, &protocol.FieldValue{Int64Value: &graphiteMetric.integerValue})
-		} else {
-			values = append(values, &protocol.FieldValue{DoubleValue: &graphiteMetric.floatValue})
-		}
-		sn := uint64(1) // use same SN makes sure that we'll only keep the latest value for a given metric_id-timestamp pair
-		point := &protocol.Point{
-			Timestamp:      &graphiteMetric.timestamp,
-			Values:         values,
-			SequenceNumber: &sn,
-		}
-		series := &protocol.Series{
-			Name:   &graphiteMetric.name,
-			Fields: []string{"value"},
-			Points: []*protocol.Point{point},
-		}
+		series := graphiteMetric.toSeries(self.templates)
 		// little inefficient for now, later we might want to add multiple series in 1 writePoints request
 		if err := self.writePoints(series); err != nil {
 			log.Error("Error in graphite plugin: %s", err)
 		}
 	}
 }
+
+// handles connections using carbon's pickle protocol. Every message
+// is a pickled list of (path, (timestamp, value)) tuples prefixed with
+// its length as a big endian uint32
+func (self *Server) handlePickleClient(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		var length uint32
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+			if err != io.EOF {
+				log.Error("GraphiteServer: connection closed uncleanly/broken: %s", err)
+			}
+			return
+		}
+		if int64(length) > int64(self.pickleMaxSize) {
+			log.Error("GraphiteServer: pickle message of %d bytes is too large", length)
+			return
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			log.Error("GraphiteServer: incomplete pickle message: %s", err)
+			return
+		}
+		v, err := unpickle(data)
+		if err != nil {
+			log.Error("GraphiteServer: cannot unpickle message: %s", err)
+			return
+		}
+		metrics, err := metricsFromPickle(v)
+		if err != nil {
+			log.Error("GraphiteServer: %s", err)
+			return
+		}
+		for _, metric := range metrics {
+			if err := self.writePoints(metric.toSeries(self.templates)); err != nil {
+				log.Error("Error in graphite plugin: %s", err)
+			}
+		}
+	}
+}
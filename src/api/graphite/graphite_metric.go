@@ -2,8 +2,10 @@ package graphite
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"protocol"
 	"strconv"
 	"strings"
 )
@@ -33,14 +35,11 @@ func (self *GraphiteMetric) Read(reader *bufio.Reader) error {
 		return fmt.Errorf("Received '%s' which doesn't have three fields", str)
 	}
 	self.name = elements[0]
-	self.floatValue, err = strconv.ParseFloat(elements[1], 64)
+	value, err := strconv.ParseFloat(elements[1], 64)
 	if err != nil {
 		return err
 	}
-	if i := int64(self.floatValue); float64(i) == self.floatValue {
-		self.isInt = true
-		self.integerValue = int64(self.floatValue)
-	}
+	self.setValue(value)
 	timestamp, err := strconv.ParseUint(elements[2], 10, 32)
 	if err != nil {
 		return err
@@ -48,3 +47,121 @@ func (self *GraphiteMetric) Read(reader *bufio.Reader) error {
 	self.timestamp = int64(timestamp * 1000000)
 	return nil
 }
+
+func (self *GraphiteMetric) setValue(value float64) {
+	self.floatValue = value
+	if i := int64(self.floatValue); float64(i) == self.floatValue {
+		self.isInt = true
+		self.integerValue = int64(self.floatValue)
+	}
+}
+
+// Converts the metric to a series using the first template that
+// matches the metric name. Metrics that don't match any template are
+// stored in a series with the same name and a single value column.
+func (self *GraphiteMetric) toSeries(templates []*Template) *protocol.Series {
+	name := self.name
+	fields := []string{}
+	values := []*protocol.FieldValue{}
+	for _, template := range templates {
+		series, columns, columnValues, ok := template.Apply(self.name)
+		if !ok {
+			continue
+		}
+		name = series
+		fields = columns
+		for i := range columnValues {
+			values = append(values, &protocol.FieldValue{StringValue: &columnValues[i]})
+		}
+		break
+	}
+
+	fields = append(fields, "value")
+	if self.isInt {
+		values = append(values, &protocol.FieldValue{Int64Value: &self.integerValue})
+	} else {
+		values = append(values, &protocol.FieldValue{DoubleValue: &self.floatValue})
+	}
+	sn := uint64(1) // use same SN makes sure that we'll only keep the latest value for a given metric_id-timestamp pair
+	point := &protocol.Point{
+		Timestamp:      &self.timestamp,
+		Values:         values,
+		SequenceNumber: &sn,
+	}
+	return &protocol.Series{
+		Name:   &name,
+		Fields: fields,
+		Points: []*protocol.Point{point},
+	}
+}
+
+// Converts the unpickled list of (path, (timestamp, value)) tuples
+// sent by carbon clients to metrics
+func metricsFromPickle(v interface{}) ([]*GraphiteMetric, error) {
+	list, ok := v.(*pickleList)
+	if !ok {
+		return nil, fmt.Errorf("Expected a list of metrics, got %T", v)
+	}
+
+	metrics := make([]*GraphiteMetric, 0, len(list.items))
+	for _, item := range list.items {
+		tuple, ok := item.([]interface{})
+		if !ok || len(tuple) != 2 {
+			return nil, fmt.Errorf("Expected a (path, (timestamp, value)) tuple, got %v", item)
+		}
+		name, ok := tuple[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("Expected a metric name, got %v", tuple[0])
+		}
+		datapoint, ok := tuple[1].([]interface{})
+		if !ok || len(datapoint) != 2 {
+			return nil, fmt.Errorf("Expected a (timestamp, value) tuple, got %v", tuple[1])
+		}
+		timestamp, err := pickleNumber(datapoint[0])
+		if err != nil {
+			return nil, err
+		}
+		value, err := pickleNumber(datapoint[1])
+		if err != nil {
+			return nil, err
+		}
+
+		metric := &GraphiteMetric{name: name, timestamp: int64(timestamp) * 1000000}
+		metric.setValue(value)
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+func pickleNumber(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), nil
+	case float64:
+		return x, nil
+	case string:
+		return strconv.ParseFloat(x, 64)
+	}
+	return 0, fmt.Errorf("Expected a number, got %v", v)
+}
+
+// Parses lines in graphite's plaintext format and converts them to
+// series using the given templates
+func ParseLines(data []byte, templates []*Template) ([]*protocol.Series, error) {
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	reader := bufio.NewReader(bytes.NewReader(data))
+	series := []*protocol.Series{}
+	for {
+		metric := &GraphiteMetric{}
+		err := metric.Read(reader)
+		if err == io.EOF {
+			return series, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, metric.toSeries(templates))
+	}
+}
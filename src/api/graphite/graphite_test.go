@@ -0,0 +1,101 @@
+package graphite
+
+import (
+	. "launchpad.net/gocheck"
+	"testing"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type GraphiteSuite struct{}
+
+var _ = Suite(&GraphiteSuite{})
+
+func (self *GraphiteSuite) TestTemplateWithSeriesName(c *C) {
+	template, err := ParseTemplate("servers.<host>.cpu.<core> cpu")
+	c.Assert(err, IsNil)
+	series, columns, values, ok := template.Apply("servers.foo.cpu.0")
+	c.Assert(ok, Equals, true)
+	c.Assert(series, Equals, "cpu")
+	c.Assert(columns, DeepEquals, []string{"host", "core"})
+	c.Assert(values, DeepEquals, []string{"foo", "0"})
+
+	_, _, _, ok = template.Apply("servers.foo.mem.0")
+	c.Assert(ok, Equals, false)
+	_, _, _, ok = template.Apply("servers.foo.cpu")
+	c.Assert(ok, Equals, false)
+}
+
+func (self *GraphiteSuite) TestTemplateWithoutSeriesName(c *C) {
+	template, err := ParseTemplate("servers.<host>.*.load")
+	c.Assert(err, IsNil)
+	series, columns, values, ok := template.Apply("servers.foo.system.load")
+	c.Assert(ok, Equals, true)
+	c.Assert(series, Equals, "servers.system.load")
+	c.Assert(columns, DeepEquals, []string{"host"})
+	c.Assert(values, DeepEquals, []string{"foo"})
+}
+
+func (self *GraphiteSuite) TestInvalidTemplates(c *C) {
+	for _, template := range []string{"", "servers..cpu", "servers.<>.cpu", "a b c"} {
+		_, err := ParseTemplate(template)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (self *GraphiteSuite) TestMetricToSeries(c *C) {
+	templates, err := ParseTemplates([]string{"servers.<host>.cpu.<core> cpu"})
+	c.Assert(err, IsNil)
+	metric := &GraphiteMetric{name: "servers.foo.cpu.0", timestamp: 1000000}
+	metric.setValue(1.5)
+	series := metric.toSeries(templates)
+	c.Assert(series.GetName(), Equals, "cpu")
+	c.Assert(series.Fields, DeepEquals, []string{"host", "core", "value"})
+	c.Assert(series.Points[0].Values[0].GetStringValue(), Equals, "foo")
+	c.Assert(series.Points[0].Values[2].GetDoubleValue(), Equals, 1.5)
+
+	metric = &GraphiteMetric{name: "foo.bar", timestamp: 1000000}
+	metric.setValue(2)
+	series = metric.toSeries(templates)
+	c.Assert(series.GetName(), Equals, "foo.bar")
+	c.Assert(series.Fields, DeepEquals, []string{"value"})
+	c.Assert(series.Points[0].Values[0].GetInt64Value(), Equals, int64(2))
+}
+
+// pickle.dumps([("servers.a.cpu.0", (1400000000, 1.5)), ("foo", (1400000001.0, 2))], protocol)
+var pickles = map[string][]byte{
+	"protocol 0": []byte("(lp0\n(Vservers.a.cpu.0\np1\n(I1400000000\nF1.5\ntp2\ntp3\na(Vfoo\np4\n(F1400000001.0\nI2\ntp5\ntp6\na."),
+	"protocol 2": []byte{128, 2, 93, 113, 0, 40, 88, 15, 0, 0, 0, 115, 101, 114, 118, 101, 114, 115, 46, 97, 46, 99, 112, 117, 46, 48, 113, 1, 74, 0, 78, 114, 83, 71, 63, 248, 0, 0, 0, 0, 0, 0, 134, 113, 2, 134, 113, 3, 88, 3, 0, 0, 0, 102, 111, 111, 113, 4, 71, 65, 212, 220, 147, 128, 64, 0, 0, 75, 2, 134, 113, 5, 134, 113, 6, 101, 46},
+}
+
+func (self *GraphiteSuite) TestUnpickle(c *C) {
+	for protocol, data := range pickles {
+		v, err := unpickle(data)
+		c.Assert(err, IsNil, Commentf("%s", protocol))
+		metrics, err := metricsFromPickle(v)
+		c.Assert(err, IsNil, Commentf("%s", protocol))
+		c.Assert(metrics, HasLen, 2)
+		c.Assert(metrics[0].name, Equals, "servers.a.cpu.0")
+		c.Assert(metrics[0].timestamp, Equals, int64(1400000000000000))
+		c.Assert(metrics[0].floatValue, Equals, 1.5)
+		c.Assert(metrics[1].name, Equals, "foo")
+		c.Assert(metrics[1].isInt, Equals, true)
+		c.Assert(metrics[1].integerValue, Equals, int64(2))
+	}
+}
+
+func (self *GraphiteSuite) TestUnpickleInvalidData(c *C) {
+	_, err := unpickle([]byte{128, 2, 93})
+	c.Assert(err, NotNil)
+	_, err = unpickle([]byte("cos\nsystem\n."))
+	c.Assert(err, NotNil)
+	// pops the item below the mark before the list is built
+	_, err = unpickle([]byte("K\x01(0l."))
+	c.Assert(err, NotNil)
+	// a binstring that claims to be 4GB long
+	_, err = unpickle([]byte("X\xff\xff\xff\xffab."))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,384 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A small unpickler that only supports the opcodes that python uses
+// to pickle the lists of (path, (timestamp, value)) tuples that carbon
+// clients send. Lists are decoded as *pickleList since they can be
+// appended to after they're created, tuples are decoded as
+// []interface{}.
+type pickleList struct {
+	items []interface{}
+}
+
+type unpickler struct {
+	reader *bytes.Reader
+	stack  []interface{}
+	marks  []int
+	memo   map[int]interface{}
+}
+
+func unpickle(data []byte) (interface{}, error) {
+	self := &unpickler{
+		reader: bytes.NewReader(data),
+		memo:   make(map[int]interface{}),
+	}
+	return self.load()
+}
+
+func (self *unpickler) load() (interface{}, error) {
+	for {
+		opcode, err := self.reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("Unexpected end of pickle: %s", err)
+		}
+
+		switch opcode {
+		case '.': // STOP
+			return self.pop()
+		case 0x80: // PROTO
+			if _, err := self.reader.ReadByte(); err != nil {
+				return nil, err
+			}
+		case 0x95: // FRAME
+			if _, err := self.readBytes(8); err != nil {
+				return nil, err
+			}
+		case '(': // MARK
+			self.marks = append(self.marks, len(self.stack))
+		case '0': // POP
+			if _, err := self.pop(); err != nil {
+				return nil, err
+			}
+		case 'N': // NONE
+			self.push(nil)
+		case 0x88: // NEWTRUE
+			self.push(true)
+		case 0x89: // NEWFALSE
+			self.push(false)
+		case ']': // EMPTY_LIST
+			self.push(&pickleList{})
+		case 'l': // LIST
+			items, err := self.popMark()
+			if err != nil {
+				return nil, err
+			}
+			self.push(&pickleList{items})
+		case ')': // EMPTY_TUPLE
+			self.push([]interface{}{})
+		case 't': // TUPLE
+			items, err := self.popMark()
+			if err != nil {
+				return nil, err
+			}
+			self.push(items)
+		case 0x85, 0x86, 0x87: // TUPLE1, TUPLE2, TUPLE3
+			n := int(opcode-0x85) + 1
+			if len(self.stack) < n {
+				return nil, fmt.Errorf("Stack underflow")
+			}
+			items := make([]interface{}, n)
+			copy(items, self.stack[len(self.stack)-n:])
+			self.stack = self.stack[:len(self.stack)-n]
+			self.push(items)
+		case 'a': // APPEND
+			item, err := self.pop()
+			if err != nil {
+				return nil, err
+			}
+			if err := self.appendToList([]interface{}{item}); err != nil {
+				return nil, err
+			}
+		case 'e': // APPENDS
+			items, err := self.popMark()
+			if err != nil {
+				return nil, err
+			}
+			if err := self.appendToList(items); err != nil {
+				return nil, err
+			}
+		case 'p': // PUT
+			line, err := self.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if err := self.put(strconv.Atoi(line)); err != nil {
+				return nil, err
+			}
+		case 'q': // BINPUT
+			b, err := self.reader.ReadByte()
+			if err := self.put(int(b), err); err != nil {
+				return nil, err
+			}
+		case 'r': // LONG_BINPUT
+			n, err := self.readUint32()
+			if err := self.put(int(n), err); err != nil {
+				return nil, err
+			}
+		case 0x94: // MEMOIZE
+			if err := self.put(len(self.memo), nil); err != nil {
+				return nil, err
+			}
+		case 'g': // GET
+			line, err := self.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if err := self.get(strconv.Atoi(line)); err != nil {
+				return nil, err
+			}
+		case 'h': // BINGET
+			b, err := self.reader.ReadByte()
+			if err := self.get(int(b), err); err != nil {
+				return nil, err
+			}
+		case 'j': // LONG_BINGET
+			n, err := self.readUint32()
+			if err := self.get(int(n), err); err != nil {
+				return nil, err
+			}
+		case 'I': // INT
+			line, err := self.readLine()
+			if err != nil {
+				return nil, err
+			}
+			switch line {
+			case "00":
+				self.push(false)
+			case "01":
+				self.push(true)
+			default:
+				i, err := strconv.ParseInt(line, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				self.push(i)
+			}
+		case 'L': // LONG
+			line, err := self.readLine()
+			if err != nil {
+				return nil, err
+			}
+			i, err := strconv.ParseInt(strings.TrimSuffix(line, "L"), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			self.push(i)
+		case 'J': // BININT
+			n, err := self.readUint32()
+			if err != nil {
+				return nil, err
+			}
+			self.push(int64(int32(n)))
+		case 'K': // BININT1
+			b, err := self.reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			self.push(int64(b))
+		case 'M': // BININT2
+			data, err := self.readBytes(2)
+			if err != nil {
+				return nil, err
+			}
+			self.push(int64(binary.LittleEndian.Uint16(data)))
+		case 0x8a: // LONG1
+			length, err := self.reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			data, err := self.readBytes(int(length))
+			if err != nil {
+				return nil, err
+			}
+			i, err := decodeLong(data)
+			if err != nil {
+				return nil, err
+			}
+			self.push(i)
+		case 'F': // FLOAT
+			line, err := self.readLine()
+			if err != nil {
+				return nil, err
+			}
+			f, err := strconv.ParseFloat(line, 64)
+			if err != nil {
+				return nil, err
+			}
+			self.push(f)
+		case 'G': // BINFLOAT
+			data, err := self.readBytes(8)
+			if err != nil {
+				return nil, err
+			}
+			self.push(math.Float64frombits(binary.BigEndian.Uint64(data)))
+		case 'S': // STRING
+			line, err := self.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if len(line) < 2 {
+				return nil, fmt.Errorf("Invalid string %s", line)
+			}
+			self.push(line[1 : len(line)-1])
+		case 'V': // UNICODE
+			line, err := self.readLine()
+			if err != nil {
+				return nil, err
+			}
+			self.push(line)
+		case 'T', 'X', 'B': // BINSTRING, BINUNICODE, BINBYTES
+			n, err := self.readUint32()
+			if err != nil {
+				return nil, err
+			}
+			if err := self.pushString(int(n)); err != nil {
+				return nil, err
+			}
+		case 'U', 0x8c, 'C': // SHORT_BINSTRING, SHORT_BINUNICODE, SHORT_BINBYTES
+			n, err := self.reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if err := self.pushString(int(n)); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported pickle opcode 0x%x", opcode)
+		}
+	}
+}
+
+func (self *unpickler) push(v interface{}) {
+	self.stack = append(self.stack, v)
+}
+
+func (self *unpickler) pop() (interface{}, error) {
+	if len(self.stack) == 0 {
+		return nil, fmt.Errorf("Stack underflow")
+	}
+	v := self.stack[len(self.stack)-1]
+	self.stack = self.stack[:len(self.stack)-1]
+	return v, nil
+}
+
+// pops everything up to the last mark
+func (self *unpickler) popMark() ([]interface{}, error) {
+	if len(self.marks) == 0 {
+		return nil, fmt.Errorf("Missing mark")
+	}
+	mark := self.marks[len(self.marks)-1]
+	self.marks = self.marks[:len(self.marks)-1]
+	// the items below the mark might have been popped since
+	if mark > len(self.stack) {
+		return nil, fmt.Errorf("Stack underflow")
+	}
+	items := make([]interface{}, len(self.stack)-mark)
+	copy(items, self.stack[mark:])
+	self.stack = self.stack[:mark]
+	return items, nil
+}
+
+func (self *unpickler) appendToList(items []interface{}) error {
+	if len(self.stack) == 0 {
+		return fmt.Errorf("Stack underflow")
+	}
+	list, ok := self.stack[len(self.stack)-1].(*pickleList)
+	if !ok {
+		return fmt.Errorf("Cannot append to %T", self.stack[len(self.stack)-1])
+	}
+	list.items = append(list.items, items...)
+	return nil
+}
+
+func (self *unpickler) put(index int, err error) error {
+	if err != nil {
+		return err
+	}
+	if len(self.stack) == 0 {
+		return fmt.Errorf("Stack underflow")
+	}
+	self.memo[index] = self.stack[len(self.stack)-1]
+	return nil
+}
+
+func (self *unpickler) get(index int, err error) error {
+	if err != nil {
+		return err
+	}
+	v, ok := self.memo[index]
+	if !ok {
+		return fmt.Errorf("Memo %d doesn't exist", index)
+	}
+	self.push(v)
+	return nil
+}
+
+func (self *unpickler) pushString(length int) error {
+	data, err := self.readBytes(length)
+	if err != nil {
+		return err
+	}
+	self.push(string(data))
+	return nil
+}
+
+func (self *unpickler) readLine() (string, error) {
+	var line []byte
+	for {
+		b, err := self.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' {
+			return strings.TrimRight(string(line), "\r"), nil
+		}
+		line = append(line, b)
+	}
+}
+
+// the lengths come from the message, so they're checked against what's
+// left of it before anything is allocated
+func (self *unpickler) readBytes(length int) ([]byte, error) {
+	if length < 0 || length > self.reader.Len() {
+		return nil, fmt.Errorf("Length %d is past the end of the pickle", length)
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(self.reader, data)
+	return data, err
+}
+
+func (self *unpickler) readUint32() (uint32, error) {
+	data, err := self.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+// decodes a little endian two's complement integer
+func decodeLong(data []byte) (int64, error) {
+	if len(data) > 8 {
+		return 0, fmt.Errorf("Integer too large")
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	var i uint64
+	for j := len(data) - 1; j >= 0; j-- {
+		i = i<<8 | uint64(data[j])
+	}
+	// sign extend
+	if data[len(data)-1]&0x80 != 0 {
+		i |= math.MaxUint64 << uint(8*len(data))
+	}
+	return int64(i), nil
+}
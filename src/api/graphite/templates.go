@@ -0,0 +1,100 @@
+package graphite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A template maps a dotted graphite metric name to a series name and
+// columns. Templates look like:
+//
+//	servers.<host>.cpu.<core> cpu
+//
+// The parts of the metric name that match a <name> placeholder are
+// stored in the column with that name, literal parts have to match
+// exactly and * matches any part. The second field is the name of the
+// series, if it's missing the series is named after the parts of the
+// metric that didn't go in a column joined with dots, i.e. servers.cpu
+// in the example above.
+type Template struct {
+	parts     []string
+	series    string
+	separator string
+}
+
+func ParseTemplate(s string) (*Template, error) {
+	return ParseTemplateWithSeparator(s, ".")
+}
+
+// Same as ParseTemplate but the names are split on the given
+// separator, e.g. / for mqtt topics
+func ParseTemplateWithSeparator(s, separator string) (*Template, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("Invalid template '%s'", s)
+	}
+
+	template := &Template{parts: strings.Split(fields[0], separator), separator: separator}
+	if len(fields) == 2 {
+		template.series = fields[1]
+	}
+	for _, part := range template.parts {
+		if part == "" || part == "<>" {
+			return nil, fmt.Errorf("Invalid template '%s', empty part", s)
+		}
+	}
+	return template, nil
+}
+
+func ParseTemplates(templates []string) ([]*Template, error) {
+	return ParseTemplatesWithSeparator(templates, ".")
+}
+
+func ParseTemplatesWithSeparator(templates []string, separator string) ([]*Template, error) {
+	parsed := make([]*Template, 0, len(templates))
+	for _, s := range templates {
+		template, err := ParseTemplateWithSeparator(s, separator)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, template)
+	}
+	return parsed, nil
+}
+
+// Apply returns the series name, columns and column values for the
+// given metric name. The last return value is false if the metric
+// doesn't match the template.
+func (self *Template) Apply(name string) (string, []string, []string, bool) {
+	parts := strings.Split(name, self.separator)
+	if len(parts) != len(self.parts) {
+		return "", nil, nil, false
+	}
+
+	columns := []string{}
+	values := []string{}
+	seriesParts := []string{}
+	for i, part := range self.parts {
+		switch {
+		case isPlaceholder(part):
+			columns = append(columns, part[1:len(part)-1])
+			values = append(values, parts[i])
+		case part == "*":
+			seriesParts = append(seriesParts, parts[i])
+		case part == parts[i]:
+			seriesParts = append(seriesParts, parts[i])
+		default:
+			return "", nil, nil, false
+		}
+	}
+
+	series := self.series
+	if series == "" {
+		series = strings.Join(seriesParts, ".")
+	}
+	return series, columns, values, true
+}
+
+func isPlaceholder(part string) bool {
+	return len(part) > 2 && part[0] == '<' && part[len(part)-1] == '>'
+}
@@ -12,11 +12,13 @@ import (
 	"io/ioutil"
 	"net"
 	libhttp "net/http"
+	"os"
 	"parser"
 	"path/filepath"
 	"protocol"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "code.google.com/p/log4go"
@@ -26,9 +28,13 @@ import (
 type HttpServer struct {
 	conn           net.Listener
 	sslConn        net.Listener
+	unixConn       net.Listener
 	httpPort       string
 	httpSslPort    string
 	httpSslCert    string
+	clientCaPath   string
+	unixSocketPath string
+	unixSocketMode os.FileMode
 	adminAssetsDir string
 	coordinator    coordinator.Coordinator
 	userManager    UserManager
@@ -36,6 +42,48 @@ type HttpServer struct {
 	clusterConfig  *cluster.ClusterConfiguration
 	raftServer     *coordinator.RaftServer
 	readTimeout    time.Duration
+	cors           *CorsPolicy
+	connections    *ConnectionTracker
+	// requests that take longer are logged, 0 means never
+	slowRequestThreshold time.Duration
+	tokenIssuer          *TokenIssuer
+	version              string
+	gitSha               string
+	buildDate            string
+	// 0 means unlimited
+	maxBodySize       int64
+	maxPointsPerWrite int
+	// nil if async writes aren't enabled
+	intakeQueue *IntakeQueue
+	// nil if the rate limits aren't enabled, they're replaced when the
+	// configuration is reloaded
+	rateLimitersLock    sync.RWMutex
+	userRateLimiter     *RateLimiter
+	databaseRateLimiter *RateLimiter
+	// the organizational units of client certificates that belong to
+	// cluster admins
+	clusterAdminOUs []string
+	// nil if pam isn't enabled
+	pamAuthenticate func(username, password string) error
+	pamListeners    []string
+	// nil if the audit log isn't enabled
+	auditLog *AuditLog
+	// nil if the lockouts aren't enabled
+	loginThrottle *LoginThrottle
+	// the default ttl of the password reset tokens
+	passwordResetTtl time.Duration
+	// the tls configuration of the ssl port, it's replaced when the
+	// certificate is reloaded
+	sslConfigLock     sync.RWMutex
+	sslConfig         *tls.Config
+	sslModTime        time.Time
+	sslReloadInterval time.Duration
+	stopSslWatcher    chan struct{}
+	pprofEnabled      bool
+	// nil if the server doesn't have a local datastore
+	deadLetters *cluster.DeadLetterQueue
+	// nil if the configuration can't be reloaded
+	reloadConfig func() error
 }
 
 func NewHttpServer(httpPort string, readTimeout time.Duration, adminAssetsDir string, theCoordinator coordinator.Coordinator, userManager UserManager, clusterConfig *cluster.ClusterConfiguration, raftServer *coordinator.RaftServer) *HttpServer {
@@ -44,15 +92,20 @@ func NewHttpServer(httpPort string, readTimeout time.Duration, adminAssetsDir st
 	self.adminAssetsDir = adminAssetsDir
 	self.coordinator = theCoordinator
 	self.userManager = userManager
-	self.shutdown = make(chan bool, 2)
+	self.shutdown = make(chan bool, 3)
 	self.clusterConfig = clusterConfig
 	self.raftServer = raftServer
 	self.readTimeout = readTimeout
+	self.cors = DefaultCorsPolicy()
+	self.connections = NewConnectionTracker()
 	return self
 }
 
 const (
 	INVALID_CREDENTIALS_MSG = "Invalid database/username/password"
+	VERSION_HEADER          = "X-Influxdb-Version"
+	BUILD_HEADER            = "X-Influxdb-Build"
+	BUILD_DATE_HEADER       = "X-Influxdb-Build-Date"
 )
 
 func (self *HttpServer) EnableSsl(addr, certPath string) {
@@ -68,6 +121,10 @@ func (self *HttpServer) EnableSsl(addr, certPath string) {
 	return
 }
 
+func (self *HttpServer) SetCorsPolicy(policy *CorsPolicy) {
+	self.cors = policy
+}
+
 func (self *HttpServer) ListenAndServe() {
 	var err error
 	if self.httpPort != "" {
@@ -82,58 +139,126 @@ func (self *HttpServer) ListenAndServe() {
 func (self *HttpServer) registerEndpoint(p *pat.PatternServeMux, method string, pattern string, f libhttp.HandlerFunc) {
 	switch method {
 	case "get":
-		p.Get(pattern, CorsHeaderHandler(f))
+		p.Get(pattern, self.requestIdHandler(self.cors.CompressionHandler(f)))
 	case "post":
-		p.Post(pattern, CorsHeaderHandler(f))
+		p.Post(pattern, self.requestIdHandler(self.cors.CompressionHandler(self.bodySizeLimitHandler(f))))
 	case "del":
-		p.Del(pattern, CorsHeaderHandler(f))
+		p.Del(pattern, self.requestIdHandler(self.cors.CompressionHandler(f)))
 	}
-	p.Options(pattern, CorsHeaderHandler(self.sendCrossOriginHeader))
+	p.Options(pattern, self.cors.PreflightHandler)
 }
 
 func (self *HttpServer) Serve(listener net.Listener) {
 	defer func() { self.shutdown <- true }()
 
 	self.conn = listener
+	if self.intakeQueue != nil {
+		self.intakeQueue.Start()
+	}
 	p := pat.New()
 
 	// Run the given query and return an array of series or a chunked response
 	// with each batch of points we get back
 	self.registerEndpoint(p, "get", "/db/:db/series", self.query)
 
-	// Write points to the given database
-	self.registerEndpoint(p, "post", "/db/:db/series", self.writePoints)
+	// Push the points that match a query over a websocket as they're
+	// written, the response can't be compressed and isn't a slow request
+	p.Get("/db/:db/subscribe", libhttp.HandlerFunc(self.subscribe))
+
+	// Graphite's render api, graphite dashboards can use /db/:db as
+	// the url of the graphite server
+	self.registerEndpoint(p, "get", "/db/:db/render", self.graphiteRender)
+	self.registerEndpoint(p, "post", "/db/:db/render", self.graphiteRender)
+
+	// Write points to the given database, streaming writes aren't
+	// limited to the maximum body size
+	p.Post("/db/:db/series", self.requestIdHandler(self.cors.CompressionHandler(self.writeSizeLimitHandler(self.writePoints))))
+
+	// Import a dump in batches, the size limit applies to every line of
+	// the dump instead of the whole body
+	p.Post("/db/:db/import", self.requestIdHandler(self.cors.CompressionHandler(self.bulkImport)))
+	p.Options("/db/:db/import", self.cors.PreflightHandler)
+
+	// Write points to multiple databases
+	self.registerEndpoint(p, "post", "/series", self.writePointsToDatabases)
+
+	// prometheus remote storage
+	self.registerEndpoint(p, "post", "/db/:db/prometheus/write", self.prometheusWrite)
+	self.registerEndpoint(p, "post", "/db/:db/prometheus/read", self.prometheusRead)
 	self.registerEndpoint(p, "del", "/db/:db/series/:series", self.dropSeries)
+	self.registerEndpoint(p, "del", "/db/:db/series", self.deletePoints)
 	self.registerEndpoint(p, "get", "/db", self.listDatabases)
 	self.registerEndpoint(p, "post", "/db", self.createDatabase)
+	self.registerEndpoint(p, "post", "/db/:db/databases", self.createDatabaseAsDbUser)
 	self.registerEndpoint(p, "del", "/db/:name", self.dropDatabase)
 
 	// cluster admins management interface
 	self.registerEndpoint(p, "get", "/cluster_admins", self.listClusterAdmins)
 	self.registerEndpoint(p, "get", "/cluster_admins/authenticate", self.authenticateClusterAdmin)
 	self.registerEndpoint(p, "post", "/cluster_admins", self.createClusterAdmin)
+	// has to be registered before /cluster_admins/:user
+	self.registerEndpoint(p, "post", "/cluster_admins/token", self.issueClusterAdminToken)
+	// the second factor of the cluster admins
+	self.registerEndpoint(p, "post", "/cluster_admins/:user/totp", self.enrollTotp)
+	self.registerEndpoint(p, "post", "/cluster_admins/:user/totp/confirm", self.confirmTotp)
+	self.registerEndpoint(p, "del", "/cluster_admins/:user/totp", self.disableTotp)
 	self.registerEndpoint(p, "post", "/cluster_admins/:user", self.updateClusterAdmin)
 	self.registerEndpoint(p, "del", "/cluster_admins/:user", self.deleteClusterAdmin)
 
+	// api keys management interface
+	self.registerEndpoint(p, "get", "/api_keys", self.listApiKeys)
+	self.registerEndpoint(p, "post", "/api_keys", self.createApiKey)
+	self.registerEndpoint(p, "del", "/api_keys/:id", self.revokeApiKey)
+
+	// write subscriptions management interface
+	self.registerEndpoint(p, "get", "/subscriptions", self.listWriteSubscriptions)
+	self.registerEndpoint(p, "post", "/subscriptions", self.createWriteSubscription)
+	self.registerEndpoint(p, "del", "/subscriptions/:name", self.dropWriteSubscription)
+
+	// roles management interface
+	self.registerEndpoint(p, "get", "/roles", self.listRoles)
+	self.registerEndpoint(p, "post", "/roles", self.saveRole)
+	self.registerEndpoint(p, "del", "/roles/:name", self.dropRole)
+
+	// tenants management interface
+	self.registerEndpoint(p, "get", "/tenants", self.listTenants)
+	self.registerEndpoint(p, "post", "/tenants", self.saveTenant)
+	self.registerEndpoint(p, "get", "/tenants/:name", self.getTenant)
+	self.registerEndpoint(p, "del", "/tenants/:name", self.dropTenant)
+
+	// failed logins
+	self.registerEndpoint(p, "get", "/lockouts", self.listLockouts)
+	self.registerEndpoint(p, "del", "/lockouts/users/:name", self.clearUserLockout)
+	self.registerEndpoint(p, "del", "/lockouts/ips/:ip", self.clearIpLockout)
+
 	// db users management interface
 	self.registerEndpoint(p, "get", "/db/:db/authenticate", self.authenticateDbUser)
+	self.registerEndpoint(p, "post", "/db/:db/token", self.issueDbUserToken)
 	self.registerEndpoint(p, "get", "/db/:db/users", self.listDbUsers)
 	self.registerEndpoint(p, "post", "/db/:db/users", self.createDbUser)
 	self.registerEndpoint(p, "get", "/db/:db/users/:user", self.showDbUser)
 	self.registerEndpoint(p, "del", "/db/:db/users/:user", self.deleteDbUser)
 	self.registerEndpoint(p, "post", "/db/:db/users/:user", self.updateDbUser)
+	self.registerEndpoint(p, "post", "/db/:db/users/:user/reset_token", self.issuePasswordResetToken)
+	self.registerEndpoint(p, "post", "/db/:db/reset_password", self.resetPassword)
 
 	// continuous queries management interface
 	self.registerEndpoint(p, "get", "/db/:db/continuous_queries", self.listDbContinuousQueries)
 	self.registerEndpoint(p, "post", "/db/:db/continuous_queries", self.createDbContinuousQueries)
 	self.registerEndpoint(p, "del", "/db/:db/continuous_queries/:id", self.deleteDbContinuousQueries)
+	self.registerEndpoint(p, "post", "/db/:db/continuous_queries/:id/backfill", self.backfillContinuousQuery)
 
 	// healthcheck
 	self.registerEndpoint(p, "get", "/ping", self.ping)
+	self.registerEndpoint(p, "get", "/health", self.health)
+	self.registerEndpoint(p, "get", "/ready", self.ready)
 
 	// force a raft log compaction
 	self.registerEndpoint(p, "post", "/raft/force_compaction", self.forceRaftCompaction)
 
+	// the raft state of this server, its peers and the latest snapshot
+	self.registerEndpoint(p, "get", "/raft/status", self.raftStatus)
+
 	// fetch current list of available interfaces
 	self.registerEndpoint(p, "get", "/interfaces", self.listInterfaces)
 
@@ -141,18 +266,77 @@ func (self *HttpServer) Serve(listener net.Listener) {
 	self.registerEndpoint(p, "get", "/cluster/servers", self.listServers)
 	self.registerEndpoint(p, "post", "/cluster/shards", self.createShard)
 	self.registerEndpoint(p, "get", "/cluster/shards", self.getShards)
+	self.registerEndpoint(p, "get", "/cluster/shards/heatmap", self.getShardHeatmap)
+	self.registerEndpoint(p, "get", "/cluster/shards/:id", self.getShard)
 	self.registerEndpoint(p, "del", "/cluster/shards/:id", self.dropShard)
+	self.registerEndpoint(p, "post", "/cluster/shards/mount", self.mountShard)
+	self.registerEndpoint(p, "post", "/cluster/shards/:id/check", self.checkShard)
+	self.registerEndpoint(p, "post", "/cluster/shards/:id/move", self.moveShard)
+	self.registerEndpoint(p, "get", "/cluster/scrub", self.getScrubStats)
+	self.registerEndpoint(p, "post", "/cluster/scrub", self.triggerScrub)
+
+	// the status of the background operations of this server, e.g. the
+	// scrubs, the repairs and the moves of the shards
+	self.registerEndpoint(p, "get", "/cluster/jobs", self.listJobs)
+	self.registerEndpoint(p, "get", "/cluster/jobs/:id", self.getJob)
+	self.registerEndpoint(p, "post", "/cluster/jobs/:id/cancel", self.cancelJob)
+
+	// inspect, retry and purge the points that the local shards
+	// couldn't write
+	self.registerEndpoint(p, "get", "/db/:db/dead_letters", self.listDeadLetters)
+	self.registerEndpoint(p, "del", "/db/:db/dead_letters", self.purgeDeadLetters)
+	self.registerEndpoint(p, "get", "/db/:db/dead_letters/:id", self.getDeadLetter)
+	self.registerEndpoint(p, "del", "/db/:db/dead_letters/:id", self.deleteDeadLetter)
+	self.registerEndpoint(p, "post", "/db/:db/dead_letters/:id/retry", self.retryDeadLetter)
+
+	// the limits of the writes to a database
+	self.registerEndpoint(p, "get", "/db/:db/quota", self.getDatabaseQuota)
+	self.registerEndpoint(p, "post", "/db/:db/quota", self.setDatabaseQuota)
+	self.registerEndpoint(p, "del", "/db/:db/quota", self.dropDatabaseQuota)
+
+	// the depth and failures of the async writes queue
+	self.registerEndpoint(p, "get", "/intake_queue", self.getIntakeQueueStats)
+
+	// list and close the connections to the api
+	self.registerEndpoint(p, "get", "/connections", self.listConnections)
+	self.registerEndpoint(p, "del", "/connections/:id", self.closeConnection)
+
+	// load the ssl certificate again after it was renewed
+	self.registerEndpoint(p, "post", "/cluster/ssl/reload", self.reloadSsl)
+	self.registerEndpoint(p, "post", "/cluster/config/reload", self.reloadConfiguration)
+
+	// export the databases, their users and continuous queries and
+	// their points, and import them into another cluster. The dump is
+	// read as it streams in, it isn't limited to the maximum body size
+	self.registerEndpoint(p, "get", "/cluster/dump", self.dump)
+	p.Post("/cluster/restore", self.requestIdHandler(self.cors.CompressionHandler(self.restore)))
+	p.Options("/cluster/restore", self.cors.PreflightHandler)
+
+	// change the log level of a module of this server for a while
+	self.registerEndpoint(p, "get", "/cluster/log_levels", self.listLogLevels)
+	self.registerEndpoint(p, "post", "/cluster/log_levels", self.overrideLogLevel)
+	self.registerEndpoint(p, "del", "/cluster/log_levels/:module", self.removeLogLevel)
 
 	// return whether the cluster is in sync or not
 	self.registerEndpoint(p, "get", "/sync", self.isInSync)
 
+	// the internal metrics, e.g. the points written and the query latency
+	self.registerEndpoint(p, "get", "/debug/vars", self.debugVars)
+
+	// the cpu, heap, goroutine and block profiles
+	if self.pprofEnabled {
+		self.registerPprofEndpoints(p)
+	}
+
+	go self.startUnixSocket(p)
+
 	if listener == nil {
 		self.startSsl(p)
 		return
 	}
 
 	go self.startSsl(p)
-	self.serveListener(listener, p)
+	self.serveListener(self.connections.Listener(listener), HTTP_LISTENER, p)
 }
 
 func (self *HttpServer) startSsl(p *pat.PatternServeMux) {
@@ -165,39 +349,66 @@ func (self *HttpServer) startSsl(p *pat.PatternServeMux) {
 
 	log.Info("Starting SSL api on port %s using certificate in %s", self.httpSslPort, self.httpSslCert)
 
-	cert, err := tls.LoadX509KeyPair(self.httpSslCert, self.httpSslCert)
-	if err != nil {
+	if err := self.ReloadSsl(); err != nil {
 		panic(err)
 	}
-
-	self.sslConn, err = tls.Listen("tcp", self.httpSslPort, &tls.Config{
-		Certificates: []tls.Certificate{cert},
-	})
+	listener, err := net.Listen("tcp", self.httpSslPort)
 	if err != nil {
 		panic(err)
 	}
+	self.sslConn = &reloadingTlsListener{self.connections.Listener(listener), self.currentSslConfig}
+	if self.sslReloadInterval > 0 {
+		self.stopSslWatcher = make(chan struct{})
+		go self.watchSslFiles(self.stopSslWatcher)
+	}
 
-	self.serveListener(self.sslConn, p)
+	self.serveListener(self.sslConn, HTTPS_LISTENER, p)
 }
 
-func (self *HttpServer) serveListener(listener net.Listener, p *pat.PatternServeMux) {
-	srv := &libhttp.Server{Handler: p, ReadTimeout: self.readTimeout}
+func (self *HttpServer) serveListener(listener net.Listener, name string, p *pat.PatternServeMux) {
+	srv := &libhttp.Server{Handler: self.connections.Handler(listenerHandler(name, p)), ReadTimeout: self.readTimeout}
 	if err := srv.Serve(listener); err != nil && !strings.Contains(err.Error(), "closed network") {
 		panic(err)
 	}
 }
 
 func (self *HttpServer) Close() {
+	self.Shutdown(5 * time.Second)
+}
+
+// Stops accepting connections and requests and waits for the requests
+// that are being served to finish, at most for the timeout. The writes
+// of the requests that finished are in the wal.
+func (self *HttpServer) Shutdown(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	if self.unixConn != nil {
+		self.unixConn.Close()
+	}
+	if self.sslConn != nil {
+		self.sslConn.Close()
+	}
 	if self.conn != nil {
 		log.Info("Closing http server")
 		self.conn.Close()
-		log.Info("Waiting for all requests to finish before killing the process")
 		select {
-		case <-time.After(time.Second * 5):
-			log.Error("There seems to be a hanging request. Closing anyway")
+		case <-time.After(deadline.Sub(time.Now())):
 		case <-self.shutdown:
 		}
 	}
+	log.Info("Waiting for all requests to finish before killing the process")
+	if !self.connections.Drain(deadline.Sub(time.Now())) {
+		log.Error("There seems to be a hanging request. Closing anyway")
+	}
+	if self.intakeQueue != nil {
+		log.Info("Stopping the async writes queue")
+		self.intakeQueue.Close()
+	}
+	if self.auditLog != nil {
+		self.auditLog.Close()
+	}
+	if self.stopSslWatcher != nil {
+		close(self.stopSslWatcher)
+	}
 }
 
 type Writer interface {
@@ -206,9 +417,11 @@ type Writer interface {
 }
 
 type AllPointsWriter struct {
-	memSeries map[string]*protocol.Series
-	w         libhttp.ResponseWriter
-	precision TimePrecision
+	memSeries   map[string]*protocol.Series
+	w           libhttp.ResponseWriter
+	precision   TimePrecision
+	format      *QueryFormat
+	ifNoneMatch string
 }
 
 func (self *AllPointsWriter) yield(series *protocol.Series) error {
@@ -223,13 +436,20 @@ func (self *AllPointsWriter) yield(series *protocol.Series) error {
 }
 
 func (self *AllPointsWriter) done() {
-	data, err := serializeMultipleSeries(self.memSeries, self.precision)
+	data, err := serializeMultipleSeries(self.memSeries, self.precision, self.format)
 	if err != nil {
 		self.w.WriteHeader(libhttp.StatusInternalServerError)
 		self.w.Write([]byte(err.Error()))
 		return
 	}
-	self.w.Header().Add("content-type", "application/json")
+	// dashboards that refresh a panel that didn't change get a 304
+	etag := queryETag(data)
+	self.w.Header().Set("ETag", etag)
+	if etagMatches(self.ifNoneMatch, etag) {
+		self.w.WriteHeader(libhttp.StatusNotModified)
+		return
+	}
+	self.w.Header().Add("content-type", self.format.contentType)
 	self.w.WriteHeader(libhttp.StatusOK)
 	self.w.Write(data)
 }
@@ -237,17 +457,18 @@ func (self *AllPointsWriter) done() {
 type ChunkWriter struct {
 	w                libhttp.ResponseWriter
 	precision        TimePrecision
+	format           *QueryFormat
 	wroteContentType bool
 }
 
 func (self *ChunkWriter) yield(series *protocol.Series) error {
-	data, err := serializeSingleSeries(series, self.precision)
+	data, err := serializeSingleSeries(series, self.precision, self.format)
 	if err != nil {
 		return err
 	}
 	if !self.wroteContentType {
 		self.wroteContentType = true
-		self.w.Header().Add("content-type", "application/json")
+		self.w.Header().Add("content-type", self.format.contentType)
 	}
 	self.w.WriteHeader(libhttp.StatusOK)
 	self.w.Write(data)
@@ -280,8 +501,13 @@ func (self *HttpServer) forceRaftCompaction(w libhttp.ResponseWriter, r *libhttp
 	})
 }
 
-func (self *HttpServer) sendCrossOriginHeader(w libhttp.ResponseWriter, r *libhttp.Request) {
-	w.WriteHeader(libhttp.StatusOK)
+func (self *HttpServer) raftStatus(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(user User) (int, interface{}) {
+		if self.raftServer == nil {
+			return libhttp.StatusNotFound, "Raft isn't running on this server"
+		}
+		return libhttp.StatusOK, self.raftServer.Status()
+	})
 }
 
 func (self *HttpServer) query(w libhttp.ResponseWriter, r *libhttp.Request) {
@@ -295,14 +521,57 @@ func (self *HttpServer) query(w libhttp.ResponseWriter, r *libhttp.Request) {
 			return libhttp.StatusBadRequest, err.Error()
 		}
 
+		if statusCode, body := self.checkQueryRateLimits(w, user, db, 1); statusCode != 0 {
+			return statusCode, body
+		}
+
+		format, err := QueryFormatFromRequest(r)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+
+		chunked := r.URL.Query().Get("chunked") == "true" || format.streaming
+		pager, err := newQueryPager(r, query)
+		if err != nil {
+			if e, ok := err.(*parser.QueryError); ok {
+				return libhttp.StatusBadRequest, e.PrettyPrint()
+			}
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		if pager != nil {
+			if chunked {
+				return libhttp.StatusBadRequest, "Paginated queries can't be chunked"
+			}
+			query = pager.query
+		}
+
 		var writer Writer
-		if r.URL.Query().Get("chunked") == "true" {
-			writer = &ChunkWriter{w, precision, false}
+		if format == csvFormat && pager == nil {
+			// csv is streamed even if it isn't chunked, the pages
+			// are buffered because their cursor is sent as a header
+			// after the query ran
+			writer = NewCsvWriter(w, precision)
+		} else if chunked {
+			writer = &ChunkWriter{w, precision, format, false}
 		} else {
-			writer = &AllPointsWriter{map[string]*protocol.Series{}, w, precision}
+			writer = &AllPointsWriter{map[string]*protocol.Series{}, w, precision, format, r.Header.Get("If-None-Match")}
+		}
+		yield := writer.yield
+		if pager != nil {
+			yield = func(series *protocol.Series) error {
+				return writer.yield(pager.filter(series))
+			}
+		}
+		var trace *Trace
+		if r.URL.Query().Get("trace") == "true" {
+			trace = NewTrace()
+			user = UserWithTrace(user, trace)
 		}
-		seriesWriter := NewSeriesWriter(writer.yield)
+		seriesWriter := NewSeriesWriter(yield)
 		err = self.coordinator.RunQuery(user, db, query, seriesWriter)
+		if self.auditLog != nil && isDestructiveQuery(query) {
+			self.audit(r, user.GetName(), "query", db, query, err)
+		}
 		if err != nil {
 			if e, ok := err.(*parser.QueryError); ok {
 				return errorToStatusCode(err), e.PrettyPrint()
@@ -310,6 +579,18 @@ func (self *HttpServer) query(w libhttp.ResponseWriter, r *libhttp.Request) {
 			return errorToStatusCode(err), err.Error()
 		}
 
+		if pager != nil {
+			cursor, err := pager.nextCursor()
+			if err != nil {
+				return libhttp.StatusInternalServerError, err.Error()
+			}
+			if cursor != "" {
+				w.Header().Set(CURSOR_HEADER, cursor)
+			}
+		}
+		if trace != nil {
+			writer.yield(traceSeries(trace))
+		}
 		writer.done()
 		return -1, nil
 	})
@@ -321,6 +602,10 @@ func errorToStatusCode(err error) int {
 		return libhttp.StatusUnauthorized // HTTP 401
 	case AuthorizationError:
 		return libhttp.StatusForbidden // HTTP 403
+	case QuotaExceededError:
+		return STATUS_QUOTA_EXCEEDED // HTTP 507
+	case WriteRateExceededError:
+		return STATUS_TOO_MANY_REQUESTS // HTTP 429
 	default:
 		return libhttp.StatusBadRequest // HTTP 400
 	}
@@ -336,6 +621,13 @@ func (self *HttpServer) writePoints(w libhttp.ResponseWriter, r *libhttp.Request
 	}
 
 	self.tryAsDbUserAndClusterAdmin(w, r, func(user User) (int, interface{}) {
+		if isProtobufWrite(r) {
+			return self.writeProtobufPoints(w, r, user, db, precision)
+		}
+		if isStreamingWrite(r) {
+			return self.streamWritePoints(w, r, user, db, precision)
+		}
+
 		series, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			return libhttp.StatusInternalServerError, err.Error()
@@ -346,19 +638,21 @@ func (self *HttpServer) writePoints(w libhttp.ResponseWriter, r *libhttp.Request
 			return libhttp.StatusBadRequest, err.Error()
 		}
 
-		// convert the wire format to the internal representation of the time series
-		dataStoreSeries := make([]*protocol.Series, 0, len(serializedSeries))
-		for _, s := range serializedSeries {
-			if len(s.Points) == 0 {
-				continue
-			}
+		if statusCode, body := self.checkPointsPerWrite(countSerializedPoints(serializedSeries)); statusCode != 0 {
+			return statusCode, body
+		}
 
-			series, err := ConvertToDataStoreSeries(s, precision)
-			if err != nil {
-				return libhttp.StatusBadRequest, err.Error()
-			}
+		dataStoreSeries, err := convertToDataStoreSeries(serializedSeries, precision)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+
+		if statusCode, body := self.checkWriteRateLimits(w, user, db, dataStoreSeries); statusCode != 0 {
+			return statusCode, body
+		}
 
-			dataStoreSeries = append(dataStoreSeries, series)
+		if r.URL.Query().Get("async") == "true" {
+			return self.queueWrite(user, db, r, series)
 		}
 
 		err = self.coordinator.WriteSeriesData(user, db, dataStoreSeries)
@@ -371,6 +665,138 @@ func (self *HttpServer) writePoints(w libhttp.ResponseWriter, r *libhttp.Request
 	})
 }
 
+// convert the wire format to the internal representation of the time series
+func convertToDataStoreSeries(serializedSeries []*SerializedSeries, precision TimePrecision) ([]*protocol.Series, error) {
+	dataStoreSeries := make([]*protocol.Series, 0, len(serializedSeries))
+	for _, s := range serializedSeries {
+		if len(s.Points) == 0 {
+			continue
+		}
+
+		series, err := ConvertToDataStoreSeries(s, precision)
+		if err != nil {
+			return nil, err
+		}
+
+		dataStoreSeries = append(dataStoreSeries, series)
+	}
+	return dataStoreSeries, nil
+}
+
+type databaseSeries struct {
+	Database string              `json:"database"`
+	Series   []*SerializedSeries `json:"series"`
+}
+
+// Writes points to multiple databases in one request. The credentials
+// and the write access are checked against every database in the
+// request before anything is written, cluster admins can write to any
+// database.
+func (self *HttpServer) writePointsToDatabases(w libhttp.ResponseWriter, r *libhttp.Request) {
+	var requests []*databaseSeries
+	users := make(map[string]User)
+	authenticate := func(r *libhttp.Request) (User, int, string) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, libhttp.StatusInternalServerError, err.Error()
+		}
+		if err := json.Unmarshal(body, &requests); err != nil {
+			return nil, libhttp.StatusBadRequest, err.Error()
+		}
+		return self.databaseUsersFromRequest(r, requests, users)
+	}
+	self.tryAs(w, r, authenticate, func(u User) (int, interface{}) {
+		return self.doWritePointsToDatabases(w, r, requests, users)
+	})
+}
+
+// Authenticates the request against every database of the write and
+// adds the users to users, a cluster admin is the user of all of them.
+// Returns the user of the first database.
+func (self *HttpServer) databaseUsersFromRequest(r *libhttp.Request, requests []*databaseSeries, users map[string]User) (User, int, string) {
+	clusterAdmin, clusterAdminStatus, message := self.clusterAdminFromRequest(r)
+	if clusterAdminStatus == libhttp.StatusBadRequest {
+		return nil, clusterAdminStatus, message
+	}
+	if len(requests) == 0 {
+		return nil, libhttp.StatusBadRequest, "The request doesn't have any databases to write to"
+	}
+	for _, request := range requests {
+		if request.Database == "" {
+			return nil, libhttp.StatusBadRequest, "Database name cannot be empty"
+		}
+		if _, ok := users[request.Database]; ok {
+			continue
+		}
+		if clusterAdminStatus == 0 {
+			users[request.Database] = clusterAdmin
+			continue
+		}
+		user, statusCode, message := self.dbUserFromRequest(r, request.Database)
+		if statusCode != 0 {
+			return nil, statusCode, fmt.Sprintf("Cannot write to database %s: %s", request.Database, message)
+		}
+		users[request.Database] = user
+	}
+	return users[requests[0].Database], 0, ""
+}
+
+func (self *HttpServer) doWritePointsToDatabases(w libhttp.ResponseWriter, r *libhttp.Request, requests []*databaseSeries, users map[string]User) (int, interface{}) {
+	precision, err := TimePrecisionFromString(r.URL.Query().Get("time_precision"))
+	if err != nil {
+		return libhttp.StatusBadRequest, err.Error()
+	}
+
+	points := 0
+	for _, request := range requests {
+		points += countSerializedPoints(request.Series)
+	}
+	if statusCode, body := self.checkPointsPerWrite(points); statusCode != 0 {
+		return statusCode, body
+	}
+
+	// check the write access to all the databases first, so we don't
+	// end up with a partial write because of a missing permission
+	for db, user := range users {
+		if !user.HasWriteAccess(db) {
+			err := NewAuthorizationError("Insufficient permissions to write to %s", db)
+			return errorToStatusCode(err), err.Error()
+		}
+		users[db] = UserWithRequestId(user, requestId(r))
+	}
+
+	dataStoreSeries := make([][]*protocol.Series, 0, len(requests))
+	for _, request := range requests {
+		series, err := convertToDataStoreSeries(request.Series, precision)
+		if err != nil {
+			return libhttp.StatusBadRequest, fmt.Sprintf("Invalid series for database %s: %s", request.Database, err)
+		}
+		dataStoreSeries = append(dataStoreSeries, series)
+	}
+
+	usage := make(map[string]*rateUsage)
+	for i, request := range requests {
+		if usage[request.Database] == nil {
+			usage[request.Database] = &rateUsage{writes: 1}
+		}
+		usage[request.Database].points += countPoints(dataStoreSeries[i])
+	}
+	if statusCode, body := self.checkRateLimits(w, users, usage); statusCode != 0 {
+		return statusCode, body
+	}
+
+	for i, request := range requests {
+		if len(dataStoreSeries[i]) == 0 {
+			continue
+		}
+		err := self.coordinator.WriteSeriesData(users[request.Database], request.Database, dataStoreSeries[i])
+		if err != nil {
+			return errorToStatusCode(err), fmt.Sprintf("Cannot write to database %s: %s", request.Database, err)
+		}
+	}
+	return libhttp.StatusOK, nil
+}
+
 type createDatabaseRequest struct {
 	Name              string `json:"name"`
 	ReplicationFactor uint8  `json:"replicationFactor"`
@@ -387,7 +813,17 @@ func (self *HttpServer) listDatabases(w libhttp.ResponseWriter, r *libhttp.Reque
 }
 
 func (self *HttpServer) createDatabase(w libhttp.ResponseWriter, r *libhttp.Request) {
-	self.tryAsClusterAdmin(w, r, func(user User) (int, interface{}) {
+	self.tryAsClusterAdmin(w, r, self.createDatabaseYield(r))
+}
+
+// Creates a database as a user of :db that was granted the privilege
+// to create databases, the user becomes an admin of the new database
+func (self *HttpServer) createDatabaseAsDbUser(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsDbUserAndClusterAdmin(w, r, self.createDatabaseYield(r))
+}
+
+func (self *HttpServer) createDatabaseYield(r *libhttp.Request) func(User) (int, interface{}) {
+	return func(user User) (int, interface{}) {
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			return libhttp.StatusInternalServerError, err.Error()
@@ -398,19 +834,21 @@ func (self *HttpServer) createDatabase(w libhttp.ResponseWriter, r *libhttp.Requ
 			return libhttp.StatusBadRequest, err.Error()
 		}
 		err = self.coordinator.CreateDatabase(user, createRequest.Name, createRequest.ReplicationFactor)
+		self.audit(r, user.GetName(), "create_database", createRequest.Name, "", err)
 		if err != nil {
 			log.Error("Cannot create database %s. Error: %s", createRequest.Name, err)
 			return errorToStatusCode(err), err.Error()
 		}
 		log.Debug("Created database %s with replication factor %d", createRequest.Name, createRequest.ReplicationFactor)
 		return libhttp.StatusCreated, nil
-	})
+	}
 }
 
 func (self *HttpServer) dropDatabase(w libhttp.ResponseWriter, r *libhttp.Request) {
 	self.tryAsClusterAdmin(w, r, func(user User) (int, interface{}) {
 		name := r.URL.Query().Get(":name")
 		err := self.coordinator.DropDatabase(user, name)
+		self.audit(r, user.GetName(), "drop_database", name, "", err)
 		if err != nil {
 			return errorToStatusCode(err), err.Error()
 		}
@@ -428,6 +866,34 @@ func (self *HttpServer) dropSeries(w libhttp.ResponseWriter, r *libhttp.Request)
 		}
 		seriesWriter := NewSeriesWriter(f)
 		err := self.coordinator.RunQuery(user, db, fmt.Sprintf("drop series %s", series), seriesWriter)
+		self.audit(r, user.GetName(), "drop_series", db, series, err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusNoContent, nil
+	})
+}
+
+// Deletes the points of the series that match the regex between start
+// and end, the times are in time_precision and both of them are
+// optional. It's the same as running "delete from /regex/ where time >
+// start and time < end", the delete is logged in the wal of every
+// shard that has the data so the replicas delete the same points.
+func (self *HttpServer) deletePoints(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+	query, err := deleteQueryFromRequest(r)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	self.tryAsDbUserAndClusterAdmin(w, r, func(user User) (int, interface{}) {
+		seriesWriter := NewSeriesWriter(func(s *protocol.Series) error {
+			return nil
+		})
+		err := self.coordinator.RunQuery(user, db, query, seriesWriter)
+		self.audit(r, user.GetName(), "delete_points", db, query, err)
 		if err != nil {
 			return errorToStatusCode(err), err.Error()
 		}
@@ -435,19 +901,80 @@ func (self *HttpServer) dropSeries(w libhttp.ResponseWriter, r *libhttp.Request)
 	})
 }
 
+func deleteQueryFromRequest(r *libhttp.Request) (string, error) {
+	q := r.URL.Query()
+	regex := q.Get("regex")
+	if regex == "" {
+		return "", fmt.Errorf("The regex of the series to delete is required")
+	}
+	precision, err := TimePrecisionFromString(q.Get("time_precision"))
+	if err != nil {
+		return "", err
+	}
+
+	conditions := []string{}
+	for _, bound := range []struct {
+		param    string
+		operator string
+	}{{"start", ">"}, {"end", "<"}} {
+		value := q.Get(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("Invalid %s %s", bound.param, value)
+		}
+		switch precision {
+		case SecondPrecision:
+			t *= 1000000
+		case MillisecondPrecision:
+			t *= 1000
+		}
+		conditions = append(conditions, fmt.Sprintf("time %s %du", bound.operator, t))
+	}
+
+	query := fmt.Sprintf("delete from /%s/", strings.Replace(regex, "/", "\\/", -1))
+	if len(conditions) > 0 {
+		query += " where " + strings.Join(conditions, " and ")
+	}
+	if !isDeleteFromRegex(query, regex) {
+		return "", fmt.Errorf("Invalid regex %s", regex)
+	}
+	return query, nil
+}
+
+// Returns true if the query parses as a single delete from the regex,
+// the regex is pasted in the query and could otherwise end it early,
+// e.g. with a trailing backslash
+func isDeleteFromRegex(query, regex string) bool {
+	queries, err := parser.ParseQuery(query)
+	if err != nil || len(queries) != 1 || queries[0].DeleteQuery == nil {
+		return false
+	}
+	from := queries[0].DeleteQuery.GetFromClause()
+	if from.Type != parser.FromClauseArray || len(from.Names) != 1 {
+		return false
+	}
+	name := from.Names[0].Name
+	compiled, isRegex := name.GetCompiledRegex()
+	// case insensitive regexes are compiled with a (?i) prefix
+	return isRegex && name.Name == regex && compiled.String() == regex
+}
+
 type Point struct {
 	Timestamp      int64         `json:"timestamp"`
 	SequenceNumber uint32        `json:"sequenceNumber"`
 	Values         []interface{} `json:"values"`
 }
 
-func serializeSingleSeries(series *protocol.Series, precision TimePrecision) ([]byte, error) {
+func serializeSingleSeries(series *protocol.Series, precision TimePrecision, format *QueryFormat) ([]byte, error) {
 	arg := map[string]*protocol.Series{"": series}
-	return json.Marshal(SerializeSeries(arg, precision)[0])
+	return format.marshal(SerializeSeries(arg, precision)[0])
 }
 
-func serializeMultipleSeries(series map[string]*protocol.Series, precision TimePrecision) ([]byte, error) {
-	return json.Marshal(SerializeSeries(series, precision))
+func serializeMultipleSeries(series map[string]*protocol.Series, precision TimePrecision, format *QueryFormat) ([]byte, error) {
+	return format.marshal(SerializeSeries(series, precision))
 }
 
 // // cluster admins management interface
@@ -508,28 +1035,179 @@ func getUsernameAndPassword(r *libhttp.Request) (string, string, error) {
 	return fields[0], fields[1], nil
 }
 
-func (self *HttpServer) tryAsClusterAdmin(w libhttp.ResponseWriter, r *libhttp.Request, yield func(User) (int, interface{})) {
+// Authenticates the request as a cluster admin using the client
+// certificate or the username and password of the request. Returns the
+// status code and error message if the authentication failed, the
+// status code is 0 otherwise.
+func (self *HttpServer) clusterAdminFromRequest(r *libhttp.Request) (User, int, string) {
+	user, statusCode, message := self.clusterAdminFromCredentials(r)
+	if statusCode != 0 {
+		return nil, statusCode, message
+	}
+	if statusCode, message := checkAllowedNetworks(r, user); statusCode != 0 {
+		return nil, statusCode, message
+	}
+	return user, 0, ""
+}
+
+// Same as clusterAdminFromRequest for users of the given db
+func (self *HttpServer) dbUserFromRequest(r *libhttp.Request, db string) (User, int, string) {
+	user, statusCode, message := self.dbUserFromCredentials(r, db)
+	if statusCode != 0 {
+		return nil, statusCode, message
+	}
+	if statusCode, message := checkAllowedNetworks(r, user); statusCode != 0 {
+		return nil, statusCode, message
+	}
+	return user, 0, ""
+}
+
+// Returns the status code and error message if the user can't
+// authenticate from the source ip of the request. Requests to the
+// unix socket are always allowed.
+func checkAllowedNetworks(r *libhttp.Request, user User) (int, string) {
+	restricted, ok := user.(interface {
+		IsAllowedFrom(ip net.IP) bool
+	})
+	if !ok {
+		return 0, ""
+	}
+	ip := net.ParseIP(sourceIp(r))
+	if ip == nil || restricted.IsAllowedFrom(ip) {
+		return 0, ""
+	}
+	return libhttp.StatusUnauthorized, fmt.Sprintf("%s can't authenticate from %s", user.GetName(), ip)
+}
+
+func (self *HttpServer) clusterAdminFromCredentials(r *libhttp.Request) (User, int, string) {
+	if _, ok := getApiKey(r); ok {
+		return nil, libhttp.StatusUnauthorized, "Api keys can't be used as a cluster admin"
+	}
+
+	if name, isClusterAdmin, ok := self.clientCertificateUser(r); ok {
+		if !isClusterAdmin {
+			return nil, libhttp.StatusUnauthorized, fmt.Sprintf("The certificate of %s doesn't belong to a cluster admin", name)
+		}
+		user, err := self.userManager.LookupClusterAdmin(name)
+		if err != nil {
+			return nil, libhttp.StatusUnauthorized, err.Error()
+		}
+		return user, 0, ""
+	}
+
+	if claims, ok, err := self.tokenClaims(r); ok {
+		if err != nil {
+			return nil, libhttp.StatusUnauthorized, err.Error()
+		}
+		if !claims.ClusterAdmin {
+			return nil, libhttp.StatusUnauthorized, "The token doesn't belong to a cluster admin"
+		}
+		user, err := self.userManager.LookupClusterAdmin(claims.Subject)
+		if err != nil {
+			return nil, libhttp.StatusUnauthorized, err.Error()
+		}
+		return checkTokenCredentials(claims, user)
+	}
+
 	username, password, err := getUsernameAndPassword(r)
 	if err != nil {
-		w.WriteHeader(libhttp.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
+		return nil, libhttp.StatusBadRequest, err.Error()
 	}
-
 	if username == "" {
-		w.Header().Add("WWW-Authenticate", "Basic realm=\"influxdb\"")
-		w.WriteHeader(libhttp.StatusUnauthorized)
-		w.Write([]byte(INVALID_CREDENTIALS_MSG))
-		return
+		return nil, libhttp.StatusUnauthorized, INVALID_CREDENTIALS_MSG
+	}
+	if statusCode, message := self.checkLockout(r, username); statusCode != 0 {
+		return nil, statusCode, message
+	}
+	if self.pamAccepts(r, username, password) {
+		if user, err := self.userManager.LookupClusterAdmin(username); err == nil {
+			return self.checkSecondFactor(r, username, user)
+		}
 	}
-
 	user, err := self.userManager.AuthenticateClusterAdmin(username, password)
 	if err != nil {
-		w.Header().Add("WWW-Authenticate", "Basic realm=\"influxdb\"")
-		w.WriteHeader(libhttp.StatusUnauthorized)
-		w.Write([]byte(err.Error()))
+		return nil, libhttp.StatusUnauthorized, err.Error()
+	}
+	return self.checkSecondFactor(r, username, user)
+}
+
+func (self *HttpServer) dbUserFromCredentials(r *libhttp.Request, db string) (User, int, string) {
+	if name, isClusterAdmin, ok := self.clientCertificateUser(r); ok {
+		if isClusterAdmin {
+			return nil, libhttp.StatusUnauthorized, fmt.Sprintf("The certificate of %s belongs to a cluster admin", name)
+		}
+		user, err := self.userManager.LookupDbUser(db, name)
+		if err != nil {
+			return nil, libhttp.StatusUnauthorized, err.Error()
+		}
+		return user, 0, ""
+	}
+
+	if claims, ok, err := self.tokenClaims(r); ok {
+		if err != nil {
+			return nil, libhttp.StatusUnauthorized, err.Error()
+		}
+		if claims.ClusterAdmin || claims.Database != db {
+			return nil, libhttp.StatusUnauthorized, fmt.Sprintf("The token isn't valid for database %s", db)
+		}
+		user, err := self.userManager.LookupDbUser(db, claims.Subject)
+		if err != nil {
+			return nil, libhttp.StatusUnauthorized, err.Error()
+		}
+		return checkTokenCredentials(claims, user)
+	}
+
+	if key, ok := getApiKey(r); ok {
+		user, err := self.userManager.AuthenticateApiKey(db, key)
+		if err != nil {
+			return nil, libhttp.StatusUnauthorized, err.Error()
+		}
+		return user, 0, ""
+	}
+
+	username, password, err := getUsernameAndPassword(r)
+	if err != nil {
+		return nil, libhttp.StatusBadRequest, err.Error()
+	}
+	if username == "" {
+		return nil, libhttp.StatusUnauthorized, INVALID_CREDENTIALS_MSG
+	}
+	if statusCode, message := self.checkLockout(r, username); statusCode != 0 {
+		return nil, statusCode, message
+	}
+	if self.pamAccepts(r, username, password) {
+		if user, err := self.userManager.LookupDbUser(db, username); err == nil {
+			return self.checkSecondFactor(r, username, user)
+		}
+	}
+	// cluster admins can authenticate as db users too, they need their
+	// second factor here as well
+	user, err := self.userManager.AuthenticateDbUser(db, username, password)
+	if err != nil {
+		return nil, libhttp.StatusUnauthorized, err.Error()
+	}
+	return self.checkSecondFactor(r, username, user)
+}
+
+func (self *HttpServer) tryAsClusterAdmin(w libhttp.ResponseWriter, r *libhttp.Request, yield func(User) (int, interface{})) {
+	self.tryAs(w, r, self.clusterAdminFromRequest, yield)
+}
+
+// Authenticates the request with authenticate and writes the response
+// that yield returns for the user
+func (self *HttpServer) tryAs(w libhttp.ResponseWriter, r *libhttp.Request, authenticate func(*libhttp.Request) (User, int, string), yield func(User) (int, interface{})) {
+	user, statusCode, message := authenticate(r)
+	if statusCode != 0 {
+		if statusCode == libhttp.StatusUnauthorized {
+			self.authenticationFailed(r, message)
+			w.Header().Add("WWW-Authenticate", "Basic realm=\"influxdb\"")
+		}
+		w.WriteHeader(statusCode)
+		w.Write([]byte(message))
 		return
 	}
+	self.connections.SetUser(r, user.GetName())
+	user = UserWithRequestId(user, requestId(r))
 	statusCode, contentType, body := yieldUser(user, yield)
 	if statusCode < 0 {
 		return
@@ -552,7 +1230,8 @@ type NewUser struct {
 }
 
 type UpdateClusterAdminUser struct {
-	Password string `json:"password"`
+	Password        string    `json:"password"`
+	AllowedNetworks *[]string `json:"allowedNetworks"`
 }
 
 type ApiUser struct {
@@ -567,6 +1246,11 @@ type UserDetail struct {
 type ContinuousQuery struct {
 	Id    int64  `json:"id"`
 	Query string `json:"query"`
+	// the unix time up to which the query ran, the queries without a
+	// group by time don't have one
+	LastCheckpoint    int64   `json:"lastCheckpoint,omitempty"`
+	LastRunDurationMs float64 `json:"lastRunDurationMs,omitempty"`
+	Errors            int64   `json:"errors"`
 }
 
 type NewContinuousQuery struct {
@@ -589,6 +1273,7 @@ func (self *HttpServer) listClusterAdmins(w libhttp.ResponseWriter, r *libhttp.R
 
 func (self *HttpServer) authenticateClusterAdmin(w libhttp.ResponseWriter, r *libhttp.Request) {
 	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		self.audit(r, u.GetName(), "authenticate", "", "", nil)
 		return libhttp.StatusOK, nil
 	})
 }
@@ -610,7 +1295,9 @@ func (self *HttpServer) createClusterAdmin(w libhttp.ResponseWriter, r *libhttp.
 
 	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
 		username := newUser.Name
-		if err := self.userManager.CreateClusterAdminUser(u, username, newUser.Password); err != nil {
+		err := self.userManager.CreateClusterAdminUser(u, username, newUser.Password)
+		self.audit(r, u.GetName(), "create_cluster_admin", "", username, err)
+		if err != nil {
 			errorStr := err.Error()
 			return errorToStatusCode(err), errorStr
 		}
@@ -622,7 +1309,9 @@ func (self *HttpServer) deleteClusterAdmin(w libhttp.ResponseWriter, r *libhttp.
 	newUser := r.URL.Query().Get(":user")
 
 	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
-		if err := self.userManager.DeleteClusterAdminUser(u, newUser); err != nil {
+		err := self.userManager.DeleteClusterAdminUser(u, newUser)
+		self.audit(r, u.GetName(), "delete_cluster_admin", "", newUser, err)
+		if err != nil {
 			return errorToStatusCode(err), err.Error()
 		}
 		return libhttp.StatusOK, nil
@@ -643,7 +1332,20 @@ func (self *HttpServer) updateClusterAdmin(w libhttp.ResponseWriter, r *libhttp.
 	newUser := r.URL.Query().Get(":user")
 
 	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
-		if err := self.userManager.ChangeClusterAdminPassword(u, newUser, updateClusterAdminUser.Password); err != nil {
+		if networks := updateClusterAdminUser.AllowedNetworks; networks != nil {
+			err := self.userManager.SetClusterAdminAllowedNetworks(u, newUser, *networks)
+			self.audit(r, u.GetName(), "set_cluster_admin_allowed_networks", "", newUser, err)
+			if err != nil {
+				return errorToStatusCode(err), err.Error()
+			}
+			if updateClusterAdminUser.Password == "" {
+				return libhttp.StatusOK, nil
+			}
+		}
+
+		err := self.userManager.ChangeClusterAdminPassword(u, newUser, updateClusterAdminUser.Password)
+		self.audit(r, u.GetName(), "change_cluster_admin_password", "", newUser, err)
+		if err != nil {
 			return errorToStatusCode(err), err.Error()
 		}
 		return libhttp.StatusOK, nil
@@ -653,9 +1355,14 @@ func (self *HttpServer) updateClusterAdmin(w libhttp.ResponseWriter, r *libhttp.
 // // db users management interface
 
 func (self *HttpServer) authenticateDbUser(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
 	code, body := self.tryAsDbUser(w, r, func(u User) (int, interface{}) {
+		self.audit(r, u.GetName(), "authenticate", db, "", nil)
 		return libhttp.StatusOK, nil
 	})
+	if code == libhttp.StatusUnauthorized {
+		self.authenticationFailed(r, string(body))
+	}
 	w.WriteHeader(code)
 	if len(body) > 0 {
 		w.Write(body)
@@ -663,23 +1370,16 @@ func (self *HttpServer) authenticateDbUser(w libhttp.ResponseWriter, r *libhttp.
 }
 
 func (self *HttpServer) tryAsDbUser(w libhttp.ResponseWriter, r *libhttp.Request, yield func(User) (int, interface{})) (int, []byte) {
-	username, password, err := getUsernameAndPassword(r)
-	if err != nil {
-		return libhttp.StatusBadRequest, []byte(err.Error())
-	}
-
 	db := r.URL.Query().Get(":db")
-
-	if username == "" {
-		w.Header().Add("WWW-Authenticate", "Basic realm=\"influxdb\"")
-		return libhttp.StatusUnauthorized, []byte(INVALID_CREDENTIALS_MSG)
-	}
-
-	user, err := self.userManager.AuthenticateDbUser(db, username, password)
-	if err != nil {
-		w.Header().Add("WWW-Authenticate", "Basic realm=\"influxdb\"")
-		return libhttp.StatusUnauthorized, []byte(err.Error())
+	user, statusCode, message := self.dbUserFromRequest(r, db)
+	if statusCode != 0 {
+		if statusCode == libhttp.StatusUnauthorized {
+			w.Header().Add("WWW-Authenticate", "Basic realm=\"influxdb\"")
+		}
+		return statusCode, []byte(message)
 	}
+	self.connections.SetUser(r, user.GetName())
+	user = UserWithRequestId(user, requestId(r))
 
 	statusCode, contentType, v := yieldUser(user, yield)
 	if statusCode == libhttp.StatusUnauthorized {
@@ -767,13 +1467,16 @@ func (self *HttpServer) createDbUser(w libhttp.ResponseWriter, r *libhttp.Reques
 
 	self.tryAsDbUserAndClusterAdmin(w, r, func(u User) (int, interface{}) {
 		username := newUser.Name
-		if err := self.userManager.CreateDbUser(u, db, username, newUser.Password); err != nil {
+		err := self.userManager.CreateDbUser(u, db, username, newUser.Password)
+		self.audit(r, u.GetName(), "create_db_user", db, username, err)
+		if err != nil {
 			log.Error("Cannot create user: %s", err)
 			return errorToStatusCode(err), err.Error()
 		}
 		log.Debug("Created user %s", username)
 		if newUser.IsAdmin {
 			err = self.userManager.SetDbAdmin(u, db, newUser.Name, true)
+			self.audit(r, u.GetName(), "set_db_admin", db, username, err)
 			if err != nil {
 				return libhttp.StatusInternalServerError, err.Error()
 			}
@@ -788,7 +1491,9 @@ func (self *HttpServer) deleteDbUser(w libhttp.ResponseWriter, r *libhttp.Reques
 	db := r.URL.Query().Get(":db")
 
 	self.tryAsDbUserAndClusterAdmin(w, r, func(u User) (int, interface{}) {
-		if err := self.userManager.DeleteDbUser(u, db, newUser); err != nil {
+		err := self.userManager.DeleteDbUser(u, db, newUser)
+		self.audit(r, u.GetName(), "delete_db_user", db, newUser, err)
+		if err != nil {
 			return errorToStatusCode(err), err.Error()
 		}
 		return libhttp.StatusOK, nil
@@ -821,7 +1526,9 @@ func (self *HttpServer) updateDbUser(w libhttp.ResponseWriter, r *libhttp.Reques
 				return libhttp.StatusBadRequest, "password must be string"
 			}
 
-			if err := self.userManager.ChangeDbUserPassword(u, db, newUser, newPassword); err != nil {
+			err := self.userManager.ChangeDbUserPassword(u, db, newUser, newPassword)
+			self.audit(r, u.GetName(), "change_db_user_password", db, newUser, err)
+			if err != nil {
 				return errorToStatusCode(err), err.Error()
 			}
 		}
@@ -832,7 +1539,64 @@ func (self *HttpServer) updateDbUser(w libhttp.ResponseWriter, r *libhttp.Reques
 				return libhttp.StatusBadRequest, "admin must be boolean"
 			}
 
-			if err := self.userManager.SetDbAdmin(u, db, newUser, isAdmin); err != nil {
+			err := self.userManager.SetDbAdmin(u, db, newUser, isAdmin)
+			self.audit(r, u.GetName(), "set_db_admin", db, newUser, err)
+			if err != nil {
+				return errorToStatusCode(err), err.Error()
+			}
+		}
+
+		if value, ok := updateUser["canCreateDatabases"]; ok {
+			canCreate, ok := value.(bool)
+			if !ok {
+				return libhttp.StatusBadRequest, "canCreateDatabases must be boolean"
+			}
+
+			err := self.userManager.SetDbUserCanCreateDatabases(u, db, newUser, canCreate)
+			self.audit(r, u.GetName(), "set_db_user_can_create_databases", db, newUser, err)
+			if err != nil {
+				return errorToStatusCode(err), err.Error()
+			}
+		}
+
+		if value, ok := updateUser["roles"]; ok {
+			names, ok := value.([]interface{})
+			if !ok {
+				return libhttp.StatusBadRequest, "roles must be an array of strings"
+			}
+			roles := make([]string, 0, len(names))
+			for _, name := range names {
+				role, ok := name.(string)
+				if !ok {
+					return libhttp.StatusBadRequest, "roles must be an array of strings"
+				}
+				roles = append(roles, role)
+			}
+
+			err := self.userManager.SetDbUserRoles(u, db, newUser, roles)
+			self.audit(r, u.GetName(), "set_db_user_roles", db, newUser, err)
+			if err != nil {
+				return errorToStatusCode(err), err.Error()
+			}
+		}
+
+		if value, ok := updateUser["allowedNetworks"]; ok {
+			values, ok := value.([]interface{})
+			if !ok {
+				return libhttp.StatusBadRequest, "allowedNetworks must be an array of strings"
+			}
+			networks := make([]string, 0, len(values))
+			for _, value := range values {
+				network, ok := value.(string)
+				if !ok {
+					return libhttp.StatusBadRequest, "allowedNetworks must be an array of strings"
+				}
+				networks = append(networks, network)
+			}
+
+			err := self.userManager.SetDbUserAllowedNetworks(u, db, newUser, networks)
+			self.audit(r, u.GetName(), "set_db_user_allowed_networks", db, newUser, err)
+			if err != nil {
 				return errorToStatusCode(err), err.Error()
 			}
 		}
@@ -840,9 +1604,22 @@ func (self *HttpServer) updateDbUser(w libhttp.ResponseWriter, r *libhttp.Reques
 	})
 }
 
+// Sets the version, the git sha and the date of the build that are
+// returned by /ping
+func (self *HttpServer) SetVersion(version, gitSha, buildDate string) {
+	self.version = version
+	self.gitSha = gitSha
+	self.buildDate = buildDate
+}
+
+// A cheap liveness check that doesn't need credentials, it doesn't
+// touch the cluster so it can be used to find out which version a
+// server runs before authenticating
 func (self *HttpServer) ping(w libhttp.ResponseWriter, r *libhttp.Request) {
-	w.WriteHeader(libhttp.StatusOK)
-	w.Write([]byte("{\"status\":\"ok\"}"))
+	w.Header().Set(VERSION_HEADER, self.version)
+	w.Header().Set(BUILD_HEADER, self.gitSha)
+	w.Header().Set(BUILD_DATE_HEADER, self.buildDate)
+	w.WriteHeader(libhttp.StatusNoContent)
 }
 
 func (self *HttpServer) listInterfaces(w libhttp.ResponseWriter, r *libhttp.Request) {
@@ -881,7 +1658,13 @@ func (self *HttpServer) listDbContinuousQueries(w libhttp.ResponseWriter, r *lib
 		queries := make([]ContinuousQuery, 0, len(series[0].Points))
 
 		for _, point := range series[0].Points {
-			queries = append(queries, ContinuousQuery{Id: *point.Values[0].Int64Value, Query: *point.Values[1].StringValue})
+			query := ContinuousQuery{Id: *point.Values[0].Int64Value, Query: *point.Values[1].StringValue}
+			if len(point.Values) >= 5 {
+				query.LastCheckpoint = point.Values[2].GetInt64Value()
+				query.LastRunDurationMs = point.Values[3].GetDoubleValue()
+				query.Errors = point.Values[4].GetInt64Value()
+			}
+			queries = append(queries, query)
 		}
 
 		return libhttp.StatusOK, queries
@@ -976,6 +1759,58 @@ func (self *HttpServer) createShard(w libhttp.ResponseWriter, r *libhttp.Request
 	})
 }
 
+type mountShardInfo struct {
+	StartTime int64  `json:"startTime"`
+	EndTime   int64  `json:"endTime"`
+	LongTerm  bool   `json:"longTerm"`
+	Path      string `json:"path"`
+	ServerId  uint32 `json:"serverId"`
+}
+
+// Registers an archived shard directory that lives on one of the
+// servers with the cluster. The shard can be queried but nothing can be
+// written to it and it's never replicated.
+func (self *HttpServer) mountShard(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		info := &mountShardInfo{}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		err = json.Unmarshal(body, info)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		if info.Path == "" {
+			return libhttp.StatusBadRequest, "Request must include the path of the shard"
+		}
+		if info.EndTime <= info.StartTime {
+			return libhttp.StatusBadRequest, "The shard's endTime must be after its startTime"
+		}
+		if info.ServerId == 0 {
+			info.ServerId = self.clusterConfig.LocalServerId
+		}
+
+		shardType := cluster.SHORT_TERM
+		if info.LongTerm {
+			shardType = cluster.LONG_TERM
+		}
+		shard := &cluster.NewShardData{
+			StartTime: time.Unix(info.StartTime, 0),
+			EndTime:   time.Unix(info.EndTime, 0),
+			ServerIds: []uint32{info.ServerId},
+			Type:      shardType,
+			ReadOnly:  true,
+			Path:      info.Path,
+		}
+		_, err = self.raftServer.CreateShards([]*cluster.NewShardData{shard})
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		return libhttp.StatusAccepted, nil
+	})
+}
+
 func (self *HttpServer) getShards(w libhttp.ResponseWriter, r *libhttp.Request) {
 	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
 		result := make(map[string]interface{})
@@ -985,6 +1820,20 @@ func (self *HttpServer) getShards(w libhttp.ResponseWriter, r *libhttp.Request)
 	})
 }
 
+// The write and query rates, sizes and series counts of the shards
+// across the cluster, the hottest shards come first
+func (self *HttpServer) getShardHeatmap(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if self.raftServer == nil {
+			// there's no way to reach the other servers, only report
+			// the stats of this one
+			shards := cluster.MergeShardStats(self.clusterConfig.LocalShardStats())
+			return libhttp.StatusOK, &coordinator.ShardHeatmap{Shards: shards}
+		}
+		return libhttp.StatusOK, self.raftServer.ShardHeatmap()
+	})
+}
+
 // Note: this is meant for testing purposes only and doesn't guarantee
 // data integrity and shouldn't be used in client code.
 func (self *HttpServer) isInSync(w libhttp.ResponseWriter, r *libhttp.Request) {
@@ -1021,6 +1870,7 @@ func (self *HttpServer) dropShard(w libhttp.ResponseWriter, r *libhttp.Request)
 		}
 
 		err = self.raftServer.DropShard(uint32(id), serverIdInfo.ServerIds)
+		self.audit(r, u.GetName(), "drop_shard", "", strconv.FormatInt(id, 10), err)
 		if err != nil {
 			return libhttp.StatusInternalServerError, err.Error()
 		}
@@ -1028,15 +1878,110 @@ func (self *HttpServer) dropShard(w libhttp.ResponseWriter, r *libhttp.Request)
 	})
 }
 
+func (self *HttpServer) getScrubStats(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		scrubber := self.clusterConfig.ShardScrubber()
+		if scrubber == nil {
+			return libhttp.StatusNotFound, "The shard scrubber isn't running"
+		}
+		return libhttp.StatusOK, scrubber.Stats()
+	})
+}
+
+func (self *HttpServer) triggerScrub(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		scrubber := self.clusterConfig.ShardScrubber()
+		if scrubber == nil {
+			return libhttp.StatusNotFound, "The shard scrubber isn't running"
+		}
+		return libhttp.StatusAccepted, scrubber.Trigger().Status()
+	})
+}
+
 func (self *HttpServer) convertShardsToMap(shards []*cluster.ShardData) []interface{} {
 	result := make([]interface{}, 0)
 	for _, shard := range shards {
-		s := make(map[string]interface{})
-		s["id"] = shard.Id()
-		s["startTime"] = shard.StartTime().Unix()
-		s["endTime"] = shard.EndTime().Unix()
-		s["serverIds"] = shard.ServerIds()
-		result = append(result, s)
+		result = append(result, self.convertShardToMap(shard))
 	}
 	return result
 }
+
+func (self *HttpServer) convertShardToMap(shard *cluster.ShardData) map[string]interface{} {
+	s := make(map[string]interface{})
+	s["id"] = shard.Id()
+	s["startTime"] = shard.StartTime().Unix()
+	s["endTime"] = shard.EndTime().Unix()
+	s["serverIds"] = shard.ServerIds()
+	s["readOnly"] = shard.IsReadOnly()
+	s["local"] = shard.IsLocal
+	if shard.IsReadOnly() {
+		s["mountPath"] = shard.MountPath()
+	}
+	return s
+}
+
+// Calls yield with the shard in the url, the responses are the same as
+// the ones of tryAsClusterAdmin
+func (self *HttpServer) tryShard(w libhttp.ResponseWriter, r *libhttp.Request, yield func(User, *cluster.ShardData) (int, interface{})) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		id, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 32)
+		if err != nil {
+			return libhttp.StatusBadRequest, "Invalid shard id"
+		}
+		shard := self.clusterConfig.GetShardById(uint32(id))
+		if shard == nil {
+			return libhttp.StatusNotFound, fmt.Sprintf("Shard %d doesn't exist", id)
+		}
+		return yield(u, shard)
+	})
+}
+
+// Returns the shard with its replicas and the load it has on this
+// server
+func (self *HttpServer) getShard(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryShard(w, r, func(u User, shard *cluster.ShardData) (int, interface{}) {
+		result := self.convertShardToMap(shard)
+		result["replicas"] = self.clusterConfig.ShardReplicas(shard)
+		result["stats"] = shard.Stats()
+		return libhttp.StatusOK, result
+	})
+}
+
+// Verifies the copy of the shard on this server and returns the state
+// of the replicas. A corrupt copy is repaired in the background.
+func (self *HttpServer) checkShard(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryShard(w, r, func(u User, shard *cluster.ShardData) (int, interface{}) {
+		replicas, err := self.clusterConfig.CheckShardReplicas(shard)
+		self.audit(r, u.GetName(), "check_shard", "", strconv.FormatUint(uint64(shard.Id()), 10), err)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		return libhttp.StatusOK, replicas
+	})
+}
+
+type moveShardInfo struct {
+	From uint32 `json:"from"`
+	To   uint32 `json:"to"`
+}
+
+// Starts moving the shard to another server, the shard is dropped from
+// the old server once it's copied. Returns the job that follows the move.
+func (self *HttpServer) moveShard(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryShard(w, r, func(u User, shard *cluster.ShardData) (int, interface{}) {
+		info := &moveShardInfo{}
+		if err := json.NewDecoder(r.Body).Decode(info); err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		if info.From == 0 || info.To == 0 {
+			return libhttp.StatusBadRequest, "Request must include the 'from' and 'to' server ids"
+		}
+		err := self.raftServer.MoveShard(shard.Id(), info.From, info.To)
+		target := fmt.Sprintf("%d from %d to %d", shard.Id(), info.From, info.To)
+		self.audit(r, u.GetName(), "move_shard", "", target, err)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		return libhttp.StatusAccepted, self.clusterConfig.WatchShardMove(shard.Id(), info.From, info.To).Status()
+	})
+}
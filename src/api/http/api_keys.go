@@ -0,0 +1,106 @@
+package http
+
+import (
+	. "common"
+	"encoding/json"
+	"io/ioutil"
+	libhttp "net/http"
+	"strings"
+)
+
+const API_KEY_HEADER = "X-Api-Key"
+
+type NewApiKey struct {
+	Name      string   `json:"name"`
+	Databases []string `json:"databases"`
+	Read      bool     `json:"read"`
+	Write     bool     `json:"write"`
+}
+
+// The api key as it's returned by the api, the key itself is only set
+// when the api key is created
+type ApiKeyDetail struct {
+	Id        string   `json:"id"`
+	Key       string   `json:"key,omitempty"`
+	Name      string   `json:"name"`
+	Databases []string `json:"databases"`
+	Read      bool     `json:"read"`
+	Write     bool     `json:"write"`
+	CreatedBy string   `json:"createdBy"`
+	CreatedAt int64    `json:"createdAt"`
+}
+
+// Returns the api key in the X-Api-Key header, if any
+func getApiKey(r *libhttp.Request) (string, bool) {
+	key := strings.TrimSpace(r.Header.Get(API_KEY_HEADER))
+	return key, key != ""
+}
+
+func (self *HttpServer) listApiKeys(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		keys, err := self.userManager.ListApiKeys(u)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		details := make([]*ApiKeyDetail, 0, len(keys))
+		for _, key := range keys {
+			details = append(details, &ApiKeyDetail{
+				Id:        key.Id,
+				Name:      key.Name,
+				Databases: key.Databases,
+				Read:      key.Read,
+				Write:     key.Write,
+				CreatedBy: key.CreatedBy,
+				CreatedAt: key.CreatedAt,
+			})
+		}
+		return libhttp.StatusOK, details
+	})
+}
+
+func (self *HttpServer) createApiKey(w libhttp.ResponseWriter, r *libhttp.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	newApiKey := &NewApiKey{}
+	err = json.Unmarshal(body, newApiKey)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		key, apiKey, err := self.userManager.CreateApiKey(u, newApiKey.Name, newApiKey.Databases, newApiKey.Read, newApiKey.Write)
+		self.audit(r, u.GetName(), "create_api_key", "", newApiKey.Name, err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, &ApiKeyDetail{
+			Id:        apiKey.Id,
+			Key:       key,
+			Name:      apiKey.Name,
+			Databases: apiKey.Databases,
+			Read:      apiKey.Read,
+			Write:     apiKey.Write,
+			CreatedBy: apiKey.CreatedBy,
+			CreatedAt: apiKey.CreatedAt,
+		}
+	})
+}
+
+func (self *HttpServer) revokeApiKey(w libhttp.ResponseWriter, r *libhttp.Request) {
+	id := r.URL.Query().Get(":id")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		err := self.userManager.RevokeApiKey(u, id)
+		self.audit(r, u.GetName(), "revoke_api_key", "", id, err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
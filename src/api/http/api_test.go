@@ -4,19 +4,28 @@ import (
 	"bytes"
 	"cluster"
 	. "common"
+	"compress/gzip"
+	"compress/zlib"
 	"coordinator"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	. "launchpad.net/gocheck"
+	"logging"
+	"math"
 	"net"
 	libhttp "net/http"
 	"net/url"
+	"os"
 	"parser"
 	"protocol"
+	"strings"
 	"testing"
 	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+	"code.google.com/p/snappy-go/snappy"
 )
 
 // Hook up gocheck into the gotest runner.
@@ -33,10 +42,11 @@ type ApiSuite struct {
 
 var _ = Suite(&ApiSuite{})
 
-func (self *MockCoordinator) RunQuery(_ User, _ string, query string, yield coordinator.SeriesWriter) error {
+func (self *MockCoordinator) RunQuery(user User, _ string, query string, yield coordinator.SeriesWriter) error {
 	if self.returnedError != nil {
 		return self.returnedError
 	}
+	GetTrace(user).Add(&TraceSpan{Name: "parse", ServerId: 1, Start: time.Unix(1381346631, 0), Duration: 2 * time.Millisecond})
 
 	series, err := StringToSeriesArray(`
 [
@@ -99,10 +109,14 @@ type MockCoordinator struct {
 	db                string
 	droppedDb         string
 	returnedError     error
+	requestIds        []string
+	subscriptions     map[string]*cluster.WriteSubscription
+	roles             map[string]*cluster.Role
 }
 
-func (self *MockCoordinator) WriteSeriesData(_ User, db string, series []*protocol.Series) error {
+func (self *MockCoordinator) WriteSeriesData(user User, db string, series []*protocol.Series) error {
 	self.series = append(self.series, series...)
+	self.requestIds = append(self.requestIds, RequestId(user))
 	return nil
 }
 
@@ -161,6 +175,56 @@ func (self *MockCoordinator) DeleteContinuousQuery(_ User, db string, id uint32)
 	return nil
 }
 
+func (self *MockCoordinator) ListWriteSubscriptions(_ User) ([]*cluster.WriteSubscription, error) {
+	subscriptions := []*cluster.WriteSubscription{}
+	for _, subscription := range self.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func (self *MockCoordinator) CreateWriteSubscription(u User, name, db string, destinations []string) (*cluster.WriteSubscription, error) {
+	subscription := &cluster.WriteSubscription{Name: name, Database: db, Destinations: destinations, CreatedBy: u.GetName()}
+	if err := subscription.Validate(); err != nil {
+		return nil, err
+	}
+	self.subscriptions[name] = subscription
+	return subscription, nil
+}
+
+func (self *MockCoordinator) DropWriteSubscription(_ User, name string) error {
+	if _, ok := self.subscriptions[name]; !ok {
+		return fmt.Errorf("Subscription %s doesn't exist", name)
+	}
+	delete(self.subscriptions, name)
+	return nil
+}
+
+func (self *MockCoordinator) ListRoles(_ User) ([]*cluster.Role, error) {
+	roles := []*cluster.Role{}
+	for _, role := range self.roles {
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func (self *MockCoordinator) SaveRole(u User, role *cluster.Role) error {
+	if err := role.Validate(); err != nil {
+		return err
+	}
+	role.CreatedBy = u.GetName()
+	self.roles[role.Name] = role
+	return nil
+}
+
+func (self *MockCoordinator) DropRole(_ User, name string) error {
+	if _, ok := self.roles[name]; !ok {
+		return fmt.Errorf("Role %s doesn't exist", name)
+	}
+	delete(self.roles, name)
+	return nil
+}
+
 func (self *ApiSuite) formatUrl(path string, args ...interface{}) string {
 	path = fmt.Sprintf(path, args...)
 	port := self.listener.Addr().(*net.TCPAddr).Port
@@ -174,6 +238,8 @@ func (self *ApiSuite) SetUpSuite(c *C) {
 				&cluster.ContinuousQuery{1, "select * from foo into bar;"},
 			},
 		},
+		subscriptions: map[string]*cluster.WriteSubscription{},
+		roles:         map[string]*cluster.Role{},
 	}
 
 	self.manager = &MockUserManager{
@@ -182,7 +248,12 @@ func (self *ApiSuite) SetUpSuite(c *C) {
 	}
 	dir := c.MkDir()
 	self.server = NewHttpServer("", 10*time.Second, dir, self.coordinator, self.manager, nil, nil)
-	var err error
+	self.server.SetVersion("0.5.9", "abc123", "2014-05-01T12:00:00Z")
+	c.Assert(self.server.EnableTokens("secret", time.Hour), IsNil)
+	self.server.EnablePprof(0)
+	deadLetters, err := cluster.NewDeadLetterQueue(c.MkDir(), 0)
+	c.Assert(err, IsNil)
+	self.server.SetDeadLetterQueue(deadLetters)
 	self.listener, err = net.Listen("tcp4", ":8081")
 	c.Assert(err, IsNil)
 	go func() {
@@ -198,14 +269,19 @@ func (self *ApiSuite) TearDownSuite(c *C) {
 func (self *ApiSuite) SetUpTest(c *C) {
 	self.coordinator.series = nil
 	self.coordinator.returnedError = nil
+	self.coordinator.requestIds = nil
 	self.manager.ops = nil
+	self.manager.credentials = ""
 }
 
 func (self *ApiSuite) TestHealthCheck(c *C) {
 	url := self.formatUrl("/ping")
 	resp, err := libhttp.Get(url)
 	c.Assert(err, IsNil)
-	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusNoContent)
+	c.Assert(resp.Header.Get("X-Influxdb-Version"), Equals, "0.5.9")
+	c.Assert(resp.Header.Get("X-Influxdb-Build"), Equals, "abc123")
+	c.Assert(resp.Header.Get("X-Influxdb-Build-Date"), Equals, "2014-05-01T12:00:00Z")
 	resp.Body.Close()
 }
 
@@ -283,6 +359,38 @@ func (self *ApiSuite) TestQueryWithNullColumns(c *C) {
 	c.Assert(series[0].Points[0][3], Equals, nil)
 }
 
+func (self *ApiSuite) TestConditionalQuery(c *C) {
+	query := url.QueryEscape("select * from foo;")
+	addr := self.formatUrl("/db/foo/series?q=%s&u=dbuser&p=password", query)
+	resp, err := libhttp.Get(addr)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	etag := resp.Header.Get("ETag")
+	c.Assert(etag, Not(Equals), "")
+
+	req, err := libhttp.NewRequest("GET", addr, nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusNotModified)
+	c.Assert(data, HasLen, 0)
+
+	// a different result doesn't match the etag
+	query = url.QueryEscape("select * from foo where column_one == 'some_value';")
+	req, err = libhttp.NewRequest("GET", self.formatUrl("/db/foo/series?q=%s&time_precision=s&u=dbuser&p=password", query), nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+}
+
 func (self *ApiSuite) TestQueryErrorPropagatesProperly(c *C) {
 	self.coordinator.returnedError = fmt.Errorf("some error")
 	query := "select * from does_not_exist;"
@@ -315,6 +423,37 @@ func (self *ApiSuite) TestQueryWithSecondsPrecision(c *C) {
 	c.Assert(int(series[0].Points[0][0].(float64)), Equals, 1381346631)
 }
 
+func (self *ApiSuite) TestQueryWithTrace(c *C) {
+	query := url.QueryEscape("select * from foo;")
+	addr := self.formatUrl("/db/foo/series?q=%s&time_precision=s&trace=true&u=dbuser&p=password", query)
+	resp, err := libhttp.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	series := []SerializedSeries{}
+	c.Assert(json.Unmarshal(data, &series), IsNil)
+	c.Assert(series, HasLen, 2)
+
+	var trace *SerializedSeries
+	for i := range series {
+		if series[i].Name == TRACE_SERIES {
+			trace = &series[i]
+		}
+	}
+	c.Assert(trace, NotNil)
+	c.Assert(trace.Points, HasLen, 1)
+	point := map[string]interface{}{}
+	for i, column := range trace.Columns {
+		point[column] = trace.Points[0][i]
+	}
+	c.Assert(point["time"], Equals, float64(1381346631))
+	c.Assert(point["stage"], Equals, "parse")
+	c.Assert(point["server_id"], Equals, float64(1))
+	c.Assert(point["duration_ms"], Equals, float64(2))
+}
+
 func (self *ApiSuite) TestWritingToSeriesWithUnderscore(c *C) {
 	for _, name := range []string{"1foo", "_foo"} {
 
@@ -372,6 +511,116 @@ func (self *ApiSuite) TestNotChunkedQuery(c *C) {
 	c.Assert(int64(series[0].Points[0][0].(float64)), Equals, int64(1381346631000))
 }
 
+func (self *ApiSuite) TestMsgpackQuery(c *C) {
+	query := url.QueryEscape("select * from foo where column_one == 'some_value';")
+	addr := self.formatUrl("/db/foo/series?q=%s&format=msgpack&u=dbuser&p=password", query)
+	resp, err := libhttp.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(resp.Header.Get("content-type"), Equals, "application/x-msgpack")
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	// an array with one series that is a map of three elements
+	c.Assert(data[0:2], DeepEquals, []byte{0x91, 0x83})
+}
+
+func (self *ApiSuite) TestCsvQuery(c *C) {
+	query := url.QueryEscape("select * from foo where column_one == 'some_value';")
+	addr := self.formatUrl("/db/foo/series?q=%s&format=csv&u=dbuser&p=password", query)
+	resp, err := libhttp.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(resp.Header.Get("content-type"), Equals, "text/csv")
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	c.Assert(lines, HasLen, 5)
+	c.Assert(lines[0], Equals, "name,time,sequence_number,column_one,column_two")
+	for _, line := range lines[1:] {
+		c.Assert(strings.HasPrefix(line, "foo,"), Equals, true)
+	}
+}
+
+func (self *ApiSuite) TestChunkedCsvQuery(c *C) {
+	query := url.QueryEscape("select * from foo where column_one == 'some_value';")
+	addr := self.formatUrl("/db/foo/series?q=%s&format=csv&chunked=true&u=dbuser&p=password", query)
+	resp, err := libhttp.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("content-type"), Equals, "text/csv")
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	// the two chunks of foo share the header
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	c.Assert(lines, HasLen, 5)
+	c.Assert(lines[0], Equals, "name,time,sequence_number,column_one,column_two")
+	for _, line := range lines[1:] {
+		c.Assert(strings.HasPrefix(line, "foo,"), Equals, true)
+	}
+}
+
+func (self *ApiSuite) TestNdjsonQuery(c *C) {
+	query := url.QueryEscape("select * from foo where column_one == 'some_value';")
+	addr := self.formatUrl("/db/foo/series?q=%s&format=ndjson&u=dbuser&p=password", query)
+	resp, err := libhttp.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(resp.Header.Get("content-type"), Equals, "application/x-ndjson")
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	// the points are streamed, one per line
+	c.Assert(lines, HasLen, 4)
+	for _, line := range lines {
+		series := &SerializedSeries{}
+		c.Assert(json.Unmarshal([]byte(line), series), IsNil)
+		c.Assert(series.Name, Equals, "foo")
+		c.Assert(series.Points, HasLen, 1)
+	}
+}
+
+func (self *ApiSuite) TestQueryFormatFromAcceptHeader(c *C) {
+	query := url.QueryEscape("select * from foo where column_one == 'some_value';")
+	addr := self.formatUrl("/db/foo/series?q=%s&u=dbuser&p=password", query)
+	req, err := libhttp.NewRequest("GET", addr, nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Accept", "text/html, application/x-msgpack;q=0.9")
+	resp, err := libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(resp.Header.Get("content-type"), Equals, "application/x-msgpack")
+}
+
+func (self *ApiSuite) TestQueryFormatHonorsTheQualityValues(c *C) {
+	for accept, expected := range map[string]*QueryFormat{
+		"text/csv;q=0.1, application/json":           jsonFormat,
+		"application/json;q=0.5, text/csv":           csvFormat,
+		"text/csv;q=0, text/html":                    jsonFormat,
+		"application/x-ndjson;q=0.9, text/csv;q=0.8": ndjsonFormat,
+		"": jsonFormat,
+	} {
+		req, err := libhttp.NewRequest("GET", "/db/foo/series", nil)
+		c.Assert(err, IsNil)
+		req.Header.Set("Accept", accept)
+		format, err := QueryFormatFromRequest(req)
+		c.Assert(err, IsNil)
+		c.Assert(format, Equals, expected, Commentf("Accept: %s", accept))
+	}
+}
+
+func (self *ApiSuite) TestQueryWithInvalidFormat(c *C) {
+	query := url.QueryEscape("select * from foo;")
+	addr := self.formatUrl("/db/foo/series?q=%s&format=xml&u=dbuser&p=password", query)
+	resp, err := libhttp.Get(addr)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+}
+
 func (self *ApiSuite) TestChunkedQuery(c *C) {
 	query := "select * from foo where column_one == 'some_value';"
 	query = url.QueryEscape(query)
@@ -427,6 +676,78 @@ func (self *ApiSuite) TestWriteDataWithTimeInSeconds(c *C) {
 	c.Assert(*series.Points[0].GetTimestampInMicroseconds(), Equals, int64(1382131686000000))
 }
 
+func (self *ApiSuite) TestWriteGzippedData(c *C) {
+	data := `
+[
+  {
+    "points": [
+				[1382131686, "1"]
+    ],
+    "name": "foo",
+    "columns": ["time", "column_one"]
+  }
+]
+`
+	buffer := bytes.NewBuffer(nil)
+	writer := gzip.NewWriter(buffer)
+	writer.Write([]byte(data))
+	writer.Close()
+
+	addr := self.formatUrl("/db/foo/series?time_precision=s&u=dbuser&p=password")
+	req, err := libhttp.NewRequest("POST", addr, buffer)
+	c.Assert(err, IsNil)
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.coordinator.series, HasLen, 1)
+	c.Assert(*self.coordinator.series[0].Points[0].Values[0].StringValue, Equals, "1")
+}
+
+func (self *ApiSuite) TestQueryResponseUsesTheEncodingWithTheHighestQuality(c *C) {
+	resp := self.queryWithAcceptEncoding(c, "deflate;q=0.5, gzip")
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("Content-Encoding"), Equals, "gzip")
+	reader, err := gzip.NewReader(resp.Body)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	series := []SerializedSeries{}
+	c.Assert(json.Unmarshal(data, &series), IsNil)
+	c.Assert(series, HasLen, 1)
+}
+
+func (self *ApiSuite) TestQueryResponseIsntCompressedWithARejectedEncoding(c *C) {
+	resp := self.queryWithAcceptEncoding(c, "gzip;q=0, deflate;q=0.2")
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("Content-Encoding"), Equals, "deflate")
+	reader, err := zlib.NewReader(resp.Body)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	series := []SerializedSeries{}
+	c.Assert(json.Unmarshal(data, &series), IsNil)
+	c.Assert(series, HasLen, 1)
+
+	resp = self.queryWithAcceptEncoding(c, "gzip;q=0")
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("Content-Encoding"), Equals, "")
+}
+
+func (self *ApiSuite) queryWithAcceptEncoding(c *C, acceptEncoding string) *libhttp.Response {
+	query := url.QueryEscape("select * from foo;")
+	addr := self.formatUrl("/db/foo/series?q=%s&u=dbuser&p=password", query)
+	req, err := libhttp.NewRequest("GET", addr, nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	// disable the transparent decompression of the client
+	transport := &libhttp.Transport{DisableCompression: true}
+	resp, err := transport.RoundTrip(req)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	return resp
+}
+
 func (self *ApiSuite) TestWriteDataWithTime(c *C) {
 	data := `
 [
@@ -550,85 +871,717 @@ func (self *ApiSuite) TestWriteData(c *C) {
 	c.Assert(*series.Points[0].Values[3].BoolValue, Equals, true)
 }
 
-func (self *ApiSuite) TestWriteDataAsClusterAdmin(c *C) {
-	data := `
-[
-  {
-    "points": [
-				["1", true]
-    ],
-    "name": "foo",
-    "columns": ["column_one", "column_two"]
-  }
-]
-`
+func (self *ApiSuite) TestRequestLimits(c *C) {
+	defer self.server.SetRequestLimits(0, 0)
+	data := `[{"name": "foo", "columns": ["value"], "points": [[1], [2], [3]]}]`
+	addr := self.formatUrl("/db/foo/series?u=dbuser&p=password")
 
-	addr := self.formatUrl("/db/foo/series?u=root&p=root")
+	for _, limit := range []string{MAX_BODY_SIZE_LIMIT, MAX_POINTS_PER_WRITE_LIMIT} {
+		if limit == MAX_BODY_SIZE_LIMIT {
+			self.server.SetRequestLimits(int64(len(data)-1), 0)
+		} else {
+			self.server.SetRequestLimits(int64(len(data)), 2)
+		}
+		resp, err := libhttp.Post(addr, "application/json", bytes.NewBufferString(data))
+		c.Assert(err, IsNil)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, libhttp.StatusRequestEntityTooLarge)
+		c.Assert(resp.Header.Get("content-type"), Equals, "application/json")
+		limitError := &requestLimitError{}
+		c.Assert(json.Unmarshal(body, limitError), IsNil)
+		c.Assert(limitError.Limit, Equals, limit)
+		c.Assert(self.coordinator.series, HasLen, 0)
+	}
+
+	self.server.SetRequestLimits(int64(len(data)), 3)
 	resp, err := libhttp.Post(addr, "application/json", bytes.NewBufferString(data))
 	c.Assert(err, IsNil)
+	resp.Body.Close()
 	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.coordinator.series, HasLen, 1)
 }
 
-func (self *ApiSuite) TestCreateDatabase(c *C) {
-	data := `{"name": "foo", "apiKey": "bar"}`
-	addr := self.formatUrl("/db?api_key=asdf&u=root&p=root")
+// only the writes can stream their body past the maximum body size
+func (self *ApiSuite) TestStreamingDoesntLiftTheBodyLimitOfOtherEndpoints(c *C) {
+	defer self.server.SetRequestLimits(0, 0)
+	data := `{"token": "` + strings.Repeat("x", 100) + `"}`
+	self.server.SetRequestLimits(int64(len(data)-1), 0)
+	resp, err := libhttp.Post(self.formatUrl("/db/db1/reset_password?stream=true"), "application/json", bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusRequestEntityTooLarge)
+}
+
+func (self *ApiSuite) TestAsyncWrites(c *C) {
+	data := `[{"name": "foo", "columns": ["value"], "points": [[1], [2]]}]`
+	addr := self.formatUrl("/db/foo/series?u=dbuser&p=password&async=true")
 	resp, err := libhttp.Post(addr, "application/json", bytes.NewBufferString(data))
 	c.Assert(err, IsNil)
-	_, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+
+	dir, err := ioutil.TempDir("", "intake")
 	c.Assert(err, IsNil)
-	c.Assert(resp.StatusCode, Equals, libhttp.StatusCreated)
-	c.Assert(self.coordinator.db, Equals, "foo")
-}
+	defer os.RemoveAll(dir)
+	c.Assert(self.server.EnableAsyncWrites(dir), IsNil)
+	self.server.intakeQueue.Start()
+	defer func() {
+		self.server.intakeQueue.Close()
+		self.server.intakeQueue = nil
+	}()
 
-func (self *ApiSuite) TestDropDatabase(c *C) {
-	addr := self.formatUrl("/db/foo?u=root&p=root")
-	req, err := libhttp.NewRequest("DELETE", addr, nil)
+	resp, err = libhttp.Post(addr, "application/json", bytes.NewBufferString(data))
 	c.Assert(err, IsNil)
-	resp, err := libhttp.DefaultClient.Do(req)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusAccepted)
+	for i := 0; i < 100 && self.server.intakeQueue.Stats().Applied == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(self.coordinator.series, HasLen, 1)
+	c.Assert(self.coordinator.series[0].Points, HasLen, 2)
+
+	resp, err = libhttp.Get(self.formatUrl("/intake_queue?u=root&p=root"))
 	c.Assert(err, IsNil)
-	_, err = ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
 	c.Assert(err, IsNil)
-	c.Assert(resp.StatusCode, Equals, libhttp.StatusNoContent)
-	c.Assert(self.coordinator.droppedDb, Equals, "foo")
+	stats := &IntakeQueueStats{}
+	c.Assert(json.Unmarshal(body, stats), IsNil)
+	c.Assert(*stats, Equals, IntakeQueueStats{Depth: 0, Applied: 1, Failures: 0})
 }
 
-func (self *ApiSuite) TestClusterAdminOperations(c *C) {
-	url := self.formatUrl("/cluster_admins?u=root&p=root")
-	resp, err := libhttp.Post(url, "", bytes.NewBufferString(`{"name":"", "password": "new_pass"}`))
-	c.Assert(err, IsNil)
-	defer resp.Body.Close()
-	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+func (self *ApiSuite) TestQueuedWritesAreAppliedWithTheCurrentPermissions(c *C) {
+	body := json.RawMessage(`[{"name": "foo", "columns": ["value"], "points": [[1]]}]`)
+	for _, entry := range []*intakeEntry{
+		&intakeEntry{Database: "foo", User: "foo_writer", Body: body},
+		&intakeEntry{Database: "foo", User: "api_key:id", Body: body},
+		&intakeEntry{Database: "foo", User: "root", ClusterAdmin: true, Body: body},
+	} {
+		c.Assert(self.server.applyQueuedWrite(entry), IsNil)
+	}
+	c.Assert(self.coordinator.series, HasLen, 3)
+
+	for _, entry := range []*intakeEntry{
+		// the user can't write to the db
+		&intakeEntry{Database: "bar", User: "foo_writer", Body: body},
+		// the user, the api key and the cluster admin were deleted
+		&intakeEntry{Database: "foo", User: "deleted", Body: body},
+		&intakeEntry{Database: "foo", User: "api_key:revoked", Body: body},
+		&intakeEntry{Database: "foo", User: "deleted", ClusterAdmin: true, Body: body},
+	} {
+		err := self.server.applyQueuedWrite(entry)
+		_, ok := err.(AuthorizationError)
+		c.Assert(ok, Equals, true, Commentf("%s: %v", entry.User, err))
+	}
+	c.Assert(self.coordinator.series, HasLen, 3)
+}
 
-	url = self.formatUrl("/cluster_admins?u=root&p=root")
-	resp, err = libhttp.Post(url, "", bytes.NewBufferString(`{"name":"new_user", "password": "new_pass"}`))
+func (self *ApiSuite) TestWriteDataToMultipleDatabases(c *C) {
+	data := `
+[
+  {
+    "database": "foo",
+    "series": [{"name": "cpu", "columns": ["value"], "points": [[1]]}]
+  },
+  {
+    "database": "bar",
+    "series": [{"name": "mem", "columns": ["value"], "points": [[2], [3]]}]
+  }
+]
+`
+	addr := self.formatUrl("/series?u=dbuser&p=password")
+	resp, err := libhttp.Post(addr, "application/json", bytes.NewBufferString(data))
 	c.Assert(err, IsNil)
-	defer resp.Body.Close()
 	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
-	c.Assert(self.manager.ops, HasLen, 1)
-	c.Assert(self.manager.ops[0].operation, Equals, "cluster_admin_add")
-	c.Assert(self.manager.ops[0].username, Equals, "new_user")
-	c.Assert(self.manager.ops[0].password, Equals, "new_pass")
-	self.manager.ops = nil
+	c.Assert(self.coordinator.series, HasLen, 2)
+	c.Assert(self.coordinator.series[0].GetName(), Equals, "cpu")
+	c.Assert(self.coordinator.series[1].GetName(), Equals, "mem")
+	c.Assert(self.coordinator.series[1].Points, HasLen, 2)
+}
 
-	url = self.formatUrl("/cluster_admins/new_user?u=root&p=root")
-	resp, err = libhttp.Post(url, "", bytes.NewBufferString(`{"password":"new_password"}`))
+func (self *ApiSuite) TestWriteDataToMultipleDatabasesWithBadCredentials(c *C) {
+	data := `[{"database": "foo", "series": [{"name": "cpu", "columns": ["value"], "points": [[1]]}]}]`
+	addr := self.formatUrl("/series?u=fail_auth&p=password")
+	resp, err := libhttp.Post(addr, "application/json", bytes.NewBufferString(data))
 	c.Assert(err, IsNil)
-	defer resp.Body.Close()
-	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
-	c.Assert(self.manager.ops, HasLen, 1)
-	c.Assert(self.manager.ops[0].operation, Equals, "cluster_admin_passwd")
-	c.Assert(self.manager.ops[0].username, Equals, "new_user")
-	c.Assert(self.manager.ops[0].password, Equals, "new_password")
-	self.manager.ops = nil
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+	c.Assert(self.coordinator.series, HasLen, 0)
+}
 
-	req, _ := libhttp.NewRequest("DELETE", url, nil)
-	resp, err = libhttp.DefaultClient.Do(req)
+func (self *ApiSuite) TestWriteDataToMultipleDatabasesWithoutWriteAccess(c *C) {
+	// the user can only write to foo, nothing is written
+	data := `
+[
+  {"database": "foo", "series": [{"name": "cpu", "columns": ["value"], "points": [[1]]}]},
+  {"database": "bar", "series": [{"name": "mem", "columns": ["value"], "points": [[2]]}]}
+]
+`
+	addr := self.formatUrl("/series?u=foo_writer&p=password")
+	resp, err := libhttp.Post(addr, "application/json", bytes.NewBufferString(data))
 	c.Assert(err, IsNil)
-	defer resp.Body.Close()
-	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
-	c.Assert(self.manager.ops, HasLen, 1)
-	c.Assert(self.manager.ops[0].operation, Equals, "cluster_admin_del")
-	c.Assert(self.manager.ops[0].username, Equals, "new_user")
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusForbidden)
+	c.Assert(self.coordinator.series, HasLen, 0)
+}
+
+func (self *ApiSuite) TestTokenAuthentication(c *C) {
+	resp, err := libhttp.Post(self.formatUrl("/db/foo/token?u=dbuser&p=password"), "", nil)
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	token := &tokenResponse{}
+	c.Assert(json.Unmarshal(body, token), IsNil)
+	c.Assert(token.Token, Not(Equals), "")
+
+	query := url.QueryEscape("select * from foo;")
+	for db, statusCode := range map[string]int{"foo": libhttp.StatusOK, "bar": libhttp.StatusUnauthorized} {
+		req, err := libhttp.NewRequest("GET", self.formatUrl("/db/%s/series?q=%s", db, query), nil)
+		c.Assert(err, IsNil)
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		resp, err := libhttp.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, statusCode)
+	}
+
+	// tokens can't be used to get new tokens
+	req, err := libhttp.NewRequest("POST", self.formatUrl("/db/foo/token"), nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+
+	resp, err = libhttp.Post(self.formatUrl("/cluster_admins/token?u=fail_auth&p=password"), "", nil)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+}
+
+func (self *ApiSuite) TestTokensAreRevokedWhenTheCredentialsChange(c *C) {
+	self.manager.credentials = "fingerprint"
+	resp, err := libhttp.Post(self.formatUrl("/db/foo/token?u=dbuser&p=password"), "", nil)
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	token := &tokenResponse{}
+	c.Assert(json.Unmarshal(body, token), IsNil)
+
+	query := url.QueryEscape("select * from foo;")
+	// the credentials of the user change after the first query
+	for _, statusCode := range []int{libhttp.StatusOK, libhttp.StatusUnauthorized} {
+		req, err := libhttp.NewRequest("GET", self.formatUrl("/db/foo/series?q=%s", query), nil)
+		c.Assert(err, IsNil)
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		resp, err := libhttp.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, statusCode)
+		self.manager.credentials = "changed fingerprint"
+	}
+}
+
+func (self *ApiSuite) TestPasswordReset(c *C) {
+	resp, err := libhttp.Post(self.formatUrl("/db/db1/users/dbuser/reset_token?u=root&p=root&ttl=1h"), "", nil)
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	token := &tokenResponse{}
+	c.Assert(json.Unmarshal(body, token), IsNil)
+	c.Assert(token.Expires-time.Now().Unix() <= 3600, Equals, true)
+
+	// reset tokens can't be used to authenticate
+	req, err := libhttp.NewRequest("GET", self.formatUrl("/db/db1/series?q=%s", url.QueryEscape("select * from foo;")), nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+
+	data := fmt.Sprintf(`{"token": "%s", "password": "new_password"}`, token.Token)
+	resp, err = libhttp.Post(self.formatUrl("/db/db2/reset_password"), "application/json", bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+	c.Assert(self.manager.ops, HasLen, 0)
+
+	resp, err = libhttp.Post(self.formatUrl("/db/db1/reset_password"), "application/json", bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.manager.ops, HasLen, 1)
+	c.Assert(self.manager.ops[0].operation, Equals, "db_user_reset_passwd")
+	c.Assert(self.manager.ops[0].username, Equals, "dbuser")
+	c.Assert(self.manager.ops[0].password, Equals, "new_password")
+
+	// regular tokens can't reset passwords
+	resp, err = libhttp.Post(self.formatUrl("/db/db1/token?u=dbuser&p=password"), "", nil)
+	c.Assert(err, IsNil)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(json.Unmarshal(body, token), IsNil)
+	data = fmt.Sprintf(`{"token": "%s", "password": "new_password"}`, token.Token)
+	resp, err = libhttp.Post(self.formatUrl("/db/db1/reset_password"), "application/json", bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+}
+
+func (self *ApiSuite) TestApiKeys(c *C) {
+	data := `{"name": "automation", "databases": ["foo"], "write": true}`
+	resp, err := libhttp.Post(self.formatUrl("/api_keys?u=root&p=root"), "application/json", bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	apiKey := &ApiKeyDetail{}
+	c.Assert(json.Unmarshal(body, apiKey), IsNil)
+	c.Assert(apiKey.Key, Equals, "id.secret")
+	c.Assert(apiKey.Databases, DeepEquals, []string{"foo"})
+	c.Assert(self.manager.ops, HasLen, 1)
+	c.Assert(self.manager.ops[0].operation, Equals, "api_key_add")
+	c.Assert(self.manager.ops[0].username, Equals, "automation")
+
+	resp, err = libhttp.Get(self.formatUrl("/api_keys?u=root&p=root"))
+	c.Assert(err, IsNil)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(strings.Contains(string(body), "hash"), Equals, false)
+	apiKeys := []*ApiKeyDetail{}
+	c.Assert(json.Unmarshal(body, &apiKeys), IsNil)
+	c.Assert(apiKeys, HasLen, 1)
+	c.Assert(apiKeys[0].Key, Equals, "")
+
+	query := url.QueryEscape("select * from foo;")
+	for key, statusCode := range map[string]int{"id.secret": libhttp.StatusOK, "id.wrong": libhttp.StatusUnauthorized} {
+		req, err := libhttp.NewRequest("GET", self.formatUrl("/db/foo/series?q=%s", query), nil)
+		c.Assert(err, IsNil)
+		req.Header.Set("X-Api-Key", key)
+		resp, err := libhttp.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, statusCode)
+	}
+
+	// api keys can't manage the cluster
+	req, err := libhttp.NewRequest("GET", self.formatUrl("/api_keys"), nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("X-Api-Key", "id.secret")
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+
+	req, err = libhttp.NewRequest("DELETE", self.formatUrl("/api_keys/id?u=root&p=root"), nil)
+	c.Assert(err, IsNil)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.manager.ops, HasLen, 2)
+	c.Assert(self.manager.ops[1].operation, Equals, "api_key_del")
+	c.Assert(self.manager.ops[1].username, Equals, "id")
+}
+
+func (self *ApiSuite) TestDatabaseRateLimits(c *C) {
+	self.server.SetRateLimits(RateLimits{}, RateLimits{QueriesPerSecond: 1})
+	defer self.server.SetRateLimits(RateLimits{}, RateLimits{})
+
+	addr := self.formatUrl("/db/foo/series?q=%s&u=dbuser&p=password", url.QueryEscape("select * from foo;"))
+	resp, err := libhttp.Get(addr)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+
+	resp, err = libhttp.Get(addr)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, STATUS_TOO_MANY_REQUESTS)
+	c.Assert(resp.Header.Get("Retry-After"), Equals, "1")
+
+	// other databases have their own limits
+	resp, err = libhttp.Get(self.formatUrl("/db/bar/series?q=%s&u=dbuser&p=password", url.QueryEscape("select * from foo;")))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+}
+
+func (self *ApiSuite) TestPrometheusWrite(c *C) {
+	request := &protocol.PrometheusWriteRequest{
+		Timeseries: []*protocol.PrometheusTimeSeries{
+			&protocol.PrometheusTimeSeries{
+				Labels: []*protocol.PrometheusLabel{
+					&protocol.PrometheusLabel{Name: proto.String("job"), Value: proto.String("node")},
+					&protocol.PrometheusLabel{Name: proto.String("__name__"), Value: proto.String("up")},
+					&protocol.PrometheusLabel{Name: proto.String("instance"), Value: proto.String("host1:9100")},
+				},
+				Samples: []*protocol.PrometheusSample{
+					&protocol.PrometheusSample{Value: proto.Float64(1), Timestamp: proto.Int64(1400000000000)},
+					&protocol.PrometheusSample{Value: proto.Float64(math.NaN()), Timestamp: proto.Int64(1400000001000)},
+				},
+			},
+		},
+	}
+	data, err := proto.Marshal(request)
+	c.Assert(err, IsNil)
+	compressed, err := snappy.Encode(nil, data)
+	c.Assert(err, IsNil)
+
+	addr := self.formatUrl("/db/foo/prometheus/write?u=dbuser&p=password")
+	resp, err := libhttp.Post(addr, "application/x-protobuf", bytes.NewReader(compressed))
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusNoContent)
+	c.Assert(self.coordinator.series, HasLen, 1)
+	series := self.coordinator.series[0]
+	c.Assert(series.GetName(), Equals, "up")
+	c.Assert(series.Fields, DeepEquals, []string{"instance", "job", "value"})
+	// the NaN sample is dropped
+	c.Assert(series.Points, HasLen, 1)
+	c.Assert(series.Points[0].GetTimestamp(), Equals, int64(1400000000000000))
+	c.Assert(series.Points[0].Values[0].GetStringValue(), Equals, "host1:9100")
+	c.Assert(series.Points[0].Values[2].GetDoubleValue(), Equals, 1.0)
+}
+
+func (self *ApiSuite) TestPrometheusWriteWithInvalidBody(c *C) {
+	addr := self.formatUrl("/db/foo/prometheus/write?u=dbuser&p=password")
+	resp, err := libhttp.Post(addr, "application/x-protobuf", bytes.NewBufferString("not snappy"))
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+}
+
+func (self *ApiSuite) TestPrometheusRead(c *C) {
+	request := &protocol.PrometheusReadRequest{
+		Queries: []*protocol.PrometheusQuery{
+			&protocol.PrometheusQuery{StartTimestampMs: proto.Int64(0), EndTimestampMs: proto.Int64(1400000000000)},
+		},
+	}
+	data, err := proto.Marshal(request)
+	c.Assert(err, IsNil)
+	compressed, err := snappy.Encode(nil, data)
+	c.Assert(err, IsNil)
+
+	addr := self.formatUrl("/db/foo/prometheus/read?u=dbuser&p=password")
+	req, err := libhttp.NewRequest("POST", addr, bytes.NewReader(compressed))
+	c.Assert(err, IsNil)
+	req.Header.Set("Content-Encoding", "snappy")
+	resp, err := libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), Equals, "application/x-protobuf")
+
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	data, err = snappy.Decode(nil, body)
+	c.Assert(err, IsNil)
+	response := &protocol.PrometheusReadResponse{}
+	c.Assert(proto.Unmarshal(data, response), IsNil)
+	// the series returned by the mock coordinator don't have a value column
+	c.Assert(response.Results, HasLen, 1)
+	c.Assert(response.Results[0].Timeseries, HasLen, 0)
+}
+
+func (self *ApiSuite) TestWriteDataAsClusterAdmin(c *C) {
+	data := `
+[
+  {
+    "points": [
+				["1", true]
+    ],
+    "name": "foo",
+    "columns": ["column_one", "column_two"]
+  }
+]
+`
+
+	addr := self.formatUrl("/db/foo/series?u=root&p=root")
+	resp, err := libhttp.Post(addr, "application/json", bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+}
+
+func (self *ApiSuite) TestCreateDatabase(c *C) {
+	data := `{"name": "foo", "apiKey": "bar"}`
+	addr := self.formatUrl("/db?api_key=asdf&u=root&p=root")
+	resp, err := libhttp.Post(addr, "application/json", bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusCreated)
+	c.Assert(self.coordinator.db, Equals, "foo")
+}
+
+func (self *ApiSuite) TestAuditLog(c *C) {
+	path := c.MkDir() + "/audit.log"
+	c.Assert(self.server.EnableAuditLog(path, 0, 0), IsNil)
+	defer func() {
+		self.server.auditLog.Close()
+		self.server.auditLog = nil
+	}()
+
+	resp, err := libhttp.Post(self.formatUrl("/db?u=root&p=root"), "application/json", bytes.NewBufferString(`{"name": "audited"}`))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusCreated)
+	resp, err = libhttp.Get(self.formatUrl("/cluster_admins/authenticate?u=fail_auth&p=anypass"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+	// queries that don't change anything aren't logged
+	resp, err = libhttp.Get(self.formatUrl("/db/db1/series?u=dbuser&p=password&q=%s", url.QueryEscape("select * from foo")))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	resp, err = libhttp.Get(self.formatUrl("/db/db1/series?u=dbuser&p=password&q=%s", url.QueryEscape("drop series foo")))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	c.Assert(lines, HasLen, 3)
+	events := make([]*AuditEvent, 0, len(lines))
+	for _, line := range lines {
+		event := &AuditEvent{}
+		c.Assert(json.Unmarshal([]byte(line), event), IsNil)
+		c.Assert(event.SourceIp, Equals, "127.0.0.1")
+		c.Assert(event.RequestId, Not(Equals), "")
+		events = append(events, event)
+	}
+	c.Assert(events[0].Action, Equals, "create_database")
+	c.Assert(events[0].User, Equals, "root")
+	c.Assert(events[0].Database, Equals, "audited")
+	c.Assert(events[0].Success, Equals, true)
+	c.Assert(events[1].Action, Equals, "authenticate")
+	c.Assert(events[1].User, Equals, "fail_auth")
+	c.Assert(events[1].Success, Equals, false)
+	c.Assert(events[2].Action, Equals, "query")
+	c.Assert(events[2].User, Equals, "dbuser")
+	c.Assert(events[2].Target, Equals, "drop series foo")
+}
+
+func (self *ApiSuite) TestLockouts(c *C) {
+	self.server.SetLoginLockout(2, time.Minute)
+	defer self.server.SetLoginLockout(0, 0)
+
+	for _, status := range []int{libhttp.StatusUnauthorized, libhttp.StatusUnauthorized, STATUS_TOO_MANY_REQUESTS} {
+		resp, err := libhttp.Get(self.formatUrl("/db/db1/authenticate?u=fail_auth&p=anypass"))
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, status)
+	}
+
+	// the ip of the test is locked out as well
+	resp, err := libhttp.Get(self.formatUrl("/lockouts?u=root&p=root"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, STATUS_TOO_MANY_REQUESTS)
+	c.Assert(self.server.loginThrottle.Clear(LOCKOUT_IP, "127.0.0.1"), Equals, true)
+
+	resp, err = libhttp.Get(self.formatUrl("/lockouts?u=root&p=root"))
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	lockouts := []*Lockout{}
+	c.Assert(json.Unmarshal(body, &lockouts), IsNil)
+	c.Assert(lockouts, HasLen, 1)
+	c.Assert(lockouts[0].Name, Equals, "fail_auth")
+	c.Assert(lockouts[0].Failures, Equals, 2)
+
+	req, err := libhttp.NewRequest("DELETE", self.formatUrl("/lockouts/users/fail_auth?u=root&p=root"), nil)
+	c.Assert(err, IsNil)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+
+	resp, err = libhttp.Get(self.formatUrl("/db/db1/authenticate?u=fail_auth&p=anypass"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+}
+
+func (self *ApiSuite) TestCreateDatabaseAsDbUser(c *C) {
+	addr := self.formatUrl("/db/db1/databases?u=dbuser&p=password")
+	resp, err := libhttp.Post(addr, "application/json", bytes.NewBufferString(`{"name": "team_db"}`))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusCreated)
+	c.Assert(self.coordinator.db, Equals, "team_db")
+}
+
+func (self *ApiSuite) TestDebugVars(c *C) {
+	resp, err := libhttp.Get(self.formatUrl("/debug/vars?u=root&p=root"))
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	vars := map[string]interface{}{}
+	c.Assert(json.Unmarshal(body, &vars), IsNil)
+	c.Assert(vars["memstats"], NotNil)
+
+	resp, err = libhttp.Get(self.formatUrl("/debug/vars?u=dbuser&p=password"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+}
+
+func (self *ApiSuite) TestPprof(c *C) {
+	resp, err := libhttp.Get(self.formatUrl("/debug/pprof/goroutine?debug=1&u=root&p=root"))
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(strings.Contains(string(body), "goroutine profile"), Equals, true)
+
+	resp, err = libhttp.Get(self.formatUrl("/debug/pprof/cmdline?u=root&p=root"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+
+	resp, err = libhttp.Get(self.formatUrl("/debug/pprof/heap?u=dbuser&p=password"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+}
+
+func (self *ApiSuite) TestClusterAdminSecondFactor(c *C) {
+	for otp, expected := range map[string]int{
+		"":       libhttp.StatusUnauthorized,
+		"654321": libhttp.StatusUnauthorized,
+		"123456": libhttp.StatusOK,
+	} {
+		resp, err := libhttp.Get(self.formatUrl("/cluster_admins/authenticate?u=totp_admin&p=password&otp=%s", otp))
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, expected)
+	}
+
+	req, err := libhttp.NewRequest("GET", self.formatUrl("/cluster_admins/authenticate?u=totp_admin&p=password"), nil)
+	c.Assert(err, IsNil)
+	req.Header.Set(OTP_HEADER, "123456")
+	resp, err := libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+
+	resp, err = libhttp.Post(self.formatUrl("/cluster_admins/root/totp?u=root&p=root"), "", nil)
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	enrollment := &totpEnrollment{}
+	c.Assert(json.Unmarshal(body, enrollment), IsNil)
+	c.Assert(enrollment.Secret, Equals, "JBSWY3DPEHPK3PXP")
+	c.Assert(enrollment.Url, Matches, "otpauth://totp/InfluxDB:root\\?secret=JBSWY3DPEHPK3PXP.*")
+
+	resp, err = libhttp.Post(self.formatUrl("/cluster_admins/root/totp/confirm?u=root&p=root"), "", bytes.NewBufferString(`{"code": "123456"}`))
+	c.Assert(err, IsNil)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	codes := &totpRecoveryCodes{}
+	c.Assert(json.Unmarshal(body, codes), IsNil)
+	c.Assert(codes.RecoveryCodes, DeepEquals, []string{"recovery"})
+}
+
+func (self *ApiSuite) TestAllowedNetworks(c *C) {
+	addr := self.formatUrl("/db/db1/authenticate?u=restricted&p=password")
+	resp, err := libhttp.Get(addr)
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+	c.Assert(string(body), Matches, "restricted can't authenticate from .*")
+
+	addr = self.formatUrl("/db/db1/users/dbuser?u=root&p=root")
+	resp, err = libhttp.Post(addr, "", bytes.NewBufferString(`{"allowedNetworks": ["10.0.0.0/8", "192.168.1.1"]}`))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.manager.ops, HasLen, 1)
+	c.Assert(self.manager.ops[0].operation, Equals, "db_user_networks")
+	c.Assert(self.manager.ops[0].password, Equals, "10.0.0.0/8,192.168.1.1")
+	self.manager.ops = nil
+
+	addr = self.formatUrl("/cluster_admins/root?u=root&p=root")
+	resp, err = libhttp.Post(addr, "", bytes.NewBufferString(`{"allowedNetworks": []}`))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.manager.ops, HasLen, 1)
+	c.Assert(self.manager.ops[0].operation, Equals, "cluster_admin_networks")
+	self.manager.ops = nil
+}
+
+func (self *ApiSuite) TestDropDatabase(c *C) {
+	addr := self.formatUrl("/db/foo?u=root&p=root")
+	req, err := libhttp.NewRequest("DELETE", addr, nil)
+	c.Assert(err, IsNil)
+	resp, err := libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusNoContent)
+	c.Assert(self.coordinator.droppedDb, Equals, "foo")
+}
+
+func (self *ApiSuite) TestClusterAdminOperations(c *C) {
+	url := self.formatUrl("/cluster_admins?u=root&p=root")
+	resp, err := libhttp.Post(url, "", bytes.NewBufferString(`{"name":"", "password": "new_pass"}`))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+
+	url = self.formatUrl("/cluster_admins?u=root&p=root")
+	resp, err = libhttp.Post(url, "", bytes.NewBufferString(`{"name":"new_user", "password": "new_pass"}`))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.manager.ops, HasLen, 1)
+	c.Assert(self.manager.ops[0].operation, Equals, "cluster_admin_add")
+	c.Assert(self.manager.ops[0].username, Equals, "new_user")
+	c.Assert(self.manager.ops[0].password, Equals, "new_pass")
+	self.manager.ops = nil
+
+	url = self.formatUrl("/cluster_admins/new_user?u=root&p=root")
+	resp, err = libhttp.Post(url, "", bytes.NewBufferString(`{"password":"new_password"}`))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.manager.ops, HasLen, 1)
+	c.Assert(self.manager.ops[0].operation, Equals, "cluster_admin_passwd")
+	c.Assert(self.manager.ops[0].username, Equals, "new_user")
+	c.Assert(self.manager.ops[0].password, Equals, "new_password")
+	self.manager.ops = nil
+
+	req, _ := libhttp.NewRequest("DELETE", url, nil)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.manager.ops, HasLen, 1)
+	c.Assert(self.manager.ops[0].operation, Equals, "cluster_admin_del")
+	c.Assert(self.manager.ops[0].username, Equals, "new_user")
 }
 
 func (self *ApiSuite) TestDbUserOperations(c *C) {
@@ -684,6 +1637,32 @@ func (self *ApiSuite) TestDbUserOperations(c *C) {
 	c.Assert(self.manager.ops[0].isAdmin, Equals, false)
 	self.manager.ops = nil
 
+	// replace the roles of the user
+	url = self.formatUrl("/db/db1/users/dbuser?u=root&p=root")
+	resp, err = libhttp.Post(url, "", bytes.NewBufferString(`{"roles": ["collectors", "readers"]}`))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.manager.ops, HasLen, 1)
+	c.Assert(self.manager.ops[0].operation, Equals, "db_user_roles")
+	c.Assert(self.manager.ops[0].password, Equals, "collectors,readers")
+	self.manager.ops = nil
+	resp, err = libhttp.Post(url, "", bytes.NewBufferString(`{"roles": "collectors"}`))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+	c.Assert(self.manager.ops, HasLen, 0)
+
+	// let the user create databases
+	resp, err = libhttp.Post(url, "", bytes.NewBufferString(`{"canCreateDatabases": true}`))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.manager.ops, HasLen, 1)
+	c.Assert(self.manager.ops[0].operation, Equals, "db_user_create_databases")
+	c.Assert(self.manager.ops[0].isAdmin, Equals, true)
+	self.manager.ops = nil
+
 	url = self.formatUrl("/db/db1/users/dbuser?u=root&p=root")
 	req, _ := libhttp.NewRequest("DELETE", url, nil)
 	resp, err = libhttp.DefaultClient.Do(req)
@@ -845,3 +1824,479 @@ func (self *ApiSuite) TestContinuousQueryOperations(c *C) {
 	c.Assert(queries[0].Query, Equals, "select * from foo into bar;")
 	resp.Body.Close()
 }
+
+func (self *ApiSuite) TestListConnections(c *C) {
+	resp, err := libhttp.Get(self.formatUrl("/connections?u=root&p=root"))
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	connections := []*ConnectionInfo{}
+	c.Assert(json.Unmarshal(body, &connections), IsNil)
+
+	var current *ConnectionInfo
+	for _, connection := range connections {
+		if connection.Endpoint == "/connections" {
+			current = connection
+		}
+	}
+	c.Assert(current, NotNil)
+	c.Assert(current.User, Equals, "root")
+	c.Assert(current.Method, Equals, "GET")
+
+	req, err := libhttp.NewRequest("DELETE", self.formatUrl("/connections/%d?u=root&p=root", current.Id+1000), nil)
+	c.Assert(err, IsNil)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusNotFound)
+
+	resp, err = libhttp.Get(self.formatUrl("/connections?u=dbuser&p=password"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+}
+
+func (self *ApiSuite) TestRequestIds(c *C) {
+	data := `[{"points": [[1382131686, "1"]], "name": "foo", "columns": ["time", "column_one"]}]`
+	addr := self.formatUrl("/db/foo/series?time_precision=s&u=dbuser&p=password")
+	req, err := libhttp.NewRequest("POST", addr, bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	req.Header.Set("X-Request-Id", "from-the-client")
+	resp, err := libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+
+	id := resp.Header.Get("X-Request-Id")
+	c.Assert(id, Not(Equals), "")
+	c.Assert(id, Not(Equals), "from-the-client")
+	c.Assert(self.coordinator.requestIds, DeepEquals, []string{id})
+
+	resp, err = libhttp.Post(addr, "application/json", bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("X-Request-Id"), Not(Equals), id)
+}
+
+func (self *ApiSuite) TestStreamingWrites(c *C) {
+	body := `[{"points": [[1382131686, "1"]], "name": "foo", "columns": ["time", "column_one"]}]
+not json
+
+[{"points": [[1382131687, "2"], [1382131688, "3"]], "name": "foo", "columns": ["time", "column_one"]}]`
+	addr := self.formatUrl("/db/foo/series?stream=true&time_precision=s&u=dbuser&p=password")
+	resp, err := libhttp.Post(addr, "application/json", bytes.NewBufferString(body))
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+
+	acks := []*streamAck{}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		ack := &streamAck{}
+		if err := decoder.Decode(ack); err != nil {
+			break
+		}
+		acks = append(acks, ack)
+	}
+	resp.Body.Close()
+
+	c.Assert(acks, HasLen, 3)
+	c.Assert(*acks[0], Equals, streamAck{Batch: 1, Points: 1, Status: libhttp.StatusOK})
+	c.Assert(acks[1].Batch, Equals, 2)
+	c.Assert(acks[1].Status, Equals, libhttp.StatusBadRequest)
+	c.Assert(acks[1].Error, Not(Equals), "")
+	c.Assert(*acks[2], Equals, streamAck{Batch: 3, Points: 2, Status: libhttp.StatusOK})
+	c.Assert(self.coordinator.series, HasLen, 2)
+}
+
+func (self *ApiSuite) TestProtobufWrites(c *C) {
+	request := &protocol.WriteRequest{
+		Series: []*protocol.Series{
+			{
+				Name:   proto.String("foo"),
+				Fields: []string{"column_one"},
+				Points: []*protocol.Point{
+					{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(1)}}, Timestamp: proto.Int64(1382131686)},
+					{Values: []*protocol.FieldValue{{}}},
+				},
+			},
+		},
+	}
+	data, err := proto.Marshal(request)
+	c.Assert(err, IsNil)
+	addr := self.formatUrl("/db/foo/series?time_precision=s&u=dbuser&p=password")
+	resp, err := libhttp.Post(addr, "application/x-protobuf", bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.coordinator.series, HasLen, 1)
+	series := self.coordinator.series[0]
+	c.Assert(series.GetName(), Equals, "foo")
+	c.Assert(series.Points, HasLen, 2)
+	c.Assert(series.Points[0].GetTimestamp(), Equals, int64(1382131686000000))
+	c.Assert(series.Points[1].Timestamp, IsNil)
+	c.Assert(series.Points[1].Values[0].GetIsNull(), Equals, true)
+
+	// every point needs a value for every field
+	request.Series[0].Points[0].Values = nil
+	data, err = proto.Marshal(request)
+	c.Assert(err, IsNil)
+	resp, err = libhttp.Post(addr, "application/x-protobuf", bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+	c.Assert(self.coordinator.series, HasLen, 1)
+}
+
+func (self *ApiSuite) TestBulkImport(c *C) {
+	body := `{"points": [[1382131686, 1, "1"]], "name": "foo", "columns": ["time", "sequence_number", "column_one"]}
+{"points": [[1382131687, 2, "2"]], "name": "foo", "columns": ["time", "sequence_number", "column_one"]}
+not json
+
+[{"points": [[1382131688, "3"]], "name": "bar", "columns": ["time", "column_one"]}]`
+	addr := self.formatUrl("/db/foo/import?batch_size=2&time_precision=s&u=dbuser&p=password")
+	resp, err := libhttp.Post(addr, "application/x-ndjson", bytes.NewBufferString(body))
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+
+	progress := []*importProgress{}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		p := &importProgress{}
+		if err := decoder.Decode(p); err != nil {
+			break
+		}
+		progress = append(progress, p)
+	}
+	resp.Body.Close()
+
+	c.Assert(progress, HasLen, 4)
+	c.Assert(*progress[0], Equals, importProgress{Batch: 1, FirstLine: 1, LastLine: 2, Points: 2, Status: libhttp.StatusOK})
+	c.Assert(progress[1].FirstLine, Equals, 3)
+	c.Assert(progress[1].Status, Equals, libhttp.StatusBadRequest)
+	c.Assert(progress[1].Error, Not(Equals), "")
+	c.Assert(*progress[2], Equals, importProgress{Batch: 2, FirstLine: 5, LastLine: 5, Points: 1, Status: libhttp.StatusOK})
+	c.Assert(*progress[3], Equals, importProgress{Done: true, Points: 3, Failed: 1})
+
+	// the points of the first batch are written as one series
+	c.Assert(self.coordinator.series, HasLen, 2)
+	c.Assert(self.coordinator.series[0].GetName(), Equals, "foo")
+	c.Assert(self.coordinator.series[0].Points, HasLen, 2)
+	c.Assert(self.coordinator.series[0].Points[1].GetSequenceNumber(), Equals, uint64(2))
+}
+
+func (self *ApiSuite) TestCsvImport(c *C) {
+	body := "host,time,value,comment\nserver1,1382131686,1,a\nserver2,1382131687,x,b\nserver1,1382131688,2.5,c\n"
+	params := "format=csv&name_column=host&types=value:float,comment:skip&batch_size=10&time_precision=s&u=dbuser&p=password"
+	resp, err := libhttp.Post(self.formatUrl("/db/foo/import?%s", params), "text/csv", bytes.NewBufferString(body))
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+
+	progress := []*importProgress{}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		p := &importProgress{}
+		if err := decoder.Decode(p); err != nil {
+			break
+		}
+		progress = append(progress, p)
+	}
+	resp.Body.Close()
+
+	c.Assert(progress, HasLen, 4)
+	c.Assert(*progress[0], Equals, importProgress{Batch: 1, FirstLine: 2, LastLine: 2, Points: 1, Status: libhttp.StatusOK})
+	c.Assert(progress[1].FirstLine, Equals, 3)
+	c.Assert(progress[1].Status, Equals, libhttp.StatusBadRequest)
+	c.Assert(*progress[2], Equals, importProgress{Batch: 2, FirstLine: 4, LastLine: 4, Points: 1, Status: libhttp.StatusOK})
+	c.Assert(*progress[3], Equals, importProgress{Done: true, Points: 2, Failed: 1})
+
+	c.Assert(self.coordinator.series, HasLen, 2)
+	c.Assert(self.coordinator.series[1].GetName(), Equals, "server1")
+	c.Assert(self.coordinator.series[1].Fields, DeepEquals, []string{"value"})
+	point := self.coordinator.series[1].Points[0]
+	c.Assert(point.GetTimestamp(), Equals, int64(1382131688000000))
+	c.Assert(point.Values[0].GetDoubleValue(), Equals, 2.5)
+
+	resp, err = libhttp.Post(self.formatUrl("/db/foo/import?format=csv&u=dbuser&p=password"), "text/csv", bytes.NewBufferString(body))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+}
+
+func (self *ApiSuite) TestDeleteQueryFromRequest(c *C) {
+	for params, expected := range map[string]string{
+		"regex=cpu.*": "delete from /cpu.*/",
+		"regex=a/b&start=10&end=20&time_precision=s": `delete from /a\/b/ where time > 10000000u and time < 20000000u`,
+		"regex=cpu&end=20&time_precision=u":          "delete from /cpu/ where time < 20u",
+		"regex=cpu&start=20":                         "delete from /cpu/ where time > 20000u",
+		// the slashes in the regex can't end it
+		"regex=a/+where+time+>+0+or+/b": `delete from /a\/ where time > 0 or \/b/`,
+	} {
+		r, err := libhttp.NewRequest("DELETE", "/db/foo/series?"+params, nil)
+		c.Assert(err, IsNil)
+		query, err := deleteQueryFromRequest(r)
+		c.Assert(err, IsNil)
+		c.Assert(query, Equals, expected)
+		_, err = parser.ParseQuery(query)
+		c.Assert(err, IsNil)
+	}
+
+	for _, params := range []string{
+		"start=10",
+		"regex=cpu&start=yesterday",
+		"regex=cpu&time_precision=h",
+		// the regexes that don't parse as the regex of a single delete
+		"regex=(",
+		"regex=cpu%5C",
+		"regex=cpu%5C&start=10",
+		"regex=cpu%5C%5C%5C&end=20",
+		"regex=cpu%5C%3B+drop+series+foo",
+	} {
+		r, err := libhttp.NewRequest("DELETE", "/db/foo/series?"+params, nil)
+		c.Assert(err, IsNil)
+		_, err = deleteQueryFromRequest(r)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (self *ApiSuite) TestDeletePoints(c *C) {
+	req, err := libhttp.NewRequest("DELETE", self.formatUrl("/db/foo/series?regex=cpu.*&start=10&u=root&p=root"), nil)
+	c.Assert(err, IsNil)
+	resp, err := libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusNoContent)
+
+	req, err = libhttp.NewRequest("DELETE", self.formatUrl("/db/foo/series?u=root&p=root"), nil)
+	c.Assert(err, IsNil)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+}
+
+func (self *ApiSuite) TestPaginatedQuery(c *C) {
+	query := url.QueryEscape("select * from foo limit 1")
+	resp, err := libhttp.Get(self.formatUrl("/db/foo/series?q=%s&paginate=true&u=dbuser&p=password", query))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	cursor := resp.Header.Get(CURSOR_HEADER)
+	c.Assert(cursor, Not(Equals), "")
+
+	// the points of the first page are dropped
+	resp, err = libhttp.Get(self.formatUrl("/db/foo/series?q=%s&cursor=%s&u=dbuser&p=password", query, cursor))
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	series := []*SerializedSeries{}
+	c.Assert(json.Unmarshal(body, &series), IsNil)
+	for _, s := range series {
+		c.Assert(s.Points, HasLen, 0)
+	}
+
+	for _, params := range []string{
+		"q=" + url.QueryEscape("select * from foo") + "&paginate=true",
+		"q=" + url.QueryEscape("select * from bar limit 1") + "&cursor=" + cursor,
+		"q=" + query + "&paginate=true&chunked=true",
+	} {
+		resp, err := libhttp.Get(self.formatUrl("/db/foo/series?%s&u=dbuser&p=password", params))
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+	}
+}
+
+func (self *ApiSuite) TestWriteSubscriptions(c *C) {
+	body := `{"name": "stream", "database": "db1", "destinations": ["http://localhost:9999/db/copy/series", "udp://localhost:4444"]}`
+	resp, err := libhttp.Post(self.formatUrl("/subscriptions?u=root&p=root"), "application/json", strings.NewReader(body))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusCreated)
+
+	body = `{"name": "invalid", "database": "db1", "destinations": ["ftp://localhost"]}`
+	resp, err = libhttp.Post(self.formatUrl("/subscriptions?u=root&p=root"), "application/json", strings.NewReader(body))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+
+	resp, err = libhttp.Get(self.formatUrl("/subscriptions?u=dbuser&p=password"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+
+	resp, err = libhttp.Get(self.formatUrl("/subscriptions?u=root&p=root"))
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	subscriptions := []*WriteSubscriptionDetail{}
+	c.Assert(json.Unmarshal(data, &subscriptions), IsNil)
+	c.Assert(subscriptions, HasLen, 1)
+	c.Assert(subscriptions[0].Name, Equals, "stream")
+	c.Assert(subscriptions[0].Destinations, HasLen, 2)
+	c.Assert(subscriptions[0].CreatedBy, Equals, "root")
+
+	req, err := libhttp.NewRequest("DELETE", self.formatUrl("/subscriptions/stream?u=root&p=root"), nil)
+	c.Assert(err, IsNil)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.coordinator.subscriptions, HasLen, 0)
+}
+
+func (self *ApiSuite) TestRoles(c *C) {
+	body := `{"name": "collectors", "permissions": {"db1": {"readFrom": ["/^cpu\\..*/"], "writeTo": ["/.*/", "events"]}}}`
+	resp, err := libhttp.Post(self.formatUrl("/roles?u=root&p=root"), "application/json", strings.NewReader(body))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	role := self.coordinator.roles["collectors"]
+	c.Assert(role, NotNil)
+	c.Assert(role.Permissions["db1"].ReadFrom, HasLen, 1)
+	c.Assert(*role.Permissions["db1"].ReadFrom[0], Equals, cluster.Matcher{IsRegex: true, Name: "^cpu\\..*"})
+	c.Assert(*role.Permissions["db1"].WriteTo[1], Equals, cluster.Matcher{Name: "events"})
+
+	resp, err = libhttp.Post(self.formatUrl("/roles?u=root&p=root"), "application/json", strings.NewReader(`{"name": "invalid name"}`))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+
+	resp, err = libhttp.Get(self.formatUrl("/roles?u=dbuser&p=password"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+
+	resp, err = libhttp.Get(self.formatUrl("/roles?u=root&p=root"))
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	roles := []*RoleDetail{}
+	c.Assert(json.Unmarshal(data, &roles), IsNil)
+	c.Assert(roles, HasLen, 1)
+	c.Assert(roles[0].CreatedBy, Equals, "root")
+	c.Assert(roles[0].Permissions["db1"].ReadFrom, DeepEquals, []string{"/^cpu\\..*/"})
+
+	req, err := libhttp.NewRequest("DELETE", self.formatUrl("/roles/collectors?u=root&p=root"), nil)
+	c.Assert(err, IsNil)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.coordinator.roles, HasLen, 0)
+}
+
+func (self *ApiSuite) TestDeadLetters(c *C) {
+	series := &protocol.Series{Name: protocol.String("foo"), Fields: []string{"value"}}
+	c.Assert(self.server.deadLetters.Add("db1", 1, series, fmt.Errorf("bad points")), IsNil)
+	c.Assert(self.server.deadLetters.Add("db1", 1, series, fmt.Errorf("bad points")), IsNil)
+
+	resp, err := libhttp.Get(self.formatUrl("/db/db1/dead_letters?u=root&p=root"))
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	letters := []*cluster.DeadLetter{}
+	c.Assert(json.Unmarshal(body, &letters), IsNil)
+	c.Assert(letters, HasLen, 2)
+	c.Assert(letters[0].Error, Equals, "bad points")
+	c.Assert(letters[0].Series.GetName(), Equals, "foo")
+
+	// retrying writes the points again and removes the dead letter
+	resp, err = libhttp.Post(self.formatUrl("/db/db1/dead_letters/%d/retry?u=root&p=root", letters[0].Id), "application/json", nil)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(self.coordinator.series, HasLen, 1)
+	c.Assert(self.coordinator.series[0].GetName(), Equals, "foo")
+
+	resp, err = libhttp.Get(self.formatUrl("/db/db1/dead_letters/%d?u=root&p=root", letters[0].Id))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusNotFound)
+
+	// only cluster admins can see the dead letters
+	resp, err = libhttp.Get(self.formatUrl("/db/db1/dead_letters?u=dbuser&p=password"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+
+	req, err := libhttp.NewRequest("DELETE", self.formatUrl("/db/db1/dead_letters?u=root&p=root"), nil)
+	c.Assert(err, IsNil)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	remaining, err := self.server.deadLetters.List("db1")
+	c.Assert(err, IsNil)
+	c.Assert(remaining, HasLen, 0)
+}
+
+func (self *ApiSuite) TestReloadConfiguration(c *C) {
+	reloads := 0
+	self.server.SetConfigReloader(func() error {
+		reloads++
+		if reloads > 1 {
+			return fmt.Errorf("invalid log level")
+		}
+		return nil
+	})
+	defer self.server.SetConfigReloader(nil)
+
+	resp, err := libhttp.Post(self.formatUrl("/cluster/config/reload?u=root&p=root"), "application/json", nil)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(reloads, Equals, 1)
+
+	resp, err = libhttp.Post(self.formatUrl("/cluster/config/reload?u=root&p=root"), "application/json", nil)
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+	c.Assert(string(body), Equals, "invalid log level")
+
+	// only cluster admins can reload the configuration
+	resp, err = libhttp.Post(self.formatUrl("/cluster/config/reload?u=dbuser&p=password"), "application/json", nil)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusUnauthorized)
+	c.Assert(reloads, Equals, 2)
+}
+
+func (self *ApiSuite) TestOverrideLogLevel(c *C) {
+	data := `{"module": "coordinator", "level": "debug", "duration": "10m"}`
+	resp, err := libhttp.Post(self.formatUrl("/cluster/log_levels?u=root&p=root"), "application/json", bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+
+	resp, err = libhttp.Get(self.formatUrl("/cluster/log_levels?u=root&p=root"))
+	c.Assert(err, IsNil)
+	overrides := []*logging.LevelOverride{}
+	c.Assert(json.NewDecoder(resp.Body).Decode(&overrides), IsNil)
+	resp.Body.Close()
+	c.Assert(overrides, HasLen, 1)
+	c.Assert(overrides[0].Module, Equals, "coordinator")
+	c.Assert(overrides[0].Until.IsZero(), Equals, false)
+
+	data = `{"module": "coordinator", "level": "loud"}`
+	resp, err = libhttp.Post(self.formatUrl("/cluster/log_levels?u=root&p=root"), "application/json", bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusBadRequest)
+
+	req, _ := libhttp.NewRequest("DELETE", self.formatUrl("/cluster/log_levels/coordinator?u=root&p=root"), nil)
+	resp, err = libhttp.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusOK)
+	c.Assert(logging.Overrides(), HasLen, 0)
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	libhttp "net/http"
+	"os"
+	"parser"
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// An entry of the audit log, they're written as one json object per
+// line
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	User      string    `json:"user,omitempty"`
+	SourceIp  string    `json:"sourceIp,omitempty"`
+	Listener  string    `json:"listener,omitempty"`
+	RequestId string    `json:"requestId,omitempty"`
+	Database  string    `json:"database,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// An append only log of the authentication attempts and the commands
+// that change users, databases or delete data. The file is rotated
+// once it's bigger than maxSize, the last maxBackups files are kept as
+// path.1, path.2 and so on.
+type AuditLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	lock       sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+func NewAuditLog(path string, maxSize int64, maxBackups int) (*AuditLog, error) {
+	self := &AuditLog{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := self.open(); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+func (self *AuditLog) open() error {
+	file, err := os.OpenFile(self.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	self.file = file
+	self.size = info.Size()
+	return nil
+}
+
+func (self *AuditLog) rotate() error {
+	if err := self.file.Close(); err != nil {
+		return err
+	}
+	if self.maxBackups <= 0 {
+		if err := os.Remove(self.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return self.open()
+	}
+	for i := self.maxBackups - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", self.path, i)
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", self.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(self.path, self.path+".1"); err != nil {
+		return err
+	}
+	return self.open()
+}
+
+func (self *AuditLog) Log(event *AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.file == nil {
+		return fmt.Errorf("The audit log is closed")
+	}
+	if self.maxSize > 0 && self.size > 0 && self.size+int64(len(data)) > self.maxSize {
+		if err := self.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := self.file.Write(data)
+	self.size += int64(n)
+	return err
+}
+
+func (self *AuditLog) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.file == nil {
+		return nil
+	}
+	err := self.file.Close()
+	self.file = nil
+	return err
+}
+
+// Writes the authentication attempts, user and database management
+// commands and destructive queries to the audit log at path, an empty
+// path disables the log
+func (self *HttpServer) EnableAuditLog(path string, maxSize int64, maxBackups int) error {
+	if path == "" {
+		return nil
+	}
+	auditLog, err := NewAuditLog(path, maxSize, maxBackups)
+	if err != nil {
+		return err
+	}
+	log.Info("Writing the audit log to %s", path)
+	self.auditLog = auditLog
+	return nil
+}
+
+// Returns the ip address of the client, it's empty for requests to
+// the unix socket
+func sourceIp(r *libhttp.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// Records the action of the user, err is the reason the action failed
+func (self *HttpServer) audit(r *libhttp.Request, username, action, db, target string, err error) {
+	if self.auditLog == nil {
+		return
+	}
+	event := &AuditEvent{
+		Time:      time.Now().UTC(),
+		Action:    action,
+		User:      username,
+		SourceIp:  sourceIp(r),
+		Listener:  requestListener(r),
+		RequestId: requestId(r),
+		Database:  db,
+		Target:    target,
+		Success:   err == nil,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if err := self.auditLog.Log(event); err != nil {
+		log.Error("Cannot write to the audit log: %s", err)
+	}
+}
+
+// Records a failed authentication with the database of the request
+func (self *HttpServer) auditAuthenticationFailure(r *libhttp.Request, username, message string) {
+	self.audit(r, username, "authenticate", r.URL.Query().Get(":db"), "", errors.New(message))
+}
+
+// Returns true if the query deletes data or continuous queries
+func isDestructiveQuery(query string) bool {
+	queries, err := parser.ParseQuery(query)
+	if err != nil {
+		return false
+	}
+	for _, q := range queries {
+		if q.DeleteQuery != nil || q.DropSeriesQuery != nil || q.DropQuery != nil {
+			return true
+		}
+	}
+	return false
+}
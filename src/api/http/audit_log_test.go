@@ -0,0 +1,45 @@
+package http
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+type AuditLogSuite struct{}
+
+var _ = Suite(&AuditLogSuite{})
+
+func (self *AuditLogSuite) TestRotation(c *C) {
+	path := c.MkDir() + "/audit.log"
+	auditLog, err := NewAuditLog(path, 200, 2)
+	c.Assert(err, IsNil)
+	defer auditLog.Close()
+
+	for _, action := range []string{"first", "second", "third", "fourth"} {
+		c.Assert(auditLog.Log(&AuditEvent{Action: action, User: strings.Repeat("x", 100)}), IsNil)
+	}
+
+	for file, action := range map[string]string{
+		path:        "fourth",
+		path + ".1": "third",
+		path + ".2": "second",
+	} {
+		data, err := ioutil.ReadFile(file)
+		c.Assert(err, IsNil)
+		c.Assert(strings.Count(string(data), "\n"), Equals, 1)
+		c.Assert(strings.Contains(string(data), `"action":"`+action+`"`), Equals, true)
+	}
+	_, err = os.Stat(path + ".3")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (self *AuditLogSuite) TestDestructiveQueries(c *C) {
+	c.Assert(isDestructiveQuery("delete from foo where time < now() - 1d"), Equals, true)
+	c.Assert(isDestructiveQuery("drop series foo"), Equals, true)
+	c.Assert(isDestructiveQuery("drop continuous query 1"), Equals, true)
+	c.Assert(isDestructiveQuery("select * from foo"), Equals, false)
+	c.Assert(isDestructiveQuery("list series"), Equals, false)
+}
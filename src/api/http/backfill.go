@@ -0,0 +1,63 @@
+package http
+
+import (
+	. "common"
+	"coordinator"
+	"encoding/json"
+	"fmt"
+	libhttp "net/http"
+	"strconv"
+	"time"
+)
+
+type backfillInfo struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	Chunk           string    `json:"chunk"`
+	Concurrency     int       `json:"concurrency"`
+	PointsPerSecond int       `json:"pointsPerSecond"`
+}
+
+// Runs a continuous query over a past time range in the background, the
+// response is the job of the backfill. The job runs on this server, its
+// status is at /cluster/jobs/:id of this server.
+func (self *HttpServer) backfillContinuousQuery(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if self.raftServer == nil {
+			return libhttp.StatusNotFound, "Raft isn't running on this server"
+		}
+		id, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 32)
+		if err != nil {
+			return libhttp.StatusBadRequest, "Invalid continuous query id"
+		}
+		info := &backfillInfo{}
+		if err := json.NewDecoder(r.Body).Decode(info); err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		if info.Concurrency < 0 || info.PointsPerSecond < 0 {
+			return libhttp.StatusBadRequest, "concurrency and pointsPerSecond should be positive numbers"
+		}
+		options := &coordinator.BackfillOptions{
+			Start:           info.Start,
+			End:             info.End,
+			Concurrency:     info.Concurrency,
+			PointsPerSecond: info.PointsPerSecond,
+		}
+		if info.Chunk != "" {
+			chunk, err := ParseTimeDuration(info.Chunk)
+			if err != nil {
+				return libhttp.StatusBadRequest, fmt.Sprintf("Invalid chunk %s: %s", info.Chunk, err)
+			}
+			options.ChunkSize = time.Duration(chunk)
+		}
+
+		job, err := self.raftServer.BackfillContinuousQuery(db, uint32(id), options)
+		self.audit(r, u.GetName(), "backfill_continuous_query", db, strconv.FormatUint(id, 10), err)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		return libhttp.StatusAccepted, job.Status()
+	})
+}
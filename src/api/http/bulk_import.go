@@ -0,0 +1,241 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	. "common"
+	"encoding/json"
+	"fmt"
+	"io"
+	libhttp "net/http"
+	"protocol"
+	"strconv"
+
+	log "code.google.com/p/log4go"
+)
+
+const IMPORT_DEFAULT_BATCH_SIZE = 5000
+
+// The progress of a bulk import, there's a line for every batch and a
+// last line with done set once the body was read. The lines of a
+// batch are numbered from 1, so a client can resume an import from the
+// first line of a batch that failed.
+type importProgress struct {
+	Batch     int    `json:"batch,omitempty"`
+	FirstLine int    `json:"firstLine,omitempty"`
+	LastLine  int    `json:"lastLine,omitempty"`
+	Points    int    `json:"points"`
+	Status    int    `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Done      bool   `json:"done,omitempty"`
+	Failed    int    `json:"failedBatches,omitempty"`
+}
+
+// Accumulates the lines of a dump into batches, consecutive lines of
+// the same series with the same columns are merged into one series
+type importBatch struct {
+	series    []*SerializedSeries
+	points    int
+	firstLine int
+	lastLine  int
+}
+
+func (self *importBatch) add(line int, series []*SerializedSeries) {
+	if self.firstLine == 0 {
+		self.firstLine = line
+	}
+	self.lastLine = line
+	for _, s := range series {
+		self.points += len(s.Points)
+		if n := len(self.series); n > 0 {
+			last := self.series[n-1]
+			if last.Name == s.Name && sameColumns(last.Columns, s.Columns) {
+				last.Points = append(last.Points, s.Points...)
+				continue
+			}
+		}
+		self.series = append(self.series, s)
+	}
+}
+
+func (self *importBatch) reset() {
+	*self = importBatch{}
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Every line of a dump is a series like the ones exported with
+// format=ndjson, or an array of series like the body of a write
+func parseImportLine(data []byte) ([]*SerializedSeries, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		series := []*SerializedSeries{}
+		err := json.Unmarshal(data, &series)
+		return series, err
+	}
+	series := &SerializedSeries{}
+	if err := json.Unmarshal(data, series); err != nil {
+		return nil, err
+	}
+	return []*SerializedSeries{series}, nil
+}
+
+// Imports a dump into the database. The body is read as it arrives and
+// the points are written in batches of batch_size points, the progress
+// is reported with a line for every batch. A batch that fails doesn't
+// stop the import. The timestamps of the dump have to be in the
+// precision given by time_precision, like the timestamps of a write.
+// The size limit applies to every line of the body instead of the whole
+// body. With format=csv the body is a csv file that is written
+// with the mapping of the parameters, see csvMappingFromRequest.
+func (self *HttpServer) bulkImport(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+
+	self.tryAsDbUserAndClusterAdmin(w, r, func(user User) (int, interface{}) {
+		precision, err := TimePrecisionFromString(r.URL.Query().Get("time_precision"))
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		batchSize := IMPORT_DEFAULT_BATCH_SIZE
+		if value := r.URL.Query().Get("batch_size"); value != "" {
+			batchSize, err = strconv.Atoi(value)
+			if err != nil || batchSize <= 0 {
+				return libhttp.StatusBadRequest, fmt.Sprintf("Invalid batch size %s", value)
+			}
+		}
+		if self.maxPointsPerWrite > 0 && batchSize > self.maxPointsPerWrite {
+			batchSize = self.maxPointsPerWrite
+		}
+
+		var mapping *csvMapping
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "ndjson":
+		case "csv":
+			if mapping, err = csvMappingFromRequest(r, precision); err != nil {
+				return libhttp.StatusBadRequest, err.Error()
+			}
+		default:
+			return libhttp.StatusBadRequest, fmt.Sprintf("Unknown import format %s", format)
+		}
+
+		w.Header().Add("content-type", "application/json")
+		w.WriteHeader(libhttp.StatusOK)
+		if mapping != nil {
+			self.streamCsvImport(w, r, user, db, mapping, batchSize)
+		} else {
+			self.streamImport(w, r, user, db, precision, batchSize)
+		}
+		return -1, nil
+	})
+}
+
+func (self *HttpServer) streamImport(w libhttp.ResponseWriter, r *libhttp.Request, user User, db string, precision TimePrecision, batchSize int) {
+	encoder := json.NewEncoder(w)
+	reader := bufio.NewReader(r.Body)
+	summary := &importProgress{Done: true}
+	batch := &importBatch{}
+	batchNumber := 0
+
+	report := func(progress *importProgress) bool {
+		if err := encoder.Encode(progress); err != nil {
+			log.Debug("Cannot report the progress of the import to %s: %s", db, err)
+			return false
+		}
+		w.(libhttp.Flusher).Flush()
+		return true
+	}
+
+	flush := func() bool {
+		if batch.points == 0 {
+			batch.reset()
+			return true
+		}
+		batchNumber++
+		progress := &importProgress{
+			Batch:     batchNumber,
+			FirstLine: batch.firstLine,
+			LastLine:  batch.lastLine,
+		}
+		progress.Status, progress.Points, progress.Error = self.writeImportBatch(w, user, db, precision, batch.series)
+		if progress.Error != "" {
+			summary.Failed++
+		}
+		summary.Points += progress.Points
+		batch.reset()
+		self.connections.ExtendReadDeadline(r, self.readTimeout)
+		return report(progress)
+	}
+
+	for line := 1; ; line++ {
+		data, tooLarge, err := readStreamBatch(reader, self.maxBodySize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Debug("Import to %s stopped: %s", db, err)
+			return
+		}
+
+		var series []*SerializedSeries
+		if tooLarge {
+			err = fmt.Errorf("The line is larger than %d bytes", self.maxBodySize)
+		} else if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		} else {
+			series, err = parseImportLine(data)
+		}
+		if err != nil {
+			// the batch is written first so the lines are reported in order
+			if !flush() {
+				return
+			}
+			summary.Failed++
+			progress := &importProgress{FirstLine: line, LastLine: line, Status: libhttp.StatusBadRequest, Error: err.Error()}
+			if !report(progress) {
+				return
+			}
+			continue
+		}
+
+		batch.add(line, series)
+		if batch.points >= batchSize && !flush() {
+			return
+		}
+	}
+
+	if !flush() {
+		return
+	}
+	log.Info("Imported %d points into %s, %d batches failed", summary.Points, db, summary.Failed)
+	report(summary)
+}
+
+// Returns the status code, the number of points that were written and
+// the error message if the batch wasn't written
+func (self *HttpServer) writeImportBatch(w libhttp.ResponseWriter, user User, db string, precision TimePrecision, serializedSeries []*SerializedSeries) (int, int, string) {
+	series, err := convertToDataStoreSeries(serializedSeries, precision)
+	if err != nil {
+		return libhttp.StatusBadRequest, 0, err.Error()
+	}
+	return self.writeImportSeries(w, user, db, series)
+}
+
+func (self *HttpServer) writeImportSeries(w libhttp.ResponseWriter, user User, db string, series []*protocol.Series) (int, int, string) {
+	if statusCode, body := self.checkWriteRateLimits(w, user, db, series); statusCode != 0 {
+		return statusCode, 0, body.(string)
+	}
+	if err := self.coordinator.WriteSeriesData(user, db, series); err != nil {
+		return errorToStatusCode(err), 0, err.Error()
+	}
+	return libhttp.StatusOK, countPoints(series), ""
+}
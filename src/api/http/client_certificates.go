@@ -0,0 +1,57 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	libhttp "net/http"
+)
+
+// Requires clients of the ssl api to present a certificate signed by
+// one of the CAs in caPath. Requests are authenticated as the user
+// named after the common name of the certificate, which is a cluster
+// admin if one of the organizational units of the certificate is in
+// clusterAdminOUs and a db user otherwise.
+func (self *HttpServer) EnableClientCertificates(caPath string, clusterAdminOUs []string) {
+	self.clientCaPath = caPath
+	self.clusterAdminOUs = clusterAdminOUs
+}
+
+func (self *HttpServer) clientCertificatesConfig(config *tls.Config) error {
+	if self.clientCaPath == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(self.clientCaPath)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("Cannot find any certificates in %s", self.clientCaPath)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
+
+// Returns the name of the user in the verified client certificate and
+// whether it's a cluster admin. The last return value is false if the
+// request doesn't have a verified certificate.
+func (self *HttpServer) clientCertificateUser(r *libhttp.Request) (string, bool, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false, false
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if subject.CommonName == "" {
+		return "", false, false
+	}
+	for _, ou := range subject.OrganizationalUnit {
+		for _, adminOU := range self.clusterAdminOUs {
+			if ou == adminOU {
+				return subject.CommonName, true, true
+			}
+		}
+	}
+	return subject.CommonName, false, true
+}
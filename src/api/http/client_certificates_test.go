@@ -0,0 +1,56 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	. "launchpad.net/gocheck"
+	libhttp "net/http"
+)
+
+type ClientCertificateSuite struct {
+	server *HttpServer
+}
+
+var _ = Suite(&ClientCertificateSuite{})
+
+func (self *ClientCertificateSuite) SetUpTest(c *C) {
+	self.server = NewHttpServer("", 0, "", nil, &MockUserManager{}, nil, nil)
+	self.server.EnableClientCertificates("ca.pem", []string{"influxdb-admins"})
+}
+
+func requestWithCertificate(c *C, commonName string, ous ...string) *libhttp.Request {
+	r, err := libhttp.NewRequest("GET", "/db/foo/series", nil)
+	c.Assert(err, IsNil)
+	certificate := &x509.Certificate{
+		Subject: pkix.Name{CommonName: commonName, OrganizationalUnit: ous},
+	}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+	return r
+}
+
+func (self *ClientCertificateSuite) TestCertificatesAreMappedToUsers(c *C) {
+	_, statusCode, _ := self.server.clusterAdminFromRequest(requestWithCertificate(c, "root", "influxdb-admins"))
+	c.Assert(statusCode, Equals, 0)
+	_, statusCode, _ = self.server.dbUserFromRequest(requestWithCertificate(c, "dbuser", "developers"), "foo")
+	c.Assert(statusCode, Equals, 0)
+
+	// certificates of db users can't be used as cluster admins and
+	// vice versa
+	_, statusCode, _ = self.server.clusterAdminFromRequest(requestWithCertificate(c, "root"))
+	c.Assert(statusCode, Equals, libhttp.StatusUnauthorized)
+	_, statusCode, _ = self.server.dbUserFromRequest(requestWithCertificate(c, "dbuser", "influxdb-admins"), "foo")
+	c.Assert(statusCode, Equals, libhttp.StatusUnauthorized)
+
+	_, statusCode, _ = self.server.dbUserFromRequest(requestWithCertificate(c, "unknown"), "foo")
+	c.Assert(statusCode, Equals, libhttp.StatusUnauthorized)
+}
+
+func (self *ClientCertificateSuite) TestUnverifiedCertificatesAreIgnored(c *C) {
+	r := requestWithCertificate(c, "root", "influxdb-admins")
+	r.TLS.PeerCertificates = r.TLS.VerifiedChains[0]
+	r.TLS.VerifiedChains = nil
+	_, statusCode, message := self.server.clusterAdminFromRequest(r)
+	c.Assert(statusCode, Equals, libhttp.StatusUnauthorized)
+	c.Assert(message, Equals, INVALID_CREDENTIALS_MSG)
+}
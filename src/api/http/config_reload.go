@@ -0,0 +1,26 @@
+package http
+
+import (
+	. "common"
+	libhttp "net/http"
+)
+
+// Sets the function that reloads the configuration of the server, it's
+// called by POST /cluster/config/reload
+func (self *HttpServer) SetConfigReloader(reload func() error) {
+	self.reloadConfig = reload
+}
+
+func (self *HttpServer) reloadConfiguration(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if self.reloadConfig == nil {
+			return libhttp.StatusNotFound, "The configuration can't be reloaded"
+		}
+		err := self.reloadConfig()
+		self.audit(r, u.GetName(), "reload_config", "", "", err)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
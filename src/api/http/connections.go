@@ -0,0 +1,270 @@
+package http
+
+import (
+	. "common"
+	"fmt"
+	"net"
+	libhttp "net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// A connection to the api as it's returned by GET /connections. The
+// request fields are empty if the connection is idle.
+type ConnectionInfo struct {
+	Id         uint64 `json:"id"`
+	RemoteAddr string `json:"remoteAddr"`
+	OpenedAt   int64  `json:"openedAt"`
+	User       string `json:"user,omitempty"`
+	Method     string `json:"method,omitempty"`
+	Endpoint   string `json:"endpoint,omitempty"`
+	// how long the current request has been running in milliseconds
+	Duration int64 `json:"duration"`
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker  *ConnectionTracker
+	id       uint64
+	openedAt time.Time
+	// the request that's being served, protected by the tracker's lock
+	user      string
+	method    string
+	endpoint  string
+	startedAt time.Time
+}
+
+func (self *trackedConn) Close() error {
+	self.tracker.remove(self)
+	return self.Conn.Close()
+}
+
+type trackingListener struct {
+	net.Listener
+	tracker *ConnectionTracker
+}
+
+func (self *trackingListener) Accept() (net.Conn, error) {
+	conn, err := self.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return self.tracker.add(conn), nil
+}
+
+// Keeps track of the open connections to the api and the request each
+// one of them is serving, so the cluster admins can see what's running
+// and close the connections of a misbehaving client. Connections are
+// identified by their remote address while they're serving requests.
+type ConnectionTracker struct {
+	lock        sync.Mutex
+	nextId      uint64
+	connections map[string]*trackedConn
+	// the requests that are being served
+	requests int
+	// set once the server is shutting down, the new requests are
+	// rejected
+	draining bool
+}
+
+func NewConnectionTracker() *ConnectionTracker {
+	return &ConnectionTracker{connections: make(map[string]*trackedConn)}
+}
+
+// Returns a listener that tracks the connections it accepts. Tls
+// listeners have to wrap the returned listener, net/http only fills in
+// the tls state of the request if it's given the *tls.Conn.
+func (self *ConnectionTracker) Listener(listener net.Listener) net.Listener {
+	return &trackingListener{listener, self}
+}
+
+func (self *ConnectionTracker) add(conn net.Conn) *trackedConn {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.nextId++
+	tracked := &trackedConn{
+		Conn:     conn,
+		tracker:  self,
+		id:       self.nextId,
+		openedAt: time.Now(),
+	}
+	self.connections[conn.RemoteAddr().String()] = tracked
+	return tracked
+}
+
+func (self *ConnectionTracker) remove(conn *trackedConn) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	key := conn.RemoteAddr().String()
+	if self.connections[key] == conn {
+		delete(self.connections, key)
+	}
+}
+
+// Records the method and endpoint of the request while it's served.
+// The requests that arrive on open connections while the server is
+// shutting down are rejected.
+func (self *ConnectionTracker) Handler(handler libhttp.Handler) libhttp.Handler {
+	return libhttp.HandlerFunc(func(w libhttp.ResponseWriter, r *libhttp.Request) {
+		self.lock.Lock()
+		if self.draining {
+			self.lock.Unlock()
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(libhttp.StatusServiceUnavailable)
+			w.Write([]byte("The server is shutting down"))
+			return
+		}
+		self.requests++
+		conn := self.connections[r.RemoteAddr]
+		if conn != nil {
+			conn.method = r.Method
+			conn.endpoint = r.URL.Path
+			conn.startedAt = time.Now()
+		}
+		self.lock.Unlock()
+
+		defer func() {
+			self.lock.Lock()
+			defer self.lock.Unlock()
+			self.requests--
+			if conn == nil {
+				return
+			}
+			conn.user = ""
+			conn.method = ""
+			conn.endpoint = ""
+		}()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Rejects the new requests and waits for the ones that are being served
+// to finish. Returns false if they didn't finish within the timeout.
+func (self *ConnectionTracker) Drain(timeout time.Duration) bool {
+	self.lock.Lock()
+	self.draining = true
+	self.lock.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		self.lock.Lock()
+		requests := self.requests
+		self.lock.Unlock()
+		if requests == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			log.Warn("%d requests didn't finish before the shutdown", requests)
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Records the user that made the request once it's authenticated
+func (self *ConnectionTracker) SetUser(r *libhttp.Request, name string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if conn := self.connections[r.RemoteAddr]; conn != nil {
+		conn.user = name
+	}
+}
+
+// Returns the user that made the request or an empty string if it
+// wasn't authenticated yet
+func (self *ConnectionTracker) User(r *libhttp.Request) string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if conn := self.connections[r.RemoteAddr]; conn != nil {
+		return conn.user
+	}
+	return ""
+}
+
+// Resets the read deadline of the connection that's serving the
+// request, it's used by requests that keep reading their body
+func (self *ConnectionTracker) ExtendReadDeadline(r *libhttp.Request, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	self.lock.Lock()
+	conn := self.connections[r.RemoteAddr]
+	self.lock.Unlock()
+	if conn != nil {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+}
+
+type connectionsById []*ConnectionInfo
+
+func (self connectionsById) Len() int           { return len(self) }
+func (self connectionsById) Less(i, j int) bool { return self[i].Id < self[j].Id }
+func (self connectionsById) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+func (self *ConnectionTracker) List() []*ConnectionInfo {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	now := time.Now()
+	connections := make([]*ConnectionInfo, 0, len(self.connections))
+	for addr, conn := range self.connections {
+		info := &ConnectionInfo{
+			Id:         conn.id,
+			RemoteAddr: addr,
+			OpenedAt:   conn.openedAt.Unix(),
+			User:       conn.user,
+			Method:     conn.method,
+			Endpoint:   conn.endpoint,
+		}
+		if conn.endpoint != "" {
+			info.Duration = int64(now.Sub(conn.startedAt) / time.Millisecond)
+		}
+		connections = append(connections, info)
+	}
+	sort.Sort(connectionsById(connections))
+	return connections
+}
+
+// Closes the connection with the given id, the request it's serving
+// won't be able to write its response
+func (self *ConnectionTracker) Close(id uint64) error {
+	self.lock.Lock()
+	var conn *trackedConn
+	for _, c := range self.connections {
+		if c.id == id {
+			conn = c
+			break
+		}
+	}
+	self.lock.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("Connection %d doesn't exist", id)
+	}
+	return conn.Close()
+}
+
+func (self *HttpServer) listConnections(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		return libhttp.StatusOK, self.connections.List()
+	})
+}
+
+func (self *HttpServer) closeConnection(w libhttp.ResponseWriter, r *libhttp.Request) {
+	id, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if err := self.connections.Close(id); err != nil {
+			return libhttp.StatusNotFound, err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
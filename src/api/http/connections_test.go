@@ -0,0 +1,40 @@
+package http
+
+import (
+	"io/ioutil"
+	"net"
+
+	. "launchpad.net/gocheck"
+)
+
+type ConnectionTrackerSuite struct{}
+
+var _ = Suite(&ConnectionTrackerSuite{})
+
+func (self *ConnectionTrackerSuite) TestCloseConnection(c *C) {
+	tracker := NewConnectionTracker()
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	listener = tracker.Listener(listener)
+	defer listener.Close()
+
+	client, err := net.Dial("tcp4", listener.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Close()
+	_, err = listener.Accept()
+	c.Assert(err, IsNil)
+
+	connections := tracker.List()
+	c.Assert(connections, HasLen, 1)
+	c.Assert(connections[0].RemoteAddr, Equals, client.LocalAddr().String())
+	c.Assert(connections[0].Endpoint, Equals, "")
+
+	c.Assert(tracker.Close(connections[0].Id+1), NotNil)
+	c.Assert(tracker.Close(connections[0].Id), IsNil)
+	c.Assert(tracker.List(), HasLen, 0)
+
+	// the client sees the connection closed
+	data, err := ioutil.ReadAll(client)
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 0)
+}
@@ -2,18 +2,111 @@ package http
 
 import (
 	libhttp "net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func CorsHeaderHandler(handler libhttp.HandlerFunc) libhttp.HandlerFunc {
+// The cross origin policy of the http api. The policy is evaluated for
+// every request that has an Origin header, the CORS headers are only
+// added if the origin is allowed. An origin of * allows any origin, the
+// credentials are only allowed for the origins that are listed.
+type CorsPolicy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// allows any origin, which is how the api behaved before the policy
+// could be configured
+func DefaultCorsPolicy() *CorsPolicy {
+	return &CorsPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders: []string{"Origin", "X-Requested-With", "Content-Type", "Accept"},
+		MaxAge:         30 * 24 * time.Hour,
+	}
+}
+
+func (self *CorsPolicy) isOriginAllowed(origin string) bool {
+	return self.isOriginListed(origin) || self.allowsAnyOrigin()
+}
+
+func (self *CorsPolicy) isOriginListed(origin string) bool {
+	for _, allowed := range self.AllowedOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *CorsPolicy) allowsAnyOrigin() bool {
+	for _, allowed := range self.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *CorsPolicy) isMethodAllowed(method string) bool {
+	for _, allowed := range self.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// adds the headers that every response to an allowed origin gets,
+// returns false if the origin isn't allowed
+func (self *CorsPolicy) addOriginHeaders(rw libhttp.ResponseWriter, req *libhttp.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" || !self.isOriginAllowed(origin) {
+		return false
+	}
+
+	header := rw.Header()
+	// credentials are only allowed for the origins that are listed, any
+	// other site could read the responses to its users' requests
+	// otherwise
+	if !self.isOriginListed(origin) {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return true
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Add("Vary", "Origin")
+	if self.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+func (self *CorsPolicy) Handler(handler libhttp.HandlerFunc) libhttp.HandlerFunc {
 	return func(rw libhttp.ResponseWriter, req *libhttp.Request) {
-		rw.Header().Add("Access-Control-Allow-Origin", "*")
-		rw.Header().Add("Access-Control-Max-Age", "2592000")
-		rw.Header().Add("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
-		rw.Header().Add("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept")
+		self.addOriginHeaders(rw, req)
 		handler(rw, req)
 	}
 }
 
-func CorsAndCompressionHeaderHandler(handler libhttp.HandlerFunc) libhttp.HandlerFunc {
-	return CorsHeaderHandler(CompressionHandler(true, handler))
+// Responds to preflight requests, the allowed methods and headers are
+// only sent if the origin and the requested method are allowed
+func (self *CorsPolicy) PreflightHandler(rw libhttp.ResponseWriter, req *libhttp.Request) {
+	method := req.Header.Get("Access-Control-Request-Method")
+	if (method == "" || self.isMethodAllowed(method)) && self.addOriginHeaders(rw, req) {
+		header := rw.Header()
+		header.Set("Access-Control-Allow-Methods", strings.Join(self.AllowedMethods, ", "))
+		header.Set("Access-Control-Allow-Headers", strings.Join(self.AllowedHeaders, ", "))
+		if self.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(self.MaxAge/time.Second)))
+		}
+	}
+	rw.WriteHeader(libhttp.StatusOK)
+}
+
+func (self *CorsPolicy) CompressionHandler(handler libhttp.HandlerFunc) libhttp.HandlerFunc {
+	return self.Handler(CompressionHandler(true, handler))
 }
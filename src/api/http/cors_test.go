@@ -0,0 +1,81 @@
+package http
+
+import (
+	. "launchpad.net/gocheck"
+	libhttp "net/http"
+	"net/http/httptest"
+	"time"
+)
+
+type CorsSuite struct{}
+
+var _ = Suite(&CorsSuite{})
+
+func preflightRequest(c *C, origin, method string) *libhttp.Request {
+	req, err := libhttp.NewRequest("OPTIONS", "/db/foo/series", nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	return req
+}
+
+func (self *CorsSuite) TestDefaultPolicyAllowsAnyOrigin(c *C) {
+	rw := httptest.NewRecorder()
+	DefaultCorsPolicy().PreflightHandler(rw, preflightRequest(c, "http://example.com", "GET"))
+	c.Assert(rw.Code, Equals, libhttp.StatusOK)
+	c.Assert(rw.Header().Get("Access-Control-Allow-Origin"), Equals, "*")
+	c.Assert(rw.Header().Get("Access-Control-Allow-Methods"), Equals, "GET, POST, PUT, DELETE")
+	c.Assert(rw.Header().Get("Access-Control-Max-Age"), Equals, "2592000")
+	c.Assert(rw.Header().Get("Access-Control-Allow-Credentials"), Equals, "")
+}
+
+func (self *CorsSuite) TestPolicyIsEvaluatedPerRequest(c *C) {
+	policy := &CorsPolicy{
+		AllowedOrigins:   []string{"http://dashboard.example.com"},
+		AllowedMethods:   []string{"GET"},
+		AllowedHeaders:   []string{"Authorization"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}
+
+	rw := httptest.NewRecorder()
+	policy.PreflightHandler(rw, preflightRequest(c, "http://dashboard.example.com", "GET"))
+	c.Assert(rw.Header().Get("Access-Control-Allow-Origin"), Equals, "http://dashboard.example.com")
+	c.Assert(rw.Header().Get("Access-Control-Allow-Credentials"), Equals, "true")
+	c.Assert(rw.Header().Get("Access-Control-Allow-Headers"), Equals, "Authorization")
+	c.Assert(rw.Header().Get("Access-Control-Max-Age"), Equals, "3600")
+	c.Assert(rw.Header().Get("Vary"), Equals, "Origin")
+
+	// the method isn't allowed
+	rw = httptest.NewRecorder()
+	policy.PreflightHandler(rw, preflightRequest(c, "http://dashboard.example.com", "DELETE"))
+	c.Assert(rw.Header().Get("Access-Control-Allow-Origin"), Equals, "")
+
+	// neither is the origin
+	rw = httptest.NewRecorder()
+	req, err := libhttp.NewRequest("GET", "/db/foo/series", nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Origin", "http://evil.example.com")
+	called := false
+	policy.Handler(func(libhttp.ResponseWriter, *libhttp.Request) { called = true })(rw, req)
+	c.Assert(called, Equals, true)
+	c.Assert(rw.Header().Get("Access-Control-Allow-Origin"), Equals, "")
+}
+
+func (self *CorsSuite) TestCredentialsArentAllowedForAnyOrigin(c *C) {
+	policy := &CorsPolicy{
+		AllowedOrigins:   []string{"http://dashboard.example.com", "*"},
+		AllowedMethods:   []string{"GET"},
+		AllowCredentials: true,
+	}
+
+	rw := httptest.NewRecorder()
+	policy.PreflightHandler(rw, preflightRequest(c, "http://evil.example.com", "GET"))
+	c.Assert(rw.Header().Get("Access-Control-Allow-Origin"), Equals, "*")
+	c.Assert(rw.Header().Get("Access-Control-Allow-Credentials"), Equals, "")
+
+	rw = httptest.NewRecorder()
+	policy.PreflightHandler(rw, preflightRequest(c, "http://dashboard.example.com", "GET"))
+	c.Assert(rw.Header().Get("Access-Control-Allow-Origin"), Equals, "http://dashboard.example.com")
+	c.Assert(rw.Header().Get("Access-Control-Allow-Credentials"), Equals, "true")
+}
@@ -0,0 +1,139 @@
+package http
+
+import (
+	"bytes"
+	. "common"
+	"encoding/csv"
+	"fmt"
+	libhttp "net/http"
+	"protocol"
+	"strconv"
+)
+
+// Encodes every series as a header row followed by one row per point.
+// The first column is the name of the series so the rows of different
+// series can be told apart.
+func marshalCsv(v interface{}) ([]byte, error) {
+	var seriesList []*SerializedSeries
+	switch x := v.(type) {
+	case *SerializedSeries:
+		seriesList = []*SerializedSeries{x}
+	case []*SerializedSeries:
+		seriesList = x
+	default:
+		return nil, fmt.Errorf("Cannot encode %T to csv", v)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	writer := csv.NewWriter(buffer)
+	for _, series := range seriesList {
+		if err := writeCsvHeader(writer, series); err != nil {
+			return nil, err
+		}
+		if err := writeCsvRows(writer, series); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+func writeCsvHeader(writer *csv.Writer, series *SerializedSeries) error {
+	return writer.Write(append([]string{"name"}, series.Columns...))
+}
+
+func writeCsvRows(writer *csv.Writer, series *SerializedSeries) error {
+	for _, point := range series.Points {
+		row := make([]string, 0, len(point)+1)
+		row = append(row, series.Name)
+		for _, value := range point {
+			row = append(row, csvValue(value))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Streams the rows of the query as the chunks of the series arrive. The
+// header of a series is only written before its first chunk, it's
+// repeated only if the rows of another series or different columns were
+// written in between.
+type CsvWriter struct {
+	w           libhttp.ResponseWriter
+	writer      *csv.Writer
+	precision   TimePrecision
+	lastName    string
+	lastColumns []string
+	wroteHeader bool
+}
+
+func NewCsvWriter(w libhttp.ResponseWriter, precision TimePrecision) *CsvWriter {
+	return &CsvWriter{w: w, writer: csv.NewWriter(w), precision: precision}
+}
+
+func (self *CsvWriter) writeResponseHeader() {
+	if self.wroteHeader {
+		return
+	}
+	self.wroteHeader = true
+	self.w.Header().Add("content-type", csvFormat.contentType)
+	self.w.WriteHeader(libhttp.StatusOK)
+}
+
+func (self *CsvWriter) yield(series *protocol.Series) error {
+	serialized := SerializeSeries(map[string]*protocol.Series{"": series}, self.precision)[0]
+	if len(serialized.Points) == 0 && serialized.Name == self.lastName {
+		return nil
+	}
+	self.writeResponseHeader()
+	if serialized.Name != self.lastName || !equalColumns(serialized.Columns, self.lastColumns) {
+		if err := writeCsvHeader(self.writer, serialized); err != nil {
+			return err
+		}
+		self.lastName = serialized.Name
+		self.lastColumns = serialized.Columns
+	}
+	if err := writeCsvRows(self.writer, serialized); err != nil {
+		return err
+	}
+	self.writer.Flush()
+	if err := self.writer.Error(); err != nil {
+		return err
+	}
+	if flusher, ok := self.w.(libhttp.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (self *CsvWriter) done() {
+	// an empty result still gets the content type
+	self.writeResponseHeader()
+}
+
+func equalColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nulls are empty fields
+func csvValue(value interface{}) string {
+	switch x := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,394 @@
+package http
+
+import (
+	. "common"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	libhttp "net/http"
+	"protocol"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "code.google.com/p/log4go"
+)
+
+const (
+	CSV_TYPE_AUTO   = "auto"
+	CSV_TYPE_STRING = "string"
+	CSV_TYPE_INT    = "int"
+	CSV_TYPE_FLOAT  = "float"
+	CSV_TYPE_BOOL   = "bool"
+	// the column isn't written
+	CSV_TYPE_SKIP = "skip"
+
+	// the times are numbers in the precision of the import
+	CSV_TIME_EPOCH   = "epoch"
+	CSV_TIME_RFC3339 = "rfc3339"
+)
+
+// How the rows of a csv file are written. Every row is a point of the
+// series given by name or by the value of the name column, the other
+// columns are its values with the type the mapping gives them, the
+// types of the columns that aren't mapped are guessed from every value.
+type csvMapping struct {
+	name       string
+	nameColumn string
+	timeColumn string
+	// epoch, rfc3339 or a layout of time.Parse
+	timeFormat string
+	precision  TimePrecision
+	types      map[string]string
+	// the names of the columns if the file doesn't have a header
+	columns   []string
+	delimiter rune
+
+	// set once the columns are known
+	nameIndex    int
+	timeIndex    int
+	fields       []string
+	fieldIndexes []int
+	fieldTypes   []string
+}
+
+// Reads the mapping from the parameters of an import with format=csv:
+// name or name_column, time_column, time_format, types, e.g.
+// value:float,host:string,comment:skip, columns if the file doesn't have
+// a header and delimiter, e.g. ; or tab
+func csvMappingFromRequest(r *libhttp.Request, precision TimePrecision) (*csvMapping, error) {
+	params := r.URL.Query()
+	mapping := &csvMapping{
+		name:       params.Get("name"),
+		nameColumn: params.Get("name_column"),
+		timeColumn: params.Get("time_column"),
+		timeFormat: params.Get("time_format"),
+		precision:  precision,
+		types:      map[string]string{},
+		delimiter:  ',',
+	}
+	if (mapping.name == "") == (mapping.nameColumn == "") {
+		return nil, fmt.Errorf("Either name or name_column must be set")
+	}
+	if mapping.name != "" && !VALID_TABLE_NAMES.MatchString(mapping.name) {
+		return nil, fmt.Errorf("%s is not a valid series name", mapping.name)
+	}
+	if mapping.timeFormat == "" {
+		mapping.timeFormat = CSV_TIME_EPOCH
+	}
+	if types := params.Get("types"); types != "" {
+		for _, columnType := range strings.Split(types, ",") {
+			parts := strings.SplitN(columnType, ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("Invalid column type %s, it should be column:type", columnType)
+			}
+			switch parts[1] {
+			case CSV_TYPE_AUTO, CSV_TYPE_STRING, CSV_TYPE_INT, CSV_TYPE_FLOAT, CSV_TYPE_BOOL, CSV_TYPE_SKIP:
+				mapping.types[parts[0]] = parts[1]
+			default:
+				return nil, fmt.Errorf("Unknown type %s of column %s", parts[1], parts[0])
+			}
+		}
+	}
+	if columns := params.Get("columns"); columns != "" {
+		mapping.columns = strings.Split(columns, ",")
+	}
+	switch delimiter := params.Get("delimiter"); delimiter {
+	case "":
+	case "tab":
+		mapping.delimiter = '\t'
+	default:
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\n' {
+			return nil, fmt.Errorf("Invalid delimiter %s", delimiter)
+		}
+		mapping.delimiter = r
+	}
+	return mapping, nil
+}
+
+// Looks up the mapped columns in the columns of the file, a column named
+// time is the time column unless another one is mapped
+func (self *csvMapping) setColumns(columns []string) error {
+	self.nameIndex, self.timeIndex = -1, -1
+	self.fields, self.fieldIndexes, self.fieldTypes = nil, nil, nil
+	seen := map[string]bool{}
+	for i, column := range columns {
+		column = strings.TrimSpace(column)
+		if seen[column] {
+			return fmt.Errorf("Column %s appears more than once", column)
+		}
+		seen[column] = true
+		switch {
+		case self.nameColumn != "" && column == self.nameColumn:
+			self.nameIndex = i
+		case column == self.timeColumn || (self.timeColumn == "" && column == "time"):
+			self.timeIndex = i
+		case self.types[column] == CSV_TYPE_SKIP:
+		default:
+			columnType := self.types[column]
+			if columnType == "" {
+				columnType = CSV_TYPE_AUTO
+			}
+			self.fields = append(self.fields, column)
+			self.fieldIndexes = append(self.fieldIndexes, i)
+			self.fieldTypes = append(self.fieldTypes, columnType)
+		}
+	}
+	if self.nameColumn != "" && self.nameIndex == -1 {
+		return fmt.Errorf("The name column %s doesn't exist", self.nameColumn)
+	}
+	if self.timeColumn != "" && self.timeIndex == -1 {
+		return fmt.Errorf("The time column %s doesn't exist", self.timeColumn)
+	}
+	for column := range self.types {
+		if !seen[column] {
+			return fmt.Errorf("Column %s of the types doesn't exist", column)
+		}
+	}
+	if len(self.fields) == 0 {
+		return fmt.Errorf("There are no columns to write")
+	}
+	return nil
+}
+
+// Returns the name of the series of the row and its point, the points
+// without a time column get the time of the server
+func (self *csvMapping) convert(row []string) (string, *protocol.Point, error) {
+	name := self.name
+	if self.nameIndex != -1 {
+		name = row[self.nameIndex]
+		if !VALID_TABLE_NAMES.MatchString(name) {
+			return "", nil, fmt.Errorf("%s is not a valid series name", name)
+		}
+	}
+	point := &protocol.Point{Values: make([]*protocol.FieldValue, 0, len(self.fields))}
+	if self.timeIndex != -1 {
+		timestamp, err := self.parseTime(row[self.timeIndex])
+		if err != nil {
+			return "", nil, err
+		}
+		point.Timestamp = &timestamp
+	}
+	for i, index := range self.fieldIndexes {
+		value, err := csvFieldValue(row[index], self.fieldTypes[i])
+		if err != nil {
+			return "", nil, fmt.Errorf("Invalid value of %s: %s", self.fields[i], err)
+		}
+		point.Values = append(point.Values, value)
+	}
+	return name, point, nil
+}
+
+// Returns the time in microseconds
+func (self *csvMapping) parseTime(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("The time is empty")
+	}
+	var t time.Time
+	var err error
+	switch self.timeFormat {
+	case CSV_TIME_EPOCH:
+		epoch, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid time %s", value)
+		}
+		switch self.precision {
+		case SecondPrecision:
+			epoch *= 1000
+			fallthrough
+		case MillisecondPrecision:
+			epoch *= 1000
+		}
+		return int64(epoch), nil
+	case CSV_TIME_RFC3339:
+		t, err = time.Parse(time.RFC3339Nano, value)
+	default:
+		t, err = time.Parse(self.timeFormat, value)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time %s: %s", value, err)
+	}
+	return t.UnixNano() / int64(time.Microsecond), nil
+}
+
+// Empty fields are nulls, the values of the columns without a type are
+// integers, floats, booleans or strings, whatever they parse as first
+func csvFieldValue(value string, columnType string) (*protocol.FieldValue, error) {
+	if value == "" {
+		return &protocol.FieldValue{IsNull: &TRUE}, nil
+	}
+	switch columnType {
+	case CSV_TYPE_STRING:
+		return &protocol.FieldValue{StringValue: &value}, nil
+	case CSV_TYPE_INT:
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &protocol.FieldValue{Int64Value: &i}, nil
+	case CSV_TYPE_FLOAT:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, err
+		}
+		return &protocol.FieldValue{DoubleValue: &f}, nil
+	case CSV_TYPE_BOOL:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		return &protocol.FieldValue{BoolValue: &b}, nil
+	}
+	trimmed := strings.TrimSpace(value)
+	if i, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+		return &protocol.FieldValue{Int64Value: &i}, nil
+	}
+	if f, err := strconv.ParseFloat(trimmed, 64); err == nil {
+		return &protocol.FieldValue{DoubleValue: &f}, nil
+	}
+	if trimmed == "true" || trimmed == "false" {
+		b := trimmed == "true"
+		return &protocol.FieldValue{BoolValue: &b}, nil
+	}
+	return &protocol.FieldValue{StringValue: &value}, nil
+}
+
+// Accumulates the points of the rows into one series per name
+type csvBatch struct {
+	series   []*protocol.Series
+	byName   map[string]*protocol.Series
+	points   int
+	firstRow int
+	lastRow  int
+}
+
+func newCsvBatch() *csvBatch {
+	return &csvBatch{byName: map[string]*protocol.Series{}}
+}
+
+func (self *csvBatch) add(row int, name string, fields []string, point *protocol.Point) {
+	if self.firstRow == 0 {
+		self.firstRow = row
+	}
+	self.lastRow = row
+	series := self.byName[name]
+	if series == nil {
+		series = &protocol.Series{Name: protocol.String(name), Fields: fields}
+		self.byName[name] = series
+		self.series = append(self.series, series)
+	}
+	series.Points = append(series.Points, point)
+	self.points++
+}
+
+// Imports a csv file like a dump, the rows are written in batches and
+// the progress of every batch is reported with the rows it had. The
+// rows are numbered from 1, the header is the first row.
+func (self *HttpServer) streamCsvImport(w libhttp.ResponseWriter, r *libhttp.Request, user User, db string, mapping *csvMapping, batchSize int) {
+	encoder := json.NewEncoder(w)
+	summary := &importProgress{Done: true}
+	batch := newCsvBatch()
+	batchNumber := 0
+
+	reader := csv.NewReader(r.Body)
+	reader.Comma = mapping.delimiter
+	reader.TrimLeadingSpace = true
+
+	report := func(progress *importProgress) bool {
+		if err := encoder.Encode(progress); err != nil {
+			log.Debug("Cannot report the progress of the import to %s: %s", db, err)
+			return false
+		}
+		w.(libhttp.Flusher).Flush()
+		return true
+	}
+
+	flush := func() bool {
+		if batch.points == 0 {
+			batch = newCsvBatch()
+			return true
+		}
+		batchNumber++
+		progress := &importProgress{
+			Batch:     batchNumber,
+			FirstLine: batch.firstRow,
+			LastLine:  batch.lastRow,
+		}
+		progress.Status, progress.Points, progress.Error = self.writeImportSeries(w, user, db, batch.series)
+		if progress.Error != "" {
+			summary.Failed++
+		}
+		summary.Points += progress.Points
+		batch = newCsvBatch()
+		self.connections.ExtendReadDeadline(r, self.readTimeout)
+		return report(progress)
+	}
+
+	row := 0
+	columns := mapping.columns
+	if columns == nil {
+		header, err := reader.Read()
+		row++
+		if err == io.EOF {
+			report(summary)
+			return
+		}
+		if err == nil {
+			columns = header
+		} else {
+			summary.Failed++
+			report(&importProgress{FirstLine: row, LastLine: row, Status: libhttp.StatusBadRequest, Error: err.Error()})
+			report(summary)
+			return
+		}
+	}
+	if err := mapping.setColumns(columns); err != nil {
+		summary.Failed++
+		report(&importProgress{FirstLine: 1, LastLine: 1, Status: libhttp.StatusBadRequest, Error: err.Error()})
+		report(summary)
+		return
+	}
+	reader.FieldsPerRecord = len(columns)
+
+	for {
+		record, err := reader.Read()
+		row++
+		if err == io.EOF {
+			break
+		}
+		var name string
+		var point *protocol.Point
+		if err == nil {
+			name, point, err = mapping.convert(record)
+		} else if _, ok := err.(*csv.ParseError); !ok {
+			log.Debug("Csv import to %s stopped: %s", db, err)
+			return
+		}
+		if err != nil {
+			// the batch is written first so the rows are reported in order
+			if !flush() {
+				return
+			}
+			summary.Failed++
+			if !report(&importProgress{FirstLine: row, LastLine: row, Status: libhttp.StatusBadRequest, Error: err.Error()}) {
+				return
+			}
+			continue
+		}
+
+		batch.add(row, name, mapping.fields, point)
+		if batch.points >= batchSize && !flush() {
+			return
+		}
+	}
+
+	if !flush() {
+		return
+	}
+	log.Info("Imported %d points from csv into %s, %d batches failed", summary.Points, db, summary.Failed)
+	report(summary)
+}
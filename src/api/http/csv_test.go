@@ -0,0 +1,84 @@
+package http
+
+import (
+	. "common"
+	. "launchpad.net/gocheck"
+)
+
+type CsvSuite struct{}
+
+var _ = Suite(&CsvSuite{})
+
+func (self *CsvSuite) TestEncodingSeries(c *C) {
+	series := []*SerializedSeries{
+		&SerializedSeries{
+			Name:    "foo",
+			Columns: []string{"time", "value"},
+			Points:  [][]interface{}{{int64(1), 1.5}, {int64(2), nil}},
+		},
+		&SerializedSeries{
+			Name:    "bar",
+			Columns: []string{"time", "host"},
+			Points:  [][]interface{}{{int64(3), "a,b"}},
+		},
+	}
+	data, err := marshalCsv(series)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "name,time,value\nfoo,1,1.5\nfoo,2,\nname,time,host\nbar,3,\"a,b\"\n")
+}
+
+func (self *CsvSuite) TestCsvFieldValues(c *C) {
+	value, err := csvFieldValue("1", CSV_TYPE_FLOAT)
+	c.Assert(err, IsNil)
+	c.Assert(value.DoubleValue, NotNil)
+	c.Assert(value.GetDoubleValue(), Equals, 1.0)
+
+	value, err = csvFieldValue("1", CSV_TYPE_AUTO)
+	c.Assert(err, IsNil)
+	c.Assert(value.GetInt64Value(), Equals, int64(1))
+	value, err = csvFieldValue("true", CSV_TYPE_AUTO)
+	c.Assert(err, IsNil)
+	c.Assert(value.GetBoolValue(), Equals, true)
+	value, err = csvFieldValue("1", CSV_TYPE_STRING)
+	c.Assert(err, IsNil)
+	c.Assert(value.GetStringValue(), Equals, "1")
+	value, err = csvFieldValue("", CSV_TYPE_INT)
+	c.Assert(err, IsNil)
+	c.Assert(value.GetIsNull(), Equals, true)
+
+	_, err = csvFieldValue("1.5", CSV_TYPE_INT)
+	c.Assert(err, NotNil)
+}
+
+func (self *CsvSuite) TestCsvTimes(c *C) {
+	mapping := &csvMapping{timeFormat: CSV_TIME_EPOCH, precision: MillisecondPrecision}
+	t, err := mapping.parseTime("1382131686123")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, int64(1382131686123000))
+
+	mapping.timeFormat = CSV_TIME_RFC3339
+	t, err = mapping.parseTime("2013-10-18T21:28:06Z")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, int64(1382131686000000))
+
+	mapping.timeFormat = "02/01/2006 15:04:05"
+	t, err = mapping.parseTime("18/10/2013 21:28:06")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, int64(1382131686000000))
+
+	_, err = mapping.parseTime("")
+	c.Assert(err, NotNil)
+}
+
+func (self *CsvSuite) TestCsvColumns(c *C) {
+	mapping := &csvMapping{name: "foo", types: map[string]string{"comment": CSV_TYPE_SKIP}}
+	c.Assert(mapping.setColumns([]string{"time", "value", "comment"}), IsNil)
+	c.Assert(mapping.timeIndex, Equals, 0)
+	c.Assert(mapping.fields, DeepEquals, []string{"value"})
+
+	mapping.types["missing"] = CSV_TYPE_INT
+	c.Assert(mapping.setColumns([]string{"time", "value", "comment"}), ErrorMatches, ".*missing.*")
+	delete(mapping.types, "missing")
+	mapping.timeColumn = "timestamp"
+	c.Assert(mapping.setColumns([]string{"time", "value", "comment"}), ErrorMatches, ".*timestamp.*")
+}
@@ -0,0 +1,99 @@
+package http
+
+import (
+	"cluster"
+	. "common"
+	libhttp "net/http"
+	"protocol"
+	"strconv"
+)
+
+// Serves the dead letters of the local shards of this server, every
+// replica keeps the points it couldn't write
+func (self *HttpServer) SetDeadLetterQueue(queue *cluster.DeadLetterQueue) {
+	self.deadLetters = queue
+}
+
+// Calls yield with the dead letter in the url, the responses are the
+// same as the ones of tryAsClusterAdmin
+func (self *HttpServer) tryDeadLetter(w libhttp.ResponseWriter, r *libhttp.Request, yield func(User, string, *cluster.DeadLetter) (int, interface{})) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if self.deadLetters == nil {
+			return libhttp.StatusNotFound, "The dead letters aren't available on this server"
+		}
+		db := r.URL.Query().Get(":db")
+		id, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+		if err != nil {
+			return libhttp.StatusBadRequest, "Invalid dead letter id"
+		}
+		letter, err := self.deadLetters.Get(db, id)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		if letter == nil {
+			return libhttp.StatusNotFound, "Dead letter not found"
+		}
+		return yield(u, db, letter)
+	})
+}
+
+func (self *HttpServer) listDeadLetters(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if self.deadLetters == nil {
+			return libhttp.StatusNotFound, "The dead letters aren't available on this server"
+		}
+		letters, err := self.deadLetters.List(r.URL.Query().Get(":db"))
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		return libhttp.StatusOK, letters
+	})
+}
+
+func (self *HttpServer) getDeadLetter(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryDeadLetter(w, r, func(u User, db string, letter *cluster.DeadLetter) (int, interface{}) {
+		return libhttp.StatusOK, letter
+	})
+}
+
+// Writes the points of the dead letter again and removes it if the
+// write succeeds
+func (self *HttpServer) retryDeadLetter(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryDeadLetter(w, r, func(u User, db string, letter *cluster.DeadLetter) (int, interface{}) {
+		err := self.coordinator.WriteSeriesData(u, db, []*protocol.Series{letter.Series})
+		self.audit(r, u.GetName(), "retry_dead_letter", db, strconv.FormatUint(letter.Id, 10), err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		if err := self.deadLetters.Remove(db, letter.Id); err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
+
+func (self *HttpServer) deleteDeadLetter(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryDeadLetter(w, r, func(u User, db string, letter *cluster.DeadLetter) (int, interface{}) {
+		err := self.deadLetters.Remove(db, letter.Id)
+		self.audit(r, u.GetName(), "delete_dead_letter", db, strconv.FormatUint(letter.Id, 10), err)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
+
+func (self *HttpServer) purgeDeadLetters(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if self.deadLetters == nil {
+			return libhttp.StatusNotFound, "The dead letters aren't available on this server"
+		}
+		db := r.URL.Query().Get(":db")
+		err := self.deadLetters.Purge(db)
+		self.audit(r, u.GetName(), "purge_dead_letters", db, "", err)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
@@ -0,0 +1,29 @@
+package http
+
+import (
+	"bytes"
+	. "common"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	libhttp "net/http"
+)
+
+// Returns the expvar variables as json, i.e. the memory stats of the
+// runtime and the counters of the influxdb map
+func (self *HttpServer) debugVars(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		buffer := bytes.NewBufferString("{")
+		first := true
+		expvar.Do(func(kv expvar.KeyValue) {
+			if !first {
+				buffer.WriteString(",")
+			}
+			first = false
+			fmt.Fprintf(buffer, "%q:%s", kv.Key, kv.Value)
+		})
+		buffer.WriteString("}")
+		vars := json.RawMessage(buffer.Bytes())
+		return libhttp.StatusOK, &vars
+	})
+}
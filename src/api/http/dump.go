@@ -0,0 +1,192 @@
+package http
+
+import (
+	"cluster"
+	. "common"
+	"coordinator"
+	"encoding/json"
+	"fmt"
+	libhttp "net/http"
+	"protocol"
+	"strconv"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// Slows a dump down to a number of points per second, so it can run
+// against a server that is serving the other clients
+type dumpThrottle struct {
+	pointsPerSecond int
+	start           time.Time
+	points          int64
+}
+
+func newDumpThrottle(pointsPerSecond int) *dumpThrottle {
+	return &dumpThrottle{pointsPerSecond: pointsPerSecond, start: time.Now()}
+}
+
+// Counts the points and sleeps until the rate is back under the limit
+func (self *dumpThrottle) wait(points int) {
+	self.points += int64(points)
+	if self.pointsPerSecond <= 0 {
+		return
+	}
+	expected := time.Duration(float64(self.points) / float64(self.pointsPerSecond) * float64(time.Second))
+	if delay := expected - time.Since(self.start); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Streams a dump of a database, or of all the databases and the
+// cluster admins if db isn't set. The dump runs as a job that can be
+// cancelled, a dump that fails or is cancelled stops before its end
+// record.
+func (self *HttpServer) dump(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get("db")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		pointsPerSecond := 0
+		if s := r.URL.Query().Get("points_per_second"); s != "" {
+			var err error
+			pointsPerSecond, err = strconv.Atoi(s)
+			if err != nil || pointsPerSecond < 0 {
+				return libhttp.StatusBadRequest, "points_per_second should be a positive number"
+			}
+		}
+
+		databases := []*cluster.Database{}
+		for _, database := range self.clusterConfig.GetDatabases() {
+			if db == "" || database.Name == db {
+				databases = append(databases, database)
+			}
+		}
+		if db != "" && len(databases) == 0 {
+			return libhttp.StatusNotFound, "Database " + db + " doesn't exist"
+		}
+
+		target := db
+		if target == "" {
+			target = "all"
+		}
+		job := self.clusterConfig.Jobs().Add("dump", target, true)
+		job.Start()
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(libhttp.StatusOK)
+		err := self.writeDump(w, u, databases, db == "", newDumpThrottle(pointsPerSecond), job)
+		if job.IsCancelled() {
+			err = nil
+		}
+		job.Finish(err)
+		if err != nil {
+			log.Error("Dump of %s failed: %s", target, err)
+		}
+		self.audit(r, u.GetName(), "dump", db, "", err)
+		return -1, nil
+	})
+}
+
+func (self *HttpServer) writeDump(w libhttp.ResponseWriter, u User, databases []*cluster.Database, withClusterAdmins bool, throttle *dumpThrottle, job *cluster.Job) error {
+	encoder := json.NewEncoder(w)
+	write := func(record *cluster.DumpRecord) error {
+		if job.IsCancelled() {
+			return fmt.Errorf("The dump was cancelled")
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		if flusher, ok := w.(libhttp.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	header := &cluster.DumpRecord{Type: cluster.DUMP_HEADER, Version: cluster.DUMP_FORMAT_VERSION, CreatedAt: time.Now().Unix()}
+	if err := write(header); err != nil {
+		return err
+	}
+	if withClusterAdmins {
+		for _, name := range self.clusterConfig.GetClusterAdmins() {
+			admin := self.clusterConfig.GetClusterAdmin(name)
+			if admin == nil {
+				continue
+			}
+			if err := write(&cluster.DumpRecord{Type: cluster.DUMP_CLUSTER_ADMIN, ClusterAdmin: admin}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, database := range databases {
+		records := []*cluster.DumpRecord{
+			&cluster.DumpRecord{Type: cluster.DUMP_DATABASE, Database: database.Name, ReplicationFactor: database.ReplicationFactor},
+		}
+		for _, user := range self.clusterConfig.GetDbUsers(database.Name) {
+			if dbUser, ok := user.(*cluster.DbUser); ok {
+				records = append(records, &cluster.DumpRecord{Type: cluster.DUMP_USER, Database: database.Name, User: dbUser})
+			}
+		}
+		for _, query := range self.clusterConfig.GetContinuousQueries(database.Name) {
+			records = append(records, &cluster.DumpRecord{Type: cluster.DUMP_CONTINUOUS_QUERY, Database: database.Name, Query: query.Query})
+		}
+		for _, record := range records {
+			if err := write(record); err != nil {
+				return err
+			}
+		}
+
+		yield := func(series *protocol.Series) error {
+			if len(series.Points) == 0 {
+				return nil
+			}
+			serialized := SerializeSeries(map[string]*protocol.Series{series.GetName(): series}, MicrosecondPrecision)
+			for _, s := range serialized {
+				if err := write(&cluster.DumpRecord{Type: cluster.DUMP_SERIES, Database: database.Name, Series: s}); err != nil {
+					return err
+				}
+			}
+			throttle.wait(len(series.Points))
+			return nil
+		}
+		if err := self.coordinator.RunQuery(u, database.Name, "select * from /.*/", NewSeriesWriter(yield)); err != nil {
+			return err
+		}
+		job.SetProgress(i+1, len(databases))
+	}
+	return write(&cluster.DumpRecord{Type: cluster.DUMP_END, Points: throttle.points})
+}
+
+// Restores a dump with the coordinator and reports the progress with a
+// line for every batch of points. A restore that was interrupted can be
+// resumed by passing the line of the last progress it reported as skip.
+func (self *HttpServer) restore(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		skip := 0
+		if s := r.URL.Query().Get("skip"); s != "" {
+			var err error
+			skip, err = strconv.Atoi(s)
+			if err != nil || skip < 0 {
+				return libhttp.StatusBadRequest, "skip should be a positive number"
+			}
+		}
+
+		w.Header().Add("content-type", "application/json")
+		w.WriteHeader(libhttp.StatusOK)
+		encoder := json.NewEncoder(w)
+		report := func(progress *coordinator.RestoreProgress) error {
+			self.connections.ExtendReadDeadline(r, self.readTimeout)
+			if err := encoder.Encode(progress); err != nil {
+				return err
+			}
+			w.(libhttp.Flusher).Flush()
+			return nil
+		}
+		err := self.coordinator.RestoreDump(u, cluster.NewDumpReader(r.Body), skip, report)
+		if err != nil {
+			log.Error("Restore failed: %s", err)
+			report(&coordinator.RestoreProgress{Error: err.Error()})
+		}
+		self.audit(r, u.GetName(), "restore", "", "", err)
+		return -1, nil
+	})
+}
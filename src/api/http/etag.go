@@ -0,0 +1,31 @@
+package http
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Returns the etag of a query response. The etag is weak because the
+// response may be compressed differently depending on the request.
+func queryETag(data []byte) string {
+	hash := fnv.New64a()
+	hash.Write(data)
+	return fmt.Sprintf(`W/"%x"`, hash.Sum64())
+}
+
+// Returns true if the value of an If-None-Match header matches the
+// etag, etags are compared with the weak comparison like the spec says
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
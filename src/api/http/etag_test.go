@@ -0,0 +1,22 @@
+package http
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type ETagSuite struct{}
+
+var _ = Suite(&ETagSuite{})
+
+func (self *ETagSuite) TestETagMatches(c *C) {
+	etag := queryETag([]byte(`[{"name":"foo"}]`))
+	c.Assert(etag, Equals, queryETag([]byte(`[{"name":"foo"}]`)))
+	c.Assert(etag, Not(Equals), queryETag([]byte(`[{"name":"bar"}]`)))
+
+	c.Assert(etagMatches("", etag), Equals, false)
+	c.Assert(etagMatches(etag, etag), Equals, true)
+	c.Assert(etagMatches(etag[2:], etag), Equals, true)
+	c.Assert(etagMatches(`"foo", `+etag, etag), Equals, true)
+	c.Assert(etagMatches("*", etag), Equals, true)
+	c.Assert(etagMatches(`"foo"`, etag), Equals, false)
+}
@@ -0,0 +1,532 @@
+package http
+
+import (
+	. "common"
+	"engine"
+	"fmt"
+	"math"
+	libhttp "net/http"
+	"protocol"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how far back /render goes if the request doesn't have a from
+const GRAPHITE_DEFAULT_RANGE = 24 * time.Hour
+
+// A series in the response of /render, every data point is a
+// [value, timestamp in seconds] pair
+type GraphiteRenderSeries struct {
+	Target     string           `json:"target"`
+	Datapoints [][2]interface{} `json:"datapoints"`
+}
+
+const (
+	graphitePath = iota
+	graphiteString
+	graphiteNumber
+	graphiteCall
+)
+
+// A parsed render target, raw is the text of the expression
+type graphiteExpr struct {
+	kind   int
+	raw    string
+	value  string
+	number float64
+	args   []*graphiteExpr
+}
+
+type graphiteTargetParser struct {
+	target   string
+	position int
+}
+
+func parseGraphiteTarget(target string) (*graphiteExpr, error) {
+	parser := &graphiteTargetParser{target: target}
+	expr, err := parser.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	parser.skipSpaces()
+	if parser.position != len(target) {
+		return nil, fmt.Errorf("Unexpected %q at %d in target %s", target[parser.position:], parser.position, target)
+	}
+	return expr, nil
+}
+
+func (self *graphiteTargetParser) skipSpaces() {
+	for self.position < len(self.target) && self.target[self.position] == ' ' {
+		self.position++
+	}
+}
+
+func (self *graphiteTargetParser) parseExpr() (*graphiteExpr, error) {
+	self.skipSpaces()
+	start := self.position
+	if start == len(self.target) {
+		return nil, fmt.Errorf("Unexpected end of target %s", self.target)
+	}
+
+	if quote := self.target[start]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(self.target[start+1:], quote)
+		if end < 0 {
+			return nil, fmt.Errorf("Unterminated string in target %s", self.target)
+		}
+		self.position = start + end + 2
+		return &graphiteExpr{kind: graphiteString, raw: self.target[start:self.position], value: self.target[start+1 : start+end+1]}, nil
+	}
+
+	// commas in braces are part of the path, e.g. servers.{a,b}.cpu
+	depth := 0
+	for ; self.position < len(self.target); self.position++ {
+		c := self.target[self.position]
+		if c == '{' {
+			depth++
+		} else if c == '}' {
+			depth--
+		} else if depth == 0 && (c == '(' || c == ')' || c == ',' || c == ' ') {
+			break
+		}
+	}
+	token := self.target[start:self.position]
+	if token == "" {
+		return nil, fmt.Errorf("Unexpected %q at %d in target %s", self.target[start:], start, self.target)
+	}
+
+	if self.position == len(self.target) || self.target[self.position] != '(' {
+		if number, err := strconv.ParseFloat(token, 64); err == nil && strings.IndexAny(token[:1], "-.0123456789") == 0 {
+			return &graphiteExpr{kind: graphiteNumber, raw: token, number: number}, nil
+		}
+		return &graphiteExpr{kind: graphitePath, raw: token, value: token}, nil
+	}
+
+	expr := &graphiteExpr{kind: graphiteCall, value: token}
+	self.position++
+	for {
+		self.skipSpaces()
+		if self.position < len(self.target) && self.target[self.position] == ')' && len(expr.args) == 0 {
+			self.position++
+			break
+		}
+		arg, err := self.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.args = append(expr.args, arg)
+		self.skipSpaces()
+		if self.position == len(self.target) {
+			return nil, fmt.Errorf("Unexpected end of target %s", self.target)
+		}
+		c := self.target[self.position]
+		self.position++
+		if c == ')' {
+			break
+		}
+		if c != ',' {
+			return nil, fmt.Errorf("Unexpected %q at %d in target %s", c, self.position-1, self.target)
+		}
+	}
+	expr.raw = self.target[start:self.position]
+	return expr, nil
+}
+
+// Converts a graphite path with wildcards to the regex of the series
+// names it matches
+func graphitePathToRegex(path string) string {
+	regex := "^"
+	inBraces := false
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '*':
+			regex += `[^.]*`
+		case c == '?':
+			regex += `[^.]`
+		case c == '{':
+			inBraces = true
+			regex += "("
+		case c == '}':
+			inBraces = false
+			regex += ")"
+		case c == ',' && inBraces:
+			regex += "|"
+		case c == '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				regex += `\[`
+				continue
+			}
+			regex += path[i : i+end+1]
+			i += end
+		case c == '/':
+			regex += `\/`
+		default:
+			regex += regexp.QuoteMeta(string(c))
+		}
+	}
+	return regex + "$"
+}
+
+var graphiteUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+	"mon": 30 * 24 * time.Hour, "month": 30 * 24 * time.Hour, "months": 30 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour, "year": 365 * 24 * time.Hour, "years": 365 * 24 * time.Hour,
+}
+
+// Parses intervals like 5min or 1d
+func parseGraphiteInterval(s string) (time.Duration, error) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid interval %s", s)
+	}
+	unit, ok := graphiteUnits[s[i:]]
+	if !ok {
+		return 0, fmt.Errorf("Invalid interval %s", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// Parses the from and until parameters of /render, they can be now, a
+// relative time like -1h or a unix timestamp in seconds
+func parseGraphiteTime(s string, now time.Time) (time.Time, error) {
+	switch {
+	case s == "now":
+		return now, nil
+	case strings.HasPrefix(s, "-"):
+		interval, err := parseGraphiteInterval(s[1:])
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-interval), nil
+	}
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time %s", s)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+type graphitePoint struct {
+	time  int64
+	value *float64
+}
+
+type graphiteSeries struct {
+	name   string
+	points []*graphitePoint
+}
+
+// Evaluates render targets, fetch runs an influxdb query and returns
+// the series it returned
+type graphiteRenderer struct {
+	fetch func(query string) ([]*protocol.Series, error)
+	from  time.Time
+	until time.Time
+}
+
+func (self *graphiteRenderer) fetchPath(path, aggregate string, interval time.Duration) ([]*graphiteSeries, error) {
+	column := "value"
+	if aggregate != "" {
+		column = fmt.Sprintf("%s(value)", aggregate)
+	}
+	query := fmt.Sprintf("select %s from /%s/ where time > %du and time < %du", column, graphitePathToRegex(path),
+		self.from.UnixNano()/1000, self.until.UnixNano()/1000)
+	if aggregate != "" {
+		query += fmt.Sprintf(" group by time(%ds)", int64(interval/time.Second))
+	}
+	query += " order asc"
+
+	results, err := self.fetch(query)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*graphiteSeries{}
+	names := []string{}
+	for _, s := range results {
+		index := s.GetFieldIndex("value")
+		if index < 0 {
+			index = 0
+		}
+		series := byName[s.GetName()]
+		for _, point := range s.Points {
+			if series == nil {
+				series = &graphiteSeries{name: s.GetName()}
+				byName[series.name] = series
+				names = append(names, series.name)
+			}
+			p := &graphitePoint{time: point.GetTimestamp() / 1000000}
+			if index < len(point.Values) {
+				switch value := point.GetFieldValue(index).(type) {
+				case float64:
+					p.value = &value
+				case int64:
+					f := float64(value)
+					p.value = &f
+				}
+			}
+			series.points = append(series.points, p)
+		}
+	}
+
+	sort.Strings(names)
+	serieses := make([]*graphiteSeries, 0, len(names))
+	for _, name := range names {
+		serieses = append(serieses, byName[name])
+	}
+	return serieses, nil
+}
+
+func (self *graphiteRenderer) eval(expr *graphiteExpr) ([]*graphiteSeries, error) {
+	switch expr.kind {
+	case graphitePath:
+		return self.fetchPath(expr.value, "", 0)
+	case graphiteCall:
+	default:
+		return nil, fmt.Errorf("%s isn't a series", expr.raw)
+	}
+
+	switch expr.value {
+	case "summarize":
+		return self.summarize(expr)
+	case "alias":
+		if len(expr.args) != 2 || expr.args[1].kind != graphiteString {
+			return nil, fmt.Errorf("Usage: alias(series, \"name\")")
+		}
+		serieses, err := self.eval(expr.args[0])
+		for _, series := range serieses {
+			series.name = expr.args[1].value
+		}
+		return serieses, err
+	case "aliasByNode":
+		return self.aliasByNode(expr)
+	case "scale", "offset":
+		if len(expr.args) != 2 || expr.args[1].kind != graphiteNumber {
+			return nil, fmt.Errorf("Usage: %s(series, number)", expr.value)
+		}
+		serieses, err := self.eval(expr.args[0])
+		n := expr.args[1].number
+		for _, series := range serieses {
+			series.name = fmt.Sprintf("%s(%s,%s)", expr.value, series.name, expr.args[1].raw)
+			for _, point := range series.points {
+				if point.value == nil {
+					continue
+				}
+				value := *point.value * n
+				if expr.value == "offset" {
+					value = *point.value + n
+				}
+				point.value = &value
+			}
+		}
+		return serieses, err
+	case "sumSeries", "sum", "averageSeries", "avg", "maxSeries", "minSeries":
+		return self.combine(expr)
+	}
+	return nil, fmt.Errorf("Unsupported function %s", expr.value)
+}
+
+var graphiteAggregates = map[string]string{
+	"sum":   "sum",
+	"avg":   "mean",
+	"max":   "max",
+	"min":   "min",
+	"first": "first",
+	"last":  "last",
+}
+
+// summarize(path, "1h", "sum") runs the aggregate in influxdb
+func (self *graphiteRenderer) summarize(expr *graphiteExpr) ([]*graphiteSeries, error) {
+	if len(expr.args) < 2 || len(expr.args) > 3 || expr.args[0].kind != graphitePath || expr.args[1].kind != graphiteString {
+		return nil, fmt.Errorf("Usage: summarize(path, \"interval\", \"sum|avg|max|min|first|last\")")
+	}
+	interval, err := parseGraphiteInterval(expr.args[1].value)
+	if err != nil {
+		return nil, err
+	}
+	function := "sum"
+	if len(expr.args) == 3 {
+		function = expr.args[2].value
+	}
+	aggregate, ok := graphiteAggregates[function]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported summarize function %s", function)
+	}
+
+	serieses, err := self.fetchPath(expr.args[0].value, aggregate, interval)
+	for _, series := range serieses {
+		series.name = fmt.Sprintf("summarize(%s, \"%s\", \"%s\")", series.name, expr.args[1].value, function)
+	}
+	return serieses, err
+}
+
+func (self *graphiteRenderer) aliasByNode(expr *graphiteExpr) ([]*graphiteSeries, error) {
+	if len(expr.args) < 2 {
+		return nil, fmt.Errorf("Usage: aliasByNode(series, node...)")
+	}
+	nodes := []int{}
+	for _, arg := range expr.args[1:] {
+		if arg.kind != graphiteNumber {
+			return nil, fmt.Errorf("Usage: aliasByNode(series, node...)")
+		}
+		nodes = append(nodes, int(arg.number))
+	}
+	serieses, err := self.eval(expr.args[0])
+	for _, series := range serieses {
+		parts := strings.Split(series.name, ".")
+		selected := []string{}
+		for _, node := range nodes {
+			if node < 0 {
+				node += len(parts)
+			}
+			if node >= 0 && node < len(parts) {
+				selected = append(selected, parts[node])
+			}
+		}
+		series.name = strings.Join(selected, ".")
+	}
+	return serieses, err
+}
+
+// Combines the points of the series that have the same timestamp, the
+// series should be summarized first if their timestamps don't line up
+func (self *graphiteRenderer) combine(expr *graphiteExpr) ([]*graphiteSeries, error) {
+	var serieses []*graphiteSeries
+	for _, arg := range expr.args {
+		s, err := self.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		serieses = append(serieses, s...)
+	}
+
+	values := map[int64][]float64{}
+	times := []int64{}
+	for _, series := range serieses {
+		for _, point := range series.points {
+			if _, ok := values[point.time]; !ok {
+				values[point.time] = nil
+				times = append(times, point.time)
+			}
+			if point.value != nil {
+				values[point.time] = append(values[point.time], *point.value)
+			}
+		}
+	}
+	engine.SortInt64(times)
+
+	argNames := make([]string, 0, len(expr.args))
+	for _, arg := range expr.args {
+		argNames = append(argNames, arg.raw)
+	}
+	combined := &graphiteSeries{name: fmt.Sprintf("%s(%s)", expr.value, strings.Join(argNames, ","))}
+	for _, t := range times {
+		point := &graphitePoint{time: t}
+		if len(values[t]) > 0 {
+			result := combineGraphiteValues(expr.value, values[t])
+			point.value = &result
+		}
+		combined.points = append(combined.points, point)
+	}
+	return []*graphiteSeries{combined}, nil
+}
+
+func combineGraphiteValues(function string, values []float64) float64 {
+	result := values[0]
+	for _, value := range values[1:] {
+		switch function {
+		case "maxSeries":
+			result = math.Max(result, value)
+		case "minSeries":
+			result = math.Min(result, value)
+		default:
+			result += value
+		}
+	}
+	if function == "averageSeries" || function == "avg" {
+		result /= float64(len(values))
+	}
+	return result
+}
+
+// A subset of graphite's /render api so graphite dashboards can be
+// pointed at a database. Only the json format is supported, see
+// graphiteRenderer.eval for the supported functions.
+func (self *HttpServer) graphiteRender(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	self.tryAsDbUserAndClusterAdmin(w, r, func(user User) (int, interface{}) {
+		if format := r.Form.Get("format"); format != "" && format != "json" {
+			return libhttp.StatusBadRequest, "Only the json format is supported"
+		}
+
+		now := time.Now()
+		renderer := &graphiteRenderer{from: now.Add(-GRAPHITE_DEFAULT_RANGE), until: now}
+		var err error
+		if from := r.Form.Get("from"); from != "" {
+			if renderer.from, err = parseGraphiteTime(from, now); err != nil {
+				return libhttp.StatusBadRequest, err.Error()
+			}
+		}
+		if until := r.Form.Get("until"); until != "" {
+			if renderer.until, err = parseGraphiteTime(until, now); err != nil {
+				return libhttp.StatusBadRequest, err.Error()
+			}
+		}
+
+		targets := r.Form["target"]
+		if statusCode, body := self.checkQueryRateLimits(w, user, db, len(targets)); statusCode != 0 {
+			return statusCode, body
+		}
+		renderer.fetch = func(query string) ([]*protocol.Series, error) {
+			var results []*protocol.Series
+			writer := NewSeriesWriter(func(series *protocol.Series) error {
+				results = append(results, series)
+				return nil
+			})
+			err := self.coordinator.RunQuery(user, db, query, writer)
+			return results, err
+		}
+
+		response := []*GraphiteRenderSeries{}
+		for _, target := range targets {
+			expr, err := parseGraphiteTarget(target)
+			if err != nil {
+				return libhttp.StatusBadRequest, err.Error()
+			}
+			serieses, err := renderer.eval(expr)
+			if err != nil {
+				return errorToStatusCode(err), err.Error()
+			}
+			for _, series := range serieses {
+				datapoints := make([][2]interface{}, 0, len(series.points))
+				for _, point := range series.points {
+					var value interface{}
+					if point.value != nil {
+						value = *point.value
+					}
+					datapoints = append(datapoints, [2]interface{}{value, point.time})
+				}
+				response = append(response, &GraphiteRenderSeries{Target: series.name, Datapoints: datapoints})
+			}
+		}
+		return libhttp.StatusOK, response
+	})
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"protocol"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+	. "launchpad.net/gocheck"
+)
+
+type GraphiteRenderSuite struct{}
+
+var _ = Suite(&GraphiteRenderSuite{})
+
+func (self *GraphiteRenderSuite) TestParseTarget(c *C) {
+	expr, err := parseGraphiteTarget(`alias(sumSeries(servers.{web1,web2}.cpu, servers.db.cpu), "cpu")`)
+	c.Assert(err, IsNil)
+	c.Assert(expr.kind, Equals, graphiteCall)
+	c.Assert(expr.value, Equals, "alias")
+	c.Assert(expr.args, HasLen, 2)
+	c.Assert(expr.args[0].raw, Equals, "sumSeries(servers.{web1,web2}.cpu, servers.db.cpu)")
+	c.Assert(expr.args[0].args, HasLen, 2)
+	c.Assert(expr.args[0].args[0].value, Equals, "servers.{web1,web2}.cpu")
+	c.Assert(expr.args[1].kind, Equals, graphiteString)
+	c.Assert(expr.args[1].value, Equals, "cpu")
+
+	expr, err = parseGraphiteTarget("scale(servers.web1.cpu, -0.5)")
+	c.Assert(err, IsNil)
+	c.Assert(expr.args[1].kind, Equals, graphiteNumber)
+	c.Assert(expr.args[1].number, Equals, -0.5)
+
+	for _, target := range []string{"", "alias(foo", "alias(foo, 'bar'", "foo)", "alias(foo bar)"} {
+		_, err := parseGraphiteTarget(target)
+		c.Assert(err, NotNil, Commentf("target: %s", target))
+	}
+}
+
+func (self *GraphiteRenderSuite) TestPathToRegex(c *C) {
+	c.Assert(graphitePathToRegex("servers.*.cpu"), Equals, `^servers\.[^.]*\.cpu$`)
+	c.Assert(graphitePathToRegex("servers.{web1,web2}.cpu[01]"), Equals, `^servers\.(web1|web2)\.cpu[01]$`)
+}
+
+func (self *GraphiteRenderSuite) TestParseTime(c *C) {
+	now := time.Unix(100000, 0)
+	t, err := parseGraphiteTime("-1h", now)
+	c.Assert(err, IsNil)
+	c.Assert(t.Unix(), Equals, int64(100000-3600))
+	t, err = parseGraphiteTime("5000", now)
+	c.Assert(err, IsNil)
+	c.Assert(t.Unix(), Equals, int64(5000))
+	t, err = parseGraphiteTime("now", now)
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, now)
+	_, err = parseGraphiteTime("-1fortnight", now)
+	c.Assert(err, NotNil)
+}
+
+func graphiteTestSeries(name string, field string, values ...float64) *protocol.Series {
+	series := &protocol.Series{Name: proto.String(name), Fields: []string{field}}
+	for i, value := range values {
+		series.Points = append(series.Points, &protocol.Point{
+			Values:    []*protocol.FieldValue{&protocol.FieldValue{DoubleValue: proto.Float64(value)}},
+			Timestamp: proto.Int64(int64(i+1) * 60 * 1000000),
+		})
+	}
+	return series
+}
+
+func (self *GraphiteRenderSuite) TestEval(c *C) {
+	queries := []string{}
+	renderer := &graphiteRenderer{
+		from:  time.Unix(0, 0),
+		until: time.Unix(3600, 0),
+		fetch: func(query string) ([]*protocol.Series, error) {
+			queries = append(queries, query)
+			field := "value"
+			if len(queries) == 2 {
+				field = "sum"
+			}
+			return []*protocol.Series{
+				graphiteTestSeries("servers.web2.cpu", field, 3, 4),
+				graphiteTestSeries("servers.web1.cpu", field, 1, 2),
+			}, nil
+		},
+	}
+
+	expr, err := parseGraphiteTarget("aliasByNode(scale(servers.*.cpu, 2), 1)")
+	c.Assert(err, IsNil)
+	serieses, err := renderer.eval(expr)
+	c.Assert(err, IsNil)
+	c.Assert(queries[0], Equals, `select value from /^servers\.[^.]*\.cpu$/ where time > 0u and time < 3600000000u order asc`)
+	c.Assert(serieses, HasLen, 2)
+	c.Assert(serieses[0].name, Equals, "web1")
+	c.Assert(serieses[0].points, HasLen, 2)
+	c.Assert(serieses[0].points[0].time, Equals, int64(60))
+	c.Assert(*serieses[0].points[1].value, Equals, 4.0)
+
+	expr, err = parseGraphiteTarget(`summarize(servers.*.cpu, "5min", "avg")`)
+	c.Assert(err, IsNil)
+	serieses, err = renderer.eval(expr)
+	c.Assert(err, IsNil)
+	c.Assert(queries[1], Equals, `select mean(value) from /^servers\.[^.]*\.cpu$/ where time > 0u and time < 3600000000u group by time(300s) order asc`)
+	c.Assert(serieses[1].name, Equals, `summarize(servers.web2.cpu, "5min", "avg")`)
+
+	expr, err = parseGraphiteTarget("maxSeries(servers.*.cpu)")
+	c.Assert(err, IsNil)
+	serieses, err = renderer.eval(expr)
+	c.Assert(err, IsNil)
+	c.Assert(serieses, HasLen, 1)
+	c.Assert(serieses[0].name, Equals, "maxSeries(servers.*.cpu)")
+	c.Assert(*serieses[0].points[0].value, Equals, 3.0)
+	c.Assert(*serieses[0].points[1].value, Equals, 4.0)
+
+	for _, target := range []string{"derivative(servers.web1.cpu)", `summarize(scale(foo, 2), "1h")`, `summarize(foo, "1h", "median")`, "'foo'"} {
+		expr, err := parseGraphiteTarget(target)
+		c.Assert(err, IsNil)
+		_, err = renderer.eval(expr)
+		c.Assert(err, NotNil, Commentf("target: %s", target))
+	}
+}
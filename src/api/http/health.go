@@ -0,0 +1,135 @@
+package http
+
+import (
+	"fmt"
+	libhttp "net/http"
+	"time"
+	"wal"
+
+	"github.com/goraft/raft"
+)
+
+// how long the health checks wait for the wal to report its status
+const HEALTH_CHECK_WAL_TIMEOUT = 5 * time.Second
+
+type RaftHealth struct {
+	State   string `json:"state"`
+	Name    string `json:"name"`
+	Leader  string `json:"leader"`
+	Members int    `json:"members"`
+}
+
+// The state of this node as it's reported by /health and /ready. A
+// node is healthy if it's running and its wal can take writes, it's
+// ready if it's also part of the cluster and can reach the leader.
+// Quarantined shards are reported but don't make the node unhealthy,
+// they're repaired from other replicas in the background.
+type HealthReport struct {
+	Healthy           bool        `json:"healthy"`
+	Ready             bool        `json:"ready"`
+	Raft              *RaftHealth `json:"raft"`
+	LocalServerAdded  bool        `json:"localServerAdded"`
+	LeaderReachable   bool        `json:"leaderReachable"`
+	QuarantinedShards []uint32    `json:"quarantinedShards"`
+	Wal               *wal.Status `json:"wal"`
+	Problems          []string    `json:"problems,omitempty"`
+}
+
+// Sets Healthy, Ready and Problems from the rest of the report
+func (self *HealthReport) check() {
+	self.Problems = nil
+	healthy := true
+	ready := true
+
+	if self.Wal == nil {
+		healthy = false
+		self.Problems = append(self.Problems, "The wal didn't report its status")
+	} else if self.Wal.LastError != "" {
+		healthy = false
+		self.Problems = append(self.Problems, fmt.Sprintf("The wal cannot append requests: %s", self.Wal.LastError))
+	}
+	if self.Raft.State == raft.Stopped {
+		healthy = false
+		self.Problems = append(self.Problems, "Raft is stopped")
+	}
+
+	if !self.LocalServerAdded {
+		ready = false
+		self.Problems = append(self.Problems, "This server wasn't added to the cluster yet")
+	}
+	if self.Raft.Leader == "" {
+		ready = false
+		self.Problems = append(self.Problems, "The cluster doesn't have a leader")
+	} else if !self.LeaderReachable {
+		ready = false
+		self.Problems = append(self.Problems, fmt.Sprintf("The leader %s isn't reachable", self.Raft.Leader))
+	}
+
+	self.Healthy = healthy
+	self.Ready = healthy && ready
+}
+
+func (self *HttpServer) walStatus() *wal.Status {
+	statusChan := make(chan *wal.Status, 1)
+	go func() {
+		statusChan <- self.clusterConfig.WalStatus()
+	}()
+	select {
+	case status := <-statusChan:
+		return status
+	case <-time.After(HEALTH_CHECK_WAL_TIMEOUT):
+		return nil
+	}
+}
+
+func (self *HttpServer) healthReport() *HealthReport {
+	report := &HealthReport{
+		Raft: &RaftHealth{
+			State:   self.raftServer.State(),
+			Name:    self.raftServer.GetRaftName(),
+			Leader:  self.raftServer.Leader(),
+			Members: self.raftServer.MemberCount(),
+		},
+		LocalServerAdded:  self.clusterConfig.HasLocalServer(),
+		QuarantinedShards: self.clusterConfig.QuarantinedLocalShards(),
+		Wal:               self.walStatus(),
+	}
+
+	leader := report.Raft.Leader
+	if leader == report.Raft.Name {
+		report.LeaderReachable = true
+	} else if server := self.clusterConfig.GetServerByRaftName(leader); server != nil {
+		report.LeaderReachable = server.IsUp()
+	}
+
+	report.check()
+	return report
+}
+
+func writeHealthReport(w libhttp.ResponseWriter, ok bool, report *HealthReport) {
+	statusCode := libhttp.StatusOK
+	if !ok {
+		statusCode = libhttp.StatusServiceUnavailable
+	}
+	body, contentType, err := toBytes(report)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Add("content-type", contentType)
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// Returns 200 if this node is running, 503 otherwise
+func (self *HttpServer) health(w libhttp.ResponseWriter, r *libhttp.Request) {
+	report := self.healthReport()
+	writeHealthReport(w, report.Healthy, report)
+}
+
+// Returns 200 if this node should receive traffic, 503 otherwise
+func (self *HttpServer) ready(w libhttp.ResponseWriter, r *libhttp.Request) {
+	report := self.healthReport()
+	writeHealthReport(w, report.Ready, report)
+}
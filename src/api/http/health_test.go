@@ -0,0 +1,70 @@
+package http
+
+import (
+	"wal"
+
+	. "launchpad.net/gocheck"
+)
+
+type HealthSuite struct{}
+
+var _ = Suite(&HealthSuite{})
+
+func healthyReport() *HealthReport {
+	return &HealthReport{
+		Raft:              &RaftHealth{State: "follower", Name: "a", Leader: "b", Members: 3},
+		LocalServerAdded:  true,
+		LeaderReachable:   true,
+		QuarantinedShards: []uint32{},
+		Wal:               &wal.Status{LogFiles: 1},
+	}
+}
+
+func (self *HealthSuite) TestHealthyNode(c *C) {
+	report := healthyReport()
+	report.check()
+	c.Assert(report.Healthy, Equals, true)
+	c.Assert(report.Ready, Equals, true)
+	c.Assert(report.Problems, HasLen, 0)
+}
+
+func (self *HealthSuite) TestQuarantinedShardsDontFailTheChecks(c *C) {
+	report := healthyReport()
+	report.QuarantinedShards = []uint32{1, 2}
+	report.check()
+	c.Assert(report.Healthy, Equals, true)
+	c.Assert(report.Ready, Equals, true)
+}
+
+func (self *HealthSuite) TestWalErrorsMakeTheNodeUnhealthy(c *C) {
+	report := healthyReport()
+	report.Wal.LastError = "disk full"
+	report.check()
+	c.Assert(report.Healthy, Equals, false)
+	c.Assert(report.Ready, Equals, false)
+	c.Assert(report.Problems, HasLen, 1)
+
+	report = healthyReport()
+	report.Wal = nil
+	report.check()
+	c.Assert(report.Healthy, Equals, false)
+}
+
+func (self *HealthSuite) TestNodeWithoutLeaderIsntReady(c *C) {
+	report := healthyReport()
+	report.Raft.Leader = ""
+	report.LeaderReachable = false
+	report.check()
+	c.Assert(report.Healthy, Equals, true)
+	c.Assert(report.Ready, Equals, false)
+
+	report = healthyReport()
+	report.LeaderReachable = false
+	report.check()
+	c.Assert(report.Ready, Equals, false)
+
+	report = healthyReport()
+	report.LocalServerAdded = false
+	report.check()
+	c.Assert(report.Ready, Equals, false)
+}
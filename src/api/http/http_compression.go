@@ -5,6 +5,7 @@ import (
 	"compress/zlib"
 	"io"
 	libhttp "net/http"
+	"strconv"
 	"strings"
 )
 
@@ -16,24 +17,70 @@ type CompressedResponseWriter struct {
 func NewCompressionResponseWriter(useCompression bool, rw libhttp.ResponseWriter, req *libhttp.Request) *CompressedResponseWriter {
 	var writer io.Writer = rw
 
-	if req.Header.Get("Accept-Encoding") != "" {
-		encodings := strings.Split(req.Header.Get("Accept-Encoding"), ",")
-
-		for _, val := range encodings {
-			if val == "gzip" {
-				rw.Header().Set("Content-Encoding", "gzip")
-				writer, _ = gzip.NewWriterLevel(writer, gzip.BestSpeed)
-				break
-			} else if val == "deflate" {
-				rw.Header().Set("Content-Encoding", "deflate")
-				writer, _ = zlib.NewWriterLevel(writer, zlib.BestSpeed)
-				break
-			}
+	if useCompression {
+		switch acceptedEncoding(req.Header.Get("Accept-Encoding")) {
+		case "gzip":
+			rw.Header().Set("Content-Encoding", "gzip")
+			writer, _ = gzip.NewWriterLevel(writer, gzip.BestSpeed)
+		case "deflate":
+			rw.Header().Set("Content-Encoding", "deflate")
+			writer, _ = zlib.NewWriterLevel(writer, zlib.BestSpeed)
 		}
 	}
+	if writer != rw {
+		rw.Header().Add("Vary", "Accept-Encoding")
+	}
 	return &CompressedResponseWriter{rw, writer}
 }
 
+// Returns the encoding of the Accept-Encoding header with the highest
+// quality that is supported, gzip or deflate, or an empty string if the
+// client doesn't accept any of them.
+func acceptedEncoding(header string) string {
+	return preferredValue(header, []string{"gzip", "deflate"})
+}
+
+// Returns the value of an Accept style header with the highest quality
+// that is supported, or an empty string if none of the supported values
+// is acceptable. The values with a quality of 0 aren't acceptable, the
+// first one wins if the quality is the same.
+func preferredValue(header string, supported []string) string {
+	value := ""
+	quality := 0.0
+	for _, val := range strings.Split(header, ",") {
+		params := strings.Split(val, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if !isSupportedValue(name, supported) {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil {
+				parsed = 0
+			}
+			q = parsed
+		}
+		if q > quality {
+			value, quality = name, q
+		}
+	}
+	return value
+}
+
+func isSupportedValue(name string, supported []string) bool {
+	for _, s := range supported {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (self *CompressedResponseWriter) Header() libhttp.Header {
 	return self.responseWriter.Header()
 }
@@ -46,19 +93,82 @@ func (self *CompressedResponseWriter) WriteHeader(responseCode int) {
 	self.responseWriter.WriteHeader(responseCode)
 }
 
+// Flush is used by the chunked responses, it flushes the compressed
+// data that is buffered and then the underlying response writer
+func (self *CompressedResponseWriter) Flush() {
+	switch x := self.writer.(type) {
+	case *gzip.Writer:
+		x.Flush()
+	case *zlib.Writer:
+		x.Flush()
+	}
+	if flusher, ok := self.responseWriter.(libhttp.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (self *CompressedResponseWriter) Close() {
+	switch x := self.writer.(type) {
+	case *gzip.Writer:
+		x.Close()
+	case *zlib.Writer:
+		x.Close()
+	}
+}
+
 func CompressionHandler(enableCompression bool, handler libhttp.HandlerFunc) libhttp.HandlerFunc {
 	if !enableCompression {
 		return handler
 	}
 
 	return func(rw libhttp.ResponseWriter, req *libhttp.Request) {
+		if !decompressRequestBody(rw, req) {
+			return
+		}
 		crw := NewCompressionResponseWriter(true, rw, req)
+		defer crw.Close()
 		handler(crw, req)
-		switch x := crw.writer.(type) {
-		case *gzip.Writer:
-			x.Close()
-		case *zlib.Writer:
-			x.Close()
-		}
 	}
 }
+
+// Replaces the body of requests that have a Content-Encoding of gzip
+// or deflate with a reader that decompresses it. Returns false if the
+// body couldn't be decompressed, in which case an error has already
+// been written to the client.
+func decompressRequestBody(rw libhttp.ResponseWriter, req *libhttp.Request) bool {
+	var err error
+	var reader io.ReadCloser
+
+	switch strings.TrimSpace(req.Header.Get("Content-Encoding")) {
+	case "", "snappy":
+		// snappy bodies are decoded by the prometheus endpoints
+		return true
+	case "gzip":
+		reader, err = gzip.NewReader(req.Body)
+	case "deflate":
+		reader, err = zlib.NewReader(req.Body)
+	default:
+		rw.WriteHeader(libhttp.StatusUnsupportedMediaType)
+		rw.Write([]byte("Unsupported Content-Encoding " + req.Header.Get("Content-Encoding")))
+		return false
+	}
+
+	if err != nil {
+		rw.WriteHeader(libhttp.StatusBadRequest)
+		rw.Write([]byte(err.Error()))
+		return false
+	}
+	req.Body = &decompressedBody{reader, req.Body}
+	req.Header.Del("Content-Encoding")
+	return true
+}
+
+type decompressedBody struct {
+	io.ReadCloser
+	original io.ReadCloser
+}
+
+func (self *decompressedBody) Close() error {
+	self.ReadCloser.Close()
+	return self.original.Close()
+}
@@ -0,0 +1,424 @@
+package http
+
+import (
+	"bufio"
+	"cluster"
+	. "common"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	libhttp "net/http"
+	"os"
+	"path/filepath"
+	"stats"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+const (
+	INTAKE_LOG_FILE    = "intake.log"
+	INTAKE_OFFSET_FILE = "intake.offset"
+	// the backoff between the attempts to apply a write that failed
+	// because of the cluster, the write is retried until it's applied
+	INTAKE_MIN_RETRY_DELAY = time.Second
+	INTAKE_MAX_RETRY_DELAY = time.Minute
+)
+
+// A write that was accepted by the api but hasn't been applied yet. The
+// user was authorized to write to the database when the write was
+// queued, its permissions are checked again when it's applied.
+type intakeEntry struct {
+	Database     string          `json:"database"`
+	User         string          `json:"user"`
+	ClusterAdmin bool            `json:"cluster_admin,omitempty"`
+	Precision    string          `json:"time_precision"`
+	Body         json.RawMessage `json:"body"`
+}
+
+var intakeQueueDepth = stats.NewGauge("intakeQueueDepth")
+
+type IntakeQueueStats struct {
+	Depth    int64 `json:"depth"`
+	Applied  int64 `json:"applied"`
+	Failures int64 `json:"failures"`
+}
+
+// A durable queue of writes. Writes are appended to a log file that is
+// synced before Append returns and a single worker applies them in
+// order, the offset of the next write is saved after every write so the
+// worker picks up where it left off after a restart. The log is
+// truncated whenever the worker catches up.
+type IntakeQueue struct {
+	dir           string
+	lock          sync.Mutex
+	file          *os.File
+	size          int64
+	offset        int64
+	stats         IntakeQueueStats
+	apply         func(*intakeEntry) error
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	notify        chan bool
+	shutdown      chan bool
+	done          chan bool
+	started       bool
+}
+
+func NewIntakeQueue(dir string, apply func(*intakeEntry) error) (*IntakeQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, INTAKE_LOG_FILE), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	self := &IntakeQueue{
+		dir:           dir,
+		file:          f,
+		size:          info.Size(),
+		apply:         apply,
+		retryDelay:    INTAKE_MIN_RETRY_DELAY,
+		maxRetryDelay: INTAKE_MAX_RETRY_DELAY,
+		notify:        make(chan bool, 1),
+		shutdown:      make(chan bool),
+		done:          make(chan bool),
+	}
+	if err := self.readOffset(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := self.countPending(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return self, nil
+}
+
+func (self *IntakeQueue) readOffset() error {
+	data, err := ioutil.ReadFile(filepath.Join(self.dir, INTAKE_OFFSET_FILE))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Cannot parse the intake queue offset: %s", err)
+	}
+	// the log was truncated but we crashed before saving the offset
+	if offset > self.size {
+		offset = 0
+	}
+	self.offset = offset
+	return nil
+}
+
+func (self *IntakeQueue) saveOffset() error {
+	path := filepath.Join(self.dir, INTAKE_OFFSET_FILE)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(self.offset, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (self *IntakeQueue) countPending() error {
+	position := self.offset
+	reader := bufio.NewReader(io.NewSectionReader(self.file, self.offset, self.size-self.offset))
+	for {
+		_, length, err := readIntakeEntry(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// a partial write at the end of the log, the entry wasn't
+			// acknowledged so it's safe to drop it
+			log.Warn("Truncating the intake queue after a partial entry: %s", err)
+			return self.truncate(position)
+		}
+		position += length
+		self.stats.Depth++
+		intakeQueueDepth.Set(self.stats.Depth)
+	}
+}
+
+func (self *IntakeQueue) truncate(size int64) error {
+	if err := self.file.Truncate(size); err != nil {
+		return err
+	}
+	self.size = size
+	return nil
+}
+
+func readIntakeEntry(reader io.Reader) (*intakeEntry, int64, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, 0, err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	entry := &intakeEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, 0, err
+	}
+	return entry, int64(4 + length), nil
+}
+
+// Appends the write to the log, the write is durable once Append returns
+func (self *IntakeQueue) Append(entry *intakeEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:], data)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, err := self.file.WriteAt(record, self.size); err != nil {
+		return err
+	}
+	if err := self.file.Sync(); err != nil {
+		return err
+	}
+	self.size += int64(len(record))
+	self.stats.Depth++
+	intakeQueueDepth.Set(self.stats.Depth)
+
+	select {
+	case self.notify <- true:
+	default:
+	}
+	return nil
+}
+
+func (self *IntakeQueue) Stats() IntakeQueueStats {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.stats
+}
+
+// Starts the worker that applies the writes
+func (self *IntakeQueue) Start() {
+	self.started = true
+	go self.run()
+}
+
+func (self *IntakeQueue) Close() {
+	close(self.shutdown)
+	if self.started {
+		<-self.done
+	}
+	self.file.Close()
+}
+
+func (self *IntakeQueue) run() {
+	defer close(self.done)
+	for {
+		entry, length, err := self.next()
+		if err != nil {
+			log.Error("Cannot read the intake queue: %s", err)
+		}
+		if entry == nil {
+			select {
+			case <-self.notify:
+				continue
+			case <-self.shutdown:
+				return
+			}
+		}
+
+		if !self.applyWithRetries(entry) {
+			return
+		}
+		if err := self.advance(length); err != nil {
+			log.Error("Cannot save the intake queue offset: %s", err)
+		}
+	}
+}
+
+// Returns the next entry or nil if the queue is empty
+func (self *IntakeQueue) next() (*intakeEntry, int64, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.offset == self.size {
+		if self.offset > 0 {
+			// the worker caught up, start over with an empty log
+			if err := self.truncate(0); err != nil {
+				return nil, 0, err
+			}
+			self.offset = 0
+			return nil, 0, self.saveOffset()
+		}
+		return nil, 0, nil
+	}
+	entry, length, err := readIntakeEntry(io.NewSectionReader(self.file, self.offset, self.size-self.offset))
+	if err != nil {
+		// the log is corrupt, skip the rest of it
+		self.stats.Failures += self.stats.Depth
+		self.stats.Depth = 0
+		intakeQueueDepth.Set(self.stats.Depth)
+		self.offset = self.size
+		if saveErr := self.saveOffset(); saveErr != nil {
+			log.Error("Cannot save the intake queue offset: %s", saveErr)
+		}
+		return nil, 0, err
+	}
+	return entry, length, nil
+}
+
+// Applies the entry, the writes that fail because of the cluster are
+// retried until they're applied and the offset isn't advanced
+// meanwhile. The writes that won't be applied by retrying them, because
+// they're invalid, exceed a quota or rate limit or the user isn't allowed
+// to write anymore, are logged and dropped. Returns false if the queue was closed before the entry was applied.
+func (self *IntakeQueue) applyWithRetries(entry *intakeEntry) bool {
+	delay := self.retryDelay
+	for {
+		err := self.apply(entry)
+		if err == nil {
+			self.lock.Lock()
+			self.stats.Applied++
+			self.lock.Unlock()
+			return true
+		}
+
+		if isPermanentWriteError(err) {
+			log.Error("Dropping queued write to %s by %s: %s", entry.Database, entry.User, err)
+			self.lock.Lock()
+			self.stats.Failures++
+			self.lock.Unlock()
+			return true
+		}
+
+		log.Warn("Cannot apply queued write to %s, retrying in %s: %s", entry.Database, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-self.shutdown:
+			return false
+		}
+		if delay *= 2; delay > self.maxRetryDelay {
+			delay = self.maxRetryDelay
+		}
+	}
+}
+
+// Returns true if retrying the write can't make it succeed
+func isPermanentWriteError(err error) bool {
+	switch err.(type) {
+	case AuthorizationError, QuotaExceededError, WriteRateExceededError, *invalidWriteError:
+		return true
+	}
+	return false
+}
+
+func (self *IntakeQueue) advance(length int64) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.offset += length
+	self.stats.Depth--
+	intakeQueueDepth.Set(self.stats.Depth)
+	return self.saveOffset()
+}
+
+// A queued write that can't be parsed, there's no point in retrying it
+type invalidWriteError struct {
+	err error
+}
+
+func (self *invalidWriteError) Error() string {
+	return self.err.Error()
+}
+
+// Queues the writes with async=true and returns 202 right away, the
+// queue is stored in dir. The queued writes are applied once the server
+// starts serving requests.
+func (self *HttpServer) EnableAsyncWrites(dir string) error {
+	queue, err := NewIntakeQueue(dir, self.applyQueuedWrite)
+	if err != nil {
+		return err
+	}
+	self.intakeQueue = queue
+	return nil
+}
+
+func (self *HttpServer) queueWrite(user User, db string, r *libhttp.Request, body []byte) (int, interface{}) {
+	if self.intakeQueue == nil {
+		return libhttp.StatusBadRequest, "Async writes aren't enabled"
+	}
+	if !user.HasWriteAccess(db) {
+		return libhttp.StatusForbidden, fmt.Sprintf("Insufficient permissions to write to %s", db)
+	}
+	entry := &intakeEntry{
+		Database:     db,
+		User:         user.GetName(),
+		ClusterAdmin: user.IsClusterAdmin(),
+		Precision:    r.URL.Query().Get("time_precision"),
+		Body:         json.RawMessage(body),
+	}
+	if err := self.intakeQueue.Append(entry); err != nil {
+		return libhttp.StatusInternalServerError, err.Error()
+	}
+	return libhttp.StatusAccepted, nil
+}
+
+func (self *HttpServer) applyQueuedWrite(entry *intakeEntry) error {
+	precision, err := TimePrecisionFromString(entry.Precision)
+	if err != nil {
+		return &invalidWriteError{err}
+	}
+	serializedSeries := []*SerializedSeries{}
+	if err := json.Unmarshal(entry.Body, &serializedSeries); err != nil {
+		return &invalidWriteError{err}
+	}
+	series, err := convertToDataStoreSeries(serializedSeries, precision)
+	if err != nil {
+		return &invalidWriteError{err}
+	}
+	user, err := self.queuedWriteUser(entry)
+	if err != nil {
+		return NewAuthorizationError("Cannot find %s: %s", entry.User, err)
+	}
+	if !user.HasWriteAccess(entry.Database) {
+		return NewAuthorizationError("%s isn't allowed to write to %s anymore", entry.User, entry.Database)
+	}
+	return self.coordinator.WriteSeriesData(user, entry.Database, series)
+}
+
+// Returns the user that queued the write with its current permissions,
+// it may have been deleted or lost its access since
+func (self *HttpServer) queuedWriteUser(entry *intakeEntry) (User, error) {
+	if entry.ClusterAdmin {
+		return self.userManager.LookupClusterAdmin(entry.User)
+	}
+	if strings.HasPrefix(entry.User, cluster.API_KEY_USER_PREFIX) {
+		return self.userManager.LookupApiKey(entry.Database, strings.TrimPrefix(entry.User, cluster.API_KEY_USER_PREFIX))
+	}
+	return self.userManager.LookupDbUser(entry.Database, entry.User)
+}
+
+func (self *HttpServer) getIntakeQueueStats(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if self.intakeQueue == nil {
+			return libhttp.StatusNotFound, "Async writes aren't enabled"
+		}
+		return libhttp.StatusOK, self.intakeQueue.Stats()
+	})
+}
@@ -0,0 +1,196 @@
+package http
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type IntakeQueueSuite struct {
+	dir     string
+	lock    sync.Mutex
+	applied []string
+	// the number of times the writes to the flaky db fail
+	failures int
+}
+
+var _ = Suite(&IntakeQueueSuite{})
+
+func (self *IntakeQueueSuite) SetUpTest(c *C) {
+	dir, err := ioutil.TempDir("", "intake")
+	c.Assert(err, IsNil)
+	self.dir = dir
+	self.applied = nil
+	self.failures = 0
+}
+
+func (self *IntakeQueueSuite) TearDownTest(c *C) {
+	os.RemoveAll(self.dir)
+}
+
+func (self *IntakeQueueSuite) apply(entry *intakeEntry) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if entry.Database == "invalid" {
+		return &invalidWriteError{fmt.Errorf("invalid write")}
+	}
+	if entry.Database == "forbidden" {
+		return common.NewAuthorizationError("Insufficient permissions to write to forbidden")
+	}
+	if entry.Database == "over_quota" {
+		return common.NewQuotaExceededError("Database over_quota exceeded its daily points quota")
+	}
+	if entry.Database == "rate_limited" {
+		return common.NewWriteRateExceededError("Write rate exceeded for rate_limited")
+	}
+	if entry.Database == "flaky" && self.failures > 0 {
+		self.failures--
+		return fmt.Errorf("no servers are up")
+	}
+	self.applied = append(self.applied, entry.Database)
+	return nil
+}
+
+func (self *IntakeQueueSuite) waitForStats(c *C, queue *IntakeQueue, applied, failures int64) {
+	for i := 0; i < 100; i++ {
+		stats := queue.Stats()
+		if stats.Applied == applied && stats.Failures == failures {
+			c.Assert(stats.Depth, Equals, int64(0))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("Timed out waiting for the queue, stats: %v", queue.Stats())
+}
+
+func newIntakeEntry(db string) *intakeEntry {
+	return &intakeEntry{Database: db, User: "dbuser", Body: json.RawMessage("[]")}
+}
+
+func (self *IntakeQueueSuite) TestAppliesWritesInOrder(c *C) {
+	queue, err := NewIntakeQueue(self.dir, self.apply)
+	c.Assert(err, IsNil)
+	for _, db := range []string{"db1", "invalid", "db2"} {
+		c.Assert(queue.Append(newIntakeEntry(db)), IsNil)
+	}
+	c.Assert(queue.Stats().Depth, Equals, int64(3))
+
+	queue.Start()
+	self.waitForStats(c, queue, 2, 1)
+	queue.Close()
+	c.Assert(self.applied, DeepEquals, []string{"db1", "db2"})
+}
+
+func (self *IntakeQueueSuite) TestPendingWritesSurviveRestarts(c *C) {
+	queue, err := NewIntakeQueue(self.dir, self.apply)
+	c.Assert(err, IsNil)
+	c.Assert(queue.Append(newIntakeEntry("db1")), IsNil)
+	c.Assert(queue.Append(newIntakeEntry("db2")), IsNil)
+	queue.Start()
+	queue.Close()
+
+	queue, err = NewIntakeQueue(self.dir, self.apply)
+	c.Assert(err, IsNil)
+	queue.Start()
+	c.Assert(queue.Append(newIntakeEntry("db3")), IsNil)
+	// the first queue may have applied some of the writes before it
+	// was closed but every write is applied exactly once
+	for i := 0; i < 100 && queue.Stats().Depth > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	queue.Close()
+	c.Assert(self.applied, DeepEquals, []string{"db1", "db2", "db3"})
+}
+
+func (self *IntakeQueueSuite) TestPartialEntriesAreDropped(c *C) {
+	queue, err := NewIntakeQueue(self.dir, self.apply)
+	c.Assert(err, IsNil)
+	c.Assert(queue.Append(newIntakeEntry("db1")), IsNil)
+	queue.Close()
+
+	f, err := os.OpenFile(self.dir+"/"+INTAKE_LOG_FILE, os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte{0, 0, 1})
+	c.Assert(err, IsNil)
+	f.Close()
+
+	queue, err = NewIntakeQueue(self.dir, self.apply)
+	c.Assert(err, IsNil)
+	c.Assert(queue.Stats().Depth, Equals, int64(1))
+	queue.Start()
+	self.waitForStats(c, queue, 1, 0)
+	queue.Close()
+	c.Assert(self.applied, DeepEquals, []string{"db1"})
+}
+
+func (self *IntakeQueueSuite) TestFailedWritesAreRetriedUntilTheyreApplied(c *C) {
+	self.failures = 10
+	queue, err := NewIntakeQueue(self.dir, self.apply)
+	c.Assert(err, IsNil)
+	queue.retryDelay = time.Millisecond
+	queue.maxRetryDelay = 2 * time.Millisecond
+	for _, db := range []string{"flaky", "db1"} {
+		c.Assert(queue.Append(newIntakeEntry(db)), IsNil)
+	}
+
+	queue.Start()
+	self.waitForStats(c, queue, 2, 0)
+	queue.Close()
+	// the write after the failing one waits for it
+	c.Assert(self.applied, DeepEquals, []string{"flaky", "db1"})
+}
+
+func (self *IntakeQueueSuite) TestUnauthorizedWritesAreDropped(c *C) {
+	queue, err := NewIntakeQueue(self.dir, self.apply)
+	c.Assert(err, IsNil)
+	for _, db := range []string{"forbidden", "db1"} {
+		c.Assert(queue.Append(newIntakeEntry(db)), IsNil)
+	}
+
+	queue.Start()
+	self.waitForStats(c, queue, 1, 1)
+	queue.Close()
+	c.Assert(self.applied, DeepEquals, []string{"db1"})
+}
+
+func (self *IntakeQueueSuite) TestWritesOverTheLimitsAreDropped(c *C) {
+	queue, err := NewIntakeQueue(self.dir, self.apply)
+	c.Assert(err, IsNil)
+	for _, db := range []string{"over_quota", "rate_limited", "db1"} {
+		c.Assert(queue.Append(newIntakeEntry(db)), IsNil)
+	}
+
+	queue.Start()
+	self.waitForStats(c, queue, 1, 2)
+	queue.Close()
+	c.Assert(self.applied, DeepEquals, []string{"db1"})
+}
+
+func (self *IntakeQueueSuite) TestOffsetIsntAdvancedPastAFailingWrite(c *C) {
+	self.failures = 1000
+	queue, err := NewIntakeQueue(self.dir, self.apply)
+	c.Assert(err, IsNil)
+	queue.retryDelay = time.Millisecond
+	queue.maxRetryDelay = time.Millisecond
+	c.Assert(queue.Append(newIntakeEntry("flaky")), IsNil)
+	queue.Start()
+	time.Sleep(50 * time.Millisecond)
+	queue.Close()
+	c.Assert(queue.Stats().Failures, Equals, int64(0))
+
+	// the write is applied once the cluster is back
+	self.failures = 0
+	queue, err = NewIntakeQueue(self.dir, self.apply)
+	c.Assert(err, IsNil)
+	c.Assert(queue.Stats().Depth, Equals, int64(1))
+	queue.Start()
+	self.waitForStats(c, queue, 1, 0)
+	queue.Close()
+	c.Assert(self.applied, DeepEquals, []string{"flaky"})
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"cluster"
+	. "common"
+	libhttp "net/http"
+	"strconv"
+)
+
+// Calls yield with the job in the url, the responses are the same as
+// the ones of tryAsClusterAdmin
+func (self *HttpServer) tryJob(w libhttp.ResponseWriter, r *libhttp.Request, yield func(User, *cluster.Job) (int, interface{})) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		id, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+		if err != nil {
+			return libhttp.StatusBadRequest, "Invalid job id"
+		}
+		job := self.clusterConfig.Jobs().Get(id)
+		if job == nil {
+			return libhttp.StatusNotFound, "Job not found"
+		}
+		return yield(u, job)
+	})
+}
+
+func (self *HttpServer) listJobs(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		return libhttp.StatusOK, self.clusterConfig.Jobs().List()
+	})
+}
+
+func (self *HttpServer) getJob(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryJob(w, r, func(u User, job *cluster.Job) (int, interface{}) {
+		return libhttp.StatusOK, job.Status()
+	})
+}
+
+// Asks the job to stop, it's cancelled once the operation stops
+func (self *HttpServer) cancelJob(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryJob(w, r, func(u User, job *cluster.Job) (int, interface{}) {
+		err := self.clusterConfig.Jobs().Cancel(job.Id())
+		self.audit(r, u.GetName(), "cancel_job", "", strconv.FormatUint(job.Id(), 10), err)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		return libhttp.StatusAccepted, job.Status()
+	})
+}
@@ -0,0 +1,236 @@
+package http
+
+import (
+	. "common"
+	"fmt"
+	libhttp "net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LOCKOUT_USER = "user"
+	LOCKOUT_IP   = "ip"
+)
+
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// A user or source ip that failed to authenticate
+type Lockout struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Failures    int    `json:"failures"`
+	LockedUntil int64  `json:"lockedUntil,omitempty"`
+}
+
+// Counts the failed authentications per username and source ip. After
+// maxFailures failures that are less than lockoutDuration apart the
+// user or ip is locked out for lockoutDuration, even with the right
+// password. The failures are only tracked by this server. The methods
+// of a nil LoginThrottle allow everything.
+type LoginThrottle struct {
+	maxFailures     int
+	lockoutDuration time.Duration
+	lock            sync.Mutex
+	failures        map[string]*loginFailures
+	lastPrune       time.Time
+}
+
+func NewLoginThrottle(maxFailures int, lockoutDuration time.Duration) *LoginThrottle {
+	return &LoginThrottle{
+		maxFailures:     maxFailures,
+		lockoutDuration: lockoutDuration,
+		failures:        make(map[string]*loginFailures),
+		lastPrune:       time.Now(),
+	}
+}
+
+func lockoutKey(kind, name string) string {
+	return kind + ":" + name
+}
+
+func (self *LoginThrottle) keys(username, ip string) []string {
+	keys := make([]string, 0, 2)
+	if username != "" {
+		keys = append(keys, lockoutKey(LOCKOUT_USER, username))
+	}
+	if ip != "" {
+		keys = append(keys, lockoutKey(LOCKOUT_IP, ip))
+	}
+	return keys
+}
+
+// drops the failures that are too old to count, must be called with
+// the lock held
+func (self *LoginThrottle) prune(now time.Time) {
+	if now.Sub(self.lastPrune) < self.lockoutDuration {
+		return
+	}
+	for key, failures := range self.failures {
+		if now.Sub(failures.last) > self.lockoutDuration && now.After(failures.lockedUntil) {
+			delete(self.failures, key)
+		}
+	}
+	self.lastPrune = now
+}
+
+// Returns how long the user or the ip is still locked out, 0 if
+// neither of them is
+func (self *LoginThrottle) Locked(username, ip string) time.Duration {
+	if self == nil {
+		return 0
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range self.keys(username, ip) {
+		failures := self.failures[key]
+		if failures == nil {
+			continue
+		}
+		if d := failures.lockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+func (self *LoginThrottle) Failed(username, ip string) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	now := time.Now()
+	self.prune(now)
+	for _, key := range self.keys(username, ip) {
+		failures := self.failures[key]
+		if failures == nil || now.Sub(failures.last) > self.lockoutDuration {
+			failures = &loginFailures{}
+			self.failures[key] = failures
+		}
+		failures.count++
+		failures.last = now
+		if failures.count >= self.maxFailures {
+			failures.lockedUntil = now.Add(self.lockoutDuration)
+		}
+	}
+}
+
+// Forgets the failures of the user, the failures of its ip still count
+// since anyone with a valid account could reset them otherwise
+func (self *LoginThrottle) Succeeded(username string) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.failures, lockoutKey(LOCKOUT_USER, username))
+}
+
+// Returns the users and ips that failed to authenticate recently
+func (self *LoginThrottle) Lockouts() []*Lockout {
+	lockouts := []*Lockout{}
+	if self == nil {
+		return lockouts
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	now := time.Now()
+	self.prune(now)
+	keys := make([]string, 0, len(self.failures))
+	for key := range self.failures {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		failures := self.failures[key]
+		lockout := &Lockout{Failures: failures.count}
+		if strings.HasPrefix(key, LOCKOUT_USER+":") {
+			lockout.Type, lockout.Name = LOCKOUT_USER, key[len(LOCKOUT_USER)+1:]
+		} else {
+			lockout.Type, lockout.Name = LOCKOUT_IP, key[len(LOCKOUT_IP)+1:]
+		}
+		if failures.lockedUntil.After(now) {
+			lockout.LockedUntil = failures.lockedUntil.Unix()
+		}
+		lockouts = append(lockouts, lockout)
+	}
+	return lockouts
+}
+
+// Forgets the failures of the user or ip, returns false if there
+// weren't any
+func (self *LoginThrottle) Clear(kind, name string) bool {
+	if self == nil {
+		return false
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	key := lockoutKey(kind, name)
+	if _, ok := self.failures[key]; !ok {
+		return false
+	}
+	delete(self.failures, key)
+	return true
+}
+
+// Locks out the users and ips for lockoutDuration after maxFailures
+// failed authentications, 0 disables the lockouts
+func (self *HttpServer) SetLoginLockout(maxFailures int, lockoutDuration time.Duration) {
+	self.loginThrottle = nil
+	if maxFailures > 0 && lockoutDuration > 0 {
+		self.loginThrottle = NewLoginThrottle(maxFailures, lockoutDuration)
+	}
+}
+
+// Returns the status code and message if the user or the ip of the
+// request is locked out, the status code is 0 otherwise
+func (self *HttpServer) checkLockout(r *libhttp.Request, username string) (int, string) {
+	if wait := self.loginThrottle.Locked(username, sourceIp(r)); wait > 0 {
+		return STATUS_TOO_MANY_REQUESTS, fmt.Sprintf("Too many failed logins, retry in %s", wait)
+	}
+	return 0, ""
+}
+
+// Records a failed authentication with the username and source ip of
+// the request
+func (self *HttpServer) authenticationFailed(r *libhttp.Request, message string) {
+	username, _, _ := getUsernameAndPassword(r)
+	self.loginThrottle.Failed(username, sourceIp(r))
+	self.auditAuthenticationFailure(r, username, message)
+}
+
+func (self *HttpServer) listLockouts(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		return libhttp.StatusOK, self.loginThrottle.Lockouts()
+	})
+}
+
+func (self *HttpServer) clearUserLockout(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.clearLockout(w, r, LOCKOUT_USER, r.URL.Query().Get(":name"))
+}
+
+func (self *HttpServer) clearIpLockout(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.clearLockout(w, r, LOCKOUT_IP, r.URL.Query().Get(":ip"))
+}
+
+func (self *HttpServer) clearLockout(w libhttp.ResponseWriter, r *libhttp.Request, kind, name string) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if !self.loginThrottle.Clear(kind, name) {
+			return libhttp.StatusNotFound, fmt.Sprintf("There are no failed logins for %s %s", kind, name)
+		}
+		self.audit(r, u.GetName(), "clear_lockout", "", lockoutKey(kind, name), nil)
+		return libhttp.StatusOK, nil
+	})
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type LoginThrottleSuite struct{}
+
+var _ = Suite(&LoginThrottleSuite{})
+
+func (self *LoginThrottleSuite) TestLockout(c *C) {
+	throttle := NewLoginThrottle(3, time.Minute)
+	for i := 0; i < 2; i++ {
+		throttle.Failed("alice", "10.0.0.1")
+	}
+	c.Assert(throttle.Locked("alice", "10.0.0.1"), Equals, time.Duration(0))
+
+	throttle.Failed("alice", "10.0.0.1")
+	c.Assert(throttle.Locked("alice", "10.0.0.2") > 0, Equals, true)
+	c.Assert(throttle.Locked("bob", "10.0.0.1") > 0, Equals, true)
+	c.Assert(throttle.Locked("bob", "10.0.0.2"), Equals, time.Duration(0))
+
+	lockouts := throttle.Lockouts()
+	c.Assert(lockouts, HasLen, 2)
+	c.Assert(lockouts[0].Type, Equals, LOCKOUT_IP)
+	c.Assert(lockouts[0].Name, Equals, "10.0.0.1")
+	c.Assert(lockouts[1].Type, Equals, LOCKOUT_USER)
+	c.Assert(lockouts[1].Name, Equals, "alice")
+	c.Assert(lockouts[1].Failures, Equals, 3)
+	c.Assert(lockouts[1].LockedUntil > time.Now().Unix(), Equals, true)
+
+	// a successful login only resets the failures of the user
+	throttle.Succeeded("alice")
+	c.Assert(throttle.Locked("alice", "10.0.0.2"), Equals, time.Duration(0))
+	c.Assert(throttle.Locked("alice", "10.0.0.1") > 0, Equals, true)
+	c.Assert(throttle.Clear(LOCKOUT_IP, "10.0.0.1"), Equals, true)
+	c.Assert(throttle.Clear(LOCKOUT_IP, "10.0.0.1"), Equals, false)
+	c.Assert(throttle.Locked("alice", "10.0.0.1"), Equals, time.Duration(0))
+}
+
+func (self *LoginThrottleSuite) TestNilThrottleAllowsEverything(c *C) {
+	var throttle *LoginThrottle
+	throttle.Failed("alice", "10.0.0.1")
+	c.Assert(throttle.Locked("alice", "10.0.0.1"), Equals, time.Duration(0))
+	c.Assert(throttle.Lockouts(), HasLen, 0)
+}
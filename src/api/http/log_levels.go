@@ -0,0 +1,58 @@
+package http
+
+import (
+	. "common"
+	"encoding/json"
+	"logging"
+	libhttp "net/http"
+	"time"
+)
+
+type logLevelOverride struct {
+	Module string `json:"module"`
+	Level  string `json:"level"`
+	// e.g. 10m, the level is kept until it's removed if it's empty
+	Duration string `json:"duration"`
+}
+
+func (self *HttpServer) listLogLevels(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		return libhttp.StatusOK, logging.Overrides()
+	})
+}
+
+func (self *HttpServer) overrideLogLevel(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		info := &logLevelOverride{}
+		if err := json.NewDecoder(r.Body).Decode(info); err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		var duration time.Duration
+		if info.Duration != "" {
+			var err error
+			duration, err = time.ParseDuration(info.Duration)
+			if err != nil || duration < 0 {
+				return libhttp.StatusBadRequest, "Invalid duration " + info.Duration
+			}
+		}
+		override, err := logging.OverrideLevel(info.Module, info.Level, duration)
+		self.audit(r, u.GetName(), "override_log_level", "", info.Module+" "+info.Level, err)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		return libhttp.StatusOK, override
+	})
+}
+
+// Restores the configured level of the module
+func (self *HttpServer) removeLogLevel(w libhttp.ResponseWriter, r *libhttp.Request) {
+	module := r.URL.Query().Get(":module")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if !logging.RemoveOverride(module) {
+			return libhttp.StatusNotFound, "The level of " + module + " wasn't changed"
+		}
+		self.audit(r, u.GetName(), "remove_log_level", "", module, nil)
+		return libhttp.StatusOK, nil
+	})
+}
@@ -1,8 +1,10 @@
 package http
 
 import (
+	"cluster"
 	"common"
 	"fmt"
+	"strings"
 )
 
 type Operation struct {
@@ -13,8 +15,13 @@ type Operation struct {
 }
 
 type MockDbUser struct {
-	Name    string
-	IsAdmin bool
+	Name        string
+	IsAdmin     bool
+	Credentials string
+}
+
+func (self MockDbUser) CredentialsFingerprint() string {
+	return self.Credentials
 }
 
 func (self MockDbUser) GetName() string {
@@ -45,10 +52,44 @@ func (self MockDbUser) HasReadAccess(_ string) bool {
 	return true
 }
 
+type MockClusterAdmin struct {
+	Name string
+}
+
+func (self MockClusterAdmin) GetName() string {
+	return self.Name
+}
+
+func (self MockClusterAdmin) IsDeleted() bool {
+	return false
+}
+
+func (self MockClusterAdmin) IsClusterAdmin() bool {
+	return true
+}
+
+func (self MockClusterAdmin) IsDbAdmin(_ string) bool {
+	return true
+}
+
+func (self MockClusterAdmin) GetDb() string {
+	return ""
+}
+
+func (self MockClusterAdmin) HasWriteAccess(_ string) bool {
+	return true
+}
+
+func (self MockClusterAdmin) HasReadAccess(_ string) bool {
+	return true
+}
+
 type MockUserManager struct {
 	dbUsers       map[string]map[string]MockDbUser
 	clusterAdmins []string
 	ops           []*Operation
+	// the fingerprint of the credentials of dbuser
+	credentials string
 }
 
 func (self *MockUserManager) AuthenticateDbUser(db, username, password string) (common.User, error) {
@@ -56,11 +97,19 @@ func (self *MockUserManager) AuthenticateDbUser(db, username, password string) (
 		return nil, fmt.Errorf("Invalid username/password")
 	}
 
+	if username == "restricted" {
+		return &cluster.DbUser{CommonUser: cluster.CommonUser{Name: username, AllowedNetworks: []string{"10.0.0.0/8"}}, Db: db}, nil
+	}
+
+	if username == "foo_writer" {
+		return &cluster.DbUser{CommonUser: cluster.CommonUser{Name: username}, Db: db, WriteTo: []*cluster.Matcher{&cluster.Matcher{Name: "foo"}}}, nil
+	}
+
 	if username != "dbuser" {
 		return nil, fmt.Errorf("Invalid username/password")
 	}
 
-	return nil, nil
+	return MockDbUser{Name: username, Credentials: self.credentials}, nil
 }
 
 func (self *MockUserManager) AuthenticateClusterAdmin(username, password string) (common.User, error) {
@@ -68,11 +117,64 @@ func (self *MockUserManager) AuthenticateClusterAdmin(username, password string)
 		return nil, fmt.Errorf("Invalid username/password")
 	}
 
+	if username == "totp_admin" {
+		return &cluster.ClusterAdmin{CommonUser: cluster.CommonUser{Name: username}, TotpSecret: "secret"}, nil
+	}
+
 	if username != "root" {
 		return nil, fmt.Errorf("Invalid username/password")
 	}
 
-	return nil, nil
+	return MockClusterAdmin{Name: username}, nil
+}
+
+func (self *MockUserManager) LookupDbUser(db, username string) (common.User, error) {
+	if username == "foo_writer" {
+		return &cluster.DbUser{CommonUser: cluster.CommonUser{Name: username}, Db: db, WriteTo: []*cluster.Matcher{&cluster.Matcher{Name: "foo"}}}, nil
+	}
+	if username != "dbuser" {
+		return nil, fmt.Errorf("Unknown user %s", username)
+	}
+	return MockDbUser{Name: username, Credentials: self.credentials}, nil
+}
+
+func (self *MockUserManager) LookupClusterAdmin(username string) (common.User, error) {
+	if username != "root" {
+		return nil, fmt.Errorf("Unknown cluster admin %s", username)
+	}
+	return MockClusterAdmin{Name: username}, nil
+}
+
+func (self *MockUserManager) AuthenticateApiKey(db, key string) (common.User, error) {
+	if key != "id.secret" {
+		return nil, fmt.Errorf("Invalid api key")
+	}
+	return MockDbUser{Name: "api_key:id"}, nil
+}
+
+func (self *MockUserManager) LookupApiKey(db, id string) (common.User, error) {
+	if id != "id" {
+		return nil, fmt.Errorf("Unknown api key %s", id)
+	}
+	return MockDbUser{Name: "api_key:id"}, nil
+}
+
+func (self *MockUserManager) CreateApiKey(requester common.User, name string, databases []string, read, write bool) (string, *cluster.ApiKey, error) {
+	if name == "" {
+		return "", nil, fmt.Errorf("Invalid empty name")
+	}
+
+	self.ops = append(self.ops, &Operation{"api_key_add", name, "", false})
+	return "id.secret", &cluster.ApiKey{Id: "id", Name: name, Hash: "hash", Databases: databases, Read: read, Write: write}, nil
+}
+
+func (self *MockUserManager) RevokeApiKey(requester common.User, id string) error {
+	self.ops = append(self.ops, &Operation{"api_key_del", id, "", false})
+	return nil
+}
+
+func (self *MockUserManager) ListApiKeys(requester common.User) ([]*cluster.ApiKey, error) {
+	return []*cluster.ApiKey{{Id: "id", Name: "automation", Hash: "hash", Databases: []string{"foo"}, Read: true}}, nil
 }
 
 func (self *MockUserManager) CreateClusterAdminUser(request common.User, username, password string) error {
@@ -94,6 +196,36 @@ func (self *MockUserManager) ChangeClusterAdminPassword(requester common.User, u
 	return nil
 }
 
+func (self *MockUserManager) SetClusterAdminAllowedNetworks(requester common.User, username string, networks []string) error {
+	self.ops = append(self.ops, &Operation{"cluster_admin_networks", username, strings.Join(networks, ","), false})
+	return nil
+}
+
+func (self *MockUserManager) EnrollClusterAdminTotp(requester common.User, username string) (string, error) {
+	self.ops = append(self.ops, &Operation{"cluster_admin_enroll_totp", username, "", false})
+	return "JBSWY3DPEHPK3PXP", nil
+}
+
+func (self *MockUserManager) ConfirmClusterAdminTotp(requester common.User, username, code string) ([]string, error) {
+	if code != "123456" {
+		return nil, common.NewAuthorizationError("Invalid totp code")
+	}
+	self.ops = append(self.ops, &Operation{"cluster_admin_confirm_totp", username, code, false})
+	return []string{"recovery"}, nil
+}
+
+func (self *MockUserManager) DisableClusterAdminTotp(requester common.User, username string) error {
+	self.ops = append(self.ops, &Operation{"cluster_admin_disable_totp", username, "", false})
+	return nil
+}
+
+func (self *MockUserManager) VerifyClusterAdminSecondFactor(username, code string) error {
+	if code != "123456" {
+		return common.NewAuthorizationError("Invalid second factor code")
+	}
+	return nil
+}
+
 func (self *MockUserManager) CreateDbUser(request common.User, db, username, password string) error {
 	if username == "" {
 		return fmt.Errorf("Invalid empty username")
@@ -113,11 +245,38 @@ func (self *MockUserManager) ChangeDbUserPassword(requester common.User, db, use
 	return nil
 }
 
+func (self *MockUserManager) DbUserPasswordFingerprint(requester common.User, db, username string) (string, error) {
+	return "fingerprint-" + username, nil
+}
+
+func (self *MockUserManager) ResetDbUserPassword(db, username, fingerprint, password string) error {
+	if fingerprint != "fingerprint-"+username {
+		return common.NewAuthorizationError("The password was changed since the reset token was issued")
+	}
+	self.ops = append(self.ops, &Operation{"db_user_reset_passwd", username, password, false})
+	return nil
+}
+
 func (self *MockUserManager) SetDbAdmin(requester common.User, db, username string, isAdmin bool) error {
 	self.ops = append(self.ops, &Operation{"db_user_admin", username, "", isAdmin})
 	return nil
 }
 
+func (self *MockUserManager) SetDbUserCanCreateDatabases(requester common.User, db, username string, canCreate bool) error {
+	self.ops = append(self.ops, &Operation{"db_user_create_databases", username, "", canCreate})
+	return nil
+}
+
+func (self *MockUserManager) SetDbUserAllowedNetworks(requester common.User, db, username string, networks []string) error {
+	self.ops = append(self.ops, &Operation{"db_user_networks", username, strings.Join(networks, ","), false})
+	return nil
+}
+
+func (self *MockUserManager) SetDbUserRoles(requester common.User, db, username string, roles []string) error {
+	self.ops = append(self.ops, &Operation{"db_user_roles", username, strings.Join(roles, ","), false})
+	return nil
+}
+
 func (self *MockUserManager) ListClusterAdmins(requester common.User) ([]string, error) {
 	return self.clusterAdmins, nil
 }
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"bytes"
+	. "common"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// A minimal MessagePack encoder, it only supports the types that can
+// show up in a serialized series.
+type msgpackEncoder struct {
+	buffer *bytes.Buffer
+}
+
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	encoder := &msgpackEncoder{bytes.NewBuffer(nil)}
+	if err := encoder.encode(v); err != nil {
+		return nil, err
+	}
+	return encoder.buffer.Bytes(), nil
+}
+
+func (self *msgpackEncoder) encode(v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		self.buffer.WriteByte(0xc0)
+	case bool:
+		if x {
+			self.buffer.WriteByte(0xc3)
+		} else {
+			self.buffer.WriteByte(0xc2)
+		}
+	case int:
+		self.encodeInt(int64(x))
+	case int64:
+		self.encodeInt(x)
+	case uint32:
+		self.encodeUint(uint64(x))
+	case uint64:
+		self.encodeUint(x)
+	case float64:
+		self.buffer.WriteByte(0xcb)
+		binary.Write(self.buffer, binary.BigEndian, math.Float64bits(x))
+	case string:
+		self.encodeString(x)
+	case []string:
+		self.encodeArrayHeader(len(x))
+		for _, s := range x {
+			self.encodeString(s)
+		}
+	case []interface{}:
+		self.encodeArrayHeader(len(x))
+		for _, value := range x {
+			if err := self.encode(value); err != nil {
+				return err
+			}
+		}
+	case [][]interface{}:
+		self.encodeArrayHeader(len(x))
+		for _, value := range x {
+			if err := self.encode(value); err != nil {
+				return err
+			}
+		}
+	case *SerializedSeries:
+		self.encodeMapHeader(3)
+		self.encodeString("name")
+		self.encodeString(x.Name)
+		self.encodeString("columns")
+		self.encode(x.Columns)
+		self.encodeString("points")
+		return self.encode(x.Points)
+	case []*SerializedSeries:
+		self.encodeArrayHeader(len(x))
+		for _, series := range x {
+			if err := self.encode(series); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Cannot encode %T to msgpack", v)
+	}
+	return nil
+}
+
+func (self *msgpackEncoder) encodeInt(x int64) {
+	if x >= 0 {
+		self.encodeUint(uint64(x))
+		return
+	}
+	switch {
+	case x >= -32:
+		self.buffer.WriteByte(byte(x))
+	case x >= math.MinInt8:
+		self.buffer.WriteByte(0xd0)
+		binary.Write(self.buffer, binary.BigEndian, int8(x))
+	case x >= math.MinInt16:
+		self.buffer.WriteByte(0xd1)
+		binary.Write(self.buffer, binary.BigEndian, int16(x))
+	case x >= math.MinInt32:
+		self.buffer.WriteByte(0xd2)
+		binary.Write(self.buffer, binary.BigEndian, int32(x))
+	default:
+		self.buffer.WriteByte(0xd3)
+		binary.Write(self.buffer, binary.BigEndian, x)
+	}
+}
+
+func (self *msgpackEncoder) encodeUint(x uint64) {
+	switch {
+	case x <= 0x7f:
+		self.buffer.WriteByte(byte(x))
+	case x <= math.MaxUint8:
+		self.buffer.WriteByte(0xcc)
+		self.buffer.WriteByte(byte(x))
+	case x <= math.MaxUint16:
+		self.buffer.WriteByte(0xcd)
+		binary.Write(self.buffer, binary.BigEndian, uint16(x))
+	case x <= math.MaxUint32:
+		self.buffer.WriteByte(0xce)
+		binary.Write(self.buffer, binary.BigEndian, uint32(x))
+	default:
+		self.buffer.WriteByte(0xcf)
+		binary.Write(self.buffer, binary.BigEndian, x)
+	}
+}
+
+func (self *msgpackEncoder) encodeString(s string) {
+	length := len(s)
+	switch {
+	case length < 32:
+		self.buffer.WriteByte(0xa0 | byte(length))
+	case length <= math.MaxUint8:
+		self.buffer.WriteByte(0xd9)
+		self.buffer.WriteByte(byte(length))
+	case length <= math.MaxUint16:
+		self.buffer.WriteByte(0xda)
+		binary.Write(self.buffer, binary.BigEndian, uint16(length))
+	default:
+		self.buffer.WriteByte(0xdb)
+		binary.Write(self.buffer, binary.BigEndian, uint32(length))
+	}
+	self.buffer.WriteString(s)
+}
+
+func (self *msgpackEncoder) encodeArrayHeader(length int) {
+	switch {
+	case length < 16:
+		self.buffer.WriteByte(0x90 | byte(length))
+	case length <= math.MaxUint16:
+		self.buffer.WriteByte(0xdc)
+		binary.Write(self.buffer, binary.BigEndian, uint16(length))
+	default:
+		self.buffer.WriteByte(0xdd)
+		binary.Write(self.buffer, binary.BigEndian, uint32(length))
+	}
+}
+
+func (self *msgpackEncoder) encodeMapHeader(length int) {
+	switch {
+	case length < 16:
+		self.buffer.WriteByte(0x80 | byte(length))
+	case length <= math.MaxUint16:
+		self.buffer.WriteByte(0xde)
+		binary.Write(self.buffer, binary.BigEndian, uint16(length))
+	default:
+		self.buffer.WriteByte(0xdf)
+		binary.Write(self.buffer, binary.BigEndian, uint32(length))
+	}
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	. "common"
+	. "launchpad.net/gocheck"
+)
+
+type MsgpackSuite struct{}
+
+var _ = Suite(&MsgpackSuite{})
+
+func (self *MsgpackSuite) TestEncodingScalars(c *C) {
+	for _, test := range []struct {
+		value    interface{}
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{false, []byte{0xc2}},
+		{int64(1), []byte{0x01}},
+		{int64(-1), []byte{0xff}},
+		{int64(-100), []byte{0xd0, 0x9c}},
+		{int64(300), []byte{0xcd, 0x01, 0x2c}},
+		{uint64(1 << 32), []byte{0xcf, 0, 0, 0, 1, 0, 0, 0, 0}},
+		{float64(1.5), []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"foo", []byte{0xa3, 'f', 'o', 'o'}},
+	} {
+		data, err := marshalMsgpack(test.value)
+		c.Assert(err, IsNil)
+		c.Assert(data, DeepEquals, test.expected)
+	}
+}
+
+func (self *MsgpackSuite) TestEncodingSeries(c *C) {
+	series := &SerializedSeries{
+		Name:    "foo",
+		Columns: []string{"time", "value"},
+		Points:  [][]interface{}{{int64(1), "bar"}},
+	}
+	data, err := marshalMsgpack([]*SerializedSeries{series})
+	c.Assert(err, IsNil)
+	expected := []byte{
+		0x91, 0x83,
+		0xa4, 'n', 'a', 'm', 'e', 0xa3, 'f', 'o', 'o',
+		0xa7, 'c', 'o', 'l', 'u', 'm', 'n', 's', 0x92, 0xa4, 't', 'i', 'm', 'e', 0xa5, 'v', 'a', 'l', 'u', 'e',
+		0xa6, 'p', 'o', 'i', 'n', 't', 's', 0x91, 0x92, 0x01, 0xa3, 'b', 'a', 'r',
+	}
+	c.Assert(data, DeepEquals, expected)
+}
+
+func (self *MsgpackSuite) TestEncodingUnsupportedType(c *C) {
+	_, err := marshalMsgpack(struct{}{})
+	c.Assert(err, NotNil)
+}
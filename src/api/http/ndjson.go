@@ -0,0 +1,41 @@
+package http
+
+import (
+	"bytes"
+	. "common"
+	"encoding/json"
+	"fmt"
+)
+
+// Encodes every point as a series with a single point on its own line.
+// The lines are written as the points come out of the query engine,
+// and since every line has the time and sequence number of the point a
+// client can resume an export from the last line it received.
+func marshalNdjson(v interface{}) ([]byte, error) {
+	var seriesList []*SerializedSeries
+	switch x := v.(type) {
+	case *SerializedSeries:
+		seriesList = []*SerializedSeries{x}
+	case []*SerializedSeries:
+		seriesList = x
+	default:
+		return nil, fmt.Errorf("Cannot encode %T to ndjson", v)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	encoder := json.NewEncoder(buffer)
+	for _, series := range seriesList {
+		for _, point := range series.Points {
+			line := &SerializedSeries{
+				Name:    series.Name,
+				Columns: series.Columns,
+				Points:  [][]interface{}{point},
+			}
+			// Encode terminates every value with a newline
+			if err := encoder.Encode(line); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,227 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	libhttp "net/http"
+	"parser"
+	"protocol"
+	"time"
+)
+
+// The header of the query responses that has the cursor of the next
+// page, it's missing if there are no more pages
+const CURSOR_HEADER = "X-Influxdb-Cursor"
+
+// The last point of a series that was returned to the client
+type seriesPosition struct {
+	Time     int64  `json:"t"`
+	Sequence uint64 `json:"s"`
+	// true if the series doesn't have any points left
+	Done bool `json:"d,omitempty"`
+}
+
+// The continuation token of a paginated query. It's opaque to the
+// clients, they send it back in the cursor parameter as is.
+type queryCursor struct {
+	QueryHash uint32                     `json:"q"`
+	Series    map[string]*seriesPosition `json:"s"`
+}
+
+func hashQuery(query string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(query))
+	return h.Sum32()
+}
+
+func (self *queryCursor) encode() (string, error) {
+	data, err := json.Marshal(self)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+func decodeQueryCursor(s string) (*queryCursor, error) {
+	data, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+	cursor := &queryCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+	if cursor.Series == nil {
+		cursor.Series = map[string]*seriesPosition{}
+	}
+	return cursor, nil
+}
+
+// Pages through the results of a raw select query with a limit. Every
+// page runs the query on the time range that's left after the last
+// point returned for the series that aren't done yet and drops the
+// points that were already returned, so points that are written while
+// the client is paging don't shift the pages. A series is done once it
+// returns less points than the limit. The query can't make progress if
+// more points than the limit share the same timestamp in a series.
+type queryPager struct {
+	query     string
+	queryHash uint32
+	limit     int
+	ascending bool
+	previous  *queryCursor
+	counts    map[string]int
+	last      map[string]*seriesPosition
+}
+
+// Returns nil if the request doesn't ask for pagination, i.e. it has
+// neither paginate=true nor a cursor
+func newQueryPager(r *libhttp.Request, query string) (*queryPager, error) {
+	params := r.URL.Query()
+	cursorParam := params.Get("cursor")
+	if params.Get("paginate") != "true" && cursorParam == "" {
+		return nil, nil
+	}
+
+	queries, err := parser.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) != 1 || queries[0].SelectQuery == nil {
+		return nil, fmt.Errorf("Only a single select query can be paginated")
+	}
+	selectQuery := queries[0].SelectQuery
+	if selectQuery.Limit <= 0 || selectQuery.HasAggregates() || selectQuery.IntoClause != nil {
+		return nil, fmt.Errorf("Only raw select queries with a limit can be paginated")
+	}
+
+	self := &queryPager{
+		query:     query,
+		queryHash: hashQuery(query),
+		limit:     selectQuery.Limit,
+		ascending: selectQuery.Ascending,
+		previous:  &queryCursor{Series: map[string]*seriesPosition{}},
+		counts:    map[string]int{},
+		last:      map[string]*seriesPosition{},
+	}
+	if cursorParam == "" {
+		return self, nil
+	}
+
+	cursor, err := decodeQueryCursor(cursorParam)
+	if err != nil {
+		return nil, err
+	}
+	if cursor.QueryHash != self.queryHash {
+		return nil, fmt.Errorf("The cursor belongs to a different query")
+	}
+	self.previous = cursor
+
+	startTime, endTime := selectQuery.GetStartTime(), selectQuery.GetEndTime()
+	if bound, ok := self.bound(); ok {
+		if self.ascending {
+			if start := time.Unix(0, (bound-1)*1000); start.After(startTime) {
+				startTime = start
+			}
+		} else {
+			if end := time.Unix(0, (bound+1)*1000); end.Before(endTime) {
+				endTime = end
+			}
+		}
+	}
+	self.query = selectQuery.GetQueryStringWithTimes(startTime, endTime)
+	return self, nil
+}
+
+// Returns the position in microseconds the next page starts at, that's
+// the position of the series that's the furthest behind
+func (self *queryPager) bound() (int64, bool) {
+	var bound int64
+	found := false
+	for _, position := range self.previous.Series {
+		if position.Done {
+			continue
+		}
+		if !found || (self.ascending && position.Time < bound) || (!self.ascending && position.Time > bound) {
+			bound = position.Time
+			found = true
+		}
+	}
+	return bound, found
+}
+
+// Returns true if the point comes after the position in the order of
+// the query
+func (self *queryPager) isAfter(point *protocol.Point, position *seriesPosition) bool {
+	timestamp, sequence := point.GetTimestamp(), point.GetSequenceNumber()
+	if self.ascending {
+		return timestamp > position.Time || (timestamp == position.Time && sequence > position.Sequence)
+	}
+	return timestamp < position.Time || (timestamp == position.Time && sequence < position.Sequence)
+}
+
+// Records the position of the series and drops the points that were
+// returned in the previous pages
+func (self *queryPager) filter(series *protocol.Series) *protocol.Series {
+	name := series.GetName()
+	previous := self.previous.Series[name]
+	self.counts[name] += len(series.Points)
+	if self.last[name] == nil && previous != nil {
+		self.last[name] = &seriesPosition{Time: previous.Time, Sequence: previous.Sequence}
+	}
+	for _, point := range series.Points {
+		last := self.last[name]
+		if last == nil || self.isAfter(point, last) {
+			self.last[name] = &seriesPosition{Time: point.GetTimestamp(), Sequence: point.GetSequenceNumber()}
+		}
+	}
+
+	if previous == nil {
+		return series
+	}
+	points := make([]*protocol.Point, 0, len(series.Points))
+	for _, point := range series.Points {
+		if !previous.Done && self.isAfter(point, previous) {
+			points = append(points, point)
+		}
+	}
+	series.Points = points
+	return series
+}
+
+// Returns the cursor of the next page or an empty string if all the
+// series are done
+func (self *queryPager) nextCursor() (string, error) {
+	cursor := &queryCursor{QueryHash: self.queryHash, Series: map[string]*seriesPosition{}}
+	hasMore := false
+	for name, position := range self.previous.Series {
+		if position.Done {
+			cursor.Series[name] = position
+		}
+	}
+	for name, count := range self.counts {
+		if previous := self.previous.Series[name]; previous != nil && previous.Done {
+			continue
+		}
+		position := self.last[name]
+		if position == nil {
+			position = &seriesPosition{}
+		}
+		position.Done = count < self.limit
+		hasMore = hasMore || !position.Done
+		cursor.Series[name] = position
+	}
+	// the series that didn't return any points are done
+	for name, position := range self.previous.Series {
+		if _, ok := cursor.Series[name]; !ok {
+			cursor.Series[name] = &seriesPosition{Time: position.Time, Sequence: position.Sequence, Done: true}
+		}
+	}
+
+	if !hasMore {
+		return "", nil
+	}
+	return cursor.encode()
+}
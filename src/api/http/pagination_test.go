@@ -0,0 +1,110 @@
+package http
+
+import (
+	"protocol"
+
+	"code.google.com/p/goprotobuf/proto"
+	. "launchpad.net/gocheck"
+)
+
+type PaginationSuite struct{}
+
+var _ = Suite(&PaginationSuite{})
+
+func paginationSeries(name string, points ...int64) *protocol.Series {
+	series := &protocol.Series{Name: proto.String(name), Fields: []string{"value"}}
+	for i := 0; i < len(points); i += 2 {
+		series.Points = append(series.Points, &protocol.Point{
+			Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(1)}},
+			Timestamp:      proto.Int64(points[i]),
+			SequenceNumber: proto.Uint64(uint64(points[i+1])),
+		})
+	}
+	return series
+}
+
+func newTestPager(limit int, cursor string) *queryPager {
+	pager := &queryPager{
+		queryHash: hashQuery("select * from foo limit 2"),
+		limit:     limit,
+		previous:  &queryCursor{Series: map[string]*seriesPosition{}},
+		counts:    map[string]int{},
+		last:      map[string]*seriesPosition{},
+	}
+	if cursor != "" {
+		previous, err := decodeQueryCursor(cursor)
+		if err != nil {
+			panic(err)
+		}
+		pager.previous = previous
+	}
+	return pager
+}
+
+func (self *PaginationSuite) TestCursorRoundTrip(c *C) {
+	cursor := &queryCursor{QueryHash: 42, Series: map[string]*seriesPosition{"foo": {Time: 10, Sequence: 2}}}
+	encoded, err := cursor.encode()
+	c.Assert(err, IsNil)
+	decoded, err := decodeQueryCursor(encoded)
+	c.Assert(err, IsNil)
+	c.Assert(decoded, DeepEquals, cursor)
+
+	_, err = decodeQueryCursor("not a cursor")
+	c.Assert(err, NotNil)
+}
+
+func (self *PaginationSuite) TestPagesDescending(c *C) {
+	pager := newTestPager(2, "")
+	c.Assert(pager.filter(paginationSeries("foo", 30, 1, 20, 1)).Points, HasLen, 2)
+	c.Assert(pager.filter(paginationSeries("bar", 25, 1)).Points, HasLen, 1)
+	cursor, err := pager.nextCursor()
+	c.Assert(err, IsNil)
+	c.Assert(cursor, Not(Equals), "")
+
+	pager = newTestPager(2, cursor)
+	bound, ok := pager.bound()
+	c.Assert(ok, Equals, true)
+	c.Assert(bound, Equals, int64(20))
+	// a point was written at the timestamp of the last point, it comes
+	// after the last point because its sequence number is lower
+	series := pager.filter(paginationSeries("foo", 20, 1, 20, 0))
+	c.Assert(series.Points, HasLen, 1)
+	c.Assert(series.Points[0].GetSequenceNumber(), Equals, uint64(0))
+	// bar is done, its points are dropped
+	c.Assert(pager.filter(paginationSeries("bar", 15, 1)).Points, HasLen, 0)
+	cursor, err = pager.nextCursor()
+	c.Assert(err, IsNil)
+	c.Assert(cursor, Not(Equals), "")
+
+	pager = newTestPager(2, cursor)
+	c.Assert(pager.filter(paginationSeries("foo", 20, 0, 10, 1)).Points, HasLen, 1)
+	cursor, err = pager.nextCursor()
+	c.Assert(err, IsNil)
+	c.Assert(cursor, Not(Equals), "")
+
+	// foo doesn't return any points, there are no pages left
+	pager = newTestPager(2, cursor)
+	cursor, err = pager.nextCursor()
+	c.Assert(err, IsNil)
+	c.Assert(cursor, Equals, "")
+}
+
+func (self *PaginationSuite) TestPagesAscending(c *C) {
+	pager := newTestPager(2, "")
+	pager.ascending = true
+	c.Assert(pager.filter(paginationSeries("foo", 10, 1, 20, 1)).Points, HasLen, 2)
+	cursor, err := pager.nextCursor()
+	c.Assert(err, IsNil)
+
+	pager = newTestPager(2, cursor)
+	pager.ascending = true
+	bound, _ := pager.bound()
+	c.Assert(bound, Equals, int64(20))
+	series := pager.filter(paginationSeries("foo", 20, 1, 30, 1))
+	c.Assert(series.Points, HasLen, 1)
+	c.Assert(series.Points[0].GetTimestamp(), Equals, int64(30))
+	cursor, err = pager.nextCursor()
+	c.Assert(err, IsNil)
+	c.Assert(cursor, Not(Equals), "")
+	c.Assert(pager.last["foo"].Time, Equals, int64(30))
+}
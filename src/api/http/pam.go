@@ -0,0 +1,76 @@
+package http
+
+import (
+	"fmt"
+	libhttp "net/http"
+
+	log "code.google.com/p/log4go"
+)
+
+const (
+	// set on every request to the listener that received it, it
+	// replaces any value sent by the client
+	LISTENER_HEADER = "X-Influxdb-Listener"
+
+	HTTP_LISTENER  = "http"
+	HTTPS_LISTENER = "https"
+	UNIX_LISTENER  = "unix"
+)
+
+// Returns the listener that received the request
+func requestListener(r *libhttp.Request) string {
+	return r.Header.Get(LISTENER_HEADER)
+}
+
+func listenerHandler(name string, handler libhttp.Handler) libhttp.Handler {
+	return libhttp.HandlerFunc(func(w libhttp.ResponseWriter, r *libhttp.Request) {
+		r.Header.Set(LISTENER_HEADER, name)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Verifies the passwords of the requests received by the given
+// listeners with PAM using the given service. The users still have to
+// exist, PAM replaces their passwords but their permissions come from
+// the cluster. The passwords are checked against the cluster if PAM
+// rejects them. It's an error if the server was built without PAM
+// support.
+func (self *HttpServer) EnablePam(service string, listeners []string) error {
+	if len(listeners) == 0 {
+		return nil
+	}
+	for _, listener := range listeners {
+		switch listener {
+		case HTTP_LISTENER, HTTPS_LISTENER, UNIX_LISTENER:
+		default:
+			return fmt.Errorf("Unknown listener %s, it has to be %s, %s or %s", listener, HTTP_LISTENER, HTTPS_LISTENER, UNIX_LISTENER)
+		}
+	}
+	authenticate, err := newPamAuthenticator(service)
+	if err != nil {
+		return err
+	}
+	log.Info("Authenticating the requests to %v with pam service %s", listeners, service)
+	self.pamAuthenticate = authenticate
+	self.pamListeners = listeners
+	return nil
+}
+
+// Returns true if the password of the user was verified by PAM
+func (self *HttpServer) pamAccepts(r *libhttp.Request, username, password string) bool {
+	if self.pamAuthenticate == nil || password == "" {
+		return false
+	}
+	listener := requestListener(r)
+	for _, pamListener := range self.pamListeners {
+		if pamListener != listener {
+			continue
+		}
+		if err := self.pamAuthenticate(username, password); err != nil {
+			log.Debug("Pam rejected %s: %s", username, err)
+			return false
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+// build this file if the pam tag is specified, it needs libpam
+
+// +build pam
+
+package http
+
+import (
+	"errors"
+
+	"github.com/msteinert/pam"
+)
+
+func newPamAuthenticator(service string) (func(username, password string) error, error) {
+	return func(username, password string) error {
+		transaction, err := pam.StartFunc(service, username, func(style pam.Style, message string) (string, error) {
+			switch style {
+			case pam.PromptEchoOff, pam.PromptEchoOn:
+				return password, nil
+			case pam.ErrorMsg, pam.TextInfo:
+				return "", nil
+			}
+			return "", errors.New("Unsupported pam message style")
+		})
+		if err != nil {
+			return err
+		}
+		if err := transaction.Authenticate(0); err != nil {
+			return err
+		}
+		// checks that the account isn't expired or locked
+		return transaction.AcctMgmt(0)
+	}, nil
+}
@@ -0,0 +1,11 @@
+// +build !pam
+
+package http
+
+import (
+	"fmt"
+)
+
+func newPamAuthenticator(service string) (func(username, password string) error, error) {
+	return nil, fmt.Errorf("InfluxDB was built without pam support, build it with pam=on")
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"fmt"
+	libhttp "net/http"
+	"net/http/httptest"
+
+	. "launchpad.net/gocheck"
+)
+
+type PamSuite struct{}
+
+var _ = Suite(&PamSuite{})
+
+func (self *PamSuite) TestPamIsUsedOnTheSelectedListeners(c *C) {
+	server := &HttpServer{
+		pamAuthenticate: func(username, password string) error {
+			if username == "alice" && password == "secret" {
+				return nil
+			}
+			return fmt.Errorf("Authentication failure")
+		},
+		pamListeners: []string{HTTPS_LISTENER},
+	}
+
+	var received *libhttp.Request
+	handler := listenerHandler(HTTPS_LISTENER, libhttp.HandlerFunc(func(w libhttp.ResponseWriter, r *libhttp.Request) {
+		received = r
+	}))
+	r, err := libhttp.NewRequest("GET", "/ping", nil)
+	c.Assert(err, IsNil)
+	// the header sent by the client is replaced
+	r.Header.Set(LISTENER_HEADER, HTTP_LISTENER)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	c.Assert(requestListener(received), Equals, HTTPS_LISTENER)
+
+	c.Assert(server.pamAccepts(received, "alice", "secret"), Equals, true)
+	c.Assert(server.pamAccepts(received, "alice", "wrong"), Equals, false)
+	c.Assert(server.pamAccepts(received, "alice", ""), Equals, false)
+
+	received.Header.Set(LISTENER_HEADER, HTTP_LISTENER)
+	c.Assert(server.pamAccepts(received, "alice", "secret"), Equals, false)
+}
+
+func (self *PamSuite) TestEnablePamChecksTheListeners(c *C) {
+	server := &HttpServer{}
+	c.Assert(server.EnablePam("influxdb", nil), IsNil)
+	c.Assert(server.pamAuthenticate, IsNil)
+	c.Assert(server.EnablePam("influxdb", []string{"ftp"}), NotNil)
+}
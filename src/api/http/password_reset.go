@@ -0,0 +1,101 @@
+package http
+
+import (
+	. "common"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	libhttp "net/http"
+	"time"
+)
+
+const DEFAULT_PASSWORD_RESET_TTL = 24 * time.Hour
+
+type passwordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Sets how long the password reset tokens are valid for by default,
+// admins can ask for a shorter ttl
+func (self *HttpServer) SetPasswordResetTtl(ttl time.Duration) {
+	self.passwordResetTtl = ttl
+}
+
+// Issues a token that the db user can redeem once to set a new
+// password, so admins don't have to pick passwords for their users
+func (self *HttpServer) issuePasswordResetToken(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+	username := r.URL.Query().Get(":user")
+
+	self.tryAsDbUserAndClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if self.tokenIssuer == nil {
+			return libhttp.StatusNotFound, "Tokens aren't enabled"
+		}
+		ttl := self.passwordResetTtl
+		if ttl <= 0 {
+			ttl = DEFAULT_PASSWORD_RESET_TTL
+		}
+		if value := r.URL.Query().Get("ttl"); value != "" {
+			requested, err := time.ParseDuration(value)
+			if err != nil || requested <= 0 {
+				return libhttp.StatusBadRequest, fmt.Sprintf("Invalid ttl %s", value)
+			}
+			if requested < ttl {
+				ttl = requested
+			}
+		}
+
+		fingerprint, err := self.userManager.DbUserPasswordFingerprint(u, db, username)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		token, claims, err := self.tokenIssuer.IssuePasswordReset(username, db, fingerprint, ttl)
+		self.audit(r, u.GetName(), "issue_password_reset_token", db, username, err)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		return libhttp.StatusOK, &tokenResponse{token, claims.ExpiresAt}
+	})
+}
+
+// Sets the password of the user the reset token was issued for, the
+// request doesn't need any other credentials
+func (self *HttpServer) resetPassword(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+	statusCode, body := self.doResetPassword(r, db)
+	w.WriteHeader(statusCode)
+	if body != "" {
+		w.Write([]byte(body))
+	}
+}
+
+func (self *HttpServer) doResetPassword(r *libhttp.Request, db string) (int, string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return libhttp.StatusInternalServerError, err.Error()
+	}
+	request := &passwordResetRequest{}
+	if err := json.Unmarshal(data, request); err != nil {
+		return libhttp.StatusBadRequest, err.Error()
+	}
+	if self.tokenIssuer == nil {
+		return libhttp.StatusNotFound, "Tokens aren't enabled"
+	}
+
+	claims, err := self.tokenIssuer.Verify(request.Token)
+	if err == nil && (claims.Purpose != PASSWORD_RESET_PURPOSE || claims.Database != db) {
+		err = fmt.Errorf("The token isn't a password reset token for database %s", db)
+	}
+	if err != nil {
+		self.audit(r, "", "reset_password", db, "", err)
+		return libhttp.StatusUnauthorized, err.Error()
+	}
+
+	err = self.userManager.ResetDbUserPassword(db, claims.Subject, claims.PasswordFingerprint, request.Password)
+	self.audit(r, claims.Subject, "reset_password", db, claims.Subject, err)
+	if err != nil {
+		return errorToStatusCode(err), err.Error()
+	}
+	return libhttp.StatusOK, ""
+}
@@ -0,0 +1,36 @@
+package http
+
+import (
+	. "common"
+	libhttp "net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/bmizerany/pat"
+)
+
+// Serves the profiles of net/http/pprof on /debug/pprof to the cluster
+// admins. blockProfileRate is passed to runtime.SetBlockProfileRate,
+// the block profile is empty if it's 0.
+func (self *HttpServer) EnablePprof(blockProfileRate int) {
+	self.pprofEnabled = true
+	runtime.SetBlockProfileRate(blockProfileRate)
+}
+
+func (self *HttpServer) registerPprofEndpoints(p *pat.PatternServeMux) {
+	self.registerEndpoint(p, "get", "/debug/pprof/cmdline", self.pprofHandler(pprof.Cmdline))
+	self.registerEndpoint(p, "get", "/debug/pprof/profile", self.pprofHandler(pprof.Profile))
+	self.registerEndpoint(p, "get", "/debug/pprof/symbol", self.pprofHandler(pprof.Symbol))
+	self.registerEndpoint(p, "post", "/debug/pprof/symbol", self.pprofHandler(pprof.Symbol))
+	// the index and the named profiles, e.g. heap, goroutine and block
+	self.registerEndpoint(p, "get", "/debug/pprof/", self.pprofHandler(pprof.Index))
+}
+
+func (self *HttpServer) pprofHandler(handler libhttp.HandlerFunc) libhttp.HandlerFunc {
+	return func(w libhttp.ResponseWriter, r *libhttp.Request) {
+		self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+			handler(w, r)
+			return -1, nil
+		})
+	}
+}
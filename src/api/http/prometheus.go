@@ -0,0 +1,334 @@
+package http
+
+import (
+	"bytes"
+	. "common"
+	"fmt"
+	"io/ioutil"
+	"math"
+	libhttp "net/http"
+	"protocol"
+	"regexp"
+	"sort"
+	"strings"
+
+	"code.google.com/p/goprotobuf/proto"
+	"code.google.com/p/snappy-go/snappy"
+)
+
+// the label that has the name of the metric in prometheus
+const PROMETHEUS_METRIC_NAME_LABEL = "__name__"
+
+// The labels named like the columns of the sample, its time and its
+// sequence number are stored in a column with this prefix. The labels
+// that already start with the prefix get another one, that way every
+// column can be mapped back to its label.
+const PROMETHEUS_LABEL_COLUMN_PREFIX = "label_"
+
+func prometheusLabelColumn(label string) string {
+	switch label {
+	case "value", "time", "sequence_number":
+		return PROMETHEUS_LABEL_COLUMN_PREFIX + label
+	}
+	if strings.HasPrefix(label, PROMETHEUS_LABEL_COLUMN_PREFIX) {
+		return PROMETHEUS_LABEL_COLUMN_PREFIX + label
+	}
+	return label
+}
+
+func prometheusColumnLabel(column string) string {
+	return strings.TrimPrefix(column, PROMETHEUS_LABEL_COLUMN_PREFIX)
+}
+
+// Implements prometheus' remote_write protocol. The body is a snappy
+// compressed WriteRequest, every time series is written to the series
+// named after the metric and the labels are stored in columns.
+func (self *HttpServer) prometheusWrite(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+
+	self.tryAsDbUserAndClusterAdmin(w, r, func(user User) (int, interface{}) {
+		compressed, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		request := &protocol.PrometheusWriteRequest{}
+		if err := proto.Unmarshal(data, request); err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+
+		points := 0
+		for _, ts := range request.Timeseries {
+			points += len(ts.Samples)
+		}
+		if statusCode, body := self.checkPointsPerWrite(points); statusCode != 0 {
+			return statusCode, body
+		}
+
+		series, err := convertPrometheusTimeSeries(request.Timeseries)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		if len(series) == 0 {
+			return libhttp.StatusNoContent, nil
+		}
+		if statusCode, body := self.checkWriteRateLimits(w, user, db, series); statusCode != 0 {
+			return statusCode, body
+		}
+		if err := self.coordinator.WriteSeriesData(user, db, series); err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusNoContent, nil
+	})
+}
+
+func convertPrometheusTimeSeries(timeseries []*protocol.PrometheusTimeSeries) ([]*protocol.Series, error) {
+	series := make([]*protocol.Series, 0, len(timeseries))
+	for _, ts := range timeseries {
+		name := ""
+		labels := make(map[string]string)
+		for _, label := range ts.Labels {
+			if label.GetName() == PROMETHEUS_METRIC_NAME_LABEL {
+				name = label.GetValue()
+				continue
+			}
+			labels[prometheusLabelColumn(label.GetName())] = label.GetValue()
+		}
+		if name == "" {
+			return nil, fmt.Errorf("Time series without a %s label", PROMETHEUS_METRIC_NAME_LABEL)
+		}
+
+		fields := make([]string, 0, len(labels)+1)
+		for label := range labels {
+			fields = append(fields, label)
+		}
+		sort.Strings(fields)
+
+		points := make([]*protocol.Point, 0, len(ts.Samples))
+		for _, sample := range ts.Samples {
+			value := sample.GetValue()
+			// prometheus uses NaN to mark stale series
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			values := make([]*protocol.FieldValue, 0, len(fields)+1)
+			for _, field := range fields {
+				values = append(values, &protocol.FieldValue{StringValue: protocol.String(labels[field])})
+			}
+			values = append(values, &protocol.FieldValue{DoubleValue: &value})
+			timestamp := sample.GetTimestamp() * 1000
+			points = append(points, &protocol.Point{Timestamp: &timestamp, Values: values})
+		}
+		if len(points) == 0 {
+			continue
+		}
+
+		series = append(series, &protocol.Series{
+			Name:   protocol.String(name),
+			Fields: append(fields, "value"),
+			Points: points,
+		})
+	}
+	return series, nil
+}
+
+// Implements prometheus' remote_read protocol. Every query in the
+// snappy compressed ReadRequest is translated to a select on the
+// series that match the metric name with a where condition for the
+// other label matchers.
+func (self *HttpServer) prometheusRead(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+
+	self.tryAsDbUserAndClusterAdmin(w, r, func(user User) (int, interface{}) {
+		compressed, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		request := &protocol.PrometheusReadRequest{}
+		if err := proto.Unmarshal(data, request); err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		if statusCode, body := self.checkQueryRateLimits(w, user, db, len(request.Queries)); statusCode != 0 {
+			return statusCode, body
+		}
+
+		response := &protocol.PrometheusReadResponse{}
+		for _, query := range request.Queries {
+			queryString, nameFilter, err := prometheusQueryString(query)
+			if err != nil {
+				return libhttp.StatusBadRequest, err.Error()
+			}
+			result := newPrometheusQueryResult(nameFilter)
+			err = self.coordinator.RunQuery(user, db, queryString, NewSeriesWriter(result.yield))
+			if err != nil {
+				return errorToStatusCode(err), err.Error()
+			}
+			response.Results = append(response.Results, result.result())
+		}
+
+		data, err = proto.Marshal(response)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		compressed, err = snappy.Encode(nil, data)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(libhttp.StatusOK)
+		w.Write(compressed)
+		return -1, nil
+	})
+}
+
+// Returns the query for the given prometheus query. Matchers on the
+// metric name that can't be expressed in the from clause are returned
+// as a function that filters the series names.
+func prometheusQueryString(query *protocol.PrometheusQuery) (string, func(string) bool, error) {
+	from := "/.*/"
+	nameFilter := func(string) bool { return true }
+	conditions := []string{
+		fmt.Sprintf("time > %du", query.GetStartTimestampMs()*1000-1),
+		fmt.Sprintf("time < %du", query.GetEndTimestampMs()*1000+1),
+	}
+
+	for _, matcher := range query.Matchers {
+		name, value := matcher.GetName(), matcher.GetValue()
+		if name == PROMETHEUS_METRIC_NAME_LABEL {
+			switch matcher.GetType() {
+			case protocol.PrometheusLabelMatcher_EQ:
+				from = prometheusRegex(regexp.QuoteMeta(value))
+			case protocol.PrometheusLabelMatcher_RE:
+				from = prometheusRegex(value)
+			case protocol.PrometheusLabelMatcher_NEQ:
+				nameFilter = func(name string) bool { return name != value }
+			case protocol.PrometheusLabelMatcher_NRE:
+				// prometheus regexes are anchored
+				re, err := regexp.Compile("^(?:" + value + ")$")
+				if err != nil {
+					return "", nil, err
+				}
+				nameFilter = func(name string) bool { return !re.MatchString(name) }
+			}
+			continue
+		}
+
+		if !isValidColumnName(name) {
+			return "", nil, fmt.Errorf("Invalid label name %s", name)
+		}
+		name = prometheusLabelColumn(name)
+		switch matcher.GetType() {
+		case protocol.PrometheusLabelMatcher_EQ, protocol.PrometheusLabelMatcher_NEQ:
+			if strings.Contains(value, "'") {
+				return "", nil, fmt.Errorf("Label values cannot contain single quotes")
+			}
+			operator := "="
+			if matcher.GetType() == protocol.PrometheusLabelMatcher_NEQ {
+				operator = "<>"
+			}
+			conditions = append(conditions, fmt.Sprintf("%s %s '%s'", name, operator, value))
+		case protocol.PrometheusLabelMatcher_RE:
+			conditions = append(conditions, fmt.Sprintf("%s =~ %s", name, prometheusRegex(value)))
+		case protocol.PrometheusLabelMatcher_NRE:
+			conditions = append(conditions, fmt.Sprintf("%s !~ %s", name, prometheusRegex(value)))
+		}
+	}
+
+	queryString := fmt.Sprintf("select * from %s where %s order asc", from, strings.Join(conditions, " and "))
+	return queryString, nameFilter, nil
+}
+
+var columnNameRegex = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+func isValidColumnName(name string) bool {
+	return columnNameRegex.MatchString(name)
+}
+
+// returns an anchored regex that can be used in a query
+func prometheusRegex(value string) string {
+	return "/^(?:" + strings.Replace(value, "/", "\\/", -1) + ")$/"
+}
+
+// Collects the series returned by a query and groups their points in
+// prometheus time series, one for every combination of label values
+type prometheusQueryResult struct {
+	nameFilter func(string) bool
+	keys       []string
+	timeseries map[string]*protocol.PrometheusTimeSeries
+}
+
+func newPrometheusQueryResult(nameFilter func(string) bool) *prometheusQueryResult {
+	return &prometheusQueryResult{
+		nameFilter: nameFilter,
+		timeseries: make(map[string]*protocol.PrometheusTimeSeries),
+	}
+}
+
+func (self *prometheusQueryResult) yield(series *protocol.Series) error {
+	if !self.nameFilter(series.GetName()) {
+		return nil
+	}
+	valueIndex := -1
+	for i, field := range series.Fields {
+		if field == "value" {
+			valueIndex = i
+		}
+	}
+	if valueIndex < 0 {
+		return nil
+	}
+
+	for _, point := range series.Points {
+		var value float64
+		switch v := point.Values[valueIndex]; {
+		case v == nil:
+			continue
+		case v.DoubleValue != nil:
+			value = v.GetDoubleValue()
+		case v.Int64Value != nil:
+			value = float64(v.GetInt64Value())
+		default:
+			continue
+		}
+
+		labels := []*protocol.PrometheusLabel{
+			&protocol.PrometheusLabel{Name: proto.String(PROMETHEUS_METRIC_NAME_LABEL), Value: series.Name},
+		}
+		key := bytes.NewBufferString(series.GetName())
+		for i, field := range series.Fields {
+			v := point.Values[i]
+			if i == valueIndex || v == nil || v.StringValue == nil {
+				continue
+			}
+			labels = append(labels, &protocol.PrometheusLabel{Name: proto.String(prometheusColumnLabel(field)), Value: v.StringValue})
+			fmt.Fprintf(key, "\x00%s\x00%s", field, v.GetStringValue())
+		}
+
+		ts := self.timeseries[key.String()]
+		if ts == nil {
+			ts = &protocol.PrometheusTimeSeries{Labels: labels}
+			self.timeseries[key.String()] = ts
+			self.keys = append(self.keys, key.String())
+		}
+		ts.Samples = append(ts.Samples, &protocol.PrometheusSample{
+			Value:     proto.Float64(value),
+			Timestamp: proto.Int64(point.GetTimestamp() / 1000),
+		})
+	}
+	return nil
+}
+
+func (self *prometheusQueryResult) result() *protocol.PrometheusQueryResult {
+	result := &protocol.PrometheusQueryResult{}
+	for _, key := range self.keys {
+		result.Timeseries = append(result.Timeseries, self.timeseries[key])
+	}
+	return result
+}
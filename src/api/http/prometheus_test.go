@@ -0,0 +1,136 @@
+package http
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	. "launchpad.net/gocheck"
+	"protocol"
+)
+
+type PrometheusSuite struct{}
+
+var _ = Suite(&PrometheusSuite{})
+
+func matcher(t protocol.PrometheusLabelMatcher_Type, name, value string) *protocol.PrometheusLabelMatcher {
+	return &protocol.PrometheusLabelMatcher{Type: &t, Name: proto.String(name), Value: proto.String(value)}
+}
+
+func (self *PrometheusSuite) TestQueryString(c *C) {
+	query := &protocol.PrometheusQuery{
+		StartTimestampMs: proto.Int64(1000),
+		EndTimestampMs:   proto.Int64(2000),
+		Matchers: []*protocol.PrometheusLabelMatcher{
+			matcher(protocol.PrometheusLabelMatcher_EQ, "__name__", "http.requests"),
+			matcher(protocol.PrometheusLabelMatcher_EQ, "job", "api"),
+			matcher(protocol.PrometheusLabelMatcher_NEQ, "env", "dev"),
+			matcher(protocol.PrometheusLabelMatcher_RE, "path", "/api/.*"),
+			matcher(protocol.PrometheusLabelMatcher_NRE, "code", "5.."),
+		},
+	}
+	queryString, nameFilter, err := prometheusQueryString(query)
+	c.Assert(err, IsNil)
+	c.Assert(queryString, Equals, `select * from /^(?:http\.requests)$/ where time > 999999u and time < 2000001u`+
+		` and job = 'api' and env <> 'dev' and path =~ /^(?:\/api\/.*)$/ and code !~ /^(?:5..)$/ order asc`)
+	c.Assert(nameFilter("anything"), Equals, true)
+}
+
+func (self *PrometheusSuite) TestNegatedNameMatchers(c *C) {
+	query := &protocol.PrometheusQuery{
+		Matchers: []*protocol.PrometheusLabelMatcher{
+			matcher(protocol.PrometheusLabelMatcher_NRE, "__name__", "go_.*"),
+		},
+	}
+	queryString, nameFilter, err := prometheusQueryString(query)
+	c.Assert(err, IsNil)
+	c.Assert(queryString, Equals, "select * from /.*/ where time > -1u and time < 1u order asc")
+	c.Assert(nameFilter("go_goroutines"), Equals, false)
+	c.Assert(nameFilter("up"), Equals, true)
+}
+
+func (self *PrometheusSuite) TestInvalidMatchers(c *C) {
+	for _, m := range []*protocol.PrometheusLabelMatcher{
+		matcher(protocol.PrometheusLabelMatcher_EQ, "job", "it's"),
+		matcher(protocol.PrometheusLabelMatcher_EQ, "bad-label", "foo"),
+		matcher(protocol.PrometheusLabelMatcher_NRE, "__name__", "("),
+	} {
+		_, _, err := prometheusQueryString(&protocol.PrometheusQuery{Matchers: []*protocol.PrometheusLabelMatcher{m}})
+		c.Assert(err, NotNil)
+	}
+}
+
+func (self *PrometheusSuite) TestGroupingPointsInTimeSeries(c *C) {
+	result := newPrometheusQueryResult(func(string) bool { return true })
+	series := &protocol.Series{
+		Name:   proto.String("up"),
+		Fields: []string{"instance", "value"},
+		Points: []*protocol.Point{
+			&protocol.Point{
+				Timestamp: proto.Int64(1000000),
+				Values:    []*protocol.FieldValue{{StringValue: proto.String("a")}, {DoubleValue: proto.Float64(1)}},
+			},
+			&protocol.Point{
+				Timestamp: proto.Int64(1000000),
+				Values:    []*protocol.FieldValue{{StringValue: proto.String("b")}, {Int64Value: proto.Int64(0)}},
+			},
+			&protocol.Point{
+				Timestamp: proto.Int64(2000000),
+				Values:    []*protocol.FieldValue{{StringValue: proto.String("a")}, {DoubleValue: proto.Float64(1)}},
+			},
+		},
+	}
+	c.Assert(result.yield(series), IsNil)
+
+	timeseries := result.result().Timeseries
+	c.Assert(timeseries, HasLen, 2)
+	c.Assert(timeseries[0].Labels, HasLen, 2)
+	c.Assert(timeseries[0].Labels[0].GetValue(), Equals, "up")
+	c.Assert(timeseries[0].Labels[1].GetName(), Equals, "instance")
+	c.Assert(timeseries[0].Labels[1].GetValue(), Equals, "a")
+	c.Assert(timeseries[0].Samples, HasLen, 2)
+	c.Assert(timeseries[0].Samples[1].GetTimestamp(), Equals, int64(2000))
+	c.Assert(timeseries[1].Samples, HasLen, 1)
+	c.Assert(timeseries[1].Samples[0].GetValue(), Equals, 0.0)
+}
+
+func (self *PrometheusSuite) TestLabelsNamedLikeTheValueColumnAreRenamed(c *C) {
+	series, err := convertPrometheusTimeSeries([]*protocol.PrometheusTimeSeries{
+		&protocol.PrometheusTimeSeries{
+			Labels: []*protocol.PrometheusLabel{
+				&protocol.PrometheusLabel{Name: proto.String("__name__"), Value: proto.String("up")},
+				&protocol.PrometheusLabel{Name: proto.String("value"), Value: proto.String("label")},
+				&protocol.PrometheusLabel{Name: proto.String("label_value"), Value: proto.String("prefixed")},
+				&protocol.PrometheusLabel{Name: proto.String("time"), Value: proto.String("now")},
+			},
+			Samples: []*protocol.PrometheusSample{
+				&protocol.PrometheusSample{Value: proto.Float64(2), Timestamp: proto.Int64(1000)},
+			},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(series, HasLen, 1)
+	c.Assert(series[0].Fields, DeepEquals, []string{"label_label_value", "label_time", "label_value", "value"})
+	c.Assert(series[0].Points[0].Values[2].GetStringValue(), Equals, "label")
+	c.Assert(series[0].Points[0].Values[3].GetDoubleValue(), Equals, 2.0)
+
+	// the columns are mapped back to the labels when they're read
+	result := newPrometheusQueryResult(func(string) bool { return true })
+	c.Assert(result.yield(series[0]), IsNil)
+	timeseries := result.result().Timeseries
+	c.Assert(timeseries, HasLen, 1)
+	labels := map[string]string{}
+	for _, label := range timeseries[0].Labels {
+		labels[label.GetName()] = label.GetValue()
+	}
+	c.Assert(labels, DeepEquals, map[string]string{"__name__": "up", "value": "label", "label_value": "prefixed", "time": "now"})
+	c.Assert(timeseries[0].Samples[0].GetValue(), Equals, 2.0)
+
+	// and the matchers use the renamed columns
+	queryString, _, err := prometheusQueryString(&protocol.PrometheusQuery{
+		Matchers: []*protocol.PrometheusLabelMatcher{
+			matcher(protocol.PrometheusLabelMatcher_EQ, "value", "label"),
+			matcher(protocol.PrometheusLabelMatcher_EQ, "label_value", "prefixed"),
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(queryString, Equals, "select * from /.*/ where time > -1u and time < 1u"+
+		" and label_value = 'label' and label_label_value = 'prefixed' order asc")
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	. "common"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	libhttp "net/http"
+	"protocol"
+
+	"code.google.com/p/goprotobuf/proto"
+)
+
+const PROTOBUF_CONTENT_TYPE = "application/x-protobuf"
+
+func isProtobufWrite(r *libhttp.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == PROTOBUF_CONTENT_TYPE
+}
+
+// Writes a body that's an encoded protocol.WriteRequest. The series are
+// written like the series of a json write: the timestamps are in the
+// precision given by time_precision and the points without a timestamp
+// get the current time.
+func (self *HttpServer) writeProtobufPoints(w libhttp.ResponseWriter, r *libhttp.Request, user User, db string, precision TimePrecision) (int, interface{}) {
+	if isStreamingWrite(r) || r.URL.Query().Get("async") == "true" {
+		return libhttp.StatusBadRequest, "Protobuf writes can't be streamed or async"
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return libhttp.StatusInternalServerError, err.Error()
+	}
+	request := &protocol.WriteRequest{}
+	if err := proto.Unmarshal(data, request); err != nil {
+		return libhttp.StatusBadRequest, err.Error()
+	}
+
+	series, err := convertProtobufSeries(request.Series, precision)
+	if err != nil {
+		return libhttp.StatusBadRequest, err.Error()
+	}
+	if statusCode, body := self.checkPointsPerWrite(countPoints(series)); statusCode != 0 {
+		return statusCode, body
+	}
+	if len(series) == 0 {
+		return libhttp.StatusOK, nil
+	}
+	if statusCode, body := self.checkWriteRateLimits(w, user, db, series); statusCode != 0 {
+		return statusCode, body
+	}
+	if err := self.coordinator.WriteSeriesData(user, db, series); err != nil {
+		return errorToStatusCode(err), err.Error()
+	}
+	return libhttp.StatusOK, nil
+}
+
+// Checks the series the same way ConvertToDataStoreSeries checks the
+// series of a json write and converts the timestamps to microseconds.
+// The series are modified in place.
+func convertProtobufSeries(series []*protocol.Series, precision TimePrecision) ([]*protocol.Series, error) {
+	var multiplier int64 = 1
+	switch precision {
+	case SecondPrecision:
+		multiplier = 1000000
+	case MillisecondPrecision:
+		multiplier = 1000
+	}
+
+	converted := make([]*protocol.Series, 0, len(series))
+	for _, s := range series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		if !VALID_TABLE_NAMES.MatchString(s.GetName()) {
+			return nil, fmt.Errorf("%s is not a valid series name", s.GetName())
+		}
+		for _, field := range s.Fields {
+			if field == "" || field == "time" || field == "sequence_number" {
+				return nil, fmt.Errorf("Invalid field name '%s' in series %s", field, s.GetName())
+			}
+		}
+		for _, point := range s.Points {
+			if len(point.Values) != len(s.Fields) {
+				return nil, fmt.Errorf("Series %s has %d fields but a point has %d values", s.GetName(), len(s.Fields), len(point.Values))
+			}
+			for _, value := range point.Values {
+				if value == nil {
+					return nil, fmt.Errorf("Series %s has a point with a missing value", s.GetName())
+				}
+				if value.StringValue == nil && value.DoubleValue == nil && value.BoolValue == nil && value.Int64Value == nil {
+					value.IsNull = &TRUE
+				}
+			}
+			if point.Timestamp != nil {
+				timestamp := *point.Timestamp * multiplier
+				point.Timestamp = &timestamp
+			}
+		}
+		converted = append(converted, s)
+	}
+	return converted, nil
+}
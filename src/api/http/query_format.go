@@ -0,0 +1,53 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	libhttp "net/http"
+)
+
+// The format of the series returned by the query endpoint, it's set
+// using the format query parameter or negotiated using the Accept
+// header. Defaults to json. Streaming formats are always written in
+// chunks as the query engine produces them, csv is streamed by its own
+// writer so that the header of a series isn't repeated for every chunk.
+type QueryFormat struct {
+	name        string
+	contentType string
+	marshal     func(interface{}) ([]byte, error)
+	streaming   bool
+}
+
+var (
+	jsonFormat    = &QueryFormat{"json", "application/json", json.Marshal, false}
+	msgpackFormat = &QueryFormat{"msgpack", "application/x-msgpack", marshalMsgpack, false}
+	csvFormat     = &QueryFormat{"csv", "text/csv", marshalCsv, false}
+	ndjsonFormat  = &QueryFormat{"ndjson", "application/x-ndjson", marshalNdjson, true}
+
+	queryFormats = []*QueryFormat{jsonFormat, msgpackFormat, csvFormat, ndjsonFormat}
+)
+
+func QueryFormatFromRequest(r *libhttp.Request) (*QueryFormat, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, format := range queryFormats {
+			if format.name == name {
+				return format, nil
+			}
+		}
+		return nil, fmt.Errorf("Unknown format %s", name)
+	}
+
+	// the supported type with the highest quality value wins, e.g.
+	// json for text/csv;q=0.1, application/json
+	contentTypes := make([]string, 0, len(queryFormats))
+	for _, format := range queryFormats {
+		contentTypes = append(contentTypes, format.contentType)
+	}
+	accepted := preferredValue(r.Header.Get("Accept"), contentTypes)
+	for _, format := range queryFormats {
+		if format.contentType == accepted {
+			return format, nil
+		}
+	}
+	return jsonFormat, nil
+}
@@ -0,0 +1,74 @@
+package http
+
+import (
+	"cluster"
+	. "common"
+	"encoding/json"
+	"io/ioutil"
+	libhttp "net/http"
+)
+
+// The writes that would take a database over its quota are rejected
+// with Insufficient Storage, so the clients can tell them apart from the
+// rate limited ones
+const STATUS_QUOTA_EXCEEDED = 507
+
+func (self *HttpServer) getDatabaseQuota(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		quota, usage, err := self.coordinator.GetDatabaseQuota(u, db)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		if quota == nil {
+			return libhttp.StatusNotFound, "Database " + db + " doesn't have a quota"
+		}
+		return libhttp.StatusOK, map[string]interface{}{
+			"pointsPerDay": quota.PointsPerDay,
+			"bytesPerDay":  quota.BytesPerDay,
+			"maxSeries":    quota.MaxSeries,
+			"createdBy":    quota.CreatedBy,
+			"usage":        usage,
+		}
+	})
+}
+
+func (self *HttpServer) setDatabaseQuota(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	quota := &cluster.DatabaseQuota{}
+	if err := json.Unmarshal(body, quota); err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	quota.Database = db
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		err := self.coordinator.SetDatabaseQuota(u, quota)
+		self.audit(r, u.GetName(), "set_database_quota", db, "", err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
+
+func (self *HttpServer) dropDatabaseQuota(w libhttp.ResponseWriter, r *libhttp.Request) {
+	db := r.URL.Query().Get(":db")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		err := self.coordinator.DropDatabaseQuota(u, db)
+		self.audit(r, u.GetName(), "drop_database_quota", db, "", err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
@@ -0,0 +1,266 @@
+package http
+
+import (
+	. "common"
+	"fmt"
+	"math"
+	libhttp "net/http"
+	"protocol"
+	"sync"
+	"time"
+)
+
+// net/http doesn't have a constant for it yet
+const STATUS_TOO_MANY_REQUESTS = 429
+
+// buckets that weren't used for this long are full and can be dropped
+const RATE_LIMITER_IDLE_TIMEOUT = time.Minute
+
+// The number of queries, write requests and points per second that a
+// user or a database can send, 0 means unlimited
+type RateLimits struct {
+	QueriesPerSecond float64
+	WritesPerSecond  float64
+	PointsPerSecond  float64
+}
+
+func (self RateLimits) isEnabled() bool {
+	return self.QueriesPerSecond > 0 || self.WritesPerSecond > 0 || self.PointsPerSecond > 0
+}
+
+// A token bucket that holds up to one second worth of tokens
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	capacity := math.Max(rate, 1)
+	return &tokenBucket{rate, capacity, capacity, now}
+}
+
+func (self *tokenBucket) refill(now time.Time) {
+	self.tokens = math.Min(self.capacity, self.tokens+now.Sub(self.last).Seconds()*self.rate)
+	self.last = now
+}
+
+// Returns how long we have to wait before n tokens can be taken. A
+// request for more tokens than the bucket can hold is allowed when
+// the bucket is full and leaves it in debt.
+func (self *tokenBucket) wait(n int, now time.Time) time.Duration {
+	self.refill(now)
+	needed := math.Min(float64(n), self.capacity)
+	if self.tokens >= needed {
+		return 0
+	}
+	return time.Duration((needed - self.tokens) / self.rate * float64(time.Second))
+}
+
+func (self *tokenBucket) take(n int) {
+	self.tokens -= float64(n)
+}
+
+type rateBuckets struct {
+	queries *tokenBucket
+	writes  *tokenBucket
+	points  *tokenBucket
+}
+
+// Keeps a set of buckets per key, i.e. per user or per database. The
+// methods of a nil RateLimiter allow everything.
+type RateLimiter struct {
+	limits    RateLimits
+	lock      sync.Mutex
+	buckets   map[string]*rateBuckets
+	lastPrune time.Time
+}
+
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	return &RateLimiter{
+		limits:    limits,
+		buckets:   make(map[string]*rateBuckets),
+		lastPrune: time.Now(),
+	}
+}
+
+func (self *RateLimiter) getBuckets(key string, now time.Time) *rateBuckets {
+	if now.Sub(self.lastPrune) > RATE_LIMITER_IDLE_TIMEOUT {
+		for k, buckets := range self.buckets {
+			if buckets.lastUse().Before(now.Add(-RATE_LIMITER_IDLE_TIMEOUT)) {
+				delete(self.buckets, k)
+			}
+		}
+		self.lastPrune = now
+	}
+
+	buckets := self.buckets[key]
+	if buckets != nil {
+		return buckets
+	}
+	buckets = &rateBuckets{}
+	if self.limits.QueriesPerSecond > 0 {
+		buckets.queries = newTokenBucket(self.limits.QueriesPerSecond, now)
+	}
+	if self.limits.WritesPerSecond > 0 {
+		buckets.writes = newTokenBucket(self.limits.WritesPerSecond, now)
+	}
+	if self.limits.PointsPerSecond > 0 {
+		buckets.points = newTokenBucket(self.limits.PointsPerSecond, now)
+	}
+	self.buckets[key] = buckets
+	return buckets
+}
+
+func (self *rateBuckets) lastUse() time.Time {
+	var last time.Time
+	for _, bucket := range []*tokenBucket{self.queries, self.writes, self.points} {
+		if bucket != nil && bucket.last.After(last) {
+			last = bucket.last
+		}
+	}
+	return last
+}
+
+// Returns how long the caller has to wait before it can send the given
+// number of queries, writes and points, or 0 if it doesn't have to
+// wait. Nothing is taken from the buckets, see Take.
+func (self *RateLimiter) Wait(key string, queries, writes, points int) time.Duration {
+	if self == nil {
+		return 0
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	now := time.Now()
+	buckets := self.getBuckets(key, now)
+	var wait time.Duration
+	for _, b := range []struct {
+		bucket *tokenBucket
+		n      int
+	}{{buckets.queries, queries}, {buckets.writes, writes}, {buckets.points, points}} {
+		if b.bucket == nil || b.n == 0 {
+			continue
+		}
+		if w := b.bucket.wait(b.n, now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+func (self *RateLimiter) Take(key string, queries, writes, points int) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	buckets := self.getBuckets(key, time.Now())
+	if buckets.queries != nil {
+		buckets.queries.take(queries)
+	}
+	if buckets.writes != nil {
+		buckets.writes.take(writes)
+	}
+	if buckets.points != nil {
+		buckets.points.take(points)
+	}
+}
+
+// Sets the per user and per database rate limits, the limits are
+// disabled by default. Setting them again starts from full buckets.
+func (self *HttpServer) SetRateLimits(user, database RateLimits) {
+	var userRateLimiter, databaseRateLimiter *RateLimiter
+	if user.isEnabled() {
+		userRateLimiter = NewRateLimiter(user)
+	}
+	if database.isEnabled() {
+		databaseRateLimiter = NewRateLimiter(database)
+	}
+	self.rateLimitersLock.Lock()
+	defer self.rateLimitersLock.Unlock()
+	self.userRateLimiter = userRateLimiter
+	self.databaseRateLimiter = databaseRateLimiter
+}
+
+func (self *HttpServer) rateLimiters() (user, database *RateLimiter) {
+	self.rateLimitersLock.RLock()
+	defer self.rateLimitersLock.RUnlock()
+	return self.userRateLimiter, self.databaseRateLimiter
+}
+
+func rateLimiterUserKey(user User) string {
+	return user.GetDb() + "/" + user.GetName()
+}
+
+// The number of queries, write requests and points of a request
+type rateUsage struct {
+	queries int
+	writes  int
+	points  int
+}
+
+// Takes the queries, writes and points of every database in usage
+// from the databases' rate limits and the rate limits of the user that
+// made the request to that database. If any of them is exceeded
+// nothing is taken and the Retry-After header is set. The returned
+// status code is 0 if the request can go through.
+func (self *HttpServer) checkRateLimits(w libhttp.ResponseWriter, users map[string]User, usage map[string]*rateUsage) (int, interface{}) {
+	userRateLimiter, databaseRateLimiter := self.rateLimiters()
+	// the same user can write to several databases in one request
+	userUsage := make(map[string]*rateUsage)
+	var wait time.Duration
+	for db, u := range usage {
+		if d := databaseRateLimiter.Wait(db, u.queries, u.writes, u.points); d > wait {
+			wait = d
+		}
+		if userRateLimiter == nil {
+			continue
+		}
+		key := rateLimiterUserKey(users[db])
+		total := userUsage[key]
+		if total == nil {
+			total = &rateUsage{}
+			userUsage[key] = total
+		}
+		total.queries += u.queries
+		total.writes += u.writes
+		total.points += u.points
+	}
+	for key, u := range userUsage {
+		if d := userRateLimiter.Wait(key, u.queries, u.writes, u.points); d > wait {
+			wait = d
+		}
+	}
+
+	if wait > 0 {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+		return STATUS_TOO_MANY_REQUESTS, fmt.Sprintf("Rate limit exceeded, retry in %s", wait)
+	}
+
+	for db, u := range usage {
+		databaseRateLimiter.Take(db, u.queries, u.writes, u.points)
+	}
+	for key, u := range userUsage {
+		userRateLimiter.Take(key, u.queries, u.writes, u.points)
+	}
+	return 0, nil
+}
+
+func (self *HttpServer) checkQueryRateLimits(w libhttp.ResponseWriter, user User, db string, queries int) (int, interface{}) {
+	return self.checkRateLimits(w, map[string]User{db: user}, map[string]*rateUsage{db: &rateUsage{queries: queries}})
+}
+
+func (self *HttpServer) checkWriteRateLimits(w libhttp.ResponseWriter, user User, db string, series []*protocol.Series) (int, interface{}) {
+	return self.checkRateLimits(w, map[string]User{db: user}, map[string]*rateUsage{db: &rateUsage{writes: 1, points: countPoints(series)}})
+}
+
+func countPoints(series []*protocol.Series) int {
+	points := 0
+	for _, s := range series {
+		points += len(s.Points)
+	}
+	return points
+}
@@ -0,0 +1,44 @@
+package http
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type RateLimiterSuite struct{}
+
+var _ = Suite(&RateLimiterSuite{})
+
+func (self *RateLimiterSuite) TestTokenBucket(c *C) {
+	now := time.Now()
+	bucket := newTokenBucket(10, now)
+	c.Assert(bucket.wait(10, now), Equals, time.Duration(0))
+	bucket.take(10)
+	c.Assert(bucket.wait(1, now), Equals, 100*time.Millisecond)
+	c.Assert(bucket.wait(1, now.Add(100*time.Millisecond)), Equals, time.Duration(0))
+}
+
+func (self *RateLimiterSuite) TestLargeRequestsGoIntoDebt(c *C) {
+	now := time.Now()
+	bucket := newTokenBucket(10, now)
+	c.Assert(bucket.wait(25, now), Equals, time.Duration(0))
+	bucket.take(25)
+	c.Assert(bucket.wait(10, now), Equals, 2500*time.Millisecond)
+}
+
+func (self *RateLimiterSuite) TestKeysAreLimitedSeparately(c *C) {
+	limiter := NewRateLimiter(RateLimits{WritesPerSecond: 1, PointsPerSecond: 100})
+	c.Assert(limiter.Wait("db1", 0, 1, 50), Equals, time.Duration(0))
+	limiter.Take("db1", 0, 1, 50)
+	c.Assert(limiter.Wait("db1", 0, 1, 1) > 0, Equals, true)
+	c.Assert(limiter.Wait("db2", 0, 1, 1), Equals, time.Duration(0))
+	// queries aren't limited
+	c.Assert(limiter.Wait("db1", 100, 0, 0), Equals, time.Duration(0))
+}
+
+func (self *RateLimiterSuite) TestNilLimiterAllowsEverything(c *C) {
+	var limiter *RateLimiter
+	c.Assert(limiter.Wait("db1", 1000, 1000, 1000), Equals, time.Duration(0))
+	limiter.Take("db1", 1000, 1000, 1000)
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	libhttp "net/http"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+const REQUEST_ID_HEADER = "X-Request-Id"
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Returns the id that was assigned to the request
+func requestId(r *libhttp.Request) string {
+	return r.Header.Get(REQUEST_ID_HEADER)
+}
+
+// Requests that take longer than the threshold are logged, 0 disables
+// the log
+func (self *HttpServer) SetSlowRequestThreshold(threshold time.Duration) {
+	self.slowRequestThreshold = threshold
+}
+
+// Assigns an id to the request and returns it in the X-Request-Id
+// header. The id replaces any id sent by the client, it's passed to the
+// coordinator with the user and logged with slow requests.
+func (self *HttpServer) requestIdHandler(f libhttp.HandlerFunc) libhttp.HandlerFunc {
+	return func(w libhttp.ResponseWriter, r *libhttp.Request) {
+		id := newRequestId()
+		r.Header.Set(REQUEST_ID_HEADER, id)
+		w.Header().Set(REQUEST_ID_HEADER, id)
+
+		start := time.Now()
+		f(w, r)
+
+		threshold := self.slowRequestThreshold
+		if threshold <= 0 {
+			return
+		}
+		if took := time.Now().Sub(start); took > threshold {
+			log.Warn("Slow request %s: %s %s by %s took %s, query: %s",
+				id, r.Method, r.URL.Path, self.connections.User(r), took, r.URL.Query().Get("q"))
+		}
+	}
+}
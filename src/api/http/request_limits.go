@@ -0,0 +1,117 @@
+package http
+
+import (
+	"bytes"
+	. "common"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	libhttp "net/http"
+)
+
+const (
+	MAX_BODY_SIZE_LIMIT        = "max-body-size"
+	MAX_POINTS_PER_WRITE_LIMIT = "max-points-per-write"
+)
+
+// The body of the responses to requests that exceed one of the limits,
+// limit is the name of the configuration option
+type requestLimitError struct {
+	Error string `json:"error"`
+	Limit string `json:"limit"`
+	Max   int64  `json:"max"`
+}
+
+// Sets the maximum size of request bodies in bytes and the maximum
+// number of points in a write, 0 means unlimited
+func (self *HttpServer) SetRequestLimits(maxBodySize int64, maxPointsPerWrite int) {
+	self.maxBodySize = maxBodySize
+	self.maxPointsPerWrite = maxPointsPerWrite
+}
+
+func writeRequestLimitError(w libhttp.ResponseWriter, limitError *requestLimitError) {
+	body, err := json.Marshal(limitError)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(libhttp.StatusRequestEntityTooLarge)
+	w.Write(body)
+}
+
+// Rejects requests with a body larger than the maximum body size. The
+// content length is checked before anything is read and bodies without
+// a content length are read up to the limit, so a client can't make
+// the handlers buffer more than that.
+func (self *HttpServer) bodySizeLimitHandler(f libhttp.HandlerFunc) libhttp.HandlerFunc {
+	return func(w libhttp.ResponseWriter, r *libhttp.Request) {
+		maxBodySize := self.maxBodySize
+		if maxBodySize <= 0 {
+			f(w, r)
+			return
+		}
+
+		limitError := &requestLimitError{
+			Error: fmt.Sprintf("The request body is larger than %d bytes", maxBodySize),
+			Limit: MAX_BODY_SIZE_LIMIT,
+			Max:   maxBodySize,
+		}
+		if r.ContentLength > maxBodySize {
+			writeRequestLimitError(w, limitError)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		r.Body.Close()
+		if err != nil {
+			w.WriteHeader(libhttp.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if int64(len(body)) > maxBodySize {
+			writeRequestLimitError(w, limitError)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		f(w, r)
+	}
+}
+
+// Like bodySizeLimitHandler but lets streaming writes through, they
+// check the size of every batch instead. Only the handler of the
+// writes can stream its body.
+func (self *HttpServer) writeSizeLimitHandler(f libhttp.HandlerFunc) libhttp.HandlerFunc {
+	limited := self.bodySizeLimitHandler(f)
+	return func(w libhttp.ResponseWriter, r *libhttp.Request) {
+		if isStreamingWrite(r) {
+			f(w, r)
+			return
+		}
+		limited(w, r)
+	}
+}
+
+// Returns the status code and body of the response if the number of
+// points exceeds the maximum number of points per write, the status
+// code is 0 otherwise
+func (self *HttpServer) checkPointsPerWrite(points int) (int, interface{}) {
+	if self.maxPointsPerWrite <= 0 || points <= self.maxPointsPerWrite {
+		return 0, nil
+	}
+	return libhttp.StatusRequestEntityTooLarge, &requestLimitError{
+		Error: fmt.Sprintf("The write has %d points, the maximum is %d", points, self.maxPointsPerWrite),
+		Limit: MAX_POINTS_PER_WRITE_LIMIT,
+		Max:   int64(self.maxPointsPerWrite),
+	}
+}
+
+func countSerializedPoints(series []*SerializedSeries) int {
+	points := 0
+	for _, s := range series {
+		points += len(s.Points)
+	}
+	return points
+}
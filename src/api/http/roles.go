@@ -0,0 +1,135 @@
+package http
+
+import (
+	"cluster"
+	. "common"
+	"encoding/json"
+	"io/ioutil"
+	libhttp "net/http"
+	"strings"
+)
+
+// The permissions of a role on a database. The matchers are series
+// names, or regexes if they're surrounded by slashes, e.g. /^cpu\..*/
+type RolePermissionDetail struct {
+	ReadFrom []string `json:"readFrom"`
+	WriteTo  []string `json:"writeTo"`
+	Admin    bool     `json:"admin"`
+}
+
+type RoleDetail struct {
+	Name        string                           `json:"name"`
+	Permissions map[string]*RolePermissionDetail `json:"permissions"`
+	CreatedBy   string                           `json:"createdBy,omitempty"`
+}
+
+func parseMatchers(names []string) []*cluster.Matcher {
+	matchers := make([]*cluster.Matcher, 0, len(names))
+	for _, name := range names {
+		if len(name) > 1 && strings.HasPrefix(name, "/") && strings.HasSuffix(name, "/") {
+			matchers = append(matchers, &cluster.Matcher{IsRegex: true, Name: name[1 : len(name)-1]})
+			continue
+		}
+		matchers = append(matchers, &cluster.Matcher{Name: name})
+	}
+	return matchers
+}
+
+func formatMatchers(matchers []*cluster.Matcher) []string {
+	names := make([]string, 0, len(matchers))
+	for _, matcher := range matchers {
+		if matcher.IsRegex {
+			names = append(names, "/"+matcher.Name+"/")
+			continue
+		}
+		names = append(names, matcher.Name)
+	}
+	return names
+}
+
+func (self *RoleDetail) role() *cluster.Role {
+	role := &cluster.Role{
+		Name:        self.Name,
+		Permissions: map[string]*cluster.RolePermission{},
+	}
+	for db, permission := range self.Permissions {
+		if permission == nil {
+			continue
+		}
+		role.Permissions[db] = &cluster.RolePermission{
+			ReadFrom: parseMatchers(permission.ReadFrom),
+			WriteTo:  parseMatchers(permission.WriteTo),
+			IsAdmin:  permission.Admin,
+		}
+	}
+	return role
+}
+
+func newRoleDetail(role *cluster.Role) *RoleDetail {
+	detail := &RoleDetail{
+		Name:        role.Name,
+		Permissions: map[string]*RolePermissionDetail{},
+		CreatedBy:   role.CreatedBy,
+	}
+	for db, permission := range role.Permissions {
+		detail.Permissions[db] = &RolePermissionDetail{
+			ReadFrom: formatMatchers(permission.ReadFrom),
+			WriteTo:  formatMatchers(permission.WriteTo),
+			Admin:    permission.IsAdmin,
+		}
+	}
+	return detail
+}
+
+func (self *HttpServer) listRoles(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		roles, err := self.coordinator.ListRoles(u)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		details := make([]*RoleDetail, 0, len(roles))
+		for _, role := range roles {
+			details = append(details, newRoleDetail(role))
+		}
+		return libhttp.StatusOK, details
+	})
+}
+
+// Creates the role or replaces the permissions of an existing role
+func (self *HttpServer) saveRole(w libhttp.ResponseWriter, r *libhttp.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	detail := &RoleDetail{}
+	if err := json.Unmarshal(body, detail); err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		role := detail.role()
+		err := self.coordinator.SaveRole(u, role)
+		self.audit(r, u.GetName(), "save_role", "", role.Name, err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, newRoleDetail(role)
+	})
+}
+
+func (self *HttpServer) dropRole(w libhttp.ResponseWriter, r *libhttp.Request) {
+	name := r.URL.Query().Get(":name")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		err := self.coordinator.DropRole(u, name)
+		self.audit(r, u.GetName(), "drop_role", "", name, err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
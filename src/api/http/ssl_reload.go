@@ -0,0 +1,127 @@
+package http
+
+import (
+	. "common"
+	"crypto/tls"
+	"net"
+	libhttp "net/http"
+	"os"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// A tls listener that uses the latest configuration of the server for
+// every connection it accepts, so new certificates are used without
+// closing the listener. Connections that were accepted before keep the
+// certificate they were opened with.
+type reloadingTlsListener struct {
+	net.Listener
+	config func() *tls.Config
+}
+
+func (self *reloadingTlsListener) Accept() (net.Conn, error) {
+	conn, err := self.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Server(conn, self.config()), nil
+}
+
+// Checks the certificate and the client CA files every interval and
+// reloads them if they changed, 0 disables the checks. The files can
+// also be reloaded with POST /cluster/ssl/reload.
+func (self *HttpServer) SetSslReloadInterval(interval time.Duration) {
+	self.sslReloadInterval = interval
+}
+
+func (self *HttpServer) loadSslConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(self.httpSslCert, self.httpSslCert)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if err := self.clientCertificatesConfig(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (self *HttpServer) currentSslConfig() *tls.Config {
+	self.sslConfigLock.RLock()
+	defer self.sslConfigLock.RUnlock()
+	return self.sslConfig
+}
+
+// Reads the certificate and the client CAs again, the new connections
+// to the ssl port use them. The current certificate is kept if the
+// files are invalid.
+func (self *HttpServer) ReloadSsl() error {
+	modTime := self.sslFilesModTime()
+	config, err := self.loadSslConfig()
+	if err != nil {
+		return err
+	}
+	self.sslConfigLock.Lock()
+	self.sslConfig = config
+	self.sslModTime = modTime
+	self.sslConfigLock.Unlock()
+	log.Info("Loaded the ssl certificate %s", self.httpSslCert)
+	return nil
+}
+
+// Returns the last time the certificate or the client CA file changed
+func (self *HttpServer) sslFilesModTime() time.Time {
+	var modTime time.Time
+	for _, path := range []string{self.httpSslCert, self.clientCaPath} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime
+}
+
+func (self *HttpServer) watchSslFiles(stop chan struct{}) {
+	ticker := time.NewTicker(self.sslReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		self.sslConfigLock.RLock()
+		loaded := self.sslModTime
+		self.sslConfigLock.RUnlock()
+		if !self.sslFilesModTime().After(loaded) {
+			continue
+		}
+		if err := self.ReloadSsl(); err != nil {
+			log.Error("Cannot reload the ssl certificate %s, still using the old one: %s", self.httpSslCert, err)
+		}
+	}
+}
+
+func (self *HttpServer) reloadSsl(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if self.currentSslConfig() == nil {
+			return libhttp.StatusNotFound, "Ssl isn't enabled"
+		}
+		err := self.ReloadSsl()
+		self.audit(r, u.GetName(), "reload_ssl", "", self.httpSslCert, err)
+		if err != nil {
+			return libhttp.StatusInternalServerError, err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
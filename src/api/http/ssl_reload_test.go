@@ -0,0 +1,47 @@
+package http
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+)
+
+type SslReloadSuite struct {
+	dir string
+}
+
+var _ = Suite(&SslReloadSuite{})
+
+func (self *SslReloadSuite) SetUpTest(c *C) {
+	dir, err := ioutil.TempDir("", "ssl-reload")
+	c.Assert(err, IsNil)
+	self.dir = dir
+}
+
+func (self *SslReloadSuite) TearDownTest(c *C) {
+	os.RemoveAll(self.dir)
+}
+
+func (self *SslReloadSuite) TestInvalidCertificatesAreNotLoaded(c *C) {
+	cert, err := ioutil.ReadFile("../../../cert.pem")
+	c.Assert(err, IsNil)
+	path := filepath.Join(self.dir, "cert.pem")
+	c.Assert(ioutil.WriteFile(path, cert, 0600), IsNil)
+
+	server := NewHttpServer("", 0, "", nil, &MockUserManager{}, nil, nil)
+	server.EnableSsl(":0", path)
+	c.Assert(server.ReloadSsl(), IsNil)
+	config := server.currentSslConfig()
+	c.Assert(config, NotNil)
+	c.Assert(config.Certificates, HasLen, 1)
+
+	c.Assert(ioutil.WriteFile(path, []byte("not a certificate"), 0600), IsNil)
+	c.Assert(server.ReloadSsl(), NotNil)
+	c.Assert(server.currentSslConfig(), Equals, config)
+
+	c.Assert(ioutil.WriteFile(path, cert, 0600), IsNil)
+	c.Assert(server.ReloadSsl(), IsNil)
+	c.Assert(server.currentSslConfig(), Not(Equals), config)
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	. "common"
+	"encoding/json"
+	"fmt"
+	"io"
+	libhttp "net/http"
+
+	log "code.google.com/p/log4go"
+)
+
+// The acknowledgment of a batch in a streaming write. Batches are
+// numbered from 1 in the order they were sent, a batch with an error
+// wasn't written and can be sent again.
+type streamAck struct {
+	Batch  int    `json:"batch"`
+	Points int    `json:"points"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Returns true if the client wants to stream batches on this request
+func isStreamingWrite(r *libhttp.Request) bool {
+	return r.URL.Query().Get("stream") == "true"
+}
+
+// Reads the next line of the body. Lines longer than maxSize are
+// skipped and tooLarge is set, maxSize is ignored if it's 0.
+func readStreamBatch(reader *bufio.Reader, maxSize int64) (batch []byte, tooLarge bool, err error) {
+	for {
+		line, err := reader.ReadSlice('\n')
+		if !tooLarge {
+			batch = append(batch, line...)
+			if maxSize > 0 && int64(len(bytes.TrimSpace(batch))) > maxSize {
+				tooLarge = true
+				batch = nil
+			}
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && (tooLarge || len(batch) > 0):
+			// the last line doesn't have to end with a newline
+			return batch, tooLarge, nil
+		default:
+			return batch, tooLarge, err
+		}
+	}
+}
+
+// Writes the batches of a streaming write as they arrive. Every line of
+// the body is a batch in the same format as the body of a regular
+// write, and every batch is acknowledged with a line in the response
+// once it's written or rejected. The limits on the body size and the
+// number of points apply to every batch and the read timeout is reset
+// after every batch, so a client can keep the request open as long as
+// it keeps sending.
+func (self *HttpServer) streamWritePoints(w libhttp.ResponseWriter, r *libhttp.Request, user User, db string, precision TimePrecision) (int, interface{}) {
+	if r.URL.Query().Get("async") == "true" {
+		return libhttp.StatusBadRequest, "Streaming writes can't be async"
+	}
+
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(libhttp.StatusOK)
+	encoder := json.NewEncoder(w)
+	reader := bufio.NewReader(r.Body)
+
+	for batch := 1; ; {
+		data, tooLarge, err := readStreamBatch(reader, self.maxBodySize)
+		if err == io.EOF {
+			return -1, nil
+		}
+		if err != nil {
+			log.Debug("Streaming write to %s stopped: %s", db, err)
+			return -1, nil
+		}
+
+		ack := &streamAck{Batch: batch}
+		if tooLarge {
+			ack.Status = libhttp.StatusRequestEntityTooLarge
+			ack.Error = fmt.Sprintf("The batch is larger than %d bytes", self.maxBodySize)
+		} else if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		} else {
+			ack.Status, ack.Points, ack.Error = self.writeStreamBatch(w, user, db, precision, data)
+		}
+
+		if err := encoder.Encode(ack); err != nil {
+			log.Debug("Cannot acknowledge batch %d of streaming write to %s: %s", batch, db, err)
+			return -1, nil
+		}
+		w.(libhttp.Flusher).Flush()
+		self.connections.ExtendReadDeadline(r, self.readTimeout)
+		batch++
+	}
+}
+
+// Returns the status code, the number of points that were written and
+// the error message if the batch wasn't written
+func (self *HttpServer) writeStreamBatch(w libhttp.ResponseWriter, user User, db string, precision TimePrecision, data []byte) (int, int, string) {
+	serializedSeries := []*SerializedSeries{}
+	if err := json.Unmarshal(data, &serializedSeries); err != nil {
+		return libhttp.StatusBadRequest, 0, err.Error()
+	}
+	if statusCode, body := self.checkPointsPerWrite(countSerializedPoints(serializedSeries)); statusCode != 0 {
+		return statusCode, 0, body.(*requestLimitError).Error
+	}
+	series, err := convertToDataStoreSeries(serializedSeries, precision)
+	if err != nil {
+		return libhttp.StatusBadRequest, 0, err.Error()
+	}
+	if statusCode, body := self.checkWriteRateLimits(w, user, db, series); statusCode != 0 {
+		return statusCode, 0, body.(string)
+	}
+	if err := self.coordinator.WriteSeriesData(user, db, series); err != nil {
+		return errorToStatusCode(err), 0, err.Error()
+	}
+	return libhttp.StatusOK, countPoints(series), ""
+}
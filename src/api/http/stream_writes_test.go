@@ -0,0 +1,35 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+type StreamWritesSuite struct{}
+
+var _ = Suite(&StreamWritesSuite{})
+
+func (self *StreamWritesSuite) TestReadStreamBatch(c *C) {
+	reader := bufio.NewReaderSize(strings.NewReader("short\n"+strings.Repeat("x", 100)+"\nlast"), 16)
+
+	batch, tooLarge, err := readStreamBatch(reader, 10)
+	c.Assert(err, IsNil)
+	c.Assert(tooLarge, Equals, false)
+	c.Assert(string(batch), Equals, "short\n")
+
+	// the line is larger than the limit and the reader's buffer
+	_, tooLarge, err = readStreamBatch(reader, 10)
+	c.Assert(err, IsNil)
+	c.Assert(tooLarge, Equals, true)
+
+	batch, tooLarge, err = readStreamBatch(reader, 10)
+	c.Assert(err, IsNil)
+	c.Assert(tooLarge, Equals, false)
+	c.Assert(string(batch), Equals, "last")
+
+	_, _, err = readStreamBatch(reader, 10)
+	c.Assert(err, Equals, io.EOF)
+}
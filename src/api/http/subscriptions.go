@@ -0,0 +1,85 @@
+package http
+
+import (
+	. "common"
+	"coordinator"
+	libhttp "net/http"
+	"parser"
+	"protocol"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+	log "code.google.com/p/log4go"
+)
+
+// Upgrades the request to a websocket and sends the points written to
+// the database that match the query in q as they're written, every
+// message is an array of series like the response of a query. The
+// query is checked and the user authenticated before the upgrade, so
+// errors are returned as regular http responses.
+func (self *HttpServer) subscribe(w libhttp.ResponseWriter, r *libhttp.Request) {
+	query := r.URL.Query().Get("q")
+	db := r.URL.Query().Get(":db")
+
+	self.tryAsDbUserAndClusterAdmin(w, r, func(user User) (int, interface{}) {
+		precision, err := TimePrecisionFromString(r.URL.Query().Get("time_precision"))
+		if err != nil {
+			return libhttp.StatusBadRequest, err.Error()
+		}
+		subscription, err := self.coordinator.Subscribe(user, db, query)
+		if err != nil {
+			if e, ok := err.(*parser.QueryError); ok {
+				return errorToStatusCode(err), e.PrettyPrint()
+			}
+			return errorToStatusCode(err), err.Error()
+		}
+		defer self.coordinator.Unsubscribe(subscription)
+
+		// the users are authenticated with their credentials, so the
+		// origin of the request isn't checked
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			self.streamSubscription(ws, user, db, subscription, precision)
+		}}
+		server.ServeHTTP(w, r)
+		return -1, nil
+	})
+}
+
+func (self *HttpServer) streamSubscription(ws *websocket.Conn, user User, db string, subscription *coordinator.Subscription, precision TimePrecision) {
+	// the read timeout of the server applies to requests, not to the
+	// lifetime of the websocket
+	ws.SetDeadline(time.Time{})
+
+	// the client doesn't send anything, reading tells us when it's gone
+	closed := make(chan bool)
+	go func() {
+		defer close(closed)
+		var message string
+		for {
+			if err := websocket.Message.Receive(ws, &message); err != nil {
+				return
+			}
+		}
+	}()
+
+	log.Info("Subscription %d to %s by %s started", subscription.Id, db, user.GetName())
+	defer func() {
+		log.Info("Subscription %d to %s by %s stopped, %d series dropped", subscription.Id, db, user.GetName(), subscription.Dropped())
+	}()
+
+	for {
+		select {
+		case series, ok := <-subscription.Series:
+			if !ok {
+				return
+			}
+			serialized := SerializeSeries(map[string]*protocol.Series{"": series}, precision)
+			if err := websocket.JSON.Send(ws, serialized); err != nil {
+				log.Debug("Cannot send to subscription %d: %s", subscription.Id, err)
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package http
+
+import (
+	"cluster"
+	. "common"
+	"encoding/json"
+	"io/ioutil"
+	libhttp "net/http"
+)
+
+func (self *HttpServer) listTenants(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		tenants, err := self.coordinator.ListTenants(u)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, tenants
+	})
+}
+
+// Returns the tenant with the usage of its databases
+func (self *HttpServer) getTenant(w libhttp.ResponseWriter, r *libhttp.Request) {
+	name := r.URL.Query().Get(":name")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		tenant, usage, err := self.coordinator.GetTenant(u, name)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		if tenant == nil {
+			return libhttp.StatusNotFound, "Tenant " + name + " doesn't exist"
+		}
+		return libhttp.StatusOK, map[string]interface{}{
+			"name":            tenant.Name,
+			"databases":       tenant.Databases,
+			"maxSeries":       tenant.MaxSeries,
+			"maxDiskBytes":    tenant.MaxDiskBytes,
+			"pointsPerSecond": tenant.PointsPerSecond,
+			"createdBy":       tenant.CreatedBy,
+			"usage":           usage,
+		}
+	})
+}
+
+// Creates the tenant or replaces its databases and limits
+func (self *HttpServer) saveTenant(w libhttp.ResponseWriter, r *libhttp.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	tenant := &cluster.Tenant{}
+	if err := json.Unmarshal(body, tenant); err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		err := self.coordinator.SaveTenant(u, tenant)
+		self.audit(r, u.GetName(), "save_tenant", "", tenant.Name, err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, tenant
+	})
+}
+
+// Drops the tenant, its databases are kept
+func (self *HttpServer) dropTenant(w libhttp.ResponseWriter, r *libhttp.Request) {
+	name := r.URL.Query().Get(":name")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		err := self.coordinator.DropTenant(u, name)
+		self.audit(r, u.GetName(), "drop_tenant", "", name, err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
@@ -0,0 +1,289 @@
+package http
+
+import (
+	. "common"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	libhttp "net/http"
+	"strings"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// The claims of the tokens the api issues. Database is empty for
+// tokens of cluster admins.
+type tokenClaims struct {
+	Subject      string `json:"sub"`
+	Database     string `json:"db,omitempty"`
+	ClusterAdmin bool   `json:"cluster_admin,omitempty"`
+	IssuedAt     int64  `json:"iat"`
+	ExpiresAt    int64  `json:"exp"`
+	// set on the tokens that can't be used to authenticate, e.g.
+	// password reset tokens
+	Purpose string `json:"purpose,omitempty"`
+	// the fingerprint of the password that a password reset token
+	// replaces, the token can't be used once the password changed
+	PasswordFingerprint string `json:"pwd,omitempty"`
+	// the fingerprint of the credentials of the subject when the token
+	// was issued, the token can't be used once they changed
+	Credentials string `json:"cred,omitempty"`
+}
+
+const PASSWORD_RESET_PURPOSE = "password_reset"
+
+// Issues and verifies JSON web tokens signed with HMAC-SHA256. Clients
+// get a token once using their password and send it in the
+// Authorization header of the following requests, which is a lot
+// cheaper to check than a bcrypt hash.
+type TokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// the header of every token, the tokens are always signed with HS256
+var tokenHeader = encodeTokenPart([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// If secret is empty a random secret is generated, tokens are only
+// valid on the server that issued them in that case
+func NewTokenIssuer(secret string, ttl time.Duration) (*TokenIssuer, error) {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &TokenIssuer{key, ttl}, nil
+}
+
+func encodeTokenPart(data []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(data), "=")
+}
+
+func decodeTokenPart(s string) ([]byte, error) {
+	if m := len(s) % 4; m != 0 {
+		s += strings.Repeat("=", 4-m)
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+func (self *TokenIssuer) sign(payload string) string {
+	mac := hmac.New(sha256.New, self.secret)
+	mac.Write([]byte(payload))
+	return encodeTokenPart(mac.Sum(nil))
+}
+
+// Issues a token for the user, credentials is the fingerprint of the
+// credentials of the user, see credentialsFingerprint
+func (self *TokenIssuer) Issue(username, db, credentials string, clusterAdmin bool) (string, *tokenClaims, error) {
+	now := time.Now()
+	claims := &tokenClaims{
+		Subject:      username,
+		Database:     db,
+		ClusterAdmin: clusterAdmin,
+		IssuedAt:     now.Unix(),
+		ExpiresAt:    now.Add(self.ttl).Unix(),
+		Credentials:  credentials,
+	}
+	token, err := self.encode(claims)
+	return token, claims, err
+}
+
+// Issues a token that lets the db user set a new password once, the
+// token expires after ttl or when the password changes
+func (self *TokenIssuer) IssuePasswordReset(username, db, fingerprint string, ttl time.Duration) (string, *tokenClaims, error) {
+	now := time.Now()
+	claims := &tokenClaims{
+		Subject:             username,
+		Database:            db,
+		IssuedAt:            now.Unix(),
+		ExpiresAt:           now.Add(ttl).Unix(),
+		Purpose:             PASSWORD_RESET_PURPOSE,
+		PasswordFingerprint: fingerprint,
+	}
+	token, err := self.encode(claims)
+	return token, claims, err
+}
+
+func (self *TokenIssuer) encode(claims *tokenClaims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := tokenHeader + "." + encodeTokenPart(data)
+	return payload + "." + self.sign(payload), nil
+}
+
+// Returns the claims of the token if it has a valid signature and
+// hasn't expired
+func (self *TokenIssuer) Verify(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Malformed token")
+	}
+	// the header is fixed, which makes sure tokens with other
+	// algorithms (or none) are rejected
+	if parts[0] != tokenHeader {
+		return nil, fmt.Errorf("Unsupported token header")
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(self.sign(payload)), []byte(parts[2])) {
+		return nil, fmt.Errorf("Invalid token signature")
+	}
+
+	data, err := decodeTokenPart(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed token")
+	}
+	claims := &tokenClaims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		return nil, fmt.Errorf("Malformed token")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("Token expired")
+	}
+	return claims, nil
+}
+
+func (self *HttpServer) EnableTokens(secret string, ttl time.Duration) error {
+	if secret == "" {
+		log.Warn("No token-secret is set, tokens will only be accepted by the server that issued them")
+	}
+	issuer, err := NewTokenIssuer(secret, ttl)
+	if err != nil {
+		return err
+	}
+	self.tokenIssuer = issuer
+	return nil
+}
+
+// Returns the fingerprint of the credentials of the user, it changes
+// when the password or the second factor of the user changes and when
+// the user is deleted and created again. Empty for users that don't
+// have credentials.
+func credentialsFingerprint(user User) string {
+	if u, ok := user.(interface {
+		CredentialsFingerprint() string
+	}); ok {
+		return u.CredentialsFingerprint()
+	}
+	return ""
+}
+
+// Returns the user the token was issued to, unless the credentials of
+// the user changed since
+func checkTokenCredentials(claims *tokenClaims, user User) (User, int, string) {
+	if subtle.ConstantTimeCompare([]byte(credentialsFingerprint(user)), []byte(claims.Credentials)) != 1 {
+		return nil, libhttp.StatusUnauthorized, "The token was revoked, the credentials of the user changed"
+	}
+	return user, 0, ""
+}
+
+// Returns the bearer token in the Authorization header, if any
+func getToken(r *libhttp.Request) (string, bool) {
+	fields := strings.Fields(r.Header.Get("Authorization"))
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+		return "", false
+	}
+	return fields[1], true
+}
+
+// Returns the claims of the token the request was made with, the
+// second return value is false if the request doesn't have a token
+func (self *HttpServer) tokenClaims(r *libhttp.Request) (*tokenClaims, bool, error) {
+	token, ok := getToken(r)
+	if !ok {
+		return nil, false, nil
+	}
+	if self.tokenIssuer == nil {
+		return nil, true, fmt.Errorf("Tokens aren't enabled")
+	}
+	claims, err := self.tokenIssuer.Verify(token)
+	if err == nil && claims.Purpose != "" {
+		return nil, true, fmt.Errorf("The token can't be used to authenticate")
+	}
+	return claims, true, err
+}
+
+// Returns the name of the user that authenticated the request without
+// a token, which is either the common name of its client certificate
+// or the username.
+func (self *HttpServer) tokenSubject(r *libhttp.Request) (string, error) {
+	if _, ok := getToken(r); ok {
+		return "", fmt.Errorf("Tokens can only be issued with a password or a client certificate")
+	}
+	if _, ok := getApiKey(r); ok {
+		return "", fmt.Errorf("Tokens can only be issued with a password or a client certificate")
+	}
+	if name, _, ok := self.clientCertificateUser(r); ok {
+		return name, nil
+	}
+	username, _, err := getUsernameAndPassword(r)
+	return username, err
+}
+
+type tokenResponse struct {
+	Token   string `json:"token"`
+	Expires int64  `json:"expires"`
+}
+
+func (self *HttpServer) issueToken(w libhttp.ResponseWriter, r *libhttp.Request, db string, clusterAdmin bool) {
+	var user User
+	var statusCode int
+	var message string
+	if clusterAdmin {
+		user, statusCode, message = self.clusterAdminFromRequest(r)
+	} else {
+		user, statusCode, message = self.dbUserFromRequest(r, db)
+	}
+
+	var body interface{} = message
+	if statusCode == 0 {
+		statusCode, body = self.doIssueToken(r, user, db, clusterAdmin)
+		if statusCode == libhttp.StatusOK {
+			username, _ := self.tokenSubject(r)
+			self.audit(r, username, "issue_token", db, "", nil)
+		}
+	} else if statusCode == libhttp.StatusUnauthorized {
+		self.authenticationFailed(r, message)
+	}
+	if statusCode == libhttp.StatusUnauthorized {
+		w.Header().Add("WWW-Authenticate", "Basic realm=\"influxdb\"")
+	}
+	bodyContent, contentType, err := toBytes(body)
+	if err != nil {
+		statusCode, contentType, bodyContent = libhttp.StatusInternalServerError, "text/plain", []byte(err.Error())
+	}
+	w.Header().Add("content-type", contentType)
+	w.WriteHeader(statusCode)
+	w.Write(bodyContent)
+}
+
+func (self *HttpServer) doIssueToken(r *libhttp.Request, user User, db string, clusterAdmin bool) (int, interface{}) {
+	if self.tokenIssuer == nil {
+		return libhttp.StatusNotFound, "Tokens aren't enabled"
+	}
+	username, err := self.tokenSubject(r)
+	if err != nil {
+		return libhttp.StatusBadRequest, err.Error()
+	}
+	token, claims, err := self.tokenIssuer.Issue(username, db, credentialsFingerprint(user), clusterAdmin)
+	if err != nil {
+		return libhttp.StatusInternalServerError, err.Error()
+	}
+	return libhttp.StatusOK, &tokenResponse{token, claims.ExpiresAt}
+}
+
+func (self *HttpServer) issueClusterAdminToken(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.issueToken(w, r, "", true)
+}
+
+func (self *HttpServer) issueDbUserToken(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.issueToken(w, r, r.URL.Query().Get(":db"), false)
+}
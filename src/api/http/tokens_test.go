@@ -0,0 +1,58 @@
+package http
+
+import (
+	"encoding/base64"
+	. "launchpad.net/gocheck"
+	"strings"
+	"time"
+)
+
+type TokenSuite struct{}
+
+var _ = Suite(&TokenSuite{})
+
+func (self *TokenSuite) TestIssuedTokensAreVerified(c *C) {
+	issuer, err := NewTokenIssuer("secret", time.Hour)
+	c.Assert(err, IsNil)
+	token, claims, err := issuer.Issue("dbuser", "foo", "", false)
+	c.Assert(err, IsNil)
+	c.Assert(claims.ExpiresAt-claims.IssuedAt, Equals, int64(3600))
+
+	verified, err := issuer.Verify(token)
+	c.Assert(err, IsNil)
+	c.Assert(verified, DeepEquals, claims)
+
+	// a different secret
+	other, err := NewTokenIssuer("other secret", time.Hour)
+	c.Assert(err, IsNil)
+	_, err = other.Verify(token)
+	c.Assert(err, ErrorMatches, "Invalid token signature")
+}
+
+func (self *TokenSuite) TestTamperedTokensAreRejected(c *C) {
+	issuer, err := NewTokenIssuer("secret", time.Hour)
+	c.Assert(err, IsNil)
+	token, _, err := issuer.Issue("dbuser", "foo", "", false)
+	c.Assert(err, IsNil)
+	parts := strings.Split(token, ".")
+
+	claims := encodeTokenPart([]byte(`{"sub":"root","cluster_admin":true,"exp":9999999999}`))
+	_, err = issuer.Verify(parts[0] + "." + claims + "." + parts[2])
+	c.Assert(err, ErrorMatches, "Invalid token signature")
+
+	none := strings.TrimRight(base64.URLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)), "=")
+	_, err = issuer.Verify(none + "." + parts[1] + ".")
+	c.Assert(err, ErrorMatches, "Unsupported token header")
+
+	_, err = issuer.Verify("garbage")
+	c.Assert(err, ErrorMatches, "Malformed token")
+}
+
+func (self *TokenSuite) TestExpiredTokensAreRejected(c *C) {
+	issuer, err := NewTokenIssuer("secret", -time.Second)
+	c.Assert(err, IsNil)
+	token, _, err := issuer.Issue("root", "", "", true)
+	c.Assert(err, IsNil)
+	_, err = issuer.Verify(token)
+	c.Assert(err, ErrorMatches, "Token expired")
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"cluster"
+	. "common"
+	"encoding/json"
+	"io/ioutil"
+	libhttp "net/http"
+)
+
+// The header with the second factor code of cluster admins that
+// enabled totp, it can also be given in the otp parameter
+const OTP_HEADER = "X-Influxdb-Otp"
+
+const TOTP_ISSUER = "InfluxDB"
+
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	Url    string `json:"url"`
+}
+
+type totpConfirmation struct {
+	Code string `json:"code"`
+}
+
+type totpRecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// Returns the user if it doesn't need a second factor or if the
+// request has a valid code, the status code and message otherwise.
+// The failed logins of the user are reset once it's authenticated.
+func (self *HttpServer) checkSecondFactor(r *libhttp.Request, username string, user User) (User, int, string) {
+	if admin, ok := user.(interface {
+		RequiresSecondFactor() bool
+	}); ok && admin.RequiresSecondFactor() {
+		code := r.Header.Get(OTP_HEADER)
+		if code == "" {
+			code = r.URL.Query().Get("otp")
+		}
+		if code == "" {
+			return nil, libhttp.StatusUnauthorized, "A second factor code is required in the " + OTP_HEADER + " header"
+		}
+		if err := self.userManager.VerifyClusterAdminSecondFactor(user.GetName(), code); err != nil {
+			return nil, libhttp.StatusUnauthorized, err.Error()
+		}
+	}
+	self.loginThrottle.Succeeded(username)
+	return user, 0, ""
+}
+
+func (self *HttpServer) enrollTotp(w libhttp.ResponseWriter, r *libhttp.Request) {
+	username := r.URL.Query().Get(":user")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		secret, err := self.userManager.EnrollClusterAdminTotp(u, username)
+		self.audit(r, u.GetName(), "enroll_totp", "", username, err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, &totpEnrollment{secret, cluster.TotpUrl(TOTP_ISSUER, username, secret)}
+	})
+}
+
+func (self *HttpServer) confirmTotp(w libhttp.ResponseWriter, r *libhttp.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	confirmation := &totpConfirmation{}
+	if err := json.Unmarshal(body, confirmation); err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	username := r.URL.Query().Get(":user")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		codes, err := self.userManager.ConfirmClusterAdminTotp(u, username, confirmation.Code)
+		self.audit(r, u.GetName(), "confirm_totp", "", username, err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, &totpRecoveryCodes{codes}
+	})
+}
+
+func (self *HttpServer) disableTotp(w libhttp.ResponseWriter, r *libhttp.Request) {
+	username := r.URL.Query().Get(":user")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		err := self.userManager.DisableClusterAdminTotp(u, username)
+		self.audit(r, u.GetName(), "disable_totp", "", username, err)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
@@ -0,0 +1,39 @@
+package http
+
+import (
+	. "common"
+	"protocol"
+	"time"
+)
+
+// The name of the series with the timings of the stages of a query
+// that's returned with the results when the query has trace=true
+const TRACE_SERIES = "_trace"
+
+// Returns a point for every stage of the query at the time it started,
+// with the server that ran it, the shard if it's about a shard and how
+// long it took
+func traceSeries(trace *Trace) *protocol.Series {
+	series := &protocol.Series{
+		Name:   protocol.String(TRACE_SERIES),
+		Fields: []string{"stage", "server_id", "shard_id", "duration_ms"},
+		Points: []*protocol.Point{},
+	}
+	for _, span := range trace.Spans() {
+		name := span.Name
+		serverId := int64(span.ServerId)
+		shardId := int64(span.ShardId)
+		duration := float64(span.Duration) / float64(time.Millisecond)
+		point := &protocol.Point{
+			Values: []*protocol.FieldValue{
+				{StringValue: &name},
+				{Int64Value: &serverId},
+				{Int64Value: &shardId},
+				{DoubleValue: &duration},
+			},
+		}
+		point.SetTimestampInMicroseconds(TimeToMicroseconds(span.Start))
+		series.Points = append(series.Points, point)
+	}
+	return series
+}
@@ -0,0 +1,60 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	log "code.google.com/p/log4go"
+	"github.com/bmizerany/pat"
+)
+
+// Serves the api on a unix socket in addition to the tcp ports. The
+// socket is created with the given permissions, so access can be
+// limited to the users and groups that can open the file. Requests on
+// the socket are authenticated like any other request.
+func (self *HttpServer) EnableUnixSocket(path string, mode os.FileMode) {
+	self.unixSocketPath = path
+	self.unixSocketMode = mode
+}
+
+func (self *HttpServer) listenUnixSocket() (net.Listener, error) {
+	// remove the socket left behind by a server that crashed
+	if info, err := os.Lstat(self.unixSocketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", self.unixSocketPath)
+		}
+		if err := os.Remove(self.unixSocketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", self.unixSocketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(self.unixSocketPath, self.unixSocketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// The connections on the socket aren't tracked by the connection
+// tracker, they all have the same remote address.
+func (self *HttpServer) startUnixSocket(p *pat.PatternServeMux) {
+	defer func() { self.shutdown <- true }()
+
+	if self.unixSocketPath == "" {
+		return
+	}
+
+	log.Info("Starting the api on unix socket %s", self.unixSocketPath)
+
+	var err error
+	self.unixConn, err = self.listenUnixSocket()
+	if err != nil {
+		panic(err)
+	}
+	self.serveListener(self.unixConn, UNIX_LISTENER, p)
+}
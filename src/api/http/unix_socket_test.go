@@ -0,0 +1,47 @@
+package http
+
+import (
+	"net"
+	libhttp "net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type UnixSocketSuite struct{}
+
+var _ = Suite(&UnixSocketSuite{})
+
+func (self *UnixSocketSuite) TestServeOnUnixSocket(c *C) {
+	path := filepath.Join(c.MkDir(), "influxdb.sock")
+	server := NewHttpServer("", 10*time.Second, "", nil, &MockUserManager{}, nil, nil)
+	server.EnableUnixSocket(path, 0600)
+	go server.Serve(nil)
+	defer server.Close()
+
+	client := &libhttp.Client{
+		Transport: &libhttp.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			},
+		},
+	}
+	var resp *libhttp.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		resp, err = client.Get("http://influxdb/ping")
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, libhttp.StatusNoContent)
+
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0600))
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"cluster"
 	"common"
 )
 
@@ -10,12 +11,42 @@ type UserManager interface {
 	AuthenticateDbUser(db, username, password string) (common.User, error)
 	// Returns the cluster admin with the given credentials
 	AuthenticateClusterAdmin(username, password string) (common.User, error)
+	// Returns the db user without checking the password, for users that
+	// were authenticated in some other way, e.g. with a client certificate
+	LookupDbUser(db, username string) (common.User, error)
+	// Same as LookupDbUser for cluster admins
+	LookupClusterAdmin(username string) (common.User, error)
+	// Returns the api key if it's valid and scoped to the given db
+	AuthenticateApiKey(db, key string) (common.User, error)
+	// Returns the api key with the given id if it's scoped to the db,
+	// without checking its secret
+	LookupApiKey(db, id string) (common.User, error)
+	// Create an api key, it's an error if requester isn't a cluster
+	// admin. Returns the key that should be given to the client
+	CreateApiKey(requester common.User, name string, databases []string, read, write bool) (string, *cluster.ApiKey, error)
+	// Revoke an api key. Same restrictions as CreateApiKey
+	RevokeApiKey(requester common.User, id string) error
+	// list the api keys. only a cluster admin can list the api keys
+	ListApiKeys(requester common.User) ([]*cluster.ApiKey, error)
 	// Create a cluster admin user, it's an error if requester isn't a cluster admin
 	CreateClusterAdminUser(request common.User, username, password string) error
 	// Delete a cluster admin. Same restrictions as CreateClusterAdminUser
 	DeleteClusterAdminUser(requester common.User, username string) error
 	// Change cluster admin's password. It's an error if requester isn't a cluster admin
 	ChangeClusterAdminPassword(requester common.User, username, password string) error
+	// Restrict the networks in CIDR notation the cluster admin can
+	// authenticate from. Same restrictions as ChangeClusterAdminPassword
+	SetClusterAdminAllowedNetworks(requester common.User, username string, networks []string) error
+	// Start the totp enrollment of the requester, returns the secret
+	EnrollClusterAdminTotp(requester common.User, username string) (string, error)
+	// Enable the pending totp secret if the code is valid, returns the
+	// recovery codes
+	ConfirmClusterAdminTotp(requester common.User, username, code string) ([]string, error)
+	// Turn the second factor off. Same restrictions as ChangeClusterAdminPassword
+	DisableClusterAdminTotp(requester common.User, username string) error
+	// Returns an error if the code isn't a valid totp code or an unused
+	// recovery code of the cluster admin
+	VerifyClusterAdminSecondFactor(username, code string) error
 	// list cluster admins. only a cluster admin can list the other cluster admins
 	ListClusterAdmins(requester common.User) ([]string, error)
 	// Create a db user, it's an error if requester isn't a db admin or cluster admin
@@ -27,8 +58,22 @@ type UserManager interface {
 	// list cluster admins. only a cluster admin or the db admin can list the db users
 	ListDbUsers(requester common.User, db string) ([]common.User, error)
 	GetDbUser(requester common.User, db, username string) (common.User, error)
+	// Returns a fingerprint of the password of the db user that changes
+	// with the password. Same restrictions as ChangeDbUserPassword
+	DbUserPasswordFingerprint(requester common.User, db, username string) (string, error)
+	// Change db user's password if the fingerprint of its current
+	// password matches, the fingerprint has to come from an admin
+	ResetDbUserPassword(db, username, fingerprint, password string) error
 	// make user a db admin for 'db'. It's an error if the requester
 	// isn't a db admin or cluster admin or if user isn't a db user
 	// for the given db
 	SetDbAdmin(requester common.User, db, username string, isAdmin bool) error
+	// replace the roles of the user, same restrictions as SetDbAdmin
+	SetDbUserRoles(requester common.User, db, username string, roles []string) error
+	// restrict the networks the db user can authenticate from, it's an
+	// error if the requester isn't a cluster admin or another db admin
+	SetDbUserAllowedNetworks(requester common.User, db, username string, networks []string) error
+	// let the db user create databases, it's an error if the requester
+	// isn't a cluster admin
+	SetDbUserCanCreateDatabases(requester common.User, db, username string, canCreate bool) error
 }
@@ -0,0 +1,82 @@
+package http
+
+import (
+	"cluster"
+	. "common"
+	"encoding/json"
+	"io/ioutil"
+	libhttp "net/http"
+)
+
+type NewWriteSubscription struct {
+	Name         string   `json:"name"`
+	Database     string   `json:"database"`
+	Destinations []string `json:"destinations"`
+}
+
+type WriteSubscriptionDetail struct {
+	Name         string   `json:"name"`
+	Database     string   `json:"database"`
+	Destinations []string `json:"destinations"`
+	CreatedBy    string   `json:"createdBy"`
+	CreatedAt    int64    `json:"createdAt"`
+}
+
+func newWriteSubscriptionDetail(subscription *cluster.WriteSubscription) *WriteSubscriptionDetail {
+	return &WriteSubscriptionDetail{
+		Name:         subscription.Name,
+		Database:     subscription.Database,
+		Destinations: subscription.Destinations,
+		CreatedBy:    subscription.CreatedBy,
+		CreatedAt:    subscription.CreatedAt,
+	}
+}
+
+func (self *HttpServer) listWriteSubscriptions(w libhttp.ResponseWriter, r *libhttp.Request) {
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		subscriptions, err := self.coordinator.ListWriteSubscriptions(u)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		details := make([]*WriteSubscriptionDetail, 0, len(subscriptions))
+		for _, subscription := range subscriptions {
+			details = append(details, newWriteSubscriptionDetail(subscription))
+		}
+		return libhttp.StatusOK, details
+	})
+}
+
+func (self *HttpServer) createWriteSubscription(w libhttp.ResponseWriter, r *libhttp.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	newSubscription := &NewWriteSubscription{}
+	err = json.Unmarshal(body, newSubscription)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		subscription, err := self.coordinator.CreateWriteSubscription(u, newSubscription.Name, newSubscription.Database, newSubscription.Destinations)
+		if err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusCreated, newWriteSubscriptionDetail(subscription)
+	})
+}
+
+func (self *HttpServer) dropWriteSubscription(w libhttp.ResponseWriter, r *libhttp.Request) {
+	name := r.URL.Query().Get(":name")
+
+	self.tryAsClusterAdmin(w, r, func(u User) (int, interface{}) {
+		if err := self.coordinator.DropWriteSubscription(u, name); err != nil {
+			return errorToStatusCode(err), err.Error()
+		}
+		return libhttp.StatusOK, nil
+	})
+}
@@ -0,0 +1,224 @@
+// package kafka provides an input plugin that consumes all the
+// partitions of a kafka topic and writes the messages to a single
+// database. Messages are either json arrays of series, the same
+// payload that is posted to the http api, or lines in graphite's
+// plaintext format. The messages are written in batches and the
+// offsets are checkpointed after every batch.
+package kafka
+
+import (
+	"api/graphite"
+	. "common"
+	"configuration"
+	"coordinator"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"protocol"
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+	"github.com/Shopify/sarama"
+)
+
+const (
+	FORMAT_JSON     = "json"
+	FORMAT_GRAPHITE = "graphite"
+
+	OFFSETS_FILE = "kafka_offsets"
+
+	// the backoff between the attempts to write a batch
+	MIN_RETRY_DELAY = 100 * time.Millisecond
+	MAX_RETRY_DELAY = 30 * time.Second
+)
+
+type Server struct {
+	brokers      []string
+	topic        string
+	database     string
+	format       string
+	templates    []*graphite.Template
+	batchSize    int
+	batchTimeout time.Duration
+	offsets      *OffsetStore
+	coordinator  coordinator.Coordinator
+	consumer     sarama.Consumer
+	shutdown     chan bool
+	wg           sync.WaitGroup
+}
+
+func NewServer(config *configuration.Configuration, coord coordinator.Coordinator) (*Server, error) {
+	self := &Server{
+		brokers:      config.KafkaBrokers,
+		topic:        config.KafkaTopic,
+		database:     config.KafkaDatabase,
+		format:       config.KafkaFormat,
+		batchSize:    config.KafkaBatchSize,
+		batchTimeout: config.KafkaBatchTimeout,
+		coordinator:  coord,
+		shutdown:     make(chan bool),
+	}
+	if !config.KafkaEnabled {
+		return self, nil
+	}
+
+	switch self.format {
+	case FORMAT_JSON:
+	case FORMAT_GRAPHITE:
+		templates, err := graphite.ParseTemplates(config.GraphiteTemplates)
+		if err != nil {
+			return nil, err
+		}
+		self.templates = templates
+	default:
+		return nil, fmt.Errorf("Unknown kafka message format %s", self.format)
+	}
+
+	offsets, err := NewOffsetStore(filepath.Join(config.DataDir, OFFSETS_FILE))
+	if err != nil {
+		return nil, err
+	}
+	self.offsets = offsets
+	return self, nil
+}
+
+func (self *Server) ListenAndServe() {
+	var err error
+	self.consumer, err = sarama.NewConsumer(self.brokers, sarama.NewConfig())
+	if err != nil {
+		log.Error("KafkaServer: Cannot connect to %v: %s", self.brokers, err)
+		return
+	}
+	partitions, err := self.consumer.Partitions(self.topic)
+	if err != nil {
+		log.Error("KafkaServer: Cannot get the partitions of %s: %s", self.topic, err)
+		return
+	}
+
+	for _, partition := range partitions {
+		offset, ok := self.offsets.Get(self.topic, partition)
+		if !ok {
+			offset = sarama.OffsetOldest
+		}
+		partitionConsumer, err := self.consumer.ConsumePartition(self.topic, partition, offset)
+		if err != nil {
+			log.Error("KafkaServer: Cannot consume partition %d of %s: %s", partition, self.topic, err)
+			continue
+		}
+		log.Info("KafkaServer: Consuming partition %d of %s from offset %d", partition, self.topic, offset)
+		self.wg.Add(1)
+		go self.consumePartition(partition, partitionConsumer)
+	}
+}
+
+func (self *Server) Close() {
+	if self.consumer == nil {
+		return
+	}
+	log.Info("KafkaServer: Closing kafka consumer")
+	close(self.shutdown)
+	self.wg.Wait()
+	self.consumer.Close()
+}
+
+func (self *Server) consumePartition(partition int32, partitionConsumer sarama.PartitionConsumer) {
+	defer self.wg.Done()
+	defer partitionConsumer.Close()
+
+	batch := []*protocol.Series{}
+	messages := 0
+	var nextOffset int64 = -1
+	timeout := time.NewTimer(self.batchTimeout)
+
+	// returns false if the batch couldn't be written before the server
+	// was shut down
+	flush := func() bool {
+		if messages > 0 && !self.writeBatch(batch, partition, nextOffset) {
+			return false
+		}
+		batch = []*protocol.Series{}
+		messages = 0
+		timeout.Reset(self.batchTimeout)
+		return true
+	}
+
+	for {
+		select {
+		case message := <-partitionConsumer.Messages():
+			series, err := ParseMessage(self.format, self.templates, message.Value)
+			if err != nil {
+				log.Warn("KafkaServer: Dropping message %d from partition %d: %s", message.Offset, partition, err)
+			} else {
+				batch = append(batch, series...)
+			}
+			messages++
+			nextOffset = message.Offset + 1
+			if messages >= self.batchSize && !flush() {
+				return
+			}
+		case err := <-partitionConsumer.Errors():
+			log.Error("KafkaServer: Error consuming partition %d: %s", partition, err)
+		case <-timeout.C:
+			if !flush() {
+				return
+			}
+		case <-self.shutdown:
+			flush()
+			return
+		}
+	}
+}
+
+// Writes the batch and checkpoints the offset. A failed write is
+// retried with a backoff and the partition isn't consumed meanwhile, so
+// no later offset is checkpointed before the batch is written. Returns
+// false if the server is shut down before the batch is written, its
+// messages are consumed again after a restart then.
+func (self *Server) writeBatch(batch []*protocol.Series, partition int32, nextOffset int64) bool {
+	delay := MIN_RETRY_DELAY
+	for len(batch) > 0 {
+		err := coordinator.WriteInputSeries(self.coordinator, self.database, batch)
+		if err == nil {
+			break
+		}
+		log.Error("KafkaServer: Cannot write batch from partition %d, retrying in %s: %s", partition, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-self.shutdown:
+			return false
+		}
+		if delay *= 2; delay > MAX_RETRY_DELAY {
+			delay = MAX_RETRY_DELAY
+		}
+	}
+	// the batch is written, if the checkpoint fails the messages are
+	// written again after a restart unless a later checkpoint succeeds
+	if err := self.offsets.Checkpoint(self.topic, partition, nextOffset); err != nil {
+		log.Error("KafkaServer: Cannot checkpoint offset %d of partition %d: %s", nextOffset, partition, err)
+	}
+	return true
+}
+
+func ParseMessage(format string, templates []*graphite.Template, data []byte) ([]*protocol.Series, error) {
+	if format == FORMAT_GRAPHITE {
+		return graphite.ParseLines(data, templates)
+	}
+
+	serializedSeries := []*SerializedSeries{}
+	if err := json.Unmarshal(data, &serializedSeries); err != nil {
+		return nil, err
+	}
+	series := make([]*protocol.Series, 0, len(serializedSeries))
+	for _, s := range serializedSeries {
+		if len(s.Points) == 0 {
+			continue
+		}
+		dataStoreSeries, err := ConvertToDataStoreSeries(s, MillisecondPrecision)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, dataStoreSeries)
+	}
+	return series, nil
+}
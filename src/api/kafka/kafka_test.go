@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"common"
+	"coordinator"
+	"fmt"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"protocol"
+	"testing"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type KafkaSuite struct{}
+
+var _ = Suite(&KafkaSuite{})
+
+func (self *KafkaSuite) TestOffsetsAreCheckpointed(c *C) {
+	dir, err := ioutil.TempDir("", "kafka")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, OFFSETS_FILE)
+
+	store, err := NewOffsetStore(path)
+	c.Assert(err, IsNil)
+	_, ok := store.Get("metrics", 0)
+	c.Assert(ok, Equals, false)
+	c.Assert(store.Checkpoint("metrics", 0, 10), IsNil)
+	c.Assert(store.Checkpoint("metrics", 1, 20), IsNil)
+
+	store, err = NewOffsetStore(path)
+	c.Assert(err, IsNil)
+	offset, ok := store.Get("metrics", 0)
+	c.Assert(ok, Equals, true)
+	c.Assert(offset, Equals, int64(10))
+	offset, ok = store.Get("metrics", 1)
+	c.Assert(ok, Equals, true)
+	c.Assert(offset, Equals, int64(20))
+}
+
+func (self *KafkaSuite) TestParsingJsonMessages(c *C) {
+	series, err := ParseMessage(FORMAT_JSON, nil, []byte(`[{"name": "cpu", "columns": ["time", "value"], "points": [[1400000000000, 1.5]]}]`))
+	c.Assert(err, IsNil)
+	c.Assert(series, HasLen, 1)
+	c.Assert(series[0].GetName(), Equals, "cpu")
+	c.Assert(series[0].Points[0].GetTimestamp(), Equals, int64(1400000000000000))
+
+	_, err = ParseMessage(FORMAT_JSON, nil, []byte(`{`))
+	c.Assert(err, NotNil)
+}
+
+func (self *KafkaSuite) TestParsingGraphiteMessages(c *C) {
+	series, err := ParseMessage(FORMAT_GRAPHITE, nil, []byte("servers.a.cpu 1.5 1400000000\nservers.b.cpu 2 1400000000"))
+	c.Assert(err, IsNil)
+	c.Assert(series, HasLen, 2)
+	c.Assert(series[1].GetName(), Equals, "servers.b.cpu")
+	c.Assert(series[1].Points[0].Values[0].GetInt64Value(), Equals, int64(2))
+}
+
+// Fails the given number of writes, the other methods of the coordinator
+// aren't used by the kafka input
+type failingCoordinator struct {
+	coordinator.Coordinator
+	failures int
+	writes   int
+}
+
+func (self *failingCoordinator) WriteSeriesData(user common.User, db string, series []*protocol.Series) error {
+	self.writes++
+	if self.failures > 0 {
+		self.failures--
+		return fmt.Errorf("no servers are up")
+	}
+	return nil
+}
+
+func newTestServer(c *C, coord coordinator.Coordinator) (*Server, func()) {
+	dir, err := ioutil.TempDir("", "kafka")
+	c.Assert(err, IsNil)
+	offsets, err := NewOffsetStore(filepath.Join(dir, OFFSETS_FILE))
+	c.Assert(err, IsNil)
+	server := &Server{topic: "metrics", database: "db1", offsets: offsets, coordinator: coord, shutdown: make(chan bool)}
+	return server, func() { os.RemoveAll(dir) }
+}
+
+func (self *KafkaSuite) TestFailedBatchesAreRetriedBeforeTheOffsetIsCheckpointed(c *C) {
+	coord := &failingCoordinator{failures: 2}
+	server, cleanup := newTestServer(c, coord)
+	defer cleanup()
+
+	series, err := ParseMessage(FORMAT_JSON, nil, []byte(`[{"name": "cpu", "columns": ["value"], "points": [[1]]}]`))
+	c.Assert(err, IsNil)
+	c.Assert(server.writeBatch(series, 0, 11), Equals, true)
+	c.Assert(coord.writes, Equals, 3)
+	offset, ok := server.offsets.Get("metrics", 0)
+	c.Assert(ok, Equals, true)
+	c.Assert(offset, Equals, int64(11))
+}
+
+func (self *KafkaSuite) TestOffsetOfAFailedBatchIsntCheckpointedOnShutdown(c *C) {
+	coord := &failingCoordinator{failures: 1}
+	server, cleanup := newTestServer(c, coord)
+	defer cleanup()
+	close(server.shutdown)
+
+	series, err := ParseMessage(FORMAT_JSON, nil, []byte(`[{"name": "cpu", "columns": ["value"], "points": [[1]]}]`))
+	c.Assert(err, IsNil)
+	c.Assert(server.writeBatch(series, 0, 11), Equals, false)
+	_, ok := server.offsets.Get("metrics", 0)
+	c.Assert(ok, Equals, false)
+}
@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Keeps track of the offset of the next message to consume for every
+// partition. The offsets are saved to a file after every batch that is
+// written, so the consumer picks up where it left off after a restart.
+type OffsetStore struct {
+	path    string
+	lock    sync.Mutex
+	offsets map[string]int64
+}
+
+func NewOffsetStore(path string) (*OffsetStore, error) {
+	self := &OffsetStore{path: path, offsets: make(map[string]int64)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return self, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &self.offsets); err != nil {
+		return nil, fmt.Errorf("Cannot parse kafka offsets in %s: %s", path, err)
+	}
+	return self, nil
+}
+
+func offsetKey(topic string, partition int32) string {
+	return fmt.Sprintf("%s/%d", topic, partition)
+}
+
+func (self *OffsetStore) Get(topic string, partition int32) (int64, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	offset, ok := self.offsets[offsetKey(topic, partition)]
+	return offset, ok
+}
+
+// Sets the offset of the next message and saves all the offsets
+func (self *OffsetStore) Checkpoint(topic string, partition int32, offset int64) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.offsets[offsetKey(topic, partition)] = offset
+
+	data, err := json.Marshal(self.offsets)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash doesn't leave a
+	// truncated file behind
+	tmp := self.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, self.path)
+}
@@ -0,0 +1,102 @@
+// package mqtt provides an input plugin that subscribes to topics on
+// an mqtt broker and writes the messages published on them to a single
+// database. See MessageToSeries for how topics and payloads are mapped
+// to series.
+package mqtt
+
+import (
+	"api/graphite"
+	"configuration"
+	"coordinator"
+	"fmt"
+	"protocol"
+
+	log "code.google.com/p/log4go"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// how long to wait for in flight messages when disconnecting, in
+// milliseconds
+const DISCONNECT_QUIESCE = 250
+
+type Server struct {
+	topics      []string
+	qos         byte
+	database    string
+	templates   []*graphite.Template
+	options     *paho.ClientOptions
+	client      paho.Client
+	coordinator coordinator.Coordinator
+}
+
+func NewServer(config *configuration.Configuration, coord coordinator.Coordinator) (*Server, error) {
+	self := &Server{
+		topics:      config.MqttTopics,
+		qos:         byte(config.MqttQos),
+		database:    config.MqttDatabase,
+		coordinator: coord,
+	}
+	if config.MqttQos < 0 || config.MqttQos > 2 {
+		return nil, fmt.Errorf("Invalid mqtt qos %d, it must be 0, 1 or 2", config.MqttQos)
+	}
+	templates, err := graphite.ParseTemplatesWithSeparator(config.MqttTemplates, TOPIC_SEPARATOR)
+	if err != nil {
+		return nil, err
+	}
+	self.templates = templates
+
+	self.options = paho.NewClientOptions().
+		AddBroker(config.MqttBroker).
+		SetClientID(config.MqttClientId).
+		SetUsername(config.MqttUsername).
+		SetPassword(config.MqttPassword).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(self.subscribe)
+	return self, nil
+}
+
+func (self *Server) ListenAndServe() {
+	self.client = paho.NewClient(self.options)
+	token := self.client.Connect()
+	if token.Wait() && token.Error() != nil {
+		log.Error("MqttServer: Cannot connect to the broker: %s", token.Error())
+		self.client = nil
+	}
+}
+
+// subscribes to the topics, this is called every time the client
+// (re)connects since the broker may have dropped the subscriptions
+func (self *Server) subscribe(client paho.Client) {
+	filters := make(map[string]byte, len(self.topics))
+	for _, topic := range self.topics {
+		filters[topic] = self.qos
+	}
+	token := client.SubscribeMultiple(filters, self.handleMessage)
+	if token.Wait() && token.Error() != nil {
+		log.Error("MqttServer: Cannot subscribe to %v: %s", self.topics, token.Error())
+		return
+	}
+	log.Info("MqttServer: Subscribed to %v", self.topics)
+}
+
+func (self *Server) Close() {
+	if self.client != nil {
+		log.Info("MqttServer: Disconnecting from the broker")
+		self.client.Disconnect(DISCONNECT_QUIESCE)
+	}
+}
+
+func (self *Server) handleMessage(client paho.Client, message paho.Message) {
+	series, err := MessageToSeries(message.Topic(), message.Payload(), self.templates)
+	if err != nil {
+		log.Warn("MqttServer: %s", err)
+		return
+	}
+	if err := self.writeSeries(series); err != nil {
+		log.Error("MqttServer: Cannot write message on topic %s: %s", message.Topic(), err)
+	}
+}
+
+func (self *Server) writeSeries(series *protocol.Series) error {
+	return coordinator.WriteInputSeries(self.coordinator, self.database, []*protocol.Series{series})
+}
@@ -0,0 +1,138 @@
+package mqtt
+
+import (
+	"api/graphite"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"protocol"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// the separator of mqtt topic levels
+const TOPIC_SEPARATOR = "/"
+
+// Converts a message published on the given topic to a series. The
+// topic is mapped to the series name and columns using the templates,
+// e.g. sensors/<room>/<sensor> stores the room and sensor in columns
+// of the sensors series. Topics that don't match any template are
+// used as the series name.
+//
+// The payload is either a number, which is stored in the value column,
+// or a json object whose keys are the columns. The time key of json
+// objects is the time of the point in milliseconds, if it's missing the
+// time the message was received is used.
+func MessageToSeries(topic string, payload []byte, templates []*graphite.Template) (*protocol.Series, error) {
+	name := topic
+	fields := []string{}
+	values := []*protocol.FieldValue{}
+	for _, template := range templates {
+		series, columns, columnValues, ok := template.Apply(topic)
+		if !ok {
+			continue
+		}
+		name = series
+		fields = columns
+		for i := range columnValues {
+			values = append(values, &protocol.FieldValue{StringValue: &columnValues[i]})
+		}
+		break
+	}
+
+	payloadFields, payloadValues, timestamp, err := parsePayload(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid payload on topic %s: %s", topic, err)
+	}
+	if timestamp == 0 {
+		timestamp = time.Now().UnixNano() / int64(time.Microsecond)
+	}
+
+	return &protocol.Series{
+		Name:   &name,
+		Fields: append(fields, payloadFields...),
+		Points: []*protocol.Point{
+			&protocol.Point{Timestamp: &timestamp, Values: append(values, payloadValues...)},
+		},
+	}, nil
+}
+
+// returns the columns and values in the payload and the timestamp in
+// microseconds, which is 0 if the payload doesn't have one
+func parsePayload(payload []byte) ([]string, []*protocol.FieldValue, int64, error) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) == 0 {
+		return nil, nil, 0, fmt.Errorf("Empty payload")
+	}
+
+	if payload[0] != '{' {
+		value, err := numberValue(json.Number(payload))
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return []string{"value"}, []*protocol.FieldValue{value}, 0, nil
+	}
+
+	object := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, nil, 0, err
+	}
+
+	var timestamp int64
+	if t, ok := object["time"]; ok {
+		number, ok := t.(json.Number)
+		if !ok {
+			return nil, nil, 0, fmt.Errorf("Invalid time %v", t)
+		}
+		ms, err := number.Int64()
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("Invalid time %s", number)
+		}
+		timestamp = ms * 1000
+		delete(object, "time")
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]*protocol.FieldValue, 0, len(keys))
+	for _, key := range keys {
+		var value *protocol.FieldValue
+		var err error
+		switch x := object[key].(type) {
+		case json.Number:
+			value, err = numberValue(x)
+		case string:
+			value = &protocol.FieldValue{StringValue: protocol.String(x)}
+		case bool:
+			value = &protocol.FieldValue{BoolValue: &x}
+		case nil:
+			isNull := true
+			value = &protocol.FieldValue{IsNull: &isNull}
+		default:
+			err = fmt.Errorf("Unsupported value %v for %s", x, key)
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		values = append(values, value)
+	}
+	return keys, values, timestamp, nil
+}
+
+func numberValue(number json.Number) (*protocol.FieldValue, error) {
+	if i, err := strconv.ParseInt(string(number), 10, 64); err == nil {
+		return &protocol.FieldValue{Int64Value: &i}, nil
+	}
+	f, err := strconv.ParseFloat(string(number), 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid number %s", number)
+	}
+	return &protocol.FieldValue{DoubleValue: &f}, nil
+}
@@ -0,0 +1,56 @@
+package mqtt
+
+import (
+	"api/graphite"
+	. "launchpad.net/gocheck"
+	"testing"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MqttSuite struct{}
+
+var _ = Suite(&MqttSuite{})
+
+func (self *MqttSuite) TestTopicsAreMappedToColumns(c *C) {
+	templates, err := graphite.ParseTemplatesWithSeparator([]string{"sensors/<room>/<sensor>"}, TOPIC_SEPARATOR)
+	c.Assert(err, IsNil)
+
+	series, err := MessageToSeries("sensors/kitchen/temperature", []byte("21.5"), templates)
+	c.Assert(err, IsNil)
+	c.Assert(series.GetName(), Equals, "sensors")
+	c.Assert(series.Fields, DeepEquals, []string{"room", "sensor", "value"})
+	values := series.Points[0].Values
+	c.Assert(values[0].GetStringValue(), Equals, "kitchen")
+	c.Assert(values[1].GetStringValue(), Equals, "temperature")
+	c.Assert(values[2].GetDoubleValue(), Equals, 21.5)
+
+	// topics that don't match a template are used as the series name
+	series, err = MessageToSeries("home/power", []byte("300"), templates)
+	c.Assert(err, IsNil)
+	c.Assert(series.GetName(), Equals, "home/power")
+	c.Assert(series.Fields, DeepEquals, []string{"value"})
+	c.Assert(series.Points[0].Values[0].GetInt64Value(), Equals, int64(300))
+}
+
+func (self *MqttSuite) TestJsonPayloads(c *C) {
+	payload := []byte(`{"temperature": 21.5, "humidity": 40, "ok": true, "time": 1400000000000}`)
+	series, err := MessageToSeries("sensors", payload, nil)
+	c.Assert(err, IsNil)
+	c.Assert(series.Fields, DeepEquals, []string{"humidity", "ok", "temperature"})
+	point := series.Points[0]
+	c.Assert(point.GetTimestamp(), Equals, int64(1400000000000000))
+	c.Assert(point.Values[0].GetInt64Value(), Equals, int64(40))
+	c.Assert(point.Values[1].GetBoolValue(), Equals, true)
+	c.Assert(point.Values[2].GetDoubleValue(), Equals, 21.5)
+}
+
+func (self *MqttSuite) TestInvalidPayloads(c *C) {
+	for _, payload := range []string{"", "on", `{"time": "now"}`, `{"a": [1]}`} {
+		_, err := MessageToSeries("sensors", []byte(payload), nil)
+		c.Assert(err, NotNil)
+	}
+}
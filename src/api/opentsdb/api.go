@@ -0,0 +1,178 @@
+// package opentsdb provides a listener that accepts OpenTSDB's telnet
+// put command and its /api/put http endpoint on the same port, so
+// TCollector and other OpenTSDB clients can write to influxdb without
+// any changes. Every metric is written to a series with the same name,
+// the tags are stored in columns named after the tag keys and the
+// value in the value column.
+package opentsdb
+
+import (
+	"bufio"
+	"configuration"
+	"coordinator"
+	"io/ioutil"
+	"net"
+	libhttp "net/http"
+	"protocol"
+	"strings"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+type Server struct {
+	listenAddress string
+	database      string
+	coordinator   coordinator.Coordinator
+	conn          net.Listener
+	httpListener  *chanListener
+	shutdown      chan bool
+}
+
+func NewServer(config *configuration.Configuration, coord coordinator.Coordinator) *Server {
+	self := &Server{}
+	self.listenAddress = config.OpenTsdbPortString()
+	self.database = config.OpenTsdbDatabase
+	self.coordinator = coord
+	self.shutdown = make(chan bool, 1)
+	return self
+}
+
+func (self *Server) ListenAndServe() {
+	var err error
+	self.conn, err = net.Listen("tcp", self.listenAddress)
+	if err != nil {
+		log.Error("OpenTsdbServer: Listen: ", err)
+		return
+	}
+	self.Serve(self.conn)
+}
+
+func (self *Server) Serve(listener net.Listener) {
+	defer func() { self.shutdown <- true }()
+
+	// http connections are handed to an http server through this
+	// listener
+	self.httpListener = newChanListener(listener.Addr())
+	mux := libhttp.NewServeMux()
+	mux.HandleFunc("/api/put", self.handlePut)
+	go libhttp.Serve(self.httpListener, mux)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "closed network") {
+				self.httpListener.Close()
+				return
+			}
+			log.Error("OpenTsdbServer: Accept: ", err)
+			continue
+		}
+		go self.handleConnection(conn)
+	}
+}
+
+func (self *Server) Close() {
+	if self.conn != nil {
+		log.Info("OpenTsdbServer: Closing opentsdb server")
+		self.conn.Close()
+		select {
+		case <-time.After(time.Second * 5):
+			log.Error("OpenTsdbServer: There seems to be a hanging opentsdb request. Closing anyway")
+		case <-self.shutdown:
+		}
+	}
+}
+
+// Looks at the first bytes of the connection to decide whether it's
+// an http request or a telnet session
+func (self *Server) handleConnection(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	method, err := reader.Peek(4)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	switch string(method) {
+	case "GET ", "POST", "PUT ", "HEAD":
+		select {
+		case self.httpListener.connections <- &bufferedConn{conn, reader}:
+		case <-self.httpListener.closed:
+			conn.Close()
+		}
+	default:
+		self.handleTelnet(conn, reader)
+	}
+}
+
+func (self *Server) handleTelnet(conn net.Conn, reader *bufio.Reader) {
+	defer conn.Close()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+
+		switch strings.SplitN(line, " ", 2)[0] {
+		case "put":
+			point, err := ParsePutCommand(line)
+			if err == nil {
+				err = self.writePoints([]*Point{point})
+			}
+			if err != nil {
+				log.Warn("OpenTsdbServer: %s", err)
+				conn.Write([]byte("put: " + err.Error() + "\n"))
+			}
+		case "version":
+			conn.Write([]byte("InfluxDB OpenTSDB input\n"))
+		case "exit":
+			return
+		case "":
+		default:
+			conn.Write([]byte("unknown command: " + line + "\n"))
+		}
+	}
+}
+
+func (self *Server) handlePut(w libhttp.ResponseWriter, r *libhttp.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(libhttp.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	points, err := ParsePutBody(body)
+	if err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err := self.writePoints(points); err != nil {
+		w.WriteHeader(libhttp.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(libhttp.StatusNoContent)
+}
+
+func (self *Server) writePoints(points []*Point) error {
+	series := make([]*protocol.Series, 0, len(points))
+	for _, point := range points {
+		s, err := point.ToSeries()
+		if err != nil {
+			return err
+		}
+		series = append(series, s)
+	}
+	if len(series) == 0 {
+		return nil
+	}
+
+	return coordinator.WriteInputSeries(self.coordinator, self.database, series)
+}
@@ -0,0 +1,54 @@
+package opentsdb
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+)
+
+// A listener that returns the connections that are sent on its
+// channel, used to pass the http connections to an http server
+type chanListener struct {
+	addr        net.Addr
+	connections chan net.Conn
+	closeOnce   sync.Once
+	closed      chan bool
+}
+
+func newChanListener(addr net.Addr) *chanListener {
+	return &chanListener{
+		addr:        addr,
+		connections: make(chan net.Conn),
+		closed:      make(chan bool),
+	}
+}
+
+func (self *chanListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-self.connections:
+		return conn, nil
+	case <-self.closed:
+		return nil, errors.New("use of closed network connection")
+	}
+}
+
+func (self *chanListener) Close() error {
+	self.closeOnce.Do(func() { close(self.closed) })
+	return nil
+}
+
+func (self *chanListener) Addr() net.Addr {
+	return self.addr
+}
+
+// A connection that reads what was buffered while sniffing the
+// protocol before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (self *bufferedConn) Read(b []byte) (int, error) {
+	return self.reader.Read(b)
+}
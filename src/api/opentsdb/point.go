@@ -0,0 +1,113 @@
+package opentsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"protocol"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A single data point in OpenTSDB, the tags are stored in columns
+// named after the tag keys
+type Point struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     json.Number       `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// timestamps bigger than this are in milliseconds
+const MAX_SECONDS_TIMESTAMP = 9999999999
+
+// Parses the arguments of a telnet put command, which looks like:
+//
+//	put <metric> <timestamp> <value> <tagk1=tagv1 ...>
+func ParsePutCommand(line string) (*Point, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[0] != "put" {
+		return nil, fmt.Errorf("Invalid put command '%s'", line)
+	}
+	timestamp, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid timestamp '%s'", fields[2])
+	}
+
+	point := &Point{
+		Metric:    fields[1],
+		Timestamp: timestamp,
+		Value:     json.Number(fields[3]),
+		Tags:      make(map[string]string),
+	}
+	for _, tag := range fields[4:] {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid tag '%s'", tag)
+		}
+		point.Tags[parts[0]] = parts[1]
+	}
+	return point, nil
+}
+
+// Parses the body of /api/put, which is either a single point or an
+// array of points
+func ParsePutBody(body []byte) ([]*Point, error) {
+	body = []byte(strings.TrimSpace(string(body)))
+	if len(body) > 0 && body[0] == '{' {
+		point := &Point{}
+		if err := json.Unmarshal(body, point); err != nil {
+			return nil, err
+		}
+		return []*Point{point}, nil
+	}
+
+	points := []*Point{}
+	if err := json.Unmarshal(body, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+func (self *Point) ToSeries() (*protocol.Series, error) {
+	if self.Metric == "" {
+		return nil, fmt.Errorf("Missing metric name")
+	}
+	if self.Value == "" {
+		return nil, fmt.Errorf("Missing value for metric %s", self.Metric)
+	}
+
+	tagKeys := make([]string, 0, len(self.Tags))
+	for key := range self.Tags {
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+
+	fields := make([]string, 0, len(tagKeys)+1)
+	values := make([]*protocol.FieldValue, 0, len(tagKeys)+1)
+	for _, key := range tagKeys {
+		fields = append(fields, key)
+		values = append(values, &protocol.FieldValue{StringValue: protocol.String(self.Tags[key])})
+	}
+
+	fields = append(fields, "value")
+	if i, err := strconv.ParseInt(string(self.Value), 10, 64); err == nil {
+		values = append(values, &protocol.FieldValue{Int64Value: &i})
+	} else if f, err := strconv.ParseFloat(string(self.Value), 64); err == nil {
+		values = append(values, &protocol.FieldValue{DoubleValue: &f})
+	} else {
+		return nil, fmt.Errorf("Invalid value '%s' for metric %s", self.Value, self.Metric)
+	}
+
+	timestamp := self.Timestamp * 1000
+	if self.Timestamp <= MAX_SECONDS_TIMESTAMP {
+		timestamp *= 1000
+	}
+	return &protocol.Series{
+		Name:   protocol.String(self.Metric),
+		Fields: fields,
+		Points: []*protocol.Point{
+			&protocol.Point{Timestamp: &timestamp, Values: values},
+		},
+	}, nil
+}
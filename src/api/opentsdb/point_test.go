@@ -0,0 +1,70 @@
+package opentsdb
+
+import (
+	. "launchpad.net/gocheck"
+	"testing"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type PointSuite struct{}
+
+var _ = Suite(&PointSuite{})
+
+func (self *PointSuite) TestParsingPutCommand(c *C) {
+	point, err := ParsePutCommand("put sys.cpu.user 1356998400 42.5 host=webserver01 cpu=0")
+	c.Assert(err, IsNil)
+	c.Assert(point.Metric, Equals, "sys.cpu.user")
+	c.Assert(point.Timestamp, Equals, int64(1356998400))
+	c.Assert(point.Tags, DeepEquals, map[string]string{"host": "webserver01", "cpu": "0"})
+
+	series, err := point.ToSeries()
+	c.Assert(err, IsNil)
+	c.Assert(series.GetName(), Equals, "sys.cpu.user")
+	c.Assert(series.Fields, DeepEquals, []string{"cpu", "host", "value"})
+	c.Assert(series.Points[0].GetTimestamp(), Equals, int64(1356998400000000))
+	c.Assert(series.Points[0].Values[0].GetStringValue(), Equals, "0")
+	c.Assert(series.Points[0].Values[1].GetStringValue(), Equals, "webserver01")
+	c.Assert(series.Points[0].Values[2].GetDoubleValue(), Equals, 42.5)
+}
+
+func (self *PointSuite) TestParsingInvalidPutCommands(c *C) {
+	for _, line := range []string{
+		"put sys.cpu.user 1356998400",
+		"put sys.cpu.user foo 42.5",
+		"put sys.cpu.user 1356998400 42.5 host",
+	} {
+		_, err := ParsePutCommand(line)
+		c.Assert(err, NotNil, Commentf("%s", line))
+	}
+
+	point, err := ParsePutCommand("put sys.cpu.user 1356998400 foo")
+	c.Assert(err, IsNil)
+	_, err = point.ToSeries()
+	c.Assert(err, NotNil)
+}
+
+func (self *PointSuite) TestParsingPutBody(c *C) {
+	points, err := ParsePutBody([]byte(`{"metric": "sys.cpu.nice", "timestamp": 1346846400123, "value": 18, "tags": {"host": "web01"}}`))
+	c.Assert(err, IsNil)
+	c.Assert(points, HasLen, 1)
+	series, err := points[0].ToSeries()
+	c.Assert(err, IsNil)
+	// timestamps in milliseconds
+	c.Assert(series.Points[0].GetTimestamp(), Equals, int64(1346846400123000))
+	c.Assert(series.Points[0].Values[1].GetInt64Value(), Equals, int64(18))
+
+	points, err = ParsePutBody([]byte(`[
+	  {"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18, "tags": {"host": "web01"}},
+	  {"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 9, "tags": {"host": "web02"}}
+	]`))
+	c.Assert(err, IsNil)
+	c.Assert(points, HasLen, 2)
+	c.Assert(points[1].Tags["host"], Equals, "web02")
+
+	_, err = ParsePutBody([]byte(`{"metric": `))
+	c.Assert(err, NotNil)
+}
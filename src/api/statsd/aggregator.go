@@ -0,0 +1,135 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"protocol"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Aggregates the metrics received during a flush interval. On every
+// flush a point is written for every metric:
+//
+//	counters: count and rate (per second)
+//	timers:   count, mean, min, max, sum and upper_<percentile>
+//	gauges:   value
+//	sets:     count of unique values
+//
+// Counters, timers and sets are reset after every flush while gauges
+// keep their last value.
+type Aggregator struct {
+	lock        sync.Mutex
+	percentiles []float64
+	counters    map[string]float64
+	timers      map[string][]float64
+	gauges      map[string]float64
+	sets        map[string]map[string]bool
+}
+
+func NewAggregator(percentiles []float64) *Aggregator {
+	self := &Aggregator{percentiles: percentiles, gauges: make(map[string]float64)}
+	self.reset()
+	return self
+}
+
+func (self *Aggregator) reset() {
+	self.counters = make(map[string]float64)
+	self.timers = make(map[string][]float64)
+	self.sets = make(map[string]map[string]bool)
+}
+
+func (self *Aggregator) Add(metric *Metric) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	switch metric.Type {
+	case COUNTER:
+		self.counters[metric.Name] += metric.Value / metric.SampleRate
+	case TIMER:
+		self.timers[metric.Name] = append(self.timers[metric.Name], metric.Value)
+	case GAUGE:
+		if metric.Relative {
+			self.gauges[metric.Name] += metric.Value
+		} else {
+			self.gauges[metric.Name] = metric.Value
+		}
+	case SET:
+		set := self.sets[metric.Name]
+		if set == nil {
+			set = make(map[string]bool)
+			self.sets[metric.Name] = set
+		}
+		set[metric.SetValue] = true
+	}
+}
+
+// Returns the aggregated series and resets the aggregator. interval is
+// the number of seconds since the last flush and timestamp is in
+// microseconds
+func (self *Aggregator) Flush(timestamp int64, interval float64) []*protocol.Series {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	series := []*protocol.Series{}
+	for name, count := range self.counters {
+		series = append(series, newSeries(name, timestamp, []string{"count", "rate"}, []float64{count, count / interval}))
+	}
+	for name, values := range self.timers {
+		fields, stats := self.timerStats(values)
+		series = append(series, newSeries(name, timestamp, fields, stats))
+	}
+	for name, value := range self.gauges {
+		series = append(series, newSeries(name, timestamp, []string{"value"}, []float64{value}))
+	}
+	for name, set := range self.sets {
+		series = append(series, newSeries(name, timestamp, []string{"count"}, []float64{float64(len(set))}))
+	}
+	self.reset()
+	return series
+}
+
+func (self *Aggregator) timerStats(values []float64) ([]string, []float64) {
+	sort.Float64s(values)
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	count := float64(len(values))
+
+	fields := []string{"count", "mean", "min", "max", "sum"}
+	stats := []float64{count, sum / count, values[0], values[len(values)-1], sum}
+	for _, percentile := range self.percentiles {
+		// the value below which percentile % of the values fall
+		index := int(math.Ceil(percentile/100*count)) - 1
+		if index < 0 {
+			index = 0
+		}
+		if index >= len(values) {
+			index = len(values) - 1
+		}
+		fields = append(fields, percentileColumn(percentile))
+		stats = append(stats, values[index])
+	}
+	return fields, stats
+}
+
+// the column of the 99.9 percentile is upper_99_9
+func percentileColumn(percentile float64) string {
+	return "upper_" + strings.Replace(fmt.Sprintf("%g", percentile), ".", "_", -1)
+}
+
+func newSeries(name string, timestamp int64, fields []string, values []float64) *protocol.Series {
+	fieldValues := make([]*protocol.FieldValue, 0, len(values))
+	for i := range values {
+		fieldValues = append(fieldValues, &protocol.FieldValue{DoubleValue: &values[i]})
+	}
+	return &protocol.Series{
+		Name:   protocol.String(name),
+		Fields: fields,
+		Points: []*protocol.Point{
+			&protocol.Point{Timestamp: &timestamp, Values: fieldValues},
+		},
+	}
+}
@@ -0,0 +1,117 @@
+// package statsd provides a udp listener that understands the statsd
+// protocol. The metrics are aggregated over the flush interval and the
+// aggregates are written to a single database.
+package statsd
+
+import (
+	"configuration"
+	"coordinator"
+	"net"
+	"protocol"
+	"strings"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// the maximum size of a udp datagram
+const MAX_PACKET_SIZE = 64 * 1024
+
+type Server struct {
+	listenAddress string
+	database      string
+	flushInterval time.Duration
+	aggregator    *Aggregator
+	coordinator   coordinator.Coordinator
+	conn          *net.UDPConn
+	shutdown      chan bool
+	stopFlushing  chan bool
+}
+
+func NewServer(config *configuration.Configuration, coord coordinator.Coordinator) *Server {
+	self := &Server{}
+	self.listenAddress = config.StatsdPortString()
+	self.database = config.StatsdDatabase
+	self.flushInterval = config.StatsdFlushInterval
+	self.aggregator = NewAggregator(config.StatsdPercentiles)
+	self.coordinator = coord
+	self.shutdown = make(chan bool, 1)
+	self.stopFlushing = make(chan bool, 1)
+	return self
+}
+
+func (self *Server) ListenAndServe() {
+	addr, err := net.ResolveUDPAddr("udp", self.listenAddress)
+	if err != nil {
+		log.Error("StatsdServer: ResolveUDPAddr: ", err)
+		return
+	}
+	self.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Error("StatsdServer: Listen: ", err)
+		return
+	}
+	go self.flushPeriodically()
+	self.Serve(self.conn)
+}
+
+func (self *Server) Serve(conn *net.UDPConn) {
+	defer func() { self.shutdown <- true }()
+
+	buffer := make([]byte, MAX_PACKET_SIZE)
+	for {
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if strings.Contains(err.Error(), "closed network") {
+				return
+			}
+			log.Error("StatsdServer: Read: ", err)
+			continue
+		}
+		metrics, errors := ParsePacket(string(buffer[:n]))
+		for _, err := range errors {
+			log.Warn("StatsdServer: %s", err)
+		}
+		for _, metric := range metrics {
+			self.aggregator.Add(metric)
+		}
+	}
+}
+
+func (self *Server) Close() {
+	if self.conn != nil {
+		log.Info("StatsdServer: Closing statsd server")
+		self.conn.Close()
+		<-self.shutdown
+		self.stopFlushing <- true
+	}
+}
+
+func (self *Server) flushPeriodically() {
+	lastFlush := time.Now()
+	for {
+		select {
+		case <-time.After(self.flushInterval):
+		case <-self.stopFlushing:
+			self.flush(lastFlush)
+			return
+		}
+		lastFlush = self.flush(lastFlush)
+	}
+}
+
+func (self *Server) flush(lastFlush time.Time) time.Time {
+	now := time.Now()
+	series := self.aggregator.Flush(now.UnixNano()/int64(time.Microsecond), now.Sub(lastFlush).Seconds())
+	if err := self.writeSeries(series); err != nil {
+		log.Error("StatsdServer: Cannot write aggregates: %s", err)
+	}
+	return now
+}
+
+func (self *Server) writeSeries(series []*protocol.Series) error {
+	if len(series) == 0 {
+		return nil
+	}
+	return coordinator.WriteInputSeries(self.coordinator, self.database, series)
+}
@@ -0,0 +1,82 @@
+package statsd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	COUNTER = "c"
+	TIMER   = "ms"
+	GAUGE   = "g"
+	SET     = "s"
+)
+
+// A single statsd sample, e.g. requests:1|c|@0.1
+type Metric struct {
+	Name       string
+	Type       string
+	Value      float64
+	SetValue   string
+	SampleRate float64
+	// gauges that start with a sign are added to the current value
+	Relative bool
+}
+
+// Parses the metrics in a statsd packet, there's one metric per line
+func ParsePacket(packet string) ([]*Metric, []error) {
+	metrics := []*Metric{}
+	errors := []error{}
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		metric, err := ParseMetric(line)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, errors
+}
+
+func ParseMetric(line string) (*Metric, error) {
+	colon := strings.LastIndex(line, ":")
+	if colon <= 0 {
+		return nil, fmt.Errorf("Invalid statsd metric '%s'", line)
+	}
+	fields := strings.Split(line[colon+1:], "|")
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("Invalid statsd metric '%s'", line)
+	}
+
+	metric := &Metric{Name: line[:colon], Type: fields[1], SampleRate: 1}
+	switch metric.Type {
+	case SET:
+		metric.SetValue = fields[0]
+	case COUNTER, TIMER, GAUGE:
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value in statsd metric '%s'", line)
+		}
+		metric.Value = value
+		metric.Relative = metric.Type == GAUGE && (fields[0][0] == '+' || fields[0][0] == '-')
+	default:
+		return nil, fmt.Errorf("Unknown type in statsd metric '%s'", line)
+	}
+
+	if len(fields) == 3 {
+		if !strings.HasPrefix(fields[2], "@") {
+			return nil, fmt.Errorf("Invalid sample rate in statsd metric '%s'", line)
+		}
+		rate, err := strconv.ParseFloat(fields[2][1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return nil, fmt.Errorf("Invalid sample rate in statsd metric '%s'", line)
+		}
+		metric.SampleRate = rate
+	}
+	return metric, nil
+}
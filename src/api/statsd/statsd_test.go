@@ -0,0 +1,68 @@
+package statsd
+
+import (
+	. "launchpad.net/gocheck"
+	"protocol"
+	"testing"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type StatsdSuite struct{}
+
+var _ = Suite(&StatsdSuite{})
+
+func (self *StatsdSuite) TestParsingMetrics(c *C) {
+	metrics, errors := ParsePacket("requests:1|c|@0.5\nlatency:320|ms\nusers:bob|s\nload:-2|g\nbad metric\nfoo:1|x")
+	c.Assert(errors, HasLen, 2)
+	c.Assert(metrics, HasLen, 4)
+	c.Assert(*metrics[0], Equals, Metric{Name: "requests", Type: COUNTER, Value: 1, SampleRate: 0.5})
+	c.Assert(*metrics[1], Equals, Metric{Name: "latency", Type: TIMER, Value: 320, SampleRate: 1})
+	c.Assert(*metrics[2], Equals, Metric{Name: "users", Type: SET, SetValue: "bob", SampleRate: 1})
+	c.Assert(*metrics[3], Equals, Metric{Name: "load", Type: GAUGE, Value: -2, SampleRate: 1, Relative: true})
+
+	for _, line := range []string{"foo:1|c|0.5", "foo:1|c|@2", "foo:bar|c", ":1|c"} {
+		_, err := ParseMetric(line)
+		c.Assert(err, NotNil, Commentf("%s", line))
+	}
+}
+
+func seriesByName(series []*protocol.Series) map[string]map[string]float64 {
+	result := make(map[string]map[string]float64)
+	for _, s := range series {
+		values := make(map[string]float64)
+		for i, field := range s.Fields {
+			values[field] = s.Points[0].Values[i].GetDoubleValue()
+		}
+		result[s.GetName()] = values
+	}
+	return result
+}
+
+func (self *StatsdSuite) TestAggregatingMetrics(c *C) {
+	aggregator := NewAggregator([]float64{90, 99.9})
+	metrics, _ := ParsePacket("requests:1|c|@0.5\nrequests:3|c\nload:5|g\nload:+2|g\nusers:bob|s\nusers:bob|s\nusers:alice|s")
+	for i := 1; i <= 10; i++ {
+		metrics = append(metrics, &Metric{Name: "latency", Type: TIMER, Value: float64(i)})
+	}
+	for _, metric := range metrics {
+		aggregator.Add(metric)
+	}
+
+	series := seriesByName(aggregator.Flush(1000000, 10))
+	c.Assert(series, HasLen, 4)
+	c.Assert(series["requests"], DeepEquals, map[string]float64{"count": 5, "rate": 0.5})
+	c.Assert(series["load"], DeepEquals, map[string]float64{"value": 7})
+	c.Assert(series["users"], DeepEquals, map[string]float64{"count": 2})
+	c.Assert(series["latency"], DeepEquals, map[string]float64{
+		"count": 10, "mean": 5.5, "min": 1, "max": 10, "sum": 55, "upper_90": 9, "upper_99_9": 10,
+	})
+
+	// only the gauges are kept after a flush
+	series = seriesByName(aggregator.Flush(2000000, 10))
+	c.Assert(series, HasLen, 1)
+	c.Assert(series["load"], DeepEquals, map[string]float64{"value": 7})
+}
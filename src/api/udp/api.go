@@ -0,0 +1,101 @@
+// package udp provides a udp listener that writes the points it
+// receives to a single database. Every datagram is a json array of
+// series, the same payload that is posted to the http api.
+package udp
+
+import (
+	. "common"
+	"configuration"
+	"coordinator"
+	"encoding/json"
+	"net"
+	"protocol"
+	"strings"
+
+	log "code.google.com/p/log4go"
+)
+
+// the maximum size of a udp datagram
+const MAX_DATAGRAM_SIZE = 64 * 1024
+
+type Server struct {
+	listenAddress string
+	database      string
+	coordinator   coordinator.Coordinator
+	conn          *net.UDPConn
+	shutdown      chan bool
+}
+
+func NewServer(config *configuration.Configuration, coord coordinator.Coordinator) *Server {
+	self := &Server{}
+	self.listenAddress = config.UdpInputPortString()
+	self.database = config.UdpInputDatabase
+	self.coordinator = coord
+	self.shutdown = make(chan bool, 1)
+	return self
+}
+
+func (self *Server) ListenAndServe() {
+	addr, err := net.ResolveUDPAddr("udp", self.listenAddress)
+	if err != nil {
+		log.Error("UdpServer: ResolveUDPAddr: ", err)
+		return
+	}
+	self.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Error("UdpServer: Listen: ", err)
+		return
+	}
+	self.Serve(self.conn)
+}
+
+func (self *Server) Serve(conn *net.UDPConn) {
+	defer func() { self.shutdown <- true }()
+
+	buffer := make([]byte, MAX_DATAGRAM_SIZE)
+	for {
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if strings.Contains(err.Error(), "closed network") {
+				return
+			}
+			log.Error("UdpServer: Read: ", err)
+			continue
+		}
+		if err := self.handleDatagram(buffer[:n]); err != nil {
+			log.Error("UdpServer: Cannot write points: %s", err)
+		}
+	}
+}
+
+func (self *Server) Close() {
+	if self.conn != nil {
+		log.Info("UdpServer: Closing udp server")
+		self.conn.Close()
+		<-self.shutdown
+	}
+}
+
+func (self *Server) handleDatagram(data []byte) error {
+	serializedSeries := []*SerializedSeries{}
+	if err := json.Unmarshal(data, &serializedSeries); err != nil {
+		return err
+	}
+
+	series := make([]*protocol.Series, 0, len(serializedSeries))
+	for _, s := range serializedSeries {
+		if len(s.Points) == 0 {
+			continue
+		}
+		dataStoreSeries, err := ConvertToDataStoreSeries(s, MillisecondPrecision)
+		if err != nil {
+			return err
+		}
+		series = append(series, dataStoreSeries)
+	}
+	if len(series) == 0 {
+		return nil
+	}
+
+	return coordinator.WriteInputSeries(self.coordinator, self.database, series)
+}
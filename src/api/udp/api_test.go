@@ -0,0 +1,101 @@
+package udp
+
+import (
+	"cluster"
+	"common"
+	"coordinator"
+	. "launchpad.net/gocheck"
+	"net"
+	"protocol"
+	"testing"
+	"time"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type UdpSuite struct{}
+
+var _ = Suite(&UdpSuite{})
+
+type write struct {
+	user   common.User
+	db     string
+	series []*protocol.Series
+}
+
+// Only the writes are implemented, the other methods of the coordinator
+// aren't used by the udp input
+type mockCoordinator struct {
+	coordinator.Coordinator
+	writes chan *write
+}
+
+func (self *mockCoordinator) WriteSeriesData(user common.User, db string, series []*protocol.Series) error {
+	self.writes <- &write{user, db, series}
+	return nil
+}
+
+func newTestServer() (*Server, *mockCoordinator) {
+	coord := &mockCoordinator{writes: make(chan *write, 10)}
+	return &Server{database: "db1", coordinator: coord, shutdown: make(chan bool, 1)}, coord
+}
+
+func (self *UdpSuite) TestDatagramsAreWrittenAsTheInternalAdmin(c *C) {
+	server, coord := newTestServer()
+	err := server.handleDatagram([]byte(`[{"name": "cpu", "columns": ["time", "value"], "points": [[1400000000000, 1.5]]}]`))
+	c.Assert(err, IsNil)
+
+	c.Assert(coord.writes, HasLen, 1)
+	w := <-coord.writes
+	c.Assert(w.user, Equals, common.User(cluster.InternalClusterAdmin))
+	c.Assert(w.db, Equals, "db1")
+	c.Assert(w.series, HasLen, 1)
+	c.Assert(w.series[0].GetName(), Equals, "cpu")
+	c.Assert(w.series[0].Points[0].GetTimestamp(), Equals, int64(1400000000000000))
+	c.Assert(w.series[0].Points[0].Values[0].GetDoubleValue(), Equals, 1.5)
+}
+
+func (self *UdpSuite) TestSeriesWithoutPointsArentWritten(c *C) {
+	server, coord := newTestServer()
+	err := server.handleDatagram([]byte(`[{"name": "cpu", "columns": ["value"], "points": []}]`))
+	c.Assert(err, IsNil)
+	c.Assert(coord.writes, HasLen, 0)
+}
+
+func (self *UdpSuite) TestInvalidDatagramsArentWritten(c *C) {
+	server, coord := newTestServer()
+	c.Assert(server.handleDatagram([]byte(`[{"name": "cpu"`)), NotNil)
+	// the time has to be a number
+	c.Assert(server.handleDatagram([]byte(`[{"name": "cpu", "columns": ["time", "value"], "points": [["now", 1]]}]`)), NotNil)
+	c.Assert(coord.writes, HasLen, 0)
+}
+
+func (self *UdpSuite) TestServingDatagrams(c *C) {
+	server, coord := newTestServer()
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	conn, err := net.ListenUDP("udp", addr)
+	c.Assert(err, IsNil)
+	server.conn = conn
+	go server.Serve(conn)
+	defer server.Close()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	c.Assert(err, IsNil)
+	defer client.Close()
+	// the invalid datagram doesn't stop the server
+	_, err = client.Write([]byte(`not json`))
+	c.Assert(err, IsNil)
+	_, err = client.Write([]byte(`[{"name": "mem", "columns": ["value"], "points": [[2]]}]`))
+	c.Assert(err, IsNil)
+
+	select {
+	case w := <-coord.writes:
+		c.Assert(w.series[0].GetName(), Equals, "mem")
+	case <-time.After(time.Second):
+		c.Fatal("The datagram wasn't written")
+	}
+}
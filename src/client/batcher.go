@@ -0,0 +1,130 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_BATCH_SIZE     = 1000
+	DEFAULT_FLUSH_INTERVAL = time.Second
+)
+
+type BatchConfig struct {
+	// the points that are written at once, a batch is written as soon
+	// as it has that many points
+	Size int
+	// the time the points wait at most before they're written
+	FlushInterval time.Duration
+	Precision     TimePrecision
+	// called with the error and the series of a batch that couldn't be
+	// written in the background, the series are dropped
+	OnError func(err error, series []*Series)
+}
+
+// Accumulates the series that are added and writes them in batches
+type Batcher struct {
+	client    *Client
+	size      int
+	precision TimePrecision
+	onError   func(error, []*Series)
+	lock      sync.Mutex
+	series    []*Series
+	points    int
+	// serializes the writes so the batches are written in order
+	writeLock sync.Mutex
+	shutdown  chan bool
+	done      chan bool
+}
+
+// Returns a batcher that writes to the database of the client, it has
+// to be closed to write the last batch
+func (self *Client) NewBatcher(config *BatchConfig) *Batcher {
+	batcher := &Batcher{
+		client:    self,
+		size:      config.Size,
+		precision: config.Precision,
+		onError:   config.OnError,
+		shutdown:  make(chan bool),
+		done:      make(chan bool),
+	}
+	if batcher.size <= 0 {
+		batcher.size = DEFAULT_BATCH_SIZE
+	}
+	if batcher.precision == "" {
+		batcher.precision = Millisecond
+	}
+	interval := config.FlushInterval
+	if interval <= 0 {
+		interval = DEFAULT_FLUSH_INTERVAL
+	}
+	go batcher.flushPeriodically(interval)
+	return batcher
+}
+
+// Adds the series to the batch. The batch is written by the caller once
+// it's full, the error is the one of that write.
+func (self *Batcher) Add(series ...*Series) error {
+	self.lock.Lock()
+	for _, s := range series {
+		self.series = append(self.series, s)
+		self.points += len(s.Points)
+	}
+	full := self.points >= self.size
+	self.lock.Unlock()
+
+	if full {
+		return self.Flush()
+	}
+	return nil
+}
+
+// Writes the series that were added
+func (self *Batcher) Flush() error {
+	self.writeLock.Lock()
+	defer self.writeLock.Unlock()
+	return self.write(self.take())
+}
+
+// Returns the series that were added and empties the batch
+func (self *Batcher) take() []*Series {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	series := self.series
+	self.series = nil
+	self.points = 0
+	return series
+}
+
+func (self *Batcher) write(series []*Series) error {
+	if len(series) == 0 {
+		return nil
+	}
+	return self.client.WriteSeriesWithTimePrecision(series, self.precision)
+}
+
+func (self *Batcher) flushPeriodically(interval time.Duration) {
+	defer close(self.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.shutdown:
+			return
+		case <-ticker.C:
+			self.writeLock.Lock()
+			series := self.take()
+			if err := self.write(series); err != nil && self.onError != nil {
+				self.onError(err, series)
+			}
+			self.writeLock.Unlock()
+		}
+	}
+}
+
+// Stops the periodic writes and writes the last batch
+func (self *Batcher) Close() error {
+	close(self.shutdown)
+	<-self.done
+	return self.Flush()
+}
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_HOST          = "localhost:8086"
+	DEFAULT_RETRIES       = 3
+	DEFAULT_RETRY_BACKOFF = 100 * time.Millisecond
+)
+
+// The precision of the timestamps of the writes and the queries
+type TimePrecision string
+
+const (
+	Second      TimePrecision = "s"
+	Millisecond TimePrecision = "m"
+	Microsecond TimePrecision = "u"
+)
+
+type ClientConfig struct {
+	// the addresses of the servers, e.g. localhost:8086. The requests go
+	// to the first one until it fails, then to the next one.
+	Hosts    []string
+	Username string
+	Password string
+	Database string
+	IsSecure bool
+	// the client used for the requests, http.DefaultClient if it's nil
+	HttpClient *http.Client
+	// the attempts of a request after the first one, the requests are
+	// retried when a server can't be reached or returns a 5xx
+	Retries int
+	// the delay before the first retry, it doubles with every retry
+	RetryBackoff time.Duration
+}
+
+type Series struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Points  [][]interface{} `json:"points"`
+}
+
+// The error of a request the server refused, the requests that return
+// a 4xx aren't retried
+type ResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (self *ResponseError) Error() string {
+	return fmt.Sprintf("Server returned %d: %s", self.StatusCode, self.Body)
+}
+
+type Client struct {
+	hosts        []string
+	username     string
+	password     string
+	database     string
+	scheme       string
+	httpClient   *http.Client
+	retries      int
+	retryBackoff time.Duration
+	lock         sync.Mutex
+	// the index of the host the requests go to
+	current int
+}
+
+func NewClient(config *ClientConfig) (*Client, error) {
+	hosts := config.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{DEFAULT_HOST}
+	}
+	if config.Retries < 0 {
+		return nil, fmt.Errorf("Retries can't be negative")
+	}
+	self := &Client{
+		hosts:        hosts,
+		username:     config.Username,
+		password:     config.Password,
+		database:     config.Database,
+		scheme:       "http",
+		httpClient:   config.HttpClient,
+		retries:      config.Retries,
+		retryBackoff: config.RetryBackoff,
+	}
+	if config.IsSecure {
+		self.scheme = "https"
+	}
+	if self.httpClient == nil {
+		self.httpClient = http.DefaultClient
+	}
+	if self.retryBackoff == 0 {
+		self.retryBackoff = DEFAULT_RETRY_BACKOFF
+	}
+	return self, nil
+}
+
+func (self *Client) host() (int, string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.current, self.hosts[self.current]
+}
+
+// Moves the requests to the host after the one that failed, unless
+// another request did it already
+func (self *Client) failover(failed int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.current == failed {
+		self.current = (self.current + 1) % len(self.hosts)
+	}
+}
+
+// Sends the request and returns the body of the response. The request
+// is retried on the next host with a backoff if the host can't be
+// reached or returns a 5xx.
+func (self *Client) request(method, path string, params url.Values, body []byte) ([]byte, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("u", self.username)
+	params.Set("p", self.password)
+
+	backoff := self.retryBackoff
+	var err error
+	for attempt := 0; attempt <= self.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		index, host := self.host()
+		var data []byte
+		data, err = self.send(method, fmt.Sprintf("%s://%s%s?%s", self.scheme, host, path, params.Encode()), body)
+		if err == nil {
+			return data, nil
+		}
+		if e, ok := err.(*ResponseError); ok && e.StatusCode < 500 {
+			return nil, err
+		}
+		self.failover(index)
+	}
+	return nil, err
+}
+
+func (self *Client) send(method, url string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := self.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &ResponseError{resp.StatusCode, string(bytes.TrimSpace(data))}
+	}
+	return data, nil
+}
+
+func (self *Client) Ping() error {
+	_, err := self.request("GET", "/ping", nil, nil)
+	return err
+}
+
+func (self *Client) CreateDatabase(name string) error {
+	body, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return err
+	}
+	_, err = self.request("POST", "/db", nil, body)
+	return err
+}
+
+func (self *Client) DeleteDatabase(name string) error {
+	_, err := self.request("DELETE", "/db/"+url.QueryEscape(name), nil, nil)
+	return err
+}
+
+// Writes the series with timestamps in milliseconds, the points without
+// a time column get the time of the server
+func (self *Client) WriteSeries(series []*Series) error {
+	return self.WriteSeriesWithTimePrecision(series, Millisecond)
+}
+
+func (self *Client) WriteSeriesWithTimePrecision(series []*Series, precision TimePrecision) error {
+	body, err := json.Marshal(series)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("time_precision", string(precision))
+	_, err = self.request("POST", "/db/"+url.QueryEscape(self.database)+"/series", params, body)
+	return err
+}
+
+// Returns the series of the query with timestamps in milliseconds
+func (self *Client) Query(query string) ([]*Series, error) {
+	return self.QueryWithTimePrecision(query, Millisecond)
+}
+
+func (self *Client) QueryWithTimePrecision(query string, precision TimePrecision) ([]*Series, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("time_precision", string(precision))
+	data, err := self.request("GET", "/db/"+url.QueryEscape(self.database)+"/series", params, nil)
+	if err != nil {
+		return nil, err
+	}
+	series := []*Series{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// the timestamps and the integers don't fit in a float64
+	decoder.UseNumber()
+	if err := decoder.Decode(&series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
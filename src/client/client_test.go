@@ -0,0 +1,134 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ClientSuite struct{}
+
+var _ = Suite(&ClientSuite{})
+
+// Records the series that are written to it
+type mockServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	status   int
+	requests int
+	written  []*Series
+}
+
+func newMockServer(status int) *mockServer {
+	self := &mockServer{status: status}
+	self.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		self.requests++
+		if self.status != http.StatusOK {
+			w.WriteHeader(self.status)
+			w.Write([]byte("failed"))
+			return
+		}
+		if r.Method == "POST" {
+			body, _ := ioutil.ReadAll(r.Body)
+			series := []*Series{}
+			json.Unmarshal(body, &series)
+			self.written = append(self.written, series...)
+			return
+		}
+		w.Write([]byte(`[{"name":"cpu","columns":["time","value"],"points":[[1400000000000,1]]}]`))
+	}))
+	return self
+}
+
+func (self *mockServer) host() string {
+	return strings.TrimPrefix(self.URL, "http://")
+}
+
+func (self *mockServer) points() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	points := 0
+	for _, s := range self.written {
+		points += len(s.Points)
+	}
+	return points
+}
+
+func (self *ClientSuite) TestFailover(c *C) {
+	down := newMockServer(http.StatusServiceUnavailable)
+	defer down.Close()
+	up := newMockServer(http.StatusOK)
+	defer up.Close()
+
+	client, err := NewClient(&ClientConfig{Hosts: []string{down.host(), up.host()}, Database: "db1", Retries: 2, RetryBackoff: time.Millisecond})
+	c.Assert(err, IsNil)
+	series, err := client.Query("select * from cpu")
+	c.Assert(err, IsNil)
+	c.Assert(series, HasLen, 1)
+	c.Assert(series[0].Points[0][0], Equals, json.Number("1400000000000"))
+	c.Assert(down.requests, Equals, 1)
+
+	// the requests keep going to the host that works
+	c.Assert(client.WriteSeries([]*Series{{Name: "cpu", Columns: []string{"value"}, Points: [][]interface{}{{1}}}}), IsNil)
+	c.Assert(down.requests, Equals, 1)
+	c.Assert(up.points(), Equals, 1)
+}
+
+func (self *ClientSuite) TestClientErrorsAreNotRetried(c *C) {
+	server := newMockServer(http.StatusBadRequest)
+	defer server.Close()
+
+	client, err := NewClient(&ClientConfig{Hosts: []string{server.host()}, Database: "db1", Retries: 3, RetryBackoff: time.Millisecond})
+	c.Assert(err, IsNil)
+	_, err = client.Query("select * from")
+	c.Assert(err, FitsTypeOf, &ResponseError{})
+	c.Assert(err.(*ResponseError).StatusCode, Equals, http.StatusBadRequest)
+	c.Assert(server.requests, Equals, 1)
+}
+
+func (self *ClientSuite) TestBatcher(c *C) {
+	server := newMockServer(http.StatusOK)
+	defer server.Close()
+
+	client, err := NewClient(&ClientConfig{Hosts: []string{server.host()}, Database: "db1"})
+	c.Assert(err, IsNil)
+	batcher := client.NewBatcher(&BatchConfig{Size: 3, FlushInterval: time.Hour})
+	point := &Series{Name: "cpu", Columns: []string{"value"}, Points: [][]interface{}{{1}}}
+	c.Assert(batcher.Add(point, point), IsNil)
+	c.Assert(server.points(), Equals, 0)
+	c.Assert(batcher.Add(point), IsNil)
+	c.Assert(server.points(), Equals, 3)
+
+	c.Assert(batcher.Add(point), IsNil)
+	c.Assert(batcher.Close(), IsNil)
+	c.Assert(server.points(), Equals, 4)
+}
+
+func (self *ClientSuite) TestBatcherFlushInterval(c *C) {
+	server := newMockServer(http.StatusOK)
+	defer server.Close()
+
+	client, err := NewClient(&ClientConfig{Hosts: []string{server.host()}, Database: "db1"})
+	c.Assert(err, IsNil)
+	batcher := client.NewBatcher(&BatchConfig{Size: 100, FlushInterval: 10 * time.Millisecond})
+	defer batcher.Close()
+	c.Assert(batcher.Add(&Series{Name: "cpu", Columns: []string{"value"}, Points: [][]interface{}{{1}}}), IsNil)
+	for i := 0; i < 100 && server.points() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(server.points(), Equals, 1)
+}
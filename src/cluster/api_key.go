@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	API_KEY_ID_LENGTH     = 8
+	API_KEY_SECRET_LENGTH = 24
+	// the names of the api keys are the prefix followed by the id, the
+	// names of the users can't contain a colon
+	API_KEY_USER_PREFIX = "api_key:"
+)
+
+// An api key is a principal for automation, it can read and/or write
+// the databases it's scoped to and nothing else. The key is given to
+// the client as <id>.<secret>, only the sha256 of the secret is
+// stored. Since the secret is random and long, a fast hash is enough
+// and we don't have to bcrypt it on every request.
+type ApiKey struct {
+	Id           string   `json:"id"`
+	Name         string   `json:"name"`
+	Hash         string   `json:"hash"`
+	Databases    []string `json:"databases"`
+	Read         bool     `json:"read"`
+	Write        bool     `json:"write"`
+	CreatedBy    string   `json:"created_by"`
+	CreatedAt    int64    `json:"created_at"`
+	IsKeyDeleted bool     `json:"is_deleted"`
+}
+
+// Generates a new key, returns the key that should be given to the
+// client and the api key with the id and hash set
+func NewApiKey(name string, databases []string, read, write bool) (string, *ApiKey, error) {
+	id, err := randomHex(API_KEY_ID_LENGTH)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(API_KEY_SECRET_LENGTH)
+	if err != nil {
+		return "", nil, err
+	}
+	key := &ApiKey{
+		Id:        id,
+		Name:      name,
+		Hash:      hashApiKeySecret(secret),
+		Databases: databases,
+		Read:      read,
+		Write:     write,
+	}
+	return id + "." + secret, key, nil
+}
+
+// Splits a key given by the client into its id and secret
+func ParseApiKey(key string) (string, string, error) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("Invalid api key")
+	}
+	return parts[0], parts[1], nil
+}
+
+func randomHex(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashApiKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (self *ApiKey) isValidSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashApiKeySecret(secret)), []byte(self.Hash)) == 1
+}
+
+func (self *ApiKey) HasScope(db string) bool {
+	for _, d := range self.Databases {
+		if d == db {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *ApiKey) GetName() string {
+	return API_KEY_USER_PREFIX + self.Id
+}
+
+func (self *ApiKey) IsDeleted() bool {
+	return self.IsKeyDeleted
+}
+
+func (self *ApiKey) IsClusterAdmin() bool {
+	return false
+}
+
+func (self *ApiKey) IsDbAdmin(db string) bool {
+	return false
+}
+
+func (self *ApiKey) GetDb() string {
+	return ""
+}
+
+func (self *ApiKey) HasWriteAccess(_ string) bool {
+	return self.Write
+}
+
+func (self *ApiKey) HasReadAccess(_ string) bool {
+	return self.Read
+}
@@ -0,0 +1,39 @@
+package cluster
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type ApiKeySuite struct{}
+
+var _ = Suite(&ApiKeySuite{})
+
+func (self *ApiKeySuite) TestAuthentication(c *C) {
+	config := NewClusterConfiguration(nil, nil, nil, nil)
+	key, apiKey, err := NewApiKey("automation", []string{"db1"}, false, true)
+	c.Assert(err, IsNil)
+	config.SaveApiKey(apiKey)
+
+	id, secret, err := ParseApiKey(key)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, apiKey.Id)
+	c.Assert(apiKey.Hash, Not(Equals), secret)
+
+	user, err := config.AuthenticateApiKey("db1", key)
+	c.Assert(err, IsNil)
+	c.Assert(user.HasWriteAccess("cpu"), Equals, true)
+	c.Assert(user.HasReadAccess("cpu"), Equals, false)
+	c.Assert(user.IsClusterAdmin(), Equals, false)
+
+	_, err = config.AuthenticateApiKey("db2", key)
+	c.Assert(err, NotNil)
+	_, err = config.AuthenticateApiKey("db1", id+".wrong")
+	c.Assert(err, NotNil)
+	_, err = config.AuthenticateApiKey("db1", secret)
+	c.Assert(err, NotNil)
+
+	apiKey.IsKeyDeleted = true
+	config.SaveApiKey(apiKey)
+	_, err = config.AuthenticateApiKey("db1", key)
+	c.Assert(err, NotNil)
+}
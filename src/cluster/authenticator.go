@@ -0,0 +1,129 @@
+package cluster
+
+import (
+	"common"
+	"errors"
+
+	log "code.google.com/p/log4go"
+)
+
+var ErrInvalidCredentials = errors.New("Invalid username/password")
+
+// Verifies the credentials of users with an external service. Users
+// that are authenticated get the roles that their groups are mapped
+// to, the users of the cluster configuration are used if the external
+// service rejects the credentials or can't be reached.
+type Authenticator interface {
+	// Returns the groups of the user, ErrInvalidCredentials if the
+	// credentials are wrong
+	Authenticate(username, password string) ([]string, error)
+}
+
+// Sets the authenticator that's tried before the users of the cluster
+// configuration. groupRoles maps the groups to the roles that their
+// users get and the users of the admin groups are cluster admins.
+func (self *ClusterConfiguration) SetAuthenticator(authenticator Authenticator, groupRoles map[string][]string, adminGroups []string) {
+	self.authenticator = authenticator
+	self.authenticatorGroupRoles = groupRoles
+	self.authenticatorAdminGroups = adminGroups
+}
+
+// Returns the groups of the user if the authenticator accepts the
+// credentials, ok is false otherwise
+func (self *ClusterConfiguration) authenticateExternalUser(username, password string) (groups []string, ok bool) {
+	if self.authenticator == nil || password == "" {
+		return nil, false
+	}
+	groups, err := self.authenticator.Authenticate(username, password)
+	if err == ErrInvalidCredentials {
+		return nil, false
+	}
+	if err != nil {
+		log.Warn("Cannot authenticate %s with the external authenticator, falling back to the local users: %s", username, err)
+		return nil, false
+	}
+	return groups, true
+}
+
+func (self *ClusterConfiguration) isExternalAdmin(groups []string) bool {
+	for _, group := range groups {
+		for _, adminGroup := range self.authenticatorAdminGroups {
+			if group == adminGroup {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (self *ClusterConfiguration) externalRoles(groups []string) []string {
+	var roles []string
+	seen := map[string]bool{}
+	for _, group := range groups {
+		for _, role := range self.authenticatorGroupRoles[group] {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// Returns the user if the authenticator accepts the credentials and
+// the user's groups give it permissions on the database, nil otherwise
+func (self *ClusterConfiguration) authenticateExternalDbUser(db, username, password string) common.User {
+	groups, ok := self.authenticateExternalUser(username, password)
+	if !ok {
+		return nil
+	}
+	if self.isExternalAdmin(groups) {
+		return self.ExternalUser(db, username, false, nil)
+	}
+	user := self.ExternalUser(db, username, true, self.externalRoles(groups))
+	if user == nil {
+		log.Debug("The groups of %s don't give any permissions on %s", username, db)
+	}
+	return user
+}
+
+func (self *ClusterConfiguration) authenticateExternalClusterAdmin(username, password string) common.User {
+	groups, ok := self.authenticateExternalUser(username, password)
+	if !ok || !self.isExternalAdmin(groups) {
+		return nil
+	}
+	return self.ExternalUser("", username, false, nil)
+}
+
+// Returns a user that the authenticator accepted with the given roles,
+// nil if the roles don't give it any permissions on the database. The
+// other servers build the user the same way when they run its queries,
+// a local user with the same name is a different user.
+func (self *ClusterConfiguration) ExternalUser(db, username string, isDbUser bool, roles []string) common.User {
+	if !isDbUser {
+		return &ClusterAdmin{CommonUser: CommonUser{Name: username, IsExternal: true}}
+	}
+	user := &DbUser{
+		CommonUser: CommonUser{Name: username, IsExternal: true},
+		Db:         db,
+		Roles:      roles,
+		roleLookup: self.GetRole,
+	}
+	if len(user.rolePermissions()) == 0 {
+		return nil
+	}
+	return user
+}
+
+// Returns the roles of the user and true if the authenticator accepted
+// it, the requests to the other servers carry them so they don't look
+// up the user
+func externalIdentity(user common.User) ([]string, bool) {
+	switch u := common.UnwrapUser(user).(type) {
+	case *DbUser:
+		return u.Roles, u.IsExternal
+	case *ClusterAdmin:
+		return nil, u.IsExternal
+	}
+	return nil, false
+}
@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"common"
+	"fmt"
+
+	. "launchpad.net/gocheck"
+)
+
+type AuthenticatorSuite struct{}
+
+var _ = Suite(&AuthenticatorSuite{})
+
+type fakeAuthenticator struct {
+	groups map[string][]string
+	err    error
+}
+
+func (self *fakeAuthenticator) Authenticate(username, password string) ([]string, error) {
+	if self.err != nil {
+		return nil, self.err
+	}
+	groups, ok := self.groups[username]
+	if !ok || password != "secret" {
+		return nil, ErrInvalidCredentials
+	}
+	return groups, nil
+}
+
+func (self *AuthenticatorSuite) TestExternalUsers(c *C) {
+	config := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(config.CreateDatabase("db1", 1), IsNil)
+	config.SaveRole(&Role{Name: "readers", Permissions: map[string]*RolePermission{
+		"db1": {ReadFrom: []*Matcher{{true, ".*"}}},
+	}})
+	authenticator := &fakeAuthenticator{groups: map[string][]string{
+		"alice": {"ops"},
+		"bob":   {"admins"},
+		"carol": {"sales"},
+	}}
+	config.SetAuthenticator(authenticator, map[string][]string{"ops": {"readers"}}, []string{"admins"})
+
+	user, err := config.AuthenticateDbUser("db1", "alice", "secret")
+	c.Assert(err, IsNil)
+	c.Assert(user.HasReadAccess("cpu"), Equals, true)
+	c.Assert(user.HasWriteAccess("cpu"), Equals, false)
+	c.Assert(user.IsClusterAdmin(), Equals, false)
+	_, err = config.AuthenticateClusterAdmin("alice", "secret")
+	c.Assert(err, NotNil)
+	_, err = config.AuthenticateDbUser("db1", "alice", "wrong")
+	c.Assert(err, NotNil)
+
+	user, err = config.AuthenticateClusterAdmin("bob", "secret")
+	c.Assert(err, IsNil)
+	c.Assert(user.IsClusterAdmin(), Equals, true)
+
+	// the groups of carol don't give any permissions on db1
+	_, err = config.AuthenticateDbUser("db1", "carol", "secret")
+	c.Assert(err, NotNil)
+}
+
+// the servers that run the queries of an external user build it from
+// the roles in the request, a local user with the same name is ignored
+func (self *AuthenticatorSuite) TestExternalUsersOnOtherServers(c *C) {
+	config := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(config.CreateDatabase("db1", 1), IsNil)
+	config.SaveRole(&Role{Name: "readers", Permissions: map[string]*RolePermission{
+		"db1": {ReadFrom: []*Matcher{{true, ".*"}}},
+	}})
+	config.SaveDbUser(&DbUser{CommonUser: CommonUser{Name: "alice", CacheKey: "db1%alice"}, Db: "db1", IsAdmin: true})
+	authenticator := &fakeAuthenticator{groups: map[string][]string{"alice": {"ops"}}}
+	config.SetAuthenticator(authenticator, map[string][]string{"ops": {"readers"}}, nil)
+
+	user, err := config.AuthenticateDbUser("db1", "alice", "secret")
+	c.Assert(err, IsNil)
+	roles, ok := externalIdentity(common.UserWithRequestId(user, "1"))
+	c.Assert(ok, Equals, true)
+	c.Assert(roles, DeepEquals, []string{"readers"})
+
+	remote := config.ExternalUser("db1", "alice", true, roles)
+	c.Assert(remote, NotNil)
+	c.Assert(remote.HasReadAccess("cpu"), Equals, true)
+	c.Assert(remote.IsDbAdmin("db1"), Equals, false)
+	c.Assert(config.ExternalUser("db1", "alice", true, nil), IsNil)
+
+	_, ok = externalIdentity(config.GetDbUser("db1", "alice"))
+	c.Assert(ok, Equals, false)
+}
+
+func (self *AuthenticatorSuite) TestFallbackToLocalUsers(c *C) {
+	config := NewClusterConfiguration(nil, nil, nil, nil)
+	hash, err := HashPassword("password")
+	c.Assert(err, IsNil)
+	config.SaveDbUser(&DbUser{CommonUser: CommonUser{Name: "dave", Hash: string(hash), CacheKey: "db1%dave"}, Db: "db1"})
+	authenticator := &fakeAuthenticator{groups: map[string][]string{}}
+	config.SetAuthenticator(authenticator, nil, nil)
+
+	_, err = config.AuthenticateDbUser("db1", "dave", "password")
+	c.Assert(err, IsNil)
+
+	// the local users are used if the server can't be reached
+	authenticator.err = fmt.Errorf("connection refused")
+	_, err = config.AuthenticateDbUser("db1", "dave", "password")
+	c.Assert(err, IsNil)
+}
+
+func (self *AuthenticatorSuite) TestLdapEscaping(c *C) {
+	c.Assert(escapeLdapDn("alice"), Equals, "alice")
+	c.Assert(escapeLdapDn("smith, john"), Equals, "smith\\, john")
+	c.Assert(escapeLdapDn("#admin "), Equals, "\\#admin\\ ")
+	c.Assert(escapeLdapFilter("al*ce"), Equals, "al\\2ace")
+	c.Assert(escapeLdapFilter("(uid=x)"), Equals, "\\28uid=x\\29")
+}
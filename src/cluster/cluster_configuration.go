@@ -14,6 +14,7 @@ import (
 	"parser"
 	"protocol"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 	"wal"
@@ -38,21 +39,41 @@ type WAL interface {
 	CreateCheckpoint() error
 	RecoverServerFromRequestNumber(requestNumber uint32, shardIds []uint32, yield func(request *protocol.Request, shardId uint32) error) error
 	RecoverServerFromLastCommit(serverId uint32, shardIds []uint32, yield func(request *protocol.Request, shardId uint32) error) error
+	Status() *wal.Status
 }
 
 type ShardCreator interface {
 	// the shard creator expects all shards to be of the same type (long term or short term) and have the same
 	// start and end times. This is called to create the shard set for a given duration.
 	CreateShards(shards []*NewShardData) ([]*ShardData, error)
+	// drops the replicas of the shard on the servers, it's called once a
+	// shard that's moved has been copied
+	DropShard(id uint32, serverIds []uint32) error
 }
 
+// Gets notified of the changes to the cluster, e.g. the servers that
+// were added and the shards that were created or dropped. Every server
+// applies the changes, so every server notifies its handler. The
+// handler is called with the locks of the configuration held and
+// shouldn't block.
+type ClusterEventHandler interface {
+	ClusterEvent(eventType string, details map[string]interface{})
+}
+
+// The events of the cluster configuration
+const (
+	SERVER_ADDED_EVENT  = "server_added"
+	SHARD_CREATED_EVENT = "shard_created"
+	SHARD_DROPPED_EVENT = "shard_dropped"
+)
+
 const (
 	FIRST_LOWER_CASE_CHARACTER = uint8('a')
 )
 
 /*
-  This struct stores all the metadata confiugration information about a running cluster. This includes
-  the servers in the cluster and their state, databases, users, and which continuous queries are running.
+This struct stores all the metadata confiugration information about a running cluster. This includes
+the servers in the cluster and their state, databases, users, and which continuous queries are running.
 */
 type ClusterConfiguration struct {
 	createDatabaseLock         sync.RWMutex
@@ -60,12 +81,25 @@ type ClusterConfiguration struct {
 	usersLock                  sync.RWMutex
 	clusterAdmins              map[string]*ClusterAdmin
 	dbUsers                    map[string]map[string]*DbUser
+	apiKeys                    map[string]*ApiKey
+	writeSubscriptions         map[string]*WriteSubscription
+	writeSubscriptionsLock     sync.RWMutex
+	roles                      map[string]*Role
+	rolesLock                  sync.RWMutex
+	quotas                     map[string]*DatabaseQuota
+	quotasLock                 sync.RWMutex
+	tenants                    map[string]*Tenant
+	tenantsLock                sync.RWMutex
+	authenticator              Authenticator
+	authenticatorGroupRoles    map[string][]string
+	authenticatorAdminGroups   []string
 	servers                    []*ClusterServer
 	serversLock                sync.RWMutex
 	continuousQueries          map[string][]*ContinuousQuery
 	continuousQueriesLock      sync.RWMutex
 	ParsedContinuousQueries    map[string]map[uint32]*parser.SelectQuery
 	continuousQueryTimestamp   time.Time
+	continuousQueryStats       map[string]map[uint32]*ContinuousQueryStats
 	LocalServerId              uint32
 	config                     *configuration.Configuration
 	addedLocalServerWait       chan bool
@@ -83,6 +117,12 @@ type ClusterConfiguration struct {
 	shardsByIdLock             sync.RWMutex
 	LocalRaftName              string
 	writeBuffers               []*WriteBuffer
+	scrubber                   *ShardScrubber
+	jobs                       *JobRegistry
+	eventHandler               ClusterEventHandler
+	// the local shards that are being repaired
+	repairs     map[uint32]bool
+	repairsLock sync.Mutex
 }
 
 type ContinuousQuery struct {
@@ -104,8 +144,14 @@ func NewClusterConfiguration(
 		DatabaseReplicationFactors: make(map[string]uint8),
 		clusterAdmins:              make(map[string]*ClusterAdmin),
 		dbUsers:                    make(map[string]map[string]*DbUser),
+		apiKeys:                    make(map[string]*ApiKey),
+		writeSubscriptions:         make(map[string]*WriteSubscription),
+		roles:                      make(map[string]*Role),
+		quotas:                     make(map[string]*DatabaseQuota),
+		tenants:                    make(map[string]*Tenant),
 		continuousQueries:          make(map[string][]*ContinuousQuery),
 		ParsedContinuousQueries:    make(map[string]map[uint32]*parser.SelectQuery),
+		continuousQueryStats:       make(map[string]map[uint32]*ContinuousQueryStats),
 		servers:                    make([]*ClusterServer, 0),
 		config:                     config,
 		addedLocalServerWait:       make(chan bool, 1),
@@ -116,6 +162,8 @@ func NewClusterConfiguration(
 		shortTermShards:            make([]*ShardData, 0),
 		random:                     rand.New(rand.NewSource(time.Now().UnixNano())),
 		shardsById:                 make(map[uint32]*ShardData, 0),
+		jobs:                       NewJobRegistry(),
+		repairs:                    make(map[uint32]bool),
 	}
 }
 
@@ -123,6 +171,16 @@ func (self *ClusterConfiguration) SetShardCreator(shardCreator ShardCreator) {
 	self.shardCreator = shardCreator
 }
 
+func (self *ClusterConfiguration) SetEventHandler(handler ClusterEventHandler) {
+	self.eventHandler = handler
+}
+
+func (self *ClusterConfiguration) fireEvent(eventType string, details map[string]interface{}) {
+	if self.eventHandler != nil {
+		self.eventHandler.ClusterEvent(eventType, details)
+	}
+}
+
 // called by the server, this will wake up every 10 mintues to see if it should
 // create a shard for the next window of time. This way shards get created before
 // a bunch of writes stream in and try to create it all at the same time.
@@ -143,7 +201,7 @@ func (self *ClusterConfiguration) automaticallyCreateFutureShard(shards []*Shard
 		return
 	}
 	latestShard := shards[0]
-	if latestShard.endTime.Add(-15*time.Minute).Unix() < time.Now().Unix() {
+	if latestShard.endTime.Add(-15*time.Minute).Unix() < common.Now().Unix() {
 		newShardTime := latestShard.endTime.Add(time.Second)
 		microSecondEpochForNewShard := newShardTime.Unix() * 1000 * 1000
 		log.Info("Automatically creating shard for %s", newShardTime.Format("Mon Jan 2 15:04:05 -0700 MST 2006"))
@@ -171,6 +229,11 @@ func (self *ClusterConfiguration) WaitForLocalServerLoaded() {
 	<-self.addedLocalServerWait
 }
 
+// Returns true once the local server was added to the cluster
+func (self *ClusterConfiguration) HasLocalServer() bool {
+	return self.addedLocalServer
+}
+
 func (self *ClusterConfiguration) GetServerByRaftName(name string) *ClusterServer {
 	for _, server := range self.servers {
 		if server.RaftName == name {
@@ -216,6 +279,12 @@ func (self *ClusterConfiguration) AddPotentialServer(server *ClusterServer) {
 	self.servers = append(self.servers, server)
 	server.Id = uint32(len(self.servers))
 	log.Info("Added server to cluster config: %d, %s, %s", server.Id, server.RaftConnectionString, server.ProtobufConnectionString)
+	self.fireEvent(SERVER_ADDED_EVENT, map[string]interface{}{
+		"id":                       server.Id,
+		"raftName":                 server.RaftName,
+		"raftConnectionString":     server.RaftConnectionString,
+		"protobufConnectionString": server.ProtobufConnectionString,
+	})
 	log.Info("Checking whether this is the local server new: %s, local: %s\n", self.config.ProtobufConnectionString(), server.ProtobufConnectionString)
 	if server.RaftName != self.LocalRaftName {
 		log.Info("Connecting to ProtobufServer: %s", server.ProtobufConnectionString, self.config.ProtobufConnectionString())
@@ -246,6 +315,14 @@ func (self *ClusterConfiguration) GetDatabases() []*Database {
 	return dbs
 }
 
+func (self *ClusterConfiguration) DatabaseExists(name string) bool {
+	self.createDatabaseLock.RLock()
+	defer self.createDatabaseLock.RUnlock()
+
+	_, ok := self.DatabaseReplicationFactors[name]
+	return ok
+}
+
 func (self *ClusterConfiguration) CreateDatabase(name string, replicationFactor uint8) error {
 	self.createDatabaseLock.Lock()
 	defer self.createDatabaseLock.Unlock()
@@ -271,6 +348,28 @@ func (self *ClusterConfiguration) DropDatabase(name string) error {
 	defer self.usersLock.Unlock()
 
 	delete(self.dbUsers, name)
+
+	self.writeSubscriptionsLock.Lock()
+	defer self.writeSubscriptionsLock.Unlock()
+	for subscriptionName, subscription := range self.writeSubscriptions {
+		if subscription.Database == name {
+			delete(self.writeSubscriptions, subscriptionName)
+		}
+	}
+
+	self.rolesLock.Lock()
+	defer self.rolesLock.Unlock()
+	for _, role := range self.roles {
+		delete(role.Permissions, name)
+	}
+
+	self.quotasLock.Lock()
+	defer self.quotasLock.Unlock()
+	delete(self.quotas, name)
+
+	self.tenantsLock.Lock()
+	defer self.tenantsLock.Unlock()
+	self.removeTenantDatabase(name)
 	return nil
 }
 
@@ -330,6 +429,7 @@ func (self *ClusterConfiguration) DeleteContinuousQuery(db string, id uint32) er
 			q[len(q)-1], q[i], q = nil, q[len(q)-1], q[:len(q)-1]
 			self.continuousQueries[db] = q
 			delete(self.ParsedContinuousQueries[db], id)
+			delete(self.continuousQueryStats[db], id)
 			break
 		}
 	}
@@ -384,6 +484,7 @@ func (self *ClusterConfiguration) SaveDbUser(u *DbUser) {
 		dbUsers = map[string]*DbUser{}
 		self.dbUsers[db] = dbUsers
 	}
+	u.roleLookup = self.GetRole
 	dbUsers[u.GetName()] = u
 }
 
@@ -429,30 +530,167 @@ func (self *ClusterConfiguration) SaveClusterAdmin(u *ClusterAdmin) {
 	self.clusterAdmins[u.GetName()] = u
 }
 
+func (self *ClusterConfiguration) GetApiKeys() []*ApiKey {
+	self.usersLock.RLock()
+	defer self.usersLock.RUnlock()
+
+	keys := make([]*ApiKey, 0, len(self.apiKeys))
+	for _, key := range self.apiKeys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (self *ClusterConfiguration) GetApiKey(id string) *ApiKey {
+	self.usersLock.RLock()
+	defer self.usersLock.RUnlock()
+
+	return self.apiKeys[id]
+}
+
+func (self *ClusterConfiguration) SaveApiKey(key *ApiKey) {
+	self.usersLock.Lock()
+	defer self.usersLock.Unlock()
+	if key.IsDeleted() {
+		delete(self.apiKeys, key.Id)
+		return
+	}
+	self.apiKeys[key.Id] = key
+}
+
+func (self *ClusterConfiguration) GetWriteSubscriptions() []*WriteSubscription {
+	self.writeSubscriptionsLock.RLock()
+	defer self.writeSubscriptionsLock.RUnlock()
+
+	subscriptions := make([]*WriteSubscription, 0, len(self.writeSubscriptions))
+	for _, subscription := range self.writeSubscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions
+}
+
+func (self *ClusterConfiguration) GetWriteSubscription(name string) *WriteSubscription {
+	self.writeSubscriptionsLock.RLock()
+	defer self.writeSubscriptionsLock.RUnlock()
+
+	return self.writeSubscriptions[name]
+}
+
+// Returns the subscriptions that forward the writes to the given
+// database, it's called on every write
+func (self *ClusterConfiguration) GetDatabaseWriteSubscriptions(db string) []*WriteSubscription {
+	self.writeSubscriptionsLock.RLock()
+	defer self.writeSubscriptionsLock.RUnlock()
+
+	var subscriptions []*WriteSubscription
+	for _, subscription := range self.writeSubscriptions {
+		if subscription.Database == db {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions
+}
+
+func (self *ClusterConfiguration) SaveWriteSubscription(subscription *WriteSubscription) {
+	self.writeSubscriptionsLock.Lock()
+	defer self.writeSubscriptionsLock.Unlock()
+	if subscription.IsDeleted {
+		delete(self.writeSubscriptions, subscription.Name)
+		return
+	}
+	self.writeSubscriptions[subscription.Name] = subscription
+}
+
+func (self *ClusterConfiguration) GetRoles() []*Role {
+	self.rolesLock.RLock()
+	defer self.rolesLock.RUnlock()
+
+	roles := make([]*Role, 0, len(self.roles))
+	for _, role := range self.roles {
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+func (self *ClusterConfiguration) GetRole(name string) *Role {
+	self.rolesLock.RLock()
+	defer self.rolesLock.RUnlock()
+
+	return self.roles[name]
+}
+
+// Creates or replaces the role, the users that have the role get the
+// new permissions right away. Deleting a role removes it from its
+// users, so a role that's created again with the same name doesn't
+// give them any permissions.
+func (self *ClusterConfiguration) SaveRole(role *Role) {
+	self.rolesLock.Lock()
+	if !role.IsDeleted {
+		self.roles[role.Name] = role
+		self.rolesLock.Unlock()
+		return
+	}
+	delete(self.roles, role.Name)
+	self.rolesLock.Unlock()
+
+	self.usersLock.Lock()
+	defer self.usersLock.Unlock()
+	for _, dbUsers := range self.dbUsers {
+		for _, user := range dbUsers {
+			// the slice is replaced since users may be checking their
+			// access without the lock
+			var roles []string
+			for _, name := range user.Roles {
+				if name != role.Name {
+					roles = append(roles, name)
+				}
+			}
+			user.Roles = roles
+		}
+	}
+}
+
 type SavedConfiguration struct {
 	Databases         map[string]uint8
 	Admins            map[string]*ClusterAdmin
 	DbUsers           map[string]map[string]*DbUser
+	ApiKeys           map[string]*ApiKey
+	Subscriptions     map[string]*WriteSubscription
+	Roles             map[string]*Role
+	Quotas            map[string]*DatabaseQuota
+	Tenants           map[string]*Tenant
 	Servers           []*ClusterServer
 	ShortTermShards   []*NewShardData
 	LongTermShards    []*NewShardData
 	ContinuousQueries map[string][]*ContinuousQuery
+	// the last run and the errors of the continuous queries
+	ContinuousQueryStats map[string]map[uint32]*ContinuousQueryStats
 }
 
 func (self *ClusterConfiguration) Save() ([]byte, error) {
 	log.Debug("Dumping the cluster configuration")
+	admins, dbUsers, apiKeys, err := self.encryptedCredentials()
+	if err != nil {
+		return nil, err
+	}
 	data := &SavedConfiguration{
-		Databases:         self.DatabaseReplicationFactors,
-		Admins:            self.clusterAdmins,
-		DbUsers:           self.dbUsers,
-		Servers:           self.servers,
-		ContinuousQueries: self.continuousQueries,
-		ShortTermShards:   self.convertShardsToNewShardData(self.shortTermShards),
-		LongTermShards:    self.convertShardsToNewShardData(self.longTermShards),
+		Databases:            self.DatabaseReplicationFactors,
+		Admins:               admins,
+		DbUsers:              dbUsers,
+		ApiKeys:              apiKeys,
+		Subscriptions:        self.writeSubscriptions,
+		Roles:                self.roles,
+		Quotas:               self.quotas,
+		Tenants:              self.tenants,
+		Servers:              self.servers,
+		ContinuousQueries:    self.continuousQueries,
+		ContinuousQueryStats: self.continuousQueryStats,
+		ShortTermShards:      self.convertShardsToNewShardData(self.shortTermShards),
+		LongTermShards:       self.convertShardsToNewShardData(self.longTermShards),
 	}
 
 	b := bytes.NewBuffer(nil)
-	err := gob.NewEncoder(b).Encode(&data)
+	err = gob.NewEncoder(b).Encode(&data)
 	if err != nil {
 		return nil, err
 	}
@@ -460,10 +698,71 @@ func (self *ClusterConfiguration) Save() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// Returns copies of the users and api keys with encrypted hashes
+func (self *ClusterConfiguration) encryptedCredentials() (map[string]*ClusterAdmin, map[string]map[string]*DbUser, map[string]*ApiKey, error) {
+	admins := make(map[string]*ClusterAdmin, len(self.clusterAdmins))
+	for name, admin := range self.clusterAdmins {
+		encrypted, err := admin.Encrypted()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		admins[name] = encrypted
+	}
+	dbUsers := make(map[string]map[string]*DbUser, len(self.dbUsers))
+	for db, users := range self.dbUsers {
+		dbUsers[db] = make(map[string]*DbUser, len(users))
+		for name, user := range users {
+			encrypted, err := user.Encrypted()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			dbUsers[db][name] = encrypted
+		}
+	}
+	apiKeys := make(map[string]*ApiKey, len(self.apiKeys))
+	for id, key := range self.apiKeys {
+		encrypted, err := key.Encrypted()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		apiKeys[id] = encrypted
+	}
+	return admins, dbUsers, apiKeys, nil
+}
+
+// Replaces the encrypted hashes of the recovered users and api keys
+// with the plain ones
+func decryptCredentials(data *SavedConfiguration) error {
+	for name, admin := range data.Admins {
+		decrypted, err := admin.Decrypted()
+		if err != nil {
+			return err
+		}
+		data.Admins[name] = decrypted
+	}
+	for _, users := range data.DbUsers {
+		for name, user := range users {
+			decrypted, err := user.Decrypted()
+			if err != nil {
+				return err
+			}
+			users[name] = decrypted
+		}
+	}
+	for id, key := range data.ApiKeys {
+		decrypted, err := key.Decrypted()
+		if err != nil {
+			return err
+		}
+		data.ApiKeys[id] = decrypted
+	}
+	return nil
+}
+
 func (self *ClusterConfiguration) convertShardsToNewShardData(shards []*ShardData) []*NewShardData {
 	newShardData := make([]*NewShardData, len(shards), len(shards))
 	for i, shard := range shards {
-		newShardData[i] = &NewShardData{Id: shard.id, Type: shard.shardType, StartTime: shard.startTime, EndTime: shard.endTime, ServerIds: shard.serverIds, DurationSplit: shard.durationIsSplit}
+		newShardData[i] = &NewShardData{Id: shard.id, Type: shard.shardType, StartTime: shard.startTime, EndTime: shard.endTime, ServerIds: shard.ServerIds(), DurationSplit: shard.durationIsSplit, ReadOnly: shard.readOnly, Path: shard.mountPath}
 	}
 	return newShardData
 }
@@ -472,10 +771,13 @@ func (self *ClusterConfiguration) convertNewShardDataToShards(newShards []*NewSh
 	shards := make([]*ShardData, len(newShards), len(newShards))
 	for i, newShard := range newShards {
 		shard := NewShard(newShard.Id, newShard.StartTime, newShard.EndTime, newShard.Type, newShard.DurationSplit, self.wal)
+		if newShard.ReadOnly {
+			shard.SetReadOnly(newShard.Path)
+		}
 		servers := make([]*ClusterServer, 0)
 		for _, serverId := range newShard.ServerIds {
 			if serverId == self.LocalServerId {
-				err := shard.SetLocalStore(self.shardStore, self.LocalServerId)
+				err := self.setLocalStore(shard)
 				if err != nil {
 					log.Error("CliusterConfig convertNewShardDataToShards: ", err)
 				}
@@ -498,10 +800,47 @@ func (self *ClusterConfiguration) Recovery(b []byte) error {
 	if err != nil {
 		return err
 	}
+	if err := decryptCredentials(data); err != nil {
+		return err
+	}
 
 	self.DatabaseReplicationFactors = data.Databases
 	self.clusterAdmins = data.Admins
 	self.dbUsers = data.DbUsers
+	// snapshots taken before api keys were added don't have any
+	self.apiKeys = data.ApiKeys
+	if self.apiKeys == nil {
+		self.apiKeys = make(map[string]*ApiKey)
+	}
+	self.writeSubscriptionsLock.Lock()
+	self.writeSubscriptions = data.Subscriptions
+	if self.writeSubscriptions == nil {
+		self.writeSubscriptions = make(map[string]*WriteSubscription)
+	}
+	self.writeSubscriptionsLock.Unlock()
+	self.rolesLock.Lock()
+	self.roles = data.Roles
+	if self.roles == nil {
+		self.roles = make(map[string]*Role)
+	}
+	self.rolesLock.Unlock()
+	self.quotasLock.Lock()
+	self.quotas = data.Quotas
+	if self.quotas == nil {
+		self.quotas = make(map[string]*DatabaseQuota)
+	}
+	self.quotasLock.Unlock()
+	self.tenantsLock.Lock()
+	self.tenants = data.Tenants
+	if self.tenants == nil {
+		self.tenants = make(map[string]*Tenant)
+	}
+	self.tenantsLock.Unlock()
+	for _, dbUsers := range self.dbUsers {
+		for _, user := range dbUsers {
+			user.roleLookup = self.GetRole
+		}
+	}
 
 	// copy the protobuf client from the old servers
 	oldServers := map[string]ServerConnection{}
@@ -551,11 +890,18 @@ func (self *ClusterConfiguration) Recovery(b []byte) error {
 			self.addContinuousQuery(db, query)
 		}
 	}
+	self.continuousQueryStats = data.ContinuousQueryStats
+	if self.continuousQueryStats == nil {
+		self.continuousQueryStats = make(map[string]map[uint32]*ContinuousQueryStats)
+	}
 
 	return nil
 }
 
 func (self *ClusterConfiguration) AuthenticateDbUser(db, username, password string) (common.User, error) {
+	if user := self.authenticateExternalDbUser(db, username, password); user != nil {
+		return user, nil
+	}
 	dbUsers := self.dbUsers[db]
 	if dbUsers == nil || dbUsers[username] == nil {
 		return nil, common.NewAuthorizationError("Invalid username/password")
@@ -568,6 +914,9 @@ func (self *ClusterConfiguration) AuthenticateDbUser(db, username, password stri
 }
 
 func (self *ClusterConfiguration) AuthenticateClusterAdmin(username, password string) (common.User, error) {
+	if user := self.authenticateExternalClusterAdmin(username, password); user != nil {
+		return user, nil
+	}
 	user := self.clusterAdmins[username]
 	if user == nil {
 		return nil, common.NewAuthorizationError("Invalid username/password")
@@ -578,6 +927,55 @@ func (self *ClusterConfiguration) AuthenticateClusterAdmin(username, password st
 	return nil, common.NewAuthorizationError("Invalid username/password")
 }
 
+// Returns the api key if it's valid and scoped to the given db
+func (self *ClusterConfiguration) AuthenticateApiKey(db, key string) (common.User, error) {
+	id, secret, err := ParseApiKey(key)
+	if err != nil {
+		return nil, common.NewAuthorizationError("Invalid api key")
+	}
+	apiKey := self.GetApiKey(id)
+	if apiKey == nil || !apiKey.isValidSecret(secret) {
+		return nil, common.NewAuthorizationError("Invalid api key")
+	}
+	if !apiKey.HasScope(db) {
+		return nil, common.NewAuthorizationError("The api key isn't valid for database %s", db)
+	}
+	return apiKey, nil
+}
+
+// Returns the api key without checking its secret if it's scoped to
+// the given db, only use it if the key was authenticated in some other
+// way
+func (self *ClusterConfiguration) LookupApiKey(db, id string) (common.User, error) {
+	apiKey := self.GetApiKey(id)
+	if apiKey == nil {
+		return nil, common.NewAuthorizationError("Unknown api key %s", id)
+	}
+	if !apiKey.HasScope(db) {
+		return nil, common.NewAuthorizationError("The api key isn't valid for database %s", db)
+	}
+	return apiKey, nil
+}
+
+// Returns the db user without checking the password, only use it if
+// the user was authenticated in some other way
+func (self *ClusterConfiguration) LookupDbUser(db, username string) (common.User, error) {
+	user := self.GetDbUser(db, username)
+	if user == nil {
+		return nil, common.NewAuthorizationError("Unknown user %s", username)
+	}
+	return user, nil
+}
+
+// Same as LookupDbUser for cluster admins
+func (self *ClusterConfiguration) LookupClusterAdmin(username string) (common.User, error) {
+	user := self.GetClusterAdmin(username)
+	if user == nil {
+		return nil, common.NewAuthorizationError("Unknown cluster admin %s", username)
+	}
+	return user, nil
+}
+
 func (self *ClusterConfiguration) HasContinuousQueries() bool {
 	return self.continuousQueries != nil && len(self.continuousQueries) > 0
 }
@@ -619,6 +1017,9 @@ func (self *ClusterConfiguration) GetShardToWriteToBySeriesAndTime(db, series st
 	}
 	matchingShards := make([]*ShardData, 0)
 	for _, s := range shards {
+		if s.IsReadOnly() {
+			continue
+		}
 		if s.IsMicrosecondInRange(microsecondsEpoch) {
 			matchingShards = append(matchingShards, s)
 		} else if len(matchingShards) > 0 {
@@ -834,7 +1235,12 @@ func (self *ClusterConfiguration) AddShards(shards []*NewShardData) ([]*ShardDat
 		existingShards = self.longTermShards
 	}
 
+	// read only shards are archives that are mounted next to the
+	// regular shards, they never replace them
 	for _, s := range existingShards {
+		if shards[0].ReadOnly || s.readOnly {
+			continue
+		}
 		if s.startTime.Unix() == startTime.Unix() && s.endTime.Unix() == endTime.Unix() {
 			createdShards = append(createdShards, s)
 		}
@@ -849,10 +1255,13 @@ func (self *ClusterConfiguration) AddShards(shards []*NewShardData) ([]*ShardDat
 	for _, newShard := range shards {
 		id := uint32(len(self.GetAllShards()) + 1)
 		shard := NewShard(id, newShard.StartTime, newShard.EndTime, shardType, durationIsSplit, self.wal)
+		if newShard.ReadOnly {
+			shard.SetReadOnly(newShard.Path)
+		}
 		servers := make([]*ClusterServer, 0)
 		for _, serverId := range newShard.ServerIds {
 			if serverId == self.LocalServerId {
-				err := shard.SetLocalStore(self.shardStore, self.LocalServerId)
+				err := self.setLocalStore(shard)
 				if err != nil {
 					log.Error("AddShards: error setting local store: ", err)
 					return nil, err
@@ -878,6 +1287,13 @@ func (self *ClusterConfiguration) AddShards(shards []*NewShardData) ([]*ShardDat
 		}
 
 		createdShards = append(createdShards, shard)
+		self.fireEvent(SHARD_CREATED_EVENT, map[string]interface{}{
+			"id":        shard.id,
+			"startTime": shard.startTime,
+			"endTime":   shard.endTime,
+			"longTerm":  shardType == LONG_TERM,
+			"serverIds": shard.ServerIds(),
+		})
 
 		log.Info("%s: %d - start: %s (%d). end: %s (%d). isLocal: %d. servers: %s",
 			message, shard.Id(),
@@ -893,10 +1309,13 @@ func (self *ClusterConfiguration) MarshalNewShardArrayToShards(newShards []*NewS
 	durationIsSplit := len(newShards) > 1
 	for i, s := range newShards {
 		shard := NewShard(s.Id, s.StartTime, s.EndTime, s.Type, durationIsSplit, self.wal)
+		if s.ReadOnly {
+			shard.SetReadOnly(s.Path)
+		}
 		servers := make([]*ClusterServer, 0)
 		for _, serverId := range s.ServerIds {
 			if serverId == self.LocalServerId {
-				err := shard.SetLocalStore(self.shardStore, self.LocalServerId)
+				err := self.setLocalStore(shard)
 				if err != nil {
 					log.Error("AddShards: error setting local store: ", err)
 					return nil, err
@@ -931,6 +1350,7 @@ func (self *ClusterConfiguration) GetLocalShardById(id uint32) *ShardData {
 func (self *ClusterConfiguration) DropShard(shardId uint32, serverIds []uint32) error {
 	// take it out of the memory map so writes and queries stop going to it
 	self.updateOrRemoveShard(shardId, serverIds)
+	self.fireEvent(SHARD_DROPPED_EVENT, map[string]interface{}{"id": shardId, "serverIds": serverIds})
 
 	// now actually remove it from disk if it lives here
 	for _, serverId := range serverIds {
@@ -941,6 +1361,95 @@ func (self *ClusterConfiguration) DropShard(shardId uint32, serverIds []uint32)
 	return nil
 }
 
+// VerifyLocalShards checks the checksums of all the shards stored on
+// this server. Corrupt shards get quarantined and re-replicated from
+// a healthy replica in the background.
+func (self *ClusterConfiguration) VerifyLocalShards() {
+	for _, shard := range self.GetAllShards() {
+		if !shard.IsLocal {
+			continue
+		}
+		err := self.shardStore.VerifyShard(shard.Id())
+		if err != nil {
+			log.Error("Error while verifying shard %d: %s", shard.Id(), err)
+		}
+		if self.shardStore.IsQuarantined(shard.Id()) {
+			go self.RepairShard(shard)
+		}
+	}
+}
+
+// Returns the ids of the local shards that are quarantined
+func (self *ClusterConfiguration) QuarantinedLocalShards() []uint32 {
+	ids := []uint32{}
+	for _, shard := range self.GetAllShards() {
+		if shard.IsLocal && self.shardStore.IsQuarantined(shard.Id()) {
+			ids = append(ids, shard.Id())
+		}
+	}
+	return ids
+}
+
+func (self *ClusterConfiguration) WalStatus() *wal.Status {
+	return self.wal.Status()
+}
+
+func (self *ClusterConfiguration) StartShardScrubber(interval time.Duration) {
+	self.scrubber = NewShardScrubber(self, self.shardStore, interval)
+	self.scrubber.Start()
+}
+
+func (self *ClusterConfiguration) ShardScrubber() *ShardScrubber {
+	return self.scrubber
+}
+
+// The background operations of this server
+func (self *ClusterConfiguration) Jobs() *JobRegistry {
+	return self.jobs
+}
+
+// Repairs the local copy of the shard from the other replicas, only one
+// repair of a shard runs at a time. The mounted shards can't be repaired.
+func (self *ClusterConfiguration) RepairShard(shard *ShardData) error {
+	if shard.IsReadOnly() {
+		err := fmt.Errorf("Shard %d is mounted read only, it can't be repaired", shard.Id())
+		log.Warn(err)
+		return err
+	}
+	self.repairsLock.Lock()
+	if self.repairs[shard.Id()] {
+		self.repairsLock.Unlock()
+		return fmt.Errorf("Shard %d is already being repaired", shard.Id())
+	}
+	self.repairs[shard.Id()] = true
+	self.repairsLock.Unlock()
+	defer func() {
+		self.repairsLock.Lock()
+		delete(self.repairs, shard.Id())
+		self.repairsLock.Unlock()
+	}()
+
+	job := self.jobs.Add("repair_shard", strconv.FormatUint(uint64(shard.Id()), 10), false)
+	job.Start()
+	databases := make([]string, 0)
+	for _, db := range self.GetDatabases() {
+		databases = append(databases, db.Name)
+	}
+	err := shard.Repair(databases, job)
+	if err != nil {
+		log.Error("Error while repairing shard %d: %s", shard.Id(), err)
+	}
+	job.Finish(err)
+	return err
+}
+
+// Makes the shard local, its local copy is repaired from the other
+// replicas if it's found corrupt
+func (self *ClusterConfiguration) setLocalStore(shard *ShardData) error {
+	shard.repair = self.RepairShard
+	return shard.SetLocalStore(self.shardStore, self.LocalServerId)
+}
+
 func (self *ClusterConfiguration) RecoverFromWAL() error {
 	writeBuffer := NewWriteBuffer("local", self.shardStore, self.wal, self.LocalServerId, self.config.LocalStoreWriteBufferSize)
 	self.writeBuffers = append(self.writeBuffers, writeBuffer)
@@ -997,7 +1506,7 @@ func (self *ClusterConfiguration) recover(serverId uint32, writer Writer) error
 func (self *ClusterConfiguration) shardIdsForServerId(serverId uint32) []uint32 {
 	shardIds := make([]uint32, 0)
 	for _, shard := range self.GetAllShards() {
-		for _, id := range shard.serverIds {
+		for _, id := range shard.ServerIds() {
 			if id == serverId {
 				sid := shard.Id()
 				shardIds = append(shardIds, sid)
@@ -1026,26 +1535,13 @@ func (self *ClusterConfiguration) updateOrRemoveShard(shardId uint32, serverIds
 		log.Error("Attempted to remove shard %d, which we couldn't find. %d shards currently loaded.", shardId, len(self.GetAllShards()))
 	}
 
-	if len(shard.serverIds) == len(serverIds) {
+	if len(shard.ServerIds()) == len(serverIds) {
 		self.removeShard(shardId)
 		return
 	}
 	self.shardsByIdLock.Lock()
 	defer self.shardsByIdLock.Unlock()
-	newIds := make([]uint32, 0)
-	for _, oldId := range shard.serverIds {
-		include := true
-		for _, removeId := range serverIds {
-			if oldId == removeId {
-				include = false
-				break
-			}
-		}
-		if include {
-			newIds = append(newIds, oldId)
-		}
-	}
-	shard.serverIds = newIds
+	shard.removeServers(serverIds)
 }
 
 func (self *ClusterConfiguration) removeShard(shardId uint32) {
@@ -77,6 +77,15 @@ func (self *ClusterServer) SetWriteBuffer(writeBuffer *WriteBuffer) {
 	self.writeBuffer = writeBuffer
 }
 
+// Returns how many requests the server is behind, 0 for the local
+// server
+func (self *ClusterServer) WriteLag() uint32 {
+	if self.writeBuffer == nil {
+		return 0
+	}
+	return self.writeBuffer.Lag()
+}
+
 func (self *ClusterServer) GetId() uint32 {
 	return self.Id
 }
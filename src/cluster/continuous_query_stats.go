@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"time"
+)
+
+// The result of a run of a continuous query, the leader sends them with
+// the checkpoint of the run
+type ContinuousQueryRun struct {
+	Database string        `json:"database"`
+	Id       uint32        `json:"id"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type ContinuousQueryStats struct {
+	LastRunDuration time.Duration
+	Errors          int64
+}
+
+func (self *ClusterConfiguration) RecordContinuousQueryRuns(runs []*ContinuousQueryRun) {
+	self.continuousQueriesLock.Lock()
+	defer self.continuousQueriesLock.Unlock()
+
+	for _, run := range runs {
+		queries := self.continuousQueryStats[run.Database]
+		if queries == nil {
+			queries = map[uint32]*ContinuousQueryStats{}
+			self.continuousQueryStats[run.Database] = queries
+		}
+		stats := &ContinuousQueryStats{LastRunDuration: run.Duration}
+		if previous := queries[run.Id]; previous != nil {
+			stats.Errors = previous.Errors
+		}
+		if run.Error != "" {
+			stats.Errors++
+		}
+		queries[run.Id] = stats
+	}
+}
+
+// Returns the stats of the continuous query or nil if it never ran
+func (self *ClusterConfiguration) GetContinuousQueryStats(db string, id uint32) *ContinuousQueryStats {
+	self.continuousQueriesLock.Lock()
+	defer self.continuousQueriesLock.Unlock()
+
+	stats := self.continuousQueryStats[db][id]
+	if stats == nil {
+		return nil
+	}
+	copy := *stats
+	return &copy
+}
@@ -0,0 +1,32 @@
+package cluster
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type ContinuousQueryStatsSuite struct{}
+
+var _ = Suite(&ContinuousQueryStatsSuite{})
+
+func (self *ContinuousQueryStatsSuite) TestRecordRuns(c *C) {
+	config := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(config.CreateContinuousQuery("db1", "select count(value) from cpu group by time(1m) into cpu.1m"), IsNil)
+	c.Assert(config.GetContinuousQueryStats("db1", 1), IsNil)
+
+	config.RecordContinuousQueryRuns([]*ContinuousQueryRun{{Database: "db1", Id: 1, Duration: time.Second, Error: "timeout"}})
+	config.RecordContinuousQueryRuns([]*ContinuousQueryRun{{Database: "db1", Id: 1, Duration: 2 * time.Second}})
+	stats := config.GetContinuousQueryStats("db1", 1)
+	c.Assert(stats.LastRunDuration, Equals, 2*time.Second)
+	c.Assert(stats.Errors, Equals, int64(1))
+
+	data, err := config.Save()
+	c.Assert(err, IsNil)
+	recovered := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(recovered.Recovery(data), IsNil)
+	c.Assert(recovered.GetContinuousQueryStats("db1", 1).Errors, Equals, int64(1))
+
+	c.Assert(config.DeleteContinuousQuery("db1", 1), IsNil)
+	c.Assert(config.GetContinuousQueryStats("db1", 1), IsNil)
+}
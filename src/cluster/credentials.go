@@ -0,0 +1,164 @@
+package cluster
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// The prefix of the encrypted credentials, values without it were
+// saved before the encryption was enabled and are used as they are
+const ENCRYPTED_CREDENTIAL_PREFIX = "enc:v1:"
+
+var credentialsCipher cipher.AEAD
+
+// Encrypts the password hashes and api key hashes in the raft log and
+// snapshots with a key derived from key, an empty key disables the
+// encryption. All the servers of the cluster have to use the same key.
+func SetCredentialsKey(key string) error {
+	if key == "" {
+		credentialsCipher = nil
+		return nil
+	}
+	digest := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(digest[:])
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	credentialsCipher = aead
+	return nil
+}
+
+// Returns the encrypted value if a key is set, the value otherwise
+func EncryptCredential(value string) (string, error) {
+	if credentialsCipher == nil || value == "" || strings.HasPrefix(value, ENCRYPTED_CREDENTIAL_PREFIX) {
+		return value, nil
+	}
+	nonce := make([]byte, credentialsCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := credentialsCipher.Seal(nonce, nonce, []byte(value), nil)
+	return ENCRYPTED_CREDENTIAL_PREFIX + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Returns the plain value of a value returned by EncryptCredential
+func DecryptCredential(value string) (string, error) {
+	if !strings.HasPrefix(value, ENCRYPTED_CREDENTIAL_PREFIX) {
+		return value, nil
+	}
+	if credentialsCipher == nil {
+		return "", fmt.Errorf("The credentials are encrypted but there's no credentials key")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(value[len(ENCRYPTED_CREDENTIAL_PREFIX):])
+	if err != nil {
+		return "", err
+	}
+	nonceSize := credentialsCipher.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", fmt.Errorf("The encrypted credential is too short")
+	}
+	plain, err := credentialsCipher.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("Cannot decrypt the credential, the credentials key is probably wrong")
+	}
+	return string(plain), nil
+}
+
+// Returns a copy of the user with an encrypted hash
+func (self *CommonUser) encrypted() (CommonUser, error) {
+	user := *self
+	hash, err := EncryptCredential(self.Hash)
+	user.Hash = hash
+	return user, err
+}
+
+// Replaces the encrypted hash with the plain one
+func (self *CommonUser) decrypt() error {
+	hash, err := DecryptCredential(self.Hash)
+	if err != nil {
+		return fmt.Errorf("User %s: %s", self.Name, err)
+	}
+	self.Hash = hash
+	return nil
+}
+
+// Returns a copy of the user that can be written to disk
+func (self *DbUser) Encrypted() (*DbUser, error) {
+	common, err := self.CommonUser.encrypted()
+	if err != nil {
+		return nil, err
+	}
+	user := *self
+	user.CommonUser = common
+	return &user, nil
+}
+
+// Returns a copy of a user returned by Encrypted with the plain hash
+func (self *DbUser) Decrypted() (*DbUser, error) {
+	user := *self
+	if err := user.CommonUser.decrypt(); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// The totp secrets of cluster admins are encrypted too
+func (self *ClusterAdmin) Encrypted() (*ClusterAdmin, error) {
+	common, err := self.CommonUser.encrypted()
+	if err != nil {
+		return nil, err
+	}
+	user := *self
+	user.CommonUser = common
+	if user.TotpSecret, err = EncryptCredential(self.TotpSecret); err != nil {
+		return nil, err
+	}
+	if user.PendingTotpSecret, err = EncryptCredential(self.PendingTotpSecret); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (self *ClusterAdmin) Decrypted() (*ClusterAdmin, error) {
+	user := *self
+	if err := user.CommonUser.decrypt(); err != nil {
+		return nil, err
+	}
+	var err error
+	if user.TotpSecret, err = DecryptCredential(self.TotpSecret); err != nil {
+		return nil, fmt.Errorf("User %s: %s", self.Name, err)
+	}
+	if user.PendingTotpSecret, err = DecryptCredential(self.PendingTotpSecret); err != nil {
+		return nil, fmt.Errorf("User %s: %s", self.Name, err)
+	}
+	return &user, nil
+}
+
+func (self *ApiKey) Encrypted() (*ApiKey, error) {
+	key := *self
+	hash, err := EncryptCredential(self.Hash)
+	if err != nil {
+		return nil, err
+	}
+	key.Hash = hash
+	return &key, nil
+}
+
+func (self *ApiKey) Decrypted() (*ApiKey, error) {
+	key := *self
+	hash, err := DecryptCredential(self.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("Api key %s: %s", self.Id, err)
+	}
+	key.Hash = hash
+	return &key, nil
+}
@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+type CredentialsSuite struct{}
+
+var _ = Suite(&CredentialsSuite{})
+
+func (self *CredentialsSuite) TearDownTest(c *C) {
+	SetCredentialsKey("")
+}
+
+func (self *CredentialsSuite) TestEncryption(c *C) {
+	c.Assert(SetCredentialsKey("secret"), IsNil)
+	encrypted, err := EncryptCredential("hash")
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(encrypted, ENCRYPTED_CREDENTIAL_PREFIX), Equals, true)
+	c.Assert(strings.Contains(encrypted, "hash"), Equals, false)
+	plain, err := DecryptCredential(encrypted)
+	c.Assert(err, IsNil)
+	c.Assert(plain, Equals, "hash")
+
+	// hashes saved before the encryption was enabled are still valid
+	plain, err = DecryptCredential("old_hash")
+	c.Assert(err, IsNil)
+	c.Assert(plain, Equals, "old_hash")
+
+	c.Assert(SetCredentialsKey("other"), IsNil)
+	_, err = DecryptCredential(encrypted)
+	c.Assert(err, NotNil)
+
+	SetCredentialsKey("")
+	_, err = DecryptCredential(encrypted)
+	c.Assert(err, NotNil)
+}
+
+func (self *CredentialsSuite) TestEncryptedUsers(c *C) {
+	c.Assert(SetCredentialsKey("secret"), IsNil)
+	user := &DbUser{CommonUser: CommonUser{Name: "dave", Hash: "hash"}, Db: "db1", IsAdmin: true}
+	encrypted, err := user.Encrypted()
+	c.Assert(err, IsNil)
+	c.Assert(encrypted.Hash, Not(Equals), "hash")
+	c.Assert(user.Hash, Equals, "hash")
+	decrypted, err := encrypted.Decrypted()
+	c.Assert(err, IsNil)
+	c.Assert(decrypted.Hash, Equals, "hash")
+	c.Assert(decrypted.IsAdmin, Equals, true)
+}
@@ -0,0 +1,51 @@
+package cluster
+
+import (
+	"fmt"
+)
+
+// The limits of the writes to a database, 0 means unlimited. The points
+// and the bytes are counted per UTC day across the cluster, the series
+// are the ones the database has.
+type DatabaseQuota struct {
+	Database     string `json:"database"`
+	PointsPerDay int64  `json:"pointsPerDay"`
+	BytesPerDay  int64  `json:"bytesPerDay"`
+	MaxSeries    int    `json:"maxSeries"`
+	CreatedBy    string `json:"createdBy"`
+	IsDeleted    bool   `json:"isDeleted"`
+}
+
+func (self *DatabaseQuota) Validate() error {
+	if self.PointsPerDay < 0 || self.BytesPerDay < 0 || self.MaxSeries < 0 {
+		return fmt.Errorf("The quota of %s can't be negative", self.Database)
+	}
+	return nil
+}
+
+// Returns the quota of the database or nil if it doesn't have one
+func (self *ClusterConfiguration) GetDatabaseQuota(db string) *DatabaseQuota {
+	self.quotasLock.RLock()
+	defer self.quotasLock.RUnlock()
+	return self.quotas[db]
+}
+
+func (self *ClusterConfiguration) GetDatabaseQuotas() []*DatabaseQuota {
+	self.quotasLock.RLock()
+	defer self.quotasLock.RUnlock()
+	quotas := make([]*DatabaseQuota, 0, len(self.quotas))
+	for _, quota := range self.quotas {
+		quotas = append(quotas, quota)
+	}
+	return quotas
+}
+
+func (self *ClusterConfiguration) SaveDatabaseQuota(quota *DatabaseQuota) {
+	self.quotasLock.Lock()
+	defer self.quotasLock.Unlock()
+	if quota.IsDeleted {
+		delete(self.quotas, quota.Database)
+		return
+	}
+	self.quotas[quota.Database] = quota
+}
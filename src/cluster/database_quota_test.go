@@ -0,0 +1,36 @@
+package cluster
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type DatabaseQuotaSuite struct{}
+
+var _ = Suite(&DatabaseQuotaSuite{})
+
+func (self *DatabaseQuotaSuite) TestValidate(c *C) {
+	c.Assert((&DatabaseQuota{Database: "db1", PointsPerDay: 1000}).Validate(), IsNil)
+	c.Assert((&DatabaseQuota{Database: "db1", BytesPerDay: -1}).Validate(), NotNil)
+	c.Assert((&DatabaseQuota{Database: "db1", MaxSeries: -1}).Validate(), NotNil)
+}
+
+func (self *DatabaseQuotaSuite) TestSaveAndRecover(c *C) {
+	config := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(config.CreateDatabase("db1", 1), IsNil)
+	config.SaveDatabaseQuota(&DatabaseQuota{Database: "db1", PointsPerDay: 1000, MaxSeries: 10})
+	c.Assert(config.GetDatabaseQuotas(), HasLen, 1)
+
+	data, err := config.Save()
+	c.Assert(err, IsNil)
+	recovered := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(recovered.Recovery(data), IsNil)
+	c.Assert(recovered.GetDatabaseQuota("db1"), NotNil)
+	c.Assert(recovered.GetDatabaseQuota("db1").PointsPerDay, Equals, int64(1000))
+
+	recovered.SaveDatabaseQuota(&DatabaseQuota{Database: "db1", IsDeleted: true})
+	c.Assert(recovered.GetDatabaseQuota("db1"), IsNil)
+
+	// dropping the database drops its quota
+	c.Assert(config.DropDatabase("db1"), IsNil)
+	c.Assert(config.GetDatabaseQuota("db1"), IsNil)
+}
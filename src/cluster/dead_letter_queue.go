@@ -0,0 +1,225 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"protocol"
+	"sort"
+	"stats"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+var (
+	deadLettersAdded   = stats.NewCounter("deadLetters")
+	deadLettersDropped = stats.NewCounter("deadLettersDropped")
+)
+
+// Points that a replica will never be able to write, e.g. because
+// they're malformed. They're kept with the error so they can be
+// inspected and written again or purged.
+type DeadLetter struct {
+	Id       uint64           `json:"id"`
+	Database string           `json:"database"`
+	ShardId  uint32           `json:"shardId"`
+	Error    string           `json:"error"`
+	Time     time.Time        `json:"time"`
+	Series   *protocol.Series `json:"series"`
+}
+
+// The dead letters of the local shards. Every dead letter is a json
+// file named after its id in the directory of its database. A database
+// keeps at most maxLetters dead letters, the oldest ones are dropped
+// when more are added. 0 keeps all of them.
+type DeadLetterQueue struct {
+	dir        string
+	lock       sync.Mutex
+	lastId     uint64
+	maxLetters int
+}
+
+func NewDeadLetterQueue(dir string, maxLetters int) (*DeadLetterQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	self := &DeadLetterQueue{dir: dir, maxLetters: maxLetters}
+	databases, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, database := range databases {
+		ids, err := self.ids(filepath.Join(dir, database.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) > 0 && ids[len(ids)-1] > self.lastId {
+			self.lastId = ids[len(ids)-1]
+		}
+	}
+	return self, nil
+}
+
+func (self *DeadLetterQueue) databaseDir(database string) string {
+	return filepath.Join(self.dir, url.QueryEscape(database))
+}
+
+func (self *DeadLetterQueue) path(database string, id uint64) string {
+	return filepath.Join(self.databaseDir(database), fmt.Sprintf("%.20d.json", id))
+}
+
+// Returns the sorted ids of the dead letters in the directory
+func (self *DeadLetterQueue) ids(dir string) ([]uint64, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := []uint64{}
+	for _, info := range infos {
+		id, err := strconv.ParseUint(strings.TrimSuffix(info.Name(), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Sort(uint64Slice(ids))
+	return ids, nil
+}
+
+func (self *DeadLetterQueue) Add(database string, shardId uint32, series *protocol.Series, reason error) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.lastId++
+	letter := &DeadLetter{
+		Id:       self.lastId,
+		Database: database,
+		ShardId:  shardId,
+		Error:    reason.Error(),
+		Time:     time.Now(),
+		Series:   series,
+	}
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(self.databaseDir(database), 0755); err != nil {
+		return err
+	}
+	path := self.path(database, letter.Id)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	deadLettersAdded.Inc()
+	points := 0
+	if series != nil {
+		points = len(series.Points)
+	}
+	log.Warn("Moved %d points of %s in shard %d to the dead letters of %s as %d: %s",
+		points, series.GetName(), shardId, database, letter.Id, reason)
+	return self.dropOldest(database)
+}
+
+// Removes the oldest dead letters of the database that are over the
+// limit, the lock must be held
+func (self *DeadLetterQueue) dropOldest(database string) error {
+	if self.maxLetters <= 0 {
+		return nil
+	}
+	ids, err := self.ids(self.databaseDir(database))
+	if err != nil {
+		return err
+	}
+	if len(ids) <= self.maxLetters {
+		return nil
+	}
+	dropped := ids[:len(ids)-self.maxLetters]
+	for _, id := range dropped {
+		if err := os.Remove(self.path(database, id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	deadLettersDropped.Add(int64(len(dropped)))
+	log.Warn("Dropped the %d oldest dead letters of %s, it has more than %d", len(dropped), database, self.maxLetters)
+	return nil
+}
+
+// Returns the dead letters of the database, oldest first
+func (self *DeadLetterQueue) List(database string) ([]*DeadLetter, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	ids, err := self.ids(self.databaseDir(database))
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*DeadLetter, 0, len(ids))
+	for _, id := range ids {
+		letter, err := self.read(database, id)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// Returns the dead letter or nil if it doesn't exist
+func (self *DeadLetterQueue) Get(database string, id uint64) (*DeadLetter, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	letter, err := self.read(database, id)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return letter, err
+}
+
+func (self *DeadLetterQueue) read(database string, id uint64) (*DeadLetter, error) {
+	data, err := ioutil.ReadFile(self.path(database, id))
+	if err != nil {
+		return nil, err
+	}
+	letter := &DeadLetter{}
+	if err := json.Unmarshal(data, letter); err != nil {
+		return nil, fmt.Errorf("Cannot parse dead letter %d of %s: %s", id, database, err)
+	}
+	return letter, nil
+}
+
+func (self *DeadLetterQueue) Remove(database string, id uint64) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	err := os.Remove(self.path(database, id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Removes all the dead letters of the database
+func (self *DeadLetterQueue) Purge(database string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	log.Info("Purging the dead letters of %s", database)
+	return os.RemoveAll(self.databaseDir(database))
+}
+
+type uint64Slice []uint64
+
+func (self uint64Slice) Len() int           { return len(self) }
+func (self uint64Slice) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+func (self uint64Slice) Less(i, j int) bool { return self[i] < self[j] }
@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"fmt"
+	"protocol"
+
+	. "launchpad.net/gocheck"
+)
+
+type DeadLetterQueueSuite struct{}
+
+var _ = Suite(&DeadLetterQueueSuite{})
+
+func (self *DeadLetterQueueSuite) TestAddListAndRemove(c *C) {
+	dir := c.MkDir()
+	queue, err := NewDeadLetterQueue(dir, 0)
+	c.Assert(err, IsNil)
+
+	series := &protocol.Series{Name: protocol.String("cpu"), Fields: []string{"value"}}
+	c.Assert(queue.Add("db1", 1, series, fmt.Errorf("malformed")), IsNil)
+	c.Assert(queue.Add("db/2", 2, series, fmt.Errorf("read only")), IsNil)
+	c.Assert(queue.Add("db1", 3, series, fmt.Errorf("malformed")), IsNil)
+
+	letters, err := queue.List("db1")
+	c.Assert(err, IsNil)
+	c.Assert(letters, HasLen, 2)
+	c.Assert(letters[0].Id, Equals, uint64(1))
+	c.Assert(letters[0].ShardId, Equals, uint32(1))
+	c.Assert(letters[0].Error, Equals, "malformed")
+	c.Assert(letters[0].Series.GetName(), Equals, "cpu")
+	c.Assert(letters[1].Id, Equals, uint64(3))
+
+	letter, err := queue.Get("db/2", 2)
+	c.Assert(err, IsNil)
+	c.Assert(letter.Database, Equals, "db/2")
+	letter, err = queue.Get("db1", 2)
+	c.Assert(err, IsNil)
+	c.Assert(letter, IsNil)
+
+	c.Assert(queue.Remove("db1", 1), IsNil)
+	letters, err = queue.List("db1")
+	c.Assert(err, IsNil)
+	c.Assert(letters, HasLen, 1)
+
+	// the ids keep increasing after a restart
+	queue, err = NewDeadLetterQueue(dir, 0)
+	c.Assert(err, IsNil)
+	c.Assert(queue.Add("db1", 1, series, fmt.Errorf("malformed")), IsNil)
+	letters, err = queue.List("db1")
+	c.Assert(err, IsNil)
+	c.Assert(letters[len(letters)-1].Id, Equals, uint64(4))
+
+	c.Assert(queue.Purge("db1"), IsNil)
+	letters, err = queue.List("db1")
+	c.Assert(err, IsNil)
+	c.Assert(letters, HasLen, 0)
+	letters, err = queue.List("db/2")
+	c.Assert(err, IsNil)
+	c.Assert(letters, HasLen, 1)
+}
+
+func (self *DeadLetterQueueSuite) TestOldestLettersAreDropped(c *C) {
+	queue, err := NewDeadLetterQueue(c.MkDir(), 2)
+	c.Assert(err, IsNil)
+
+	series := &protocol.Series{Name: protocol.String("cpu"), Fields: []string{"value"}}
+	for i := 0; i < 3; i++ {
+		c.Assert(queue.Add("db1", 1, series, fmt.Errorf("malformed")), IsNil)
+	}
+	c.Assert(queue.Add("db2", 1, series, fmt.Errorf("malformed")), IsNil)
+
+	letters, err := queue.List("db1")
+	c.Assert(err, IsNil)
+	c.Assert(letters, HasLen, 2)
+	c.Assert(letters[0].Id, Equals, uint64(2))
+	c.Assert(letters[1].Id, Equals, uint64(3))
+	// the limit is per database
+	letters, err = queue.List("db2")
+	c.Assert(err, IsNil)
+	c.Assert(letters, HasLen, 1)
+}
+
+func (self *DeadLetterQueueSuite) TestAddingANilSeries(c *C) {
+	queue, err := NewDeadLetterQueue(c.MkDir(), 0)
+	c.Assert(err, IsNil)
+	c.Assert(queue.Add("db1", 1, nil, fmt.Errorf("Unable to write no data")), IsNil)
+	letters, err := queue.List("db1")
+	c.Assert(err, IsNil)
+	c.Assert(letters, HasLen, 1)
+}
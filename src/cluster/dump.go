@@ -0,0 +1,96 @@
+package cluster
+
+import (
+	"bufio"
+	"common"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// The version of the dump format, the dumps of a newer version can't
+// be read
+const DUMP_FORMAT_VERSION = 1
+
+// The records of a dump, one json object per line. A dump starts with
+// the header, the cluster admins follow, then every database with its
+// users, continuous queries and points, and the end record is last so
+// a truncated dump can be told apart from a complete one.
+const (
+	DUMP_HEADER           = "header"
+	DUMP_CLUSTER_ADMIN    = "cluster_admin"
+	DUMP_DATABASE         = "database"
+	DUMP_USER             = "user"
+	DUMP_CONTINUOUS_QUERY = "continuous_query"
+	DUMP_SERIES           = "series"
+	DUMP_END              = "end"
+)
+
+type DumpRecord struct {
+	Type string `json:"type"`
+	// the header has the version and the time of the dump in seconds
+	Version   int   `json:"version,omitempty"`
+	CreatedAt int64 `json:"createdAt,omitempty"`
+	// the database of the record, every record but the header, the
+	// cluster admins and the end has one
+	Database          string        `json:"database,omitempty"`
+	ReplicationFactor uint8         `json:"replicationFactor,omitempty"`
+	ClusterAdmin      *ClusterAdmin `json:"clusterAdmin,omitempty"`
+	User              *DbUser       `json:"user,omitempty"`
+	Query             string        `json:"query,omitempty"`
+	// the timestamps of the points are in microseconds
+	Series *common.SerializedSeries `json:"series,omitempty"`
+	// the end has the number of points of the dump
+	Points int64 `json:"points,omitempty"`
+}
+
+// Reads the records of a dump, the header is checked by the first call
+// to Next
+type DumpReader struct {
+	reader *bufio.Reader
+	line   int
+	ended  bool
+}
+
+func NewDumpReader(r io.Reader) *DumpReader {
+	return &DumpReader{reader: bufio.NewReader(r)}
+}
+
+// The line of the last record that was read
+func (self *DumpReader) Line() int {
+	return self.line
+}
+
+// Returns the next record or io.EOF after the end record, the dumps
+// that stop before it return io.ErrUnexpectedEOF
+func (self *DumpReader) Next() (*DumpRecord, error) {
+	if self.ended {
+		return nil, io.EOF
+	}
+	data, err := self.reader.ReadBytes('\n')
+	if err == io.EOF {
+		if len(data) == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	self.line++
+
+	record := &DumpRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("line %d: %s", self.line, err)
+	}
+	if self.line == 1 {
+		if record.Type != DUMP_HEADER {
+			return nil, fmt.Errorf("The dump doesn't start with a header")
+		}
+		if record.Version > DUMP_FORMAT_VERSION {
+			return nil, fmt.Errorf("The dump is version %d, this server reads up to version %d", record.Version, DUMP_FORMAT_VERSION)
+		}
+	}
+	if record.Type == DUMP_END {
+		self.ended = true
+	}
+	return record, nil
+}
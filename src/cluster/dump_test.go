@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"io"
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+type DumpSuite struct{}
+
+var _ = Suite(&DumpSuite{})
+
+func (self *DumpSuite) TestReadDump(c *C) {
+	reader := NewDumpReader(strings.NewReader(`{"type":"header","version":1,"createdAt":1400000000}
+{"type":"database","database":"db1","replicationFactor":2}
+{"type":"series","database":"db1","series":{"name":"cpu","columns":["time","value"],"points":[[1400000000000000,1]]}}
+{"type":"end","points":1}
+`))
+	types := []string{}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		types = append(types, record.Type)
+		if record.Type == DUMP_SERIES {
+			c.Assert(record.Series.Name, Equals, "cpu")
+			c.Assert(record.Series.Points, HasLen, 1)
+		}
+	}
+	c.Assert(types, DeepEquals, []string{DUMP_HEADER, DUMP_DATABASE, DUMP_SERIES, DUMP_END})
+	c.Assert(reader.Line(), Equals, 4)
+}
+
+func (self *DumpSuite) TestTruncatedDump(c *C) {
+	reader := NewDumpReader(strings.NewReader(`{"type":"header","version":1}
+{"type":"database","database":"db1"}
+`))
+	_, err := reader.Next()
+	c.Assert(err, IsNil)
+	_, err = reader.Next()
+	c.Assert(err, IsNil)
+	_, err = reader.Next()
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+}
+
+func (self *DumpSuite) TestNewerVersion(c *C) {
+	reader := NewDumpReader(strings.NewReader(`{"type":"header","version":2}` + "\n"))
+	_, err := reader.Next()
+	c.Assert(err, ErrorMatches, ".*version 2.*")
+
+	reader = NewDumpReader(strings.NewReader(`{"type":"database","database":"db1"}` + "\n"))
+	_, err = reader.Next()
+	c.Assert(err, ErrorMatches, ".*header.*")
+}
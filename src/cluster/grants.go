@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"strings"
+)
+
+// The permissions a user gets from one source, the user itself, one
+// of its roles or being a cluster admin
+type Grant struct {
+	Source             string
+	Database           string
+	ReadFrom           []*Matcher
+	WriteTo            []*Matcher
+	IsAdmin            bool
+	CanCreateDatabases bool
+}
+
+const (
+	GRANT_SOURCE_USER          = "user"
+	GRANT_SOURCE_CLUSTER_ADMIN = "cluster_admin"
+	GRANT_SOURCE_ROLE_PREFIX   = "role:"
+)
+
+// Returns the matchers separated by commas, regexes are written as
+// /regex/
+func FormatMatchers(matchers []*Matcher) string {
+	names := make([]string, 0, len(matchers))
+	for _, matcher := range matchers {
+		if matcher.IsRegex {
+			names = append(names, "/"+matcher.Name+"/")
+			continue
+		}
+		names = append(names, matcher.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Returns the permissions of the user followed by the permissions
+// every role of the user gives on its database
+func (self *DbUser) Grants() []*Grant {
+	grants := []*Grant{{
+		Source:             GRANT_SOURCE_USER,
+		Database:           self.Db,
+		ReadFrom:           self.ReadFrom,
+		WriteTo:            self.WriteTo,
+		IsAdmin:            self.IsAdmin,
+		CanCreateDatabases: self.CanCreateDatabases,
+	}}
+	if self.roleLookup == nil {
+		return grants
+	}
+	for _, name := range self.Roles {
+		permission := self.roleLookup(name).permission(self.Db)
+		if permission == nil {
+			continue
+		}
+		grants = append(grants, &Grant{
+			Source:   GRANT_SOURCE_ROLE_PREFIX + name,
+			Database: self.Db,
+			ReadFrom: permission.ReadFrom,
+			WriteTo:  permission.WriteTo,
+			IsAdmin:  permission.IsAdmin,
+		})
+	}
+	return grants
+}
+
+// Cluster admins can do anything on every database
+func (self *ClusterAdmin) Grants() []*Grant {
+	all := []*Matcher{{IsRegex: true, Name: ".*"}}
+	return []*Grant{{
+		Source:             GRANT_SOURCE_CLUSTER_ADMIN,
+		Database:           "*",
+		ReadFrom:           all,
+		WriteTo:            all,
+		IsAdmin:            true,
+		CanCreateDatabases: true,
+	}}
+}
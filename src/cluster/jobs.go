@@ -0,0 +1,237 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	JOB_PENDING   = "pending"
+	JOB_RUNNING   = "running"
+	JOB_DONE      = "done"
+	JOB_FAILED    = "failed"
+	JOB_CANCELLED = "cancelled"
+
+	// the finished jobs that are kept so their status can be checked
+	JOB_HISTORY_SIZE = 100
+)
+
+// An operation that runs in the background on this server, e.g. the
+// repair of a shard. The methods of a nil job do nothing, so the
+// operations can report their progress without checking if they're
+// tracked.
+type Job struct {
+	id          uint64
+	jobType     string
+	target      string
+	cancellable bool
+	lock        sync.Mutex
+	status      string
+	progress    float64
+	err         string
+	createdAt   time.Time
+	startedAt   time.Time
+	finishedAt  time.Time
+	cancel      chan bool
+	cancelled   bool
+}
+
+type JobStatus struct {
+	Id          uint64    `json:"id"`
+	Type        string    `json:"type"`
+	Target      string    `json:"target"`
+	Status      string    `json:"status"`
+	Progress    float64   `json:"progress"`
+	Error       string    `json:"error,omitempty"`
+	Cancellable bool      `json:"cancellable"`
+	CreatedAt   time.Time `json:"createdAt"`
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+}
+
+func (self *Job) Id() uint64 {
+	return self.id
+}
+
+func (self *Job) Status() *JobStatus {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return &JobStatus{
+		Id:          self.id,
+		Type:        self.jobType,
+		Target:      self.target,
+		Status:      self.status,
+		Progress:    self.progress,
+		Error:       self.err,
+		Cancellable: self.cancellable,
+		CreatedAt:   self.createdAt,
+		StartedAt:   self.startedAt,
+		FinishedAt:  self.finishedAt,
+	}
+}
+
+func (self *Job) isFinished() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return !self.finishedAt.IsZero()
+}
+
+func (self *Job) Start() {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.status = JOB_RUNNING
+	self.startedAt = time.Now()
+}
+
+// Sets the progress to done out of total
+func (self *Job) SetProgress(done, total int) {
+	if self == nil || total <= 0 {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.progress = 100 * float64(done) / float64(total)
+}
+
+// The job failed if err isn't nil, the jobs that stop because they were
+// cancelled end up cancelled
+func (self *Job) Finish(err error) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.finishedAt = time.Now()
+	switch {
+	case err != nil:
+		self.status = JOB_FAILED
+		self.err = err.Error()
+	case self.cancelled:
+		self.status = JOB_CANCELLED
+	default:
+		self.status = JOB_DONE
+		self.progress = 100
+	}
+}
+
+// Returns a channel that is closed when the job is cancelled, the
+// channel of a nil job is never closed
+func (self *Job) Cancelled() <-chan bool {
+	if self == nil {
+		return nil
+	}
+	return self.cancel
+}
+
+func (self *Job) IsCancelled() bool {
+	if self == nil {
+		return false
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.cancelled
+}
+
+// The jobs of this server by id. The jobs aren't replicated, the status
+// of a job has to be checked on the server that runs it.
+type JobRegistry struct {
+	lock   sync.Mutex
+	lastId uint64
+	jobs   map[uint64]*Job
+}
+
+func NewJobRegistry() *JobRegistry {
+	return &JobRegistry{jobs: make(map[uint64]*Job)}
+}
+
+// Returns a new pending job. The operations that can be cancelled have
+// to stop once Cancelled() is closed.
+func (self *JobRegistry) Add(jobType, target string, cancellable bool) *Job {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.lastId++
+	job := &Job{
+		id:          self.lastId,
+		jobType:     jobType,
+		target:      target,
+		cancellable: cancellable,
+		status:      JOB_PENDING,
+		createdAt:   time.Now(),
+		cancel:      make(chan bool),
+	}
+	self.jobs[job.id] = job
+	self.prune()
+	return job
+}
+
+// Drops the oldest finished jobs beyond the history size, the lock
+// must be held
+func (self *JobRegistry) prune() {
+	finished := make([]uint64, 0)
+	for id, job := range self.jobs {
+		if job.isFinished() {
+			finished = append(finished, id)
+		}
+	}
+	if len(finished) <= JOB_HISTORY_SIZE {
+		return
+	}
+	sort.Sort(uint64Slice(finished))
+	for _, id := range finished[:len(finished)-JOB_HISTORY_SIZE] {
+		delete(self.jobs, id)
+	}
+}
+
+// Returns the job or nil if it doesn't exist
+func (self *JobRegistry) Get(id uint64) *Job {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.jobs[id]
+}
+
+// Returns the status of the jobs, oldest first
+func (self *JobRegistry) List() []*JobStatus {
+	self.lock.Lock()
+	ids := make([]uint64, 0, len(self.jobs))
+	for id := range self.jobs {
+		ids = append(ids, id)
+	}
+	sort.Sort(uint64Slice(ids))
+	jobs := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		jobs = append(jobs, self.jobs[id])
+	}
+	self.lock.Unlock()
+
+	statuses := make([]*JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		statuses = append(statuses, job.Status())
+	}
+	return statuses
+}
+
+// Asks the job to stop, the job is cancelled once the operation stops
+func (self *JobRegistry) Cancel(id uint64) error {
+	job := self.Get(id)
+	if job == nil {
+		return fmt.Errorf("Job %d doesn't exist", id)
+	}
+	if !job.cancellable {
+		return fmt.Errorf("Job %d can't be cancelled", id)
+	}
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	if !job.finishedAt.IsZero() {
+		return fmt.Errorf("Job %d already finished", id)
+	}
+	if !job.cancelled {
+		job.cancelled = true
+		close(job.cancel)
+	}
+	return nil
+}
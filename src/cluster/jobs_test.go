@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"fmt"
+
+	. "launchpad.net/gocheck"
+)
+
+type JobSuite struct{}
+
+var _ = Suite(&JobSuite{})
+
+func (self *JobSuite) TestProgressAndStatus(c *C) {
+	jobs := NewJobRegistry()
+	job := jobs.Add("scrub", "", true)
+	c.Assert(job.Status().Status, Equals, JOB_PENDING)
+
+	job.Start()
+	job.SetProgress(1, 4)
+	status := jobs.Get(job.Id()).Status()
+	c.Assert(status.Status, Equals, JOB_RUNNING)
+	c.Assert(status.Progress, Equals, 25.0)
+
+	job.Finish(nil)
+	c.Assert(job.Status().Status, Equals, JOB_DONE)
+	c.Assert(job.Status().Progress, Equals, 100.0)
+
+	failed := jobs.Add("repair_shard", "1", false)
+	failed.Finish(fmt.Errorf("no healthy replicas"))
+	c.Assert(failed.Status().Status, Equals, JOB_FAILED)
+	c.Assert(failed.Status().Error, Equals, "no healthy replicas")
+	c.Assert(jobs.List(), HasLen, 2)
+	c.Assert(jobs.List()[0].Id, Equals, job.Id())
+}
+
+func (self *JobSuite) TestCancel(c *C) {
+	jobs := NewJobRegistry()
+	job := jobs.Add("scrub", "", true)
+	job.Start()
+	c.Assert(jobs.Cancel(job.Id()), IsNil)
+	<-job.Cancelled()
+	c.Assert(job.IsCancelled(), Equals, true)
+	job.Finish(nil)
+	c.Assert(job.Status().Status, Equals, JOB_CANCELLED)
+	c.Assert(jobs.Cancel(job.Id()), NotNil)
+
+	c.Assert(jobs.Cancel(jobs.Add("move_shard", "1 from 1 to 2", false).Id()), NotNil)
+	c.Assert(jobs.Cancel(100), NotNil)
+
+	// the methods of a nil job do nothing
+	var nilJob *Job
+	nilJob.Start()
+	nilJob.SetProgress(1, 2)
+	nilJob.Finish(nil)
+	c.Assert(nilJob.IsCancelled(), Equals, false)
+}
+
+func (self *JobSuite) TestFinishedJobsArePruned(c *C) {
+	jobs := NewJobRegistry()
+	running := jobs.Add("scrub", "", true)
+	for i := 0; i < JOB_HISTORY_SIZE+10; i++ {
+		jobs.Add("repair_shard", "1", false).Finish(nil)
+	}
+	jobs.Add("scrub", "", true)
+	c.Assert(jobs.Get(running.Id()), NotNil)
+	c.Assert(jobs.Get(2), IsNil)
+	c.Assert(jobs.List(), HasLen, JOB_HISTORY_SIZE+2)
+}
@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/go-ldap/ldap"
+)
+
+const (
+	LDAP_USER_PLACEHOLDER = "{user}"
+	LDAP_DN_PLACEHOLDER   = "{dn}"
+)
+
+// Authenticates the users by binding to an ldap server or an active
+// directory domain controller with their credentials. UserDn is the dn
+// of the users with {user} in place of the username, e.g.
+// uid={user},ou=people,dc=example,dc=com, or {user}@example.com for
+// active directory. The groups of the user are the values of
+// GroupAttribute of the entries under GroupSearchBase that match
+// GroupSearchFilter, where {user} is replaced with the username and
+// {dn} with the dn the user is bound as.
+type LdapAuthenticator struct {
+	Url                string
+	InsecureSkipVerify bool
+	UserDn             string
+	GroupSearchBase    string
+	GroupSearchFilter  string
+	GroupAttribute     string
+}
+
+func (self *LdapAuthenticator) dial() (*ldap.Conn, error) {
+	u, err := url.Parse(self.Url)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	switch u.Scheme {
+	case "ldap":
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "389")
+		}
+		return ldap.Dial("tcp", host)
+	case "ldaps":
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "636")
+		}
+		hostname, _, _ := net.SplitHostPort(host)
+		return ldap.DialTLS("tcp", host, &tls.Config{
+			ServerName:         hostname,
+			InsecureSkipVerify: self.InsecureSkipVerify,
+		})
+	}
+	return nil, fmt.Errorf("Unsupported ldap url %s, the scheme has to be ldap or ldaps", self.Url)
+}
+
+func (self *LdapAuthenticator) Authenticate(username, password string) ([]string, error) {
+	// an empty password is an anonymous bind which always succeeds
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := self.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dn := strings.Replace(self.UserDn, LDAP_USER_PLACEHOLDER, escapeLdapDn(username), -1)
+	if err := conn.Bind(dn, password); err != nil {
+		if e, ok := err.(*ldap.Error); ok && e.ResultCode == ldap.LDAPResultInvalidCredentials {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if self.GroupSearchBase == "" {
+		return nil, nil
+	}
+	filter := strings.NewReplacer(
+		LDAP_USER_PLACEHOLDER, escapeLdapFilter(username),
+		LDAP_DN_PLACEHOLDER, escapeLdapFilter(dn),
+	).Replace(self.GroupSearchFilter)
+	request := ldap.NewSearchRequest(
+		self.GroupSearchBase,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{self.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		if group := entry.GetAttributeValue(self.GroupAttribute); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// Escapes a value that's used in a dn, see rfc 4514
+func escapeLdapDn(value string) string {
+	escaped := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0:
+			escaped = append(escaped, '\\', c)
+		case (c == ' ' || c == '#') && i == 0, c == ' ' && i == len(value)-1:
+			escaped = append(escaped, '\\', c)
+		case c == 0:
+			escaped = append(escaped, `\00`...)
+		default:
+			escaped = append(escaped, c)
+		}
+	}
+	return string(escaped)
+}
+
+// Escapes a value that's used in a search filter, see rfc 4515
+func escapeLdapFilter(value string) string {
+	escaped := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case '*', '(', ')', '\\', 0:
+			escaped = append(escaped, fmt.Sprintf("\\%02x", c)...)
+		default:
+			escaped = append(escaped, c)
+		}
+	}
+	return string(escaped)
+}
@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// A role bundles the permissions on databases, db users that are
+// assigned a role get the permissions on their database on top of
+// their own. Changing a role changes the permissions of all its users.
+type Role struct {
+	Name        string                     `json:"name"`
+	Permissions map[string]*RolePermission `json:"permissions"`
+	CreatedBy   string                     `json:"created_by"`
+	IsDeleted   bool                       `json:"is_deleted"`
+}
+
+// The permissions of a role on a database
+type RolePermission struct {
+	ReadFrom []*Matcher `json:"read_matchers"`
+	WriteTo  []*Matcher `json:"write_matchers"`
+	IsAdmin  bool       `json:"is_admin"`
+}
+
+var validRoleName = regexp.MustCompile("^[a-zA-Z0-9_][a-zA-Z0-9\\._-]*$")
+
+func (self *Role) Validate() error {
+	if !validRoleName.MatchString(self.Name) {
+		return fmt.Errorf("%s isn't a valid role name", self.Name)
+	}
+	for db, permission := range self.Permissions {
+		if permission == nil {
+			return fmt.Errorf("Role %s has no permissions on %s", self.Name, db)
+		}
+		for _, matchers := range [][]*Matcher{permission.ReadFrom, permission.WriteTo} {
+			for _, matcher := range matchers {
+				if !matcher.IsRegex {
+					continue
+				}
+				if _, err := regexp.Compile(matcher.Name); err != nil {
+					return fmt.Errorf("Invalid matcher %s in role %s: %s", matcher.Name, self.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (self *Role) permission(db string) *RolePermission {
+	if self == nil {
+		return nil
+	}
+	return self.Permissions[db]
+}
+
+func (self *RolePermission) hasReadAccess(name string) bool {
+	return matchesAny(self.ReadFrom, name)
+}
+
+func (self *RolePermission) hasWriteAccess(name string) bool {
+	return matchesAny(self.WriteTo, name)
+}
+
+func matchesAny(matchers []*Matcher, name string) bool {
+	for _, matcher := range matchers {
+		if matcher.Matches(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type RoleSuite struct{}
+
+var _ = Suite(&RoleSuite{})
+
+func (self *RoleSuite) TestValidate(c *C) {
+	role := &Role{Name: "collectors", Permissions: map[string]*RolePermission{
+		"db1": {WriteTo: []*Matcher{{true, "^cpu\\..*"}}},
+	}}
+	c.Assert(role.Validate(), IsNil)
+
+	c.Assert((&Role{Name: "invalid name"}).Validate(), NotNil)
+	role.Permissions["db1"].ReadFrom = []*Matcher{{true, "("}}
+	c.Assert(role.Validate(), NotNil)
+}
+
+func (self *RoleSuite) TestUsersGetThePermissionsOfTheirRoles(c *C) {
+	config := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(config.CreateDatabase("db1", 1), IsNil)
+	config.SaveRole(&Role{Name: "collectors", Permissions: map[string]*RolePermission{
+		"db1": {WriteTo: []*Matcher{{true, "^cpu\\..*"}}},
+		"db2": {IsAdmin: true},
+	}})
+	config.SaveDbUser(&DbUser{CommonUser: CommonUser{Name: "agent"}, Db: "db1", Roles: []string{"collectors", "missing"}})
+	user := config.GetDbUser("db1", "agent")
+	c.Assert(user.HasWriteAccess("cpu.idle"), Equals, true)
+	c.Assert(user.HasWriteAccess("memory.free"), Equals, false)
+	c.Assert(user.HasReadAccess("cpu.idle"), Equals, false)
+	// the permissions on other databases don't apply
+	c.Assert(user.IsDbAdmin("db1"), Equals, false)
+
+	// changing the role changes the permissions of its users
+	config.SaveRole(&Role{Name: "collectors", Permissions: map[string]*RolePermission{
+		"db1": {ReadFrom: []*Matcher{{true, ".*"}}, IsAdmin: true},
+	}})
+	c.Assert(user.HasWriteAccess("cpu.idle"), Equals, false)
+	c.Assert(user.HasReadAccess("memory.free"), Equals, true)
+	c.Assert(user.IsDbAdmin("db1"), Equals, true)
+
+	// the roles survive a snapshot
+	data, err := config.Save()
+	c.Assert(err, IsNil)
+	recovered := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(recovered.Recovery(data), IsNil)
+	c.Assert(recovered.GetRole("collectors"), NotNil)
+	c.Assert(recovered.GetDbUser("db1", "agent").HasReadAccess("memory.free"), Equals, true)
+
+	// deleting the role removes it from the users
+	config.SaveRole(&Role{Name: "collectors", IsDeleted: true})
+	c.Assert(config.GetRoles(), HasLen, 0)
+	c.Assert(user.Roles, DeepEquals, []string{"missing"})
+	c.Assert(user.HasReadAccess("memory.free"), Equals, false)
+}
+
+func (self *RoleSuite) TestGrants(c *C) {
+	config := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(config.CreateDatabase("db1", 1), IsNil)
+	config.SaveRole(&Role{Name: "collectors", Permissions: map[string]*RolePermission{
+		"db1": {WriteTo: []*Matcher{{true, "^cpu\\..*"}, {false, "load"}}},
+	}})
+	config.SaveDbUser(&DbUser{CommonUser: CommonUser{Name: "agent"}, Db: "db1", ReadFrom: []*Matcher{{false, "load"}}, Roles: []string{"collectors"}})
+
+	grants := config.GetDbUser("db1", "agent").Grants()
+	c.Assert(grants, HasLen, 2)
+	c.Assert(grants[0].Source, Equals, GRANT_SOURCE_USER)
+	c.Assert(FormatMatchers(grants[0].ReadFrom), Equals, "load")
+	c.Assert(grants[1].Source, Equals, "role:collectors")
+	c.Assert(grants[1].Database, Equals, "db1")
+	c.Assert(FormatMatchers(grants[1].WriteTo), Equals, "/^cpu\\..*/,load")
+}
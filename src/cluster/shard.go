@@ -7,7 +7,9 @@ import (
 	"parser"
 	p "protocol"
 	"sort"
+	"stats"
 	"strings"
+	"sync"
 	"time"
 	"wal"
 
@@ -49,6 +51,10 @@ type NewShardData struct {
 	ServerIds     []uint32
 	Type          ShardType
 	DurationSplit bool `json:",omitempty"`
+	// archived shards are mounted read only from Path on the
+	// server in ServerIds and are only used for queries
+	ReadOnly bool   `json:",omitempty"`
+	Path     string `json:",omitempty"`
 }
 
 type ShardType int
@@ -59,12 +65,16 @@ const (
 )
 
 type ShardData struct {
-	id               uint32
-	startTime        time.Time
-	startMicro       int64
-	endMicro         int64
-	endTime          time.Time
-	wal              WAL
+	id         uint32
+	startTime  time.Time
+	startMicro int64
+	endMicro   int64
+	endTime    time.Time
+	wal        WAL
+	// guards servers, clusterServers and serverIds. They're replaced
+	// instead of modified when the replicas change, so the writes and
+	// queries can use them after the lock is released.
+	replicasLock     sync.RWMutex
 	servers          []wal.Server
 	clusterServers   []*ClusterServer
 	store            LocalShardStore
@@ -75,6 +85,15 @@ type ShardData struct {
 	shardNanoseconds uint64
 	localServerId    uint32
 	IsLocal          bool
+	readOnly         bool
+	mountPath        string
+	// repairs the local copy of the shard from the other replicas once
+	// it's found corrupt
+	repair func(*ShardData) error
+	// the points written to the shard through this server and the
+	// queries that ran against the local copy of the shard
+	writes  *stats.Meter
+	queries *stats.Meter
 }
 
 func NewShard(id uint32, startTime, endTime time.Time, shardType ShardType, durationIsSplit bool, wal WAL) *ShardData {
@@ -91,6 +110,8 @@ func NewShard(id uint32, startTime, endTime time.Time, shardType ShardType, dura
 		durationIsSplit:  durationIsSplit,
 		shardDuration:    shardDuration,
 		shardNanoseconds: uint64(shardDuration),
+		writes:           stats.NewUnregisteredMeter(),
+		queries:          stats.NewUnregisteredMeter(),
 	}
 }
 
@@ -121,6 +142,25 @@ type LocalShardStore interface {
 	GetOrCreateShard(id uint32) (LocalShardDb, error)
 	ReturnShard(id uint32)
 	DeleteShard(shardId uint32) error
+	SetShardType(id uint32, shardType ShardType)
+	// returns the size on disk and the number of series of the shard
+	ShardStats(id uint32) (size int64, seriesCount int, err error)
+	// returns the approximate size on disk of the points of the database
+	// in the shard
+	DatabaseSize(id uint32, db string) (int64, error)
+	MountShard(id uint32, path string) error
+	VerifyShard(id uint32) error
+	QuarantineShard(id uint32)
+	UnquarantineShard(id uint32)
+	IsQuarantined(id uint32) bool
+	// returns true if the shard is on the local disk
+	HasShard(id uint32) bool
+	// returns an empty replacement of the shard that gets the writes of
+	// the shard until the repair is finished or aborted
+	StartShardRepair(id uint32) (LocalShardDb, error)
+	// replaces the shard with its replacement
+	FinishShardRepair(id uint32) error
+	AbortShardRepair(id uint32)
 }
 
 func (self *ShardData) Id() uint32 {
@@ -148,21 +188,99 @@ func (self *ShardData) IsMicrosecondInRange(t int64) bool {
 }
 
 func (self *ShardData) SetServers(servers []*ClusterServer) {
-	self.clusterServers = servers
-	self.servers = make([]wal.Server, len(servers), len(servers))
+	self.replicasLock.Lock()
+	defer self.replicasLock.Unlock()
+	walServers := make([]wal.Server, len(servers), len(servers))
+	serverIds := append([]uint32{}, self.serverIds...)
 	for i, server := range servers {
-		self.serverIds = append(self.serverIds, server.Id)
-		self.servers[i] = server
+		serverIds = append(serverIds, server.Id)
+		walServers[i] = server
+	}
+	self.clusterServers = servers
+	self.servers = walServers
+	self.serverIds = sortServerIds(serverIds)
+}
+
+// Adds a remote server to the replicas of the shard
+func (self *ShardData) AddServer(server *ClusterServer) {
+	self.replicasLock.Lock()
+	defer self.replicasLock.Unlock()
+	self.clusterServers = append(append([]*ClusterServer{}, self.clusterServers...), server)
+	self.servers = append(append([]wal.Server{}, self.servers...), server)
+	self.serverIds = sortServerIds(append(append([]uint32{}, self.serverIds...), server.Id))
+}
+
+// Returns the remote replicas of the shard, the slice isn't modified
+// when they change
+func (self *ShardData) remoteServers() []*ClusterServer {
+	self.replicasLock.RLock()
+	defer self.replicasLock.RUnlock()
+	return self.clusterServers
+}
+
+// Removes the servers from the replicas of the shard, the writes stop
+// going to them. If the local server is removed the shard isn't local
+// anymore.
+func (self *ShardData) removeServers(serverIds []uint32) {
+	self.replicasLock.Lock()
+	defer self.replicasLock.Unlock()
+	removed := make(map[uint32]bool, len(serverIds))
+	for _, id := range serverIds {
+		removed[id] = true
+	}
+	newIds := make([]uint32, 0)
+	for _, id := range self.serverIds {
+		if !removed[id] {
+			newIds = append(newIds, id)
+		}
+	}
+	self.serverIds = newIds
+
+	clusterServers := make([]*ClusterServer, 0, len(self.clusterServers))
+	servers := make([]wal.Server, 0, len(self.clusterServers))
+	for _, server := range self.clusterServers {
+		if !removed[server.Id] {
+			clusterServers = append(clusterServers, server)
+			servers = append(servers, server)
+		}
+	}
+	self.clusterServers = clusterServers
+	self.servers = servers
+
+	if self.IsLocal && removed[self.localServerId] {
+		self.IsLocal = false
 	}
-	self.sortServerIds()
+}
+
+// SetReadOnly marks the shard as an archived shard that can only be
+// queried. If the shard is local it will be mounted from the given
+// path.
+func (self *ShardData) SetReadOnly(path string) {
+	self.readOnly = true
+	self.mountPath = path
+}
+
+func (self *ShardData) IsReadOnly() bool {
+	return self.readOnly
+}
+
+func (self *ShardData) MountPath() string {
+	return self.mountPath
 }
 
 func (self *ShardData) SetLocalStore(store LocalShardStore, localServerId uint32) error {
-	self.serverIds = append(self.serverIds, localServerId)
+	self.replicasLock.Lock()
+	self.serverIds = sortServerIds(append(append([]uint32{}, self.serverIds...), localServerId))
+	self.replicasLock.Unlock()
 	self.localServerId = localServerId
-	self.sortServerIds()
 
 	self.store = store
+	self.store.SetShardType(self.id, self.shardType)
+	if self.readOnly {
+		if err := self.store.MountShard(self.id, self.mountPath); err != nil {
+			return err
+		}
+	}
 	// make sure we can open up the shard
 	_, err := self.store.GetOrCreateShard(self.id)
 	if err != nil {
@@ -174,21 +292,31 @@ func (self *ShardData) SetLocalStore(store LocalShardStore, localServerId uint32
 	return nil
 }
 
+// Returns the ids of the servers that have the shard in ascending
+// order, the slice isn't modified when they change
 func (self *ShardData) ServerIds() []uint32 {
+	self.replicasLock.RLock()
+	defer self.replicasLock.RUnlock()
 	return self.serverIds
 }
 
 func (self *ShardData) Write(request *p.Request) error {
+	if self.readOnly {
+		return fmt.Errorf("Shard %d is read only", self.id)
+	}
 	request.ShardId = &self.id
 	requestNumber, err := self.wal.AssignSequenceNumbersAndLog(request, self)
 	if err != nil {
 		return err
 	}
 	request.RequestNumber = &requestNumber
-	if self.store != nil {
+	for _, series := range request.MultiSeries {
+		self.writes.Mark(int64(len(series.Points)))
+	}
+	if self.IsLocal {
 		self.store.BufferWrite(request)
 	}
-	for _, server := range self.clusterServers {
+	for _, server := range self.remoteServers() {
 		// we have to create a new reqeust object because the ID gets assigned on each server.
 		requestWithoutId := &p.Request{Type: request.Type, Database: request.Database, MultiSeries: request.MultiSeries, ShardId: &self.id, RequestNumber: request.RequestNumber}
 		server.BufferWrite(requestWithoutId)
@@ -197,6 +325,9 @@ func (self *ShardData) Write(request *p.Request) error {
 }
 
 func (self *ShardData) WriteLocalOnly(request *p.Request) error {
+	if self.readOnly {
+		return fmt.Errorf("Shard %d is read only", self.id)
+	}
 	self.store.Write(request)
 	return nil
 }
@@ -216,7 +347,25 @@ func (self *ShardData) Query(querySpec *parser.QuerySpec, response chan *p.Respo
 		}
 	}
 
-	if self.IsLocal {
+	clusterServers := self.remoteServers()
+	healthyServers := make([]*ClusterServer, 0, len(clusterServers))
+	for _, s := range clusterServers {
+		if !s.IsUp() {
+			continue
+		}
+		healthyServers = append(healthyServers, s)
+	}
+
+	if self.IsLocal && self.store.IsQuarantined(self.id) {
+		if len(healthyServers) == 0 {
+			log.Warn("Shard %d is quarantined and has no healthy replicas, excluding it from query %s", self.id, querySpec.GetQueryString())
+			response <- &p.Response{Type: &endStreamResponse}
+			return
+		}
+		log.Warn("Shard %d is quarantined, querying a healthy replica instead", self.id)
+	} else if self.IsLocal {
+		self.queries.Mark(1)
+		start := time.Now()
 		var processor QueryProcessor
 		var err error
 
@@ -268,19 +417,17 @@ func (self *ShardData) Query(querySpec *parser.QuerySpec, response chan *p.Respo
 		err = shard.Query(querySpec, processor)
 		processor.Close()
 		if err != nil {
+			if _, ok := err.(common.CorruptDataError); ok {
+				log.Error("Shard %d is corrupt: %s", self.id, err)
+				self.quarantine()
+			}
 			response <- &p.Response{Type: &endStreamResponse, ErrorMessage: p.String(err.Error())}
 		}
+		common.GetTrace(querySpec.User()).Time("shard_scan", self.localServerId, self.id, start)
 		response <- &p.Response{Type: &endStreamResponse}
 		return
 	}
 
-	healthyServers := make([]*ClusterServer, 0, len(self.clusterServers))
-	for _, s := range self.clusterServers {
-		if !s.IsUp() {
-			continue
-		}
-		healthyServers = append(healthyServers, s)
-	}
 	healthyCount := len(healthyServers)
 	if healthyCount == 0 {
 		message := fmt.Sprintf("No servers up to query shard %d", self.id)
@@ -296,6 +443,102 @@ func (self *ShardData) Query(querySpec *parser.QuerySpec, response chan *p.Respo
 	server.MakeRequest(request, response)
 }
 
+// Quarantines the local copy of the shard, so it isn't queried, and
+// repairs it from the other replicas in the background unless it's
+// mounted
+func (self *ShardData) quarantine() {
+	self.store.QuarantineShard(self.id)
+	if self.repair != nil && !self.readOnly {
+		go self.repair(self)
+	}
+}
+
+// Repair replaces the data of a quarantined local shard with the data
+// of a healthy replica. The data is copied to a replacement next to the
+// shard that gets the new writes too, the shard is swapped with it and
+// unquarantined once all the databases have been copied. The progress is
+// reported to the job.
+func (self *ShardData) Repair(databases []string, job *Job) error {
+	if !self.IsLocal || !self.store.IsQuarantined(self.id) {
+		return nil
+	}
+	if self.readOnly {
+		// mounted shards don't have other replicas
+		return fmt.Errorf("Shard %d is mounted read only, it can't be repaired", self.id)
+	}
+
+	var server *ClusterServer
+	for _, s := range self.remoteServers() {
+		if s.IsUp() {
+			server = s
+			break
+		}
+	}
+	if server == nil {
+		return fmt.Errorf("No healthy replicas to repair shard %d from", self.id)
+	}
+
+	log.Info("Repairing shard %d from server %d", self.id, server.GetId())
+	replacement, err := self.store.StartShardRepair(self.id)
+	if err != nil {
+		return err
+	}
+	if err := self.copyFrom(server, replacement, databases, job); err != nil {
+		self.store.AbortShardRepair(self.id)
+		return err
+	}
+	if err := self.store.FinishShardRepair(self.id); err != nil {
+		return err
+	}
+
+	self.store.UnquarantineShard(self.id)
+	log.Info("Repaired shard %d", self.id)
+	return nil
+}
+
+// Copies the points of the databases in the shard on the server to the
+// local shard db
+func (self *ShardData) copyFrom(server *ClusterServer, shard LocalShardDb, databases []string, job *Job) error {
+	queries, err := parser.ParseQuery("select * from /.*/")
+	if err != nil {
+		return err
+	}
+	queryString := queries[0].SelectQuery.GetQueryStringWithTimes(self.startTime, self.endTime)
+
+	userName := InternalClusterAdmin.GetName()
+	isDbUser := false
+	for i, database := range databases {
+		job.SetProgress(i, len(databases))
+		db := database
+		request := &p.Request{
+			Type:     &queryRequest,
+			ShardId:  &self.id,
+			Query:    &queryString,
+			UserName: &userName,
+			Database: &db,
+			IsDbUser: &isDbUser,
+		}
+		responses := make(chan *p.Response, 100)
+		server.MakeRequest(request, responses)
+		for {
+			response := <-responses
+			if response.GetType() == endStreamResponse || response.GetType() == accessDeniedResponse {
+				if response.ErrorMessage != nil {
+					return fmt.Errorf("Error repairing shard %d: %s", self.id, response.GetErrorMessage())
+				}
+				break
+			}
+			if response.Series == nil || len(response.Series.Points) == 0 {
+				continue
+			}
+			if err := shard.Write(db, response.Series); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (self *ShardData) DropDatabase(database string, sendToServers bool) {
 	if self.IsLocal {
 		if shard, err := self.store.GetOrCreateShard(self.id); err == nil {
@@ -308,8 +551,9 @@ func (self *ShardData) DropDatabase(database string, sendToServers bool) {
 		return
 	}
 
-	responses := make([]chan *p.Response, len(self.clusterServers), len(self.clusterServers))
-	for i, server := range self.clusterServers {
+	clusterServers := self.remoteServers()
+	responses := make([]chan *p.Response, len(clusterServers), len(clusterServers))
+	for i, server := range clusterServers {
 		responseChan := make(chan *p.Response, 1)
 		responses[i] = responseChan
 		request := &p.Request{Type: &dropDatabaseRequest, Database: &database, ShardId: &self.id}
@@ -323,7 +567,7 @@ func (self *ShardData) DropDatabase(database string, sendToServers bool) {
 
 func (self *ShardData) String() string {
 	serversString := make([]string, 0)
-	for _, s := range self.servers {
+	for _, s := range self.remoteServers() {
 		serversString = append(serversString, fmt.Sprintf("%d", s.GetId()))
 	}
 	local := "false"
@@ -331,7 +575,12 @@ func (self *ShardData) String() string {
 		local = "true"
 	}
 
-	return fmt.Sprintf("[ID: %d, START: %d, END: %d, LOCAL: %s, SERVERS: [%s]]", self.id, self.startMicro, self.endMicro, local, strings.Join(serversString, ","))
+	readOnly := ""
+	if self.readOnly {
+		readOnly = ", READ ONLY"
+	}
+
+	return fmt.Sprintf("[ID: %d, START: %d, END: %d, LOCAL: %s, SERVERS: [%s]%s]", self.id, self.startMicro, self.endMicro, local, strings.Join(serversString, ","), readOnly)
 }
 
 func (self *ShardData) ShouldAggregateLocally(querySpec *parser.QuerySpec) bool {
@@ -417,7 +666,7 @@ func (self *ShardData) deleteDataLocally(querySpec *parser.QuerySpec) (<-chan *p
 func (self *ShardData) forwardRequest(request *p.Request) ([]<-chan *p.Response, []uint32, error) {
 	ids := []uint32{}
 	responses := []<-chan *p.Response{}
-	for _, server := range self.clusterServers {
+	for _, server := range self.remoteServers() {
 		responseChan := make(chan *p.Response, 1)
 		// do this so that a new id will get assigned
 		request.Id = nil
@@ -490,7 +739,7 @@ func (self *ShardData) createRequest(querySpec *parser.QuerySpec) *p.Request {
 	database := querySpec.Database()
 	isDbUser := !user.IsClusterAdmin()
 
-	return &p.Request{
+	request := &p.Request{
 		Type:     &queryRequest,
 		ShardId:  &self.id,
 		Query:    &queryString,
@@ -498,6 +747,18 @@ func (self *ShardData) createRequest(querySpec *parser.QuerySpec) *p.Request {
 		Database: &database,
 		IsDbUser: &isDbUser,
 	}
+	if requestId := common.RequestId(user); requestId != "" {
+		request.RequestId = &requestId
+	}
+	if roles, ok := externalIdentity(user); ok {
+		request.IsExternalUser = &ok
+		request.Roles = roles
+	}
+	if common.GetTrace(user) != nil {
+		trace := true
+		request.Trace = &trace
+	}
+	return request
 }
 
 // used to serialize shards when sending around in raft or when snapshotting in the log
@@ -507,20 +768,22 @@ func (self *ShardData) ToNewShardData() *NewShardData {
 		StartTime: self.startTime,
 		EndTime:   self.endTime,
 		Type:      self.shardType,
-		ServerIds: self.serverIds,
+		ServerIds: self.ServerIds(),
 	}
 }
 
-// server ids should always be returned in sorted order
-func (self *ShardData) sortServerIds() {
-	serverIdInts := make([]int, len(self.serverIds), len(self.serverIds))
-	for i, id := range self.serverIds {
+// server ids should always be returned in sorted order, the ids are
+// sorted in place
+func sortServerIds(serverIds []uint32) []uint32 {
+	serverIdInts := make([]int, len(serverIds), len(serverIds))
+	for i, id := range serverIds {
 		serverIdInts[i] = int(id)
 	}
 	sort.Ints(serverIdInts)
 	for i, id := range serverIdInts {
-		self.serverIds[i] = uint32(id)
+		serverIds[i] = uint32(id)
 	}
+	return serverIds
 }
 
 func SortShardsByTimeAscending(shards []*ShardData) {
@@ -0,0 +1,213 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// The state of a copy of a shard
+type ShardReplica struct {
+	ServerId uint32 `json:"serverId"`
+	Local    bool   `json:"local"`
+	Up       bool   `json:"up"`
+	// the requests the server is behind
+	WriteLag uint32 `json:"writeLag"`
+	// only set for the local copy, it's being repaired from the other
+	// replicas if it's corrupt
+	Corrupt bool `json:"corrupt"`
+}
+
+// Returns the shard with the id or nil if it doesn't exist
+func (self *ClusterConfiguration) GetShardById(id uint32) *ShardData {
+	self.shardsByIdLock.RLock()
+	defer self.shardsByIdLock.RUnlock()
+	return self.shardsById[id]
+}
+
+// Returns the replicas of the shard as they're seen by this server
+func (self *ClusterConfiguration) ShardReplicas(shard *ShardData) []*ShardReplica {
+	self.shardsByIdLock.RLock()
+	defer self.shardsByIdLock.RUnlock()
+	replicas := make([]*ShardReplica, 0, len(shard.ServerIds()))
+	if shard.IsLocal {
+		replicas = append(replicas, &ShardReplica{
+			ServerId: self.LocalServerId,
+			Local:    true,
+			Up:       true,
+			Corrupt:  self.shardStore.IsQuarantined(shard.id),
+		})
+	}
+	for _, server := range shard.remoteServers() {
+		replicas = append(replicas, &ShardReplica{
+			ServerId: server.Id,
+			Up:       server.IsUp(),
+			WriteLag: server.WriteLag(),
+		})
+	}
+	return replicas
+}
+
+// Verifies the local copy of the shard, if there's one, and repairs it
+// from the other replicas in the background if it's corrupt. Returns
+// the replicas of the shard.
+func (self *ClusterConfiguration) CheckShardReplicas(shard *ShardData) ([]*ShardReplica, error) {
+	if shard.IsLocal && !self.shardStore.IsQuarantined(shard.id) {
+		if err := self.shardStore.VerifyShard(shard.id); err != nil {
+			log.Error("Error while verifying shard %d: %s", shard.id, err)
+		}
+		if self.shardStore.IsQuarantined(shard.id) {
+			go self.RepairShard(shard)
+		}
+	}
+	return self.ShardReplicas(shard), nil
+}
+
+// Adds the server to the replicas of the shard, the new replica gets
+// the new writes right away. If the server is this one the shard is
+// quarantined, so it isn't queried, until its data is copied from the
+// other replicas. Once it's copied the replica on moveFrom is dropped,
+// unless moveFrom is 0. Adding a server that already has the shard does
+// nothing. The shard isn't copied again if it's already on the local
+// disk and not quarantined, i.e. when the raft log is replayed after the
+// copy finished.
+func (self *ClusterConfiguration) AddShardReplica(shardId, serverId, moveFrom uint32) error {
+	shard := self.GetShardById(shardId)
+	if shard == nil {
+		return fmt.Errorf("Shard %d doesn't exist", shardId)
+	}
+	server := self.GetServerById(&serverId)
+	if server == nil {
+		return fmt.Errorf("Server %d doesn't exist", serverId)
+	}
+	if shard.IsReadOnly() {
+		return fmt.Errorf("Shard %d is mounted read only, it can't be replicated", shardId)
+	}
+
+	self.shardsByIdLock.Lock()
+	if shard.hasServer(serverId) {
+		self.shardsByIdLock.Unlock()
+		return nil
+	}
+	if serverId != self.LocalServerId {
+		shard.AddServer(server)
+		self.shardsByIdLock.Unlock()
+		log.Info("Added server %d to the replicas of shard %d", serverId, shardId)
+		return nil
+	}
+	copied := self.shardStore.HasShard(shardId) && !self.shardStore.IsQuarantined(shardId)
+	err := self.setLocalStore(shard)
+	self.shardsByIdLock.Unlock()
+	if err != nil {
+		return err
+	}
+	if copied {
+		log.Info("Shard %d was already copied to this server", shardId)
+		return nil
+	}
+
+	log.Info("Copying shard %d to this server", shardId)
+	self.shardStore.QuarantineShard(shardId)
+	go self.copyShard(shard, moveFrom)
+	return nil
+}
+
+const (
+	// the copies of the shards to this server are retried until they
+	// succeed, the delay doubles after every failure
+	SHARD_COPY_MIN_RETRY_DELAY = time.Second
+	SHARD_COPY_MAX_RETRY_DELAY = 5 * time.Minute
+)
+
+// Copies the shard from the other replicas and drops the replica on
+// moveFrom once it's copied, unless moveFrom is 0. The shard stays
+// quarantined until it's copied, so the copy is retried until it
+// succeeds or the shard isn't on this server anymore.
+func (self *ClusterConfiguration) copyShard(shard *ShardData, moveFrom uint32) {
+	shardId := shard.Id()
+	delay := SHARD_COPY_MIN_RETRY_DELAY
+	for {
+		err := self.RepairShard(shard)
+		if err == nil {
+			break
+		}
+		self.shardsByIdLock.RLock()
+		local := self.shardsById[shardId] == shard && shard.hasServer(self.LocalServerId)
+		self.shardsByIdLock.RUnlock()
+		if !local {
+			log.Info("Shard %d isn't on this server anymore, giving up copying it", shardId)
+			return
+		}
+		log.Warn("Cannot copy shard %d to this server, retrying in %s: %s", shardId, delay, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > SHARD_COPY_MAX_RETRY_DELAY {
+			delay = SHARD_COPY_MAX_RETRY_DELAY
+		}
+	}
+
+	self.shardsByIdLock.RLock()
+	moved := moveFrom != 0 && shard.hasServer(moveFrom)
+	self.shardsByIdLock.RUnlock()
+	if !moved {
+		return
+	}
+	log.Info("Copied shard %d, dropping it from server %d", shardId, moveFrom)
+	if err := self.shardCreator.DropShard(shardId, []uint32{moveFrom}); err != nil {
+		log.Error("Cannot drop shard %d from server %d after moving it: %s", shardId, moveFrom, err)
+	}
+}
+
+const (
+	SHARD_MOVE_CHECK_INTERVAL = time.Second
+	// the copy of the shard on the new server most likely failed if the
+	// old replica isn't dropped by then, its repair_shard job has the error
+	SHARD_MOVE_TIMEOUT = 6 * time.Hour
+)
+
+// Returns a job that follows the move of the shard through the
+// configuration of the cluster, so it can run on any server. It's half
+// done once the new server has the shard and done once the old one
+// doesn't have it anymore.
+func (self *ClusterConfiguration) WatchShardMove(shardId, from, to uint32) *Job {
+	job := self.jobs.Add("move_shard", fmt.Sprintf("%d from %d to %d", shardId, from, to), false)
+	job.Start()
+	go func() {
+		ticker := time.NewTicker(SHARD_MOVE_CHECK_INTERVAL)
+		defer ticker.Stop()
+		timeout := time.After(SHARD_MOVE_TIMEOUT)
+		for {
+			select {
+			case <-timeout:
+				job.Finish(fmt.Errorf("Shard %d wasn't moved to server %d after %s", shardId, to, SHARD_MOVE_TIMEOUT))
+				return
+			case <-ticker.C:
+			}
+			shard := self.GetShardById(shardId)
+			if shard == nil {
+				job.Finish(fmt.Errorf("Shard %d was dropped", shardId))
+				return
+			}
+			self.shardsByIdLock.RLock()
+			added, removed := shard.hasServer(to), !shard.hasServer(from)
+			self.shardsByIdLock.RUnlock()
+			if added && removed {
+				job.Finish(nil)
+				return
+			}
+			if added {
+				job.SetProgress(1, 2)
+			}
+		}
+	}()
+	return job
+}
+
+func (self *ShardData) hasServer(serverId uint32) bool {
+	for _, id := range self.ServerIds() {
+		if id == serverId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type ShardReplicasSuite struct{}
+
+var _ = Suite(&ShardReplicasSuite{})
+
+func (self *ShardReplicasSuite) TestAddAndRemoveServers(c *C) {
+	shard := NewShard(1, time.Now(), time.Now().Add(time.Hour), SHORT_TERM, false, nil)
+	shard.SetServers([]*ClusterServer{&ClusterServer{Id: 3}})
+	shard.AddServer(&ClusterServer{Id: 2})
+	c.Assert(shard.ServerIds(), DeepEquals, []uint32{2, 3})
+	c.Assert(shard.clusterServers, HasLen, 2)
+	c.Assert(shard.hasServer(2), Equals, true)
+
+	// the writes stop going to the removed servers
+	shard.removeServers([]uint32{3})
+	c.Assert(shard.ServerIds(), DeepEquals, []uint32{2})
+	c.Assert(shard.clusterServers, HasLen, 1)
+	c.Assert(shard.clusterServers[0].Id, Equals, uint32(2))
+	c.Assert(shard.servers, HasLen, 1)
+	c.Assert(shard.hasServer(3), Equals, false)
+}
+
+func (self *ShardReplicasSuite) TestRemovingTheLocalServer(c *C) {
+	shard := NewShard(1, time.Now(), time.Now().Add(time.Hour), SHORT_TERM, false, nil)
+	shard.SetServers([]*ClusterServer{&ClusterServer{Id: 2}})
+	shard.serverIds = append(shard.serverIds, 1)
+	shard.localServerId = 1
+	shard.IsLocal = true
+
+	shard.removeServers([]uint32{1})
+	c.Assert(shard.IsLocal, Equals, false)
+	c.Assert(shard.ServerIds(), DeepEquals, []uint32{2})
+}
+
+// replaying the raft log after the shard was copied doesn't copy it
+// again
+func (self *ShardReplicasSuite) TestAddingTheLocalServerToACopiedShard(c *C) {
+	store := &mockShardStore{shard: &mockShardDb{}, onDisk: true}
+	config := NewClusterConfiguration(nil, nil, store, nil)
+	config.LocalServerId = 1
+	config.servers = []*ClusterServer{&ClusterServer{Id: 1}, &ClusterServer{Id: 2}}
+	shard := NewShard(4, time.Now(), time.Now().Add(time.Hour), SHORT_TERM, false, nil)
+	shard.SetServers([]*ClusterServer{config.servers[1]})
+	config.shardsById[4] = shard
+
+	c.Assert(config.AddShardReplica(4, 1, 0), IsNil)
+	c.Assert(shard.IsLocal, Equals, true)
+	c.Assert(shard.ServerIds(), DeepEquals, []uint32{1, 2})
+	c.Assert(store.quarantined, Equals, false)
+}
@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// The scrubber walks through the local shards that aren't getting
+// written to anymore and verifies their checksums and metadata. It
+// goes through the shards one at a time and sleeps between them so it
+// doesn't compete with queries and writes.
+type ShardScrubber struct {
+	clusterConfig  *ClusterConfiguration
+	store          LocalShardStore
+	interval       time.Duration
+	pause          time.Duration
+	lock           sync.Mutex
+	results        map[uint32]*ScrubResult
+	lastRun        time.Time
+	running        bool
+	shardsScrubbed int64
+	corruptShards  int64
+	trigger        chan *Job
+	// the job of the triggered scrub that didn't start yet
+	pending *Job
+}
+
+type ScrubResult struct {
+	ShardId    uint32        `json:"shardId"`
+	ScrubbedAt time.Time     `json:"scrubbedAt"`
+	Duration   time.Duration `json:"duration"`
+	Corrupt    bool          `json:"corrupt"`
+	Error      string        `json:"error,omitempty"`
+}
+
+type ScrubStats struct {
+	Running        bool           `json:"running"`
+	LastRun        time.Time      `json:"lastRun"`
+	ShardsScrubbed int64          `json:"shardsScrubbed"`
+	CorruptShards  int64          `json:"corruptShards"`
+	Results        []*ScrubResult `json:"results"`
+}
+
+const (
+	SCRUBBER_PAUSE_BETWEEN_SHARDS = 10 * time.Second
+)
+
+func NewShardScrubber(clusterConfig *ClusterConfiguration, store LocalShardStore, interval time.Duration) *ShardScrubber {
+	return &ShardScrubber{
+		clusterConfig: clusterConfig,
+		store:         store,
+		interval:      interval,
+		pause:         SCRUBBER_PAUSE_BETWEEN_SHARDS,
+		results:       make(map[uint32]*ScrubResult),
+		trigger:       make(chan *Job, 1),
+	}
+}
+
+// Start runs the scrubber in the background every interval. A zero
+// interval means that the scrubber only runs when triggered.
+func (self *ShardScrubber) Start() {
+	go func() {
+		for {
+			var job *Job
+			if self.interval > 0 {
+				select {
+				case job = <-self.trigger:
+				case <-time.After(self.interval):
+				}
+			} else {
+				job = <-self.trigger
+			}
+			if job == nil {
+				job = self.clusterConfig.Jobs().Add("scrub", "", true)
+			} else {
+				self.lock.Lock()
+				self.pending = nil
+				self.lock.Unlock()
+			}
+			self.scrub(job)
+		}
+	}()
+}
+
+// Trigger starts a scrub immediately, or once the running one is done.
+// Returns the job of the scrub, triggering it again before it starts
+// returns the same job.
+func (self *ShardScrubber) Trigger() *Job {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.pending == nil {
+		self.pending = self.clusterConfig.Jobs().Add("scrub", "", true)
+		self.trigger <- self.pending
+	}
+	return self.pending
+}
+
+func (self *ShardScrubber) Stats() *ScrubStats {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	results := make([]*ScrubResult, 0, len(self.results))
+	for _, result := range self.results {
+		results = append(results, result)
+	}
+	return &ScrubStats{
+		Running:        self.running,
+		LastRun:        self.lastRun,
+		ShardsScrubbed: self.shardsScrubbed,
+		CorruptShards:  self.corruptShards,
+		Results:        results,
+	}
+}
+
+func (self *ShardScrubber) scrub(job *Job) {
+	job.Start()
+	self.lock.Lock()
+	self.running = true
+	self.lastRun = time.Now()
+	self.lock.Unlock()
+
+	defer func() {
+		self.lock.Lock()
+		self.running = false
+		self.lock.Unlock()
+	}()
+
+	log.Info("Scrubbing local shards")
+	now := time.Now()
+	shards := make([]*ShardData, 0)
+	for _, shard := range self.clusterConfig.GetAllShards() {
+		// only scrub cold shards, the ones that are still getting
+		// written to will be scrubbed once they're done
+		if !shard.IsLocal || shard.EndTime().After(now) || self.store.IsQuarantined(shard.Id()) {
+			continue
+		}
+		shards = append(shards, shard)
+	}
+
+	for i, shard := range shards {
+		self.scrubShard(shard)
+		job.SetProgress(i+1, len(shards))
+		select {
+		case <-job.Cancelled():
+			log.Info("Scrub cancelled after %d shards", i+1)
+			job.Finish(nil)
+			return
+		case <-time.After(self.pause):
+		}
+	}
+	log.Info("Done scrubbing local shards")
+	job.Finish(nil)
+}
+
+func (self *ShardScrubber) scrubShard(shard *ShardData) {
+	start := time.Now()
+	err := self.store.VerifyShard(shard.Id())
+	result := &ScrubResult{
+		ShardId:    shard.Id(),
+		ScrubbedAt: start,
+		Duration:   time.Now().Sub(start),
+		Corrupt:    self.store.IsQuarantined(shard.Id()),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		log.Error("Scrubber found a problem with shard %d: %s", shard.Id(), err)
+	}
+
+	self.lock.Lock()
+	self.results[shard.Id()] = result
+	self.shardsScrubbed++
+	if result.Corrupt {
+		self.corruptShards++
+	}
+	self.lock.Unlock()
+
+	if result.Corrupt {
+		go self.clusterConfig.RepairShard(shard)
+	}
+}
@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"sort"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// The load of a shard, as it's seen by one server or, once merged, by
+// the whole cluster
+type ShardStats struct {
+	Id        uint32    `json:"id"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	ServerIds []uint32  `json:"serverIds"`
+	// the points written and their rate per second over the last
+	// minute
+	PointsWritten int64   `json:"pointsWritten"`
+	WriteRate     float64 `json:"writeRate"`
+	// the queries that ran against the shard and their rate per second
+	// over the last minute
+	Queries   int64   `json:"queries"`
+	QueryRate float64 `json:"queryRate"`
+	// the size on disk and the number of series of the largest copy of
+	// the shard
+	Size        int64 `json:"size"`
+	SeriesCount int   `json:"seriesCount"`
+}
+
+func (self *ShardData) Stats() *ShardStats {
+	stats := &ShardStats{
+		Id:            self.id,
+		StartTime:     self.startTime,
+		EndTime:       self.endTime,
+		ServerIds:     self.ServerIds(),
+		PointsWritten: self.writes.Count(),
+		WriteRate:     self.writes.Rate(),
+		Queries:       self.queries.Count(),
+		QueryRate:     self.queries.Rate(),
+	}
+	if self.IsLocal && self.store != nil {
+		size, seriesCount, err := self.store.ShardStats(self.id)
+		if err != nil {
+			log.Warn("Cannot get the size of shard %d: %s", self.id, err)
+		}
+		stats.Size = size
+		stats.SeriesCount = seriesCount
+	}
+	return stats
+}
+
+// Returns the stats of all the shards as they're seen by this server.
+// The writes are counted on the server that received them and the
+// queries on the servers that ran them, so every server has to be
+// asked to get the load of the cluster
+func (self *ClusterConfiguration) LocalShardStats() []*ShardStats {
+	shards := self.GetAllShards()
+	stats := make([]*ShardStats, 0, len(shards))
+	for _, shard := range shards {
+		stats = append(stats, shard.Stats())
+	}
+	return stats
+}
+
+// Merges the stats that the servers returned for the same shards,
+// the hottest shards, i.e. the ones with the highest write rate, come
+// first
+func MergeShardStats(serversStats ...[]*ShardStats) []*ShardStats {
+	merged := map[uint32]*ShardStats{}
+	for _, serverStats := range serversStats {
+		for _, s := range serverStats {
+			m, ok := merged[s.Id]
+			if !ok {
+				shardStats := *s
+				merged[s.Id] = &shardStats
+				continue
+			}
+			m.PointsWritten += s.PointsWritten
+			m.WriteRate += s.WriteRate
+			m.Queries += s.Queries
+			m.QueryRate += s.QueryRate
+			if s.Size > m.Size {
+				m.Size = s.Size
+			}
+			if s.SeriesCount > m.SeriesCount {
+				m.SeriesCount = s.SeriesCount
+			}
+		}
+	}
+
+	result := make(ShardStatsByLoad, 0, len(merged))
+	for _, s := range merged {
+		result = append(result, s)
+	}
+	sort.Sort(result)
+	return result
+}
+
+type ShardStatsByLoad []*ShardStats
+
+func (self ShardStatsByLoad) Len() int      { return len(self) }
+func (self ShardStatsByLoad) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self ShardStatsByLoad) Less(i, j int) bool {
+	if self[i].WriteRate != self[j].WriteRate {
+		return self[i].WriteRate > self[j].WriteRate
+	}
+	if self[i].QueryRate != self[j].QueryRate {
+		return self[i].QueryRate > self[j].QueryRate
+	}
+	return self[i].Id < self[j].Id
+}
@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type ShardStatsSuite struct{}
+
+var _ = Suite(&ShardStatsSuite{})
+
+func (self *ShardStatsSuite) TestMergeShardStats(c *C) {
+	server1 := []*ShardStats{
+		{Id: 1, PointsWritten: 100, WriteRate: 1, Queries: 2, QueryRate: 0.5, Size: 1000, SeriesCount: 10},
+		{Id: 2, PointsWritten: 50, WriteRate: 2},
+	}
+	server2 := []*ShardStats{
+		{Id: 1, PointsWritten: 200, WriteRate: 2, Queries: 1, QueryRate: 0.25, Size: 1200, SeriesCount: 9},
+		{Id: 2, PointsWritten: 50, WriteRate: 0.5, Size: 10, SeriesCount: 1},
+		{Id: 3},
+	}
+	merged := MergeShardStats(server1, server2)
+	c.Assert(merged, HasLen, 3)
+
+	// the hottest shards come first
+	c.Assert(merged[0].Id, Equals, uint32(1))
+	c.Assert(merged[0].PointsWritten, Equals, int64(300))
+	c.Assert(merged[0].WriteRate, Equals, 3.0)
+	c.Assert(merged[0].Queries, Equals, int64(3))
+	c.Assert(merged[0].QueryRate, Equals, 0.75)
+	// the size of the largest copy
+	c.Assert(merged[0].Size, Equals, int64(1200))
+	c.Assert(merged[0].SeriesCount, Equals, 10)
+
+	c.Assert(merged[1].Id, Equals, uint32(2))
+	c.Assert(merged[1].WriteRate, Equals, 2.5)
+	c.Assert(merged[1].Size, Equals, int64(10))
+	c.Assert(merged[2].Id, Equals, uint32(3))
+
+	// the stats of the servers aren't modified
+	c.Assert(server1[0].PointsWritten, Equals, int64(100))
+}
@@ -0,0 +1,193 @@
+package cluster
+
+import (
+	"common"
+	"parser"
+	"protocol"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+	. "launchpad.net/gocheck"
+)
+
+type ShardSuite struct{}
+
+var _ = Suite(&ShardSuite{})
+
+type mockShardDb struct {
+	writes   []*protocol.Series
+	queryErr error
+}
+
+func (self *mockShardDb) Write(database string, series *protocol.Series) error {
+	self.writes = append(self.writes, series)
+	return nil
+}
+
+func (self *mockShardDb) Query(*parser.QuerySpec, QueryProcessor) error { return self.queryErr }
+func (self *mockShardDb) DropDatabase(database string) error            { return nil }
+func (self *mockShardDb) IsClosed() bool                                { return false }
+
+// Keeps a single shard and its replacement in memory
+type mockShardStore struct {
+	shard       *mockShardDb
+	replacement *mockShardDb
+	quarantined bool
+	aborted     bool
+	onDisk      bool
+}
+
+func (self *mockShardStore) Write(request *protocol.Request) error   { return nil }
+func (self *mockShardStore) SetWriteBuffer(writeBuffer *WriteBuffer) {}
+func (self *mockShardStore) BufferWrite(request *protocol.Request)   {}
+func (self *mockShardStore) GetOrCreateShard(id uint32) (LocalShardDb, error) {
+	return self.shard, nil
+}
+func (self *mockShardStore) ReturnShard(id uint32)                       {}
+func (self *mockShardStore) DeleteShard(shardId uint32) error            { return nil }
+func (self *mockShardStore) SetShardType(id uint32, shardType ShardType) {}
+func (self *mockShardStore) ShardStats(id uint32) (int64, int, error)    { return 0, 0, nil }
+func (self *mockShardStore) DatabaseSize(id uint32, db string) (int64, error) {
+	return 0, nil
+}
+func (self *mockShardStore) MountShard(id uint32, path string) error { return nil }
+func (self *mockShardStore) VerifyShard(id uint32) error             { return nil }
+func (self *mockShardStore) QuarantineShard(id uint32)               { self.quarantined = true }
+func (self *mockShardStore) UnquarantineShard(id uint32)             { self.quarantined = false }
+func (self *mockShardStore) IsQuarantined(id uint32) bool            { return self.quarantined }
+func (self *mockShardStore) HasShard(id uint32) bool                 { return self.onDisk }
+
+func (self *mockShardStore) StartShardRepair(id uint32) (LocalShardDb, error) {
+	self.replacement = &mockShardDb{}
+	return self.replacement, nil
+}
+
+func (self *mockShardStore) FinishShardRepair(id uint32) error {
+	self.shard, self.replacement = self.replacement, nil
+	return nil
+}
+
+func (self *mockShardStore) AbortShardRepair(id uint32) {
+	self.replacement = nil
+	self.aborted = true
+}
+
+// Answers the queries with the series, or with the error if it's set
+type mockReplicaConnection struct {
+	series   *protocol.Series
+	err      string
+	requests []*protocol.Request
+}
+
+func (self *mockReplicaConnection) Connect() {}
+
+func (self *mockReplicaConnection) MakeRequest(request *protocol.Request, responses chan *protocol.Response) error {
+	self.requests = append(self.requests, request)
+	if self.err != "" {
+		responses <- &protocol.Response{Type: &endStreamResponse, ErrorMessage: proto.String(self.err)}
+		return nil
+	}
+	responses <- &protocol.Response{Type: &queryResponse, Series: self.series}
+	responses <- &protocol.Response{Type: &endStreamResponse}
+	return nil
+}
+
+func newLocalTestShard(c *C, store LocalShardStore, connection ServerConnection) *ShardData {
+	shard := NewShard(1, time.Now().Add(-time.Hour), time.Now(), SHORT_TERM, false, nil)
+	shard.SetServers([]*ClusterServer{&ClusterServer{Id: 2, isUp: true, connection: connection}})
+	c.Assert(shard.SetLocalStore(store, 1), IsNil)
+	return shard
+}
+
+func newTestSeries(name string) *protocol.Series {
+	point := &protocol.Point{
+		Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(1)}},
+		SequenceNumber: proto.Uint64(1),
+	}
+	point.SetTimestampInMicroseconds(time.Now().UnixNano() / 1000)
+	return &protocol.Series{Name: proto.String(name), Fields: []string{"value"}, Points: []*protocol.Point{point}}
+}
+
+func (self *ShardSuite) TestCorruptShardIsQuarantinedAndRepairedWhenQueried(c *C) {
+	store := &mockShardStore{shard: &mockShardDb{queryErr: common.NewCorruptDataError("bad checksum")}}
+	shard := newLocalTestShard(c, store, &mockReplicaConnection{})
+	repaired := make(chan *ShardData, 1)
+	shard.repair = func(shard *ShardData) error {
+		repaired <- shard
+		return nil
+	}
+
+	queries, err := parser.ParseQuery("select * from foo")
+	c.Assert(err, IsNil)
+	responses := make(chan *protocol.Response, 10)
+	shard.Query(parser.NewQuerySpec(InternalClusterAdmin, "db1", queries[0]), responses)
+
+	c.Assert(store.quarantined, Equals, true)
+	select {
+	case s := <-repaired:
+		c.Assert(s, Equals, shard)
+	case <-time.After(time.Second):
+		c.Fatal("The corrupt shard wasn't repaired")
+	}
+}
+
+func (self *ShardSuite) TestRepairSwapsInACopyOfAReplica(c *C) {
+	corrupt := &mockShardDb{}
+	store := &mockShardStore{shard: corrupt}
+	connection := &mockReplicaConnection{series: newTestSeries("foo")}
+	shard := newLocalTestShard(c, store, connection)
+	store.quarantined = true
+
+	job := NewJobRegistry().Add("repair_shard", "1", false)
+	c.Assert(shard.Repair([]string{"db1"}, job), IsNil)
+
+	c.Assert(store.quarantined, Equals, false)
+	c.Assert(store.shard, Not(Equals), corrupt)
+	c.Assert(store.shard.writes, HasLen, 1)
+	c.Assert(store.shard.writes[0].GetName(), Equals, "foo")
+	// the replica is queried as the internal admin, not as one of the
+	// users
+	c.Assert(connection.requests, HasLen, 1)
+	c.Assert(connection.requests[0].GetUserName(), Equals, INTERNAL_USER_NAME)
+	c.Assert(connection.requests[0].GetIsDbUser(), Equals, false)
+}
+
+func (self *ShardSuite) TestFailedRepairKeepsTheShardQuarantined(c *C) {
+	corrupt := &mockShardDb{}
+	store := &mockShardStore{shard: corrupt}
+	shard := newLocalTestShard(c, store, &mockReplicaConnection{err: "server is shutting down"})
+	store.quarantined = true
+
+	job := NewJobRegistry().Add("repair_shard", "1", false)
+	c.Assert(shard.Repair([]string{"db1"}, job), NotNil)
+
+	c.Assert(store.aborted, Equals, true)
+	c.Assert(store.quarantined, Equals, true)
+	c.Assert(store.shard, Equals, corrupt)
+}
+
+func (self *ShardSuite) TestHealthyShardIsntRepaired(c *C) {
+	store := &mockShardStore{shard: &mockShardDb{}}
+	connection := &mockReplicaConnection{series: newTestSeries("foo")}
+	shard := newLocalTestShard(c, store, connection)
+
+	job := NewJobRegistry().Add("repair_shard", "1", false)
+	c.Assert(shard.Repair([]string{"db1"}, job), IsNil)
+	c.Assert(connection.requests, HasLen, 0)
+	c.Assert(store.replacement, IsNil)
+}
+
+func (self *ShardSuite) TestMountedShardIsntRepaired(c *C) {
+	store := &mockShardStore{shard: &mockShardDb{}}
+	connection := &mockReplicaConnection{series: newTestSeries("foo")}
+	shard := NewShard(1, time.Now().Add(-time.Hour), time.Now(), SHORT_TERM, false, nil)
+	shard.SetReadOnly("/tmp/archive/00001")
+	shard.SetServers([]*ClusterServer{&ClusterServer{Id: 2, isUp: true, connection: connection}})
+	c.Assert(shard.SetLocalStore(store, 1), IsNil)
+	store.quarantined = true
+
+	job := NewJobRegistry().Add("repair_shard", "1", false)
+	c.Assert(shard.Repair([]string{"db1"}, job), NotNil)
+	c.Assert(connection.requests, HasLen, 0)
+	c.Assert(store.replacement, IsNil)
+}
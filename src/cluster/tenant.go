@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+
+	log "code.google.com/p/log4go"
+)
+
+// A tenant owns a set of databases, e.g. the databases of a customer of a
+// hosting provider, and limits them together. The users of a tenant are
+// the db users of its databases, the databases that they create belong
+// to the tenant too. The limits are across all the databases of the
+// tenant, 0 means unlimited.
+type Tenant struct {
+	Name      string   `json:"name"`
+	Databases []string `json:"databases"`
+	MaxSeries int      `json:"maxSeries"`
+	// the size on disk of the points of the databases on all the servers,
+	// the copies of the replicas included
+	MaxDiskBytes int64 `json:"maxDiskBytes"`
+	// the points per second across the cluster, every server takes an
+	// even share of them so the writes should be spread over the servers
+	PointsPerSecond int    `json:"pointsPerSecond"`
+	CreatedBy       string `json:"createdBy"`
+	IsDeleted       bool   `json:"isDeleted"`
+}
+
+var validTenantName = regexp.MustCompile("^[a-zA-Z0-9_][a-zA-Z0-9\\._-]*$")
+
+func (self *Tenant) Validate() error {
+	if !validTenantName.MatchString(self.Name) {
+		return fmt.Errorf("%s isn't a valid tenant name", self.Name)
+	}
+	if self.MaxSeries < 0 || self.MaxDiskBytes < 0 || self.PointsPerSecond < 0 {
+		return fmt.Errorf("The limits of tenant %s can't be negative", self.Name)
+	}
+	databases := map[string]bool{}
+	for _, db := range self.Databases {
+		if databases[db] {
+			return fmt.Errorf("Tenant %s has database %s twice", self.Name, db)
+		}
+		databases[db] = true
+	}
+	return nil
+}
+
+func (self *Tenant) HasDatabase(db string) bool {
+	for _, name := range self.Databases {
+		if name == db {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *ClusterConfiguration) GetTenants() []*Tenant {
+	self.tenantsLock.RLock()
+	defer self.tenantsLock.RUnlock()
+	tenants := make([]*Tenant, 0, len(self.tenants))
+	for _, tenant := range self.tenants {
+		tenants = append(tenants, tenant)
+	}
+	return tenants
+}
+
+// Returns the tenant or nil if it doesn't exist
+func (self *ClusterConfiguration) GetTenant(name string) *Tenant {
+	self.tenantsLock.RLock()
+	defer self.tenantsLock.RUnlock()
+	return self.tenants[name]
+}
+
+// Returns the tenant that owns the database or nil if it doesn't belong
+// to one
+func (self *ClusterConfiguration) GetDatabaseTenant(db string) *Tenant {
+	self.tenantsLock.RLock()
+	defer self.tenantsLock.RUnlock()
+	for _, tenant := range self.tenants {
+		if tenant.HasDatabase(db) {
+			return tenant
+		}
+	}
+	return nil
+}
+
+// Creates or replaces the tenant. The tenants are replaced, not changed,
+// since the writes check their limits without the lock.
+func (self *ClusterConfiguration) SaveTenant(tenant *Tenant) {
+	self.tenantsLock.Lock()
+	defer self.tenantsLock.Unlock()
+	if tenant.IsDeleted {
+		delete(self.tenants, tenant.Name)
+		return
+	}
+	self.tenants[tenant.Name] = tenant
+}
+
+// Removes the database from its tenant, the lock must be held
+func (self *ClusterConfiguration) removeTenantDatabase(db string) {
+	for name, tenant := range self.tenants {
+		if !tenant.HasDatabase(db) {
+			continue
+		}
+		updated := *tenant
+		updated.Databases = nil
+		for _, database := range tenant.Databases {
+			if database != db {
+				updated.Databases = append(updated.Databases, database)
+			}
+		}
+		self.tenants[name] = &updated
+	}
+}
+
+// Returns the size on disk of the points of the database in the shards
+// of this server
+func (self *ClusterConfiguration) LocalDatabaseSize(db string) int64 {
+	if self.shardStore == nil {
+		return 0
+	}
+	var size int64
+	for _, shard := range self.GetAllShards() {
+		if !shard.IsLocal {
+			continue
+		}
+		shardSize, err := self.shardStore.DatabaseSize(shard.Id(), db)
+		if err != nil {
+			log.Warn("Cannot get the size of %s in shard %d: %s", db, shard.Id(), err)
+			continue
+		}
+		size += shardSize
+	}
+	return size
+}
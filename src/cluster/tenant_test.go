@@ -0,0 +1,43 @@
+package cluster
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type TenantSuite struct{}
+
+var _ = Suite(&TenantSuite{})
+
+func (self *TenantSuite) TestValidate(c *C) {
+	c.Assert((&Tenant{Name: "acme", Databases: []string{"db1", "db2"}, MaxSeries: 10}).Validate(), IsNil)
+	c.Assert((&Tenant{Name: "acme~1"}).Validate(), NotNil)
+	c.Assert((&Tenant{Name: "acme", MaxDiskBytes: -1}).Validate(), NotNil)
+	c.Assert((&Tenant{Name: "acme", Databases: []string{"db1", "db1"}}).Validate(), NotNil)
+}
+
+func (self *TenantSuite) TestSaveAndRecover(c *C) {
+	config := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(config.CreateDatabase("db1", 1), IsNil)
+	c.Assert(config.CreateDatabase("db2", 1), IsNil)
+	config.SaveTenant(&Tenant{Name: "acme", Databases: []string{"db1", "db2"}, MaxSeries: 10})
+	c.Assert(config.GetTenants(), HasLen, 1)
+	c.Assert(config.GetDatabaseTenant("db2").Name, Equals, "acme")
+	c.Assert(config.GetDatabaseTenant("db3"), IsNil)
+
+	data, err := config.Save()
+	c.Assert(err, IsNil)
+	recovered := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(recovered.Recovery(data), IsNil)
+	c.Assert(recovered.GetTenant("acme"), NotNil)
+	c.Assert(recovered.GetTenant("acme").MaxSeries, Equals, 10)
+
+	recovered.SaveTenant(&Tenant{Name: "acme", IsDeleted: true})
+	c.Assert(recovered.GetTenant("acme"), IsNil)
+	c.Assert(recovered.GetDatabaseTenant("db1"), IsNil)
+
+	// dropping a database removes it from its tenant
+	tenant := config.GetTenant("acme")
+	c.Assert(config.DropDatabase("db1"), IsNil)
+	c.Assert(config.GetTenant("acme").Databases, DeepEquals, []string{"db2"})
+	c.Assert(tenant.Databases, HasLen, 2)
+}
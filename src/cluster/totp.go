@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	TOTP_PERIOD         = 30 * time.Second
+	TOTP_DIGITS         = 6
+	RECOVERY_CODE_COUNT = 10
+	// codes of the previous and the next period are accepted too, for
+	// clocks that are a bit off
+	totpSkew = 1
+)
+
+// Returns a random base32 totp secret
+func GenerateTotpSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(secret), nil
+}
+
+// Returns the otpauth url of the secret that authenticator apps can
+// read from a qr code
+func TotpUrl(issuer, username, secret string) string {
+	label := url.QueryEscape(issuer) + ":" + url.QueryEscape(username)
+	return fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s&digits=%d&period=%d",
+		label, secret, url.QueryEscape(issuer), TOTP_DIGITS, int(TOTP_PERIOD/time.Second))
+}
+
+// Returns the rfc 6238 code of the secret at the given time
+func TotpCode(secret string, t time.Time) (string, error) {
+	key, err := base32.StdEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, uint64(t.Unix()/int64(TOTP_PERIOD/time.Second))), nil
+}
+
+func totpCode(key []byte, counter uint64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// Returns true if the code is valid for the secret at the given time
+func ValidateTotp(secret, code string, t time.Time) bool {
+	key, err := base32.StdEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != TOTP_DIGITS {
+		return false
+	}
+	counter := t.Unix() / int64(TOTP_PERIOD/time.Second)
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		expected := totpCode(key, uint64(counter+i))
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns new recovery codes and their hashes, only the hashes are
+// stored
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RECOVERY_CODE_COUNT)
+	hashes := make([]string, 0, RECOVERY_CODE_COUNT)
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		random := make([]byte, 5)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(random)
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// Returns true if the admin has to give a second factor
+func (self *ClusterAdmin) RequiresSecondFactor() bool {
+	return self.TotpSecret != ""
+}
+
+// Removes the recovery code from the unused ones, returns false if it
+// isn't one of them
+func (self *ClusterAdmin) UseRecoveryCode(code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, unused := range self.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(unused), []byte(hash)) == 1 {
+			codes := make([]string, 0, len(self.RecoveryCodes)-1)
+			codes = append(codes, self.RecoveryCodes[:i]...)
+			self.RecoveryCodes = append(codes, self.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
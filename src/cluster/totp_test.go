@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"encoding/base32"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type TotpSuite struct{}
+
+var _ = Suite(&TotpSuite{})
+
+func (self *TotpSuite) TestRfc6238Vectors(c *C) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := TotpCode(secret, time.Unix(unix, 0))
+		c.Assert(err, IsNil)
+		c.Assert(code, Equals, expected)
+	}
+}
+
+func (self *TotpSuite) TestValidation(c *C) {
+	secret, err := GenerateTotpSecret()
+	c.Assert(err, IsNil)
+	now := time.Now()
+	code, err := TotpCode(secret, now)
+	c.Assert(err, IsNil)
+	c.Assert(ValidateTotp(secret, code, now), Equals, true)
+	c.Assert(ValidateTotp(secret, code, now.Add(TOTP_PERIOD)), Equals, true)
+	c.Assert(ValidateTotp(secret, code, now.Add(5*TOTP_PERIOD)), Equals, false)
+	c.Assert(ValidateTotp(secret, "", now), Equals, false)
+}
+
+func (self *TotpSuite) TestRecoveryCodesCanOnlyBeUsedOnce(c *C) {
+	codes, hashes, err := GenerateRecoveryCodes()
+	c.Assert(err, IsNil)
+	c.Assert(codes, HasLen, RECOVERY_CODE_COUNT)
+	admin := &ClusterAdmin{CommonUser: CommonUser{Name: "root"}, RecoveryCodes: hashes}
+	c.Assert(admin.UseRecoveryCode(codes[3]), Equals, true)
+	c.Assert(admin.UseRecoveryCode(codes[3]), Equals, false)
+	c.Assert(admin.RecoveryCodes, HasLen, RECOVERY_CODE_COUNT-1)
+	c.Assert(hashes, HasLen, RECOVERY_CODE_COUNT)
+}
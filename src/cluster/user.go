@@ -3,14 +3,65 @@ package cluster
 import (
 	"code.google.com/p/go.crypto/bcrypt"
 	"common"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"github.com/influxdb/go-cache"
+	"net"
 	"regexp"
+	"time"
 )
 
+// Caches the passwords that were verified so bcrypt doesn't run on
+// every request. Only an hmac of the password is kept, the key of the
+// hmac is random and never leaves the process.
 var userCache *cache.Cache
+var userCacheKey []byte
+
+// The default time the verified passwords are cached for
+const DEFAULT_AUTH_CACHE_TTL = 15 * time.Minute
+
+type cachedPassword struct {
+	// the hash that the password was verified against, the entry is
+	// stale once the user has a different hash
+	hash   string
+	digest []byte
+}
+
+// The cost of the new password hashes, higher is slower but makes it
+// harder to brute force, since it will be really slow and impractical
+var passwordHashCost = bcrypt.DefaultCost
+
+// Sets the bcrypt cost of the password hashes, the hashes of the
+// existing users are replaced the next time they authenticate.
+func SetPasswordHashCost(cost int) error {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return fmt.Errorf("The password hash cost has to be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	passwordHashCost = cost
+	return nil
+}
 
 func init() {
-	userCache = cache.New(0, 0)
+	userCacheKey = make([]byte, 32)
+	if _, err := rand.Read(userCacheKey); err != nil {
+		panic(err)
+	}
+	SetAuthCacheTtl(DEFAULT_AUTH_CACHE_TTL)
+}
+
+// Sets how long the verified passwords are cached for, the passwords
+// that were already cached are dropped.
+func SetAuthCacheTtl(ttl time.Duration) {
+	userCache = cache.New(ttl, ttl)
+}
+
+func passwordDigest(password string) []byte {
+	mac := hmac.New(sha256.New, userCacheKey)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
 }
 
 type Matcher struct {
@@ -31,6 +82,12 @@ type CommonUser struct {
 	Hash          string `json:"hash"`
 	IsUserDeleted bool   `json:"is_deleted"`
 	CacheKey      string `json:"cache_key"`
+	// the networks in CIDR notation that the user can authenticate
+	// from, any network if it's empty
+	AllowedNetworks []string `json:"allowed_networks"`
+	// the user was accepted by the external authenticator, it isn't
+	// saved
+	IsExternal bool `json:"-"`
 }
 
 func (self *CommonUser) GetName() string {
@@ -47,18 +104,82 @@ func (self *CommonUser) ChangePassword(hash string) error {
 	return nil
 }
 
+// Only successful verifications are cached, wrong passwords always go
+// through bcrypt so guessing passwords stays slow
 func (self *CommonUser) isValidPwd(password string) bool {
-	if pwd, ok := userCache.Get(self.CacheKey); ok {
-		return password == pwd.(string)
+	digest := passwordDigest(password)
+	if cached, ok := userCache.Get(self.CacheKey); ok {
+		entry := cached.(*cachedPassword)
+		if entry.hash == self.Hash && hmac.Equal(entry.digest, digest) {
+			return true
+		}
 	}
 
 	isValid := bcrypt.CompareHashAndPassword([]byte(self.Hash), []byte(password)) == nil
 	if isValid {
-		userCache.Set(self.CacheKey, password, 0)
+		userCache.Set(self.CacheKey, &cachedPassword{self.Hash, digest}, 0)
 	}
 	return isValid
 }
 
+// Returns true if the user can authenticate from the ip
+func (self *CommonUser) IsAllowedFrom(ip net.IP) bool {
+	if len(self.AllowedNetworks) == 0 {
+		return true
+	}
+	for _, network := range self.AllowedNetworks {
+		if _, ipNet, err := net.ParseCIDR(network); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the networks in CIDR notation, single addresses are turned
+// into networks that only contain them
+func ParseAllowedNetworks(networks []string) ([]string, error) {
+	parsed := make([]string, 0, len(networks))
+	for _, network := range networks {
+		if ip := net.ParseIP(network); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			parsed = append(parsed, fmt.Sprintf("%s/%d", ip, bits))
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid network %s, it has to be an ip address or in CIDR notation", network)
+		}
+		parsed = append(parsed, ipNet.String())
+	}
+	return parsed, nil
+}
+
+// Returns a fingerprint of the password hash, it changes whenever the
+// password changes but doesn't reveal anything about the hash
+func (self *CommonUser) PasswordFingerprint() string {
+	sum := sha256.Sum256([]byte(self.Hash))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Returns a fingerprint of the credentials the user authenticates
+// with, the tokens issued to the user are revoked when it changes
+func (self *CommonUser) CredentialsFingerprint() string {
+	return self.PasswordFingerprint()
+}
+
+// Returns true if the password is valid and the hash of the user
+// wasn't created with the current algorithm and cost
+func (self *CommonUser) NeedsRehash(password string) bool {
+	cost, err := bcrypt.Cost([]byte(self.Hash))
+	if err == nil && cost == passwordHashCost {
+		return false
+	}
+	return self.isValidPwd(password)
+}
+
 func (self *CommonUser) IsClusterAdmin() bool {
 	return false
 }
@@ -81,12 +202,25 @@ func (self *CommonUser) HasReadAccess(name string) bool {
 
 type ClusterAdmin struct {
 	CommonUser `json:"common"`
+	// the totp secret of the second factor, empty if it isn't enabled
+	TotpSecret string `json:"totp_secret"`
+	// the secret that is enabled once the admin confirms a code
+	PendingTotpSecret string `json:"pending_totp_secret"`
+	// sha256 hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 func (self *ClusterAdmin) IsClusterAdmin() bool {
 	return true
 }
 
+// Same as CommonUser.CredentialsFingerprint, it changes when the second
+// factor is enabled or disabled too
+func (self *ClusterAdmin) CredentialsFingerprint() string {
+	sum := sha256.Sum256([]byte(self.Hash + "\x00" + self.TotpSecret))
+	return hex.EncodeToString(sum[:16])
+}
+
 func (self *ClusterAdmin) HasWriteAccess(_ string) bool {
 	return true
 }
@@ -95,35 +229,81 @@ func (self *ClusterAdmin) HasReadAccess(_ string) bool {
 	return true
 }
 
+// The name of the internal cluster admin, it isn't a valid user name so
+// it can't be taken by a user
+const INTERNAL_USER_NAME = "influxdb:internal"
+
+// The cluster admin that the servers run their own operations as, e.g.
+// the repairs of the shards. It isn't saved, it doesn't have a password
+// and it can't log in.
+var InternalClusterAdmin = &ClusterAdmin{CommonUser: CommonUser{Name: INTERNAL_USER_NAME, CacheKey: INTERNAL_USER_NAME}}
+
 type DbUser struct {
 	CommonUser `json:"common"`
 	Db         string     `json:"db"`
 	WriteTo    []*Matcher `json:"write_matchers"`
 	ReadFrom   []*Matcher `json:"read_matchers"`
 	IsAdmin    bool       `json:"is_admin"`
+	Roles      []string   `json:"roles"`
+	// the user can create databases and becomes a db admin of the
+	// databases it creates
+	CanCreateDatabases bool `json:"can_create_databases"`
+	// looks up the roles of the user, it's set by the cluster
+	// configuration when the user is saved
+	roleLookup func(name string) *Role
+}
+
+// Returns the permissions that the roles of the user give on its
+// database
+func (self *DbUser) rolePermissions() []*RolePermission {
+	if self.roleLookup == nil {
+		return nil
+	}
+	var permissions []*RolePermission
+	for _, name := range self.Roles {
+		if permission := self.roleLookup(name).permission(self.Db); permission != nil {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
 }
 
 func (self *DbUser) IsDbAdmin(db string) bool {
-	return self.IsAdmin && self.Db == db
+	if self.Db != db {
+		return false
+	}
+	if self.IsAdmin {
+		return true
+	}
+	for _, permission := range self.rolePermissions() {
+		if permission.IsAdmin {
+			return true
+		}
+	}
+	return false
 }
 
 func (self *DbUser) HasWriteAccess(name string) bool {
-	for _, matcher := range self.WriteTo {
-		if matcher.Matches(name) {
+	if matchesAny(self.WriteTo, name) {
+		return true
+	}
+	for _, permission := range self.rolePermissions() {
+		if permission.hasWriteAccess(name) {
 			return true
 		}
 	}
-
 	return false
 }
 
 func (self *DbUser) HasReadAccess(name string) bool {
-	for _, matcher := range self.ReadFrom {
-		if matcher.Matches(name) {
+	if matchesAny(self.ReadFrom, name) {
+		return true
+	}
+	for _, permission := range self.rolePermissions() {
+		if permission.hasReadAccess(name) {
 			return true
 		}
 	}
-
 	return false
 }
 
@@ -136,7 +316,5 @@ func HashPassword(password string) ([]byte, error) {
 		return nil, common.NewQueryError(common.InvalidArgument, "Password must be more than 4 and less than 56 characters")
 	}
 
-	// The second arg is the cost of the hashing, higher is slower but makes it harder
-	// to brute force, since it will be really slow and impractical
-	return bcrypt.GenerateFromPassword([]byte(password), 10)
+	return bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
 }
@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"common"
+	"net"
 	"testing"
 	. "launchpad.net/gocheck"
 )
@@ -18,13 +19,13 @@ func Test(t *testing.T) {
 }
 
 func (self *UserSuite) SetUpSuite(c *C) {
-	user := &ClusterAdmin{CommonUser{"root", "", false, "root"}}
+	user := &ClusterAdmin{CommonUser: CommonUser{Name: "root", CacheKey: "root"}}
 	c.Assert(user.ChangePassword("password"), IsNil)
 	root = user
 }
 
 func (self *UserSuite) TestProperties(c *C) {
-	u := ClusterAdmin{CommonUser{Name: "root"}}
+	u := ClusterAdmin{CommonUser: CommonUser{Name: "root"}}
 	c.Assert(u.IsClusterAdmin(), Equals, true)
 	c.Assert(u.GetName(), Equals, "root")
 	hash, err := HashPassword("foobar")
@@ -33,7 +34,7 @@ func (self *UserSuite) TestProperties(c *C) {
 	c.Assert(u.isValidPwd("foobar"), Equals, true)
 	c.Assert(u.isValidPwd("password"), Equals, false)
 
-	dbUser := DbUser{CommonUser{Name: "db_user"}, "db", nil, nil, true}
+	dbUser := DbUser{CommonUser: CommonUser{Name: "db_user"}, Db: "db", IsAdmin: true}
 	c.Assert(dbUser.IsClusterAdmin(), Equals, false)
 	c.Assert(dbUser.IsDbAdmin("db"), Equals, true)
 	c.Assert(dbUser.GetName(), Equals, "db_user")
@@ -43,3 +44,74 @@ func (self *UserSuite) TestProperties(c *C) {
 	c.Assert(dbUser.isValidPwd("password"), Equals, true)
 	c.Assert(dbUser.isValidPwd("password1"), Equals, false)
 }
+
+func (self *UserSuite) TestPasswordCacheIsKeyedOnTheHash(c *C) {
+	hash, err := HashPassword("password")
+	c.Assert(err, IsNil)
+	u := &ClusterAdmin{CommonUser: CommonUser{Name: "cached", Hash: string(hash), CacheKey: "cached"}}
+	c.Assert(u.isValidPwd("password"), Equals, true)
+	c.Assert(u.isValidPwd("wrong"), Equals, false)
+	c.Assert(u.isValidPwd("password"), Equals, true)
+
+	// the user that another server saved through raft replaces the
+	// cached one without calling ChangePassword
+	hash, err = HashPassword("changed")
+	c.Assert(err, IsNil)
+	u = &ClusterAdmin{CommonUser: CommonUser{Name: "cached", Hash: string(hash), CacheKey: "cached"}}
+	c.Assert(u.isValidPwd("password"), Equals, false)
+	c.Assert(u.isValidPwd("changed"), Equals, true)
+}
+
+func (self *UserSuite) TestAllowedNetworks(c *C) {
+	networks, err := ParseAllowedNetworks([]string{"10.1.2.3/16", "192.168.1.1", "::1"})
+	c.Assert(err, IsNil)
+	c.Assert(networks, DeepEquals, []string{"10.1.0.0/16", "192.168.1.1/32", "::1/128"})
+	_, err = ParseAllowedNetworks([]string{"10.0.0.0/33"})
+	c.Assert(err, NotNil)
+
+	u := &DbUser{CommonUser: CommonUser{Name: "agent", AllowedNetworks: networks}, Db: "db1"}
+	c.Assert(u.IsAllowedFrom(net.ParseIP("10.1.200.1")), Equals, true)
+	c.Assert(u.IsAllowedFrom(net.ParseIP("192.168.1.1")), Equals, true)
+	c.Assert(u.IsAllowedFrom(net.ParseIP("192.168.1.2")), Equals, false)
+	c.Assert(u.IsAllowedFrom(net.ParseIP("::1")), Equals, true)
+
+	u.AllowedNetworks = nil
+	c.Assert(u.IsAllowedFrom(net.ParseIP("192.168.1.2")), Equals, true)
+}
+
+func (self *UserSuite) TestNeedsRehash(c *C) {
+	defer SetPasswordHashCost(10)
+
+	u := ClusterAdmin{CommonUser: CommonUser{Name: "rehashed", CacheKey: "rehashed"}}
+	hash, err := HashPassword("password")
+	c.Assert(err, IsNil)
+	c.Assert(u.ChangePassword(string(hash)), IsNil)
+	c.Assert(u.NeedsRehash("password"), Equals, false)
+
+	c.Assert(SetPasswordHashCost(3), NotNil)
+	c.Assert(SetPasswordHashCost(5), IsNil)
+	c.Assert(u.NeedsRehash("password"), Equals, true)
+	// only valid passwords are rehashed
+	c.Assert(u.NeedsRehash("wrong"), Equals, false)
+
+	hash, err = HashPassword("password")
+	c.Assert(err, IsNil)
+	c.Assert(u.ChangePassword(string(hash)), IsNil)
+	c.Assert(u.NeedsRehash("password"), Equals, false)
+}
+
+func (self *UserSuite) TestCredentialsFingerprint(c *C) {
+	u := &ClusterAdmin{CommonUser: CommonUser{Name: "fingerprinted", Hash: "hash"}}
+	fingerprint := u.CredentialsFingerprint()
+	c.Assert(fingerprint, Equals, (&ClusterAdmin{CommonUser: CommonUser{Name: "fingerprinted", Hash: "hash"}}).CredentialsFingerprint())
+
+	// enabling the second factor changes the fingerprint
+	u.TotpSecret = "secret"
+	c.Assert(u.CredentialsFingerprint(), Not(Equals), fingerprint)
+	u.TotpSecret = ""
+	u.Hash = "changed"
+	c.Assert(u.CredentialsFingerprint(), Not(Equals), fingerprint)
+
+	dbUser := &DbUser{CommonUser: CommonUser{Name: "db_user", Hash: "hash"}}
+	c.Assert(dbUser.CredentialsFingerprint(), Equals, dbUser.PasswordFingerprint())
+}
@@ -3,6 +3,7 @@ package cluster
 import (
 	"protocol"
 	"reflect"
+	"sync"
 	"time"
 
 	log "code.google.com/p/log4go"
@@ -17,6 +18,7 @@ type WriteBuffer struct {
 	stoppedWrites              chan uint32
 	bufferSize                 int
 	shardIds                   map[uint32]bool
+	requestNumbersLock         sync.Mutex
 	shardLastRequestNumber     map[uint32]uint32
 	shardCommitedRequestNumber map[uint32]uint32
 	writerInfo                 string
@@ -49,13 +51,33 @@ func (self *WriteBuffer) ShardsRequestNumber() map[uint32]uint32 {
 }
 
 func (self *WriteBuffer) HasUncommitedWrites() bool {
+	self.requestNumbersLock.Lock()
+	defer self.requestNumbersLock.Unlock()
 	return !reflect.DeepEqual(self.shardCommitedRequestNumber, self.shardLastRequestNumber)
 }
 
+// Returns how many requests the writer is behind in the shard that's
+// the furthest behind, i.e. the requests that were buffered but not
+// written yet
+func (self *WriteBuffer) Lag() uint32 {
+	self.requestNumbersLock.Lock()
+	defer self.requestNumbersLock.Unlock()
+	var lag uint32
+	for shardId, last := range self.shardLastRequestNumber {
+		committed := self.shardCommitedRequestNumber[shardId]
+		if last > committed && last-committed > lag {
+			lag = last - committed
+		}
+	}
+	return lag
+}
+
 // This method never blocks. It'll buffer writes until they fill the buffer then drop the on the
 // floor and let the background goroutine replay from the WAL
 func (self *WriteBuffer) Write(request *protocol.Request) {
+	self.requestNumbersLock.Lock()
 	self.shardLastRequestNumber[request.GetShardId()] = request.GetRequestNumber()
+	self.requestNumbersLock.Unlock()
 	select {
 	case self.writes <- request:
 		return
@@ -87,7 +109,9 @@ func (self *WriteBuffer) write(request *protocol.Request) {
 		requestNumber := *request.RequestNumber
 		err := self.writer.Write(request)
 		if err == nil {
+			self.requestNumbersLock.Lock()
 			self.shardCommitedRequestNumber[request.GetShardId()] = request.GetRequestNumber()
+			self.requestNumbersLock.Unlock()
 			self.wal.Commit(requestNumber, self.serverId)
 			return
 		}
@@ -0,0 +1,43 @@
+package cluster
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// A write subscription forwards a copy of the writes to a database to
+// external endpoints. Destinations are http(s) urls that the writes are
+// posted to like they're posted to /db/:db/series, or udp://host:port
+// to send every write as a json datagram.
+type WriteSubscription struct {
+	Name         string   `json:"name"`
+	Database     string   `json:"database"`
+	Destinations []string `json:"destinations"`
+	CreatedBy    string   `json:"created_by"`
+	CreatedAt    int64    `json:"created_at"`
+	IsDeleted    bool     `json:"is_deleted"`
+}
+
+func (self *WriteSubscription) Validate() error {
+	if self.Name == "" {
+		return fmt.Errorf("Subscription name cannot be empty")
+	}
+	if len(self.Destinations) == 0 {
+		return fmt.Errorf("Subscription %s needs at least one destination", self.Name)
+	}
+	for _, destination := range self.Destinations {
+		u, err := url.Parse(destination)
+		if err != nil {
+			return fmt.Errorf("Invalid destination %s: %s", destination, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "udp":
+		default:
+			return fmt.Errorf("Invalid destination %s, the scheme has to be http, https or udp", destination)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("Invalid destination %s, the host is missing", destination)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type WriteSubscriptionSuite struct{}
+
+var _ = Suite(&WriteSubscriptionSuite{})
+
+func (self *WriteSubscriptionSuite) TestValidate(c *C) {
+	subscription := &WriteSubscription{Name: "stream", Database: "db1", Destinations: []string{"http://localhost:8086/db/copy/series", "udp://localhost:4444"}}
+	c.Assert(subscription.Validate(), IsNil)
+
+	for _, destinations := range [][]string{
+		nil,
+		[]string{"ftp://localhost"},
+		[]string{"udp://"},
+		[]string{"localhost:4444"},
+	} {
+		subscription := &WriteSubscription{Name: "stream", Database: "db1", Destinations: destinations}
+		c.Assert(subscription.Validate(), NotNil)
+	}
+	c.Assert((&WriteSubscription{Database: "db1", Destinations: []string{"udp://localhost:4444"}}).Validate(), NotNil)
+}
+
+func (self *WriteSubscriptionSuite) TestSaveAndRecover(c *C) {
+	config := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(config.CreateDatabase("db1", 1), IsNil)
+	config.SaveWriteSubscription(&WriteSubscription{Name: "a", Database: "db1", Destinations: []string{"udp://localhost:4444"}})
+	config.SaveWriteSubscription(&WriteSubscription{Name: "b", Database: "db2", Destinations: []string{"udp://localhost:4444"}})
+	c.Assert(config.GetWriteSubscriptions(), HasLen, 2)
+	c.Assert(config.GetDatabaseWriteSubscriptions("db1"), HasLen, 1)
+
+	data, err := config.Save()
+	c.Assert(err, IsNil)
+	recovered := NewClusterConfiguration(nil, nil, nil, nil)
+	c.Assert(recovered.Recovery(data), IsNil)
+	c.Assert(recovered.GetWriteSubscription("a"), NotNil)
+	c.Assert(recovered.GetWriteSubscription("a").Database, Equals, "db1")
+
+	// dropping the database drops its subscriptions
+	c.Assert(config.DropDatabase("db1"), IsNil)
+	c.Assert(config.GetWriteSubscription("a"), IsNil)
+
+	config.SaveWriteSubscription(&WriteSubscription{Name: "b", IsDeleted: true})
+	c.Assert(config.GetWriteSubscriptions(), HasLen, 0)
+}
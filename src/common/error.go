@@ -42,3 +42,49 @@ func (self AuthorizationError) Error() string {
 func NewAuthorizationError(formatStr string, args ...interface{}) AuthorizationError {
 	return AuthorizationError(fmt.Sprintf(formatStr, args...))
 }
+
+type CorruptDataError string
+
+func (self CorruptDataError) Error() string {
+	return string(self)
+}
+
+func NewCorruptDataError(formatStr string, args ...interface{}) CorruptDataError {
+	return CorruptDataError(fmt.Sprintf(formatStr, args...))
+}
+
+// A write that will never succeed, e.g. because its points are
+// malformed. It isn't retried, the points are moved to the dead letters
+// of the database instead.
+type InvalidWriteError string
+
+func (self InvalidWriteError) Error() string {
+	return string(self)
+}
+
+func NewInvalidWriteError(formatStr string, args ...interface{}) InvalidWriteError {
+	return InvalidWriteError(fmt.Sprintf(formatStr, args...))
+}
+
+// A write that would take a database over its quota
+type QuotaExceededError string
+
+func (self QuotaExceededError) Error() string {
+	return string(self)
+}
+
+func NewQuotaExceededError(formatStr string, args ...interface{}) QuotaExceededError {
+	return QuotaExceededError(fmt.Sprintf(formatStr, args...))
+}
+
+// A write that would take a tenant over the points per second it can
+// write
+type WriteRateExceededError string
+
+func (self WriteRateExceededError) Error() string {
+	return string(self)
+}
+
+func NewWriteRateExceededError(formatStr string, args ...interface{}) WriteRateExceededError {
+	return WriteRateExceededError(fmt.Sprintf(formatStr, args...))
+}
@@ -67,5 +67,5 @@ func StringToSeriesArray(seriesString string, args ...interface{}) ([]*protocol.
 }
 
 func CurrentTime() int64 {
-	return time.Now().UnixNano() / int64(1000)
+	return Now().UnixNano() / int64(1000)
 }
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"sync"
 	"time"
 )
 
@@ -9,3 +10,29 @@ type EnhancedTime time.Time
 func TimeToMicroseconds(t time.Time) int64 {
 	return t.Unix()*int64(time.Second/time.Microsecond) + int64(t.Nanosecond())/int64(time.Microsecond)
 }
+
+var (
+	clockLock sync.RWMutex
+	clock     = time.Now
+)
+
+// Returns the time of the server, it's used for the points that are
+// written without a time, now() and the end time of the queries, the
+// continuous queries and the creation of the shards. It's the time of
+// the system unless a test replaced the clock with SetClock.
+func Now() time.Time {
+	clockLock.RLock()
+	defer clockLock.RUnlock()
+	return clock()
+}
+
+// Replaces the clock returned by Now, e.g. to control the time of the
+// servers of a test cluster. nil restores the clock of the system.
+func SetClock(now func() time.Time) {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	if now == nil {
+		now = time.Now
+	}
+	clock = now
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"protocol"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A stage of a query, e.g. parsing it or scanning a shard
+type TraceSpan struct {
+	Name     string
+	ServerId uint32
+	// zero for the stages that aren't about a shard
+	ShardId  uint32
+	Start    time.Time
+	Duration time.Duration
+}
+
+// The timings of the stages of a query across the servers that ran
+// it. The methods of a nil trace do nothing, so the code can time the
+// stages without checking if the query is traced
+type Trace struct {
+	lock  sync.Mutex
+	spans []*TraceSpan
+}
+
+func NewTrace() *Trace {
+	return &Trace{}
+}
+
+func (self *Trace) Add(spans ...*TraceSpan) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.spans = append(self.spans, spans...)
+}
+
+// Records the stage that started at start and ends now
+func (self *Trace) Time(name string, serverId, shardId uint32, start time.Time) {
+	self.Add(&TraceSpan{name, serverId, shardId, start, time.Since(start)})
+}
+
+// Returns the spans sorted by their start
+func (self *Trace) Spans() []*TraceSpan {
+	if self == nil {
+		return nil
+	}
+	self.lock.Lock()
+	spans := make([]*TraceSpan, len(self.spans))
+	copy(spans, self.spans)
+	self.lock.Unlock()
+	sort.Sort(spansByStart(spans))
+	return spans
+}
+
+type spansByStart []*TraceSpan
+
+func (self spansByStart) Len() int           { return len(self) }
+func (self spansByStart) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+func (self spansByStart) Less(i, j int) bool { return self[i].Start.Before(self[j].Start) }
+
+func (self *Trace) ToProtobuf() []*protocol.TraceSpan {
+	spans := self.Spans()
+	result := make([]*protocol.TraceSpan, 0, len(spans))
+	for _, span := range spans {
+		serverId, shardId := span.ServerId, span.ShardId
+		start := TimeToMicroseconds(span.Start)
+		duration := int64(span.Duration / time.Microsecond)
+		result = append(result, &protocol.TraceSpan{
+			Name:     protocol.String(span.Name),
+			ServerId: &serverId,
+			ShardId:  &shardId,
+			Start:    &start,
+			Duration: &duration,
+		})
+	}
+	return result
+}
+
+func TraceSpansFromProtobuf(spans []*protocol.TraceSpan) []*TraceSpan {
+	result := make([]*TraceSpan, 0, len(spans))
+	for _, span := range spans {
+		start := span.GetStart()
+		result = append(result, &TraceSpan{
+			Name:     span.GetName(),
+			ServerId: span.GetServerId(),
+			ShardId:  span.GetShardId(),
+			Start:    time.Unix(start/1e6, (start%1e6)*1e3),
+			Duration: time.Duration(span.GetDuration()) * time.Microsecond,
+		})
+	}
+	return result
+}
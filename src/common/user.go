@@ -9,3 +9,66 @@ type User interface {
 	HasWriteAccess(name string) bool
 	HasReadAccess(name string) bool
 }
+
+// A user that's making an api request. The id of the request is sent
+// along with the requests to the other servers and logged by them, so
+// the logs of a request can be found across the cluster.
+type requestUser struct {
+	User
+	requestId string
+	trace     *Trace
+}
+
+// Returns a copy of the request of the user or a new one
+func newRequestUser(user User) *requestUser {
+	if u, ok := user.(*requestUser); ok {
+		request := *u
+		return &request
+	}
+	return &requestUser{User: user}
+}
+
+func UserWithRequestId(user User, requestId string) User {
+	if user == nil || requestId == "" {
+		return user
+	}
+	request := newRequestUser(user)
+	request.requestId = requestId
+	return request
+}
+
+// Returns the id of the api request the user is making or an empty
+// string
+// Returns the user that UserWithRequestId or UserWithTrace wrapped
+func UnwrapUser(user User) User {
+	if u, ok := user.(*requestUser); ok {
+		return u.User
+	}
+	return user
+}
+
+func RequestId(user User) string {
+	if u, ok := user.(*requestUser); ok {
+		return u.requestId
+	}
+	return ""
+}
+
+// The stages of the queries of the user get timed in the trace
+func UserWithTrace(user User, trace *Trace) User {
+	if user == nil || trace == nil {
+		return user
+	}
+	request := newRequestUser(user)
+	request.trace = trace
+	return request
+}
+
+// Returns the trace of the request the user is making or nil if the
+// request isn't traced
+func GetTrace(user User) *Trace {
+	if u, ok := user.(*requestUser); ok {
+		return u.trace
+	}
+	return nil
+}
@@ -60,14 +60,115 @@ type AdminConfig struct {
 	Assets string
 }
 
+type CorsConfig struct {
+	AllowedOrigins   []string `toml:"allowed-origins"`
+	AllowedMethods   []string `toml:"allowed-methods"`
+	AllowedHeaders   []string `toml:"allowed-headers"`
+	AllowCredentials bool     `toml:"allow-credentials"`
+	MaxAge           duration `toml:"max-age"`
+}
+
+// 0 means unlimited
+type RateLimitConfig struct {
+	QueriesPerSecond int `toml:"queries-per-second"`
+	WritesPerSecond  int `toml:"writes-per-second"`
+	PointsPerSecond  int `toml:"points-per-second"`
+}
+
 type ApiConfig struct {
 	SslPort     int    `toml:"ssl-port"`
 	SslCertPath string `toml:"ssl-cert"`
-	Port        int
-	ReadTimeout duration `toml:"read-timeout"`
+	// client certificates signed by this CA are required on the ssl
+	// port if it's set
+	SslClientCaPath   string   `toml:"ssl-client-ca"`
+	SslClientAdminOUs []string `toml:"ssl-client-admin-ous"`
+	// the certificate is reloaded when it changes, 0 disables the checks
+	SslReloadInterval duration `toml:"ssl-reload-interval"`
+	Port              int
+	ReadTimeout       duration   `toml:"read-timeout"`
+	Cors              CorsConfig `toml:"cors"`
+	TokenSecret       string     `toml:"token-secret"`
+	TokenTtl          duration   `toml:"token-ttl"`
+	PasswordResetTtl  duration   `toml:"password-reset-ttl"`
+	// writes with async=true are queued in the data dir
+	AsyncWrites bool `toml:"async-writes"`
+	// 0 means unlimited
+	MaxBodySize       int64 `toml:"max-body-size"`
+	MaxPointsPerWrite int   `toml:"max-points-per-write"`
+	// the rate limits of every user and every database
+	UserRateLimits     RateLimitConfig `toml:"user-rate-limits"`
+	DatabaseRateLimits RateLimitConfig `toml:"database-rate-limits"`
+	// requests that take longer are logged, 0 disables the log
+	SlowRequestThreshold duration `toml:"slow-request-threshold"`
+	// serve the profiles of net/http/pprof to the cluster admins
+	Pprof            bool `toml:"pprof-enabled"`
+	BlockProfileRate int  `toml:"block-profile-rate"`
+	// the api is also served on this unix socket if it's set, the
+	// permissions are in octal
+	UnixSocket            string `toml:"unix-socket"`
+	UnixSocketPermissions string `toml:"unix-socket-permissions"`
+
+	PamService   string   `toml:"pam-service"`
+	PamListeners []string `toml:"pam-listeners"`
 }
 
 type GraphiteConfig struct {
+	Enabled    bool
+	Port       int
+	PicklePort int `toml:"pickle-port"`
+	// the largest pickle message that's accepted, carbon's limit by
+	// default
+	PickleMaxSize size `toml:"pickle-max-size"`
+	Database      string
+	Templates     []string
+}
+
+type CollectdInputConfig struct {
+	Enabled       bool
+	Port          int
+	Database      string
+	TypesDb       string `toml:"typesdb"`
+	SecurityLevel string `toml:"security-level"`
+	AuthFile      string `toml:"auth-file"`
+}
+
+type OpenTsdbInputConfig struct {
+	Enabled  bool
+	Port     int
+	Database string
+}
+
+type StatsdInputConfig struct {
+	Enabled       bool
+	Port          int
+	Database      string
+	FlushInterval duration  `toml:"flush-interval"`
+	Percentiles   []float64 `toml:"percentiles"`
+}
+
+type KafkaInputConfig struct {
+	Enabled      bool
+	Brokers      []string
+	Topic        string
+	Database     string
+	Format       string
+	BatchSize    int      `toml:"batch-size"`
+	BatchTimeout duration `toml:"batch-timeout"`
+}
+
+type MqttInputConfig struct {
+	Enabled   bool
+	Broker    string
+	ClientId  string `toml:"client-id"`
+	Username  string
+	Password  string
+	Topics    []string
+	Qos       int
+	Database  string
+	Templates []string
+}
+
+type UdpInputConfig struct {
 	Enabled  bool
 	Port     int
 	Database string
@@ -82,6 +183,7 @@ type RaftConfig struct {
 type StorageConfig struct {
 	Dir             string
 	WriteBufferSize int `toml:"write-buffer-size"`
+	MaxDeadLetters  int `toml:"max-dead-letters"`
 }
 
 type ClusterConfig struct {
@@ -94,18 +196,82 @@ type ClusterConfig struct {
 	WriteBufferSize           int      `toml:"write-buffer-size"`
 	ConcurrentShardQueryLimit int      `toml:"concurrent-shard-query-limit"`
 	MaxResponseBufferSize     int      `toml:"max-response-buffer-size"`
+	SubscriptionBufferSize    int      `toml:"subscription-buffer-size"`
+	SubscriptionMaxRetries    int      `toml:"subscription-max-retries"`
+	SlowQueryThreshold        duration `toml:"slow-query-threshold"`
+	RecordSlowQueries         bool     `toml:"record-slow-queries"`
+	ShutdownTimeout           duration `toml:"shutdown-timeout"`
+}
+
+type LdapConfig struct {
+	Enabled            bool                `toml:"enabled"`
+	Url                string              `toml:"url"`
+	InsecureSkipVerify bool                `toml:"insecure-skip-verify"`
+	UserDn             string              `toml:"user-dn"`
+	GroupSearchBase    string              `toml:"group-search-base"`
+	GroupSearchFilter  string              `toml:"group-search-filter"`
+	GroupAttribute     string              `toml:"group-attribute"`
+	AdminGroups        []string            `toml:"admin-groups"`
+	GroupRoles         map[string][]string `toml:"group-roles"`
+}
+
+type SecurityConfig struct {
+	PasswordHashCost int `toml:"password-hash-cost"`
+	// 0 disables the lockouts
+	MaxFailedLogins int      `toml:"max-failed-logins"`
+	LockoutDuration duration `toml:"lockout-duration"`
+	AuthCacheTtl    duration `toml:"auth-cache-ttl"`
+	// the key the credentials are encrypted with on disk, it's read
+	// from INFLUXDB_CREDENTIALS_KEY if it's empty
+	CredentialsKey string `toml:"credentials-key"`
+	// queries and writes are posted to this url, it can deny them
+	AuthorizerUrl      string   `toml:"authorizer-url"`
+	AuthorizerTimeout  duration `toml:"authorizer-timeout"`
+	AuthorizerFailOpen bool     `toml:"authorizer-fail-open"`
+}
+
+type AuditConfig struct {
+	File       string `toml:"file"`
+	MaxSize    size   `toml:"max-size"`
+	MaxBackups int    `toml:"max-backups"`
+}
+
+type MonitoringConfig struct {
+	Enabled         bool           `toml:"enabled"`
+	Database        string         `toml:"database"`
+	Interval        duration       `toml:"interval"`
+	QuerySampleRate float64        `toml:"query-sample-rate"`
+	Reporter        ReporterConfig `toml:"reporter"`
+}
+
+type ReporterConfig struct {
+	Enabled  bool     `toml:"enabled"`
+	Protocol string   `toml:"protocol"`
+	Address  string   `toml:"address"`
+	Prefix   string   `toml:"prefix"`
+	Interval duration `toml:"interval"`
+}
+
+type WebhooksConfig struct {
+	Urls                []string `toml:"urls"`
+	Timeout             duration `toml:"timeout"`
+	ReplicaLagThreshold int      `toml:"replica-lag-threshold"`
 }
 
 type LoggingConfig struct {
-	File  string
-	Level string
+	File   string
+	Level  string
+	Format string            `toml:"format"`
+	Levels map[string]string `toml:"levels"`
 }
 
 type LevelDbConfiguration struct {
-	MaxOpenFiles   int  `toml:"max-open-files"`
-	LruCacheSize   size `toml:"lru-cache-size"`
-	MaxOpenShards  int  `toml:"max-open-shards"`
-	PointBatchSize int  `toml:"point-batch-size"`
+	MaxOpenFiles   int      `toml:"max-open-files"`
+	LruCacheSize   size     `toml:"lru-cache-size"`
+	MaxOpenShards  int      `toml:"max-open-shards"`
+	PointBatchSize int      `toml:"point-batch-size"`
+	VerifyShards   bool     `toml:"verify-shards-on-startup"`
+	ScrubInterval  duration `toml:"scrub-interval"`
 }
 
 type ShardingDefinition struct {
@@ -121,6 +287,12 @@ type ShardConfiguration struct {
 	SplitRandom      string `toml:"split-random"`
 	splitRandomRegex *regexp.Regexp
 	hasRandomSplit   bool
+	// leveldb options for this type of shards, they default to the
+	// settings in the leveldb section if they aren't set
+	LruCacheSize    size `toml:"lru-cache-size"`
+	BloomFilterBits int  `toml:"bloom-filter-bits"`
+	WriteBufferSize size `toml:"write-buffer-size"`
+	MaxOpenFiles    int  `toml:"max-open-files"`
 }
 
 func (self *ShardConfiguration) ParseAndValidate(defaultShardDuration time.Duration) error {
@@ -161,6 +333,14 @@ func (self *ShardConfiguration) SplitRegex() *regexp.Regexp {
 	return self.splitRandomRegex
 }
 
+func (self *ShardConfiguration) LevelDbLruCacheSize() int {
+	return self.LruCacheSize.int
+}
+
+func (self *ShardConfiguration) LevelDbWriteBufferSize() int {
+	return self.WriteBufferSize.int
+}
+
 type WalConfig struct {
 	Dir                   string `toml:"dir"`
 	FlushAfterRequests    int    `toml:"flush-after"`
@@ -170,7 +350,13 @@ type WalConfig struct {
 }
 
 type InputPlugins struct {
-	Graphite GraphiteConfig `toml:"graphite"`
+	Graphite GraphiteConfig      `toml:"graphite"`
+	Udp      UdpInputConfig      `toml:"udp"`
+	Collectd CollectdInputConfig `toml:"collectd"`
+	OpenTsdb OpenTsdbInputConfig `toml:"opentsdb"`
+	Statsd   StatsdInputConfig   `toml:"statsd"`
+	Kafka    KafkaInputConfig    `toml:"kafka"`
+	Mqtt     MqttInputConfig     `toml:"mqtt"`
 }
 
 type TomlConfiguration struct {
@@ -181,6 +367,11 @@ type TomlConfiguration struct {
 	Storage      StorageConfig
 	Cluster      ClusterConfig
 	Logging      LoggingConfig
+	Ldap         LdapConfig
+	Audit        AuditConfig
+	Monitoring   MonitoringConfig
+	Webhooks     WebhooksConfig
+	Security     SecurityConfig
 	LevelDb      LevelDbConfiguration
 	Hostname     string
 	BindAddress  string             `toml:"bind-address"`
@@ -193,11 +384,74 @@ type Configuration struct {
 	AdminAssetsDir               string
 	ApiHttpSslPort               int
 	ApiHttpCertPath              string
+	ApiHttpSslClientCaPath       string
+	ApiHttpSslClientAdminOUs     []string
+	ApiHttpSslReloadInterval     time.Duration
 	ApiHttpPort                  int
 	ApiReadTimeout               time.Duration
+	ApiCorsAllowedOrigins        []string
+	ApiCorsAllowedMethods        []string
+	ApiCorsAllowedHeaders        []string
+	ApiCorsAllowCredentials      bool
+	ApiCorsMaxAge                time.Duration
+	ApiTokenSecret               string
+	ApiTokenTtl                  time.Duration
+	ApiPasswordResetTtl          time.Duration
+	ApiAsyncWrites               bool
+	ApiMaxBodySize               int64
+	ApiMaxPointsPerWrite         int
+	ApiUserQueriesPerSecond      int
+	ApiUserWritesPerSecond       int
+	ApiUserPointsPerSecond       int
+	ApiDatabaseQueriesPerSecond  int
+	ApiDatabaseWritesPerSecond   int
+	ApiDatabasePointsPerSecond   int
+	ApiSlowRequestThreshold      time.Duration
+	ApiPprofEnabled              bool
+	ApiBlockProfileRate          int
+	ApiUnixSocket                string
+	ApiUnixSocketPermissions     os.FileMode
+	ApiPamService                string
+	ApiPamListeners              []string
 	GraphiteEnabled              bool
 	GraphitePort                 int
 	GraphiteDatabase             string
+	GraphitePicklePort           int
+	GraphitePickleMaxSize        int
+	GraphiteTemplates            []string
+	UdpInputEnabled              bool
+	UdpInputPort                 int
+	UdpInputDatabase             string
+	CollectdEnabled              bool
+	CollectdPort                 int
+	CollectdDatabase             string
+	CollectdTypesDb              string
+	CollectdSecurityLevel        string
+	CollectdAuthFile             string
+	OpenTsdbEnabled              bool
+	OpenTsdbPort                 int
+	OpenTsdbDatabase             string
+	StatsdEnabled                bool
+	StatsdPort                   int
+	StatsdDatabase               string
+	StatsdFlushInterval          time.Duration
+	StatsdPercentiles            []float64
+	KafkaEnabled                 bool
+	KafkaBrokers                 []string
+	KafkaTopic                   string
+	KafkaDatabase                string
+	KafkaFormat                  string
+	KafkaBatchSize               int
+	KafkaBatchTimeout            time.Duration
+	MqttEnabled                  bool
+	MqttBroker                   string
+	MqttClientId                 string
+	MqttUsername                 string
+	MqttPassword                 string
+	MqttTopics                   []string
+	MqttQos                      int
+	MqttDatabase                 string
+	MqttTemplates                []string
 	RaftServerPort               int
 	RaftTimeout                  duration
 	SeedServers                  []string
@@ -211,11 +465,15 @@ type Configuration struct {
 	Hostname                     string
 	LogFile                      string
 	LogLevel                     string
+	LogFormat                    string
+	LogModuleLevels              map[string]string
 	BindAddress                  string
 	LevelDbMaxOpenFiles          int
 	LevelDbLruCacheSize          int
 	LevelDbMaxOpenShards         int
 	LevelDbPointBatchSize        int
+	LevelDbVerifyShardsOnStartup bool
+	LevelDbScrubInterval         time.Duration
 	ShortTermShard               *ShardConfiguration
 	LongTermShard                *ShardConfiguration
 	ReplicationFactor            int
@@ -225,14 +483,61 @@ type Configuration struct {
 	WalIndexAfterRequests        int
 	WalRequestsPerLogFile        int
 	LocalStoreWriteBufferSize    int
+	MaxDeadLetters               int
 	PerServerWriteBufferSize     int
 	ClusterMaxResponseBufferSize int
 	ConcurrentShardQueryLimit    int
+	SubscriptionBufferSize       int
+	SubscriptionMaxRetries       int
+	SlowQueryThreshold           time.Duration
+	RecordSlowQueries            bool
+	ShutdownTimeout              time.Duration
+	LdapEnabled                  bool
+	LdapUrl                      string
+	LdapInsecureSkipVerify       bool
+	LdapUserDn                   string
+	LdapGroupSearchBase          string
+	LdapGroupSearchFilter        string
+	LdapGroupAttribute           string
+	LdapAdminGroups              []string
+	LdapGroupRoles               map[string][]string
+	AuditLogFile                 string
+	AuditLogMaxSize              int64
+	AuditLogMaxBackups           int
+	PasswordHashCost             int
+	MaxFailedLogins              int
+	LockoutDuration              time.Duration
+	AuthCacheTtl                 time.Duration
+	CredentialsKey               string
+	AuthorizerUrl                string
+	AuthorizerTimeout            time.Duration
+	AuthorizerFailOpen           bool
+	MonitoringEnabled            bool
+	MonitoringDatabase           string
+	MonitoringInterval           time.Duration
+	MonitoringQuerySampleRate    float64
+	ReporterEnabled              bool
+	ReporterProtocol             string
+	ReporterAddress              string
+	ReporterPrefix               string
+	ReporterInterval             time.Duration
+	WebhookUrls                  []string
+	WebhookTimeout               time.Duration
+	WebhookReplicaLagThreshold   int
+
+	// set by the daemon, they aren't read from the config file
+	InfluxDBVersion string
+	InfluxDBGitSha  string
+	// utc, e.g. 2014-05-01T12:00:00Z
+	InfluxDBBuildDate string
+	// the file the configuration was loaded from, it's read again when
+	// the configuration is reloaded
+	FileName string
 }
 
 func LoadConfiguration(fileName string) *Configuration {
 	log.Info("Loading configuration file %s", fileName)
-	config, err := parseTomlConfiguration(fileName)
+	config, err := ParseConfiguration(fileName)
 	if err != nil {
 		log.Error("Couldn't parse configuration file: " + fileName)
 		panic(err)
@@ -240,6 +545,17 @@ func LoadConfiguration(fileName string) *Configuration {
 	return config
 }
 
+// Same as LoadConfiguration but returns the errors, e.g. to reload the
+// configuration of a running server
+func ParseConfiguration(fileName string) (*Configuration, error) {
+	config, err := parseTomlConfiguration(fileName)
+	if err != nil {
+		return nil, err
+	}
+	config.FileName = fileName
+	return config, nil
+}
+
 func parseTomlConfiguration(filename string) (*Configuration, error) {
 	body, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -281,6 +597,76 @@ func parseTomlConfiguration(filename string) (*Configuration, error) {
 		apiReadTimeout = 5 * time.Second
 	}
 
+	// the defaults allow any origin, like the api did before the cors
+	// policy was configurable
+	cors := &tomlConfiguration.HttpApi.Cors
+	if cors.AllowedOrigins == nil {
+		cors.AllowedOrigins = []string{"*"}
+	}
+	if cors.AllowedMethods == nil {
+		cors.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	}
+	if cors.AllowedHeaders == nil {
+		cors.AllowedHeaders = []string{"Origin", "X-Requested-With", "Content-Type", "Accept"}
+	}
+	if cors.MaxAge.Duration == 0 {
+		cors.MaxAge = duration{30 * 24 * time.Hour}
+	}
+	if cors.AllowCredentials {
+		for _, origin := range cors.AllowedOrigins {
+			if origin == "*" {
+				return nil, fmt.Errorf("allow-credentials can't be used with the * origin, list the allowed origins instead")
+			}
+		}
+	}
+
+	unixSocketPermissions := uint64(0770)
+	if permissions := tomlConfiguration.HttpApi.UnixSocketPermissions; permissions != "" {
+		unixSocketPermissions, err = strconv.ParseUint(permissions, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid unix-socket-permissions %s: %s", permissions, err)
+		}
+	}
+
+	if tomlConfiguration.HttpApi.PamService == "" {
+		tomlConfiguration.HttpApi.PamService = "influxdb"
+	}
+
+	if tomlConfiguration.HttpApi.TokenTtl.Duration == 0 {
+		tomlConfiguration.HttpApi.TokenTtl = duration{time.Hour}
+	}
+	if tomlConfiguration.HttpApi.PasswordResetTtl.Duration == 0 {
+		tomlConfiguration.HttpApi.PasswordResetTtl = duration{24 * time.Hour}
+	}
+
+	if tomlConfiguration.InputPlugins.Statsd.FlushInterval.Duration == 0 {
+		tomlConfiguration.InputPlugins.Statsd.FlushInterval = duration{10 * time.Second}
+	}
+
+	if tomlConfiguration.InputPlugins.Statsd.Percentiles == nil {
+		tomlConfiguration.InputPlugins.Statsd.Percentiles = []float64{90}
+	}
+
+	if tomlConfiguration.InputPlugins.Graphite.PickleMaxSize.int <= 0 {
+		tomlConfiguration.InputPlugins.Graphite.PickleMaxSize = size{ONE_MEGABYTE}
+	}
+
+	if tomlConfiguration.InputPlugins.Kafka.Format == "" {
+		tomlConfiguration.InputPlugins.Kafka.Format = "json"
+	}
+
+	if tomlConfiguration.InputPlugins.Kafka.BatchSize <= 0 {
+		tomlConfiguration.InputPlugins.Kafka.BatchSize = 1000
+	}
+
+	if tomlConfiguration.InputPlugins.Kafka.BatchTimeout.Duration == 0 {
+		tomlConfiguration.InputPlugins.Kafka.BatchTimeout = duration{time.Second}
+	}
+
+	if tomlConfiguration.InputPlugins.Mqtt.ClientId == "" {
+		tomlConfiguration.InputPlugins.Mqtt.ClientId = "influxdb"
+	}
+
 	if tomlConfiguration.Cluster.MinBackoff.Duration == 0 {
 		tomlConfiguration.Cluster.MinBackoff = duration{time.Second}
 	}
@@ -289,6 +675,66 @@ func parseTomlConfiguration(filename string) (*Configuration, error) {
 		tomlConfiguration.Cluster.MaxBackoff = duration{10 * time.Second}
 	}
 
+	ldap := &tomlConfiguration.Ldap
+	if ldap.GroupSearchFilter == "" {
+		ldap.GroupSearchFilter = "(member={dn})"
+	}
+	if ldap.GroupAttribute == "" {
+		ldap.GroupAttribute = "cn"
+	}
+
+	if tomlConfiguration.Audit.MaxSize.int == 0 {
+		tomlConfiguration.Audit.MaxSize = size{100 * ONE_MEGABYTE}
+	}
+	if tomlConfiguration.Audit.MaxBackups == 0 {
+		tomlConfiguration.Audit.MaxBackups = 5
+	}
+
+	if tomlConfiguration.Security.PasswordHashCost == 0 {
+		tomlConfiguration.Security.PasswordHashCost = 10
+	}
+	if tomlConfiguration.Security.LockoutDuration.Duration == 0 {
+		tomlConfiguration.Security.LockoutDuration = duration{5 * time.Minute}
+	}
+	if tomlConfiguration.Security.AuthCacheTtl.Duration == 0 {
+		tomlConfiguration.Security.AuthCacheTtl = duration{15 * time.Minute}
+	}
+	if tomlConfiguration.Security.AuthorizerTimeout.Duration == 0 {
+		tomlConfiguration.Security.AuthorizerTimeout = duration{time.Second}
+	}
+	if tomlConfiguration.Security.CredentialsKey == "" {
+		tomlConfiguration.Security.CredentialsKey = os.Getenv("INFLUXDB_CREDENTIALS_KEY")
+	}
+
+	if tomlConfiguration.Logging.Format == "" {
+		tomlConfiguration.Logging.Format = "text"
+	}
+
+	if tomlConfiguration.Monitoring.Database == "" {
+		tomlConfiguration.Monitoring.Database = "_internal"
+	}
+	if tomlConfiguration.Monitoring.Interval.Duration == 0 {
+		tomlConfiguration.Monitoring.Interval = duration{10 * time.Second}
+	}
+	if rate := tomlConfiguration.Monitoring.QuerySampleRate; rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("Invalid query-sample-rate %v, it should be between 0 and 1", rate)
+	}
+	if tomlConfiguration.Monitoring.Reporter.Protocol == "" {
+		tomlConfiguration.Monitoring.Reporter.Protocol = "graphite"
+	}
+	if tomlConfiguration.Monitoring.Reporter.Address == "" {
+		tomlConfiguration.Monitoring.Reporter.Address = "localhost:2003"
+	}
+	if tomlConfiguration.Monitoring.Reporter.Prefix == "" {
+		tomlConfiguration.Monitoring.Reporter.Prefix = "influxdb"
+	}
+	if tomlConfiguration.Monitoring.Reporter.Interval.Duration == 0 {
+		tomlConfiguration.Monitoring.Reporter.Interval = duration{10 * time.Second}
+	}
+	if tomlConfiguration.Webhooks.Timeout.Duration == 0 {
+		tomlConfiguration.Webhooks.Timeout = duration{5 * time.Second}
+	}
+
 	if tomlConfiguration.Cluster.ProtobufHeartbeatInterval.Duration == 0 {
 		tomlConfiguration.Cluster.ProtobufHeartbeatInterval = duration{10 * time.Millisecond}
 	}
@@ -298,11 +744,74 @@ func parseTomlConfiguration(filename string) (*Configuration, error) {
 		AdminAssetsDir:               tomlConfiguration.Admin.Assets,
 		ApiHttpPort:                  tomlConfiguration.HttpApi.Port,
 		ApiHttpCertPath:              tomlConfiguration.HttpApi.SslCertPath,
+		ApiHttpSslClientCaPath:       tomlConfiguration.HttpApi.SslClientCaPath,
+		ApiHttpSslClientAdminOUs:     tomlConfiguration.HttpApi.SslClientAdminOUs,
+		ApiHttpSslReloadInterval:     tomlConfiguration.HttpApi.SslReloadInterval.Duration,
 		ApiHttpSslPort:               tomlConfiguration.HttpApi.SslPort,
 		ApiReadTimeout:               apiReadTimeout,
+		ApiCorsAllowedOrigins:        cors.AllowedOrigins,
+		ApiCorsAllowedMethods:        cors.AllowedMethods,
+		ApiCorsAllowedHeaders:        cors.AllowedHeaders,
+		ApiCorsAllowCredentials:      cors.AllowCredentials,
+		ApiCorsMaxAge:                cors.MaxAge.Duration,
+		ApiTokenSecret:               tomlConfiguration.HttpApi.TokenSecret,
+		ApiTokenTtl:                  tomlConfiguration.HttpApi.TokenTtl.Duration,
+		ApiPasswordResetTtl:          tomlConfiguration.HttpApi.PasswordResetTtl.Duration,
+		ApiAsyncWrites:               tomlConfiguration.HttpApi.AsyncWrites,
+		ApiMaxBodySize:               tomlConfiguration.HttpApi.MaxBodySize,
+		ApiMaxPointsPerWrite:         tomlConfiguration.HttpApi.MaxPointsPerWrite,
+		ApiUserQueriesPerSecond:      tomlConfiguration.HttpApi.UserRateLimits.QueriesPerSecond,
+		ApiUserWritesPerSecond:       tomlConfiguration.HttpApi.UserRateLimits.WritesPerSecond,
+		ApiUserPointsPerSecond:       tomlConfiguration.HttpApi.UserRateLimits.PointsPerSecond,
+		ApiDatabaseQueriesPerSecond:  tomlConfiguration.HttpApi.DatabaseRateLimits.QueriesPerSecond,
+		ApiDatabaseWritesPerSecond:   tomlConfiguration.HttpApi.DatabaseRateLimits.WritesPerSecond,
+		ApiDatabasePointsPerSecond:   tomlConfiguration.HttpApi.DatabaseRateLimits.PointsPerSecond,
+		ApiSlowRequestThreshold:      tomlConfiguration.HttpApi.SlowRequestThreshold.Duration,
+		ApiPprofEnabled:              tomlConfiguration.HttpApi.Pprof,
+		ApiBlockProfileRate:          tomlConfiguration.HttpApi.BlockProfileRate,
+		ApiUnixSocket:                tomlConfiguration.HttpApi.UnixSocket,
+		ApiUnixSocketPermissions:     os.FileMode(unixSocketPermissions),
+		ApiPamService:                tomlConfiguration.HttpApi.PamService,
+		ApiPamListeners:              tomlConfiguration.HttpApi.PamListeners,
 		GraphiteEnabled:              tomlConfiguration.InputPlugins.Graphite.Enabled,
 		GraphitePort:                 tomlConfiguration.InputPlugins.Graphite.Port,
 		GraphiteDatabase:             tomlConfiguration.InputPlugins.Graphite.Database,
+		GraphitePicklePort:           tomlConfiguration.InputPlugins.Graphite.PicklePort,
+		GraphitePickleMaxSize:        tomlConfiguration.InputPlugins.Graphite.PickleMaxSize.int,
+		GraphiteTemplates:            tomlConfiguration.InputPlugins.Graphite.Templates,
+		UdpInputEnabled:              tomlConfiguration.InputPlugins.Udp.Enabled,
+		UdpInputPort:                 tomlConfiguration.InputPlugins.Udp.Port,
+		UdpInputDatabase:             tomlConfiguration.InputPlugins.Udp.Database,
+		CollectdEnabled:              tomlConfiguration.InputPlugins.Collectd.Enabled,
+		CollectdPort:                 tomlConfiguration.InputPlugins.Collectd.Port,
+		CollectdDatabase:             tomlConfiguration.InputPlugins.Collectd.Database,
+		CollectdTypesDb:              tomlConfiguration.InputPlugins.Collectd.TypesDb,
+		CollectdSecurityLevel:        tomlConfiguration.InputPlugins.Collectd.SecurityLevel,
+		CollectdAuthFile:             tomlConfiguration.InputPlugins.Collectd.AuthFile,
+		OpenTsdbEnabled:              tomlConfiguration.InputPlugins.OpenTsdb.Enabled,
+		OpenTsdbPort:                 tomlConfiguration.InputPlugins.OpenTsdb.Port,
+		OpenTsdbDatabase:             tomlConfiguration.InputPlugins.OpenTsdb.Database,
+		StatsdEnabled:                tomlConfiguration.InputPlugins.Statsd.Enabled,
+		StatsdPort:                   tomlConfiguration.InputPlugins.Statsd.Port,
+		StatsdDatabase:               tomlConfiguration.InputPlugins.Statsd.Database,
+		StatsdFlushInterval:          tomlConfiguration.InputPlugins.Statsd.FlushInterval.Duration,
+		StatsdPercentiles:            tomlConfiguration.InputPlugins.Statsd.Percentiles,
+		KafkaEnabled:                 tomlConfiguration.InputPlugins.Kafka.Enabled,
+		KafkaBrokers:                 tomlConfiguration.InputPlugins.Kafka.Brokers,
+		KafkaTopic:                   tomlConfiguration.InputPlugins.Kafka.Topic,
+		KafkaDatabase:                tomlConfiguration.InputPlugins.Kafka.Database,
+		KafkaFormat:                  tomlConfiguration.InputPlugins.Kafka.Format,
+		KafkaBatchSize:               tomlConfiguration.InputPlugins.Kafka.BatchSize,
+		KafkaBatchTimeout:            tomlConfiguration.InputPlugins.Kafka.BatchTimeout.Duration,
+		MqttEnabled:                  tomlConfiguration.InputPlugins.Mqtt.Enabled,
+		MqttBroker:                   tomlConfiguration.InputPlugins.Mqtt.Broker,
+		MqttClientId:                 tomlConfiguration.InputPlugins.Mqtt.ClientId,
+		MqttUsername:                 tomlConfiguration.InputPlugins.Mqtt.Username,
+		MqttPassword:                 tomlConfiguration.InputPlugins.Mqtt.Password,
+		MqttTopics:                   tomlConfiguration.InputPlugins.Mqtt.Topics,
+		MqttQos:                      tomlConfiguration.InputPlugins.Mqtt.Qos,
+		MqttDatabase:                 tomlConfiguration.InputPlugins.Mqtt.Database,
+		MqttTemplates:                tomlConfiguration.InputPlugins.Mqtt.Templates,
 		RaftServerPort:               tomlConfiguration.Raft.Port,
 		RaftTimeout:                  tomlConfiguration.Raft.Timeout,
 		RaftDir:                      tomlConfiguration.Raft.Dir,
@@ -315,6 +824,8 @@ func parseTomlConfiguration(filename string) (*Configuration, error) {
 		DataDir:                      tomlConfiguration.Storage.Dir,
 		LogFile:                      tomlConfiguration.Logging.File,
 		LogLevel:                     tomlConfiguration.Logging.Level,
+		LogFormat:                    tomlConfiguration.Logging.Format,
+		LogModuleLevels:              tomlConfiguration.Logging.Levels,
 		Hostname:                     tomlConfiguration.Hostname,
 		BindAddress:                  tomlConfiguration.BindAddress,
 		LevelDbMaxOpenFiles:          tomlConfiguration.LevelDb.MaxOpenFiles,
@@ -322,6 +833,8 @@ func parseTomlConfiguration(filename string) (*Configuration, error) {
 		LevelDbMaxOpenShards:         tomlConfiguration.LevelDb.MaxOpenShards,
 		LongTermShard:                &tomlConfiguration.Sharding.LongTerm,
 		LevelDbPointBatchSize:        tomlConfiguration.LevelDb.PointBatchSize,
+		LevelDbVerifyShardsOnStartup: tomlConfiguration.LevelDb.VerifyShards,
+		LevelDbScrubInterval:         tomlConfiguration.LevelDb.ScrubInterval.Duration,
 		ShortTermShard:               &tomlConfiguration.Sharding.ShortTerm,
 		ReplicationFactor:            tomlConfiguration.Sharding.ReplicationFactor,
 		WalDir:                       tomlConfiguration.WalConfig.Dir,
@@ -330,14 +843,55 @@ func parseTomlConfiguration(filename string) (*Configuration, error) {
 		WalIndexAfterRequests:        tomlConfiguration.WalConfig.IndexAfterRequests,
 		WalRequestsPerLogFile:        tomlConfiguration.WalConfig.RequestsPerLogFile,
 		LocalStoreWriteBufferSize:    tomlConfiguration.Storage.WriteBufferSize,
+		MaxDeadLetters:               tomlConfiguration.Storage.MaxDeadLetters,
 		PerServerWriteBufferSize:     tomlConfiguration.Cluster.WriteBufferSize,
 		ClusterMaxResponseBufferSize: tomlConfiguration.Cluster.MaxResponseBufferSize,
 		ConcurrentShardQueryLimit:    defaultConcurrentShardQueryLimit,
+		SubscriptionBufferSize:       tomlConfiguration.Cluster.SubscriptionBufferSize,
+		SubscriptionMaxRetries:       tomlConfiguration.Cluster.SubscriptionMaxRetries,
+		SlowQueryThreshold:           tomlConfiguration.Cluster.SlowQueryThreshold.Duration,
+		RecordSlowQueries:            tomlConfiguration.Cluster.RecordSlowQueries,
+		ShutdownTimeout:              tomlConfiguration.Cluster.ShutdownTimeout.Duration,
+		LdapEnabled:                  ldap.Enabled,
+		LdapUrl:                      ldap.Url,
+		LdapInsecureSkipVerify:       ldap.InsecureSkipVerify,
+		LdapUserDn:                   ldap.UserDn,
+		LdapGroupSearchBase:          ldap.GroupSearchBase,
+		LdapGroupSearchFilter:        ldap.GroupSearchFilter,
+		LdapGroupAttribute:           ldap.GroupAttribute,
+		LdapAdminGroups:              ldap.AdminGroups,
+		LdapGroupRoles:               ldap.GroupRoles,
+		AuditLogFile:                 tomlConfiguration.Audit.File,
+		AuditLogMaxSize:              int64(tomlConfiguration.Audit.MaxSize.int),
+		AuditLogMaxBackups:           tomlConfiguration.Audit.MaxBackups,
+		PasswordHashCost:             tomlConfiguration.Security.PasswordHashCost,
+		MaxFailedLogins:              tomlConfiguration.Security.MaxFailedLogins,
+		LockoutDuration:              tomlConfiguration.Security.LockoutDuration.Duration,
+		AuthCacheTtl:                 tomlConfiguration.Security.AuthCacheTtl.Duration,
+		CredentialsKey:               tomlConfiguration.Security.CredentialsKey,
+		AuthorizerUrl:                tomlConfiguration.Security.AuthorizerUrl,
+		AuthorizerTimeout:            tomlConfiguration.Security.AuthorizerTimeout.Duration,
+		AuthorizerFailOpen:           tomlConfiguration.Security.AuthorizerFailOpen,
+		MonitoringEnabled:            tomlConfiguration.Monitoring.Enabled,
+		MonitoringDatabase:           tomlConfiguration.Monitoring.Database,
+		MonitoringInterval:           tomlConfiguration.Monitoring.Interval.Duration,
+		MonitoringQuerySampleRate:    tomlConfiguration.Monitoring.QuerySampleRate,
+		ReporterEnabled:              tomlConfiguration.Monitoring.Reporter.Enabled,
+		ReporterProtocol:             tomlConfiguration.Monitoring.Reporter.Protocol,
+		ReporterAddress:              tomlConfiguration.Monitoring.Reporter.Address,
+		ReporterPrefix:               tomlConfiguration.Monitoring.Reporter.Prefix,
+		ReporterInterval:             tomlConfiguration.Monitoring.Reporter.Interval.Duration,
+		WebhookUrls:                  tomlConfiguration.Webhooks.Urls,
+		WebhookTimeout:               tomlConfiguration.Webhooks.Timeout.Duration,
+		WebhookReplicaLagThreshold:   tomlConfiguration.Webhooks.ReplicaLagThreshold,
 	}
 
 	if config.LocalStoreWriteBufferSize == 0 {
 		config.LocalStoreWriteBufferSize = 1000
 	}
+	if config.MaxDeadLetters == 0 {
+		config.MaxDeadLetters = 10000
+	}
 	if config.PerServerWriteBufferSize == 0 {
 		config.PerServerWriteBufferSize = 1000
 	}
@@ -346,6 +900,16 @@ func parseTomlConfiguration(filename string) (*Configuration, error) {
 		config.ClusterMaxResponseBufferSize = 100000
 	}
 
+	if config.SubscriptionBufferSize == 0 {
+		config.SubscriptionBufferSize = 1000
+	}
+	if config.SubscriptionMaxRetries == 0 {
+		config.SubscriptionMaxRetries = 5
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = 30 * time.Second
+	}
+
 	// if it wasn't set, set it to 100
 	if config.LevelDbMaxOpenFiles == 0 {
 		config.LevelDbMaxOpenFiles = 100
@@ -410,6 +974,46 @@ func (self *Configuration) GraphitePortString() string {
 	return fmt.Sprintf("%s:%d", self.BindAddress, self.GraphitePort)
 }
 
+func (self *Configuration) GraphitePicklePortString() string {
+	if self.GraphitePicklePort <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d", self.BindAddress, self.GraphitePicklePort)
+}
+
+func (self *Configuration) UdpInputPortString() string {
+	if self.UdpInputPort <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d", self.BindAddress, self.UdpInputPort)
+}
+
+func (self *Configuration) CollectdPortString() string {
+	if self.CollectdPort <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d", self.BindAddress, self.CollectdPort)
+}
+
+func (self *Configuration) OpenTsdbPortString() string {
+	if self.OpenTsdbPort <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d", self.BindAddress, self.OpenTsdbPort)
+}
+
+func (self *Configuration) StatsdPortString() string {
+	if self.StatsdPort <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d", self.BindAddress, self.StatsdPort)
+}
+
 func (self *Configuration) ProtobufPortString() string {
 	return fmt.Sprintf("%s:%d", self.BindAddress, self.ProtobufPort)
 }
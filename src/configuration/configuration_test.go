@@ -1,6 +1,9 @@
 package configuration
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 	. "launchpad.net/gocheck"
@@ -21,6 +24,8 @@ func (self *LoadConfigurationSuite) TestConfig(c *C) {
 
 	c.Assert(config.LogFile, Equals, "influxdb.log")
 	c.Assert(config.LogLevel, Equals, "info")
+	c.Assert(config.LogFormat, Equals, "json")
+	c.Assert(config.LogModuleLevels, DeepEquals, map[string]string{"coordinator": "debug", "wal": "error"})
 
 	c.Assert(config.AdminAssetsDir, Equals, "./admin")
 	c.Assert(config.AdminHttpPort, Equals, 8083)
@@ -29,15 +34,77 @@ func (self *LoadConfigurationSuite) TestConfig(c *C) {
 	// file
 	c.Assert(config.LevelDbMaxOpenFiles, Equals, 100)
 	c.Assert(config.LevelDbPointBatchSize, Equals, 50)
+	c.Assert(config.MaxDeadLetters, Equals, 10000)
 
 	c.Assert(config.ApiHttpPort, Equals, 0)
 	c.Assert(config.ApiHttpSslPort, Equals, 8087)
 	c.Assert(config.ApiHttpCertPath, Equals, "../cert.pem")
+	c.Assert(config.ApiHttpSslClientCaPath, Equals, "../ca.pem")
+	c.Assert(config.ApiHttpSslClientAdminOUs, DeepEquals, []string{"influxdb-admins"})
+	c.Assert(config.ApiHttpSslReloadInterval, Equals, 30*time.Second)
 	c.Assert(config.ApiHttpPortString(), Equals, "")
+	c.Assert(config.ApiCorsAllowedOrigins, DeepEquals, []string{"http://dashboard.example.com"})
+	c.Assert(config.ApiCorsAllowedMethods, DeepEquals, []string{"GET", "POST", "PUT", "DELETE"})
+	c.Assert(config.ApiCorsAllowedHeaders, DeepEquals, []string{"Content-Type", "Authorization"})
+	c.Assert(config.ApiCorsAllowCredentials, Equals, true)
+	c.Assert(config.ApiCorsMaxAge, Equals, 30*24*time.Hour)
+	c.Assert(config.ApiTokenSecret, Equals, "a long random string")
+	c.Assert(config.ApiTokenTtl, Equals, 15*time.Minute)
+	c.Assert(config.ApiPasswordResetTtl, Equals, 2*time.Hour)
+	c.Assert(config.ApiAsyncWrites, Equals, true)
+	c.Assert(config.ApiMaxBodySize, Equals, int64(10485760))
+	c.Assert(config.ApiMaxPointsPerWrite, Equals, 5000)
+	c.Assert(config.ApiUserQueriesPerSecond, Equals, 10)
+	c.Assert(config.ApiUserWritesPerSecond, Equals, 0)
+	c.Assert(config.ApiUserPointsPerSecond, Equals, 5000)
+	c.Assert(config.ApiDatabaseQueriesPerSecond, Equals, 0)
+	c.Assert(config.ApiDatabaseWritesPerSecond, Equals, 100)
+	c.Assert(config.ApiDatabasePointsPerSecond, Equals, 0)
+	c.Assert(config.ApiSlowRequestThreshold, Equals, 2*time.Second)
+	c.Assert(config.ApiPprofEnabled, Equals, true)
+	c.Assert(config.ApiBlockProfileRate, Equals, 1)
+	c.Assert(config.ApiUnixSocket, Equals, "/tmp/influxdb.sock")
+	c.Assert(config.ApiUnixSocketPermissions, Equals, os.FileMode(0660))
+	c.Assert(config.ApiPamService, Equals, "influxdb-test")
+	c.Assert(config.ApiPamListeners, DeepEquals, []string{"https", "unix"})
 
 	c.Assert(config.GraphiteEnabled, Equals, false)
 	c.Assert(config.GraphitePort, Equals, 2003)
 	c.Assert(config.GraphiteDatabase, Equals, "")
+	c.Assert(config.GraphitePicklePort, Equals, 2004)
+	c.Assert(config.GraphitePickleMaxSize, Equals, 1024*1024)
+	c.Assert(config.GraphiteTemplates, DeepEquals, []string{"servers.<host>.cpu.<core> cpu"})
+	c.Assert(config.UdpInputEnabled, Equals, true)
+	c.Assert(config.UdpInputPort, Equals, 4444)
+	c.Assert(config.UdpInputDatabase, Equals, "udp_db")
+	c.Assert(config.CollectdEnabled, Equals, false)
+	c.Assert(config.CollectdPort, Equals, 25826)
+	c.Assert(config.CollectdDatabase, Equals, "collectd_db")
+	c.Assert(config.CollectdTypesDb, Equals, "/usr/share/collectd/types.db")
+	c.Assert(config.CollectdSecurityLevel, Equals, "sign")
+	c.Assert(config.CollectdAuthFile, Equals, "/etc/collectd/passwd")
+	c.Assert(config.OpenTsdbEnabled, Equals, true)
+	c.Assert(config.OpenTsdbPort, Equals, 4242)
+	c.Assert(config.OpenTsdbDatabase, Equals, "opentsdb_db")
+	c.Assert(config.StatsdEnabled, Equals, false)
+	c.Assert(config.StatsdPort, Equals, 8125)
+	c.Assert(config.StatsdDatabase, Equals, "statsd_db")
+	c.Assert(config.StatsdFlushInterval, Equals, 5*time.Second)
+	c.Assert(config.StatsdPercentiles, DeepEquals, []float64{90, 99.9})
+	c.Assert(config.KafkaEnabled, Equals, false)
+	c.Assert(config.KafkaBrokers, DeepEquals, []string{"localhost:9092"})
+	c.Assert(config.KafkaTopic, Equals, "metrics")
+	c.Assert(config.KafkaDatabase, Equals, "kafka_db")
+	c.Assert(config.KafkaFormat, Equals, "graphite")
+	c.Assert(config.KafkaBatchSize, Equals, 500)
+	c.Assert(config.KafkaBatchTimeout, Equals, 2*time.Second)
+	c.Assert(config.MqttEnabled, Equals, false)
+	c.Assert(config.MqttBroker, Equals, "tcp://localhost:1883")
+	c.Assert(config.MqttClientId, Equals, "influxdb")
+	c.Assert(config.MqttTopics, DeepEquals, []string{"sensors/#"})
+	c.Assert(config.MqttQos, Equals, 1)
+	c.Assert(config.MqttDatabase, Equals, "mqtt_db")
+	c.Assert(config.MqttTemplates, DeepEquals, []string{"sensors/<room>/<sensor>"})
 
 	c.Assert(config.RaftDir, Equals, "/tmp/influxdb/development/raft")
 	c.Assert(config.RaftServerPort, Equals, 8090)
@@ -59,6 +126,49 @@ func (self *LoadConfigurationSuite) TestConfig(c *C) {
 	c.Assert(config.WalRequestsPerLogFile, Equals, 10000)
 
 	c.Assert(config.ClusterMaxResponseBufferSize, Equals, 5)
+	c.Assert(config.SubscriptionBufferSize, Equals, 500)
+	c.Assert(config.SubscriptionMaxRetries, Equals, 3)
+	c.Assert(config.SlowQueryThreshold, Equals, 5*time.Second)
+	c.Assert(config.ShutdownTimeout, Equals, 10*time.Second)
+	c.Assert(config.RecordSlowQueries, Equals, true)
+
+	c.Assert(config.LdapEnabled, Equals, true)
+	c.Assert(config.LdapUrl, Equals, "ldaps://ldap.example.com")
+	c.Assert(config.LdapUserDn, Equals, "uid={user},ou=people,dc=example,dc=com")
+	c.Assert(config.LdapGroupSearchBase, Equals, "ou=groups,dc=example,dc=com")
+	c.Assert(config.LdapGroupSearchFilter, Equals, "(member={dn})")
+	c.Assert(config.LdapGroupAttribute, Equals, "cn")
+	c.Assert(config.LdapAdminGroups, DeepEquals, []string{"influxdb-admins"})
+	c.Assert(config.LdapGroupRoles, DeepEquals, map[string][]string{"ops": []string{"collectors", "readers"}})
+
+	c.Assert(config.AuditLogFile, Equals, "/tmp/influxdb/audit.log")
+	c.Assert(config.AuditLogMaxSize, Equals, int64(10*1024*1024))
+	c.Assert(config.AuditLogMaxBackups, Equals, 5)
+	c.Assert(config.PasswordHashCost, Equals, 12)
+	c.Assert(config.MaxFailedLogins, Equals, 10)
+	c.Assert(config.LockoutDuration, Equals, 5*time.Minute)
+	c.Assert(config.AuthCacheTtl, Equals, time.Minute)
+	c.Assert(config.CredentialsKey, Equals, "secret")
+	c.Assert(config.AuthorizerUrl, Equals, "http://localhost:9000/authorize")
+	c.Assert(config.AuthorizerTimeout, Equals, time.Second)
+	c.Assert(config.AuthorizerFailOpen, Equals, true)
+
+	c.Assert(config.MonitoringEnabled, Equals, true)
+	c.Assert(config.MonitoringDatabase, Equals, "_internal")
+	c.Assert(config.MonitoringInterval, Equals, 30*time.Second)
+	c.Assert(config.MonitoringQuerySampleRate, Equals, 0.1)
+	c.Assert(config.ReporterEnabled, Equals, true)
+	c.Assert(config.ReporterProtocol, Equals, "statsd")
+	c.Assert(config.ReporterAddress, Equals, "localhost:8125")
+	c.Assert(config.ReporterPrefix, Equals, "influxdb")
+	c.Assert(config.ReporterInterval, Equals, 10*time.Second)
+	c.Assert(config.WebhookUrls, DeepEquals, []string{"http://localhost:9001/events"})
+	c.Assert(config.WebhookTimeout, Equals, 5*time.Second)
+	c.Assert(config.WebhookReplicaLagThreshold, Equals, 1000)
+
+	c.Assert(config.LongTermShard.LevelDbLruCacheSize(), Equals, 10*ONE_MEGABYTE)
+	c.Assert(config.LongTermShard.BloomFilterBits, Equals, 20)
+	c.Assert(config.ShortTermShard.LevelDbLruCacheSize(), Equals, 0)
 }
 
 func (self *LoadConfigurationSuite) TestSizeParsing(c *C) {
@@ -68,3 +178,49 @@ func (self *LoadConfigurationSuite) TestSizeParsing(c *C) {
 	c.Assert(s.UnmarshalText([]byte("10g")), IsNil)
 	c.Assert(s.int, Equals, 10*ONE_GIGABYTE)
 }
+
+func (self *LoadConfigurationSuite) TestParseConfiguration(c *C) {
+	config, err := ParseConfiguration("config.toml")
+	c.Assert(err, IsNil)
+	c.Assert(config.FileName, Equals, "config.toml")
+
+	_, err = ParseConfiguration("missing.toml")
+	c.Assert(err, NotNil)
+}
+
+func (self *LoadConfigurationSuite) TestCredentialsForAnyOriginAreRejected(c *C) {
+	file, err := ioutil.TempFile("", "influxdb-config")
+	c.Assert(err, IsNil)
+	defer os.Remove(file.Name())
+	fmt.Fprintln(file, "[api.cors]\nallowed-origins = [\"*\"]\nallow-credentials = true")
+	file.Close()
+
+	_, err = ParseConfiguration(file.Name())
+	c.Assert(err, ErrorMatches, ".*allow-credentials.*")
+}
+
+func (self *LoadConfigurationSuite) TestValidateConfiguration(c *C) {
+	// every key of the test config and of the sample config is read
+	for _, fileName := range []string{"config.toml", "../../config.sample.toml"} {
+		report, err := ValidateConfiguration(fileName)
+		c.Assert(err, IsNil)
+		c.Assert(report.UnknownKeys, HasLen, 0, Commentf("%s", fileName))
+		c.Assert(report.Config.FileName, Equals, fileName)
+	}
+
+	file, err := ioutil.TempFile("", "influxdb-config")
+	c.Assert(err, IsNil)
+	defer os.Remove(file.Name())
+	fmt.Fprintln(file, "hostnme = \"localhost\"")
+	fmt.Fprintln(file, "[api]\nread-timout = \"5s\"\n[api.cors]\nallowed-origns = []")
+	fmt.Fprintln(file, "[logging.levels]\nwal = \"warn\"\n[wall]\ndir = \"/tmp\"")
+	file.Close()
+
+	deprecatedKeys["logging.levels"] = "the levels of the modules"
+	defer delete(deprecatedKeys, "logging.levels")
+	report, err := ValidateConfiguration(file.Name())
+	c.Assert(err, IsNil)
+	c.Assert(report.HasProblems(), Equals, true)
+	c.Assert(report.UnknownKeys, DeepEquals, []string{"api.cors.allowed-origns", "api.read-timout", "hostnme", "wall"})
+	c.Assert(report.DeprecatedKeys, DeepEquals, map[string]string{"logging.levels": "the levels of the modules"})
+}
@@ -0,0 +1,99 @@
+package configuration
+
+import (
+	"encoding"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The keys that are still read but shouldn't be used anymore, with what
+// should be used instead. A key that is renamed is added here and keeps
+// working for a release before it's removed.
+var deprecatedKeys = map[string]string{}
+
+// What ValidateConfiguration found in a config file
+type ConfigurationReport struct {
+	// the configuration with the defaults of the keys that aren't set
+	Config *Configuration
+	// the keys that aren't read, e.g. typos or keys of another version,
+	// with their table, e.g. api.read-timout
+	UnknownKeys []string
+	// the deprecated keys that are set and what to use instead
+	DeprecatedKeys map[string]string
+}
+
+func (self *ConfigurationReport) HasProblems() bool {
+	return len(self.UnknownKeys) > 0 || len(self.DeprecatedKeys) > 0
+}
+
+// Parses the config file like ParseConfiguration and reports the keys
+// that are ignored or deprecated, the server silently ignores them
+func ValidateConfiguration(fileName string) (*ConfigurationReport, error) {
+	config, err := ParseConfiguration(fileName)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	if _, err := toml.Decode(string(body), &values); err != nil {
+		return nil, err
+	}
+
+	report := &ConfigurationReport{Config: config, DeprecatedKeys: map[string]string{}}
+	report.findKeys("", values, reflect.TypeOf(TomlConfiguration{}))
+	sort.Strings(report.UnknownKeys)
+	return report, nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func (self *ConfigurationReport) findKeys(table string, values map[string]interface{}, t reflect.Type) {
+	for key, value := range values {
+		name := key
+		if table != "" {
+			name = table + "." + key
+		}
+		if replacement, ok := deprecatedKeys[name]; ok {
+			self.DeprecatedKeys[name] = replacement
+		}
+		field, ok := findTomlField(t, key)
+		if !ok {
+			self.UnknownKeys = append(self.UnknownKeys, name)
+			continue
+		}
+		// the maps, e.g. logging.levels, accept any key and the sizes
+		// and durations are strings
+		if field.Type.Kind() != reflect.Struct || reflect.PtrTo(field.Type).Implements(textUnmarshalerType) {
+			continue
+		}
+		if subtable, ok := value.(map[string]interface{}); ok {
+			self.findKeys(name, subtable, field.Type)
+		}
+	}
+}
+
+// Returns the field the toml decoder sets for the key, it matches the
+// tag or the name of the field regardless of the case
+func findTomlField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Tag.Get("toml")
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
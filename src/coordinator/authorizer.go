@@ -0,0 +1,160 @@
+package coordinator
+
+import (
+	"bytes"
+	"common"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"parser"
+	"protocol"
+	"strings"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// The operations that are given to the authorizer
+const (
+	AUTHORIZE_READ        = "read"
+	AUTHORIZE_WRITE       = "write"
+	AUTHORIZE_DELETE      = "delete"
+	AUTHORIZE_DROP_SERIES = "drop_series"
+)
+
+// What the user is about to do, the built-in permissions are still
+// checked if the authorizer allows it
+type AuthorizationRequest struct {
+	User         string   `json:"user"`
+	ClusterAdmin bool     `json:"clusterAdmin"`
+	Database     string   `json:"database"`
+	Operation    string   `json:"operation"`
+	Series       []string `json:"series"`
+	Query        string   `json:"query,omitempty"`
+}
+
+// An external policy that can veto queries and writes, for the rules
+// the permissions of the users can't express
+type Authorizer interface {
+	// Returns an error if the request isn't allowed, the message of
+	// the error is returned to the user
+	Authorize(request *AuthorizationRequest) error
+}
+
+// Posts the authorization requests as json to a url. The request is
+// allowed if the response is a 2xx and denied with the body of the
+// response as the reason otherwise. If the url can't be reached the
+// request is denied, unless failOpen is set.
+type HttpAuthorizer struct {
+	url      string
+	failOpen bool
+	client   *http.Client
+}
+
+func NewHttpAuthorizer(url string, timeout time.Duration, failOpen bool) *HttpAuthorizer {
+	return &HttpAuthorizer{
+		url:      url,
+		failOpen: failOpen,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+func (self *HttpAuthorizer) Authorize(request *AuthorizationRequest) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	resp, err := self.client.Post(self.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return self.unavailable(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode >= 500 {
+		return self.unavailable(fmt.Errorf("%s returned %d", self.url, resp.StatusCode))
+	}
+	reason := strings.TrimSpace(string(body))
+	if reason == "" {
+		reason = "Denied by the authorization policy"
+	}
+	return common.NewAuthorizationError("%s", reason)
+}
+
+func (self *HttpAuthorizer) unavailable(err error) error {
+	if self.failOpen {
+		log.Warn("The authorizer is unavailable, allowing the request: %s", err)
+		return nil
+	}
+	log.Error("The authorizer is unavailable, denying the request: %s", err)
+	return common.NewAuthorizationError("The authorization policy is unavailable")
+}
+
+// Lets the authorizer veto the queries and writes that the permissions
+// of the users allow, nil disables it
+func (self *CoordinatorImpl) SetAuthorizer(authorizer Authorizer) {
+	self.authorizer = authorizer
+}
+
+func (self *CoordinatorImpl) authorize(user common.User, db, operation string, series []string, query string) error {
+	if self.authorizer == nil {
+		return nil
+	}
+	return self.authorizer.Authorize(&AuthorizationRequest{
+		User:         user.GetName(),
+		ClusterAdmin: user.IsClusterAdmin(),
+		Database:     db,
+		Operation:    operation,
+		Series:       series,
+		Query:        query,
+	})
+}
+
+// Asks the authorizer whether the user can run the query, list
+// queries are always allowed
+func (self *CoordinatorImpl) authorizeQuery(user common.User, db string, query *parser.Query) error {
+	switch {
+	case query.DeleteQuery != nil:
+		return self.authorize(user, db, AUTHORIZE_DELETE, fromClauseSeries(query.DeleteQuery.GetFromClause()), query.QueryString)
+	case query.DropSeriesQuery != nil:
+		return self.authorize(user, db, AUTHORIZE_DROP_SERIES, []string{query.DropSeriesQuery.GetTableName()}, query.QueryString)
+	case query.SelectQuery != nil:
+		return self.authorize(user, db, AUTHORIZE_READ, fromClauseSeries(query.SelectQuery.GetFromClause()), query.QueryString)
+	}
+	return nil
+}
+
+func (self *CoordinatorImpl) authorizeWrite(user common.User, db string, series []*protocol.Series) error {
+	if self.authorizer == nil {
+		return nil
+	}
+	names := make([]string, 0, len(series))
+	seen := make(map[string]bool, len(series))
+	for _, s := range series {
+		if !seen[s.GetName()] {
+			seen[s.GetName()] = true
+			names = append(names, s.GetName())
+		}
+	}
+	return self.authorize(user, db, AUTHORIZE_WRITE, names, "")
+}
+
+// Returns the names of the series in the from clause, regexes are
+// returned as /regex/
+func fromClauseSeries(from *parser.FromClause) []string {
+	if from == nil {
+		return nil
+	}
+	names := make([]string, 0, len(from.Names))
+	for _, table := range from.Names {
+		if _, ok := table.Name.GetCompiledRegex(); ok {
+			names = append(names, "/"+table.Name.Name+"/")
+			continue
+		}
+		names = append(names, table.Name.Name)
+	}
+	return names
+}
@@ -0,0 +1,60 @@
+package coordinator
+
+import (
+	"common"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"protocol"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type AuthorizerSuite struct{}
+
+var _ = Suite(&AuthorizerSuite{})
+
+func (self *AuthorizerSuite) TestHttpAuthorizer(c *C) {
+	requests := make(chan *AuthorizationRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		request := &AuthorizationRequest{}
+		json.Unmarshal(body, request)
+		requests <- request
+		if len(request.Series) > 0 && request.Series[0] == "pii" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("pii is off limits"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	coordinator := &CoordinatorImpl{}
+	coordinator.SetAuthorizer(NewHttpAuthorizer(server.URL, time.Second, false))
+	user := &MockUser{}
+	series := []*protocol.Series{subscriptionSeries("cpu", 1, 2), subscriptionSeries("cpu", 3, 4)}
+	c.Assert(coordinator.authorizeWrite(user, "db1", series), IsNil)
+	request := <-requests
+	c.Assert(request.User, Equals, "mockuser")
+	c.Assert(request.Database, Equals, "db1")
+	c.Assert(request.Operation, Equals, AUTHORIZE_WRITE)
+	c.Assert(request.Series, DeepEquals, []string{"cpu"})
+
+	err := coordinator.authorizeWrite(user, "db1", []*protocol.Series{subscriptionSeries("pii", 1, 2)})
+	c.Assert(err, FitsTypeOf, common.AuthorizationError(""))
+	c.Assert(err.Error(), Equals, "pii is off limits")
+}
+
+func (self *AuthorizerSuite) TestUnavailableAuthorizer(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	request := &AuthorizationRequest{User: "mockuser", Database: "db1", Operation: AUTHORIZE_READ}
+	c.Assert(NewHttpAuthorizer(server.URL, time.Second, false).Authorize(request), NotNil)
+	c.Assert(NewHttpAuthorizer(server.URL, time.Second, true).Authorize(request), IsNil)
+}
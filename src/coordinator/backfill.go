@@ -0,0 +1,207 @@
+package coordinator
+
+import (
+	"cluster"
+	"common"
+	"fmt"
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+const (
+	// the number of group by intervals a backfill queries at once if the
+	// chunk size isn't set
+	DEFAULT_BACKFILL_CHUNK_INTERVALS = 60
+)
+
+// The time range a continuous query is run over and how fast. The start
+// and the end are truncated to the group by time of the query, the
+// chunks are multiples of it.
+type BackfillOptions struct {
+	Start time.Time
+	// defaults to the last run of the continuous queries, the time after
+	// it is written by the continuous query itself
+	End time.Time
+	// the time range of a query, defaults to 60 group by intervals
+	ChunkSize time.Duration
+	// the number of chunks queried at once, defaults to 1
+	Concurrency int
+	// the maximum number of points written per second, 0 means unlimited
+	PointsPerSecond int
+}
+
+// Slows the writes of a backfill down to a number of points per second,
+// so it doesn't compete with the writes of the clients. The methods of a
+// nil throttle do nothing.
+type pointsThrottle struct {
+	lock            sync.Mutex
+	pointsPerSecond int
+	start           time.Time
+	points          int64
+}
+
+func newPointsThrottle(pointsPerSecond int) *pointsThrottle {
+	if pointsPerSecond <= 0 {
+		return nil
+	}
+	return &pointsThrottle{pointsPerSecond: pointsPerSecond, start: time.Now()}
+}
+
+// Counts the points and sleeps until the rate is back under the limit
+func (self *pointsThrottle) wait(points int) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	self.points += int64(points)
+	expected := time.Duration(float64(self.points) / float64(self.pointsPerSecond) * float64(time.Second))
+	delay := expected - time.Since(self.start)
+	self.lock.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+type backfillChunk struct {
+	start time.Time
+	end   time.Time
+}
+
+// Splits [start, end) into chunks of the given size, the last one can be
+// shorter
+func backfillChunks(start, end time.Time, size time.Duration) []backfillChunk {
+	chunks := []backfillChunk{}
+	for chunkStart := start; chunkStart.Before(end); chunkStart = chunkStart.Add(size) {
+		chunkEnd := chunkStart.Add(size)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+		chunks = append(chunks, backfillChunk{chunkStart, chunkEnd})
+	}
+	return chunks
+}
+
+// Runs the continuous query over a time range that is already written,
+// e.g. to populate a rollup that was created after the points were
+// written. The points are written like the ones of the continuous
+// query, running a backfill twice over the same range overwrites them.
+// The backfill runs in the background as a job that can be cancelled,
+// the job is returned.
+func (s *RaftServer) BackfillContinuousQuery(db string, id uint32, options *BackfillOptions) (*cluster.Job, error) {
+	query := s.clusterConfig.ParsedContinuousQueries[db][id]
+	if query == nil {
+		return nil, fmt.Errorf("Continuous query %d of %s doesn't exist", id, db)
+	}
+	if query.GetGroupByClause().Elems == nil {
+		return nil, fmt.Errorf("Continuous query %d of %s doesn't have a group by time, it can't be backfilled", id, db)
+	}
+	duration, err := query.GetGroupByClause().GetGroupByTime()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get group by time for continuous query: %s", err)
+	}
+	if duration == nil {
+		return nil, fmt.Errorf("Continuous query %d of %s doesn't have a group by time, it can't be backfilled", id, db)
+	}
+	if options.Start.IsZero() {
+		return nil, fmt.Errorf("The start of the backfill must be set")
+	}
+
+	start := options.Start.Truncate(*duration)
+	end := options.End
+	if end.IsZero() {
+		end = s.clusterConfig.LastContinuousQueryRunTime()
+		if end.IsZero() {
+			end = common.Now()
+		}
+	}
+	end = end.Truncate(*duration)
+	if !start.Before(end) {
+		return nil, fmt.Errorf("The start of the backfill must be before its end")
+	}
+
+	chunkSize := options.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DEFAULT_BACKFILL_CHUNK_INTERVALS * *duration
+	}
+	chunkSize -= chunkSize % *duration
+	if chunkSize < *duration {
+		chunkSize = *duration
+	}
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	target := fmt.Sprintf("%s/%d from %s to %s", db, id, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	job := s.clusterConfig.Jobs().Add("backfill", target, true)
+	go s.backfill(job, db, id, backfillChunks(start, end, chunkSize), concurrency, newPointsThrottle(options.PointsPerSecond))
+	return job, nil
+}
+
+// Queries the chunks with the given number of workers, the backfill
+// stops at the first error
+func (s *RaftServer) backfill(job *cluster.Job, db string, id uint32, chunks []backfillChunk, concurrency int, throttle *pointsThrottle) {
+	job.Start()
+	log.Info("Backfilling continuous query %d of %s in %d chunks", id, db, len(chunks))
+
+	pending := make(chan backfillChunk)
+	var lock sync.Mutex
+	var firstErr error
+	done := 0
+	failed := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return firstErr != nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range pending {
+				// the query can be deleted while the backfill runs
+				query := s.clusterConfig.ParsedContinuousQueries[db][id]
+				var err error
+				if query == nil {
+					err = fmt.Errorf("Continuous query %d of %s was deleted", id, db)
+				} else {
+					err = s.runContinuousQueryWithThrottle(db, query, chunk.start, chunk.end, throttle)
+				}
+				lock.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("Cannot backfill from %s to %s: %s", chunk.start.Format(time.RFC3339), chunk.end.Format(time.RFC3339), err)
+				}
+				done++
+				job.SetProgress(done, len(chunks))
+				lock.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for _, chunk := range chunks {
+		if failed() {
+			break
+		}
+		select {
+		case pending <- chunk:
+		case <-job.Cancelled():
+			break dispatch
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	switch {
+	case firstErr != nil:
+		log.Error("Backfill of continuous query %d of %s failed: %s", id, db, firstErr)
+	case job.IsCancelled():
+		log.Info("Backfill of continuous query %d of %s cancelled", id, db)
+	default:
+		log.Info("Backfilled continuous query %d of %s", id, db)
+	}
+	job.Finish(firstErr)
+}
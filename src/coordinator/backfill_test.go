@@ -0,0 +1,34 @@
+package coordinator
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type BackfillSuite struct{}
+
+var _ = Suite(&BackfillSuite{})
+
+func (self *BackfillSuite) TestBackfillChunks(c *C) {
+	start := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	chunks := backfillChunks(start, start.Add(150*time.Minute), time.Hour)
+	c.Assert(chunks, HasLen, 3)
+	c.Assert(chunks[0].start, Equals, start)
+	c.Assert(chunks[1].start, Equals, start.Add(time.Hour))
+	c.Assert(chunks[2].start, Equals, start.Add(2*time.Hour))
+	c.Assert(chunks[2].end, Equals, start.Add(150*time.Minute))
+	c.Assert(backfillChunks(start, start, time.Hour), HasLen, 0)
+}
+
+func (self *BackfillSuite) TestPointsThrottle(c *C) {
+	c.Assert(newPointsThrottle(0), IsNil)
+	// a nil throttle doesn't wait
+	var throttle *pointsThrottle
+	throttle.wait(1000000)
+
+	throttle = newPointsThrottle(1000)
+	start := time.Now()
+	throttle.wait(100)
+	c.Assert(time.Since(start) >= 90*time.Millisecond, Equals, true)
+}
@@ -20,12 +20,18 @@ func init() {
 		&DropDatabaseCommand{},
 		&SaveDbUserCommand{},
 		&SaveClusterAdminCommand{},
+		&SaveApiKeyCommand{},
+		&SaveWriteSubscriptionCommand{},
+		&SaveRoleCommand{},
+		&SaveDatabaseQuotaCommand{},
+		&SaveTenantCommand{},
 		&ChangeDbUserPassword{},
 		&CreateContinuousQueryCommand{},
 		&DeleteContinuousQueryCommand{},
 		&SetContinuousQueryTimestampCommand{},
 		&CreateShardsCommand{},
 		&DropShardCommand{},
+		&AddShardReplicaCommand{},
 	} {
 		internalRaftCommands[command.CommandName()] = command
 	}
@@ -33,10 +39,12 @@ func init() {
 
 type SetContinuousQueryTimestampCommand struct {
 	Timestamp time.Time `json:"timestamp"`
+	// the continuous queries that ran up to the timestamp
+	Runs []*cluster.ContinuousQueryRun `json:"runs,omitempty"`
 }
 
-func NewSetContinuousQueryTimestampCommand(timestamp time.Time) *SetContinuousQueryTimestampCommand {
-	return &SetContinuousQueryTimestampCommand{timestamp}
+func NewSetContinuousQueryTimestampCommand(timestamp time.Time, runs []*cluster.ContinuousQueryRun) *SetContinuousQueryTimestampCommand {
+	return &SetContinuousQueryTimestampCommand{timestamp, runs}
 }
 
 func (c *SetContinuousQueryTimestampCommand) CommandName() string {
@@ -46,6 +54,7 @@ func (c *SetContinuousQueryTimestampCommand) CommandName() string {
 func (c *SetContinuousQueryTimestampCommand) Apply(server raft.Server) (interface{}, error) {
 	config := server.Context().(*cluster.ClusterConfiguration)
 	err := config.SetContinuousQueryTimestamp(c.Timestamp)
+	config.RecordContinuousQueryRuns(c.Runs)
 	return nil, err
 }
 
@@ -140,7 +149,11 @@ func (c *SaveDbUserCommand) CommandName() string {
 
 func (c *SaveDbUserCommand) Apply(server raft.Server) (interface{}, error) {
 	config := server.Context().(*cluster.ClusterConfiguration)
-	config.SaveDbUser(c.User)
+	user, err := c.User.Decrypted()
+	if err != nil {
+		return nil, err
+	}
+	config.SaveDbUser(user)
 	log.Debug("(raft:%s) Created user %s:%s", server.Name(), c.User.Db, c.User.Name)
 	return nil, nil
 }
@@ -166,7 +179,11 @@ func (c *ChangeDbUserPassword) CommandName() string {
 func (c *ChangeDbUserPassword) Apply(server raft.Server) (interface{}, error) {
 	log.Debug("(raft:%s) changing db user password for %s:%s", server.Name(), c.Database, c.Username)
 	config := server.Context().(*cluster.ClusterConfiguration)
-	return nil, config.ChangeDbUserPassword(c.Database, c.Username, c.Hash)
+	hash, err := cluster.DecryptCredential(c.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return nil, config.ChangeDbUserPassword(c.Database, c.Username, hash)
 }
 
 type SaveClusterAdminCommand struct {
@@ -185,7 +202,115 @@ func (c *SaveClusterAdminCommand) CommandName() string {
 
 func (c *SaveClusterAdminCommand) Apply(server raft.Server) (interface{}, error) {
 	config := server.Context().(*cluster.ClusterConfiguration)
-	config.SaveClusterAdmin(c.User)
+	user, err := c.User.Decrypted()
+	if err != nil {
+		return nil, err
+	}
+	config.SaveClusterAdmin(user)
+	return nil, nil
+}
+
+type SaveApiKeyCommand struct {
+	Key *cluster.ApiKey `json:"key"`
+}
+
+func NewSaveApiKeyCommand(key *cluster.ApiKey) *SaveApiKeyCommand {
+	return &SaveApiKeyCommand{
+		Key: key,
+	}
+}
+
+func (c *SaveApiKeyCommand) CommandName() string {
+	return "save_api_key"
+}
+
+func (c *SaveApiKeyCommand) Apply(server raft.Server) (interface{}, error) {
+	config := server.Context().(*cluster.ClusterConfiguration)
+	key, err := c.Key.Decrypted()
+	if err != nil {
+		return nil, err
+	}
+	config.SaveApiKey(key)
+	return nil, nil
+}
+
+type SaveWriteSubscriptionCommand struct {
+	Subscription *cluster.WriteSubscription `json:"subscription"`
+}
+
+func NewSaveWriteSubscriptionCommand(subscription *cluster.WriteSubscription) *SaveWriteSubscriptionCommand {
+	return &SaveWriteSubscriptionCommand{
+		Subscription: subscription,
+	}
+}
+
+func (c *SaveWriteSubscriptionCommand) CommandName() string {
+	return "save_write_subscription"
+}
+
+func (c *SaveWriteSubscriptionCommand) Apply(server raft.Server) (interface{}, error) {
+	config := server.Context().(*cluster.ClusterConfiguration)
+	config.SaveWriteSubscription(c.Subscription)
+	return nil, nil
+}
+
+type SaveDatabaseQuotaCommand struct {
+	Quota *cluster.DatabaseQuota `json:"quota"`
+}
+
+func NewSaveDatabaseQuotaCommand(quota *cluster.DatabaseQuota) *SaveDatabaseQuotaCommand {
+	return &SaveDatabaseQuotaCommand{
+		Quota: quota,
+	}
+}
+
+func (c *SaveDatabaseQuotaCommand) CommandName() string {
+	return "save_database_quota"
+}
+
+func (c *SaveDatabaseQuotaCommand) Apply(server raft.Server) (interface{}, error) {
+	config := server.Context().(*cluster.ClusterConfiguration)
+	config.SaveDatabaseQuota(c.Quota)
+	return nil, nil
+}
+
+type SaveTenantCommand struct {
+	Tenant *cluster.Tenant `json:"tenant"`
+}
+
+func NewSaveTenantCommand(tenant *cluster.Tenant) *SaveTenantCommand {
+	return &SaveTenantCommand{
+		Tenant: tenant,
+	}
+}
+
+func (c *SaveTenantCommand) CommandName() string {
+	return "save_tenant"
+}
+
+func (c *SaveTenantCommand) Apply(server raft.Server) (interface{}, error) {
+	config := server.Context().(*cluster.ClusterConfiguration)
+	config.SaveTenant(c.Tenant)
+	return nil, nil
+}
+
+type SaveRoleCommand struct {
+	Role *cluster.Role `json:"role"`
+}
+
+func NewSaveRoleCommand(role *cluster.Role) *SaveRoleCommand {
+	return &SaveRoleCommand{
+		Role: role,
+	}
+}
+
+func (c *SaveRoleCommand) CommandName() string {
+	return "save_role"
+}
+
+func (c *SaveRoleCommand) Apply(server raft.Server) (interface{}, error) {
+	config := server.Context().(*cluster.ClusterConfiguration)
+	config.SaveRole(c.Role)
 	return nil, nil
 }
 
@@ -282,3 +407,25 @@ func (c *DropShardCommand) Apply(server raft.Server) (interface{}, error) {
 	err := config.DropShard(c.ShardId, c.ServerIds)
 	return nil, err
 }
+
+// Adds a replica of the shard on the server, the replica on MoveFrom is
+// dropped once the shard is copied unless MoveFrom is 0
+type AddShardReplicaCommand struct {
+	ShardId  uint32
+	ServerId uint32
+	MoveFrom uint32
+}
+
+func NewAddShardReplicaCommand(id, serverId, moveFrom uint32) *AddShardReplicaCommand {
+	return &AddShardReplicaCommand{ShardId: id, ServerId: serverId, MoveFrom: moveFrom}
+}
+
+func (c *AddShardReplicaCommand) CommandName() string {
+	return "add_shard_replica"
+}
+
+func (c *AddShardReplicaCommand) Apply(server raft.Server) (interface{}, error) {
+	config := server.Context().(*cluster.ClusterConfiguration)
+	err := config.AddShardReplica(c.ShardId, c.ServerId, c.MoveFrom)
+	return nil, err
+}
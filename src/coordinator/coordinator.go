@@ -4,6 +4,7 @@ import (
 	"cluster"
 	"common"
 	"configuration"
+	"crypto/subtle"
 	"engine"
 	"fmt"
 	"math"
@@ -21,6 +22,14 @@ type CoordinatorImpl struct {
 	clusterConfiguration *cluster.ClusterConfiguration
 	raftServer           ClusterConsensus
 	config               *configuration.Configuration
+	subscriptions        subscriptions
+	forwarder            *WriteForwarder
+	// nil if there's no external authorizer
+	authorizer Authorizer
+	quotas     *QuotaTracker
+	// guards the settings of config that are changed when the
+	// configuration is reloaded
+	settingsLock sync.RWMutex
 }
 
 const (
@@ -71,22 +80,38 @@ func NewCoordinatorImpl(config *configuration.Configuration, raftServer ClusterC
 		config:               config,
 		clusterConfiguration: clusterConfiguration,
 		raftServer:           raftServer,
+		forwarder:            NewWriteForwarder(config.SubscriptionBufferSize, config.SubscriptionMaxRetries),
+		quotas:               NewQuotaTracker(),
 	}
+	coordinator.quotas.servers = func() int { return len(clusterConfiguration.Servers()) }
 
 	return coordinator
 }
 
 func (self *CoordinatorImpl) RunQuery(user common.User, database string, queryString string, seriesWriter SeriesWriter) (err error) {
-	log.Info("Query: db: %s, u: %s, q: %s", database, user.GetName(), queryString)
+	log.Info("Query: db: %s, u: %s, q: %s, request: %s", database, user.GetName(), queryString, common.RequestId(user))
 	// don't let a panic pass beyond RunQuery
 	defer common.RecoverFunc(database, queryString, nil)
+	queriesExecuted.Inc()
+	defer func(start time.Time) {
+		queryLatency.Since(start)
+		if err != nil {
+			queryErrors.Inc()
+		}
+	}(time.Now())
 
+	parseStart := time.Now()
 	q, err := parser.ParseQuery(queryString)
 	if err != nil {
 		return err
 	}
+	self.traceStage(user, "parse", 0, parseStart)
 
 	for _, query := range q {
+		if err := self.authorizeQuery(user, database, query); err != nil {
+			return err
+		}
+
 		querySpec := parser.NewQuerySpec(user, database, query)
 
 		if query.DeleteQuery != nil {
@@ -107,6 +132,27 @@ func (self *CoordinatorImpl) RunQuery(user common.User, database string, querySt
 			continue
 		}
 
+		if query.ShowGrantsQuery != nil {
+			if err := self.runShowGrantsQuery(user, database, query.ShowGrantsQuery.GetUser(), seriesWriter); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if query.IsShowStatsQuery() {
+			if err := self.runShowStatsQuery(user, seriesWriter); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if query.IsShowDiagnosticsQuery() {
+			if err := self.runShowDiagnosticsQuery(user, seriesWriter); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if query.IsListQuery() {
 			if query.IsListSeriesQuery() {
 				self.runListSeriesQuery(querySpec, seriesWriter)
@@ -321,6 +367,7 @@ func (self *CoordinatorImpl) getShardsAndProcessor(querySpec *parser.QuerySpec,
 func (self *CoordinatorImpl) readFromResposneChannels(processor cluster.QueryProcessor,
 	writer SeriesWriter,
 	isExplainQuery bool,
+	pointsScanned *int64,
 	errors chan<- error,
 	channels <-chan (<-chan *protocol.Response)) {
 
@@ -346,6 +393,7 @@ func (self *CoordinatorImpl) readFromResposneChannels(processor cluster.QueryPro
 				log.Debug("Series has no points, continue")
 				continue
 			}
+			*pointsScanned += int64(len(response.Series.Points))
 
 			// if we don't have a processor, yield the point to the writer
 			// this happens if shard took care of the query
@@ -394,6 +442,10 @@ func (self *CoordinatorImpl) queryShards(querySpec *parser.QuerySpec, shards []*
 		// We query shards for data and stream them to query processor
 		log.Debug("QUERYING: shard: ", i, shard.String())
 		go shard.Query(querySpec, responseChan)
+		if trace := common.GetTrace(querySpec.User()); trace != nil {
+			responseChannels <- self.traceShardResponses(trace, shard.Id(), responseChan, bufferSize)
+			continue
+		}
 		responseChannels <- responseChan
 	}
 
@@ -401,15 +453,35 @@ func (self *CoordinatorImpl) queryShards(querySpec *parser.QuerySpec, shards []*
 }
 
 func (self *CoordinatorImpl) runQuerySpec(querySpec *parser.QuerySpec, seriesWriter SeriesWriter) error {
+	start := time.Now()
+	var sampled *countingSeriesWriter
+	if self.sampleQuery() {
+		sampled = &countingSeriesWriter{SeriesWriter: seriesWriter}
+		seriesWriter = sampled
+	}
 	shards, processor, seriesClosed, err := self.getShardsAndProcessor(querySpec, seriesWriter)
 	if err != nil {
 		return err
 	}
+	self.traceStage(querySpec.User(), "plan", 0, start)
+
+	// only written by readFromResposneChannels, which is done once the
+	// errors channel is closed
+	var pointsScanned int64
+	defer func() {
+		self.traceStage(querySpec.User(), "query", 0, start)
+		self.logSlowQuery(querySpec, len(shards), pointsScanned, time.Since(start))
+		if sampled != nil {
+			self.recordQuery(querySpec, time.Since(start), sampled.Points())
+		}
+	}()
 
 	defer func() {
 		if processor != nil {
+			mergeStart := time.Now()
 			processor.Close()
 			<-seriesClosed
+			self.traceStage(querySpec.User(), "merge", 0, mergeStart)
 		} else {
 			seriesWriter.Close()
 		}
@@ -428,7 +500,7 @@ func (self *CoordinatorImpl) runQuerySpec(querySpec *parser.QuerySpec, seriesWri
 	}
 	responseChannels := make(chan (<-chan *protocol.Response), shardConcurrentLimit)
 
-	go self.readFromResposneChannels(processor, seriesWriter, querySpec.IsExplainQuery(), errors, responseChannels)
+	go self.readFromResposneChannels(processor, seriesWriter, querySpec.IsExplainQuery(), &pointsScanned, errors, responseChannels)
 
 	err = self.queryShards(querySpec, shards, errors, responseChannels)
 
@@ -465,11 +537,46 @@ func (self *CoordinatorImpl) WriteSeriesData(user common.User, db string, series
 	if !user.HasWriteAccess(db) {
 		return common.NewAuthorizationError("Insufficient permissions to write to %s", db)
 	}
+	if err := self.authorizeWrite(user, db, series); err != nil {
+		return err
+	}
 
-	err := self.CommitSeriesData(db, series)
+	points := countPoints(series)
+	var bytes int64
+	var names []string
+	quota := self.clusterConfiguration.GetDatabaseQuota(db)
+	tenant := self.clusterConfiguration.GetDatabaseTenant(db)
+	if quota != nil || tenant != nil {
+		bytes, names = seriesSizeAndNames(series)
+	}
+	if err := self.quotas.Reserve(quota, points, bytes, names); err != nil {
+		quotaRejections.Inc()
+		return err
+	}
+	if err := self.quotas.CheckTenant(tenant, db, points, names); err != nil {
+		self.quotas.Release(quota, points, bytes)
+		quotaRejections.Inc()
+		return err
+	}
+
+	writeRequests.Inc()
+	start := time.Now()
+	err := self.commitSeriesData(db, series, common.RequestId(user))
+	writeLatency.Since(start)
 	if err != nil {
+		self.quotas.Release(quota, points, bytes)
+		writeErrors.Inc()
 		return err
 	}
+	if tenant != nil && tenant.MaxSeries > 0 {
+		self.quotas.AddSeries(db, names)
+	}
+	pointsWritten.Mark(points)
+	pointsWrittenByDb.Add(db, points)
+
+	if subscriptions := self.clusterConfiguration.GetDatabaseWriteSubscriptions(db); len(subscriptions) > 0 {
+		self.forwarder.Forward(series, subscriptions)
+	}
 
 	for _, s := range series {
 		self.ProcessContinuousQueries(db, s)
@@ -571,6 +678,10 @@ func (self *CoordinatorImpl) InterpolateValuesAndCommit(query string, db string,
 }
 
 func (self *CoordinatorImpl) CommitSeriesData(db string, serieses []*protocol.Series) error {
+	return self.commitSeriesData(db, serieses, "")
+}
+
+func (self *CoordinatorImpl) commitSeriesData(db string, serieses []*protocol.Series, requestId string) error {
 	now := common.CurrentTime()
 
 	shardToSerieses := map[uint32]map[string]*protocol.Series{}
@@ -626,18 +737,22 @@ func (self *CoordinatorImpl) CommitSeriesData(db string, serieses []*protocol.Se
 			seriesesSlice = append(seriesesSlice, s)
 		}
 
-		err := self.write(db, seriesesSlice, shard)
+		err := self.write(db, seriesesSlice, shard, requestId)
 		if err != nil {
-			log.Error("COORD error writing: ", err)
+			log.Error("COORD error writing (request %s): %s", requestId, err)
 			return err
 		}
 	}
 
+	self.publish(db, serieses)
 	return nil
 }
 
-func (self *CoordinatorImpl) write(db string, series []*protocol.Series, shard cluster.Shard) error {
+func (self *CoordinatorImpl) write(db string, series []*protocol.Series, shard cluster.Shard, requestId string) error {
 	request := &protocol.Request{Type: &write, Database: &db, MultiSeries: series}
+	if requestId != "" {
+		request.RequestId = &requestId
+	}
 	return shard.Write(request)
 }
 
@@ -671,6 +786,7 @@ func (self *CoordinatorImpl) ListContinuousQueries(user common.User, db string)
 	}
 
 	queries := self.clusterConfiguration.GetContinuousQueries(db)
+	checkpoint := self.clusterConfiguration.LastContinuousQueryRunTime()
 	points := []*protocol.Point{}
 
 	for _, query := range queries {
@@ -678,10 +794,29 @@ func (self *CoordinatorImpl) ListContinuousQueries(user common.User, db string)
 		queryString := query.Query
 		timestamp := time.Now().Unix()
 		sequenceNumber := uint64(1)
+		// the queries without a group by time run on every write, they
+		// don't have a checkpoint or runs
+		lastCheckpoint := &protocol.FieldValue{}
+		lastRunDuration := &protocol.FieldValue{}
+		var errors int64
+		if self.hasGroupByTime(db, query.Id) {
+			if !checkpoint.IsZero() {
+				seconds := checkpoint.Unix()
+				lastCheckpoint.Int64Value = &seconds
+			}
+			if stats := self.clusterConfiguration.GetContinuousQueryStats(db, query.Id); stats != nil {
+				duration := float64(stats.LastRunDuration) / float64(time.Millisecond)
+				lastRunDuration.DoubleValue = &duration
+				errors = stats.Errors
+			}
+		}
 		points = append(points, &protocol.Point{
 			Values: []*protocol.FieldValue{
 				&protocol.FieldValue{Int64Value: &queryId},
 				&protocol.FieldValue{StringValue: &queryString},
+				lastCheckpoint,
+				lastRunDuration,
+				&protocol.FieldValue{Int64Value: &errors},
 			},
 			Timestamp:      &timestamp,
 			SequenceNumber: &sequenceNumber,
@@ -690,15 +825,76 @@ func (self *CoordinatorImpl) ListContinuousQueries(user common.User, db string)
 	seriesName := "continuous queries"
 	series := []*protocol.Series{&protocol.Series{
 		Name:   &seriesName,
-		Fields: []string{"id", "query"},
+		Fields: []string{"id", "query", "last_checkpoint", "last_run_duration_ms", "errors"},
 		Points: points,
 	}}
 	return series, nil
 }
 
+func (self *CoordinatorImpl) hasGroupByTime(db string, id uint32) bool {
+	query := self.clusterConfiguration.ParsedContinuousQueries[db][id]
+	if query == nil {
+		return false
+	}
+	return query.GetGroupByClause().Elems != nil
+}
+
+// Writes the permissions of the db user or cluster admin, one point for
+// the user itself and one for every role. Users can see their own
+// grants, db admins the grants of the users of their database.
+func (self *CoordinatorImpl) runShowGrantsQuery(user common.User, db, name string, seriesWriter SeriesWriter) error {
+	isSelf := user.GetName() == name && (user.IsClusterAdmin() || user.GetDb() == db)
+	if !isSelf && !user.IsClusterAdmin() && !user.IsDbAdmin(db) {
+		return common.NewAuthorizationError("Insufficient permissions to show the grants of %s", name)
+	}
+
+	var grants []*cluster.Grant
+	if dbUser := self.clusterConfiguration.GetDbUser(db, name); dbUser != nil {
+		grants = dbUser.Grants()
+	} else if admin := self.clusterConfiguration.GetClusterAdmin(name); admin != nil && (isSelf || user.IsClusterAdmin()) {
+		grants = admin.Grants()
+	} else {
+		return fmt.Errorf("User %s doesn't exist", name)
+	}
+
+	points := make([]*protocol.Point, 0, len(grants))
+	timestamp := time.Now().Unix()
+	for _, grant := range grants {
+		source := grant.Source
+		database := grant.Database
+		read := cluster.FormatMatchers(grant.ReadFrom)
+		write := cluster.FormatMatchers(grant.WriteTo)
+		isAdmin := grant.IsAdmin
+		canCreateDatabases := grant.CanCreateDatabases
+		sequenceNumber := uint64(len(points) + 1)
+		points = append(points, &protocol.Point{
+			Values: []*protocol.FieldValue{
+				&protocol.FieldValue{StringValue: &source},
+				&protocol.FieldValue{StringValue: &database},
+				&protocol.FieldValue{StringValue: &read},
+				&protocol.FieldValue{StringValue: &write},
+				&protocol.FieldValue{BoolValue: &isAdmin},
+				&protocol.FieldValue{BoolValue: &canCreateDatabases},
+			},
+			Timestamp:      &timestamp,
+			SequenceNumber: &sequenceNumber,
+		})
+	}
+	seriesName := "grants"
+	return seriesWriter.Write(&protocol.Series{
+		Name:   &seriesName,
+		Fields: []string{"source", "database", "read", "write", "admin", "can_create_databases"},
+		Points: points,
+	})
+}
+
 func (self *CoordinatorImpl) CreateDatabase(user common.User, db string, replicationFactor uint8) error {
+	var creator *cluster.DbUser
 	if !user.IsClusterAdmin() {
-		return common.NewAuthorizationError("Insufficient permissions to create database")
+		creator = self.clusterConfiguration.GetDbUser(user.GetDb(), user.GetName())
+		if creator == nil || !creator.CanCreateDatabases {
+			return common.NewAuthorizationError("Insufficient permissions to create database")
+		}
 	}
 
 	if !isValidName(db) {
@@ -709,7 +905,40 @@ func (self *CoordinatorImpl) CreateDatabase(user common.User, db string, replica
 	if err != nil {
 		return err
 	}
-	return nil
+	if creator == nil {
+		return nil
+	}
+
+	// the databases that the users of a tenant create belong to it, the
+	// database is dropped if it can't be added to the tenant so that it
+	// doesn't escape the limits of the tenant
+	if tenant := self.clusterConfiguration.GetDatabaseTenant(creator.Db); tenant != nil {
+		if err := self.addTenantDatabase(tenant, db); err != nil {
+			log.Error("Cannot add the database %s to tenant %s, dropping it: %s", db, tenant.Name, err)
+			if dropErr := self.raftServer.DropDatabase(db); dropErr != nil {
+				log.Error("Cannot drop the database %s: %s", db, dropErr)
+			}
+			return err
+		}
+	}
+
+	// db users that create a database become its admin, they log in
+	// with the same password. The privilege to create databases isn't
+	// copied, it stays with the user it was granted to so that revoking
+	// it takes effect everywhere
+	matchers := []*cluster.Matcher{&cluster.Matcher{true, ".*"}}
+	log.Info("Making %s:%s an admin of the database %s it created", creator.Db, creator.Name, db)
+	return self.raftServer.SaveDbUser(&cluster.DbUser{
+		CommonUser: cluster.CommonUser{
+			Name:     creator.Name,
+			Hash:     creator.Hash,
+			CacheKey: db + "%" + creator.Name,
+		},
+		Db:       db,
+		WriteTo:  matchers,
+		ReadFrom: matchers,
+		IsAdmin:  true,
+	})
 }
 
 func (self *CoordinatorImpl) ListDatabases(user common.User) ([]*cluster.Database, error) {
@@ -751,12 +980,61 @@ func (self *CoordinatorImpl) AuthenticateDbUser(db, username, password string) (
 	user, err := self.clusterConfiguration.AuthenticateDbUser(db, username, password)
 	if user != nil {
 		log.Debug("(raft:%s) User %s authenticated succesfully", self.raftServer.(*RaftServer).raftServer.Name(), username)
+		if dbUser := self.clusterConfiguration.GetDbUser(db, username); dbUser != nil && dbUser.NeedsRehash(password) {
+			go self.rehashDbUserPassword(dbUser, password)
+		}
 	}
 	return user, err
 }
 
 func (self *CoordinatorImpl) AuthenticateClusterAdmin(username, password string) (common.User, error) {
-	return self.clusterConfiguration.AuthenticateClusterAdmin(username, password)
+	user, err := self.clusterConfiguration.AuthenticateClusterAdmin(username, password)
+	if user != nil {
+		if admin := self.clusterConfiguration.GetClusterAdmin(username); admin != nil && admin.NeedsRehash(password) {
+			go self.rehashClusterAdminPassword(admin, password)
+		}
+	}
+	return user, err
+}
+
+// Replaces the hash of the user with one that uses the current cost,
+// it's called after the user authenticated with its password
+func (self *CoordinatorImpl) rehashDbUserPassword(user *cluster.DbUser, password string) {
+	hash, err := cluster.HashPassword(password)
+	if err != nil {
+		log.Error("Cannot rehash the password of %s:%s: %s", user.Db, user.Name, err)
+		return
+	}
+	updated := *user
+	updated.Hash = string(hash)
+	if err := self.raftServer.SaveDbUser(&updated); err != nil {
+		log.Error("Cannot save the new password hash of %s:%s: %s", user.Db, user.Name, err)
+		return
+	}
+	log.Info("Rehashed the password of %s:%s", user.Db, user.Name)
+}
+
+func (self *CoordinatorImpl) rehashClusterAdminPassword(user *cluster.ClusterAdmin, password string) {
+	hash, err := cluster.HashPassword(password)
+	if err != nil {
+		log.Error("Cannot rehash the password of cluster admin %s: %s", user.Name, err)
+		return
+	}
+	updated := *user
+	updated.Hash = string(hash)
+	if err := self.raftServer.SaveClusterAdminUser(&updated); err != nil {
+		log.Error("Cannot save the new password hash of cluster admin %s: %s", user.Name, err)
+		return
+	}
+	log.Info("Rehashed the password of cluster admin %s", user.Name)
+}
+
+func (self *CoordinatorImpl) LookupDbUser(db, username string) (common.User, error) {
+	return self.clusterConfiguration.LookupDbUser(db, username)
+}
+
+func (self *CoordinatorImpl) LookupClusterAdmin(username string) (common.User, error) {
+	return self.clusterConfiguration.LookupClusterAdmin(username)
 }
 
 func (self *CoordinatorImpl) ListClusterAdmins(requester common.User) ([]string, error) {
@@ -785,7 +1063,7 @@ func (self *CoordinatorImpl) CreateClusterAdminUser(requester common.User, usern
 		return fmt.Errorf("User %s already exists", username)
 	}
 
-	return self.raftServer.SaveClusterAdminUser(&cluster.ClusterAdmin{cluster.CommonUser{Name: username, CacheKey: username, Hash: string(hash)}})
+	return self.raftServer.SaveClusterAdminUser(&cluster.ClusterAdmin{CommonUser: cluster.CommonUser{Name: username, CacheKey: username, Hash: string(hash)}})
 }
 
 func (self *CoordinatorImpl) DeleteClusterAdminUser(requester common.User, username string) error {
@@ -820,6 +1098,112 @@ func (self *CoordinatorImpl) ChangeClusterAdminPassword(requester common.User, u
 	return self.raftServer.SaveClusterAdminUser(user)
 }
 
+// Restricts the networks the cluster admin can authenticate from, an
+// empty list allows any network
+func (self *CoordinatorImpl) SetClusterAdminAllowedNetworks(requester common.User, username string, networks []string) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	user := self.clusterConfiguration.GetClusterAdmin(username)
+	if user == nil {
+		return fmt.Errorf("Invalid user name %s", username)
+	}
+	parsed, err := cluster.ParseAllowedNetworks(networks)
+	if err != nil {
+		return err
+	}
+	updated := *user
+	updated.AllowedNetworks = parsed
+	return self.raftServer.SaveClusterAdminUser(&updated)
+}
+
+// Starts the totp enrollment of the cluster admin, returns the secret
+// that has to be confirmed with ConfirmClusterAdminTotp. Admins can
+// only enroll themselves.
+func (self *CoordinatorImpl) EnrollClusterAdminTotp(requester common.User, username string) (string, error) {
+	if !requester.IsClusterAdmin() || requester.GetName() != username {
+		return "", common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	user := self.clusterConfiguration.GetClusterAdmin(username)
+	if user == nil {
+		return "", fmt.Errorf("Invalid user name %s", username)
+	}
+	secret, err := cluster.GenerateTotpSecret()
+	if err != nil {
+		return "", err
+	}
+	updated := *user
+	updated.PendingTotpSecret = secret
+	return secret, self.raftServer.SaveClusterAdminUser(&updated)
+}
+
+// Enables the pending totp secret if the code is valid, returns the
+// recovery codes. They're only stored as hashes so this is the only
+// time they can be shown.
+func (self *CoordinatorImpl) ConfirmClusterAdminTotp(requester common.User, username, code string) ([]string, error) {
+	if !requester.IsClusterAdmin() || requester.GetName() != username {
+		return nil, common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	user := self.clusterConfiguration.GetClusterAdmin(username)
+	if user == nil {
+		return nil, fmt.Errorf("Invalid user name %s", username)
+	}
+	if user.PendingTotpSecret == "" {
+		return nil, fmt.Errorf("%s didn't start a totp enrollment", username)
+	}
+	if !cluster.ValidateTotp(user.PendingTotpSecret, code, time.Now()) {
+		return nil, common.NewAuthorizationError("Invalid totp code")
+	}
+	codes, hashes, err := cluster.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	updated := *user
+	updated.TotpSecret = user.PendingTotpSecret
+	updated.PendingTotpSecret = ""
+	updated.RecoveryCodes = hashes
+	return codes, self.raftServer.SaveClusterAdminUser(&updated)
+}
+
+// Turns the second factor of the cluster admin off, for admins that
+// lost their device and their recovery codes
+func (self *CoordinatorImpl) DisableClusterAdminTotp(requester common.User, username string) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	user := self.clusterConfiguration.GetClusterAdmin(username)
+	if user == nil {
+		return fmt.Errorf("Invalid user name %s", username)
+	}
+	updated := *user
+	updated.TotpSecret = ""
+	updated.PendingTotpSecret = ""
+	updated.RecoveryCodes = nil
+	return self.raftServer.SaveClusterAdminUser(&updated)
+}
+
+// Checks the second factor of the cluster admin, code is either a totp
+// code or one of the unused recovery codes
+func (self *CoordinatorImpl) VerifyClusterAdminSecondFactor(username, code string) error {
+	user := self.clusterConfiguration.GetClusterAdmin(username)
+	if user == nil {
+		return common.NewAuthorizationError("Invalid user name %s", username)
+	}
+	if !user.RequiresSecondFactor() || cluster.ValidateTotp(user.TotpSecret, code, time.Now()) {
+		return nil
+	}
+	updated := *user
+	if code == "" || !updated.UseRecoveryCode(code) {
+		return common.NewAuthorizationError("Invalid second factor code")
+	}
+	log.Warn("Cluster admin %s used a recovery code, %d left", username, len(updated.RecoveryCodes))
+	return self.raftServer.SaveClusterAdminUser(&updated)
+}
+
 func (self *CoordinatorImpl) CreateDbUser(requester common.User, db, username, password string) error {
 	if !requester.IsClusterAdmin() && !requester.IsDbAdmin(db) {
 		return common.NewAuthorizationError("Insufficient permissions")
@@ -844,11 +1228,16 @@ func (self *CoordinatorImpl) CreateDbUser(requester common.User, db, username, p
 	}
 	matchers := []*cluster.Matcher{&cluster.Matcher{true, ".*"}}
 	log.Debug("(raft:%s) Creating user %s:%s", self.raftServer.(*RaftServer).raftServer.Name(), db, username)
-	return self.raftServer.SaveDbUser(&cluster.DbUser{cluster.CommonUser{
-		Name:     username,
-		Hash:     string(hash),
-		CacheKey: db + "%" + username,
-	}, db, matchers, matchers, false})
+	return self.raftServer.SaveDbUser(&cluster.DbUser{
+		CommonUser: cluster.CommonUser{
+			Name:     username,
+			Hash:     string(hash),
+			CacheKey: db + "%" + username,
+		},
+		Db:       db,
+		WriteTo:  matchers,
+		ReadFrom: matchers,
+	})
 }
 
 func (self *CoordinatorImpl) DeleteDbUser(requester common.User, db, username string) error {
@@ -897,6 +1286,37 @@ func (self *CoordinatorImpl) ChangeDbUserPassword(requester common.User, db, use
 	return self.raftServer.ChangeDbUserPassword(db, username, hash)
 }
 
+func (self *CoordinatorImpl) DbUserPasswordFingerprint(requester common.User, db, username string) (string, error) {
+	if !requester.IsClusterAdmin() && !requester.IsDbAdmin(db) {
+		return "", common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	user := self.clusterConfiguration.GetDbUser(db, username)
+	if user == nil {
+		return "", fmt.Errorf("Invalid username %s", username)
+	}
+	return user.PasswordFingerprint(), nil
+}
+
+// Changes the password of the user if its password didn't change since
+// the fingerprint was taken, the caller has to make sure the
+// fingerprint comes from an admin, e.g. in a signed token
+func (self *CoordinatorImpl) ResetDbUserPassword(db, username, fingerprint, password string) error {
+	user := self.clusterConfiguration.GetDbUser(db, username)
+	if user == nil {
+		return common.NewAuthorizationError("Invalid username %s", username)
+	}
+	if subtle.ConstantTimeCompare([]byte(user.PasswordFingerprint()), []byte(fingerprint)) != 1 {
+		return common.NewAuthorizationError("The password was changed since the reset token was issued")
+	}
+
+	hash, err := cluster.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return self.raftServer.ChangeDbUserPassword(db, username, hash)
+}
+
 func (self *CoordinatorImpl) SetDbAdmin(requester common.User, db, username string, isAdmin bool) error {
 	if !requester.IsClusterAdmin() && !requester.IsDbAdmin(db) {
 		return common.NewAuthorizationError("Insufficient permissions")
@@ -911,6 +1331,225 @@ func (self *CoordinatorImpl) SetDbAdmin(requester common.User, db, username stri
 	return nil
 }
 
+// Grants or revokes the privilege to create databases, only cluster
+// admins can change it
+func (self *CoordinatorImpl) SetDbUserCanCreateDatabases(requester common.User, db, username string, canCreate bool) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	user := self.clusterConfiguration.GetDbUser(db, username)
+	if user == nil {
+		return fmt.Errorf("Invalid username %s", username)
+	}
+	updated := *user
+	updated.CanCreateDatabases = canCreate
+	return self.raftServer.SaveDbUser(&updated)
+}
+
+// Restricts the networks the db user can authenticate from, db admins
+// can't lift their own restrictions
+func (self *CoordinatorImpl) SetDbUserAllowedNetworks(requester common.User, db, username string, networks []string) error {
+	if !requester.IsClusterAdmin() && (!requester.IsDbAdmin(db) || requester.GetName() == username) {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	user := self.clusterConfiguration.GetDbUser(db, username)
+	if user == nil {
+		return fmt.Errorf("Invalid username %s", username)
+	}
+	parsed, err := cluster.ParseAllowedNetworks(networks)
+	if err != nil {
+		return err
+	}
+	updated := *user
+	updated.AllowedNetworks = parsed
+	return self.raftServer.SaveDbUser(&updated)
+}
+
+// Replaces the roles of the user, the roles have to exist
+func (self *CoordinatorImpl) SetDbUserRoles(requester common.User, db, username string, roles []string) error {
+	if !requester.IsClusterAdmin() && !requester.IsDbAdmin(db) {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	user := self.clusterConfiguration.GetDbUser(db, username)
+	if user == nil {
+		return fmt.Errorf("Invalid username %s", username)
+	}
+	for _, name := range roles {
+		if self.clusterConfiguration.GetRole(name) == nil {
+			return fmt.Errorf("Role %s doesn't exist", name)
+		}
+	}
+	updated := *user
+	updated.Roles = roles
+	return self.raftServer.SaveDbUser(&updated)
+}
+
+func (self *CoordinatorImpl) AuthenticateApiKey(db, key string) (common.User, error) {
+	return self.clusterConfiguration.AuthenticateApiKey(db, key)
+}
+
+func (self *CoordinatorImpl) LookupApiKey(db, id string) (common.User, error) {
+	return self.clusterConfiguration.LookupApiKey(db, id)
+}
+
+func (self *CoordinatorImpl) ListApiKeys(requester common.User) ([]*cluster.ApiKey, error) {
+	if !requester.IsClusterAdmin() {
+		return nil, common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	return self.clusterConfiguration.GetApiKeys(), nil
+}
+
+// Creates an api key scoped to the given databases, returns the key
+// that should be given to the client, it can't be retrieved later.
+func (self *CoordinatorImpl) CreateApiKey(requester common.User, name string, databases []string, read, write bool) (string, *cluster.ApiKey, error) {
+	if !requester.IsClusterAdmin() {
+		return "", nil, common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	if name == "" {
+		return "", nil, fmt.Errorf("Api key name cannot be empty")
+	}
+	if len(databases) == 0 {
+		return "", nil, fmt.Errorf("Api key %s has to be scoped to at least one database", name)
+	}
+	if !read && !write {
+		return "", nil, fmt.Errorf("Api key %s needs read or write access", name)
+	}
+	for _, db := range databases {
+		if !self.clusterConfiguration.DatabaseExists(db) {
+			return "", nil, fmt.Errorf("Database %s doesn't exist", db)
+		}
+	}
+
+	key, apiKey, err := cluster.NewApiKey(name, databases, read, write)
+	if err != nil {
+		return "", nil, err
+	}
+	apiKey.CreatedBy = requester.GetName()
+	apiKey.CreatedAt = time.Now().Unix()
+	if err := self.raftServer.SaveApiKey(apiKey); err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+func (self *CoordinatorImpl) RevokeApiKey(requester common.User, id string) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	apiKey := self.clusterConfiguration.GetApiKey(id)
+	if apiKey == nil {
+		return fmt.Errorf("Api key %s doesn't exist", id)
+	}
+
+	apiKey.IsKeyDeleted = true
+	return self.raftServer.SaveApiKey(apiKey)
+}
+
+func (self *CoordinatorImpl) ListWriteSubscriptions(requester common.User) ([]*cluster.WriteSubscription, error) {
+	if !requester.IsClusterAdmin() {
+		return nil, common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	return self.clusterConfiguration.GetWriteSubscriptions(), nil
+}
+
+// Forwards a copy of the writes to the database that are received by
+// any server in the cluster to the destinations
+func (self *CoordinatorImpl) CreateWriteSubscription(requester common.User, name, db string, destinations []string) (*cluster.WriteSubscription, error) {
+	if !requester.IsClusterAdmin() {
+		return nil, common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	subscription := &cluster.WriteSubscription{
+		Name:         name,
+		Database:     db,
+		Destinations: destinations,
+		CreatedBy:    requester.GetName(),
+		CreatedAt:    time.Now().Unix(),
+	}
+	if err := subscription.Validate(); err != nil {
+		return nil, err
+	}
+	if !self.clusterConfiguration.DatabaseExists(db) {
+		return nil, fmt.Errorf("Database %s doesn't exist", db)
+	}
+	if self.clusterConfiguration.GetWriteSubscription(name) != nil {
+		return nil, fmt.Errorf("Subscription %s already exists", name)
+	}
+	if err := self.raftServer.SaveWriteSubscription(subscription); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (self *CoordinatorImpl) DropWriteSubscription(requester common.User, name string) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	subscription := self.clusterConfiguration.GetWriteSubscription(name)
+	if subscription == nil {
+		return fmt.Errorf("Subscription %s doesn't exist", name)
+	}
+
+	deleted := *subscription
+	deleted.IsDeleted = true
+	return self.raftServer.SaveWriteSubscription(&deleted)
+}
+
+func (self *CoordinatorImpl) ListRoles(requester common.User) ([]*cluster.Role, error) {
+	if !requester.IsClusterAdmin() {
+		return nil, common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	return self.clusterConfiguration.GetRoles(), nil
+}
+
+func (self *CoordinatorImpl) SaveRole(requester common.User, role *cluster.Role) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	role.CreatedBy = requester.GetName()
+	role.IsDeleted = false
+	if existing := self.clusterConfiguration.GetRole(role.Name); existing != nil {
+		role.CreatedBy = existing.CreatedBy
+	}
+	if role.Permissions == nil {
+		role.Permissions = map[string]*cluster.RolePermission{}
+	}
+	if err := role.Validate(); err != nil {
+		return err
+	}
+	for db := range role.Permissions {
+		if !self.clusterConfiguration.DatabaseExists(db) {
+			return fmt.Errorf("Database %s doesn't exist", db)
+		}
+	}
+	return self.raftServer.SaveRole(role)
+}
+
+func (self *CoordinatorImpl) DropRole(requester common.User, name string) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+
+	role := self.clusterConfiguration.GetRole(name)
+	if role == nil {
+		return fmt.Errorf("Role %s doesn't exist", name)
+	}
+
+	deleted := *role
+	deleted.IsDeleted = true
+	return self.raftServer.SaveRole(&deleted)
+}
+
 func (self *CoordinatorImpl) ConnectToProtobufServers(localConnectionString string) error {
 	log.Info("Connecting to other nodes in the cluster")
 
@@ -0,0 +1,101 @@
+package coordinator
+
+import (
+	"common"
+	"fmt"
+	"protocol"
+	"runtime"
+	"sort"
+	"stats"
+	"time"
+)
+
+// The raft state that SHOW DIAGNOSTICS reports, the raft server
+// implements it
+type raftStatus interface {
+	State() string
+	Leader() string
+}
+
+// Writes a series for every stat of the server, the columns are the
+// values of the stat, e.g. count, rate and the percentiles
+func (self *CoordinatorImpl) runShowStatsQuery(user common.User, seriesWriter SeriesWriter) error {
+	if !user.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions to show the stats")
+	}
+
+	timestamp := time.Now().Unix()
+	var err error
+	stats.Do(func(name string, values map[string]float64) {
+		if err != nil {
+			return
+		}
+		fields := make([]string, 0, len(values))
+		for field := range values {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		fieldValues := make([]*protocol.FieldValue, 0, len(fields))
+		for _, field := range fields {
+			value := values[field]
+			fieldValues = append(fieldValues, &protocol.FieldValue{DoubleValue: &value})
+		}
+		seriesName := name
+		sequenceNumber := uint64(1)
+		err = seriesWriter.Write(&protocol.Series{
+			Name:   &seriesName,
+			Fields: fields,
+			Points: []*protocol.Point{
+				&protocol.Point{Values: fieldValues, Timestamp: &timestamp, SequenceNumber: &sequenceNumber},
+			},
+		})
+	})
+	return err
+}
+
+// Writes the build, the uptime, the directories and the raft state of
+// the server as name/value points of the diagnostics series
+func (self *CoordinatorImpl) runShowDiagnosticsQuery(user common.User, seriesWriter SeriesWriter) error {
+	if !user.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions to show the diagnostics")
+	}
+
+	diagnostics := [][2]string{
+		{"version", self.config.InfluxDBVersion},
+		{"git_sha", self.config.InfluxDBGitSha},
+		{"build_date", self.config.InfluxDBBuildDate},
+		{"go_version", runtime.Version()},
+		{"uptime", time.Since(serverStartTime).String()},
+		{"hostname", self.config.HostnameOrDetect()},
+		{"server_id", fmt.Sprintf("%d", self.clusterConfiguration.ServerId())},
+		{"data_dir", self.config.DataDir},
+		{"raft_dir", self.config.RaftDir},
+		{"wal_dir", self.config.WalDir},
+		{"replication_factor", fmt.Sprintf("%d", self.config.ReplicationFactor)},
+		{"goroutines", fmt.Sprintf("%d", runtime.NumGoroutine())},
+	}
+	if raft, ok := self.raftServer.(raftStatus); ok {
+		diagnostics = append(diagnostics, [2]string{"raft_state", raft.State()}, [2]string{"raft_leader", raft.Leader()})
+	}
+
+	timestamp := time.Now().Unix()
+	points := make([]*protocol.Point, 0, len(diagnostics))
+	for _, diagnostic := range diagnostics {
+		name, value := diagnostic[0], diagnostic[1]
+		sequenceNumber := uint64(len(points) + 1)
+		points = append(points, &protocol.Point{
+			Values: []*protocol.FieldValue{
+				&protocol.FieldValue{StringValue: &name},
+				&protocol.FieldValue{StringValue: &value},
+			},
+			Timestamp:      &timestamp,
+			SequenceNumber: &sequenceNumber,
+		})
+	}
+	seriesName := "diagnostics"
+	return seriesWriter.Write(&protocol.Series{
+		Name:   &seriesName,
+		Fields: []string{"name", "value"},
+		Points: points,
+	})
+}
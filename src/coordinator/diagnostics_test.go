@@ -0,0 +1,68 @@
+package coordinator
+
+import (
+	"cluster"
+	"common"
+	"configuration"
+	"protocol"
+
+	. "launchpad.net/gocheck"
+)
+
+type DiagnosticsSuite struct{}
+
+var _ = Suite(&DiagnosticsSuite{})
+
+type collectingSeriesWriter struct {
+	series []*protocol.Series
+}
+
+func (self *collectingSeriesWriter) Write(series *protocol.Series) error {
+	self.series = append(self.series, series)
+	return nil
+}
+
+func (self *collectingSeriesWriter) Close() {}
+
+func (self *DiagnosticsSuite) TestShowStats(c *C) {
+	coordinator := &CoordinatorImpl{}
+	writer := &collectingSeriesWriter{}
+	err := coordinator.runShowStatsQuery(&MockUser{}, writer)
+	c.Assert(err, FitsTypeOf, common.AuthorizationError(""))
+
+	queriesExecuted.Inc()
+	admin := &cluster.ClusterAdmin{CommonUser: cluster.CommonUser{Name: "root"}}
+	c.Assert(coordinator.runShowStatsQuery(admin, writer), IsNil)
+	found := false
+	for _, series := range writer.series {
+		if series.GetName() != "queriesExecuted" {
+			continue
+		}
+		found = true
+		c.Assert(series.Fields, DeepEquals, []string{"count"})
+		c.Assert(series.Points[0].Values[0].GetDoubleValue() >= 1, Equals, true)
+	}
+	c.Assert(found, Equals, true)
+}
+
+func (self *DiagnosticsSuite) TestShowDiagnosticsHasTheBuild(c *C) {
+	coordinator := &CoordinatorImpl{
+		clusterConfiguration: &cluster.ClusterConfiguration{},
+		config: &configuration.Configuration{
+			InfluxDBVersion:   "0.5.9",
+			InfluxDBGitSha:    "abc123",
+			InfluxDBBuildDate: "2014-05-01T12:00:00Z",
+		},
+	}
+	writer := &collectingSeriesWriter{}
+	admin := &cluster.ClusterAdmin{CommonUser: cluster.CommonUser{Name: "root"}}
+	c.Assert(coordinator.runShowDiagnosticsQuery(admin, writer), IsNil)
+	c.Assert(writer.series, HasLen, 1)
+	diagnostics := map[string]string{}
+	for _, point := range writer.series[0].Points {
+		diagnostics[point.Values[0].GetStringValue()] = point.Values[1].GetStringValue()
+	}
+	c.Assert(diagnostics["version"], Equals, "0.5.9")
+	c.Assert(diagnostics["git_sha"], Equals, "abc123")
+	c.Assert(diagnostics["build_date"], Equals, "2014-05-01T12:00:00Z")
+}
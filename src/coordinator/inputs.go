@@ -0,0 +1,15 @@
+package coordinator
+
+import (
+	"cluster"
+	"protocol"
+)
+
+// The inputs that write the points they receive to a database without
+// authenticating their clients, e.g. udp, graphite or collectd, write
+// them as the internal cluster admin. Unlike one of the cluster admins
+// it's never deleted or changed, so the writes can't fail because the
+// user got stale.
+func WriteInputSeries(coord Coordinator, db string, series []*protocol.Series) error {
+	return coord.WriteSeriesData(cluster.InternalClusterAdmin, db, series)
+}
@@ -27,6 +27,37 @@ type Coordinator interface {
 
 	// v2 clustering, based on sharding instead of the circular hash ring
 	RunQuery(user common.User, db, query string, seriesWriter SeriesWriter) error
+
+	// live queries, the matching points are sent on the subscription as
+	// they're written
+	Subscribe(user common.User, db, query string) (*Subscription, error)
+	Unsubscribe(subscription *Subscription)
+
+	// write subscriptions, copies of the writes to a database are
+	// forwarded to their destinations
+	ListWriteSubscriptions(requester common.User) ([]*cluster.WriteSubscription, error)
+	CreateWriteSubscription(requester common.User, name, db string, destinations []string) (*cluster.WriteSubscription, error)
+	DropWriteSubscription(requester common.User, name string) error
+
+	ListRoles(requester common.User) ([]*cluster.Role, error)
+	// Creates the role or replaces its permissions
+	SaveRole(requester common.User, role *cluster.Role) error
+	DropRole(requester common.User, name string) error
+
+	// the limits of the writes to a database and its usage today
+	GetDatabaseQuota(requester common.User, db string) (*cluster.DatabaseQuota, *QuotaUsage, error)
+	SetDatabaseQuota(requester common.User, quota *cluster.DatabaseQuota) error
+	DropDatabaseQuota(requester common.User, db string) error
+
+	// the tenants own databases and limit them together
+	ListTenants(requester common.User) ([]*cluster.Tenant, error)
+	GetTenant(requester common.User, name string) (*cluster.Tenant, *TenantUsage, error)
+	// Creates the tenant or replaces its databases and limits
+	SaveTenant(requester common.User, tenant *cluster.Tenant) error
+	DropTenant(requester common.User, name string) error
+
+	// replays a dump, the points on the lines up to skip are skipped
+	RestoreDump(requester common.User, reader *cluster.DumpReader, skip int, report func(*RestoreProgress) error) error
 }
 
 type ClusterConsensus interface {
@@ -37,6 +68,11 @@ type ClusterConsensus interface {
 	SaveClusterAdminUser(u *cluster.ClusterAdmin) error
 	SaveDbUser(user *cluster.DbUser) error
 	ChangeDbUserPassword(db, username string, hash []byte) error
+	SaveApiKey(key *cluster.ApiKey) error
+	SaveWriteSubscription(subscription *cluster.WriteSubscription) error
+	SaveRole(role *cluster.Role) error
+	SaveDatabaseQuota(quota *cluster.DatabaseQuota) error
+	SaveTenant(tenant *cluster.Tenant) error
 
 	// an insert index of -1 will append to the end of the ring
 	AddServer(server *cluster.ClusterServer, insertIndex int) error
@@ -0,0 +1,42 @@
+package coordinator
+
+import (
+	"protocol"
+	"stats"
+	"time"
+)
+
+// The uptime in SHOW DIAGNOSTICS is counted from here
+var serverStartTime = time.Now()
+
+// The stat with the points written to every database
+const POINTS_WRITTEN_BY_DATABASE = "pointsWrittenByDatabase"
+
+var (
+	pointsWritten          = stats.NewMeter("pointsWritten")
+	pointsWrittenByDb      = stats.NewCounters(POINTS_WRITTEN_BY_DATABASE)
+	forwardQueueDepth      = stats.NewGauge("forwardQueueDepth")
+	writeRequests          = stats.NewCounter("writeRequests")
+	writeErrors            = stats.NewCounter("writeErrors")
+	quotaRejections        = stats.NewCounter("quotaRejections")
+	writeLatency           = stats.NewHistogram("writeLatencyMs")
+	queriesExecuted        = stats.NewCounter("queriesExecuted")
+	queryErrors            = stats.NewCounter("queryErrors")
+	queryLatency           = stats.NewHistogram("queryLatencyMs")
+	slowQueries            = stats.NewCounter("slowQueries")
+	raftCommands           = stats.NewCounter("raftCommands")
+	raftCommandErrors      = stats.NewCounter("raftCommandErrors")
+	raftApplyLatency       = stats.NewHistogram("raftApplyLatencyMs")
+	protobufRequests       = stats.NewCounter("protobufServerRequests")
+	protobufClientRequests = stats.NewCounter("protobufClientRequests")
+	protobufClientErrors   = stats.NewCounter("protobufClientErrors")
+)
+
+// Returns the number of points in the series
+func countPoints(series []*protocol.Series) int64 {
+	var count int64
+	for _, s := range series {
+		count += int64(len(s.Points))
+	}
+	return count
+}
@@ -100,6 +100,7 @@ func (self *ProtobufClient) MakeRequest(request *protocol.Request, responseStrea
 		self.requestBufferLock.Unlock()
 	}
 
+	protobufClientRequests.Inc()
 	data, err := request.Encode()
 	if err != nil {
 		return err
@@ -109,6 +110,7 @@ func (self *ProtobufClient) MakeRequest(request *protocol.Request, responseStrea
 	if conn == nil {
 		conn = self.reconnect()
 		if conn == nil {
+			protobufClientErrors.Inc()
 			return fmt.Errorf("Failed to connect to server %s", self.hostAndPort)
 		}
 	}
@@ -125,6 +127,7 @@ func (self *ProtobufClient) MakeRequest(request *protocol.Request, responseStrea
 	}
 
 	// if we got here it errored out, clear out the request
+	protobufClientErrors.Inc()
 	self.requestBufferLock.Lock()
 	delete(self.requestBuffer, *request.Id)
 	self.requestBufferLock.Unlock()
@@ -10,6 +10,7 @@ import (
 	"net"
 	"parser"
 	"protocol"
+	"strings"
 
 	log "code.google.com/p/log4go"
 )
@@ -55,6 +56,38 @@ func (self *ProtobufRequestHandler) HandleRequest(request *protocol.Request, con
 	return nil
 }
 
+// Returns the user that the query runs as on the originating server,
+// nil if it doesn't exist
+func (self *ProtobufRequestHandler) requestUser(request *protocol.Request) common.User {
+	name := request.GetUserName()
+	if request.GetIsExternalUser() {
+		return self.clusterConfig.ExternalUser(request.GetDatabase(), name, request.GetIsDbUser(), request.Roles)
+	}
+	if !request.GetIsDbUser() {
+		if name == cluster.INTERNAL_USER_NAME {
+			// e.g. another server that repairs its copy of the shard
+			return cluster.InternalClusterAdmin
+		}
+		if user := self.clusterConfig.GetClusterAdmin(name); user != nil {
+			return user
+		}
+		return nil
+	}
+	// the names of the api keys aren't valid user names
+	if strings.HasPrefix(name, cluster.API_KEY_USER_PREFIX) {
+		apiKey, err := self.clusterConfig.LookupApiKey(request.GetDatabase(), strings.TrimPrefix(name, cluster.API_KEY_USER_PREFIX))
+		if err != nil {
+			log.Warn("Cannot find the api key of query %s: %s", request.GetRequestId(), err)
+			return nil
+		}
+		return apiKey
+	}
+	if user := self.clusterConfig.GetDbUser(request.GetDatabase(), name); user != nil {
+		return user
+	}
+	return nil
+}
+
 func (self *ProtobufRequestHandler) handleQuery(request *protocol.Request, conn net.Conn) {
 	// the query should always parse correctly since it was parsed at the originating server.
 	queries, err := parser.ParseQuery(*request.Query)
@@ -66,13 +99,7 @@ func (self *ProtobufRequestHandler) handleQuery(request *protocol.Request, conn
 		return
 	}
 	query := queries[0]
-	var user common.User
-	if *request.IsDbUser {
-		user = self.clusterConfig.GetDbUser(*request.Database, *request.UserName)
-	} else {
-		user = self.clusterConfig.GetClusterAdmin(*request.UserName)
-	}
-
+	user := self.requestUser(request)
 	if user == nil {
 		errorMsg := fmt.Sprintf("Cannot find user %s", *request.UserName)
 		response := &protocol.Response{Type: &accessDeniedResponse, ErrorMessage: &errorMsg, RequestId: request.Id}
@@ -80,6 +107,14 @@ func (self *ProtobufRequestHandler) handleQuery(request *protocol.Request, conn
 		return
 	}
 
+	user = common.UserWithRequestId(user, request.GetRequestId())
+	var trace *common.Trace
+	if request.GetTrace() {
+		trace = common.NewTrace()
+		user = common.UserWithTrace(user, trace)
+	}
+	log.Debug("Querying shard %d for request %s: %s", request.GetShardId(), request.GetRequestId(), request.GetQuery())
+
 	shard := self.clusterConfig.GetLocalShardById(*request.ShardId)
 
 	querySpec := parser.NewQuerySpec(user, *request.Database, query)
@@ -93,10 +128,16 @@ func (self *ProtobufRequestHandler) handleQuery(request *protocol.Request, conn
 	for {
 		response := <-responseChan
 		response.RequestId = request.Id
-		self.WriteResponse(conn, response)
 		if response.GetType() == protocol.Response_END_STREAM || response.GetType() == protocol.Response_ACCESS_DENIED {
+			// send the timings of the stages back to the server that
+			// got the query
+			if trace != nil {
+				response.TraceSpans = trace.ToProtobuf()
+			}
+			self.WriteResponse(conn, response)
 			return
 		}
+		self.WriteResponse(conn, response)
 	}
 }
 
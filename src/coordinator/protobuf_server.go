@@ -121,6 +121,7 @@ func (self *ProtobufServer) handleRequest(conn net.Conn, messageSize int64, buff
 	}
 
 	log.Debug("Received %s request: %d", request.GetType(), request.GetRequestNumber())
+	protobufRequests.Inc()
 
 	return self.requestHandler.HandleRequest(request, conn)
 }
@@ -0,0 +1,85 @@
+package coordinator
+
+import (
+	"math/rand"
+	"parser"
+	"protocol"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+const SAMPLED_QUERIES_SERIES = "queries"
+
+var (
+	stringLiteralRegex = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+	// the numbers and the durations that values and times are compared
+	// to, e.g. value > 10 or time > now() - 1h
+	comparedNumberRegex = regexp.MustCompile(`([=<>]\s*(?:now\(\)\s*[-+]\s*)?)-?\d+(?:\.\d+)?[a-z]*\b`)
+)
+
+// Replaces the literals of the query with ?, so the queries that only
+// differ by the values they look for are the same
+func normalizeQuery(query string) string {
+	query = stringLiteralRegex.ReplaceAllString(query, "?")
+	query = comparedNumberRegex.ReplaceAllString(query, "${1}?")
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// Counts the points that are returned by a query
+type countingSeriesWriter struct {
+	SeriesWriter
+	points int64
+}
+
+func (self *countingSeriesWriter) Write(series *protocol.Series) error {
+	atomic.AddInt64(&self.points, int64(len(series.Points)))
+	return self.SeriesWriter.Write(series)
+}
+
+func (self *countingSeriesWriter) Points() int64 {
+	return atomic.LoadInt64(&self.points)
+}
+
+// Returns true if the query should be recorded in the monitoring
+// database
+func (self *CoordinatorImpl) sampleQuery() bool {
+	rate := self.config.MonitoringQuerySampleRate
+	return rate > 0 && rand.Float64() < rate
+}
+
+// Writes the sampled query to the monitoring database, if it exists
+func (self *CoordinatorImpl) recordQuery(querySpec *parser.QuerySpec, took time.Duration, points int64) {
+	monitoringDb := self.config.MonitoringDatabase
+	if !self.clusterConfiguration.DatabaseExists(monitoringDb) {
+		return
+	}
+	series := sampledQuerySeries(querySpec.Database(), querySpec.User().GetName(), normalizeQuery(querySpec.GetQueryString()), took, points)
+	go func() {
+		if err := self.commitSeriesData(monitoringDb, []*protocol.Series{series}, ""); err != nil {
+			log.Error("Cannot record the query in %s: %s", monitoringDb, err)
+		}
+	}()
+}
+
+func sampledQuerySeries(db, user, query string, took time.Duration, points int64) *protocol.Series {
+	durationMs := float64(took) / float64(time.Millisecond)
+	return &protocol.Series{
+		Name:   protocol.String(SAMPLED_QUERIES_SERIES),
+		Fields: []string{"database", "user", "query", "duration_ms", "points"},
+		Points: []*protocol.Point{
+			&protocol.Point{
+				Values: []*protocol.FieldValue{
+					&protocol.FieldValue{StringValue: &db},
+					&protocol.FieldValue{StringValue: &user},
+					&protocol.FieldValue{StringValue: &query},
+					&protocol.FieldValue{DoubleValue: &durationMs},
+					&protocol.FieldValue{Int64Value: &points},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,49 @@
+package coordinator
+
+import (
+	"configuration"
+	"protocol"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type QuerySampleSuite struct{}
+
+var _ = Suite(&QuerySampleSuite{})
+
+type discardingSeriesWriter struct{}
+
+func (self discardingSeriesWriter) Write(*protocol.Series) error { return nil }
+func (self discardingSeriesWriter) Close()                       {}
+
+func (self *QuerySampleSuite) TestNormalizeQuery(c *C) {
+	c.Assert(normalizeQuery("select value from cpu where host = 'server\\'s'  and time > now() - 1h and value > -10.5 group by time(1m) limit 10"),
+		Equals, "select value from cpu where host = ? and time > now() - ? and value > ? group by time(1m) limit 10")
+	c.Assert(normalizeQuery("select * from /cpu.*/ where time < 1400000000s"), Equals, "select * from /cpu.*/ where time < ?")
+}
+
+func (self *QuerySampleSuite) TestSampleQuery(c *C) {
+	coordinator := &CoordinatorImpl{config: &configuration.Configuration{}}
+	c.Assert(coordinator.sampleQuery(), Equals, false)
+	coordinator.config.MonitoringQuerySampleRate = 1
+	c.Assert(coordinator.sampleQuery(), Equals, true)
+}
+
+func (self *QuerySampleSuite) TestCountingSeriesWriter(c *C) {
+	writer := &countingSeriesWriter{SeriesWriter: discardingSeriesWriter{}}
+	c.Assert(writer.Write(&protocol.Series{Points: make([]*protocol.Point, 3)}), IsNil)
+	c.Assert(writer.Write(&protocol.Series{Points: make([]*protocol.Point, 2)}), IsNil)
+	c.Assert(writer.Points(), Equals, int64(5))
+}
+
+func (self *QuerySampleSuite) TestSampledQuerySeries(c *C) {
+	series := sampledQuerySeries("db1", "paul", "select * from cpu", 250*time.Millisecond, 42)
+	c.Assert(series.GetName(), Equals, SAMPLED_QUERIES_SERIES)
+	c.Assert(series.Points, HasLen, 1)
+	values := series.Points[0].Values
+	c.Assert(values, HasLen, len(series.Fields))
+	c.Assert(values[2].GetStringValue(), Equals, "select * from cpu")
+	c.Assert(values[3].GetDoubleValue(), Equals, 250.0)
+	c.Assert(values[4].GetInt64Value(), Equals, int64(42))
+}
@@ -0,0 +1,479 @@
+package coordinator
+
+import (
+	"cluster"
+	"common"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"parser"
+	"path/filepath"
+	"protocol"
+	"sync"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+	log "code.google.com/p/log4go"
+)
+
+const (
+	QUOTA_USAGE_SYNC_INTERVAL     = 10 * time.Second
+	QUOTA_SERIES_REFRESH_INTERVAL = time.Minute
+	// the file in the raft dir that the usage of the day is saved to
+	QUOTA_USAGE_FILE = "quota_usage"
+)
+
+// The writes to a database in a UTC day
+type QuotaUsage struct {
+	Day    string `json:"day"`
+	Points int64  `json:"points"`
+	Bytes  int64  `json:"bytes"`
+	Series int    `json:"series"`
+	// the size on disk of the points, it isn't reset with the day
+	DiskBytes int64 `json:"diskBytes"`
+}
+
+// Tracks the usage of the databases that have a quota. Every server
+// counts the writes it receives and pulls the counts of the other
+// servers periodically, so the cluster can go over a quota by the
+// writes of the last sync.
+type QuotaTracker struct {
+	lock   sync.Mutex
+	day    string
+	local  map[string]*QuotaUsage
+	remote map[uint32]map[string]*QuotaUsage
+	// the series of the databases that have a limit on them
+	series map[string]map[string]bool
+	// the size on disk of the databases that have a limit on it in the
+	// shards of this server
+	disk map[string]int64
+	// the write rates of the tenants on this server
+	rates map[string]*tenantRate
+	now   func() time.Time
+	// the number of servers in the cluster, they split the write rates
+	// of the tenants
+	servers func() int
+}
+
+func NewQuotaTracker() *QuotaTracker {
+	return &QuotaTracker{
+		local:   map[string]*QuotaUsage{},
+		remote:  map[uint32]map[string]*QuotaUsage{},
+		series:  map[string]map[string]bool{},
+		disk:    map[string]int64{},
+		rates:   map[string]*tenantRate{},
+		now:     time.Now,
+		servers: func() int { return 1 },
+	}
+}
+
+// Starts counting from zero when the day changes, the lock must be held
+func (self *QuotaTracker) rollover() {
+	day := self.now().UTC().Format("2006-01-02")
+	if day == self.day {
+		return
+	}
+	self.day = day
+	self.local = map[string]*QuotaUsage{}
+	self.remote = map[uint32]map[string]*QuotaUsage{}
+}
+
+// Returns the usage of the database across the cluster, the lock must
+// be held
+func (self *QuotaTracker) usage(db string) *QuotaUsage {
+	self.rollover()
+	usage := &QuotaUsage{Day: self.day, Series: len(self.series[db]), DiskBytes: self.disk[db]}
+	if local := self.local[db]; local != nil {
+		usage.Points += local.Points
+		usage.Bytes += local.Bytes
+	}
+	for _, remote := range self.remote {
+		if r := remote[db]; r != nil {
+			usage.Points += r.Points
+			usage.Bytes += r.Bytes
+			usage.DiskBytes += r.DiskBytes
+		}
+	}
+	return usage
+}
+
+func (self *QuotaTracker) Usage(db string) *QuotaUsage {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.usage(db)
+}
+
+// Returns an error if writing the points would exceed the quota,
+// otherwise counts them right away, so that concurrent writes can't go
+// over the quota together. The points have to be released if the write
+// fails. The series are counted until the series of the database are
+// refreshed even if the write fails.
+func (self *QuotaTracker) Reserve(quota *cluster.DatabaseQuota, points, bytes int64, names []string) error {
+	if quota == nil {
+		return nil
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	usage := self.usage(quota.Database)
+	if quota.PointsPerDay > 0 && usage.Points+points > quota.PointsPerDay {
+		return common.NewQuotaExceededError("%s exceeded its quota of %d points per day", quota.Database, quota.PointsPerDay)
+	}
+	if quota.BytesPerDay > 0 && usage.Bytes+bytes > quota.BytesPerDay {
+		return common.NewQuotaExceededError("%s exceeded its quota of %d bytes per day", quota.Database, quota.BytesPerDay)
+	}
+	if quota.MaxSeries > 0 {
+		series := usage.Series
+		for _, name := range names {
+			if !self.series[quota.Database][name] {
+				series++
+			}
+		}
+		if series > quota.MaxSeries {
+			return common.NewQuotaExceededError("%s exceeded its quota of %d series", quota.Database, quota.MaxSeries)
+		}
+	}
+
+	local := self.local[quota.Database]
+	if local == nil {
+		local = &QuotaUsage{Day: self.day}
+		self.local[quota.Database] = local
+	}
+	local.Points += points
+	local.Bytes += bytes
+	if quota.MaxSeries > 0 {
+		self.addSeries(quota.Database, names)
+	}
+	return nil
+}
+
+// Gives back the points of a write that failed after they were reserved
+func (self *QuotaTracker) Release(quota *cluster.DatabaseQuota, points, bytes int64) {
+	if quota == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	// the points that were reserved on the day before were reset already
+	self.rollover()
+	if local := self.local[quota.Database]; local != nil {
+		local.Points -= points
+		local.Bytes -= bytes
+	}
+}
+
+// Counts the series that were written to a database that has a limit on
+// them through its tenant
+func (self *QuotaTracker) AddSeries(db string, names []string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.addSeries(db, names)
+}
+
+// The lock must be held
+func (self *QuotaTracker) addSeries(db string, names []string) {
+	series := self.series[db]
+	if series == nil {
+		series = map[string]bool{}
+		self.series[db] = series
+	}
+	for _, name := range names {
+		series[name] = true
+	}
+}
+
+// Returns the writes that this server received today and the size of
+// the databases on its disk
+func (self *QuotaTracker) Local() map[string]*QuotaUsage {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.rollover()
+	local := make(map[string]*QuotaUsage, len(self.local))
+	for db, usage := range self.local {
+		u := *usage
+		local[db] = &u
+	}
+	for db, size := range self.disk {
+		if local[db] == nil {
+			local[db] = &QuotaUsage{Day: self.day}
+		}
+		local[db].DiskBytes = size
+	}
+	return local
+}
+
+// The writes that this server received today, they're saved so that a
+// restart doesn't reset the usage of the day
+type savedQuotaUsage struct {
+	Day   string                 `json:"day"`
+	Local map[string]*QuotaUsage `json:"local"`
+}
+
+// Saves the writes that this server received today to the file
+func (self *QuotaTracker) Save(path string) error {
+	self.lock.Lock()
+	self.rollover()
+	data, err := json.Marshal(&savedQuotaUsage{self.day, self.local})
+	self.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// write the new usage next to the old one, a crash while writing
+	// shouldn't lose the usage that was saved before
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Loads the writes that were saved before the restart, they're ignored
+// if they were saved on another day. A missing file isn't an error.
+func (self *QuotaTracker) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	saved := &savedQuotaUsage{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		return err
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.rollover()
+	if saved.Day != self.day {
+		return nil
+	}
+	for db, usage := range saved.Local {
+		local := self.local[db]
+		if local == nil {
+			local = &QuotaUsage{Day: self.day}
+			self.local[db] = local
+		}
+		local.Points += usage.Points
+		local.Bytes += usage.Bytes
+	}
+	return nil
+}
+
+// Replaces the writes that the server received, the ones of another
+// day are ignored
+func (self *QuotaTracker) SetRemote(serverId uint32, usage map[string]*QuotaUsage) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.rollover()
+	remote := map[string]*QuotaUsage{}
+	for db, u := range usage {
+		if u.Day == self.day {
+			remote[db] = u
+		}
+	}
+	self.remote[serverId] = remote
+}
+
+// Replaces the series of the database with the ones it has on disk
+func (self *QuotaTracker) SetSeries(db string, names map[string]bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if names == nil {
+		delete(self.series, db)
+		return
+	}
+	self.series[db] = names
+}
+
+// Replaces the sizes of the databases on the disk of this server
+func (self *QuotaTracker) SetDisk(sizes map[string]int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.disk = sizes
+}
+
+// Returns the size of the points and the names of the series
+func seriesSizeAndNames(series []*protocol.Series) (int64, []string) {
+	var size int64
+	names := make([]string, 0, len(series))
+	for _, s := range series {
+		size += int64(proto.Size(s))
+		names = append(names, s.GetName())
+	}
+	return size, names
+}
+
+func (self *CoordinatorImpl) GetDatabaseQuota(requester common.User, db string) (*cluster.DatabaseQuota, *QuotaUsage, error) {
+	if !requester.IsClusterAdmin() {
+		return nil, nil, common.NewAuthorizationError("Insufficient permissions")
+	}
+	if !self.clusterConfiguration.DatabaseExists(db) {
+		return nil, nil, fmt.Errorf("Database %s doesn't exist", db)
+	}
+	return self.clusterConfiguration.GetDatabaseQuota(db), self.quotas.Usage(db), nil
+}
+
+// Creates the quota of the database or replaces its limits
+func (self *CoordinatorImpl) SetDatabaseQuota(requester common.User, quota *cluster.DatabaseQuota) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+	if err := quota.Validate(); err != nil {
+		return err
+	}
+	if !self.clusterConfiguration.DatabaseExists(quota.Database) {
+		return fmt.Errorf("Database %s doesn't exist", quota.Database)
+	}
+	quota.CreatedBy = requester.GetName()
+	quota.IsDeleted = false
+	return self.raftServer.SaveDatabaseQuota(quota)
+}
+
+func (self *CoordinatorImpl) DropDatabaseQuota(requester common.User, db string) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+	quota := self.clusterConfiguration.GetDatabaseQuota(db)
+	if quota == nil {
+		return fmt.Errorf("Database %s doesn't have a quota", db)
+	}
+
+	deleted := *quota
+	deleted.IsDeleted = true
+	return self.raftServer.SaveDatabaseQuota(&deleted)
+}
+
+// Collects the names of the series that list series returns
+type seriesNamesWriter struct {
+	names map[string]bool
+}
+
+func (self *seriesNamesWriter) Write(series *protocol.Series) error {
+	self.names[series.GetName()] = true
+	return nil
+}
+
+func (self *seriesNamesWriter) Close() {}
+
+// Loads the series of the databases that have a limit on them, by their
+// quota or their tenant, the series that were created by the other
+// servers are only counted after this runs
+func (self *CoordinatorImpl) refreshQuotaSeries() {
+	limited := map[string]bool{}
+	for _, quota := range self.clusterConfiguration.GetDatabaseQuotas() {
+		limited[quota.Database] = limited[quota.Database] || quota.MaxSeries > 0
+	}
+	for _, tenant := range self.clusterConfiguration.GetTenants() {
+		for _, db := range tenant.Databases {
+			limited[db] = limited[db] || tenant.MaxSeries > 0
+		}
+	}
+
+	for db, isLimited := range limited {
+		if !isLimited {
+			self.quotas.SetSeries(db, nil)
+			continue
+		}
+		queries, err := parser.ParseQuery("list series")
+		if err != nil {
+			log.Error("Cannot parse the list series query: %s", err)
+			return
+		}
+		writer := &seriesNamesWriter{names: map[string]bool{}}
+		querySpec := parser.NewQuerySpec(cluster.InternalClusterAdmin, db, queries[0])
+		if err := self.runListSeriesQuery(querySpec, writer); err != nil {
+			log.Warn("Cannot list the series of %s for its quota: %s", db, err)
+			continue
+		}
+		self.quotas.SetSeries(db, writer.names)
+	}
+}
+
+func (s *RaftServer) quotaUsageHandler(w http.ResponseWriter, req *http.Request) {
+	if s.coordinator == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	js, err := json.Marshal(s.coordinator.quotas.Local())
+	if err != nil {
+		log.Error("ERROR marshalling quota usage: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(js)
+}
+
+// Pulls the writes that the other servers received and refreshes the
+// series and the sizes of the databases, as long as there are quotas or
+// tenants. The writes that this server received are saved with every
+// sync and loaded again when the server starts.
+func (s *RaftServer) syncQuotaUsage() {
+	usagePath := filepath.Join(s.path, QUOTA_USAGE_FILE)
+	if s.coordinator != nil {
+		if err := s.coordinator.quotas.Load(usagePath); err != nil {
+			log.Error("Cannot load the quota usage from %s: %s", usagePath, err)
+		}
+	}
+
+	syncTicker := time.NewTicker(QUOTA_USAGE_SYNC_INTERVAL)
+	defer syncTicker.Stop()
+	seriesTicker := time.NewTicker(QUOTA_SERIES_REFRESH_INTERVAL)
+	defer seriesTicker.Stop()
+	for {
+		select {
+		case <-s.stopQuotaSync:
+			if s.coordinator != nil {
+				if err := s.coordinator.quotas.Save(usagePath); err != nil {
+					log.Error("Cannot save the quota usage to %s: %s", usagePath, err)
+				}
+			}
+			return
+		case <-syncTicker.C:
+			if s.coordinator == nil || (len(s.clusterConfig.GetDatabaseQuotas()) == 0 && len(s.clusterConfig.GetTenants()) == 0) {
+				continue
+			}
+			if err := s.coordinator.quotas.Save(usagePath); err != nil {
+				log.Error("Cannot save the quota usage to %s: %s", usagePath, err)
+			}
+			localId := s.clusterConfig.ServerId()
+			for _, server := range s.clusterConfig.Servers() {
+				if server.Id == localId {
+					continue
+				}
+				usage, err := getQuotaUsage(server.RaftConnectionString)
+				if err != nil {
+					log.Warn("Cannot get the quota usage of server %d: %s", server.Id, err)
+					continue
+				}
+				s.coordinator.quotas.SetRemote(server.Id, usage)
+			}
+		case <-seriesTicker.C:
+			if s.coordinator == nil {
+				continue
+			}
+			s.coordinator.refreshQuotaSeries()
+			s.coordinator.refreshTenantDisk()
+		}
+	}
+}
+
+func getQuotaUsage(raftConnectionString string) (map[string]*QuotaUsage, error) {
+	resp, err := raftHttpClient.Get(raftConnectionString + "/quota_usage")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Server returned %s", resp.Status)
+	}
+	usage := map[string]*QuotaUsage{}
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
@@ -0,0 +1,117 @@
+package coordinator
+
+import (
+	"cluster"
+	"common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type QuotaSuite struct{}
+
+var _ = Suite(&QuotaSuite{})
+
+func (self *QuotaSuite) TestPointsAndBytesPerDay(c *C) {
+	tracker := NewQuotaTracker()
+	quota := &cluster.DatabaseQuota{Database: "db1", PointsPerDay: 100, BytesPerDay: 1000}
+
+	c.Assert(tracker.Reserve(quota, 60, 100, nil), IsNil)
+	err := tracker.Reserve(quota, 60, 100, nil)
+	c.Assert(err, FitsTypeOf, common.QuotaExceededError(""))
+
+	// the writes of the other servers count too
+	tracker.SetRemote(2, map[string]*QuotaUsage{"db1": &QuotaUsage{Day: tracker.day, Bytes: 850}})
+	c.Assert(tracker.Reserve(quota, 10, 100, nil), NotNil)
+	c.Assert(tracker.Reserve(quota, 10, 50, nil), IsNil)
+	c.Assert(tracker.Usage("db1").Bytes, Equals, int64(1000))
+
+	// the points of a failed write are given back
+	tracker.Release(quota, 10, 50)
+	c.Assert(tracker.Usage("db1").Bytes, Equals, int64(950))
+
+	// the usage of another day is ignored
+	tracker.SetRemote(2, map[string]*QuotaUsage{"db1": &QuotaUsage{Day: "2000-01-01", Bytes: 850}})
+	c.Assert(tracker.Usage("db1").Bytes, Equals, int64(100))
+}
+
+func (self *QuotaSuite) TestRollover(c *C) {
+	now := time.Date(2014, 5, 1, 23, 0, 0, 0, time.UTC)
+	tracker := NewQuotaTracker()
+	tracker.now = func() time.Time { return now }
+	quota := &cluster.DatabaseQuota{Database: "db1", PointsPerDay: 100}
+
+	c.Assert(tracker.Reserve(quota, 100, 0, nil), IsNil)
+	c.Assert(tracker.Reserve(quota, 1, 0, nil), NotNil)
+	now = now.Add(2 * time.Hour)
+	c.Assert(tracker.Local(), HasLen, 0)
+	c.Assert(tracker.Reserve(quota, 1, 0, nil), IsNil)
+}
+
+func (self *QuotaSuite) TestMaxSeries(c *C) {
+	tracker := NewQuotaTracker()
+	quota := &cluster.DatabaseQuota{Database: "db1", MaxSeries: 2}
+	tracker.SetSeries("db1", map[string]bool{"cpu": true})
+
+	c.Assert(tracker.Reserve(quota, 1, 0, []string{"cpu", "mem"}), IsNil)
+	c.Assert(tracker.Reserve(quota, 1, 0, []string{"mem"}), IsNil)
+	c.Assert(tracker.Reserve(quota, 1, 0, []string{"disk"}), NotNil)
+	c.Assert(tracker.Usage("db1").Series, Equals, 2)
+}
+
+func (self *QuotaSuite) TestConcurrentWritesDontExceedTheQuota(c *C) {
+	tracker := NewQuotaTracker()
+	quota := &cluster.DatabaseQuota{Database: "db1", PointsPerDay: 100}
+
+	var wait sync.WaitGroup
+	var lock sync.Mutex
+	accepted := 0
+	for i := 0; i < 50; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if tracker.Reserve(quota, 10, 0, nil) == nil {
+				lock.Lock()
+				accepted++
+				lock.Unlock()
+			}
+		}()
+	}
+	wait.Wait()
+	c.Assert(accepted, Equals, 10)
+	c.Assert(tracker.Usage("db1").Points, Equals, int64(100))
+}
+
+func (self *QuotaSuite) TestUsageIsLoadedAfterARestart(c *C) {
+	dir, err := ioutil.TempDir("", "quota")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, QUOTA_USAGE_FILE)
+
+	now := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	quota := &cluster.DatabaseQuota{Database: "db1", PointsPerDay: 100}
+	tracker := NewQuotaTracker()
+	tracker.now = func() time.Time { return now }
+	c.Assert(tracker.Reserve(quota, 60, 10, nil), IsNil)
+	c.Assert(tracker.Save(path), IsNil)
+
+	restarted := NewQuotaTracker()
+	restarted.now = func() time.Time { return now }
+	c.Assert(restarted.Load(path), IsNil)
+	c.Assert(restarted.Usage("db1").Points, Equals, int64(60))
+	c.Assert(restarted.Reserve(quota, 60, 0, nil), NotNil)
+
+	// the usage of the day before isn't loaded
+	now = now.Add(24 * time.Hour)
+	restarted = NewQuotaTracker()
+	restarted.now = func() time.Time { return now }
+	c.Assert(restarted.Load(path), IsNil)
+	c.Assert(restarted.Usage("db1").Points, Equals, int64(0))
+
+	// neither is a missing file an error
+	c.Assert(NewQuotaTracker().Load(filepath.Join(dir, "missing")), IsNil)
+}
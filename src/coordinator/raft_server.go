@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
@@ -19,6 +20,7 @@ import (
 	"protocol"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "code.google.com/p/log4go"
@@ -28,8 +30,14 @@ import (
 
 const (
 	DEFAULT_ROOT_PWD = "root"
+	// how long the servers wait for the stats that they ask each other
+	// for, a server that hangs shouldn't block the caller
+	RAFT_HTTP_TIMEOUT = 5 * time.Second
 )
 
+// The client of the requests for the stats of the other servers
+var raftHttpClient = &http.Client{Timeout: RAFT_HTTP_TIMEOUT}
+
 // The raftd server is a combination of the Raft server and an HTTP
 // server which acts as the transport.
 type RaftServer struct {
@@ -49,6 +57,16 @@ type RaftServer struct {
 	notLeader                chan bool
 	coordinator              *CoordinatorImpl
 	processContinuousQueries bool
+	// nil if there are no webhooks
+	webhooks *WebhookNotifier
+	// the index of the command that raft is applying and the index of
+	// the last command in the log when the server started. The commands
+	// up to it were applied before the restart and their events were
+	// posted then, they're only replayed.
+	applyingIndex uint64
+	startupIndex  uint64
+	// closed to stop syncing the usage of the quotas
+	stopQuotaSync chan bool
 }
 
 var registeredCommands bool
@@ -71,6 +89,11 @@ func NewRaftServer(config *configuration.Configuration, clusterConfig *cluster.C
 		notLeader:     make(chan bool, 1),
 		router:        mux.NewRouter(),
 		config:        config,
+		stopQuotaSync: make(chan bool),
+	}
+	if len(config.WebhookUrls) > 0 {
+		s.webhooks = NewWebhookNotifier(config.WebhookUrls, config.WebhookTimeout, clusterConfig.ServerId, s.isLeader)
+		clusterConfig.SetEventHandler(s)
 	}
 	// Read existing name or generate a new one.
 	if b, err := ioutil.ReadFile(filepath.Join(s.path, "name")); err == nil {
@@ -116,6 +139,22 @@ func (s *RaftServer) GetRaftName() string {
 	return s.name
 }
 
+// Returns the raft state of this server, i.e. leader, follower,
+// candidate or stopped
+func (s *RaftServer) State() string {
+	return s.raftServer.State()
+}
+
+// Returns the raft name of the leader or an empty string if there's no
+// leader
+func (s *RaftServer) Leader() string {
+	return s.raftServer.Leader()
+}
+
+func (s *RaftServer) MemberCount() int {
+	return s.raftServer.MemberCount()
+}
+
 func (s *RaftServer) leaderConnectString() (string, bool) {
 	leader := s.raftServer.Leader()
 	peers := s.raftServer.Peers()
@@ -127,6 +166,16 @@ func (s *RaftServer) leaderConnectString() (string, bool) {
 }
 
 func (s *RaftServer) doOrProxyCommand(command raft.Command, commandType string) (interface{}, error) {
+	raftCommands.Inc()
+	defer raftApplyLatency.Since(time.Now())
+	value, err := s.doOrProxyCommandWithRetries(command, commandType)
+	if err != nil {
+		raftCommandErrors.Inc()
+	}
+	return value, err
+}
+
+func (s *RaftServer) doOrProxyCommandWithRetries(command raft.Command, commandType string) (interface{}, error) {
 	var err error
 	var value interface{}
 	for i := 0; i < 3; i++ {
@@ -193,32 +242,78 @@ func (s *RaftServer) DropDatabase(name string) error {
 }
 
 func (s *RaftServer) SaveDbUser(u *cluster.DbUser) error {
-	command := NewSaveDbUserCommand(u)
-	_, err := s.doOrProxyCommand(command, "save_db_user")
+	encrypted, err := u.Encrypted()
+	if err != nil {
+		return err
+	}
+	command := NewSaveDbUserCommand(encrypted)
+	_, err = s.doOrProxyCommand(command, "save_db_user")
 	return err
 }
 
 func (s *RaftServer) ChangeDbUserPassword(db, username string, hash []byte) error {
-	command := NewChangeDbUserPasswordCommand(db, username, string(hash))
-	_, err := s.doOrProxyCommand(command, "change_db_user_password")
+	encrypted, err := cluster.EncryptCredential(string(hash))
+	if err != nil {
+		return err
+	}
+	command := NewChangeDbUserPasswordCommand(db, username, encrypted)
+	_, err = s.doOrProxyCommand(command, "change_db_user_password")
 	return err
 }
 
 func (s *RaftServer) SaveClusterAdminUser(u *cluster.ClusterAdmin) error {
-	command := NewSaveClusterAdminCommand(u)
-	_, err := s.doOrProxyCommand(command, "save_cluster_admin_user")
+	encrypted, err := u.Encrypted()
+	if err != nil {
+		return err
+	}
+	command := NewSaveClusterAdminCommand(encrypted)
+	_, err = s.doOrProxyCommand(command, "save_cluster_admin_user")
+	return err
+}
+
+func (s *RaftServer) SaveApiKey(key *cluster.ApiKey) error {
+	encrypted, err := key.Encrypted()
+	if err != nil {
+		return err
+	}
+	command := NewSaveApiKeyCommand(encrypted)
+	_, err = s.doOrProxyCommand(command, "save_api_key")
+	return err
+}
+
+func (s *RaftServer) SaveWriteSubscription(subscription *cluster.WriteSubscription) error {
+	command := NewSaveWriteSubscriptionCommand(subscription)
+	_, err := s.doOrProxyCommand(command, "save_write_subscription")
+	return err
+}
+
+func (s *RaftServer) SaveDatabaseQuota(quota *cluster.DatabaseQuota) error {
+	command := NewSaveDatabaseQuotaCommand(quota)
+	_, err := s.doOrProxyCommand(command, "save_database_quota")
+	return err
+}
+
+func (s *RaftServer) SaveTenant(tenant *cluster.Tenant) error {
+	command := NewSaveTenantCommand(tenant)
+	_, err := s.doOrProxyCommand(command, "save_tenant")
+	return err
+}
+
+func (s *RaftServer) SaveRole(role *cluster.Role) error {
+	command := NewSaveRoleCommand(role)
+	_, err := s.doOrProxyCommand(command, "save_role")
 	return err
 }
 
 func (s *RaftServer) CreateRootUser() error {
-	u := &cluster.ClusterAdmin{cluster.CommonUser{"root", "", false, "root"}}
+	u := &cluster.ClusterAdmin{CommonUser: cluster.CommonUser{Name: "root", CacheKey: "root"}}
 	hash, _ := cluster.HashPassword(DEFAULT_ROOT_PWD)
 	u.ChangePassword(string(hash))
 	return s.SaveClusterAdminUser(u)
 }
 
-func (s *RaftServer) SetContinuousQueryTimestamp(timestamp time.Time) error {
-	command := NewSetContinuousQueryTimestampCommand(timestamp)
+func (s *RaftServer) SetContinuousQueryTimestamp(timestamp time.Time, runs []*cluster.ContinuousQueryRun) error {
+	command := NewSetContinuousQueryTimestampCommand(timestamp, runs)
 	_, err := s.doOrProxyCommand(command, "set_cq_ts")
 	return err
 }
@@ -241,7 +336,7 @@ func (s *RaftServer) CreateContinuousQuery(db string, query string) error {
 	// if there are already-running queries, we need to initiate a backfill
 	if duration != nil && !s.clusterConfig.LastContinuousQueryRunTime().IsZero() {
 		zeroTime := time.Time{}
-		currentBoundary := time.Now().Truncate(*duration)
+		currentBoundary := common.Now().Truncate(*duration)
 		go s.runContinuousQuery(db, selectQuery, zeroTime, currentBoundary)
 	} else {
 		// TODO: make continuous queries backfill for queries that don't have a group by time
@@ -341,9 +436,17 @@ func (s *RaftServer) startRaft() error {
 	s.raftServer.LoadSnapshot() // ignore errors
 
 	s.raftServer.AddEventListener(raft.StateChangeEventType, s.raftEventHandler)
+	if s.webhooks != nil {
+		s.raftServer.AddEventListener(raft.CommitEventType, s.commitHandler)
+		s.raftServer.AddEventListener(raft.LeaderChangeEventType, s.leaderChangeHandler)
+		s.raftServer.AddEventListener(raft.RemovePeerEventType, s.removePeerHandler)
+	}
 
 	transporter.Install(s.raftServer, s)
+	// raft applies the committed commands of the log while it starts
+	atomic.StoreUint64(&s.startupIndex, math.MaxUint64)
 	s.raftServer.Start()
+	atomic.StoreUint64(&s.startupIndex, s.lastLogIndex())
 
 	go s.CompactLog()
 
@@ -412,6 +515,63 @@ func (s *RaftServer) raftEventHandler(e raft.Event) {
 	}
 }
 
+func (s *RaftServer) isLeader() bool {
+	return s.raftServer != nil && s.raftServer.State() == raft.Leader
+}
+
+// Every server applies the changes to the cluster configuration, only
+// the leader posts them to the webhooks
+func (s *RaftServer) ClusterEvent(eventType string, details map[string]interface{}) {
+	if s.isReplaying() {
+		return
+	}
+	s.webhooks.NotifyIfLeader(eventType, details)
+}
+
+// The commands after the commit index at startup can be applied after
+// the server started, e.g. once the leader tells the commit index again,
+// so the last command in the log is the last one that is replayed
+func (s *RaftServer) lastLogIndex() uint64 {
+	entries := s.raftServer.LogEntries()
+	if len(entries) == 0 {
+		return s.raftServer.CommitIndex()
+	}
+	return entries[len(entries)-1].Index()
+}
+
+// raft dispatches the commit event before it applies the command
+func (s *RaftServer) commitHandler(e raft.Event) {
+	if entry, ok := e.Value().(*raft.LogEntry); ok {
+		atomic.StoreUint64(&s.applyingIndex, entry.Index())
+	}
+}
+
+// Returns true if the command that raft is applying was in the log when
+// the server started
+func (s *RaftServer) isReplaying() bool {
+	return atomic.LoadUint64(&s.applyingIndex) <= atomic.LoadUint64(&s.startupIndex)
+}
+
+// raft dispatches the events with its lock held, the handlers can't
+// ask raft for its state
+func (s *RaftServer) leaderChangeHandler(e raft.Event) {
+	// the new leader reports itself
+	if e.Value() != s.name {
+		return
+	}
+	s.webhooks.Notify(LEADER_CHANGED_EVENT, map[string]interface{}{
+		"leader":         e.Value(),
+		"previousLeader": e.PrevValue(),
+	})
+}
+
+func (s *RaftServer) removePeerHandler(e raft.Event) {
+	if s.isReplaying() {
+		return
+	}
+	s.webhooks.NotifyIfLeader(SERVER_REMOVED_EVENT, map[string]interface{}{"raftName": e.Value()})
+}
+
 func (s *RaftServer) raftLeaderLoop(loopTimer *time.Ticker) {
 	for {
 		select {
@@ -439,11 +599,11 @@ func (s *RaftServer) checkContinuousQueries() {
 		return
 	}
 
-	runTime := time.Now()
-	queriesDidRun := false
+	runTime := common.Now()
+	runs := []*cluster.ContinuousQueryRun{}
 
 	for db, queries := range s.clusterConfig.ParsedContinuousQueries {
-		for _, query := range queries {
+		for id, query := range queries {
 			groupByClause := query.GetGroupByClause()
 
 			// if there's no group by clause, it's handled as a fanout query
@@ -462,31 +622,53 @@ func (s *RaftServer) checkContinuousQueries() {
 			lastBoundary := lastRun.Truncate(*duration)
 
 			if currentBoundary.After(lastRun) {
-				s.runContinuousQuery(db, query, lastBoundary, currentBoundary)
-				queriesDidRun = true
+				start := time.Now()
+				run := &cluster.ContinuousQueryRun{Database: db, Id: id}
+				if err := s.runContinuousQuery(db, query, lastBoundary, currentBoundary); err != nil {
+					log.Error("Error running continuous query %d of %s: %s", id, db, err)
+					run.Error = err.Error()
+				}
+				run.Duration = time.Now().Sub(start)
+				runs = append(runs, run)
 			}
 		}
 	}
 
-	if queriesDidRun {
+	if len(runs) > 0 {
 		s.clusterConfig.SetLastContinuousQueryRunTime(runTime)
-		s.SetContinuousQueryTimestamp(runTime)
+		s.SetContinuousQueryTimestamp(runTime, runs)
 	}
 }
 
-func (s *RaftServer) runContinuousQuery(db string, query *parser.SelectQuery, start time.Time, end time.Time) {
+// Returns the first error of the query or of the writes of its points
+func (s *RaftServer) runContinuousQuery(db string, query *parser.SelectQuery, start time.Time, end time.Time) error {
+	return s.runContinuousQueryWithThrottle(db, query, start, end, nil)
+}
+
+// Like runContinuousQuery, the writes of the points wait for the
+// throttle
+func (s *RaftServer) runContinuousQueryWithThrottle(db string, query *parser.SelectQuery, start time.Time, end time.Time, throttle *pointsThrottle) error {
 	adminName := s.clusterConfig.GetClusterAdmins()[0]
 	clusterAdmin := s.clusterConfig.GetClusterAdmin(adminName)
 	intoClause := query.GetIntoClause()
 	targetName := intoClause.Target.Name
 	queryString := query.GetQueryStringWithTimesAndNoIntoClause(start, end)
 
+	var writeErr error
 	f := func(series *protocol.Series) error {
-		return s.coordinator.InterpolateValuesAndCommit(query.GetQueryString(), db, series, targetName, true)
+		throttle.wait(len(series.Points))
+		err := s.coordinator.InterpolateValuesAndCommit(query.GetQueryString(), db, series, targetName, true)
+		if err != nil && writeErr == nil {
+			writeErr = err
+		}
+		return err
 	}
 
 	writer := NewContinuousQueryWriter(f)
-	s.coordinator.RunQuery(clusterAdmin, db, queryString, writer)
+	if err := s.coordinator.RunQuery(clusterAdmin, db, queryString, writer); err != nil {
+		return err
+	}
+	return writeErr
 }
 
 func (s *RaftServer) ListenAndServe() error {
@@ -510,10 +692,20 @@ func (s *RaftServer) Serve(l net.Listener) error {
 
 	s.router.HandleFunc("/cluster_config", s.configHandler).Methods("GET")
 	s.router.HandleFunc("/join", s.joinHandler).Methods("POST")
+	s.router.HandleFunc("/shard_stats", s.shardStatsHandler).Methods("GET")
+	s.router.HandleFunc("/quota_usage", s.quotaUsageHandler).Methods("GET")
 	s.router.HandleFunc("/process_command/{command_type}", s.processCommandHandler).Methods("POST")
 
 	log.Info("Raft Server Listening at %s", s.connectionString())
 
+	if s.webhooks != nil {
+		s.webhooks.Start()
+		if s.config.WebhookReplicaLagThreshold > 0 {
+			s.webhooks.StartLagMonitor(s.clusterConfig, s.config.WebhookReplicaLagThreshold)
+		}
+	}
+	go s.syncQuotaUsage()
+
 	go func() {
 		err := s.httpServer.Serve(l)
 		if !strings.Contains(err.Error(), "closed network") {
@@ -535,6 +727,10 @@ func (self *RaftServer) Close() {
 		self.raftServer.Stop()
 		self.listener.Close()
 		self.notLeader <- true
+		if self.webhooks != nil {
+			self.webhooks.Close()
+		}
+		close(self.stopQuotaSync)
 	}
 }
 
@@ -698,3 +894,31 @@ func (self *RaftServer) DropShard(id uint32, serverIds []uint32) error {
 	_, err := self.doOrProxyCommand(command, "drop_shard")
 	return err
 }
+
+// Moves the shard from one server to another. The shard is copied to
+// the new server in the background and dropped from the old one once
+// it's copied, it can be queried the whole time.
+func (self *RaftServer) MoveShard(id, from, to uint32) error {
+	shard := self.clusterConfig.GetShardById(id)
+	if shard == nil {
+		return fmt.Errorf("Shard %d doesn't exist", id)
+	}
+	if self.clusterConfig.GetServerById(&to) == nil {
+		return fmt.Errorf("Server %d doesn't exist", to)
+	}
+	hasFrom := false
+	for _, serverId := range shard.ServerIds() {
+		if serverId == to {
+			return fmt.Errorf("Server %d already has shard %d", to, id)
+		}
+		if serverId == from {
+			hasFrom = true
+		}
+	}
+	if !hasFrom {
+		return fmt.Errorf("Server %d doesn't have shard %d", from, id)
+	}
+	command := NewAddShardReplicaCommand(id, to, from)
+	_, err := self.doOrProxyCommand(command, "add_shard_replica")
+	return err
+}
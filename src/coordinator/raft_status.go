@@ -0,0 +1,109 @@
+package coordinator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"time"
+)
+
+type RaftPeerStatus struct {
+	Name             string `json:"name"`
+	ConnectionString string `json:"connectionString"`
+	// the last time the peer answered a heartbeat, only the leader
+	// sends heartbeats
+	LastContact *time.Time `json:"lastContact,omitempty"`
+}
+
+type RaftSnapshotStatus struct {
+	LastIndex uint64    `json:"lastIndex"`
+	LastTerm  uint64    `json:"lastTerm"`
+	Path      string    `json:"path"`
+	Time      time.Time `json:"time"`
+}
+
+// The raft state of this server as it's returned by /raft/status
+type RaftStatus struct {
+	Name         string `json:"name"`
+	State        string `json:"state"`
+	Term         uint64 `json:"term"`
+	Leader       string `json:"leader"`
+	VotedFor     string `json:"votedFor"`
+	CommitIndex  uint64 `json:"commitIndex"`
+	AppliedIndex uint64 `json:"appliedIndex"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	MemberCount  int    `json:"memberCount"`
+	QuorumSize   int    `json:"quorumSize"`
+	// the election timeout and the heartbeat interval as durations,
+	// e.g. 2s
+	ElectionTimeout   string              `json:"electionTimeout"`
+	HeartbeatInterval string              `json:"heartbeatInterval"`
+	Peers             []*RaftPeerStatus   `json:"peers"`
+	Snapshot          *RaftSnapshotStatus `json:"snapshot,omitempty"`
+}
+
+func (s *RaftServer) Status() *RaftStatus {
+	status := &RaftStatus{
+		Name:              s.raftServer.Name(),
+		State:             s.raftServer.State(),
+		Term:              s.raftServer.Term(),
+		Leader:            s.raftServer.Leader(),
+		VotedFor:          s.raftServer.VotedFor(),
+		CommitIndex:       s.raftServer.CommitIndex(),
+		MemberCount:       s.raftServer.MemberCount(),
+		QuorumSize:        s.raftServer.QuorumSize(),
+		ElectionTimeout:   s.raftServer.ElectionTimeout().String(),
+		HeartbeatInterval: s.raftServer.HeartbeatInterval().String(),
+		Peers:             []*RaftPeerStatus{},
+	}
+	// the commands are applied as soon as they're committed
+	status.AppliedIndex = status.CommitIndex
+	if entries := s.raftServer.LogEntries(); len(entries) > 0 {
+		status.LastLogIndex = entries[len(entries)-1].Index()
+	}
+
+	for _, peer := range s.raftServer.Peers() {
+		peerStatus := &RaftPeerStatus{Name: peer.Name, ConnectionString: peer.ConnectionString}
+		if lastActivity := peer.LastActivity(); !lastActivity.IsZero() {
+			peerStatus.LastContact = &lastActivity
+		}
+		status.Peers = append(status.Peers, peerStatus)
+	}
+	sort.Sort(raftPeersByName(status.Peers))
+
+	status.Snapshot = latestSnapshot(path.Join(s.path, "snapshot"))
+	return status
+}
+
+type raftPeersByName []*RaftPeerStatus
+
+func (self raftPeersByName) Len() int           { return len(self) }
+func (self raftPeersByName) Less(i, j int) bool { return self[i].Name < self[j].Name }
+func (self raftPeersByName) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+// Returns the snapshot with the highest index in the directory, raft
+// names them <term>_<index>.ss
+func latestSnapshot(dir string) *RaftSnapshotStatus {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var latest *RaftSnapshotStatus
+	for _, info := range infos {
+		var term, index uint64
+		if n, err := fmt.Sscanf(info.Name(), "%d_%d.ss", &term, &index); err != nil || n != 2 {
+			continue
+		}
+		if latest != nil && index <= latest.LastIndex {
+			continue
+		}
+		latest = &RaftSnapshotStatus{
+			LastIndex: index,
+			LastTerm:  term,
+			Path:      path.Join(dir, info.Name()),
+			Time:      info.ModTime(),
+		}
+	}
+	return latest
+}
@@ -0,0 +1,27 @@
+package coordinator
+
+import (
+	"io/ioutil"
+	"path"
+
+	. "launchpad.net/gocheck"
+)
+
+type RaftStatusSuite struct{}
+
+var _ = Suite(&RaftStatusSuite{})
+
+func (self *RaftStatusSuite) TestLatestSnapshot(c *C) {
+	dir := c.MkDir()
+	c.Assert(latestSnapshot(dir), IsNil)
+	c.Assert(latestSnapshot(path.Join(dir, "missing")), IsNil)
+
+	for _, name := range []string{"3_120.ss", "4_980.ss", "4_1000.ss", "notes.txt"} {
+		c.Assert(ioutil.WriteFile(path.Join(dir, name), nil, 0644), IsNil)
+	}
+	snapshot := latestSnapshot(dir)
+	c.Assert(snapshot, NotNil)
+	c.Assert(snapshot.LastTerm, Equals, uint64(4))
+	c.Assert(snapshot.LastIndex, Equals, uint64(1000))
+	c.Assert(snapshot.Path, Equals, path.Join(dir, "4_1000.ss"))
+}
@@ -0,0 +1,156 @@
+package coordinator
+
+import (
+	"cluster"
+	"common"
+	"fmt"
+	"io"
+	"protocol"
+
+	log "code.google.com/p/log4go"
+)
+
+// the points of a dump that are written at once
+const RESTORE_BATCH_SIZE = 5000
+
+// The progress of a restore. All the records of the dump up to Line
+// were restored, so an interrupted restore can be resumed from the
+// next line.
+type RestoreProgress struct {
+	Line   int    `json:"line"`
+	Points int64  `json:"points"`
+	Done   bool   `json:"done,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Replays a dump. The databases, the users and the continuous queries
+// that don't exist yet are created with raft commands, then the points
+// are written in batches and report is called after every batch. The
+// points on the lines up to skip were restored before and are skipped,
+// the rest of the dump is restored again since it can't change what
+// exists.
+func (self *CoordinatorImpl) RestoreDump(requester common.User, reader *cluster.DumpReader, skip int, report func(*RestoreProgress) error) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions to restore a dump")
+	}
+
+	progress := &RestoreProgress{Line: skip}
+	batchDb := ""
+	batch := []*protocol.Series{}
+	batchPoints := 0
+	lastLine := 0
+
+	flush := func() error {
+		if batchPoints > 0 {
+			if err := self.WriteSeriesData(requester, batchDb, batch); err != nil {
+				return err
+			}
+			progress.Points += int64(batchPoints)
+			batch = []*protocol.Series{}
+			batchPoints = 0
+		}
+		if lastLine <= progress.Line {
+			return nil
+		}
+		progress.Line = lastLine
+		return report(progress)
+	}
+
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if flushErr := flush(); flushErr != nil {
+				return flushErr
+			}
+			return err
+		}
+		line := reader.Line()
+
+		if record.Type == cluster.DUMP_SERIES {
+			if line <= skip {
+				continue
+			}
+			if record.Database != batchDb {
+				if err := flush(); err != nil {
+					return err
+				}
+				batchDb = record.Database
+			}
+			series, err := common.ConvertToDataStoreSeries(record.Series, common.MicrosecondPrecision)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", line, err)
+			}
+			batch = append(batch, series)
+			batchPoints += len(series.Points)
+			lastLine = line
+			if batchPoints >= RESTORE_BATCH_SIZE {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := flush(); err != nil {
+			return err
+		}
+		if err := self.restoreRecord(requester, record); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		if line > skip {
+			lastLine = line
+		}
+		if record.Type == cluster.DUMP_END {
+			progress.Done = true
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Creates the database, the user or the continuous query of the
+// record unless it exists
+func (self *CoordinatorImpl) restoreRecord(requester common.User, record *cluster.DumpRecord) error {
+	switch record.Type {
+	case cluster.DUMP_CLUSTER_ADMIN:
+		admin := record.ClusterAdmin
+		if admin == nil || self.clusterConfiguration.GetClusterAdmin(admin.Name) != nil {
+			return nil
+		}
+		log.Info("Restoring cluster admin %s", admin.Name)
+		admin.CacheKey = admin.Name
+		return self.raftServer.SaveClusterAdminUser(admin)
+	case cluster.DUMP_DATABASE:
+		if self.clusterConfiguration.DatabaseExists(record.Database) {
+			return nil
+		}
+		log.Info("Restoring database %s", record.Database)
+		replicationFactor := record.ReplicationFactor
+		if replicationFactor == 0 {
+			replicationFactor = 1
+		}
+		return self.CreateDatabase(requester, record.Database, replicationFactor)
+	case cluster.DUMP_USER:
+		user := record.User
+		if user == nil || self.clusterConfiguration.GetDbUser(record.Database, user.Name) != nil {
+			return nil
+		}
+		log.Info("Restoring user %s of %s", user.Name, record.Database)
+		user.Db = record.Database
+		user.CacheKey = record.Database + "%" + user.Name
+		return self.raftServer.SaveDbUser(user)
+	case cluster.DUMP_CONTINUOUS_QUERY:
+		for _, query := range self.clusterConfiguration.GetContinuousQueries(record.Database) {
+			if query.Query == record.Query {
+				return nil
+			}
+		}
+		log.Info("Restoring continuous query %s of %s", record.Query, record.Database)
+		return self.CreateContinuousQuery(requester, record.Database, record.Query)
+	}
+	return nil
+}
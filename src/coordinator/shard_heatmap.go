@@ -0,0 +1,66 @@
+package coordinator
+
+import (
+	"cluster"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "code.google.com/p/log4go"
+)
+
+// The load of the shards across the cluster, the hottest shards come
+// first
+type ShardHeatmap struct {
+	Shards []*cluster.ShardStats `json:"shards"`
+	// the servers that couldn't be asked for their stats, by id
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func (s *RaftServer) shardStatsHandler(w http.ResponseWriter, req *http.Request) {
+	js, err := json.Marshal(s.clusterConfig.LocalShardStats())
+	if err != nil {
+		log.Error("ERROR marshalling shard stats: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(js)
+}
+
+// Asks every server for the stats of the shards and merges them. The
+// servers that are down are reported in the errors of the heatmap
+func (s *RaftServer) ShardHeatmap() *ShardHeatmap {
+	localId := s.clusterConfig.ServerId()
+	serversStats := [][]*cluster.ShardStats{}
+	errors := map[string]string{}
+	for _, server := range s.clusterConfig.Servers() {
+		if server.Id == localId {
+			serversStats = append(serversStats, s.clusterConfig.LocalShardStats())
+			continue
+		}
+		stats, err := getShardStats(server.RaftConnectionString)
+		if err != nil {
+			log.Warn("Cannot get the shard stats of server %d: %s", server.Id, err)
+			errors[fmt.Sprintf("%d", server.Id)] = err.Error()
+			continue
+		}
+		serversStats = append(serversStats, stats)
+	}
+	return &ShardHeatmap{Shards: cluster.MergeShardStats(serversStats...), Errors: errors}
+}
+
+func getShardStats(raftConnectionString string) ([]*cluster.ShardStats, error) {
+	resp, err := raftHttpClient.Get(raftConnectionString + "/shard_stats")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Server returned %s", resp.Status)
+	}
+	stats := []*cluster.ShardStats{}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
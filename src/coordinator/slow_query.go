@@ -0,0 +1,78 @@
+package coordinator
+
+import (
+	"common"
+	"parser"
+	"protocol"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+const SLOW_QUERIES_SERIES = "slow_queries"
+
+// Logs the query if it took longer than the slow query threshold and
+// writes it to the monitoring database if record-slow-queries is set
+func (self *CoordinatorImpl) logSlowQuery(querySpec *parser.QuerySpec, shards int, pointsScanned int64, took time.Duration) {
+	threshold := self.slowQueryThreshold()
+	if threshold <= 0 || took <= threshold {
+		return
+	}
+	slowQueries.Inc()
+
+	user := querySpec.User()
+	db := querySpec.Database()
+	query := querySpec.GetQueryString()
+	log.Warn("Slow query: db: %s, u: %s, q: %s, took %s, shards: %d, points scanned: %d, request: %s",
+		db, user.GetName(), query, took, shards, pointsScanned, common.RequestId(user))
+
+	if !self.config.RecordSlowQueries {
+		return
+	}
+	monitoringDb := self.config.MonitoringDatabase
+	if !self.clusterConfiguration.DatabaseExists(monitoringDb) {
+		return
+	}
+	series := slowQuerySeries(db, user.GetName(), query, common.RequestId(user), took, shards, pointsScanned)
+	go func() {
+		if err := self.commitSeriesData(monitoringDb, []*protocol.Series{series}, ""); err != nil {
+			log.Error("Cannot record the slow query in %s: %s", monitoringDb, err)
+		}
+	}()
+}
+
+// Changes the threshold of the queries that are logged, 0 disables the
+// slow query log
+func (self *CoordinatorImpl) SetSlowQueryThreshold(threshold time.Duration) {
+	self.settingsLock.Lock()
+	defer self.settingsLock.Unlock()
+	self.config.SlowQueryThreshold = threshold
+}
+
+func (self *CoordinatorImpl) slowQueryThreshold() time.Duration {
+	self.settingsLock.RLock()
+	defer self.settingsLock.RUnlock()
+	return self.config.SlowQueryThreshold
+}
+
+func slowQuerySeries(db, user, query, requestId string, took time.Duration, shards int, pointsScanned int64) *protocol.Series {
+	durationMs := float64(took) / float64(time.Millisecond)
+	shardCount := int64(shards)
+	return &protocol.Series{
+		Name:   protocol.String(SLOW_QUERIES_SERIES),
+		Fields: []string{"database", "user", "query", "request_id", "duration_ms", "shards", "points_scanned"},
+		Points: []*protocol.Point{
+			&protocol.Point{
+				Values: []*protocol.FieldValue{
+					&protocol.FieldValue{StringValue: &db},
+					&protocol.FieldValue{StringValue: &user},
+					&protocol.FieldValue{StringValue: &query},
+					&protocol.FieldValue{StringValue: &requestId},
+					&protocol.FieldValue{DoubleValue: &durationMs},
+					&protocol.FieldValue{Int64Value: &shardCount},
+					&protocol.FieldValue{Int64Value: &pointsScanned},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package coordinator
+
+import (
+	"configuration"
+	"parser"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type SlowQuerySuite struct{}
+
+var _ = Suite(&SlowQuerySuite{})
+
+func (self *SlowQuerySuite) TestSlowQueryThreshold(c *C) {
+	coordinator := &CoordinatorImpl{config: &configuration.Configuration{SlowQueryThreshold: time.Second}}
+	querySpec := parser.NewQuerySpec(&MockUser{}, "db1", &parser.Query{QueryString: "select * from cpu"})
+
+	before := slowQueries.Value()
+	coordinator.logSlowQuery(querySpec, 2, 100, 500*time.Millisecond)
+	c.Assert(slowQueries.Value(), Equals, before)
+	coordinator.logSlowQuery(querySpec, 2, 100, 2*time.Second)
+	c.Assert(slowQueries.Value(), Equals, before+1)
+
+	coordinator.config.SlowQueryThreshold = 0
+	coordinator.logSlowQuery(querySpec, 2, 100, time.Hour)
+	c.Assert(slowQueries.Value(), Equals, before+1)
+}
+
+func (self *SlowQuerySuite) TestSlowQuerySeries(c *C) {
+	series := slowQuerySeries("db1", "paul", "select * from cpu", "abc", 1500*time.Millisecond, 3, 1000)
+	c.Assert(series.GetName(), Equals, SLOW_QUERIES_SERIES)
+	c.Assert(series.Points, HasLen, 1)
+	values := series.Points[0].Values
+	c.Assert(values, HasLen, len(series.Fields))
+	c.Assert(values[2].GetStringValue(), Equals, "select * from cpu")
+	c.Assert(values[4].GetDoubleValue(), Equals, 1500.0)
+	c.Assert(values[5].GetInt64Value(), Equals, int64(3))
+	c.Assert(values[6].GetInt64Value(), Equals, int64(1000))
+}
@@ -0,0 +1,153 @@
+package coordinator
+
+import (
+	"common"
+	"engine"
+	"fmt"
+	"parser"
+	"protocol"
+	"sync"
+	"sync/atomic"
+
+	log "code.google.com/p/log4go"
+)
+
+// how many series a subscription buffers before it starts dropping
+// the writes that match it
+const SUBSCRIPTION_BUFFER_SIZE = 1000
+
+// A live query. The points written through this server that match the
+// query are sent on Series as they're written. Only raw queries on
+// series names or regexes are supported, the time conditions of the
+// query are ignored. Only the series that the user can read are sent.
+type Subscription struct {
+	Id      uint64
+	Series  chan *protocol.Series
+	db      string
+	user    common.User
+	query   *parser.SelectQuery
+	dropped int64
+}
+
+// Returns the number of series that were dropped because the
+// subscriber didn't keep up with the writes
+func (self *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&self.dropped)
+}
+
+func (self *Subscription) matchesSeries(name string) bool {
+	for _, table := range self.query.GetFromClause().Names {
+		if regex, ok := table.Name.GetCompiledRegex(); ok {
+			if regex.MatchString(name) {
+				return true
+			}
+		} else if table.Name.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the series with the points that match the query or
+// nil if none of them match
+func (self *Subscription) filter(series *protocol.Series) (*protocol.Series, error) {
+	if !self.matchesSeries(series.GetName()) || !self.user.HasReadAccess(series.GetName()) {
+		return nil, nil
+	}
+	points := make([]*protocol.Point, 0, len(series.Points))
+	for _, point := range series.Points {
+		p := *point
+		points = append(points, &p)
+	}
+	fields := make([]string, len(series.Fields))
+	copy(fields, series.Fields)
+	filtered, err := engine.Filter(self.query, &protocol.Series{Name: series.Name, Fields: fields, Points: points})
+	if err != nil || len(filtered.Points) == 0 {
+		return nil, err
+	}
+	return filtered, nil
+}
+
+type subscriptions struct {
+	lock   sync.RWMutex
+	nextId uint64
+	byId   map[uint64]*Subscription
+}
+
+// Registers a live query on the database, the subscription has to be
+// removed with Unsubscribe once the subscriber is gone
+func (self *CoordinatorImpl) Subscribe(user common.User, db string, query string) (*Subscription, error) {
+	queries, err := parser.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) != 1 || queries[0].SelectQuery == nil {
+		return nil, fmt.Errorf("Only a single select query can be subscribed to")
+	}
+	selectQuery := queries[0].SelectQuery
+	groupBy := selectQuery.GetGroupByClause()
+	if selectQuery.HasAggregates() || selectQuery.IntoClause != nil || (groupBy != nil && len(groupBy.Elems) > 0) ||
+		selectQuery.GetFromClause().Type != parser.FromClauseArray {
+		return nil, fmt.Errorf("Only raw select queries without joins or merges can be subscribed to")
+	}
+	if err := self.authorizeQuery(user, db, queries[0]); err != nil {
+		return nil, err
+	}
+
+	self.subscriptions.lock.Lock()
+	defer self.subscriptions.lock.Unlock()
+	if self.subscriptions.byId == nil {
+		self.subscriptions.byId = map[uint64]*Subscription{}
+	}
+	self.subscriptions.nextId++
+	subscription := &Subscription{
+		Id:     self.subscriptions.nextId,
+		Series: make(chan *protocol.Series, SUBSCRIPTION_BUFFER_SIZE),
+		db:     db,
+		user:   user,
+		query:  selectQuery,
+	}
+	self.subscriptions.byId[subscription.Id] = subscription
+	log.Debug("Subscription %d to %s: %s", subscription.Id, db, query)
+	return subscription, nil
+}
+
+// Removes the subscription and closes its channel
+func (self *CoordinatorImpl) Unsubscribe(subscription *Subscription) {
+	self.subscriptions.lock.Lock()
+	defer self.subscriptions.lock.Unlock()
+	if _, ok := self.subscriptions.byId[subscription.Id]; !ok {
+		return
+	}
+	delete(self.subscriptions.byId, subscription.Id)
+	close(subscription.Series)
+}
+
+// Sends the series that were written to the subscriptions they match,
+// it never blocks the write
+func (self *CoordinatorImpl) publish(db string, serieses []*protocol.Series) {
+	self.subscriptions.lock.RLock()
+	defer self.subscriptions.lock.RUnlock()
+	for _, subscription := range self.subscriptions.byId {
+		if subscription.db != db {
+			continue
+		}
+		for _, series := range serieses {
+			filtered, err := subscription.filter(series)
+			if err != nil {
+				log.Debug("Cannot filter series %s for subscription %d: %s", series.GetName(), subscription.Id, err)
+				continue
+			}
+			if filtered == nil {
+				continue
+			}
+			select {
+			case subscription.Series <- filtered:
+			default:
+				if atomic.AddInt64(&subscription.dropped, 1) == 1 {
+					log.Warn("Subscription %d isn't keeping up with the writes to %s, dropping series", subscription.Id, db)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package coordinator
+
+import (
+	"common"
+	"configuration"
+	"protocol"
+
+	"code.google.com/p/goprotobuf/proto"
+	. "launchpad.net/gocheck"
+)
+
+type SubscriptionsSuite struct{}
+
+var _ = Suite(&SubscriptionsSuite{})
+
+func subscriptionSeries(name string, values ...int64) *protocol.Series {
+	series := &protocol.Series{Name: proto.String(name), Fields: []string{"value"}}
+	for _, value := range values {
+		series.Points = append(series.Points, &protocol.Point{
+			Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(value)}},
+			Timestamp:      proto.Int64(1),
+			SequenceNumber: proto.Uint64(1),
+		})
+	}
+	return series
+}
+
+func (self *SubscriptionsSuite) TestPublish(c *C) {
+	coordinator := NewCoordinatorImpl(&configuration.Configuration{}, nil, nil)
+	subscription, err := coordinator.Subscribe(&MockUser{}, "db1", "select value from /^cpu.*/ where value > 5")
+	c.Assert(err, IsNil)
+
+	coordinator.publish("db2", []*protocol.Series{subscriptionSeries("cpu", 10)})
+	coordinator.publish("db1", []*protocol.Series{
+		subscriptionSeries("memory", 10),
+		subscriptionSeries("cpu.idle", 1, 6, 7),
+	})
+	c.Assert(subscription.Series, HasLen, 1)
+	series := <-subscription.Series
+	c.Assert(series.GetName(), Equals, "cpu.idle")
+	c.Assert(series.Points, HasLen, 2)
+	c.Assert(series.Points[0].Values[0].GetInt64Value(), Equals, int64(6))
+
+	coordinator.Unsubscribe(subscription)
+	_, ok := <-subscription.Series
+	c.Assert(ok, Equals, false)
+	// unsubscribing twice is fine
+	coordinator.Unsubscribe(subscription)
+}
+
+func (self *SubscriptionsSuite) TestPublishDropsSeriesWhenTheBufferIsFull(c *C) {
+	coordinator := NewCoordinatorImpl(&configuration.Configuration{}, nil, nil)
+	subscription, err := coordinator.Subscribe(&MockUser{}, "db1", "select * from cpu")
+	c.Assert(err, IsNil)
+	for i := 0; i < SUBSCRIPTION_BUFFER_SIZE+2; i++ {
+		coordinator.publish("db1", []*protocol.Series{subscriptionSeries("cpu", 1)})
+	}
+	c.Assert(subscription.Series, HasLen, SUBSCRIPTION_BUFFER_SIZE)
+	c.Assert(subscription.Dropped(), Equals, int64(2))
+}
+
+func (self *SubscriptionsSuite) TestSubscribeRejectsInvalidQueries(c *C) {
+	coordinator := NewCoordinatorImpl(&configuration.Configuration{}, nil, nil)
+	for _, query := range []string{
+		"select count(value) from cpu",
+		"select value from cpu group by host",
+		"select * from cpu merge memory",
+		"list series",
+	} {
+		_, err := coordinator.Subscribe(&MockUser{}, "db1", query)
+		c.Assert(err, NotNil)
+	}
+}
+
+type denyingAuthorizer struct {
+	requests []*AuthorizationRequest
+}
+
+func (self *denyingAuthorizer) Authorize(request *AuthorizationRequest) error {
+	self.requests = append(self.requests, request)
+	return common.NewAuthorizationError("Denied")
+}
+
+func (self *SubscriptionsSuite) TestSubscribeAsksTheAuthorizer(c *C) {
+	coordinator := NewCoordinatorImpl(&configuration.Configuration{}, nil, nil)
+	authorizer := &denyingAuthorizer{}
+	coordinator.SetAuthorizer(authorizer)
+	_, err := coordinator.Subscribe(&MockUser{}, "db1", "select * from cpu")
+	c.Assert(err, FitsTypeOf, common.AuthorizationError(""))
+	c.Assert(authorizer.requests, HasLen, 1)
+	c.Assert(authorizer.requests[0].Operation, Equals, AUTHORIZE_READ)
+	c.Assert(authorizer.requests[0].Series, DeepEquals, []string{"cpu"})
+	c.Assert(coordinator.subscriptions.byId, HasLen, 0)
+}
+
+func (self *SubscriptionsSuite) TestPublishSkipsSeriesTheUserCannotRead(c *C) {
+	coordinator := NewCoordinatorImpl(&configuration.Configuration{}, nil, nil)
+	user := &MockUser{dbCannotRead: map[string]bool{"cpu.secret": true}}
+	subscription, err := coordinator.Subscribe(user, "db1", "select * from /^cpu.*/")
+	c.Assert(err, IsNil)
+	coordinator.publish("db1", []*protocol.Series{
+		subscriptionSeries("cpu.secret", 1),
+		subscriptionSeries("cpu.idle", 1),
+	})
+	c.Assert(subscription.Series, HasLen, 1)
+	series := <-subscription.Series
+	c.Assert(series.GetName(), Equals, "cpu.idle")
+}
@@ -0,0 +1,184 @@
+package coordinator
+
+import (
+	"cluster"
+	"common"
+	"fmt"
+	"math"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// The usage of the databases of a tenant across the cluster. The series
+// and the size on disk are only tracked if the tenant has a limit on
+// them.
+type TenantUsage struct {
+	Series    int   `json:"series"`
+	DiskBytes int64 `json:"diskBytes"`
+	// the points written today
+	Points int64 `json:"points"`
+}
+
+// A token bucket that holds a second of points of a tenant
+type tenantRate struct {
+	pointsPerSecond int
+	tokens          float64
+	last            time.Time
+}
+
+// Returns the usage of the databases of the tenant, the lock must be
+// held
+func (self *QuotaTracker) tenantUsage(tenant *cluster.Tenant) *TenantUsage {
+	usage := &TenantUsage{}
+	for _, db := range tenant.Databases {
+		u := self.usage(db)
+		usage.Series += u.Series
+		usage.DiskBytes += u.DiskBytes
+		usage.Points += u.Points
+	}
+	return usage
+}
+
+func (self *QuotaTracker) TenantUsage(tenant *cluster.Tenant) *TenantUsage {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.tenantUsage(tenant)
+}
+
+// Returns an error if writing the points to the database would exceed
+// one of the limits of its tenant. The points are taken from the write
+// rate of the tenant on this server once the other limits are checked,
+// they count even if the write fails.
+func (self *QuotaTracker) CheckTenant(tenant *cluster.Tenant, db string, points int64, names []string) error {
+	if tenant == nil {
+		return nil
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	usage := self.tenantUsage(tenant)
+	if tenant.MaxDiskBytes > 0 && usage.DiskBytes >= tenant.MaxDiskBytes {
+		return common.NewQuotaExceededError("Tenant %s exceeded its quota of %d bytes on disk", tenant.Name, tenant.MaxDiskBytes)
+	}
+	if tenant.MaxSeries > 0 {
+		series := usage.Series
+		for _, name := range names {
+			if !self.series[db][name] {
+				series++
+			}
+		}
+		if series > tenant.MaxSeries {
+			return common.NewQuotaExceededError("Tenant %s exceeded its quota of %d series", tenant.Name, tenant.MaxSeries)
+		}
+	}
+	if tenant.PointsPerSecond > 0 && !self.takeTenantPoints(tenant, points) {
+		return common.NewWriteRateExceededError("Tenant %s exceeded its write rate of %d points per second", tenant.Name, tenant.PointsPerSecond)
+	}
+	return nil
+}
+
+// Returns false if the tenant doesn't have enough points left in the
+// current second. Every server takes an even share of the write rate of
+// the tenant, so the writes have to be spread over the servers to reach
+// the whole rate. A write with more points than the tenant can write in
+// a second is allowed when the bucket is full. The lock must be held.
+func (self *QuotaTracker) takeTenantPoints(tenant *cluster.Tenant, points int64) bool {
+	now := self.now()
+	pointsPerSecond := tenant.PointsPerSecond
+	if servers := self.servers(); servers > 1 {
+		pointsPerSecond = (pointsPerSecond + servers - 1) / servers
+	}
+	rate := self.rates[tenant.Name]
+	if rate == nil || rate.pointsPerSecond != pointsPerSecond {
+		rate = &tenantRate{pointsPerSecond, float64(pointsPerSecond), now}
+		self.rates[tenant.Name] = rate
+	}
+	capacity := float64(rate.pointsPerSecond)
+	rate.tokens = math.Min(capacity, rate.tokens+now.Sub(rate.last).Seconds()*capacity)
+	rate.last = now
+	if rate.tokens < math.Min(float64(points), capacity) {
+		return false
+	}
+	rate.tokens -= float64(points)
+	return true
+}
+
+func (self *CoordinatorImpl) ListTenants(requester common.User) ([]*cluster.Tenant, error) {
+	if !requester.IsClusterAdmin() {
+		return nil, common.NewAuthorizationError("Insufficient permissions")
+	}
+	return self.clusterConfiguration.GetTenants(), nil
+}
+
+// Returns the tenant and its usage, nil if it doesn't exist
+func (self *CoordinatorImpl) GetTenant(requester common.User, name string) (*cluster.Tenant, *TenantUsage, error) {
+	if !requester.IsClusterAdmin() {
+		return nil, nil, common.NewAuthorizationError("Insufficient permissions")
+	}
+	tenant := self.clusterConfiguration.GetTenant(name)
+	if tenant == nil {
+		return nil, nil, nil
+	}
+	return tenant, self.quotas.TenantUsage(tenant), nil
+}
+
+// Creates the tenant or replaces its databases and limits, a database
+// can only belong to one tenant
+func (self *CoordinatorImpl) SaveTenant(requester common.User, tenant *cluster.Tenant) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+	if err := tenant.Validate(); err != nil {
+		return err
+	}
+	for _, db := range tenant.Databases {
+		if !self.clusterConfiguration.DatabaseExists(db) {
+			return fmt.Errorf("Database %s doesn't exist", db)
+		}
+		if owner := self.clusterConfiguration.GetDatabaseTenant(db); owner != nil && owner.Name != tenant.Name {
+			return fmt.Errorf("Database %s already belongs to tenant %s", db, owner.Name)
+		}
+	}
+	tenant.CreatedBy = requester.GetName()
+	tenant.IsDeleted = false
+	return self.raftServer.SaveTenant(tenant)
+}
+
+// Drops the tenant, its databases and their users are kept
+func (self *CoordinatorImpl) DropTenant(requester common.User, name string) error {
+	if !requester.IsClusterAdmin() {
+		return common.NewAuthorizationError("Insufficient permissions")
+	}
+	tenant := self.clusterConfiguration.GetTenant(name)
+	if tenant == nil {
+		return fmt.Errorf("Tenant %s doesn't exist", name)
+	}
+
+	deleted := *tenant
+	deleted.IsDeleted = true
+	return self.raftServer.SaveTenant(&deleted)
+}
+
+// Adds a database that a user of the tenant created to the tenant
+func (self *CoordinatorImpl) addTenantDatabase(tenant *cluster.Tenant, db string) error {
+	updated := *tenant
+	updated.Databases = append(append([]string{}, tenant.Databases...), db)
+	log.Info("Adding the database %s to tenant %s", db, tenant.Name)
+	return self.raftServer.SaveTenant(&updated)
+}
+
+// Measures the size of the databases of the tenants that have a limit on
+// it in the shards of this server
+func (self *CoordinatorImpl) refreshTenantDisk() {
+	sizes := map[string]int64{}
+	for _, tenant := range self.clusterConfiguration.GetTenants() {
+		if tenant.MaxDiskBytes == 0 {
+			continue
+		}
+		for _, db := range tenant.Databases {
+			sizes[db] = self.clusterConfiguration.LocalDatabaseSize(db)
+		}
+	}
+	self.quotas.SetDisk(sizes)
+}
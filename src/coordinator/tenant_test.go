@@ -0,0 +1,70 @@
+package coordinator
+
+import (
+	"cluster"
+	"common"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type TenantSuite struct{}
+
+var _ = Suite(&TenantSuite{})
+
+func (self *TenantSuite) TestMaxSeriesAcrossDatabases(c *C) {
+	tracker := NewQuotaTracker()
+	tenant := &cluster.Tenant{Name: "acme", Databases: []string{"db1", "db2"}, MaxSeries: 3}
+	tracker.SetSeries("db1", map[string]bool{"cpu": true, "mem": true})
+
+	c.Assert(tracker.CheckTenant(tenant, "db2", 1, []string{"cpu"}), IsNil)
+	tracker.AddSeries("db2", []string{"cpu"})
+	err := tracker.CheckTenant(tenant, "db2", 1, []string{"disk"})
+	c.Assert(err, FitsTypeOf, common.QuotaExceededError(""))
+	c.Assert(tracker.CheckTenant(tenant, "db1", 1, []string{"mem"}), IsNil)
+	c.Assert(tracker.TenantUsage(tenant).Series, Equals, 3)
+}
+
+func (self *TenantSuite) TestMaxDiskBytes(c *C) {
+	tracker := NewQuotaTracker()
+	tenant := &cluster.Tenant{Name: "acme", Databases: []string{"db1", "db2"}, MaxDiskBytes: 1000}
+
+	tracker.SetDisk(map[string]int64{"db1": 600})
+	c.Assert(tracker.CheckTenant(tenant, "db1", 1, nil), IsNil)
+	// the size on the other servers counts too
+	tracker.SetRemote(2, map[string]*QuotaUsage{"db2": &QuotaUsage{Day: tracker.Local()["db1"].Day, DiskBytes: 400}})
+	c.Assert(tracker.CheckTenant(tenant, "db1", 1, nil), NotNil)
+	c.Assert(tracker.TenantUsage(tenant).DiskBytes, Equals, int64(1000))
+	c.Assert(tracker.Local()["db1"].DiskBytes, Equals, int64(600))
+}
+
+func (self *TenantSuite) TestPointsPerSecond(c *C) {
+	now := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewQuotaTracker()
+	tracker.now = func() time.Time { return now }
+	tenant := &cluster.Tenant{Name: "acme", Databases: []string{"db1"}, PointsPerSecond: 100}
+
+	c.Assert(tracker.CheckTenant(tenant, "db1", 60, nil), IsNil)
+	err := tracker.CheckTenant(tenant, "db1", 60, nil)
+	c.Assert(err, FitsTypeOf, common.WriteRateExceededError(""))
+
+	now = now.Add(500 * time.Millisecond)
+	c.Assert(tracker.CheckTenant(tenant, "db1", 60, nil), IsNil)
+
+	// a write that is larger than a second of points gets through once
+	// the bucket is full
+	now = now.Add(time.Second)
+	c.Assert(tracker.CheckTenant(tenant, "db1", 500, nil), IsNil)
+	c.Assert(tracker.CheckTenant(tenant, "db1", 1, nil), NotNil)
+}
+
+func (self *TenantSuite) TestPointsPerSecondAreSplitBetweenTheServers(c *C) {
+	now := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewQuotaTracker()
+	tracker.now = func() time.Time { return now }
+	tracker.servers = func() int { return 3 }
+	tenant := &cluster.Tenant{Name: "acme", Databases: []string{"db1"}, PointsPerSecond: 300}
+
+	c.Assert(tracker.CheckTenant(tenant, "db1", 100, nil), IsNil)
+	c.Assert(tracker.CheckTenant(tenant, "db1", 1, nil), NotNil)
+}
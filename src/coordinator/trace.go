@@ -0,0 +1,34 @@
+package coordinator
+
+import (
+	"common"
+	"protocol"
+	"time"
+)
+
+// Records the stage of the query in the trace of the user, if the
+// query is traced
+func (self *CoordinatorImpl) traceStage(user common.User, name string, shardId uint32, start time.Time) {
+	if trace := common.GetTrace(user); trace != nil {
+		trace.Time(name, self.clusterConfiguration.ServerId(), shardId, start)
+	}
+}
+
+// Forwards the responses of the shard and records the time it took to
+// get all of them, along with the stages the remote servers timed
+func (self *CoordinatorImpl) traceShardResponses(trace *common.Trace, shardId uint32, responses <-chan *protocol.Response, bufferSize int) <-chan *protocol.Response {
+	start := time.Now()
+	traced := make(chan *protocol.Response, bufferSize)
+	go func() {
+		for response := range responses {
+			if response.GetType() == endStreamResponse || response.GetType() == accessDeniedResponse {
+				trace.Add(common.TraceSpansFromProtobuf(response.TraceSpans)...)
+				trace.Time("shard_fetch", self.clusterConfiguration.ServerId(), shardId, start)
+				traced <- response
+				return
+			}
+			traced <- response
+		}
+	}()
+	return traced
+}
@@ -0,0 +1,194 @@
+package coordinator
+
+import (
+	"bytes"
+	"cluster"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// The events that the raft server and the lag monitor report, the
+// other events come from the cluster configuration
+const (
+	SERVER_REMOVED_EVENT    = "server_removed"
+	LEADER_CHANGED_EVENT    = "leader_changed"
+	REPLICA_LAGGING_EVENT   = "replica_lagging"
+	REPLICA_CAUGHT_UP_EVENT = "replica_caught_up"
+)
+
+const (
+	// the events that are waiting to be posted, the new events are
+	// dropped once it's full
+	WEBHOOK_QUEUE_SIZE         = 1000
+	WEBHOOK_ATTEMPTS           = 3
+	WEBHOOK_RETRY_DELAY        = time.Second
+	REPLICA_LAG_CHECK_INTERVAL = 10 * time.Second
+)
+
+// The body of the webhook posts
+type ClusterEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// the server that reported the event
+	ServerId uint32                 `json:"serverId"`
+	Details  map[string]interface{} `json:"details"`
+	// the events of the whole cluster are only posted by the leader
+	leaderOnly bool
+}
+
+// Posts the events of the cluster to the webhook urls. The events are
+// posted in order by a single worker, so a slow url delays the events
+// but never the cluster.
+type WebhookNotifier struct {
+	urls     []string
+	client   *http.Client
+	serverId func() uint32
+	isLeader func() bool
+	events   chan *ClusterEvent
+	shutdown chan bool
+	done     chan bool
+	// the servers that were reported as lagging
+	lagLock        sync.Mutex
+	laggingServers map[uint32]bool
+}
+
+func NewWebhookNotifier(urls []string, timeout time.Duration, serverId func() uint32, isLeader func() bool) *WebhookNotifier {
+	return &WebhookNotifier{
+		urls:           urls,
+		client:         &http.Client{Timeout: timeout},
+		serverId:       serverId,
+		isLeader:       isLeader,
+		events:         make(chan *ClusterEvent, WEBHOOK_QUEUE_SIZE),
+		shutdown:       make(chan bool),
+		done:           make(chan bool),
+		laggingServers: map[uint32]bool{},
+	}
+}
+
+func (self *WebhookNotifier) Start() {
+	go self.run()
+}
+
+func (self *WebhookNotifier) Close() {
+	close(self.shutdown)
+	<-self.done
+}
+
+// Queues the event, it never blocks
+func (self *WebhookNotifier) Notify(eventType string, details map[string]interface{}) {
+	self.queue(&ClusterEvent{Type: eventType, Time: time.Now(), Details: details})
+}
+
+// Queues the event, it's only posted if this server is the leader.
+// The leadership is checked by the worker, so this can be called with
+// the locks of raft held.
+func (self *WebhookNotifier) NotifyIfLeader(eventType string, details map[string]interface{}) {
+	self.queue(&ClusterEvent{Type: eventType, Time: time.Now(), Details: details, leaderOnly: true})
+}
+
+func (self *WebhookNotifier) queue(event *ClusterEvent) {
+	select {
+	case self.events <- event:
+	default:
+		log.Warn("Webhooks: the queue is full, dropping the %s event", event.Type)
+	}
+}
+
+func (self *WebhookNotifier) run() {
+	defer close(self.done)
+	for {
+		select {
+		case <-self.shutdown:
+			return
+		case event := <-self.events:
+			if event.leaderOnly && !self.isLeader() {
+				continue
+			}
+			event.ServerId = self.serverId()
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Error("Webhooks: cannot serialize the %s event: %s", event.Type, err)
+				continue
+			}
+			for _, url := range self.urls {
+				self.postWithRetries(url, event.Type, data)
+			}
+		}
+	}
+}
+
+func (self *WebhookNotifier) postWithRetries(url, eventType string, data []byte) {
+	for attempt := 1; ; attempt++ {
+		err := self.post(url, data)
+		if err == nil {
+			return
+		}
+		if attempt >= WEBHOOK_ATTEMPTS {
+			log.Error("Webhooks: dropping the %s event for %s: %s", eventType, url, err)
+			return
+		}
+		select {
+		case <-self.shutdown:
+			return
+		case <-time.After(WEBHOOK_RETRY_DELAY):
+		}
+	}
+}
+
+func (self *WebhookNotifier) post(url string, data []byte) error {
+	resp, err := self.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("the webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Reports the servers that get more than threshold requests behind and
+// the ones that catch up
+func (self *WebhookNotifier) StartLagMonitor(clusterConfig *cluster.ClusterConfiguration, threshold int) {
+	go func() {
+		ticker := time.NewTicker(REPLICA_LAG_CHECK_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-self.shutdown:
+				return
+			case <-ticker.C:
+				lags := map[uint32]uint32{}
+				for _, server := range clusterConfig.Servers() {
+					lags[server.Id] = server.WriteLag()
+				}
+				self.checkLag(lags, uint32(threshold))
+			}
+		}
+	}()
+}
+
+func (self *WebhookNotifier) checkLag(lags map[uint32]uint32, threshold uint32) {
+	self.lagLock.Lock()
+	defer self.lagLock.Unlock()
+	for serverId, lag := range lags {
+		lagging := lag > threshold
+		if lagging == self.laggingServers[serverId] {
+			continue
+		}
+		self.laggingServers[serverId] = lagging
+		eventType := REPLICA_CAUGHT_UP_EVENT
+		if lagging {
+			eventType = REPLICA_LAGGING_EVENT
+		}
+		self.Notify(eventType, map[string]interface{}{"id": serverId, "lag": lag, "threshold": threshold})
+	}
+}
@@ -0,0 +1,79 @@
+package coordinator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type WebhooksSuite struct{}
+
+var _ = Suite(&WebhooksSuite{})
+
+func (self *WebhooksSuite) TestPostsTheEvents(c *C) {
+	events := make(chan *ClusterEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &ClusterEvent{}
+		if err := json.NewDecoder(r.Body).Decode(event); err == nil {
+			events <- event
+		}
+	}))
+	defer server.Close()
+
+	leader := false
+	notifier := NewWebhookNotifier([]string{server.URL}, time.Second, func() uint32 { return 2 }, func() bool { return leader })
+	notifier.Start()
+	defer notifier.Close()
+
+	// only the leader posts the events of the cluster
+	notifier.NotifyIfLeader("shard_created", map[string]interface{}{"id": 1})
+	notifier.Notify(LEADER_CHANGED_EVENT, map[string]interface{}{"leader": "abc"})
+
+	select {
+	case event := <-events:
+		c.Assert(event.Type, Equals, LEADER_CHANGED_EVENT)
+		c.Assert(event.ServerId, Equals, uint32(2))
+		c.Assert(event.Details["leader"], Equals, "abc")
+	case <-time.After(5 * time.Second):
+		c.Fatal("The event wasn't posted")
+	}
+	select {
+	case event := <-events:
+		c.Fatalf("Unexpected event %s", event.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (self *WebhooksSuite) TestReportsTheLaggingReplicasOnce(c *C) {
+	notifier := NewWebhookNotifier(nil, time.Second, func() uint32 { return 1 }, func() bool { return true })
+	notifier.checkLag(map[uint32]uint32{2: 10, 3: 500}, 100)
+	notifier.checkLag(map[uint32]uint32{2: 10, 3: 600}, 100)
+	notifier.checkLag(map[uint32]uint32{2: 10, 3: 50}, 100)
+
+	c.Assert(notifier.events, HasLen, 2)
+	event := <-notifier.events
+	c.Assert(event.Type, Equals, REPLICA_LAGGING_EVENT)
+	c.Assert(event.Details["id"], Equals, uint32(3))
+	c.Assert(event.Details["lag"], Equals, uint32(500))
+	event = <-notifier.events
+	c.Assert(event.Type, Equals, REPLICA_CAUGHT_UP_EVENT)
+}
+
+// the commands that raft replays at startup were posted before the
+// restart
+func (self *WebhooksSuite) TestReplayedCommandsArentPosted(c *C) {
+	notifier := NewWebhookNotifier(nil, time.Second, func() uint32 { return 1 }, func() bool { return true })
+	server := &RaftServer{webhooks: notifier, startupIndex: 5}
+
+	server.applyingIndex = 3
+	server.ClusterEvent("shard_created", map[string]interface{}{"id": 1})
+	c.Assert(notifier.events, HasLen, 0)
+
+	server.applyingIndex = 6
+	server.ClusterEvent("shard_created", map[string]interface{}{"id": 2})
+	c.Assert(notifier.events, HasLen, 1)
+	c.Assert((<-notifier.events).Details["id"], Equals, 2)
+}
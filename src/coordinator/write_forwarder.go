@@ -0,0 +1,185 @@
+package coordinator
+
+import (
+	"bytes"
+	"cluster"
+	"common"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"protocol"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+const (
+	// destinations that didn't get any writes for this long are closed,
+	// they're started again on the next write
+	FORWARD_IDLE_TIMEOUT = 10 * time.Minute
+	FORWARD_HTTP_TIMEOUT = 10 * time.Second
+)
+
+// Forwards copies of the writes to the destinations of the write
+// subscriptions. Every destination has its own buffer and worker, so a
+// slow or unreachable destination doesn't hold up the writes or the
+// other destinations. The buffered writes are lost if the server
+// stops.
+type WriteForwarder struct {
+	lock         sync.Mutex
+	destinations map[string]*forwardDestination
+	bufferSize   int
+	maxRetries   int
+	client       *http.Client
+	lastPrune    time.Time
+}
+
+type forwardDestination struct {
+	url       string
+	writes    chan []byte
+	lastWrite int64
+	dropped   int64
+	forwarder *WriteForwarder
+}
+
+func NewWriteForwarder(bufferSize, maxRetries int) *WriteForwarder {
+	return &WriteForwarder{
+		destinations: map[string]*forwardDestination{},
+		bufferSize:   bufferSize,
+		maxRetries:   maxRetries,
+		client:       &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: FORWARD_HTTP_TIMEOUT}},
+		lastPrune:    time.Now(),
+	}
+}
+
+// Serializes the series like the body of a write with microsecond
+// precision
+func serializeForwardedWrite(series []*protocol.Series) ([]byte, error) {
+	serialized := make([]*common.SerializedSeries, 0, len(series))
+	for _, s := range series {
+		serialized = append(serialized, common.SerializeSeries(map[string]*protocol.Series{"": s}, common.MicrosecondPrecision)...)
+	}
+	return json.Marshal(serialized)
+}
+
+// Queues the write for the destinations of the subscriptions, it
+// never blocks the write
+func (self *WriteForwarder) Forward(series []*protocol.Series, subscriptions []*cluster.WriteSubscription) {
+	data, err := serializeForwardedWrite(series)
+	if err != nil {
+		log.Error("Cannot serialize the write for the subscriptions: %s", err)
+		return
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.pruneIdleDestinations()
+	for _, subscription := range subscriptions {
+		for _, destinationUrl := range subscription.Destinations {
+			destination := self.destinations[destinationUrl]
+			if destination == nil {
+				destination = self.startDestination(destinationUrl)
+			}
+			atomic.StoreInt64(&destination.lastWrite, time.Now().UnixNano())
+			select {
+			case destination.writes <- data:
+				forwardQueueDepth.Add(1)
+			default:
+				if atomic.AddInt64(&destination.dropped, 1) == 1 {
+					log.Warn("Subscription %s isn't keeping up with the writes to %s, dropping writes", subscription.Name, destinationUrl)
+				}
+			}
+		}
+	}
+}
+
+func (self *WriteForwarder) startDestination(destinationUrl string) *forwardDestination {
+	destination := &forwardDestination{
+		url:       destinationUrl,
+		writes:    make(chan []byte, self.bufferSize),
+		forwarder: self,
+	}
+	self.destinations[destinationUrl] = destination
+	go destination.run()
+	return destination
+}
+
+// Closes the destinations that are idle, the lock has to be held
+func (self *WriteForwarder) pruneIdleDestinations() {
+	now := time.Now()
+	if now.Sub(self.lastPrune) < FORWARD_IDLE_TIMEOUT {
+		return
+	}
+	self.lastPrune = now
+	for destinationUrl, destination := range self.destinations {
+		if now.Sub(time.Unix(0, atomic.LoadInt64(&destination.lastWrite))) > FORWARD_IDLE_TIMEOUT {
+			delete(self.destinations, destinationUrl)
+			close(destination.writes)
+		}
+	}
+}
+
+func (self *forwardDestination) run() {
+	for data := range self.writes {
+		forwardQueueDepth.Add(-1)
+		for attempt := 0; ; attempt++ {
+			retry, err := self.send(data)
+			if err == nil {
+				break
+			}
+			if !retry || attempt >= self.forwarder.maxRetries {
+				log.Error("Dropping the write to %s: %s", self.url, err)
+				atomic.AddInt64(&self.dropped, 1)
+				break
+			}
+			log.Warn("Cannot forward the write to %s, retrying: %s", self.url, err)
+			time.Sleep(time.Duration(attempt+1) * time.Second)
+		}
+	}
+	if dropped := atomic.LoadInt64(&self.dropped); dropped > 0 {
+		log.Info("Closing idle subscription destination %s, %d writes were dropped", self.url, dropped)
+	}
+}
+
+// Returns whether the write should be retried if it failed
+func (self *forwardDestination) send(data []byte) (bool, error) {
+	u, err := url.Parse(self.url)
+	if err != nil {
+		return false, err
+	}
+
+	if u.Scheme == "udp" {
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return true, err
+		}
+		defer conn.Close()
+		_, err = conn.Write(data)
+		return true, err
+	}
+
+	query := u.Query()
+	if query.Get("time_precision") == "" {
+		query.Set("time_precision", "u")
+		u.RawQuery = query.Encode()
+	}
+	resp, err := self.forwarder.client.Post(u.String(), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	// the write won't succeed if the destination rejected it
+	retry := resp.StatusCode >= 500 || resp.StatusCode == 429
+	return retry, fmt.Errorf("%s: %s", resp.Status, body)
+}
@@ -0,0 +1,85 @@
+package coordinator
+
+import (
+	"cluster"
+	"common"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"protocol"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type WriteForwarderSuite struct{}
+
+var _ = Suite(&WriteForwarderSuite{})
+
+func (self *WriteForwarderSuite) TestForwardsToHttpAndUdp(c *C) {
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		// the first attempt fails and is retried
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer udpConn.Close()
+
+	forwarder := NewWriteForwarder(10, 2)
+	subscription := &cluster.WriteSubscription{
+		Name:         "stream",
+		Database:     "db1",
+		Destinations: []string{server.URL + "/db/copy/series?u=user&p=pass", "udp://" + udpConn.LocalAddr().String()},
+	}
+	forwarder.Forward([]*protocol.Series{subscriptionSeries("cpu", 1, 2)}, []*cluster.WriteSubscription{subscription})
+
+	select {
+	case r := <-requests:
+		c.Assert(r.URL.Path, Equals, "/db/copy/series")
+		c.Assert(r.URL.Query().Get("time_precision"), Equals, "u")
+		c.Assert(r.URL.Query().Get("u"), Equals, "user")
+	case <-time.After(5 * time.Second):
+		c.Fatal("The write wasn't forwarded over http")
+	}
+	series := []*common.SerializedSeries{}
+	c.Assert(json.Unmarshal(<-bodies, &series), IsNil)
+	c.Assert(series, HasLen, 1)
+	c.Assert(series[0].Name, Equals, "cpu")
+	c.Assert(series[0].Points, HasLen, 2)
+
+	buffer := make([]byte, 65536)
+	udpConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := udpConn.ReadFrom(buffer)
+	c.Assert(err, IsNil)
+	series = []*common.SerializedSeries{}
+	c.Assert(json.Unmarshal(buffer[:n], &series), IsNil)
+	c.Assert(series, HasLen, 1)
+}
+
+func (self *WriteForwarderSuite) TestDropsWritesThatAreRejected(c *C) {
+	forwarder := NewWriteForwarder(10, 2)
+	destination := &forwardDestination{forwarder: forwarder}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	destination.url = server.URL
+	retry, err := destination.send([]byte("[]"))
+	c.Assert(err, NotNil)
+	c.Assert(retry, Equals, false)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"cluster"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// influxd backfill runs a continuous query of a running server over a
+// past time range with /db/:db/continuous_queries/:id/backfill, e.g. to
+// populate a new rollup with the points that were written before it
+// was created. It waits for the job of the backfill and prints its
+// progress.
+//
+//	influxd backfill -host localhost:8086 -u root -p root -db mydb -id 3 -start 2014-01-01T00:00:00Z
+func runBackfill(args []string) int {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	host := flags.String("host", "localhost:8086", "the address of the http api")
+	ssl := flags.Bool("ssl", false, "use https")
+	username := flags.String("u", "root", "the name of a cluster admin")
+	password := flags.String("p", "root", "the password")
+	db := flags.String("db", "", "the database of the continuous query")
+	id := flags.Int("id", 0, "the id of the continuous query")
+	start := flags.String("start", "", "the start of the time range, e.g. 2014-01-01T00:00:00Z")
+	end := flags.String("end", "", "the end of the time range, the last run of the continuous queries if it's empty")
+	chunk := flags.String("chunk", "", "the time range of a query, e.g. 1d, 60 group by intervals if it's empty")
+	concurrency := flags.Int("concurrency", 1, "the number of chunks queried at once")
+	pointsPerSecond := flags.Int("points-per-second", 0, "the maximum rate of the writes, 0 means unlimited")
+	flags.Parse(args)
+
+	if *db == "" || *id == 0 || *start == "" {
+		fmt.Fprintln(os.Stderr, "-db, -id and -start must be set")
+		return 2
+	}
+	for _, t := range []string{*start, *end} {
+		if _, err := time.Parse(time.RFC3339, t); t != "" && err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid time %s: %s\n", t, err)
+			return 2
+		}
+	}
+	info := map[string]interface{}{
+		"start":           *start,
+		"chunk":           *chunk,
+		"concurrency":     *concurrency,
+		"pointsPerSecond": *pointsPerSecond,
+	}
+	if *end != "" {
+		info["end"] = *end
+	}
+
+	scheme := "http"
+	if *ssl {
+		scheme = "https"
+	}
+	params := url.Values{}
+	params.Set("u", *username)
+	params.Set("p", *password)
+	backfillUrl := fmt.Sprintf("%s://%s/db/%s/continuous_queries/%d/backfill?%s", scheme, *host, url.QueryEscape(*db), *id, params.Encode())
+
+	status := &cluster.JobStatus{}
+	if err := backfillRequest("POST", backfillUrl, info, status); err != nil {
+		fmt.Fprintf(os.Stderr, "Backfill failed: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Backfilling %s in job %d\n", status.Target, status.Id)
+
+	jobUrl := fmt.Sprintf("%s://%s/cluster/jobs/%d?%s", scheme, *host, status.Id, params.Encode())
+	for status.FinishedAt.IsZero() {
+		time.Sleep(2 * time.Second)
+		if err := backfillRequest("GET", jobUrl, nil, status); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot get the status of job %d: %s\n", status.Id, err)
+			return 1
+		}
+		fmt.Printf("%.1f%% done\n", status.Progress)
+	}
+	if status.Status != cluster.JOB_DONE {
+		fmt.Fprintf(os.Stderr, "Backfill %s: %s\n", status.Status, status.Error)
+		return 1
+	}
+	fmt.Println("Backfill done")
+	return 0
+}
+
+// Sends the body as json if it isn't nil and decodes the response into
+// result
+func backfillRequest(method, requestUrl string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, requestUrl, reader)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, data)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
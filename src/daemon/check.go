@@ -0,0 +1,87 @@
+package main
+
+import (
+	"configuration"
+	"datastore"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"wal"
+)
+
+// Reports the problems that would stop the server from starting or
+// from serving its data, e.g. before an upgrade. The server must not
+// be running. Returns the number of problems.
+func preflightCheck(fileName string, out io.Writer) int {
+	problems := 0
+	report := func(err error, format string, args ...interface{}) {
+		status := "ok"
+		if err != nil {
+			status = "FAILED"
+			problems++
+		}
+		fmt.Fprintf(out, "%-7s %s", status, fmt.Sprintf(format, args...))
+		if err != nil {
+			fmt.Fprintf(out, ": %s", err)
+		}
+		fmt.Fprintln(out)
+	}
+
+	config, err := configuration.ParseConfiguration(fileName)
+	report(err, "configuration %s", fileName)
+	if err != nil {
+		return problems
+	}
+
+	report(checkDirectory(config.DataDir), "data directory %s", config.DataDir)
+	report(checkDirectory(config.RaftDir), "raft directory %s", config.RaftDir)
+	walErr := checkDirectory(config.WalDir)
+	if walErr == nil {
+		walErr = wal.CheckVersion(config.WalDir)
+	}
+	report(walErr, "wal directory %s", config.WalDir)
+
+	shards, err := datastore.CheckShards(config)
+	if err != nil {
+		report(err, "shards in %s", config.DataDir)
+		return problems
+	}
+	for _, shard := range shards {
+		if shard.Quarantined {
+			report(fmt.Errorf("quarantined, it will be repaired from the other replicas"), "shard %d", shard.Id)
+			continue
+		}
+		report(shard.Error, "shard %d", shard.Id)
+	}
+	return problems
+}
+
+// The directory must be writable, or it must be possible to create it
+// if it doesn't exist yet
+func checkDirectory(dir string) error {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		if err := checkDirectory(parent); err != nil {
+			return fmt.Errorf("%s doesn't exist and can't be created: %s", dir, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s isn't a directory", dir)
+	}
+	f, err := ioutil.TempFile(dir, ".influxdb-check")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
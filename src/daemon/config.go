@@ -0,0 +1,91 @@
+package main
+
+import (
+	"configuration"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+)
+
+// influxd config prints the commented default configuration, with
+// -validate it reports the keys of a config file the server ignores or
+// that are deprecated and prints the values the server would use.
+//
+//	influxd config > config.toml
+//	influxd config -validate /opt/influxdb/shared/config.toml
+func runConfig(args []string) int {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	fileName := flags.String("validate", "", "the config file to validate")
+	flags.Parse(args)
+
+	if *fileName == "" {
+		// generated from config.sample.toml by the Makefile
+		fmt.Print(sampleConfig)
+		return 0
+	}
+
+	report, err := configuration.ValidateConfiguration(*fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse %s: %s\n", *fileName, err)
+		return 1
+	}
+	for _, key := range report.UnknownKeys {
+		fmt.Printf("unknown key %s, it's ignored\n", key)
+	}
+	for key, replacement := range report.DeprecatedKeys {
+		fmt.Printf("deprecated key %s, use %s\n", key, replacement)
+	}
+	if report.HasProblems() {
+		fmt.Println()
+	}
+	printEffectiveConfiguration(os.Stdout, report.Config)
+	if report.HasProblems() {
+		return 1
+	}
+	return 0
+}
+
+// Prints the values of the configuration, including the defaults of
+// the keys that aren't set, the secrets are hidden
+func printEffectiveConfiguration(out io.Writer, config *configuration.Configuration) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		switch v := value.Field(i).Interface().(type) {
+		case *configuration.ShardConfiguration:
+			printShardConfiguration(w, field.Name, v)
+		default:
+			s := fmt.Sprint(v)
+			if isSecret(field.Name) && s != "" {
+				s = "<hidden>"
+			}
+			fmt.Fprintf(w, "%s\t%s\n", field.Name, s)
+		}
+	}
+	w.Flush()
+}
+
+func printShardConfiguration(w io.Writer, name string, shard *configuration.ShardConfiguration) {
+	if shard == nil {
+		return
+	}
+	fmt.Fprintf(w, "%s.Duration\t%s\n", name, *shard.ParsedDuration())
+	fmt.Fprintf(w, "%s.Split\t%d\n", name, shard.Split)
+	fmt.Fprintf(w, "%s.SplitRandom\t%s\n", name, shard.SplitRandom)
+	fmt.Fprintf(w, "%s.LruCacheSize\t%d\n", name, shard.LevelDbLruCacheSize())
+	fmt.Fprintf(w, "%s.BloomFilterBits\t%d\n", name, shard.BloomFilterBits)
+	fmt.Fprintf(w, "%s.WriteBufferSize\t%d\n", name, shard.LevelDbWriteBufferSize())
+	fmt.Fprintf(w, "%s.MaxOpenFiles\t%d\n", name, shard.MaxOpenFiles)
+}
+
+func isSecret(name string) bool {
+	return strings.HasSuffix(name, "Secret") || strings.HasSuffix(name, "Password") || strings.HasSuffix(name, "Key")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"cluster"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// influxd dump exports a database, or all of them, from a running
+// server with /cluster/dump. The dump is only written to the file if
+// it's complete.
+//
+//	influxd dump -host localhost:8086 -u root -p root -db mydb -out mydb.dump
+func runDump(args []string) int {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	host := flags.String("host", "localhost:8086", "the address of the http api")
+	ssl := flags.Bool("ssl", false, "use https")
+	username := flags.String("u", "root", "the name of a cluster admin")
+	password := flags.String("p", "root", "the password")
+	db := flags.String("db", "", "the database to dump, all the databases and the cluster admins if it's empty")
+	out := flags.String("out", "", "the dump file")
+	pointsPerSecond := flags.Int("points-per-second", 0, "the maximum rate of the dump, 0 means unlimited")
+	flags.Parse(args)
+
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "-out must be set")
+		return 2
+	}
+	scheme := "http"
+	if *ssl {
+		scheme = "https"
+	}
+	params := url.Values{}
+	params.Set("u", *username)
+	params.Set("p", *password)
+	if *db != "" {
+		params.Set("db", *db)
+	}
+	if *pointsPerSecond > 0 {
+		params.Set("points_per_second", strconv.Itoa(*pointsPerSecond))
+	}
+
+	points, err := dump(fmt.Sprintf("%s://%s/cluster/dump?%s", scheme, *host, params.Encode()), *out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Dump failed: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Dumped %d points to %s\n", points, *out)
+	return 0
+}
+
+// Writes the dump to a temporary file that is renamed once the end
+// record arrives, returns the number of points of the dump
+func dump(dumpUrl, fileName string) (int64, error) {
+	resp, err := http.Get(dumpUrl)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body := make([]byte, 1024)
+		n, _ := io.ReadFull(resp.Body, body)
+		return 0, fmt.Errorf("%s: %s", resp.Status, body[:n])
+	}
+
+	tmpName := fileName + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpName)
+	defer f.Close()
+
+	out := bufio.NewWriter(f)
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if _, err := out.Write(line); err != nil {
+				return 0, err
+			}
+		}
+		if err == io.EOF {
+			return 0, fmt.Errorf("the dump is incomplete, the server stopped before its end")
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// only the type and the points of the end are needed
+		record := &struct {
+			Type   string `json:"type"`
+			Points int64  `json:"points"`
+		}{}
+		if err := json.Unmarshal(line, record); err != nil {
+			return 0, err
+		}
+		if record.Type != cluster.DUMP_END {
+			continue
+		}
+		if err := out.Flush(); err != nil {
+			return 0, err
+		}
+		if err := f.Close(); err != nil {
+			return 0, err
+		}
+		return record.Points, os.Rename(tmpName, fileName)
+	}
+}
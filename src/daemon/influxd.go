@@ -3,15 +3,20 @@ package main
 import (
 	"configuration"
 	"coordinator"
+	"datastore"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"logging"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"runtime"
 	"server"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/jmhodges/levigo"
@@ -19,7 +24,13 @@ import (
 	log "code.google.com/p/log4go"
 )
 
-func setupLogging(loggingLevel, logFile string) {
+// writes to stdout without closing it
+type stdoutWriter struct{}
+
+func (self stdoutWriter) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (self stdoutWriter) Close() error                { return nil }
+
+func setupLogging(loggingLevel, logFile, format string, moduleLevels map[string]string) error {
 	level := log.DEBUG
 	switch loggingLevel {
 	case "info":
@@ -30,29 +41,107 @@ func setupLogging(loggingLevel, logFile string) {
 		level = log.ERROR
 	}
 
-	for _, filter := range log.Global {
-		filter.Level = level
+	levels := make(map[string]int, len(moduleLevels))
+	for module, name := range moduleLevels {
+		moduleLevel, err := logging.ParseLevel(name)
+		if err != nil {
+			return fmt.Errorf("Invalid log level of %s: %s", module, err)
+		}
+		levels[module] = moduleLevel
 	}
 
-	if logFile == "stdout" {
-		flw := log.NewConsoleLogWriter()
-		log.AddFilter("stdout", level, flw)
+	if format != "text" && format != "json" {
+		return fmt.Errorf("Unknown log format %s, it should be text or json", format)
+	}
 
+	var writer log.LogWriter
+	if format == "json" {
+		var output io.WriteCloser = stdoutWriter{}
+		if logFile != "stdout" {
+			os.MkdirAll(filepath.Dir(logFile), 0744)
+			file, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			output = file
+		}
+		writer = logging.NewJsonLogWriter(output)
+	} else if logFile == "stdout" {
+		writer = log.NewConsoleLogWriter()
 	} else {
 		logFileDir := filepath.Dir(logFile)
 		os.MkdirAll(logFileDir, 0744)
 
 		flw := log.NewFileLogWriter(logFile, false)
-		log.AddFilter("file", level, flw)
-
 		flw.SetFormat("[%D %T] [%L] (%S) %M")
 		flw.SetRotate(true)
 		flw.SetRotateSize(0)
 		flw.SetRotateLines(0)
 		flw.SetRotateDaily(true)
+		writer = flw
+	}
+
+	for _, filter := range log.Global {
+		filter.Level = level
+	}
+
+	// log4go has to pass the records of the noisiest module to the
+	// filter, which drops the ones below the level of their module
+	filter := logging.NewModuleFilter(writer, int(level), levels)
+	for int(level) > filter.MinLevel() {
+		level--
+	}
+
+	name := "file"
+	if logFile == "stdout" {
+		name = "stdout"
 	}
+	log.AddFilter(name, level, filter)
 
 	log.Info("Redirectoring logging to %s", logFile)
+	return nil
+}
+
+// exports or imports a shard while the server isn't running
+func exportOrImportShard(config *configuration.Configuration, exportId, importId uint32, fileName string) error {
+	if fileName == "" {
+		return fmt.Errorf("-shard-file must be set")
+	}
+	store, err := datastore.NewLevelDbShardDatastore(config)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if exportId > 0 {
+		log.Info("Exporting shard %d to %s", exportId, fileName)
+		f, err := os.Create(fileName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return store.ExportShard(exportId, f)
+	}
+
+	log.Info("Importing %s into shard %d", fileName, importId)
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return store.ImportShard(importId, f)
+}
+
+// reloads the configuration of the server every time the process gets
+// a SIGHUP
+func reloadOnHangup(s *server.Server) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for _ = range ch {
+		if err := s.Reload(); err != nil {
+			log.Error("Cannot reload the configuration, keeping the current one: %s", err)
+		}
+	}
 }
 
 func main() {
@@ -61,16 +150,53 @@ func main() {
 	resetRootPassword := flag.Bool("reset-root", false, "Reset root password")
 	pidFile := flag.String("pidfile", "", "the pid file")
 	repairLeveldb := flag.Bool("repair-ldb", false, "set to true to repair the leveldb files")
+	exportShard := flag.Int("export-shard", 0, "export the shard with the given id to the file given by -shard-file and exit")
+	importShard := flag.Int("import-shard", 0, "import the file given by -shard-file into the shard with the given id and exit")
+	shardFile := flag.String("shard-file", "", "the file used by -export-shard and -import-shard")
+	check := flag.Bool("check", false, "check the config file, the directories and the shards and exit, non zero if there are problems")
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "dump":
+			os.Exit(runDump(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "inspect-shard":
+			os.Exit(runInspectShard(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "backfill":
+			os.Exit(runBackfill(os.Args[2:]))
+		case "version":
+			os.Exit(runVersion(os.Args[2:]))
+		}
+	}
 	flag.Parse()
 
 	if wantsVersion != nil && *wantsVersion {
-		fmt.Printf("InfluxDB v%s (git: %s) (leveldb: %d.%d)\n", version, gitSha, levigo.GetLevelDBMajorVersion(), levigo.GetLevelDBMinorVersion())
+		fmt.Println(versionString())
+		return
+	}
+	if *check {
+		// the report goes to stdout, only the errors are logged
+		setupLogging("error", "stdout", "text", nil)
+		if problems := preflightCheck(*fileName, os.Stdout); problems > 0 {
+			fmt.Printf("%d problems found\n", problems)
+			os.Exit(1)
+		}
+		fmt.Println("No problems found")
 		return
 	}
+
 	config := configuration.LoadConfiguration(*fileName)
-	setupLogging(config.LogLevel, config.LogFile)
+	config.InfluxDBVersion = version
+	config.InfluxDBGitSha = gitSha
+	config.InfluxDBBuildDate = buildDate
+	if err := setupLogging(config.LogLevel, config.LogFile, config.LogFormat, config.LogModuleLevels); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot set up the logging: %s\n", err)
+		os.Exit(1)
+	}
 
 	if *repairLeveldb {
 		log.Info("Repairing leveldb")
@@ -89,6 +215,16 @@ func main() {
 		}
 	}
 
+	if *exportShard > 0 || *importShard > 0 {
+		if err := exportOrImportShard(config, uint32(*exportShard), uint32(*importShard), *shardFile); err != nil {
+			log.Error("Shard export/import failed: %s", err)
+			time.Sleep(time.Second)
+			os.Exit(1)
+		}
+		time.Sleep(time.Second)
+		return
+	}
+
 	if pidFile != nil && *pidFile != "" {
 		pid := strconv.Itoa(os.Getpid())
 		if err := ioutil.WriteFile(*pidFile, []byte(pid), 0644); err != nil {
@@ -112,9 +248,7 @@ func main() {
 +---------------------------------------------+
 
 `)
-	os.MkdirAll(config.RaftDir, 0744)
-	os.MkdirAll(config.DataDir, 0744)
-	server, err := server.NewServer(config)
+	server, err := server.New(config)
 	if err != nil {
 		// sleep for the log to flush
 		time.Sleep(time.Second)
@@ -124,6 +258,7 @@ func main() {
 	if err := startProfiler(server); err != nil {
 		panic(err)
 	}
+	go reloadOnHangup(server)
 
 	if *resetRootPassword {
 		// TODO: make this not suck
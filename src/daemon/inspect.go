@@ -0,0 +1,105 @@
+package main
+
+import (
+	"configuration"
+	"datastore"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// influxd inspect-shard reports what a shard contains while the server
+// isn't running, the shard is given by its id or by its directory.
+//
+//	influxd inspect-shard -config config.toml -shard 3
+//	influxd inspect-shard -dir /opt/influxdb/shared/data/db/shard_db/00003 -json
+func runInspectShard(args []string) int {
+	flags := flag.NewFlagSet("inspect-shard", flag.ExitOnError)
+	fileName := flags.String("config", "config.sample.toml", "the config file, its leveldb settings are used to open the shard")
+	shardId := flags.Int("shard", 0, "the id of the shard in the data directory of the config")
+	dir := flags.String("dir", "", "the directory of the shard, instead of -shard")
+	asJson := flags.Bool("json", false, "print the report as json")
+	flags.Parse(args)
+
+	if (*shardId == 0) == (*dir == "") {
+		fmt.Fprintln(os.Stderr, "either -shard or -dir must be set")
+		return 2
+	}
+	config, err := configuration.ParseConfiguration(*fileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *dir == "" {
+		*dir = filepath.Join(config.DataDir, datastore.SHARD_DATABASE_DIR, fmt.Sprintf("%.5d", *shardId))
+	}
+	// leveldb logs to stderr, the report goes to stdout
+	setupLogging("error", "stdout", "text", nil)
+
+	inspection, err := datastore.InspectShard(config, *dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot inspect %s: %s\n", *dir, err)
+		return 1
+	}
+	if *asJson {
+		data, err := json.MarshalIndent(inspection, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(string(data))
+	} else {
+		printInspection(os.Stdout, inspection)
+	}
+	if len(inspection.Anomalies) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printInspection(out io.Writer, inspection *datastore.ShardInspection) {
+	fmt.Fprintf(out, "Shard %s", inspection.Dir)
+	if inspection.Quarantined {
+		fmt.Fprint(out, " (quarantined)")
+	}
+	fmt.Fprintln(out)
+
+	keys := inspection.Keys
+	fmt.Fprintf(out, "Keys: %d points (%d bytes), %d column index, %d series index, %d other, last column id %d\n\n",
+		keys.PointKeys, keys.PointBytes, keys.ColumnIndexKeys, keys.SeriesIndexKeys, keys.OtherKeys, keys.LastColumnId)
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "database\tseries\tcolumns\tpoints\tvalues\tbytes\tfirst point\tlast point")
+	for _, s := range inspection.Series {
+		first, last := "-", "-"
+		if s.Values > 0 {
+			first, last = s.MinTime.Format(time.RFC3339), s.MaxTime.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n", s.Database, s.Name, s.Columns, s.Points, s.Values, s.Bytes, first, last)
+	}
+	w.Flush()
+
+	counts := inspection.AnomalyCounts()
+	if len(counts) == 0 {
+		fmt.Fprintln(out, "\nNo anomalies found")
+		return
+	}
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	fmt.Fprintln(out, "\nAnomalies:")
+	for _, kind := range kinds {
+		fmt.Fprintf(out, "  %s: %d\n", kind, counts[kind])
+	}
+	for _, anomaly := range inspection.Anomalies {
+		fmt.Fprintf(out, "  %s: %s\n", anomaly.Kind, anomaly.Message)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"coordinator"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// influxd restore imports a dump into a running server with
+// /cluster/restore. The last line that was restored is saved next to
+// the dump, so a restore that was interrupted can continue with
+// -resume.
+//
+//	influxd restore -host localhost:8086 -u root -p root -file mydb.dump
+func runRestore(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	host := flags.String("host", "localhost:8086", "the address of the http api")
+	ssl := flags.Bool("ssl", false, "use https")
+	username := flags.String("u", "root", "the name of a cluster admin")
+	password := flags.String("p", "root", "the password")
+	fileName := flags.String("file", "", "the dump file")
+	resume := flags.Bool("resume", false, "continue the restore after the last line that was restored")
+	flags.Parse(args)
+
+	if *fileName == "" {
+		fmt.Fprintln(os.Stderr, "-file must be set")
+		return 2
+	}
+	scheme := "http"
+	if *ssl {
+		scheme = "https"
+	}
+	stateFile := *fileName + ".restore"
+	skip := 0
+	if *resume {
+		data, err := ioutil.ReadFile(stateFile)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(data) > 0 {
+			skip, err = strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid restore state in %s: %s\n", stateFile, err)
+				return 1
+			}
+			fmt.Printf("Resuming after line %d\n", skip)
+		}
+	}
+
+	params := url.Values{}
+	params.Set("u", *username)
+	params.Set("p", *password)
+	params.Set("skip", strconv.Itoa(skip))
+	restoreUrl := fmt.Sprintf("%s://%s/cluster/restore?%s", scheme, *host, params.Encode())
+
+	progress, err := restore(restoreUrl, *fileName, stateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Restore failed: %s\n", err)
+		fmt.Fprintln(os.Stderr, "Run the restore again with -resume to continue it")
+		return 1
+	}
+	os.Remove(stateFile)
+	fmt.Printf("Restored %d points\n", progress.Points)
+	return 0
+}
+
+// Posts the dump and saves the line of every progress that the server
+// reports to the state file, the points of the progress are the ones
+// restored so far
+func restore(restoreUrl, fileName, stateFile string) (*coordinator.RestoreProgress, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	resp, err := http.Post(restoreUrl, "application/x-ndjson", f)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", resp.Status, body)
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		progress := &coordinator.RestoreProgress{}
+		if err := decoder.Decode(progress); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("the server stopped before the end of the dump")
+			}
+			return nil, err
+		}
+		if progress.Error != "" {
+			return nil, fmt.Errorf("%s", progress.Error)
+		}
+		if err := ioutil.WriteFile(stateFile, []byte(strconv.Itoa(progress.Line)), 0644); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Restored up to line %d, %d points\n", progress.Line, progress.Points)
+		if progress.Done {
+			return progress, nil
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/jmhodges/levigo"
+)
+
+// Set when the binary is linked by make with -ldflags "-X main.version
+// 0.5.9 ...", a binary built with go build is a dev build
+var (
+	version   = "dev"
+	gitSha    = "unknown"
+	buildDate = "unknown"
+)
+
+func versionString() string {
+	return fmt.Sprintf("InfluxDB v%s (git: %s) (built: %s) (leveldb: %d.%d)", version, gitSha, buildDate,
+		levigo.GetLevelDBMajorVersion(), levigo.GetLevelDBMinorVersion())
+}
+
+// influxd version prints the build of the binary, the same as -v with
+// the version of go
+func runVersion(args []string) int {
+	fmt.Println(versionString())
+	fmt.Printf("go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
@@ -0,0 +1,56 @@
+package datastore
+
+import (
+	"bytes"
+	"common"
+	"encoding/binary"
+	"hash/crc32"
+	"protocol"
+
+	"code.google.com/p/goprotobuf/proto"
+)
+
+// Point values are stored as a one byte header followed by a crc32 of
+// the marshalled FieldValue and the marshalled FieldValue itself. The
+// header can never be the first byte of a valid protobuf message
+// (wire type 7 doesn't exist), so values written before checksums were
+// introduced can still be read.
+const (
+	CHECKSUMMED_VALUE_HEADER = byte(0xFF)
+	CHECKSUM_LENGTH          = 4
+)
+
+func encodeValue(fv *protocol.FieldValue) ([]byte, error) {
+	data, err := proto.Marshal(fv)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, len(data)+CHECKSUM_LENGTH+1))
+	buffer.WriteByte(CHECKSUMMED_VALUE_HEADER)
+	binary.Write(buffer, binary.BigEndian, crc32.ChecksumIEEE(data))
+	buffer.Write(data)
+	return buffer.Bytes(), nil
+}
+
+// decodeValue verifies the checksum of the given value (if it has
+// one) and unmarshals it into a FieldValue. Values that fail the
+// checksum or can't be unmarshalled return a common.CorruptDataError
+func decodeValue(key, value []byte) (*protocol.FieldValue, error) {
+	data := value
+	if len(value) > 0 && value[0] == CHECKSUMMED_VALUE_HEADER {
+		if len(value) < CHECKSUM_LENGTH+1 {
+			return nil, common.NewCorruptDataError("Corrupt value for key %x: value is too short", key)
+		}
+		expected := binary.BigEndian.Uint32(value[1 : CHECKSUM_LENGTH+1])
+		data = value[CHECKSUM_LENGTH+1:]
+		if actual := crc32.ChecksumIEEE(data); actual != expected {
+			return nil, common.NewCorruptDataError("Corrupt value for key %x: checksum mismatch, expected %d got %d", key, expected, actual)
+		}
+	}
+
+	fv := &protocol.FieldValue{}
+	if err := proto.Unmarshal(data, fv); err != nil {
+		return nil, common.NewCorruptDataError("Corrupt value for key %x: %s", key, err)
+	}
+	return fv, nil
+}
@@ -0,0 +1,39 @@
+package datastore
+
+import (
+	"common"
+	. "launchpad.net/gocheck"
+	"protocol"
+
+	"code.google.com/p/goprotobuf/proto"
+)
+
+type ChecksumSuite struct{}
+
+var _ = Suite(&ChecksumSuite{})
+
+func (self *ChecksumSuite) TestEncodedValuesCanBeDecoded(c *C) {
+	data, err := encodeValue(&protocol.FieldValue{Int64Value: proto.Int64(42)})
+	c.Assert(err, IsNil)
+	fv, err := decodeValue(nil, data)
+	c.Assert(err, IsNil)
+	c.Assert(fv.GetInt64Value(), Equals, int64(42))
+}
+
+func (self *ChecksumSuite) TestValuesWithoutChecksumCanBeDecoded(c *C) {
+	data, err := proto.Marshal(&protocol.FieldValue{StringValue: proto.String("foo")})
+	c.Assert(err, IsNil)
+	fv, err := decodeValue(nil, data)
+	c.Assert(err, IsNil)
+	c.Assert(fv.GetStringValue(), Equals, "foo")
+}
+
+func (self *ChecksumSuite) TestCorruptValuesAreDetected(c *C) {
+	data, err := encodeValue(&protocol.FieldValue{StringValue: proto.String("foo")})
+	c.Assert(err, IsNil)
+	data[len(data)-1] = 'x'
+	_, err = decodeValue(nil, data)
+	c.Assert(err, NotNil)
+	_, ok := err.(common.CorruptDataError)
+	c.Assert(ok, Equals, true)
+}
@@ -28,6 +28,7 @@ type LevelDbShard struct {
 	columnIdMutex  sync.Mutex
 	closed         bool
 	pointBatchSize int
+	readOnly       bool
 }
 
 func NewLevelDbShard(db *levigo.DB, pointBatchSize int) (*LevelDbShard, error) {
@@ -55,11 +56,25 @@ func NewLevelDbShard(db *levigo.DB, pointBatchSize int) (*LevelDbShard, error) {
 }
 
 func (self *LevelDbShard) Write(database string, series *protocol.Series) error {
+	// the shard can be writable again once the archive is unmounted,
+	// the write is retried instead of becoming a dead letter
+	if self.readOnly {
+		return errors.New("Unable to write to a read only shard")
+	}
+
 	wb := levigo.NewWriteBatch()
 	defer wb.Close()
 
 	if series == nil || len(series.Points) == 0 {
-		return errors.New("Unable to write no data. Series was nil or had no points.")
+		return common.NewInvalidWriteError("Unable to write no data. Series was nil or had no points.")
+	}
+	for _, point := range series.Points {
+		if len(point.Values) != len(series.Fields) {
+			return common.NewInvalidWriteError("Point of %s has %d values but the series has %d fields", series.GetName(), len(point.Values), len(series.Fields))
+		}
+		if point.SequenceNumber == nil {
+			return common.NewInvalidWriteError("Point of %s has no sequence number", series.GetName())
+		}
 	}
 
 	for fieldIndex, field := range series.Fields {
@@ -80,9 +95,9 @@ func (self *LevelDbShard) Write(database string, series *protocol.Series) error
 				continue
 			}
 
-			data, err := proto.Marshal(point.Values[fieldIndex])
+			data, err := encodeValue(point.Values[fieldIndex])
 			if err != nil {
-				return err
+				return common.NewInvalidWriteError(err.Error())
 			}
 			wb.Put(pointKey, data)
 		}
@@ -94,6 +109,8 @@ func (self *LevelDbShard) Write(database string, series *protocol.Series) error
 func (self *LevelDbShard) Query(querySpec *parser.QuerySpec, processor cluster.QueryProcessor) error {
 	if querySpec.IsListSeriesQuery() {
 		return self.executeListSeriesQuery(querySpec, processor)
+	} else if self.readOnly && querySpec.IsDestructiveQuery() {
+		return errors.New("Unable to delete data from a read only shard")
 	} else if querySpec.IsDeleteFromSeriesQuery() {
 		return self.executeDeleteQuery(querySpec, processor)
 	} else if querySpec.IsDropSeriesQuery() {
@@ -129,6 +146,10 @@ func (self *LevelDbShard) Query(querySpec *parser.QuerySpec, processor cluster.Q
 }
 
 func (self *LevelDbShard) DropDatabase(database string) error {
+	if self.readOnly {
+		log.Warn("Not dropping database %s from a read only shard", database)
+		return nil
+	}
 	seriesNames := self.getSeriesForDatabase(database)
 	for _, name := range seriesNames {
 		if err := self.dropSeries(database, name); err != nil {
@@ -144,6 +165,86 @@ func (self *LevelDbShard) IsClosed() bool {
 	return self.closed
 }
 
+// Verify reads every point stored in the shard and checks its
+// checksum. It returns a common.CorruptDataError for the first corrupt value
+// it finds or any error leveldb returned while iterating.
+func (self *LevelDbShard) Verify() error {
+	ro := levigo.NewReadOptions()
+	defer ro.Close()
+	ro.SetVerifyChecksums(true)
+	ro.SetFillCache(false)
+	it := self.db.NewIterator(ro)
+	defer it.Close()
+
+	count := 0
+	for it.Seek(NEXT_ID_KEY); it.Valid(); it.Next() {
+		key := it.Key()
+		// point keys are the only keys that are exactly 24 bytes long
+		// (column id, timestamp and sequence number)
+		if len(key) != 24 || bytes.Equal(key[:8], NEXT_ID_KEY) || bytes.Compare(key[:8], ATOMIC_INCREMENT_PREFIX) >= 0 {
+			continue
+		}
+		if _, err := decodeValue(key, it.Value()); err != nil {
+			return err
+		}
+		count++
+	}
+	if err := it.GetError(); err != nil {
+		return common.NewCorruptDataError("Error while iterating over shard: %s", err)
+	}
+	log.Debug("Verified %d points", count)
+	return self.verifyIndexes()
+}
+
+// verifyIndexes makes sure that every column in the series column
+// index belongs to a series in the database series index.
+func (self *LevelDbShard) verifyIndexes() error {
+	it := self.db.NewIterator(self.readOptions)
+	defer it.Close()
+	seriesIt := self.db.NewIterator(self.readOptions)
+	defer seriesIt.Close()
+
+	prefixLength := len(SERIES_COLUMN_INDEX_PREFIX)
+	for it.Seek(SERIES_COLUMN_INDEX_PREFIX); it.Valid(); it.Next() {
+		key := it.Key()
+		if len(key) < prefixLength || !bytes.Equal(key[:prefixLength], SERIES_COLUMN_INDEX_PREFIX) {
+			break
+		}
+		// the names of the databases can't have a ~ but the names of the
+		// series and of the columns can, the key is valid if one of the
+		// ways to split it names a series in the index
+		indexKey := string(key[prefixLength:])
+		separator := strings.Index(indexKey, "~")
+		if separator <= 0 || !strings.Contains(indexKey[separator+1:], "~") {
+			return common.NewCorruptDataError("Invalid column index key %q", indexKey)
+		}
+		db, seriesAndColumn := indexKey[:separator], indexKey[separator+1:]
+		if len(it.Value()) != 8 {
+			return common.NewCorruptDataError("Invalid id for column index key %q", indexKey)
+		}
+		if !self.hasIndexedSeries(seriesIt, db, seriesAndColumn) {
+			return common.NewCorruptDataError("The series of column index key %q is missing from the series index of database %s", indexKey, db)
+		}
+	}
+	return it.GetError()
+}
+
+// Returns true if the series index of the database has a series that
+// the series and column key starts with
+func (self *LevelDbShard) hasIndexedSeries(seriesIt *levigo.Iterator, db, seriesAndColumn string) bool {
+	for i := 0; i < len(seriesAndColumn); i++ {
+		if seriesAndColumn[i] != '~' {
+			continue
+		}
+		seriesKey := append(append([]byte{}, DATABASE_SERIES_INDEX_PREFIX...), []byte(db+"~"+seriesAndColumn[:i])...)
+		seriesIt.Seek(seriesKey)
+		if seriesIt.Valid() && bytes.Equal(seriesIt.Key(), seriesKey) {
+			return true
+		}
+	}
+	return false
+}
+
 func (self *LevelDbShard) executeQueryForSeries(querySpec *parser.QuerySpec, seriesName string, columns []string, processor cluster.QueryProcessor) error {
 	startTimeBytes := self.byteArrayForTime(querySpec.GetStartTime())
 	endTimeBytes := self.byteArrayForTime(querySpec.GetEndTime())
@@ -208,7 +309,7 @@ func (self *LevelDbShard) executeQueryForSeries(querySpec *parser.QuerySpec, ser
 			sequenceNumber := key[16:]
 
 			rawTime := key[8:16]
-			rawValue := &rawColumnValue{time: rawTime, sequence: sequenceNumber, value: value}
+			rawValue := &rawColumnValue{key: key, time: rawTime, sequence: sequenceNumber, value: value}
 			rawColumnValues[i] = rawValue
 		}
 
@@ -248,8 +349,7 @@ func (self *LevelDbShard) executeQueryForSeries(querySpec *parser.QuerySpec, ser
 				iterator.Prev()
 			}
 
-			fv := &protocol.FieldValue{}
-			err := proto.Unmarshal(rawColumnValues[i].value, fv)
+			fv, err := decodeValue(rawColumnValues[i].key, rawColumnValues[i].value)
 			if err != nil {
 				return err
 			}
@@ -593,6 +693,41 @@ func (self *LevelDbShard) getSeriesForDatabase(database string) []string {
 	return names
 }
 
+// Returns the approximate size on disk of the points of the database.
+// The points of a column are keyed by its id, so the size is the one of
+// the key ranges of the ids of the columns of the database. The points
+// that are still in the memtable aren't counted.
+func (self *LevelDbShard) databaseSize(database string) int64 {
+	it := self.db.NewIterator(self.readOptions)
+	defer it.Close()
+
+	prefixLength := len(SERIES_COLUMN_INDEX_PREFIX)
+	ranges := []levigo.Range{}
+	for it.Seek(append(SERIES_COLUMN_INDEX_PREFIX, []byte(database+"~")...)); it.Valid(); it.Next() {
+		key := it.Key()
+		if len(key) < prefixLength || !bytes.Equal(key[:prefixLength], SERIES_COLUMN_INDEX_PREFIX) {
+			break
+		}
+		parts := strings.SplitN(string(key[prefixLength:]), "~", 2)
+		if parts[0] != database {
+			break
+		}
+		id := it.Value()
+		// the points of the column are keyed by the id, the time and the
+		// sequence number
+		limit := append(append(append([]byte{}, id...), MAX_SEQUENCE...), MAX_SEQUENCE...)
+		ranges = append(ranges, levigo.Range{Start: id, Limit: limit})
+	}
+	if len(ranges) == 0 {
+		return 0
+	}
+	var size int64
+	for _, s := range self.db.GetApproximateSizes(ranges) {
+		size += int64(s)
+	}
+	return size
+}
+
 func (self *LevelDbShard) createIdForDbSeriesColumn(db, series, column *string) (ret []byte, err error) {
 	ret, err = self.getIdForDbSeriesColumn(db, series, column)
 	if err != nil {
@@ -683,8 +818,7 @@ func (self *LevelDbShard) fetchSinglePoint(querySpec *parser.QuerySpec, series s
 		if data, err := self.db.Get(self.readOptions, pointKey); err != nil {
 			return nil, err
 		} else {
-			fieldValue := &protocol.FieldValue{}
-			err := proto.Unmarshal(data, fieldValue)
+			fieldValue, err := decodeValue(pointKey, data)
 			if err != nil {
 				return nil, err
 			}
@@ -3,6 +3,7 @@ package datastore
 import (
 	"bytes"
 	"cluster"
+	"common"
 	"configuration"
 	"fmt"
 	"math"
@@ -24,10 +25,27 @@ type LevelDbShardDatastore struct {
 	shardRefCounts map[uint32]int
 	shardsToClose  map[uint32]bool
 	shardsLock     sync.RWMutex
-	levelDbOptions *levigo.Options
-	writeBuffer    *cluster.WriteBuffer
-	maxOpenShards  int
-	pointBatchSize int
+	levelDbOptions map[cluster.ShardType]*levigo.Options
+	// used to open mounted shards, they shouldn't be created if
+	// they're missing
+	readOnlyOptions *levigo.Options
+	shardTypes      map[uint32]cluster.ShardType
+	mounts          map[uint32]string
+	writeBuffer     *cluster.WriteBuffer
+	maxOpenShards   int
+	pointBatchSize  int
+	quarantined     map[uint32]bool
+	deadLetters     *cluster.DeadLetterQueue
+	// the replacements of the shards that are being repaired, the
+	// writes go to the shard and to its replacement
+	repairs map[uint32]*LevelDbShard
+	// held by the writes, the swap of a repaired shard waits for them
+	writesLock sync.RWMutex
+	// the shards that are being swapped with their replacement, they
+	// can't be opened until the swap is done
+	swaps map[uint32]bool
+	// signaled whenever a shard is returned or a swap is done
+	shardsReturned *sync.Cond
 }
 
 const (
@@ -40,6 +58,11 @@ const (
 	DATABASE_DIR                    = "db"
 	SHARD_BLOOM_FILTER_BITS_PER_KEY = 10
 	SHARD_DATABASE_DIR              = "shard_db"
+	DEAD_LETTERS_DIR                = "dead_letters"
+	QUARANTINE_FILE                 = "QUARANTINED"
+	REPAIR_DIR_SUFFIX               = ".repair"
+	MOUNT_DIR_SUFFIX                = ".mount"
+	REPLACED_DIR_SUFFIX             = ".replaced"
 )
 
 var (
@@ -66,6 +89,7 @@ type Field struct {
 }
 
 type rawColumnValue struct {
+	key      []byte
 	time     []byte
 	sequence []byte
 	value    []byte
@@ -77,25 +101,118 @@ func NewLevelDbShardDatastore(config *configuration.Configuration) (*LevelDbShar
 	if err != nil {
 		return nil, err
 	}
+	deadLetters, err := cluster.NewDeadLetterQueue(filepath.Join(config.DataDir, DEAD_LETTERS_DIR), config.MaxDeadLetters)
+	if err != nil {
+		return nil, err
+	}
+	self := &LevelDbShardDatastore{
+		baseDbDir: baseDbDir,
+		config:    config,
+		shards:    make(map[uint32]*LevelDbShard),
+		levelDbOptions: map[cluster.ShardType]*levigo.Options{
+			cluster.SHORT_TERM: newLevelDbOptions(config, config.ShortTermShard),
+			cluster.LONG_TERM:  newLevelDbOptions(config, config.LongTermShard),
+		},
+		readOnlyOptions: newReadOnlyLevelDbOptions(config),
+		shardTypes:      make(map[uint32]cluster.ShardType),
+		mounts:          make(map[uint32]string),
+		maxOpenShards:   config.LevelDbMaxOpenShards,
+		lastAccess:      make(map[uint32]int64),
+		shardRefCounts:  make(map[uint32]int),
+		shardsToClose:   make(map[uint32]bool),
+		pointBatchSize:  config.LevelDbPointBatchSize,
+		quarantined:     make(map[uint32]bool),
+		deadLetters:     deadLetters,
+		repairs:         make(map[uint32]*LevelDbShard),
+		swaps:           make(map[uint32]bool),
+	}
+	self.shardsReturned = sync.NewCond(&self.shardsLock)
+	return self, nil
+}
+
+// returns the leveldb options for the given type of shards. The
+// global leveldb settings are used for the options that aren't set
+// for the shard type.
+func newLevelDbOptions(config *configuration.Configuration, shardConfig *configuration.ShardConfiguration) *levigo.Options {
+	cacheSize := config.LevelDbLruCacheSize
+	bloomFilterBits := SHARD_BLOOM_FILTER_BITS_PER_KEY
+	writeBufferSize := 0
+	maxOpenFiles := config.LevelDbMaxOpenFiles
+
+	if shardConfig != nil {
+		if shardConfig.LevelDbLruCacheSize() > 0 {
+			cacheSize = shardConfig.LevelDbLruCacheSize()
+		}
+		if shardConfig.BloomFilterBits > 0 {
+			bloomFilterBits = shardConfig.BloomFilterBits
+		}
+		if shardConfig.LevelDbWriteBufferSize() > 0 {
+			writeBufferSize = shardConfig.LevelDbWriteBufferSize()
+		}
+		if shardConfig.MaxOpenFiles > 0 {
+			maxOpenFiles = shardConfig.MaxOpenFiles
+		}
+	}
+
 	opts := levigo.NewOptions()
-	opts.SetCache(levigo.NewLRUCache(config.LevelDbLruCacheSize))
+	opts.SetCache(levigo.NewLRUCache(cacheSize))
 	opts.SetCreateIfMissing(true)
 	opts.SetBlockSize(64 * ONE_KILOBYTE)
-	filter := levigo.NewBloomFilter(SHARD_BLOOM_FILTER_BITS_PER_KEY)
+	filter := levigo.NewBloomFilter(bloomFilterBits)
 	opts.SetFilterPolicy(filter)
-	opts.SetMaxOpenFiles(config.LevelDbMaxOpenFiles)
+	opts.SetMaxOpenFiles(maxOpenFiles)
+	if writeBufferSize > 0 {
+		opts.SetWriteBufferSize(writeBufferSize)
+	}
+	return opts
+}
+
+func newReadOnlyLevelDbOptions(config *configuration.Configuration) *levigo.Options {
+	opts := newLevelDbOptions(config, config.LongTermShard)
+	opts.SetCreateIfMissing(false)
+	opts.SetParanoidChecks(true)
+	return opts
+}
 
-	return &LevelDbShardDatastore{
-		baseDbDir:      baseDbDir,
-		config:         config,
-		shards:         make(map[uint32]*LevelDbShard),
-		levelDbOptions: opts,
-		maxOpenShards:  config.LevelDbMaxOpenShards,
-		lastAccess:     make(map[uint32]int64),
-		shardRefCounts: make(map[uint32]int),
-		shardsToClose:  make(map[uint32]bool),
-		pointBatchSize: config.LevelDbPointBatchSize,
-	}, nil
+// SetShardType sets the type of the shard with the given id. The type
+// determines which leveldb options are used when the shard is opened.
+func (self *LevelDbShardDatastore) SetShardType(id uint32, shardType cluster.ShardType) {
+	self.shardsLock.Lock()
+	defer self.shardsLock.Unlock()
+	self.shardTypes[id] = shardType
+}
+
+// MountShard makes the shard with the given id use the leveldb
+// database in the given directory instead of the one in the data
+// directory. Mounted shards are read only, they're opened from a
+// snapshot of the directory so leveldb never changes the archive and
+// the directory is never created or removed by the datastore.
+func (self *LevelDbShardDatastore) MountShard(id uint32, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Cannot mount shard %d, %s isn't a directory", id, path)
+	}
+
+	self.shardsLock.RLock()
+	_, mounted := self.mounts[id]
+	self.shardsLock.RUnlock()
+	if mounted {
+		return nil
+	}
+
+	snapshot := self.shardDir(id) + MOUNT_DIR_SUFFIX
+	log.Info("DATASTORE: mounting shard %d read only from a snapshot of %s in %s", id, path, snapshot)
+	if err := snapshotShard(path, snapshot); err != nil {
+		return fmt.Errorf("Cannot mount shard %d from %s: %s", id, path, err)
+	}
+
+	self.shardsLock.Lock()
+	defer self.shardsLock.Unlock()
+	self.mounts[id] = snapshot
+	return nil
 }
 
 func (self *LevelDbShardDatastore) Close() {
@@ -110,6 +227,9 @@ func (self *LevelDbShardDatastore) GetOrCreateShard(id uint32) (cluster.LocalSha
 	now := time.Now().Unix()
 	self.shardsLock.Lock()
 	defer self.shardsLock.Unlock()
+	for self.swaps[id] {
+		self.shardsReturned.Wait()
+	}
 	db := self.shards[id]
 	self.lastAccess[id] = now
 
@@ -120,8 +240,23 @@ func (self *LevelDbShardDatastore) GetOrCreateShard(id uint32) (cluster.LocalSha
 
 	dbDir := self.shardDir(id)
 
+	// shards that we don't know the type of are opened with the long
+	// term options, same as the temporary shards that the cluster
+	// configuration creates for writes
+	shardType, ok := self.shardTypes[id]
+	if !ok {
+		shardType = cluster.LONG_TERM
+	}
+	opts := self.levelDbOptions[shardType]
+
+	mountPath, readOnly := self.mounts[id]
+	if readOnly {
+		dbDir = mountPath
+		opts = self.readOnlyOptions
+	}
+
 	log.Info("DATASTORE: opening or creating shard %s", dbDir)
-	ldb, err := levigo.Open(dbDir, self.levelDbOptions)
+	ldb, err := levigo.Open(dbDir, opts)
 	if err != nil {
 		log.Error("Error opening shard: ", err)
 		return nil, err
@@ -133,6 +268,7 @@ func (self *LevelDbShardDatastore) GetOrCreateShard(id uint32) (cluster.LocalSha
 		ldb.Close()
 		return nil, err
 	}
+	db.readOnly = readOnly
 	self.shards[id] = db
 	self.incrementShardRefCountAndCloseOldestIfNeeded(id)
 	return db, nil
@@ -152,26 +288,54 @@ func (self *LevelDbShardDatastore) ReturnShard(id uint32) {
 	self.shardsLock.Lock()
 	defer self.shardsLock.Unlock()
 	self.shardRefCounts[id] -= 1
-	if self.shardsToClose[id] && self.shardRefCounts[id] == 0 {
+	if self.shardRefCounts[id] > 0 {
+		return
+	}
+	if self.shardsToClose[id] {
 		self.closeShard(id)
 	}
+	self.shardsReturned.Broadcast()
 }
 
 func (self *LevelDbShardDatastore) Write(request *protocol.Request) error {
+	self.writesLock.RLock()
+	defer self.writesLock.RUnlock()
 	shardDb, err := self.GetOrCreateShard(*request.ShardId)
 	if err != nil {
 		return err
 	}
 	defer self.ReturnShard(*request.ShardId)
+	self.shardsLock.RLock()
+	replacement := self.repairs[*request.ShardId]
+	self.shardsLock.RUnlock()
 	for _, s := range request.MultiSeries {
 		err := shardDb.Write(*request.Database, s)
+		if _, ok := err.(common.InvalidWriteError); ok {
+			// retrying won't help, keep the points aside and write the
+			// other series
+			if err := self.deadLetters.Add(*request.Database, *request.ShardId, s, err); err != nil {
+				return err
+			}
+			continue
+		}
 		if err != nil {
 			return err
 		}
+		if replacement == nil {
+			continue
+		}
+		if err := replacement.Write(*request.Database, s); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// The points of the local shards that couldn't be written
+func (self *LevelDbShardDatastore) DeadLetters() *cluster.DeadLetterQueue {
+	return self.deadLetters
+}
+
 func (self *LevelDbShardDatastore) BufferWrite(request *protocol.Request) {
 	self.writeBuffer.Write(request)
 }
@@ -185,17 +349,238 @@ func (self *LevelDbShardDatastore) DeleteShard(shardId uint32) error {
 	shardDb := self.shards[shardId]
 	delete(self.shards, shardId)
 	delete(self.lastAccess, shardId)
+	delete(self.quarantined, shardId)
 	self.shardsLock.Unlock()
 
 	if shardDb != nil {
 		shardDb.close()
 	}
 
+	self.shardsLock.Lock()
+	mountPath, mounted := self.mounts[shardId]
+	delete(self.mounts, shardId)
+	self.shardsLock.Unlock()
+	if mounted {
+		// only the snapshot is dropped, never the archive
+		log.Info("DATASTORE: unmounting shard %d, dropping its snapshot %s", shardId, mountPath)
+		return os.RemoveAll(mountPath)
+	}
+
+	self.AbortShardRepair(shardId)
 	dir := self.shardDir(shardId)
 	log.Info("DATASTORE: dropping shard %s", dir)
 	return os.RemoveAll(dir)
 }
 
+// StartShardRepair creates an empty replacement of the shard next to
+// it. Until the repair is finished or aborted the writes to the shard
+// go to the replacement too, so the points that the other replicas
+// don't have yet aren't lost when the replacement is swapped in.
+func (self *LevelDbShardDatastore) StartShardRepair(id uint32) (cluster.LocalShardDb, error) {
+	self.shardsLock.Lock()
+	defer self.shardsLock.Unlock()
+	if _, mounted := self.mounts[id]; mounted {
+		return nil, fmt.Errorf("Shard %d is mounted read only, it can't be repaired", id)
+	}
+	if self.repairs[id] != nil {
+		return nil, fmt.Errorf("Shard %d is already being repaired", id)
+	}
+
+	dir := self.shardDir(id) + REPAIR_DIR_SUFFIX
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	shardType, ok := self.shardTypes[id]
+	if !ok {
+		shardType = cluster.LONG_TERM
+	}
+	log.Info("DATASTORE: creating the replacement of shard %d in %s", id, dir)
+	ldb, err := levigo.Open(dir, self.levelDbOptions[shardType])
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := NewLevelDbShard(ldb, self.pointBatchSize)
+	if err != nil {
+		ldb.Close()
+		return nil, err
+	}
+	self.repairs[id] = replacement
+	return replacement, nil
+}
+
+// FinishShardRepair replaces the shard with its replacement. The writes
+// and the queries that didn't get the shard yet wait until the
+// replacement is in place, the shard is closed once the ones that have
+// it returned it.
+func (self *LevelDbShardDatastore) FinishShardRepair(id uint32) error {
+	self.writesLock.Lock()
+	defer self.writesLock.Unlock()
+	self.shardsLock.Lock()
+	defer self.shardsLock.Unlock()
+	replacement := self.repairs[id]
+	if replacement == nil {
+		return fmt.Errorf("Shard %d isn't being repaired", id)
+	}
+	delete(self.repairs, id)
+	replacement.close()
+
+	self.swaps[id] = true
+	defer func() {
+		delete(self.swaps, id)
+		self.shardsReturned.Broadcast()
+	}()
+	if self.shards[id] != nil {
+		// ReturnShard closes the shard once it isn't used anymore
+		self.shardsToClose[id] = true
+		for self.shards[id] != nil && self.shardRefCounts[id] > 0 {
+			self.shardsReturned.Wait()
+		}
+		if self.shards[id] != nil {
+			self.closeShard(id)
+		}
+	}
+
+	// the corrupt shard is only removed once the replacement is in its
+	// place, a crash in between leaves one of them
+	dir := self.shardDir(id)
+	log.Info("DATASTORE: replacing shard %s with its repaired copy", dir)
+	if err := os.RemoveAll(dir + REPLACED_DIR_SUFFIX); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, dir+REPLACED_DIR_SUFFIX); err != nil {
+		return err
+	}
+	if err := os.Rename(dir+REPAIR_DIR_SUFFIX, dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir + REPLACED_DIR_SUFFIX)
+}
+
+// AbortShardRepair drops the replacement of the shard, if it's being
+// repaired
+func (self *LevelDbShardDatastore) AbortShardRepair(id uint32) {
+	self.writesLock.Lock()
+	defer self.writesLock.Unlock()
+	self.shardsLock.Lock()
+	replacement := self.repairs[id]
+	delete(self.repairs, id)
+	self.shardsLock.Unlock()
+	if replacement == nil {
+		return
+	}
+	replacement.close()
+	os.RemoveAll(self.shardDir(id) + REPAIR_DIR_SUFFIX)
+}
+
+// VerifyShard checks the checksums of all the points in the given
+// shard. If the shard is corrupt it gets quarantined and the error is
+// returned.
+func (self *LevelDbShardDatastore) VerifyShard(id uint32) error {
+	shardDb, err := self.GetOrCreateShard(id)
+	if err != nil {
+		return err
+	}
+	defer self.ReturnShard(id)
+
+	log.Info("DATASTORE: verifying shard %s", self.shardDir(id))
+	err = shardDb.(*LevelDbShard).Verify()
+	if _, ok := err.(common.CorruptDataError); ok {
+		self.QuarantineShard(id)
+	}
+	return err
+}
+
+// ShardStats returns the size of the shard directory and the number
+// of series in the shard
+func (self *LevelDbShardDatastore) ShardStats(id uint32) (int64, int, error) {
+	shardDb, err := self.GetOrCreateShard(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer self.ReturnShard(id)
+	seriesCount := len(shardDb.(*LevelDbShard).getDatabasesAndSeries())
+
+	self.shardsLock.RLock()
+	dir, mounted := self.mounts[id]
+	self.shardsLock.RUnlock()
+	if !mounted {
+		dir = self.shardDir(id)
+	}
+
+	var size int64
+	err = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, seriesCount, err
+}
+
+// DatabaseSize returns the approximate size on disk of the points of
+// the database in the shard
+func (self *LevelDbShardDatastore) DatabaseSize(id uint32, db string) (int64, error) {
+	shardDb, err := self.GetOrCreateShard(id)
+	if err != nil {
+		return 0, err
+	}
+	defer self.ReturnShard(id)
+	return shardDb.(*LevelDbShard).databaseSize(db), nil
+}
+
+// QuarantineShard marks the shard as suspect. The mark is persisted
+// in the shard directory so it survives restarts until the shard is
+// repaired.
+func (self *LevelDbShardDatastore) QuarantineShard(id uint32) {
+	self.shardsLock.Lock()
+	defer self.shardsLock.Unlock()
+	if self.quarantined[id] {
+		return
+	}
+	log.Warn("DATASTORE: quarantining shard %s", self.shardDir(id))
+	self.quarantined[id] = true
+	f, err := os.Create(filepath.Join(self.shardDir(id), QUARANTINE_FILE))
+	if err != nil {
+		log.Error("DATASTORE: cannot persist quarantine of shard %d: %s", id, err)
+		return
+	}
+	f.Close()
+}
+
+func (self *LevelDbShardDatastore) UnquarantineShard(id uint32) {
+	self.shardsLock.Lock()
+	defer self.shardsLock.Unlock()
+	delete(self.quarantined, id)
+	os.Remove(filepath.Join(self.shardDir(id), QUARANTINE_FILE))
+	log.Info("DATASTORE: shard %s is no longer quarantined", self.shardDir(id))
+}
+
+func (self *LevelDbShardDatastore) IsQuarantined(id uint32) bool {
+	self.shardsLock.Lock()
+	defer self.shardsLock.Unlock()
+	if quarantined, ok := self.quarantined[id]; ok {
+		return quarantined
+	}
+	_, err := os.Stat(filepath.Join(self.shardDir(id), QUARANTINE_FILE))
+	self.quarantined[id] = err == nil
+	return self.quarantined[id]
+}
+
+// HasShard returns true if the shard is on the local disk, it's created
+// the first time it's opened
+func (self *LevelDbShardDatastore) HasShard(id uint32) bool {
+	self.shardsLock.Lock()
+	defer self.shardsLock.Unlock()
+	if self.shards[id] != nil {
+		return true
+	}
+	_, err := os.Stat(self.shardDir(id))
+	return err == nil
+}
+
 func (self *LevelDbShardDatastore) shardDir(id uint32) string {
 	return filepath.Join(self.baseDbDir, fmt.Sprintf("%.5d", id))
 }
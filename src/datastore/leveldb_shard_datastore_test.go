@@ -1,9 +1,15 @@
 package datastore
 
 import (
+	"bytes"
 	"configuration"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"os"
+	"protocol"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
 )
 
 const TEST_DATASTORE_SHARD_DIR = "/tmp/influxdb/leveldb_shard_datastore_test"
@@ -37,3 +43,346 @@ func (self *LevelDbShardDatastoreSuite) TestWillEnforceMaxOpenShards(c *C) {
 	store.ReturnShard(uint32(2))
 	c.Assert(shard.IsClosed(), Equals, true)
 }
+
+func (self *LevelDbShardDatastoreSuite) TestCanExportAndImportShards(c *C) {
+	config := &configuration.Configuration{}
+	config.DataDir = TEST_DATASTORE_SHARD_DIR
+	config.LevelDbPointBatchSize = 2
+
+	store, err := NewLevelDbShardDatastore(config)
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	shard, err := store.GetOrCreateShard(uint32(10))
+	c.Assert(err, IsNil)
+	points := []*protocol.Point{}
+	for i := 0; i < 5; i++ {
+		point := &protocol.Point{
+			Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(int64(i))}},
+			SequenceNumber: proto.Uint64(uint64(i + 1)),
+		}
+		point.SetTimestampInMicroseconds(int64(i) * 1000000)
+		points = append(points, point)
+	}
+	series := &protocol.Series{Name: proto.String("foo"), Fields: []string{"value"}, Points: points}
+	c.Assert(shard.Write("db1", series), IsNil)
+	store.ReturnShard(uint32(10))
+
+	buffer := bytes.NewBuffer(nil)
+	c.Assert(store.ExportShard(uint32(10), buffer), IsNil)
+	c.Assert(store.ImportShard(uint32(11), buffer), IsNil)
+
+	imported, err := store.GetOrCreateShard(uint32(11))
+	c.Assert(err, IsNil)
+	defer store.ReturnShard(uint32(11))
+	c.Assert(imported.(*LevelDbShard).getDatabasesAndSeries(), DeepEquals, [][2]string{{"db1", "foo"}})
+	count := 0
+	err = imported.(*LevelDbShard).exportColumn("db1", "foo", "value", func(request *protocol.Request) error {
+		count += len(request.MultiSeries[0].Points)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 5)
+}
+
+func (self *LevelDbShardDatastoreSuite) TestShardStats(c *C) {
+	config := &configuration.Configuration{}
+	config.DataDir = TEST_DATASTORE_SHARD_DIR
+
+	store, err := NewLevelDbShardDatastore(config)
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	shard, err := store.GetOrCreateShard(uint32(20))
+	c.Assert(err, IsNil)
+	for _, name := range []string{"foo", "bar"} {
+		point := &protocol.Point{
+			Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(1)}},
+			SequenceNumber: proto.Uint64(1),
+		}
+		point.SetTimestampInMicroseconds(1000000)
+		series := &protocol.Series{Name: proto.String(name), Fields: []string{"value"}, Points: []*protocol.Point{point}}
+		c.Assert(shard.Write("db1", series), IsNil)
+	}
+	store.ReturnShard(uint32(20))
+
+	size, seriesCount, err := store.ShardStats(uint32(20))
+	c.Assert(err, IsNil)
+	c.Assert(size > 0, Equals, true)
+	c.Assert(seriesCount, Equals, 2)
+}
+
+func (self *LevelDbShardDatastoreSuite) TestDatabaseSize(c *C) {
+	config := &configuration.Configuration{}
+	config.DataDir = TEST_DATASTORE_SHARD_DIR
+
+	store, err := NewLevelDbShardDatastore(config)
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	shard, err := store.GetOrCreateShard(uint32(21))
+	c.Assert(err, IsNil)
+	series := &protocol.Series{Name: proto.String("foo"), Fields: []string{"value"}}
+	for i := 0; i < 1000; i++ {
+		point := &protocol.Point{
+			Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(int64(i))}},
+			SequenceNumber: proto.Uint64(1),
+		}
+		point.SetTimestampInMicroseconds(int64(i+1) * 1000000)
+		series.Points = append(series.Points, point)
+	}
+	c.Assert(shard.Write("db1", series), IsNil)
+	// the sizes are the ones of the files, the points have to be flushed
+	// out of the memtable
+	shard.(*LevelDbShard).compact()
+	store.ReturnShard(uint32(21))
+
+	size, err := store.DatabaseSize(uint32(21), "db1")
+	c.Assert(err, IsNil)
+	c.Assert(size > 0, Equals, true)
+	size, err = store.DatabaseSize(uint32(21), "db2")
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(0))
+}
+
+func (self *LevelDbShardDatastoreSuite) TestInvalidWritesAreMovedToTheDeadLetters(c *C) {
+	config := &configuration.Configuration{}
+	config.DataDir = TEST_DATASTORE_SHARD_DIR
+
+	store, err := NewLevelDbShardDatastore(config)
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	point := &protocol.Point{
+		Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(1)}},
+		SequenceNumber: proto.Uint64(1),
+	}
+	point.SetTimestampInMicroseconds(1000000)
+	valid := &protocol.Series{Name: proto.String("valid"), Fields: []string{"value"}, Points: []*protocol.Point{point}}
+	// the point doesn't have a value for every field
+	invalid := &protocol.Series{Name: proto.String("invalid"), Fields: []string{"value", "other"}, Points: []*protocol.Point{point}}
+	writeType := protocol.Request_WRITE
+	request := &protocol.Request{
+		Type:        &writeType,
+		Database:    proto.String("dead_letters_db"),
+		ShardId:     proto.Uint32(30),
+		MultiSeries: []*protocol.Series{invalid, valid},
+	}
+	c.Assert(store.Write(request), IsNil)
+
+	letters, err := store.DeadLetters().List("dead_letters_db")
+	c.Assert(err, IsNil)
+	c.Assert(letters, HasLen, 1)
+	c.Assert(letters[0].ShardId, Equals, uint32(30))
+	c.Assert(letters[0].Series.GetName(), Equals, "invalid")
+
+	// the valid series was written
+	_, seriesCount, err := store.ShardStats(uint32(30))
+	c.Assert(err, IsNil)
+	c.Assert(seriesCount, Equals, 1)
+}
+
+func (self *LevelDbShardDatastoreSuite) TestInspectShard(c *C) {
+	config := &configuration.Configuration{}
+	config.DataDir = TEST_DATASTORE_SHARD_DIR
+
+	store, err := NewLevelDbShardDatastore(config)
+	c.Assert(err, IsNil)
+	shard, err := store.GetOrCreateShard(uint32(40))
+	c.Assert(err, IsNil)
+	points := []*protocol.Point{}
+	for i := 0; i < 5; i++ {
+		point := &protocol.Point{
+			Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(int64(i))}},
+			SequenceNumber: proto.Uint64(uint64(i + 1)),
+		}
+		point.SetTimestampInMicroseconds(int64(i) * 1000000)
+		points = append(points, point)
+	}
+	series := &protocol.Series{Name: proto.String("foo"), Fields: []string{"value"}, Points: points}
+	c.Assert(shard.Write("db1", series), IsNil)
+	store.ReturnShard(uint32(40))
+	dir := store.shardDir(uint32(40))
+	// the shard can only be inspected once the server closed it
+	store.Close()
+
+	inspection, err := InspectShard(config, dir)
+	c.Assert(err, IsNil)
+	c.Assert(inspection.Anomalies, HasLen, 0)
+	c.Assert(inspection.Series, HasLen, 1)
+	c.Assert(inspection.Series[0].Database, Equals, "db1")
+	c.Assert(inspection.Series[0].Name, Equals, "foo")
+	c.Assert(inspection.Series[0].Points, Equals, int64(5))
+	c.Assert(inspection.Series[0].MinTime.Unix(), Equals, int64(0))
+	c.Assert(inspection.Series[0].MaxTime.Unix(), Equals, int64(4))
+	c.Assert(inspection.Keys.PointKeys, Equals, int64(5))
+	c.Assert(inspection.Keys.SeriesIndexKeys, Equals, int64(1))
+}
+
+func (self *LevelDbShardDatastoreSuite) TestWritesDuringARepairAreKept(c *C) {
+	config := &configuration.Configuration{}
+	config.DataDir = TEST_DATASTORE_SHARD_DIR
+
+	store, err := NewLevelDbShardDatastore(config)
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	writeType := protocol.Request_WRITE
+	write := func(seconds int64) *protocol.Request {
+		point := &protocol.Point{
+			Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(seconds)}},
+			SequenceNumber: proto.Uint64(1),
+		}
+		point.SetTimestampInMicroseconds(seconds * 1000000)
+		return &protocol.Request{
+			Type:        &writeType,
+			Database:    proto.String("db1"),
+			ShardId:     proto.Uint32(40),
+			MultiSeries: []*protocol.Series{&protocol.Series{Name: proto.String("foo"), Fields: []string{"value"}, Points: []*protocol.Point{point}}},
+		}
+	}
+	c.Assert(store.Write(write(1)), IsNil)
+	store.QuarantineShard(40)
+
+	replacement, err := store.StartShardRepair(40)
+	c.Assert(err, IsNil)
+	// the points copied from the replica and a point that is written
+	// while the shard is repaired
+	c.Assert(replacement.Write("db1", write(2).MultiSeries[0]), IsNil)
+	c.Assert(store.Write(write(3)), IsNil)
+	c.Assert(store.FinishShardRepair(40), IsNil)
+	store.UnquarantineShard(40)
+
+	_, err = os.Stat(store.shardDir(40) + REPAIR_DIR_SUFFIX)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(store.IsQuarantined(40), Equals, false)
+
+	shard, err := store.GetOrCreateShard(40)
+	c.Assert(err, IsNil)
+	defer store.ReturnShard(40)
+	values := []int64{}
+	err = shard.(*LevelDbShard).exportColumn("db1", "foo", "value", func(request *protocol.Request) error {
+		for _, point := range request.MultiSeries[0].Points {
+			values = append(values, point.Values[0].GetInt64Value())
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(values, DeepEquals, []int64{2, 3})
+}
+
+func (self *LevelDbShardDatastoreSuite) TestRepairedShardIsSwappedOnceItsReturned(c *C) {
+	config := &configuration.Configuration{}
+	config.DataDir = TEST_DATASTORE_SHARD_DIR
+
+	store, err := NewLevelDbShardDatastore(config)
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	// a query that has the shard while the repair finishes
+	shard, err := store.GetOrCreateShard(41)
+	c.Assert(err, IsNil)
+	_, err = store.StartShardRepair(41)
+	c.Assert(err, IsNil)
+	finished := make(chan error, 1)
+	go func() {
+		finished <- store.FinishShardRepair(41)
+	}()
+
+	select {
+	case <-finished:
+		c.Fatal("The shard was swapped while it was used")
+	case <-time.After(100 * time.Millisecond):
+	}
+	c.Assert(shard.IsClosed(), Equals, false)
+
+	store.ReturnShard(41)
+	select {
+	case err := <-finished:
+		c.Assert(err, IsNil)
+	case <-time.After(time.Second):
+		c.Fatal("The shard wasn't swapped once it was returned")
+	}
+	c.Assert(shard.IsClosed(), Equals, true)
+
+	repaired, err := store.GetOrCreateShard(41)
+	c.Assert(err, IsNil)
+	c.Assert(repaired, Not(Equals), shard)
+	store.ReturnShard(41)
+}
+
+func (self *LevelDbShardDatastoreSuite) TestNamesWithATildeArentCorrupt(c *C) {
+	config := &configuration.Configuration{}
+	config.DataDir = TEST_DATASTORE_SHARD_DIR
+
+	store, err := NewLevelDbShardDatastore(config)
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	point := &protocol.Point{
+		Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(1)}, &protocol.FieldValue{Int64Value: proto.Int64(2)}},
+		SequenceNumber: proto.Uint64(1),
+	}
+	point.SetTimestampInMicroseconds(1000000)
+	series := &protocol.Series{Name: proto.String("cpu~load"), Fields: []string{"value", "user~time"}, Points: []*protocol.Point{point}}
+	writeType := protocol.Request_WRITE
+	request := &protocol.Request{
+		Type:        &writeType,
+		Database:    proto.String("db1"),
+		ShardId:     proto.Uint32(50),
+		MultiSeries: []*protocol.Series{series},
+	}
+	c.Assert(store.Write(request), IsNil)
+
+	// the scrubber verifies the shards like this
+	c.Assert(store.VerifyShard(50), IsNil)
+	c.Assert(store.IsQuarantined(50), Equals, false)
+}
+
+func (self *LevelDbShardDatastoreSuite) TestMountedShardsDontChangeTheArchive(c *C) {
+	config := &configuration.Configuration{}
+	config.DataDir = TEST_DATASTORE_SHARD_DIR
+
+	store, err := NewLevelDbShardDatastore(config)
+	c.Assert(err, IsNil)
+	point := &protocol.Point{
+		Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(1)}},
+		SequenceNumber: proto.Uint64(1),
+	}
+	point.SetTimestampInMicroseconds(1000000)
+	writeType := protocol.Request_WRITE
+	request := &protocol.Request{
+		Type:        &writeType,
+		Database:    proto.String("db1"),
+		ShardId:     proto.Uint32(60),
+		MultiSeries: []*protocol.Series{&protocol.Series{Name: proto.String("foo"), Fields: []string{"value"}, Points: []*protocol.Point{point}}},
+	}
+	c.Assert(store.Write(request), IsNil)
+	archive := store.shardDir(60)
+	store.Close()
+
+	listFiles := func() map[string]int64 {
+		files := map[string]int64{}
+		infos, err := ioutil.ReadDir(archive)
+		c.Assert(err, IsNil)
+		for _, info := range infos {
+			files[info.Name()] = info.Size()
+		}
+		return files
+	}
+	before := listFiles()
+
+	store, err = NewLevelDbShardDatastore(config)
+	c.Assert(err, IsNil)
+	c.Assert(store.MountShard(61, archive), IsNil)
+	shard, err := store.GetOrCreateShard(61)
+	c.Assert(err, IsNil)
+	c.Assert(shard.(*LevelDbShard).getDatabasesAndSeries(), DeepEquals, [][2]string{{"db1", "foo"}})
+	store.ReturnShard(61)
+	c.Assert(store.DeleteShard(61), IsNil)
+	store.Close()
+
+	c.Assert(listFiles(), DeepEquals, before)
+	_, err = os.Stat(store.shardDir(61) + MOUNT_DIR_SUFFIX)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,65 @@
+package datastore
+
+import (
+	"configuration"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/jmhodges/levigo"
+)
+
+// The result of checking a shard in the data directory
+type ShardCheck struct {
+	Id          uint32
+	Quarantined bool
+	Error       error
+}
+
+// Opens every shard in the data directory without creating the missing
+// ones and verifies the checksums of their points, while the server
+// isn't running. Unlike VerifyShard, the corrupt shards aren't
+// quarantined.
+func CheckShards(config *configuration.Configuration) ([]*ShardCheck, error) {
+	baseDbDir := filepath.Join(config.DataDir, SHARD_DATABASE_DIR)
+	infos, err := ioutil.ReadDir(baseDbDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	opts := newReadOnlyLevelDbOptions(config)
+	defer opts.Close()
+	checks := make([]*ShardCheck, 0, len(infos))
+	for _, info := range infos {
+		id, err := strconv.ParseUint(info.Name(), 10, 32)
+		if !info.IsDir() || err != nil {
+			continue
+		}
+		dir := filepath.Join(baseDbDir, info.Name())
+		check := &ShardCheck{Id: uint32(id)}
+		if _, err := os.Stat(filepath.Join(dir, QUARANTINE_FILE)); err == nil {
+			check.Quarantined = true
+		}
+		check.Error = checkShard(dir, opts, config.LevelDbPointBatchSize)
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func checkShard(dir string, opts *levigo.Options, pointBatchSize int) error {
+	ldb, err := levigo.Open(dir, opts)
+	if err != nil {
+		return err
+	}
+	shard, err := NewLevelDbShard(ldb, pointBatchSize)
+	if err != nil {
+		ldb.Close()
+		return err
+	}
+	defer shard.close()
+	return shard.Verify()
+}
@@ -0,0 +1,215 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"protocol"
+
+	log "code.google.com/p/log4go"
+)
+
+// Shards are exported to a format that doesn't depend on the storage
+// engine. An export starts with SHARD_EXPORT_MAGIC followed by the
+// version of the format as a big endian uint32. The rest of the export
+// is a sequence of write requests, each one prefixed with its length
+// as a big endian uint32. Every request has the points of a single
+// column of a single series, so the export can be imported by writing
+// the requests in order.
+const (
+	SHARD_EXPORT_MAGIC   = "INFLUXDB_SHARD_EXPORT"
+	SHARD_EXPORT_VERSION = uint32(1)
+	MAX_EXPORT_REQUEST   = 64 * ONE_MEGABYTE
+)
+
+var exportWriteRequest = protocol.Request_WRITE
+
+func (self *LevelDbShardDatastore) ExportShard(id uint32, w io.Writer) error {
+	shardDb, err := self.GetOrCreateShard(id)
+	if err != nil {
+		return err
+	}
+	defer self.ReturnShard(id)
+	return shardDb.(*LevelDbShard).Export(w)
+}
+
+func (self *LevelDbShardDatastore) ImportShard(id uint32, r io.Reader) error {
+	shardDb, err := self.GetOrCreateShard(id)
+	if err != nil {
+		return err
+	}
+	defer self.ReturnShard(id)
+	return shardDb.(*LevelDbShard).Import(r)
+}
+
+func (self *LevelDbShard) Export(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	if _, err := writer.WriteString(SHARD_EXPORT_MAGIC); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.BigEndian, SHARD_EXPORT_VERSION); err != nil {
+		return err
+	}
+
+	requests := 0
+	for _, dbSeries := range self.getDatabasesAndSeries() {
+		database, series := dbSeries[0], dbSeries[1]
+		for _, column := range self.getColumnNamesForSeries(database, series) {
+			err := self.exportColumn(database, series, column, func(request *protocol.Request) error {
+				requests++
+				return writeExportRequest(writer, request)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	log.Info("Exported %d requests", requests)
+	return writer.Flush()
+}
+
+func (self *LevelDbShard) Import(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(SHARD_EXPORT_MAGIC))
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return err
+	}
+	if string(magic) != SHARD_EXPORT_MAGIC {
+		return errors.New("Not a shard export")
+	}
+	var version uint32
+	if err := binary.Read(reader, binary.BigEndian, &version); err != nil {
+		return err
+	}
+	if version > SHARD_EXPORT_VERSION {
+		return fmt.Errorf("Unsupported shard export version %d", version)
+	}
+
+	requests := 0
+	for {
+		var length uint32
+		err := binary.Read(reader, binary.BigEndian, &length)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if length > MAX_EXPORT_REQUEST {
+			return fmt.Errorf("Request %d is too large (%d bytes)", requests, length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return err
+		}
+		request, err := protocol.DecodeRequest(bytes.NewBuffer(data))
+		if err != nil {
+			return err
+		}
+		for _, series := range request.MultiSeries {
+			if err := self.Write(request.GetDatabase(), series); err != nil {
+				return err
+			}
+		}
+		requests++
+	}
+	log.Info("Imported %d requests", requests)
+	return nil
+}
+
+func writeExportRequest(w io.Writer, request *protocol.Request) error {
+	data, err := request.Encode()
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (self *LevelDbShard) exportColumn(database, series, column string, yield func(*protocol.Request) error) error {
+	id, err := self.getIdForDbSeriesColumn(&database, &series, &column)
+	if err != nil {
+		return err
+	}
+	if id == nil {
+		return nil
+	}
+
+	it := self.db.NewIterator(self.readOptions)
+	defer it.Close()
+
+	newRequest := func() *protocol.Request {
+		db := database
+		return &protocol.Request{
+			Type:     &exportWriteRequest,
+			Database: &db,
+			MultiSeries: []*protocol.Series{
+				&protocol.Series{
+					Name:   protocol.String(series),
+					Fields: []string{column},
+					Points: make([]*protocol.Point, 0, self.pointBatchSize),
+				},
+			},
+		}
+	}
+
+	request := newRequest()
+	for it.Seek(id); it.Valid(); it.Next() {
+		key := it.Key()
+		if len(key) < 24 || !bytes.Equal(key[:8], id) {
+			break
+		}
+
+		value, err := decodeValue(key, it.Value())
+		if err != nil {
+			return err
+		}
+		var t, sequence uint64
+		binary.Read(bytes.NewBuffer(key[8:16]), binary.BigEndian, &t)
+		binary.Read(bytes.NewBuffer(key[16:24]), binary.BigEndian, &sequence)
+		point := &protocol.Point{Values: []*protocol.FieldValue{value}, SequenceNumber: &sequence}
+		point.SetTimestampInMicroseconds(self.convertUintTimestampToInt64(&t))
+
+		s := request.MultiSeries[0]
+		s.Points = append(s.Points, point)
+		if len(s.Points) >= self.pointBatchSize {
+			if err := yield(request); err != nil {
+				return err
+			}
+			request = newRequest()
+		}
+	}
+
+	if len(request.MultiSeries[0].Points) > 0 {
+		return yield(request)
+	}
+	return nil
+}
+
+// returns a list of [database, series] pairs for all the series in
+// the shard
+func (self *LevelDbShard) getDatabasesAndSeries() [][2]string {
+	it := self.db.NewIterator(self.readOptions)
+	defer it.Close()
+
+	result := make([][2]string, 0)
+	prefixLength := len(DATABASE_SERIES_INDEX_PREFIX)
+	for it.Seek(DATABASE_SERIES_INDEX_PREFIX); it.Valid(); it.Next() {
+		key := it.Key()
+		if len(key) < prefixLength || !bytes.Equal(key[:prefixLength], DATABASE_SERIES_INDEX_PREFIX) {
+			break
+		}
+		parts := bytes.SplitN(key[prefixLength:], []byte("~"), 2)
+		if len(parts) != 2 {
+			continue
+		}
+		result = append(result, [2]string{string(parts[0]), string(parts[1])})
+	}
+	return result
+}
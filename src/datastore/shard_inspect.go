@@ -0,0 +1,264 @@
+package datastore
+
+import (
+	"bytes"
+	"configuration"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmhodges/levigo"
+)
+
+// the anomalies of every kind that are listed, the rest are only
+// counted
+const MAX_REPORTED_ANOMALIES = 20
+
+// What a shard contains, as reported by InspectShard
+type ShardInspection struct {
+	Dir         string              `json:"dir"`
+	Quarantined bool                `json:"quarantined"`
+	Series      []*SeriesInspection `json:"series"`
+	Keys        *KeySpaceInspection `json:"keys"`
+	Anomalies   []*ShardAnomaly     `json:"anomalies"`
+	counts      map[string]int
+	// the series by database and name and the columns by id
+	seriesByName map[string]*SeriesInspection
+	columns      map[string]*columnId
+}
+
+type SeriesInspection struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+	Columns  int    `json:"columns"`
+	// the values of the column that has the most of them, every point
+	// has a value in at least one column
+	Points int64 `json:"points"`
+	// the values in all the columns
+	Values   int64     `json:"values"`
+	Bytes    int64     `json:"bytes"`
+	MinTime  time.Time `json:"minTime"`
+	MaxTime  time.Time `json:"maxTime"`
+	columns  map[string]*columnStats
+	hasIndex bool
+}
+
+type columnStats struct {
+	values int64
+}
+
+// The number and the size of the keys of the shard by kind
+type KeySpaceInspection struct {
+	PointKeys       int64  `json:"pointKeys"`
+	PointBytes      int64  `json:"pointBytes"`
+	ColumnIndexKeys int64  `json:"columnIndexKeys"`
+	SeriesIndexKeys int64  `json:"seriesIndexKeys"`
+	OtherKeys       int64  `json:"otherKeys"`
+	LastColumnId    uint64 `json:"lastColumnId"`
+}
+
+type ShardAnomaly struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+type columnId struct {
+	series *SeriesInspection
+	column string
+}
+
+func (self *ShardInspection) anomaly(kind, format string, args ...interface{}) {
+	self.counts[kind]++
+	if self.counts[kind] > MAX_REPORTED_ANOMALIES {
+		return
+	}
+	self.Anomalies = append(self.Anomalies, &ShardAnomaly{kind, fmt.Sprintf(format, args...)})
+}
+
+// The number of anomalies of every kind, including the ones that
+// weren't listed
+func (self *ShardInspection) AnomalyCounts() map[string]int {
+	return self.counts
+}
+
+func (self *ShardInspection) series(db, name string) *SeriesInspection {
+	key := db + "~" + name
+	if s := self.seriesByName[key]; s != nil {
+		return s
+	}
+	s := &SeriesInspection{Database: db, Name: name, columns: map[string]*columnStats{}}
+	self.seriesByName[key] = s
+	self.Series = append(self.Series, s)
+	return s
+}
+
+// Reads every key of the shard in the directory while the server isn't
+// running and reports the series it contains, the number and the time
+// range of their points, the keys by kind and the anomalies it finds:
+// corrupt values, values of columns that aren't in the index, series
+// that are missing from the index and columns without values.
+func InspectShard(config *configuration.Configuration, dir string) (*ShardInspection, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	opts := newReadOnlyLevelDbOptions(config)
+	defer opts.Close()
+	// the shard is opened even if it's corrupt, the corruption is
+	// reported as an anomaly
+	opts.SetParanoidChecks(false)
+	db, err := levigo.Open(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	inspection := &ShardInspection{
+		Dir:          dir,
+		Keys:         &KeySpaceInspection{},
+		counts:       map[string]int{},
+		seriesByName: map[string]*SeriesInspection{},
+		columns:      map[string]*columnId{},
+	}
+	if _, err := os.Stat(filepath.Join(dir, QUARANTINE_FILE)); err == nil {
+		inspection.Quarantined = true
+	}
+
+	ro := levigo.NewReadOptions()
+	defer ro.Close()
+	ro.SetVerifyChecksums(true)
+	ro.SetFillCache(false)
+
+	// the indexes sort after the points, they're read first so the
+	// points can be attributed to their series
+	inspection.readIndexes(db, ro)
+	inspection.readPoints(db, ro)
+
+	for _, s := range inspection.Series {
+		s.Columns = len(s.columns)
+		for column, stats := range s.columns {
+			if stats.values == 0 {
+				inspection.anomaly("empty_column", "Column %s of series %s in database %s has no values", column, s.Name, s.Database)
+			}
+			if stats.values > s.Points {
+				s.Points = stats.values
+			}
+		}
+	}
+	sort.Sort(seriesInspections(inspection.Series))
+	return inspection, nil
+}
+
+func (self *ShardInspection) readIndexes(db *levigo.DB, ro *levigo.ReadOptions) {
+	it := db.NewIterator(ro)
+	defer it.Close()
+
+	if data, err := db.Get(ro, NEXT_ID_KEY); err == nil && data != nil {
+		self.Keys.LastColumnId, _ = binary.Uvarint(data)
+	}
+
+	for it.Seek(ATOMIC_INCREMENT_PREFIX); it.Valid(); it.Next() {
+		key := it.Key()
+		if len(key) < 8 {
+			self.Keys.OtherKeys++
+			continue
+		}
+		prefix, rest := key[:8], string(key[8:])
+		switch {
+		case bytes.Equal(prefix, SERIES_COLUMN_INDEX_PREFIX):
+			self.Keys.ColumnIndexKeys++
+			parts := strings.SplitN(rest, "~", 3)
+			if len(parts) < 3 || len(it.Value()) != 8 {
+				self.anomaly("invalid_index", "Invalid column index key %q", rest)
+				continue
+			}
+			series := self.series(parts[0], parts[1])
+			series.columns[parts[2]] = &columnStats{}
+			self.columns[string(it.Value())] = &columnId{series, parts[2]}
+		case bytes.Equal(prefix, DATABASE_SERIES_INDEX_PREFIX):
+			self.Keys.SeriesIndexKeys++
+			parts := strings.SplitN(rest, "~", 2)
+			if len(parts) < 2 {
+				self.anomaly("invalid_index", "Invalid series index key %q", rest)
+				continue
+			}
+			self.series(parts[0], parts[1]).hasIndex = true
+		default:
+			self.Keys.OtherKeys++
+		}
+	}
+	if err := it.GetError(); err != nil {
+		self.anomaly("leveldb", "Error while reading the indexes: %s", err)
+	}
+
+	for _, s := range self.Series {
+		if !s.hasIndex {
+			self.anomaly("missing_series_index", "Series %s in database %s is missing from the series index", s.Name, s.Database)
+		}
+	}
+}
+
+func (self *ShardInspection) readPoints(db *levigo.DB, ro *levigo.ReadOptions) {
+	it := db.NewIterator(ro)
+	defer it.Close()
+
+	// decodes the timestamps of the keys like the shard does
+	shard := &LevelDbShard{}
+	for it.Seek(NEXT_ID_KEY); it.Valid(); it.Next() {
+		key := it.Key()
+		if len(key) >= 8 && bytes.Compare(key[:8], ATOMIC_INCREMENT_PREFIX) >= 0 {
+			break
+		}
+		if bytes.Equal(key, NEXT_ID_KEY) {
+			continue
+		}
+		if len(key) != 24 {
+			self.Keys.OtherKeys++
+			self.anomaly("invalid_key", "Key %x isn't a point key", key)
+			continue
+		}
+		value := it.Value()
+		self.Keys.PointKeys++
+		self.Keys.PointBytes += int64(len(key) + len(value))
+
+		id := self.columns[string(key[:8])]
+		if id == nil {
+			self.anomaly("orphan_value", "Value of column id %x isn't in the column index", key[:8])
+			continue
+		}
+		if _, err := decodeValue(key, value); err != nil {
+			self.anomaly("corrupt_value", "Column %s of series %s in database %s: %s", id.column, id.series.Name, id.series.Database, err)
+			continue
+		}
+
+		series := id.series
+		series.columns[id.column].values++
+		series.Values++
+		series.Bytes += int64(len(key) + len(value))
+		timestamp := binary.BigEndian.Uint64(key[8:16])
+		t := time.Unix(0, shard.convertUintTimestampToInt64(&timestamp)*int64(time.Microsecond)).UTC()
+		if series.MinTime.IsZero() || t.Before(series.MinTime) {
+			series.MinTime = t
+		}
+		if t.After(series.MaxTime) {
+			series.MaxTime = t
+		}
+	}
+	if err := it.GetError(); err != nil {
+		self.anomaly("leveldb", "Error while reading the points: %s", err)
+	}
+}
+
+type seriesInspections []*SeriesInspection
+
+func (self seriesInspections) Len() int      { return len(self) }
+func (self seriesInspections) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self seriesInspections) Less(i, j int) bool {
+	if self[i].Database != self[j].Database {
+		return self[i].Database < self[j].Database
+	}
+	return self[i].Name < self[j].Name
+}
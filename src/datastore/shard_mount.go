@@ -0,0 +1,71 @@
+package datastore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The mounted shards are opened from a snapshot of the archive in the
+// data directory, leveldb writes its lock, its log and a new manifest
+// when it opens a database and compacts it later on. The table files
+// never change once they're written, they're linked into the snapshot
+// instead of copied if the archive is on the same file system. The other
+// files are copied, leveldb replaces them instead of changing them but
+// they're small.
+func snapshotShard(archive, snapshot string) error {
+	if err := os.RemoveAll(snapshot); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(snapshot, 0744); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(archive, "*"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(file)
+		if info.IsDir() {
+			return fmt.Errorf("%s isn't a shard, it has the directory %s", archive, name)
+		}
+		if name == "LOCK" || name == QUARANTINE_FILE {
+			continue
+		}
+		target := filepath.Join(snapshot, name)
+		if isTableFile(name) && os.Link(file, target) == nil {
+			continue
+		}
+		if err := copyFile(file, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isTableFile(name string) bool {
+	return strings.HasSuffix(name, ".sst") || strings.HasSuffix(name, ".ldb")
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
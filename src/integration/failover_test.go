@@ -0,0 +1,145 @@
+package integration
+
+import (
+	"client"
+	"common"
+	"encoding/json"
+	"fmt"
+	"integration/harness"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+// Runs a cluster in the test process with harness, unlike ServerSuite
+// which starts the daemon
+type FailoverSuite struct {
+	cluster *harness.Cluster
+}
+
+var _ = Suite(&FailoverSuite{})
+
+func (self *FailoverSuite) SetUpSuite(c *C) {
+	cluster, err := harness.NewCluster("/tmp/influxdb/harness", 3, 3)
+	c.Assert(err, IsNil)
+	self.cluster = cluster
+	root, err := cluster.Nodes[0].Client("", "root", "root")
+	c.Assert(err, IsNil)
+	c.Assert(root.CreateDatabase("failover"), IsNil)
+	c.Assert(cluster.WaitForSync(30*time.Second), IsNil)
+}
+
+func (self *FailoverSuite) TearDownSuite(c *C) {
+	if self.cluster != nil {
+		self.cluster.Close()
+	}
+}
+
+func (self *FailoverSuite) write(node *harness.Node, name string, values []interface{}, c *C) {
+	writer, err := node.Client("failover", "root", "root")
+	c.Assert(err, IsNil)
+	series := &client.Series{Name: name, Columns: []string{"value"}}
+	for _, value := range values {
+		series.Points = append(series.Points, []interface{}{value})
+	}
+	c.Assert(writer.WriteSeries([]*client.Series{series}), IsNil)
+}
+
+// Returns the points of the series the node has locally
+func (self *FailoverSuite) localPoints(node *harness.Node, name string, c *C) int {
+	params := url.Values{}
+	params.Set("u", "root")
+	params.Set("p", "root")
+	params.Set("q", fmt.Sprintf("select value from %s", name))
+	params.Set("force_local", "true")
+	resp, err := http.Get(fmt.Sprintf("%s/db/failover/series?%s", node.ApiUrl(), params.Encode()))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK, Commentf("%s", body))
+	series := []*common.SerializedSeries{}
+	c.Assert(json.Unmarshal(body, &series), IsNil)
+	if len(series) == 0 {
+		return 0
+	}
+	return len(series[0].Points)
+}
+
+// Waits for the points to be replicated to the node, the writes to the
+// replicas are asynchronous
+func (self *FailoverSuite) waitForLocalPoints(node *harness.Node, name string, expected int, c *C) {
+	points := 0
+	for i := 0; i < 100; i++ {
+		if points = self.localPoints(node, name, c); points == expected {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Fatalf("node %d has %d points of %s instead of %d", node.Index, points, name, expected)
+}
+
+func (self *FailoverSuite) TestWritesAreReplayedToRestartedNode(c *C) {
+	nodes := self.cluster.Nodes
+	self.write(nodes[0], "replayed", []interface{}{1, 2}, c)
+	c.Assert(self.cluster.WaitForSync(30*time.Second), IsNil)
+	for _, node := range nodes {
+		self.waitForLocalPoints(node, "replayed", 2, c)
+	}
+
+	nodes[2].Kill()
+	c.Assert(self.cluster.WaitForConvergence(30*time.Second), IsNil)
+	self.write(nodes[0], "replayed", []interface{}{3}, c)
+	self.waitForLocalPoints(nodes[1], "replayed", 3, c)
+
+	c.Assert(nodes[2].Restart(), IsNil)
+	c.Assert(self.cluster.WaitForSync(30*time.Second), IsNil)
+	self.waitForLocalPoints(nodes[2], "replayed", 3, c)
+}
+
+func (self *FailoverSuite) TestLeaderFailover(c *C) {
+	nodes := self.cluster.Nodes
+	nodes[0].Kill()
+	c.Assert(self.cluster.WaitForConvergence(30*time.Second), IsNil)
+
+	// the other nodes elect a leader and keep taking writes
+	self.write(nodes[1], "leader_failover", []interface{}{1}, c)
+	self.waitForLocalPoints(nodes[2], "leader_failover", 1, c)
+
+	c.Assert(nodes[0].Restart(), IsNil)
+	c.Assert(self.cluster.WaitForSync(30*time.Second), IsNil)
+	self.waitForLocalPoints(nodes[0], "leader_failover", 1, c)
+}
+
+func (self *FailoverSuite) TestPointsWithoutTimeGetTheTimeOfTheClock(c *C) {
+	now := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	self.cluster.Clock.Set(now)
+	defer self.cluster.Clock.Set(time.Now())
+
+	self.write(self.cluster.Nodes[0], "clock", []interface{}{1}, c)
+	c.Assert(self.cluster.WaitForSync(30*time.Second), IsNil)
+	reader, err := self.cluster.Nodes[1].Client("failover", "root", "root")
+	c.Assert(err, IsNil)
+	series, err := reader.Query("select value from clock where time > now() - 1h")
+	c.Assert(err, IsNil)
+	c.Assert(series, HasLen, 1)
+	c.Assert(series[0].Points[0][0], Equals, json.Number(fmt.Sprint(now.UnixNano()/int64(time.Millisecond))))
+}
+
+func (self *FailoverSuite) TestEmbeddedWritesAndQueries(c *C) {
+	s := self.cluster.Nodes[1].Server()
+	c.Assert(s, NotNil)
+	c.Assert(s.CreateDatabase("embedded", 1), IsNil)
+	c.Assert(self.cluster.WaitForSync(30*time.Second), IsNil)
+
+	series := &common.SerializedSeries{Name: "cpu", Columns: []string{"value"}, Points: [][]interface{}{{1.5}, {2.5}}}
+	c.Assert(s.WritePoints("embedded", []*common.SerializedSeries{series}), IsNil)
+	result, err := s.Query("embedded", "select value from cpu")
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 1)
+	c.Assert(result[0].Name, Equals, "cpu")
+	c.Assert(result[0].Points, HasLen, 2)
+}
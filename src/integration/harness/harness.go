@@ -0,0 +1,319 @@
+// Package harness runs a cluster of servers in the test process, every
+// server with its own directories and ports, so the tests of the
+// replication and the failover don't need a built daemon. The servers
+// share the clock of the cluster, the tests can move it to create
+// shards or run continuous queries.
+//
+//	cluster, err := harness.NewCluster("/tmp/influxdb/harness", 3, 2)
+//	defer cluster.Close()
+//	cluster.Nodes[1].Kill()
+//	...
+//	cluster.Nodes[1].Restart()
+//	cluster.WaitForConvergence(30 * time.Second)
+package harness
+
+import (
+	"client"
+	"common"
+	"configuration"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"server"
+	"sync"
+	"time"
+)
+
+const configTemplate = `
+hostname = "localhost"
+bind-address = "127.0.0.1"
+
+[api]
+port = %d
+
+[raft]
+port = %d
+dir  = "%s"
+
+[storage]
+dir = "%s"
+
+[cluster]
+seed-servers = [%s]
+protobuf_port = %d
+protobuf_timeout = "2s"
+protobuf_heartbeat = "100ms"
+protobuf_min_backoff = "100ms"
+protobuf_max_backoff = "100ms"
+shutdown-timeout = "2s"
+
+[sharding]
+  replication-factor = %d
+  [sharding.short-term]
+  duration = "1h"
+  [sharding.long-term]
+  duration = "24h"
+
+[wal]
+dir = "%s"
+flush-after = 0
+bookmark-after = 0
+index-after = 1000
+`
+
+// The time of the servers of a cluster, it doesn't move unless it's set
+// or advanced
+type Clock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (self *Clock) Now() time.Time {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.now
+}
+
+func (self *Clock) Set(t time.Time) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.now = t
+}
+
+func (self *Clock) Advance(d time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.now = self.now.Add(d)
+}
+
+type Cluster struct {
+	Nodes []*Node
+	Clock *Clock
+	dir   string
+}
+
+type Node struct {
+	// the index of the node in the cluster, not the id of the server
+	Index  int
+	Config *configuration.Configuration
+	lock   sync.Mutex
+	server *server.Server
+}
+
+// Starts a cluster of the given size with its data in dir, which is
+// removed first. The first node is the seed of the others. The clock of
+// the servers is set to the time of the system until the cluster is
+// closed.
+func NewCluster(dir string, size, replicationFactor int) (*Cluster, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	cluster := &Cluster{Clock: &Clock{now: time.Now()}, dir: dir}
+	common.SetClock(cluster.Clock.Now)
+
+	seed := ""
+	for i := 0; i < size; i++ {
+		node, err := newNode(filepath.Join(dir, fmt.Sprint(i)), i, seed, replicationFactor)
+		if err != nil {
+			cluster.Close()
+			return nil, err
+		}
+		cluster.Nodes = append(cluster.Nodes, node)
+		if err := node.Start(); err != nil {
+			cluster.Close()
+			return nil, err
+		}
+		if i == 0 {
+			seed = fmt.Sprintf(`"localhost:%d"`, node.Config.RaftServerPort)
+		}
+	}
+	if err := cluster.WaitForConvergence(30 * time.Second); err != nil {
+		cluster.Close()
+		return nil, err
+	}
+	return cluster, nil
+}
+
+func newNode(dir string, index int, seed string, replicationFactor int) (*Node, error) {
+	ports, err := freePorts(3)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fileName := filepath.Join(dir, "config.toml")
+	config := fmt.Sprintf(configTemplate, ports[0], ports[1], filepath.Join(dir, "raft"), filepath.Join(dir, "db"),
+		seed, ports[2], replicationFactor, filepath.Join(dir, "wal"))
+	if err := ioutil.WriteFile(fileName, []byte(config), 0644); err != nil {
+		return nil, err
+	}
+	parsed, err := configuration.ParseConfiguration(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Index: index, Config: parsed}, nil
+}
+
+// Returns ports nobody listens on, they're free until another process
+// takes them
+func freePorts(n int) ([]int, error) {
+	ports := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// Stops the nodes that are running, removes the data of the cluster and
+// restores the clock of the system
+func (self *Cluster) Close() {
+	for _, node := range self.Nodes {
+		node.Kill()
+	}
+	common.SetClock(nil)
+	os.RemoveAll(self.dir)
+}
+
+func (self *Cluster) RunningNodes() []*Node {
+	nodes := []*Node{}
+	for _, node := range self.Nodes {
+		if node.IsRunning() {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Waits until the nodes that are running agree on the raft leader, have
+// committed all the changes and know every server of the cluster
+func (self *Cluster) WaitForConvergence(timeout time.Duration) error {
+	return waitFor(timeout, self.converged)
+}
+
+// Waits until the cluster converged and the nodes that are running
+// wrote the points they got to the other servers. The writes to the
+// nodes that are down can only be written once they're restarted.
+func (self *Cluster) WaitForSync(timeout time.Duration) error {
+	return waitFor(timeout, func() error {
+		if err := self.converged(); err != nil {
+			return err
+		}
+		for _, node := range self.RunningNodes() {
+			if s := node.Server(); s != nil && s.ClusterConfig.HasUncommitedWrites() {
+				return fmt.Errorf("node %d has writes that weren't written to the other servers", node.Index)
+			}
+		}
+		return nil
+	})
+}
+
+func waitFor(timeout time.Duration, check func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("The cluster didn't sync in %s: %s", timeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (self *Cluster) converged() error {
+	leader := ""
+	for _, node := range self.RunningNodes() {
+		s := node.Server()
+		if s == nil {
+			continue
+		}
+		nodeLeader := s.RaftServer.Leader()
+		if nodeLeader == "" {
+			return fmt.Errorf("node %d has no leader", node.Index)
+		}
+		if leader != "" && nodeLeader != leader {
+			return fmt.Errorf("node %d follows %s instead of %s", node.Index, nodeLeader, leader)
+		}
+		leader = nodeLeader
+		if !s.RaftServer.CommittedAllChanges() {
+			return fmt.Errorf("node %d hasn't committed all the changes", node.Index)
+		}
+		if servers := len(s.ClusterConfig.Servers()); servers != len(self.Nodes) {
+			return fmt.Errorf("node %d knows %d servers out of %d", node.Index, servers, len(self.Nodes))
+		}
+	}
+	return nil
+}
+
+// Starts the server of the node with the data it had, and returns once
+// it takes requests
+func (self *Node) Start() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.server != nil {
+		return fmt.Errorf("Node %d is already running", self.Index)
+	}
+	s, err := server.New(self.Config)
+	if err != nil {
+		return err
+	}
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("Node %d stopped while starting: %v", self.Index, err)
+	}
+	self.server = s
+	return nil
+}
+
+// Stops the server of the node, the other nodes see it go down. Its data
+// stays, Restart starts it again.
+func (self *Node) Kill() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.server == nil {
+		return
+	}
+	self.server.Stop()
+	self.server = nil
+}
+
+func (self *Node) Restart() error {
+	self.Kill()
+	return self.Start()
+}
+
+func (self *Node) IsRunning() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.server != nil
+}
+
+// Returns the server of the node, nil if it isn't running
+func (self *Node) Server() *server.Server {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.server
+}
+
+func (self *Node) ApiUrl() string {
+	return fmt.Sprintf("http://localhost:%d", self.Config.ApiHttpPort)
+}
+
+// Returns a client of the api of the node that doesn't fail over to the
+// other nodes
+func (self *Node) Client(database, username, password string) (*client.Client, error) {
+	return client.NewClient(&client.ClientConfig{
+		Hosts:    []string{fmt.Sprintf("localhost:%d", self.Config.ApiHttpPort)},
+		Username: username,
+		Password: password,
+		Database: database,
+	})
+}
@@ -70,7 +70,7 @@ func (self *Server) WaitForServerToStart() {
 			continue
 		}
 		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode != http.StatusNoContent {
 			panic(resp)
 		}
 		// wait for the server to be marked up by other servers
@@ -290,6 +290,23 @@ func (self *Server) QueryWithUsername(database, query string, onlyLocal bool, c
 	return ResultsToSeriesCollection(js)
 }
 
+// Runs the query with the api key instead of a username and password
+func (self *Server) QueryWithApiKey(database, query, key string, c *C) *SeriesCollection {
+	fullUrl := fmt.Sprintf("http://localhost:%d/db/%s/series?q=%s", self.apiPort, database, url.QueryEscape(query))
+	req, err := http.NewRequest("GET", fullUrl, nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("X-Api-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK, Commentf("%s", body))
+	var js []*common.SerializedSeries
+	c.Assert(json.Unmarshal(body, &js), IsNil)
+	return ResultsToSeriesCollection(js)
+}
+
 func (self *Server) VerifyForbiddenQuery(database, query string, onlyLocal bool, c *C, username, password string) string {
 	encodedQuery := url.QueryEscape(query)
 	fullUrl := fmt.Sprintf("http://localhost:%d/db/%s/series?u=%s&p=%s&q=%s", self.apiPort, database, username, password, encodedQuery)
@@ -240,6 +240,25 @@ func (self *ServerSuite) TestShouldNotResetRootsPassword(c *C) {
 	c.Assert(resp.StatusCode, Equals, http.StatusOK)
 }
 
+// the servers that don't have a copy of the shard query the servers
+// that do, they have to find the api key too
+func (self *ServerSuite) TestApiKeyQueriesRemoteShards(c *C) {
+	body := self.serverProcesses[0].PostGetBody("/api_keys?u=root&p=root", `{"name": "remote_shards", "databases": ["test_rep"], "read": true}`, c)
+	apiKey := map[string]interface{}{}
+	c.Assert(json.Unmarshal(body, &apiKey), IsNil)
+	data := `[{"points": [[1]], "name": "test_api_key_remote_shards", "columns": ["value"]}]`
+	self.serverProcesses[0].Post("/db/test_rep/series?u=paul&p=pass", data, c)
+	for _, s := range self.serverProcesses {
+		s.WaitForServerToSync()
+	}
+
+	for _, s := range self.serverProcesses {
+		collection := s.QueryWithApiKey("test_rep", "select count(value) from test_api_key_remote_shards", apiKey["key"].(string), c)
+		series := collection.GetSeries("test_api_key_remote_shards", c)
+		c.Assert(series.GetValueForPointAndColumn(0, "count", c), Equals, float64(1))
+	}
+}
+
 func (self *ServerSuite) TestDeleteFullReplication(c *C) {
 	data := `
   [{
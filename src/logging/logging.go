@@ -0,0 +1,298 @@
+// package logging has log4go writers that filter the records by the
+// module that logged them and that write the records as json, one
+// object per line, for log aggregation systems.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+var levelNames = map[int]string{
+	int(log.FINEST):   "finest",
+	int(log.FINE):     "fine",
+	int(log.DEBUG):    "debug",
+	int(log.TRACE):    "trace",
+	int(log.INFO):     "info",
+	int(log.WARNING):  "warn",
+	int(log.ERROR):    "error",
+	int(log.CRITICAL): "critical",
+}
+
+// Returns the log4go level with the name, i.e. debug, info, warn or
+// error
+func ParseLevel(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return int(log.DEBUG), nil
+	case "info":
+		return int(log.INFO), nil
+	case "warn", "warning":
+		return int(log.WARNING), nil
+	case "error":
+		return int(log.ERROR), nil
+	}
+	return 0, fmt.Errorf("Unknown log level %s, it should be one of debug, info, warn or error", name)
+}
+
+func LevelName(level int) string {
+	if name, ok := levelNames[level]; ok {
+		return name
+	}
+	return fmt.Sprintf("level%d", level)
+}
+
+// Returns the module that logged the record with the source, i.e. the
+// first element of the package, e.g. api for api/http. log4go sets the
+// source to the function and the line, e.g.
+// coordinator.(*CoordinatorImpl).RunQuery:86
+func Module(source string) string {
+	slash := strings.LastIndex(source, "/")
+	dot := strings.Index(source[slash+1:], ".")
+	if dot < 0 {
+		return source
+	}
+	pkg := source[:slash+1+dot]
+	elements := strings.Split(pkg, "/")
+	// packages outside of the tree, e.g. github.com/goraft/raft, are
+	// named after their last element
+	if strings.Contains(elements[0], ".") {
+		return elements[len(elements)-1]
+	}
+	return elements[0]
+}
+
+// Drops the records that are below the level of their module, the
+// modules that don't have a level use the default one
+type ModuleFilter struct {
+	log.LogWriter
+	lock         sync.RWMutex
+	defaultLevel int
+	levels       map[string]int
+	// the levels that were changed at runtime, they're kept when the
+	// configured levels are replaced
+	overrides map[string]int
+}
+
+func NewModuleFilter(writer log.LogWriter, defaultLevel int, levels map[string]int) *ModuleFilter {
+	return &ModuleFilter{LogWriter: writer, defaultLevel: defaultLevel, levels: levels, overrides: map[string]int{}}
+}
+
+func (self *ModuleFilter) level(module string) int {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if level, ok := self.overrides[module]; ok {
+		return level
+	}
+	if level, ok := self.levels[module]; ok {
+		return level
+	}
+	return self.defaultLevel
+}
+
+// Replaces the default level and the levels of the modules
+func (self *ModuleFilter) SetLevels(defaultLevel int, levels map[string]int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.defaultLevel = defaultLevel
+	self.levels = levels
+}
+
+// Returns the lowest level of the modules, log4go has to pass the
+// records at this level to the filter
+func (self *ModuleFilter) MinLevel() int {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	min := self.defaultLevel
+	for _, level := range self.levels {
+		if level < min {
+			min = level
+		}
+	}
+	for _, level := range self.overrides {
+		if level < min {
+			min = level
+		}
+	}
+	return min
+}
+
+// Overrides the level of the module until it's removed
+func (self *ModuleFilter) SetOverride(module string, level int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.overrides[module] = level
+}
+
+func (self *ModuleFilter) RemoveOverride(module string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.overrides, module)
+}
+
+func (self *ModuleFilter) LogWrite(rec *log.LogRecord) {
+	if int(rec.Level) < self.level(Module(rec.Source)) {
+		return
+	}
+	self.LogWriter.LogWrite(rec)
+}
+
+// Sets the levels of the module filters of the global logger, e.g.
+// when the configuration is reloaded. The levels are the ones of the
+// [logging] section, the overrides are kept.
+func SetLevels(defaultLevel string, moduleLevels map[string]string) error {
+	level, err := ParseLevel(defaultLevel)
+	if err != nil {
+		return err
+	}
+	levels := make(map[string]int, len(moduleLevels))
+	for module, name := range moduleLevels {
+		moduleLevel, err := ParseLevel(name)
+		if err != nil {
+			return fmt.Errorf("Invalid log level of %s: %s", module, err)
+		}
+		levels[module] = moduleLevel
+	}
+	updateModuleFilters(func(filter *ModuleFilter) { filter.SetLevels(level, levels) })
+	return nil
+}
+
+// A level that was changed at runtime, e.g. to debug a module for a
+// while. Until is zero if the override doesn't expire.
+type LevelOverride struct {
+	Module string    `json:"module"`
+	Level  string    `json:"level"`
+	Until  time.Time `json:"until"`
+}
+
+var (
+	overridesLock sync.Mutex
+	overrides     = map[string]*LevelOverride{}
+	overrideTimer = map[string]*time.Timer{}
+)
+
+// Calls f with the module filters of the global logger and updates the
+// levels of their log4go filters
+func updateModuleFilters(f func(*ModuleFilter)) {
+	for _, filter := range log.Global {
+		moduleFilter, ok := filter.LogWriter.(*ModuleFilter)
+		if !ok {
+			continue
+		}
+		f(moduleFilter)
+		filter.Level = log.Level(moduleFilter.MinLevel())
+	}
+}
+
+// Sets the level of the module of the global logger, on top of the
+// configured levels. The configured level is restored after duration,
+// unless it's zero.
+func OverrideLevel(module, levelName string, duration time.Duration) (*LevelOverride, error) {
+	level, err := ParseLevel(levelName)
+	if err != nil {
+		return nil, err
+	}
+	if module == "" {
+		return nil, fmt.Errorf("The module is required")
+	}
+
+	overridesLock.Lock()
+	defer overridesLock.Unlock()
+	if timer := overrideTimer[module]; timer != nil {
+		timer.Stop()
+		delete(overrideTimer, module)
+	}
+	override := &LevelOverride{Module: module, Level: LevelName(level)}
+	if duration > 0 {
+		override.Until = time.Now().Add(duration)
+		var timer *time.Timer
+		timer = time.AfterFunc(duration, func() {
+			overridesLock.Lock()
+			defer overridesLock.Unlock()
+			// the override was replaced in the meantime
+			if overrideTimer[module] != timer {
+				return
+			}
+			removeOverride(module)
+		})
+		overrideTimer[module] = timer
+	}
+	overrides[module] = override
+	updateModuleFilters(func(filter *ModuleFilter) { filter.SetOverride(module, level) })
+	return override, nil
+}
+
+// Restores the configured level of the module
+func RemoveOverride(module string) bool {
+	overridesLock.Lock()
+	defer overridesLock.Unlock()
+	if timer := overrideTimer[module]; timer != nil {
+		timer.Stop()
+	}
+	return removeOverride(module)
+}
+
+// The lock must be held
+func removeOverride(module string) bool {
+	_, ok := overrides[module]
+	delete(overrides, module)
+	delete(overrideTimer, module)
+	updateModuleFilters(func(filter *ModuleFilter) { filter.RemoveOverride(module) })
+	return ok
+}
+
+func Overrides() []*LevelOverride {
+	overridesLock.Lock()
+	defer overridesLock.Unlock()
+	result := make([]*LevelOverride, 0, len(overrides))
+	for _, override := range overrides {
+		result = append(result, override)
+	}
+	return result
+}
+
+type jsonRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Module  string `json:"module"`
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+// Writes every record as a json object on its own line
+type JsonLogWriter struct {
+	lock   sync.Mutex
+	writer io.WriteCloser
+}
+
+func NewJsonLogWriter(writer io.WriteCloser) *JsonLogWriter {
+	return &JsonLogWriter{writer: writer}
+}
+
+func (self *JsonLogWriter) LogWrite(rec *log.LogRecord) {
+	data, err := json.Marshal(&jsonRecord{
+		Time:    rec.Created.UTC().Format(time.RFC3339Nano),
+		Level:   LevelName(int(rec.Level)),
+		Module:  Module(rec.Source),
+		Source:  rec.Source,
+		Message: rec.Message,
+	})
+	if err != nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.writer.Write(append(data, '\n'))
+}
+
+func (self *JsonLogWriter) Close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.writer.Close()
+}
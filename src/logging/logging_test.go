@@ -0,0 +1,139 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	log "code.google.com/p/log4go"
+	. "launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type LoggingSuite struct{}
+
+var _ = Suite(&LoggingSuite{})
+
+type recordingWriter struct {
+	records []*log.LogRecord
+}
+
+func (self *recordingWriter) LogWrite(rec *log.LogRecord) {
+	self.records = append(self.records, rec)
+}
+
+func (self *recordingWriter) Close() {}
+
+type closingBuffer struct {
+	bytes.Buffer
+}
+
+func (self *closingBuffer) Close() error {
+	return nil
+}
+
+func (self *LoggingSuite) TestModule(c *C) {
+	c.Assert(Module("coordinator.(*CoordinatorImpl).RunQuery:86"), Equals, "coordinator")
+	c.Assert(Module("api/http.(*HttpServer).query:12"), Equals, "api")
+	c.Assert(Module("github.com/goraft/raft.(*server).loop:10"), Equals, "raft")
+	c.Assert(Module("main.main:5"), Equals, "main")
+}
+
+func (self *LoggingSuite) TestModuleFilter(c *C) {
+	writer := &recordingWriter{}
+	filter := NewModuleFilter(writer, int(log.INFO), map[string]int{"coordinator": int(log.DEBUG), "wal": int(log.ERROR)})
+	c.Assert(filter.MinLevel(), Equals, int(log.DEBUG))
+
+	filter.LogWrite(&log.LogRecord{Level: log.DEBUG, Source: "coordinator.foo:1"})
+	filter.LogWrite(&log.LogRecord{Level: log.DEBUG, Source: "cluster.foo:1"})
+	filter.LogWrite(&log.LogRecord{Level: log.INFO, Source: "cluster.foo:1"})
+	filter.LogWrite(&log.LogRecord{Level: log.WARNING, Source: "wal.foo:1"})
+	filter.LogWrite(&log.LogRecord{Level: log.ERROR, Source: "wal.foo:1"})
+	c.Assert(writer.records, HasLen, 3)
+	c.Assert(writer.records[0].Source, Equals, "coordinator.foo:1")
+	c.Assert(writer.records[1].Source, Equals, "cluster.foo:1")
+	c.Assert(writer.records[2].Level, Equals, log.ERROR)
+}
+
+func (self *LoggingSuite) TestJsonLogWriter(c *C) {
+	buffer := &closingBuffer{}
+	writer := NewJsonLogWriter(buffer)
+	created := time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)
+	writer.LogWrite(&log.LogRecord{Level: log.WARNING, Created: created, Source: "wal.(*WAL).Commit:10", Message: "slow \"disk\""})
+
+	record := map[string]string{}
+	c.Assert(json.Unmarshal(buffer.Bytes(), &record), IsNil)
+	c.Assert(record, DeepEquals, map[string]string{
+		"time":    "2014-06-01T12:00:00Z",
+		"level":   "warn",
+		"module":  "wal",
+		"source":  "wal.(*WAL).Commit:10",
+		"message": "slow \"disk\"",
+	})
+	c.Assert(bytes.HasSuffix(buffer.Bytes(), []byte("\n")), Equals, true)
+}
+
+func (self *LoggingSuite) TestParseLevel(c *C) {
+	level, err := ParseLevel("WARN")
+	c.Assert(err, IsNil)
+	c.Assert(level, Equals, int(log.WARNING))
+	_, err = ParseLevel("loud")
+	c.Assert(err, NotNil)
+}
+
+func (self *LoggingSuite) TestSetLevels(c *C) {
+	writer := &recordingWriter{}
+	filter := NewModuleFilter(writer, int(log.ERROR), nil)
+	log.Global["test"] = &log.Filter{Level: log.ERROR, LogWriter: filter}
+	defer delete(log.Global, "test")
+
+	c.Assert(SetLevels("warn", map[string]string{"wal": "debug"}), IsNil)
+	c.Assert(log.Global["test"].Level, Equals, log.DEBUG)
+	filter.LogWrite(&log.LogRecord{Level: log.DEBUG, Source: "wal.foo:1"})
+	filter.LogWrite(&log.LogRecord{Level: log.INFO, Source: "cluster.foo:1"})
+	filter.LogWrite(&log.LogRecord{Level: log.WARNING, Source: "cluster.foo:1"})
+	c.Assert(writer.records, HasLen, 2)
+	c.Assert(writer.records[1].Level, Equals, log.WARNING)
+
+	// the levels are kept if any of them is invalid
+	c.Assert(SetLevels("info", map[string]string{"wal": "loud"}), NotNil)
+	c.Assert(log.Global["test"].Level, Equals, log.DEBUG)
+}
+
+func (self *LoggingSuite) TestOverrideLevel(c *C) {
+	writer := &recordingWriter{}
+	filter := NewModuleFilter(writer, int(log.WARNING), nil)
+	log.Global["test"] = &log.Filter{Level: log.WARNING, LogWriter: filter}
+	defer delete(log.Global, "test")
+
+	_, err := OverrideLevel("coordinator", "loud", 0)
+	c.Assert(err, NotNil)
+	override, err := OverrideLevel("coordinator", "debug", 0)
+	c.Assert(err, IsNil)
+	c.Assert(override.Until.IsZero(), Equals, true)
+	c.Assert(log.Global["test"].Level, Equals, log.DEBUG)
+	filter.LogWrite(&log.LogRecord{Level: log.DEBUG, Source: "coordinator.foo:1"})
+	filter.LogWrite(&log.LogRecord{Level: log.DEBUG, Source: "cluster.foo:1"})
+	c.Assert(writer.records, HasLen, 1)
+
+	// reloading the configuration keeps the override
+	c.Assert(SetLevels("error", nil), IsNil)
+	c.Assert(log.Global["test"].Level, Equals, log.DEBUG)
+	c.Assert(Overrides(), HasLen, 1)
+
+	c.Assert(RemoveOverride("coordinator"), Equals, true)
+	c.Assert(log.Global["test"].Level, Equals, log.ERROR)
+	c.Assert(Overrides(), HasLen, 0)
+
+	// the override expires
+	_, err = OverrideLevel("wal", "info", 10*time.Millisecond)
+	c.Assert(err, IsNil)
+	c.Assert(log.Global["test"].Level, Equals, log.INFO)
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(log.Global["test"].Level, Equals, log.ERROR)
+	c.Assert(Overrides(), HasLen, 0)
+}
@@ -0,0 +1,207 @@
+// package monitor writes the stats of the server to a database every
+// interval, so influxdb can be graphed and alerted on with itself.
+package monitor
+
+import (
+	"cluster"
+	"configuration"
+	"coordinator"
+	"protocol"
+	"runtime"
+	"stats"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// The series the monitor writes besides one series for every stat of
+// the stats package
+const (
+	RUNTIME_SERIES   = "runtime"
+	SHARDS_SERIES    = "shards"
+	DATABASES_SERIES = "databases"
+)
+
+type Monitor struct {
+	database      string
+	interval      time.Duration
+	coordinator   coordinator.Coordinator
+	clusterConfig *cluster.ClusterConfiguration
+	started       bool
+	// the points written to every database at the last collection
+	lastPointsWritten map[string]float64
+	lastCollection    time.Time
+	shutdown          chan bool
+	done              chan bool
+}
+
+func NewMonitor(config *configuration.Configuration, coord coordinator.Coordinator, clusterConfig *cluster.ClusterConfiguration) *Monitor {
+	return &Monitor{
+		database:      config.MonitoringDatabase,
+		interval:      config.MonitoringInterval,
+		coordinator:   coord,
+		clusterConfig: clusterConfig,
+		shutdown:      make(chan bool),
+		done:          make(chan bool),
+	}
+}
+
+// Creates the database if it doesn't exist and writes the stats every
+// interval until Close is called
+func (self *Monitor) Start() {
+	self.started = true
+	self.lastCollection = time.Now()
+	self.lastPointsWritten = pointsWrittenByDatabase()
+	go self.run()
+}
+
+func (self *Monitor) Close() {
+	if !self.started {
+		return
+	}
+	close(self.shutdown)
+	<-self.done
+}
+
+func (self *Monitor) run() {
+	defer close(self.done)
+	ticker := time.NewTicker(self.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.shutdown:
+			return
+		case now := <-ticker.C:
+			if err := self.writeSeries(self.collect(now)); err != nil {
+				log.Error("Monitor: Cannot write the stats to %s: %s", self.database, err)
+			}
+		}
+	}
+}
+
+func (self *Monitor) createDatabase() error {
+	if self.clusterConfig.DatabaseExists(self.database) {
+		return nil
+	}
+	log.Info("Monitor: Creating the database %s", self.database)
+	return self.coordinator.CreateDatabase(cluster.InternalClusterAdmin, self.database, 1)
+}
+
+func (self *Monitor) writeSeries(series []*protocol.Series) error {
+	if err := self.createDatabase(); err != nil {
+		return err
+	}
+	return coordinator.WriteInputSeries(self.coordinator, self.database, series)
+}
+
+func (self *Monitor) collect(now time.Time) []*protocol.Series {
+	timestamp := now.UnixNano() / int64(time.Microsecond)
+	serverId := int64(self.clusterConfig.ServerId())
+
+	series := []*protocol.Series{
+		runtimeSeries(serverId, timestamp),
+		self.shardsSeries(serverId, timestamp),
+	}
+
+	pointsWritten := pointsWrittenByDatabase()
+	if s := databasesSeries(serverId, timestamp, self.lastPointsWritten, pointsWritten, now.Sub(self.lastCollection)); s != nil {
+		series = append(series, s)
+	}
+	self.lastPointsWritten = pointsWritten
+	self.lastCollection = now
+
+	return append(series, statsSeries(serverId, timestamp)...)
+}
+
+func runtimeSeries(serverId, timestamp int64) *protocol.Series {
+	memStats := &runtime.MemStats{}
+	runtime.ReadMemStats(memStats)
+	return newSeries(RUNTIME_SERIES, timestamp,
+		[]string{"server_id", "goroutines", "heap_alloc", "heap_inuse", "heap_objects", "gc_count", "gc_pause_total_ms"},
+		serverId, int64(runtime.NumGoroutine()), int64(memStats.HeapAlloc), int64(memStats.HeapInuse),
+		int64(memStats.HeapObjects), int64(memStats.NumGC), float64(memStats.PauseTotalNs)/float64(time.Millisecond))
+}
+
+func (self *Monitor) shardsSeries(serverId, timestamp int64) *protocol.Series {
+	shards := self.clusterConfig.GetAllShards()
+	local := int64(0)
+	for _, shard := range shards {
+		if shard.IsLocal {
+			local++
+		}
+	}
+	return newSeries(SHARDS_SERIES, timestamp, []string{"server_id", "total", "local"}, serverId, int64(len(shards)), local)
+}
+
+func pointsWrittenByDatabase() map[string]float64 {
+	var values map[string]float64
+	stats.Do(func(name string, stat map[string]float64) {
+		if name == coordinator.POINTS_WRITTEN_BY_DATABASE {
+			values = stat
+		}
+	})
+	return values
+}
+
+// Returns a point for every database with the points written since the
+// last collection and the rate per second, nil if there were no writes
+func databasesSeries(serverId, timestamp int64, last, current map[string]float64, elapsed time.Duration) *protocol.Series {
+	if len(current) == 0 {
+		return nil
+	}
+	series := &protocol.Series{
+		Name:   protocol.String(DATABASES_SERIES),
+		Fields: []string{"server_id", "database", "points_written", "write_rate"},
+	}
+	for db, count := range current {
+		written := count - last[db]
+		rate := 0.0
+		if elapsed > 0 {
+			rate = written / elapsed.Seconds()
+		}
+		series.Points = append(series.Points, newPoint(timestamp, serverId, db, written, rate))
+	}
+	return series
+}
+
+// Returns a series for every stat, the counters of the databases are in
+// the databases series
+func statsSeries(serverId, timestamp int64) []*protocol.Series {
+	series := []*protocol.Series{}
+	stats.Do(func(name string, values map[string]float64) {
+		if name == coordinator.POINTS_WRITTEN_BY_DATABASE {
+			return
+		}
+		fields := []string{"server_id"}
+		fieldValues := []interface{}{serverId}
+		for field, value := range values {
+			fields = append(fields, field)
+			fieldValues = append(fieldValues, value)
+		}
+		series = append(series, newSeries(name, timestamp, fields, fieldValues...))
+	})
+	return series
+}
+
+func newSeries(name string, timestamp int64, fields []string, values ...interface{}) *protocol.Series {
+	return &protocol.Series{
+		Name:   protocol.String(name),
+		Fields: fields,
+		Points: []*protocol.Point{newPoint(timestamp, values...)},
+	}
+}
+
+func newPoint(timestamp int64, values ...interface{}) *protocol.Point {
+	fieldValues := make([]*protocol.FieldValue, 0, len(values))
+	for _, value := range values {
+		switch x := value.(type) {
+		case int64:
+			fieldValues = append(fieldValues, &protocol.FieldValue{Int64Value: &x})
+		case float64:
+			fieldValues = append(fieldValues, &protocol.FieldValue{DoubleValue: &x})
+		case string:
+			fieldValues = append(fieldValues, &protocol.FieldValue{StringValue: &x})
+		}
+	}
+	return &protocol.Point{Timestamp: &timestamp, Values: fieldValues}
+}
@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MonitorSuite struct{}
+
+var _ = Suite(&MonitorSuite{})
+
+func (self *MonitorSuite) TestDatabasesSeries(c *C) {
+	last := map[string]float64{"db1": 100}
+	current := map[string]float64{"db1": 300, "db2": 50}
+	series := databasesSeries(1, 10, last, current, 10*time.Second)
+	c.Assert(series.GetName(), Equals, DATABASES_SERIES)
+	c.Assert(series.Fields, DeepEquals, []string{"server_id", "database", "points_written", "write_rate"})
+	c.Assert(series.Points, HasLen, 2)
+
+	written := map[string][]float64{}
+	for _, point := range series.Points {
+		c.Assert(point.GetTimestamp(), Equals, int64(10))
+		c.Assert(point.Values[0].GetInt64Value(), Equals, int64(1))
+		written[point.Values[1].GetStringValue()] = []float64{point.Values[2].GetDoubleValue(), point.Values[3].GetDoubleValue()}
+	}
+	c.Assert(written, DeepEquals, map[string][]float64{"db1": {200, 20}, "db2": {50, 5}})
+
+	c.Assert(databasesSeries(1, 10, last, nil, time.Second), IsNil)
+}
+
+func (self *MonitorSuite) TestRuntimeSeries(c *C) {
+	series := runtimeSeries(2, 10)
+	c.Assert(series.GetName(), Equals, RUNTIME_SERIES)
+	c.Assert(series.Points, HasLen, 1)
+	c.Assert(series.Points[0].Values, HasLen, len(series.Fields))
+	c.Assert(series.Points[0].Values[0].GetInt64Value(), Equals, int64(2))
+	c.Assert(series.Points[0].Values[1].GetInt64Value() > 0, Equals, true)
+}
+
+func fakeStats(f func(string, map[string]float64)) {
+	f("pointsWritten", map[string]float64{"count": 10})
+	f("pointsWrittenByDatabase", map[string]float64{"my db": 3})
+}
+
+func (self *MonitorSuite) TestGraphiteLines(c *C) {
+	lines := graphiteLines("influxdb.host1", time.Unix(100, 0), fakeStats)
+	c.Assert(string(lines), Equals, "influxdb.host1.pointsWritten.count 10 100\ninfluxdb.host1.pointsWrittenByDatabase.my_db 3 100\n")
+}
+
+func (self *MonitorSuite) TestStatsdPackets(c *C) {
+	lines := statsdLines("influxdb.host1", fakeStats)
+	c.Assert(lines, DeepEquals, []string{"influxdb.host1.pointsWritten.count:10|g", "influxdb.host1.pointsWrittenByDatabase.my_db:3|g"})
+
+	packets := statsdPackets(lines)
+	c.Assert(packets, HasLen, 1)
+	c.Assert(string(packets[0]), Equals, lines[0]+"\n"+lines[1])
+
+	long := strings.Repeat("a", statsdPacketSize-10)
+	packets = statsdPackets([]string{long, "b:1|g", long})
+	c.Assert(packets, HasLen, 2)
+	c.Assert(string(packets[0]), Equals, long+"\nb:1|g")
+	c.Assert(string(packets[1]), Equals, long)
+}
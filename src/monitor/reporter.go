@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"bytes"
+	"configuration"
+	"fmt"
+	"net"
+	"stats"
+	"strings"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// The maximum size of the statsd packets, small enough to not get
+// fragmented
+const statsdPacketSize = 512
+
+// Pushes the stats of the server to a graphite or a statsd server every
+// interval
+type Reporter struct {
+	protocol string
+	address  string
+	// the prefix of the metrics, followed by the hostname
+	prefix   string
+	interval time.Duration
+	shutdown chan bool
+	done     chan bool
+	started  bool
+}
+
+func NewReporter(config *configuration.Configuration) *Reporter {
+	hostname := strings.Replace(config.HostnameOrDetect(), ".", "_", -1)
+	return &Reporter{
+		protocol: config.ReporterProtocol,
+		address:  config.ReporterAddress,
+		prefix:   config.ReporterPrefix + "." + hostname,
+		interval: config.ReporterInterval,
+		shutdown: make(chan bool),
+		done:     make(chan bool),
+	}
+}
+
+func (self *Reporter) Start() error {
+	if self.protocol != "graphite" && self.protocol != "statsd" {
+		return fmt.Errorf("Unknown reporter protocol %s, it should be graphite or statsd", self.protocol)
+	}
+	self.started = true
+	go self.run()
+	return nil
+}
+
+func (self *Reporter) Close() {
+	if !self.started {
+		return
+	}
+	close(self.shutdown)
+	<-self.done
+}
+
+func (self *Reporter) run() {
+	defer close(self.done)
+	ticker := time.NewTicker(self.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.shutdown:
+			return
+		case now := <-ticker.C:
+			if err := self.report(now); err != nil {
+				log.Error("Reporter: Cannot send the stats to %s %s: %s", self.protocol, self.address, err)
+			}
+		}
+	}
+}
+
+func (self *Reporter) report(now time.Time) error {
+	if self.protocol == "statsd" {
+		return self.send("udp", statsdPackets(statsdLines(self.prefix, stats.Do)))
+	}
+	return self.send("tcp", [][]byte{graphiteLines(self.prefix, now, stats.Do)})
+}
+
+// Opens a new connection on every report, so the reporter recovers
+// from the restarts of the server without any bookkeeping
+func (self *Reporter) send(network string, payloads [][]byte) error {
+	conn, err := net.DialTimeout(network, self.address, self.interval)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(self.interval))
+	for _, payload := range payloads {
+		if _, err := conn.Write(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the graphite plaintext protocol lines of the stats, i.e.
+// <prefix>.<stat>.<field> <value> <timestamp>
+func graphiteLines(prefix string, now time.Time, do func(func(string, map[string]float64))) []byte {
+	buffer := bytes.NewBuffer(nil)
+	timestamp := now.Unix()
+	do(func(name string, values map[string]float64) {
+		for field, value := range values {
+			fmt.Fprintf(buffer, "%s %v %d\n", metricName(prefix, name, field), value, timestamp)
+		}
+	})
+	return buffer.Bytes()
+}
+
+// Returns the stats as statsd gauges, i.e. <prefix>.<stat>.<field>:<value>|g
+func statsdLines(prefix string, do func(func(string, map[string]float64))) []string {
+	lines := []string{}
+	do(func(name string, values map[string]float64) {
+		for field, value := range values {
+			lines = append(lines, fmt.Sprintf("%s:%v|g", metricName(prefix, name, field), value))
+		}
+	})
+	return lines
+}
+
+// Packs the lines in packets of at most statsdPacketSize bytes, unless
+// a line is longer than that
+func statsdPackets(lines []string) [][]byte {
+	packets := [][]byte{}
+	packet := bytes.NewBuffer(nil)
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > statsdPacketSize {
+			packets = append(packets, packet.Bytes())
+			packet = bytes.NewBuffer(nil)
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.Bytes())
+	}
+	return packets
+}
+
+var metricNameReplacer = strings.NewReplacer(" ", "_", ":", "_", "|", "_", "/", "_", "\n", "_")
+
+// The fields of the keyed stats are user data, e.g. the names of the
+// databases, and can't break the protocols
+func metricName(prefix, name, field string) string {
+	return prefix + "." + metricNameReplacer.Replace(name) + "." + metricNameReplacer.Replace(field)
+}
@@ -130,6 +130,12 @@ free_drop_series_query (drop_series_query *q)
   free_value(q->name);
 }
 
+void
+free_show_grants_query (show_grants_query *q)
+{
+  free_value(q->user);
+}
+
 void
 close_query (query *q)
 {
@@ -151,6 +157,11 @@ close_query (query *q)
     free(q->drop_query);
   }
 
+  if (q->show_grants_query) {
+    free_show_grants_query(q->show_grants_query);
+    free(q->show_grants_query);
+  }
+
   if (q->delete_query) {
     free_delete_query(q->delete_query);
     free(q->delete_query);
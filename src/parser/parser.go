@@ -6,6 +6,7 @@ import "C"
 
 import (
 	"bytes"
+	"common"
 	"fmt"
 	"math"
 	"reflect"
@@ -57,6 +58,17 @@ type ListQuery struct {
 	Type ListType
 }
 
+type ShowType int
+
+const (
+	Stats ShowType = iota
+	Diagnostics
+)
+
+type ShowQuery struct {
+	Type ShowType
+}
+
 type DropQuery struct {
 	Id int
 }
@@ -73,6 +85,14 @@ type DeleteQuery struct {
 	SelectDeleteCommonQuery
 }
 
+type ShowGrantsQuery struct {
+	user string
+}
+
+func (self *ShowGrantsQuery) GetUser() string {
+	return self.user
+}
+
 type Query struct {
 	QueryString     string
 	SelectQuery     *SelectQuery
@@ -80,6 +100,8 @@ type Query struct {
 	ListQuery       *ListQuery
 	DropSeriesQuery *DropSeriesQuery
 	DropQuery       *DropQuery
+	ShowGrantsQuery *ShowGrantsQuery
+	ShowQuery       *ShowQuery
 }
 
 func (self *IntoClause) GetString() string {
@@ -112,6 +134,14 @@ func (self *Query) IsListQuery() bool {
 	return self.ListQuery != nil
 }
 
+func (self *Query) IsShowStatsQuery() bool {
+	return self.ShowQuery != nil && self.ShowQuery.Type == Stats
+}
+
+func (self *Query) IsShowDiagnosticsQuery() bool {
+	return self.ShowQuery != nil && self.ShowQuery.Type == Diagnostics
+}
+
 func (self *Query) IsExplainQuery() bool {
 	return self.SelectQuery != nil && self.SelectQuery.Explain
 }
@@ -533,6 +563,14 @@ func ParseQuery(query string) ([]*Query, error) {
 		return []*Query{&Query{QueryString: query, ListQuery: &ListQuery{Type: ContinuousQueries}}}, nil
 	}
 
+	if q.show_stats_query != 0 {
+		return []*Query{&Query{QueryString: query, ShowQuery: &ShowQuery{Type: Stats}}}, nil
+	}
+
+	if q.show_diagnostics_query != 0 {
+		return []*Query{&Query{QueryString: query, ShowQuery: &ShowQuery{Type: Diagnostics}}}, nil
+	}
+
 	if q.select_query != nil {
 		selectQuery, err := parseSelectQuery(q.select_query)
 		if err != nil {
@@ -554,6 +592,12 @@ func ParseQuery(query string) ([]*Query, error) {
 		return []*Query{&Query{QueryString: query, DropSeriesQuery: dropSeriesQuery}}, nil
 	} else if q.drop_query != nil {
 		return []*Query{&Query{QueryString: query, DropQuery: &DropQuery{Id: int(q.drop_query.id)}}}, nil
+	} else if q.show_grants_query != nil {
+		user, err := GetValue(q.show_grants_query.user)
+		if err != nil {
+			return nil, err
+		}
+		return []*Query{&Query{QueryString: query, ShowGrantsQuery: &ShowGrantsQuery{user: user.Name}}}, nil
 	}
 	return nil, fmt.Errorf("Unknown query type encountered")
 }
@@ -574,7 +618,7 @@ func parseSelectDeleteCommonQuery(fromClause *C.from_clause, whereCondition *C.c
 	goQuery := SelectDeleteCommonQuery{
 		BasicQuery: BasicQuery{
 			startTime: time.Unix(math.MinInt64/1000000000, 0).UTC(),
-			endTime:   time.Now().UTC(),
+			endTime:   common.Now().UTC(),
 		},
 	}
 
@@ -150,6 +150,31 @@ func (self *QueryParserSuite) TestParseDropSeries(c *C) {
 	c.Assert(q.GetTableName(), Equals, "foobar")
 }
 
+func (self *QueryParserSuite) TestParseShowGrants(c *C) {
+	queries, err := ParseQuery("show grants for collector.agent")
+	c.Assert(err, IsNil)
+	c.Assert(queries, HasLen, 1)
+	c.Assert(queries[0].ShowGrantsQuery, NotNil)
+	c.Assert(queries[0].ShowGrantsQuery.GetUser(), Equals, "collector.agent")
+
+	queries, err = ParseQuery("SHOW GRANTS FOR root")
+	c.Assert(err, IsNil)
+	c.Assert(queries[0].ShowGrantsQuery.GetUser(), Equals, "root")
+}
+
+func (self *QueryParserSuite) TestParseShowStatsAndDiagnostics(c *C) {
+	queries, err := ParseQuery("show stats")
+	c.Assert(err, IsNil)
+	c.Assert(queries, HasLen, 1)
+	c.Assert(queries[0].IsShowStatsQuery(), Equals, true)
+	c.Assert(queries[0].IsShowDiagnosticsQuery(), Equals, false)
+
+	queries, err = ParseQuery("SHOW DIAGNOSTICS")
+	c.Assert(err, IsNil)
+	c.Assert(queries, HasLen, 1)
+	c.Assert(queries[0].IsShowDiagnosticsQuery(), Equals, true)
+}
+
 func (self *QueryParserSuite) TestGetQueryStringForContinuousQuery(c *C) {
 	base := time.Now().Truncate(time.Minute)
 	start := base.UTC()
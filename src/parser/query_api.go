@@ -257,7 +257,7 @@ func parseTimeWithoutSuffix(value string) (int64, error) {
 func parseTime(value *Value) (int64, error) {
 	if value.Type != ValueExpression {
 		if value.IsFunctionCall() && strings.ToLower(value.Name) == "now" {
-			return time.Now().UTC().UnixNano(), nil
+			return common.Now().UTC().UnixNano(), nil
 		}
 
 		if value.IsFunctionCall() {
@@ -108,13 +108,20 @@ typedef struct {
   int id;
 } drop_query;
 
+typedef struct {
+  value *user;
+} show_grants_query;
+
 typedef struct {
   select_query *select_query;
   delete_query *delete_query;
   drop_series_query *drop_series_query;
   drop_query *drop_query;
+  show_grants_query *show_grants_query;
   char list_series_query;
   char list_continuous_queries_query;
+  char show_stats_query;
+  char show_diagnostics_query;
   error *error;
 } query;
 
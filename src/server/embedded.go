@@ -0,0 +1,115 @@
+package server
+
+// The api of a server that runs in the process of an application or a
+// test instead of a separate influxd, e.g.
+//
+//	s, err := server.New(config)
+//	...
+//	err = s.Start()
+//	...
+//	defer s.Stop()
+//	err = s.WritePoints("db1", []*common.SerializedSeries{series})
+//	...
+//	result, err := s.Query("db1", "select * from cpu")
+//
+// The writes and the queries go through the coordinator as the internal
+// cluster admin, the http api and the other inputs run like they do in
+// influxd.
+
+import (
+	"cluster"
+	"common"
+	"configuration"
+	"fmt"
+	"os"
+	"protocol"
+)
+
+// Returns a server with the configuration, its raft and data
+// directories are created if they don't exist
+func New(config *configuration.Configuration) (*Server, error) {
+	for _, dir := range []string{config.RaftDir, config.DataDir} {
+		if err := os.MkdirAll(dir, 0744); err != nil {
+			return nil, err
+		}
+	}
+	return NewServer(config)
+}
+
+// Starts the server in the background and returns once it joined the
+// cluster, replayed the wal and listens on the api, or the error that
+// stopped it while it was starting
+func (self *Server) Start() error {
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- self.ListenAndServe()
+	}()
+
+	select {
+	case <-self.ready:
+		return nil
+	case err := <-stopped:
+		if err == nil {
+			err = fmt.Errorf("The server stopped while starting")
+		}
+		return err
+	}
+}
+
+// Creates the database if it doesn't exist
+func (self *Server) CreateDatabase(db string, replicationFactor uint8) error {
+	if self.ClusterConfig.DatabaseExists(db) {
+		return nil
+	}
+	return self.Coordinator.CreateDatabase(cluster.InternalClusterAdmin, db, replicationFactor)
+}
+
+// Writes the points like the http api does, the times are in
+// milliseconds. The database has to exist.
+func (self *Server) WritePoints(db string, series []*common.SerializedSeries) error {
+	converted := make([]*protocol.Series, 0, len(series))
+	for _, s := range series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		c, err := common.ConvertToDataStoreSeries(s, common.MillisecondPrecision)
+		if err != nil {
+			return err
+		}
+		converted = append(converted, c)
+	}
+	return self.Coordinator.WriteSeriesData(cluster.InternalClusterAdmin, db, converted)
+}
+
+// Collects the series of a query, the chunks of a series are merged
+type seriesCollector struct {
+	series map[string]*protocol.Series
+	names  []string
+}
+
+func (self *seriesCollector) Write(series *protocol.Series) error {
+	name := series.GetName()
+	if old := self.series[name]; old != nil {
+		self.series[name] = common.MergeSeries(old, series)
+		return nil
+	}
+	self.series[name] = series
+	self.names = append(self.names, name)
+	return nil
+}
+
+func (self *seriesCollector) Close() {}
+
+// Runs the query and returns its series like the http api does, the
+// times are in milliseconds
+func (self *Server) Query(db, query string) ([]*common.SerializedSeries, error) {
+	collector := &seriesCollector{series: map[string]*protocol.Series{}}
+	if err := self.Coordinator.RunQuery(cluster.InternalClusterAdmin, db, query, collector); err != nil {
+		return nil, err
+	}
+	result := make([]*common.SerializedSeries, 0, len(collector.names))
+	for _, name := range collector.names {
+		result = append(result, common.SerializeSeries(map[string]*protocol.Series{name: collector.series[name]}, common.MillisecondPrecision)...)
+	}
+	return result, nil
+}
@@ -2,31 +2,57 @@ package server
 
 import (
 	"admin"
+	"api/collectd"
 	"api/graphite"
 	"api/http"
+	"api/kafka"
+	"api/mqtt"
+	"api/opentsdb"
+	"api/statsd"
+	"api/udp"
 	"cluster"
 	"configuration"
 	"coordinator"
 	"datastore"
+	"fmt"
+	"logging"
+	"monitor"
+	"net"
+	"path/filepath"
 	"time"
 	"wal"
 
 	log "code.google.com/p/log4go"
 )
 
+// the directory in the data dir that has the async writes queue
+const INTAKE_QUEUE_DIR = "intake"
+
 type Server struct {
 	RaftServer     *coordinator.RaftServer
 	ProtobufServer *coordinator.ProtobufServer
 	ClusterConfig  *cluster.ClusterConfiguration
 	HttpApi        *http.HttpServer
 	GraphiteApi    *graphite.Server
+	UdpApi         *udp.Server
+	CollectdApi    *collectd.Server
+	OpenTsdbApi    *opentsdb.Server
+	StatsdApi      *statsd.Server
+	KafkaApi       *kafka.Server
+	MqttApi        *mqtt.Server
 	AdminServer    *admin.HttpServer
+	Monitor        *monitor.Monitor
+	Reporter       *monitor.Reporter
 	Coordinator    coordinator.Coordinator
 	Config         *configuration.Configuration
 	RequestHandler *coordinator.ProtobufRequestHandler
 	stopped        bool
-	writeLog       *wal.WAL
-	shardStore     *datastore.LevelDbShardDatastore
+	// closed when the server stops
+	watchdogStop chan bool
+	// closed once the server takes requests
+	ready      chan bool
+	writeLog   *wal.WAL
+	shardStore *datastore.LevelDbShardDatastore
 }
 
 func NewServer(config *configuration.Configuration) (*Server, error) {
@@ -44,34 +70,162 @@ func NewServer(config *configuration.Configuration) (*Server, error) {
 		return nil, err
 	}
 
+	if err := cluster.SetPasswordHashCost(config.PasswordHashCost); err != nil {
+		return nil, err
+	}
+	cluster.SetAuthCacheTtl(config.AuthCacheTtl)
+	if err := cluster.SetCredentialsKey(config.CredentialsKey); err != nil {
+		return nil, err
+	}
+
 	clusterConfig := cluster.NewClusterConfiguration(config, writeLog, shardDb, newClient)
 	raftServer := coordinator.NewRaftServer(config, clusterConfig)
 	clusterConfig.LocalRaftName = raftServer.GetRaftName()
 	clusterConfig.SetShardCreator(raftServer)
 	clusterConfig.CreateFutureShardsAutomaticallyBeforeTimeComes()
+	if config.LdapEnabled {
+		log.Info("Authenticating the users with %s", config.LdapUrl)
+		clusterConfig.SetAuthenticator(&cluster.LdapAuthenticator{
+			Url:                config.LdapUrl,
+			InsecureSkipVerify: config.LdapInsecureSkipVerify,
+			UserDn:             config.LdapUserDn,
+			GroupSearchBase:    config.LdapGroupSearchBase,
+			GroupSearchFilter:  config.LdapGroupSearchFilter,
+			GroupAttribute:     config.LdapGroupAttribute,
+		}, config.LdapGroupRoles, config.LdapAdminGroups)
+	}
 
 	coord := coordinator.NewCoordinatorImpl(config, raftServer, clusterConfig)
+	if config.AuthorizerUrl != "" {
+		coord.SetAuthorizer(coordinator.NewHttpAuthorizer(config.AuthorizerUrl, config.AuthorizerTimeout, config.AuthorizerFailOpen))
+	}
 	requestHandler := coordinator.NewProtobufRequestHandler(coord, clusterConfig)
 	protobufServer := coordinator.NewProtobufServer(config.ProtobufPortString(), requestHandler)
 
 	raftServer.AssignCoordinator(coord)
 	httpApi := http.NewHttpServer(config.ApiHttpPortString(), config.ApiReadTimeout, config.AdminAssetsDir, coord, coord, clusterConfig, raftServer)
 	httpApi.EnableSsl(config.ApiHttpSslPortString(), config.ApiHttpCertPath)
-	graphiteApi := graphite.NewServer(config, coord, clusterConfig)
+	httpApi.EnableClientCertificates(config.ApiHttpSslClientCaPath, config.ApiHttpSslClientAdminOUs)
+	httpApi.SetSslReloadInterval(config.ApiHttpSslReloadInterval)
+	if err := httpApi.EnableTokens(config.ApiTokenSecret, config.ApiTokenTtl); err != nil {
+		return nil, err
+	}
+	httpApi.SetPasswordResetTtl(config.ApiPasswordResetTtl)
+	if err := httpApi.EnablePam(config.ApiPamService, config.ApiPamListeners); err != nil {
+		return nil, err
+	}
+	if err := httpApi.EnableAuditLog(config.AuditLogFile, config.AuditLogMaxSize, config.AuditLogMaxBackups); err != nil {
+		return nil, err
+	}
+	httpApi.SetCorsPolicy(&http.CorsPolicy{
+		AllowedOrigins:   config.ApiCorsAllowedOrigins,
+		AllowedMethods:   config.ApiCorsAllowedMethods,
+		AllowedHeaders:   config.ApiCorsAllowedHeaders,
+		AllowCredentials: config.ApiCorsAllowCredentials,
+		MaxAge:           config.ApiCorsMaxAge,
+	})
+	if config.ApiAsyncWrites {
+		if err := httpApi.EnableAsyncWrites(filepath.Join(config.DataDir, INTAKE_QUEUE_DIR)); err != nil {
+			return nil, err
+		}
+	}
+	httpApi.SetVersion(config.InfluxDBVersion, config.InfluxDBGitSha, config.InfluxDBBuildDate)
+	if config.ApiUnixSocket != "" {
+		httpApi.EnableUnixSocket(config.ApiUnixSocket, config.ApiUnixSocketPermissions)
+	}
+	httpApi.SetSlowRequestThreshold(config.ApiSlowRequestThreshold)
+	httpApi.SetDeadLetterQueue(shardDb.DeadLetters())
+	if config.ApiPprofEnabled {
+		httpApi.EnablePprof(config.ApiBlockProfileRate)
+	}
+	httpApi.SetLoginLockout(config.MaxFailedLogins, config.LockoutDuration)
+	httpApi.SetRequestLimits(config.ApiMaxBodySize, config.ApiMaxPointsPerWrite)
+	httpApi.SetRateLimits(apiRateLimits(config))
+	graphiteApi, err := graphite.NewServer(config, coord)
+	if err != nil {
+		return nil, err
+	}
+	udpApi := udp.NewServer(config, coord)
+	collectdApi, err := collectd.NewServer(config, coord)
+	if err != nil {
+		return nil, err
+	}
+	openTsdbApi := opentsdb.NewServer(config, coord)
+	statsdApi := statsd.NewServer(config, coord)
+	kafkaApi, err := kafka.NewServer(config, coord)
+	if err != nil {
+		return nil, err
+	}
+	mqttApi, err := mqtt.NewServer(config, coord)
+	if err != nil {
+		return nil, err
+	}
 	adminServer := admin.NewHttpServer(config.AdminAssetsDir, config.AdminHttpPortString())
+	statsMonitor := monitor.NewMonitor(config, coord, clusterConfig)
+	statsReporter := monitor.NewReporter(config)
 
-	return &Server{
+	server := &Server{
 		RaftServer:     raftServer,
 		ProtobufServer: protobufServer,
 		ClusterConfig:  clusterConfig,
 		HttpApi:        httpApi,
 		GraphiteApi:    graphiteApi,
+		UdpApi:         udpApi,
+		CollectdApi:    collectdApi,
+		OpenTsdbApi:    openTsdbApi,
+		StatsdApi:      statsdApi,
+		KafkaApi:       kafkaApi,
+		MqttApi:        mqttApi,
 		Coordinator:    coord,
 		AdminServer:    adminServer,
+		Monitor:        statsMonitor,
+		Reporter:       statsReporter,
 		Config:         config,
 		RequestHandler: requestHandler,
+		watchdogStop:   make(chan bool),
+		ready:          make(chan bool),
 		writeLog:       writeLog,
-		shardStore:     shardDb}, nil
+		shardStore:     shardDb}
+	httpApi.SetConfigReloader(server.Reload)
+	return server, nil
+}
+
+// Returns the per user and the per database rate limits of the api
+func apiRateLimits(config *configuration.Configuration) (http.RateLimits, http.RateLimits) {
+	return http.RateLimits{
+		QueriesPerSecond: float64(config.ApiUserQueriesPerSecond),
+		WritesPerSecond:  float64(config.ApiUserWritesPerSecond),
+		PointsPerSecond:  float64(config.ApiUserPointsPerSecond),
+	}, http.RateLimits{
+		QueriesPerSecond: float64(config.ApiDatabaseQueriesPerSecond),
+		WritesPerSecond:  float64(config.ApiDatabaseWritesPerSecond),
+		PointsPerSecond:  float64(config.ApiDatabasePointsPerSecond),
+	}
+}
+
+// Reads the configuration file again and applies the settings that can
+// change while the server is running, i.e. the log levels, the rate
+// limits of the api and the slow query threshold. The other settings
+// need a restart. The raft server isn't touched, so reloading doesn't
+// cause an election.
+func (self *Server) Reload() error {
+	log.Info("Reloading the configuration from %s", self.Config.FileName)
+	config, err := configuration.ParseConfiguration(self.Config.FileName)
+	if err != nil {
+		return err
+	}
+	// the daemon logs everything if the level isn't set
+	level := config.LogLevel
+	if level == "" {
+		level = "debug"
+	}
+	if err := logging.SetLevels(level, config.LogModuleLevels); err != nil {
+		return err
+	}
+	self.HttpApi.SetRateLimits(apiRateLimits(config))
+	self.Coordinator.(*coordinator.CoordinatorImpl).SetSlowQueryThreshold(config.SlowQueryThreshold)
+	log.Info("Reloaded the configuration, log level: %s, slow query threshold: %s", level, config.SlowQueryThreshold)
+	return nil
 }
 
 func (self *Server) ListenAndServe() error {
@@ -99,6 +253,13 @@ func (self *Server) ListenAndServe() error {
 	if err != nil {
 		return err
 	}
+
+	if self.Config.LevelDbVerifyShardsOnStartup {
+		log.Info("Verifying local shards...")
+		self.ClusterConfig.VerifyLocalShards()
+	}
+	self.ClusterConfig.StartShardScrubber(self.Config.LevelDbScrubInterval)
+
 	log.Info("Starting admin interface on port %d", self.Config.AdminHttpPort)
 	go self.AdminServer.ListenAndServe()
 	if self.Config.GraphiteEnabled {
@@ -106,38 +267,162 @@ func (self *Server) ListenAndServe() error {
 			log.Warn("Cannot start graphite server. please check your configuration")
 		} else {
 			log.Info("Starting Graphite Listener on port %d", self.Config.GraphitePort)
+			if self.Config.GraphitePicklePort > 0 {
+				log.Info("Starting Graphite Pickle Listener on port %d", self.Config.GraphitePicklePort)
+			}
 			go self.GraphiteApi.ListenAndServe()
 		}
 	}
+	if self.Config.UdpInputEnabled {
+		if self.Config.UdpInputPort <= 0 || self.Config.UdpInputDatabase == "" {
+			log.Warn("Cannot start udp server. please check your configuration")
+		} else {
+			log.Info("Starting Udp Listener on port %d", self.Config.UdpInputPort)
+			go self.UdpApi.ListenAndServe()
+		}
+	}
+	if self.Config.CollectdEnabled {
+		if self.Config.CollectdPort <= 0 || self.Config.CollectdDatabase == "" {
+			log.Warn("Cannot start collectd server. please check your configuration")
+		} else {
+			log.Info("Starting Collectd Listener on port %d", self.Config.CollectdPort)
+			go self.CollectdApi.ListenAndServe()
+		}
+	}
+	if self.Config.OpenTsdbEnabled {
+		if self.Config.OpenTsdbPort <= 0 || self.Config.OpenTsdbDatabase == "" {
+			log.Warn("Cannot start opentsdb server. please check your configuration")
+		} else {
+			log.Info("Starting OpenTSDB Listener on port %d", self.Config.OpenTsdbPort)
+			go self.OpenTsdbApi.ListenAndServe()
+		}
+	}
+	if self.Config.StatsdEnabled {
+		if self.Config.StatsdPort <= 0 || self.Config.StatsdDatabase == "" {
+			log.Warn("Cannot start statsd server. please check your configuration")
+		} else {
+			log.Info("Starting Statsd Listener on port %d", self.Config.StatsdPort)
+			go self.StatsdApi.ListenAndServe()
+		}
+	}
+	if self.Config.KafkaEnabled {
+		if len(self.Config.KafkaBrokers) == 0 || self.Config.KafkaTopic == "" || self.Config.KafkaDatabase == "" {
+			log.Warn("Cannot start kafka consumer. please check your configuration")
+		} else {
+			log.Info("Starting Kafka consumer for topic %s", self.Config.KafkaTopic)
+			go self.KafkaApi.ListenAndServe()
+		}
+	}
+	if self.Config.MqttEnabled {
+		if self.Config.MqttBroker == "" || len(self.Config.MqttTopics) == 0 || self.Config.MqttDatabase == "" {
+			log.Warn("Cannot start mqtt subscriber. please check your configuration")
+		} else {
+			log.Info("Starting MQTT subscriber for %v on %s", self.Config.MqttTopics, self.Config.MqttBroker)
+			go self.MqttApi.ListenAndServe()
+		}
+	}
+
+	if self.Config.MonitoringEnabled {
+		log.Info("Writing the stats of the server to %s every %s", self.Config.MonitoringDatabase, self.Config.MonitoringInterval)
+		self.Monitor.Start()
+	}
+
+	if self.Config.ReporterEnabled {
+		log.Info("Sending the stats of the server to %s %s every %s", self.Config.ReporterProtocol, self.Config.ReporterAddress, self.Config.ReporterInterval)
+		if err := self.Reporter.Start(); err != nil {
+			return err
+		}
+	}
 
 	// start processing continuous queries
 	self.RaftServer.StartProcessingContinuousQueries()
 
+	// the api listens before systemd is told that the server is ready,
+	// so the server can take requests once it's routed to
+	var listener net.Listener
+	if port := self.Config.ApiHttpPortString(); port != "" {
+		listener, err = net.Listen("tcp", port)
+		if err != nil {
+			return fmt.Errorf("The api cannot listen on %s: %s", port, err)
+		}
+	}
 	log.Info("Starting Http Api server on port %d", self.Config.ApiHttpPort)
-	self.HttpApi.ListenAndServe()
+	self.notifyReady()
+	close(self.ready)
+	self.HttpApi.Serve(listener)
 
 	return nil
 }
 
+// Stops the server within about the shutdown timeout. The api stops
+// taking requests and gets half of the timeout to finish the ones that
+// are being served. The writes that are buffered for the other servers
+// get the rest of the timeout, but at least the other half even if the
+// api took longer. The writes that didn't finish in time are replayed
+// from the wal after the restart.
 func (self *Server) Stop() {
 	if self.stopped {
 		return
 	}
 	log.Info("Stopping server")
 	self.stopped = true
+	sdNotify("STOPPING=1")
+	close(self.watchdogStop)
+	start := time.Now()
+	apiTimeout := self.Config.ShutdownTimeout / 2
 
 	log.Info("Stopping api server")
-	self.HttpApi.Close()
+	self.HttpApi.Shutdown(apiTimeout)
 	log.Info("Api server stopped")
 
-	log.Info("Stopping admin server")
-	self.AdminServer.Close()
-	log.Info("admin server stopped")
+	log.Info("Stopping udp server")
+	self.UdpApi.Close()
+	log.Info("udp server stopped")
+
+	log.Info("Stopping collectd server")
+	self.CollectdApi.Close()
+	log.Info("collectd server stopped")
+
+	log.Info("Stopping opentsdb server")
+	self.OpenTsdbApi.Close()
+	log.Info("opentsdb server stopped")
+
+	log.Info("Stopping statsd server")
+	self.StatsdApi.Close()
+	log.Info("statsd server stopped")
 
-	log.Info("Stopping raft server")
+	log.Info("Stopping kafka consumer")
+	self.KafkaApi.Close()
+	log.Info("kafka consumer stopped")
+
+	log.Info("Stopping mqtt subscriber")
+	self.MqttApi.Close()
+	log.Info("mqtt subscriber stopped")
+
+	// raft can't hand the leadership over, a leader that stops first
+	// lets the other servers elect a new one while the writes drain
+	log.Info("Stopping raft server, leader: %v", self.RaftServer.Leader() == self.RaftServer.GetRaftName())
 	self.RaftServer.Close()
 	log.Info("Raft server stopped")
 
+	log.Info("Waiting for the writes to the other servers")
+	deadline := writesDeadline(start, time.Now(), self.Config.ShutdownTimeout)
+	if !waitForWrites(self.ClusterConfig.HasUncommitedWrites, deadline) {
+		log.Warn("Some writes to the other servers didn't finish, they're replayed from the wal after the restart")
+	}
+
+	log.Info("Stopping monitor")
+	self.Monitor.Close()
+	log.Info("monitor stopped")
+
+	log.Info("Stopping reporter")
+	self.Reporter.Close()
+	log.Info("reporter stopped")
+
+	log.Info("Stopping admin server")
+	self.AdminServer.Close()
+	log.Info("admin server stopped")
+
 	log.Info("Stopping protobuf server")
 	self.ProtobufServer.Close()
 	log.Info("protobuf server stopped")
@@ -150,3 +435,28 @@ func (self *Server) Stop() {
 	self.shardStore.Close()
 	log.Info("shard store stopped")
 }
+
+// Returns the deadline of the writes to the other servers when the
+// shutdown started at start, the writes get the rest of the timeout
+// but at least the half that the api didn't get
+func writesDeadline(start, now time.Time, timeout time.Duration) time.Time {
+	deadline := now.Add(timeout / 2)
+	if end := start.Add(timeout); end.After(deadline) {
+		return end
+	}
+	return deadline
+}
+
+// Waits until pending returns false, i.e. the writes that are buffered
+// for the other servers were written, or the deadline passed. The
+// writes to the servers that are down can't finish. Returns false if
+// there are pending writes after the deadline.
+func waitForWrites(pending func() bool, deadline time.Time) bool {
+	for pending() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
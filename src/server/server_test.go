@@ -0,0 +1,117 @@
+package server
+
+import (
+	"cluster"
+	"common"
+	"coordinator"
+	. "launchpad.net/gocheck"
+	"protocol"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ServerSuite struct{}
+
+var _ = Suite(&ServerSuite{})
+
+func (self *ServerSuite) TestWaitingForTheWritesToDrain(c *C) {
+	calls := 0
+	pending := func() bool {
+		calls++
+		return calls < 3
+	}
+	c.Assert(waitForWrites(pending, time.Now().Add(time.Minute)), Equals, true)
+	c.Assert(calls, Equals, 3)
+}
+
+func (self *ServerSuite) TestWaitingForTheWritesStopsAtTheDeadline(c *C) {
+	start := time.Now()
+	pending := func() bool { return true }
+	c.Assert(waitForWrites(pending, start.Add(200*time.Millisecond)), Equals, false)
+	elapsed := time.Since(start)
+	c.Assert(elapsed >= 200*time.Millisecond, Equals, true)
+	c.Assert(elapsed < time.Second, Equals, true)
+}
+
+func (self *ServerSuite) TestTheWritesGetAtLeastHalfOfTheShutdownTimeout(c *C) {
+	start := time.Now()
+	// the api finished early, the writes get the rest of the timeout
+	c.Assert(writesDeadline(start, start.Add(time.Second), 30*time.Second), Equals, start.Add(30*time.Second))
+	// the api took its half or longer, the writes get the other half
+	c.Assert(writesDeadline(start, start.Add(15*time.Second), 30*time.Second), Equals, start.Add(30*time.Second))
+	c.Assert(writesDeadline(start, start.Add(25*time.Second), 30*time.Second), Equals, start.Add(40*time.Second))
+}
+
+// Records the users of the writes and the queries, the other methods
+// aren't used by the embedded api
+type mockCoordinator struct {
+	coordinator.Coordinator
+	users  []common.User
+	series []*protocol.Series
+}
+
+func (self *mockCoordinator) WriteSeriesData(user common.User, db string, series []*protocol.Series) error {
+	self.users = append(self.users, user)
+	self.series = append(self.series, series...)
+	return nil
+}
+
+func (self *mockCoordinator) RunQuery(user common.User, db, query string, yield coordinator.SeriesWriter) error {
+	self.users = append(self.users, user)
+	for _, series := range self.series {
+		if err := yield.Write(series); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *ServerSuite) TestEmbeddedServerActsAsTheInternalAdmin(c *C) {
+	coord := &mockCoordinator{}
+	server := &Server{Coordinator: coord}
+
+	series := &common.SerializedSeries{
+		Name:    "cpu",
+		Columns: []string{"time", "value"},
+		Points:  [][]interface{}{{1400000000000.0, 1.5}},
+	}
+	c.Assert(server.WritePoints("db1", []*common.SerializedSeries{series}), IsNil)
+	result, err := server.Query("db1", "select * from cpu")
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 1)
+	c.Assert(result[0].Name, Equals, "cpu")
+
+	// the server doesn't pick one of the cluster admins, which one
+	// would be random
+	c.Assert(coord.users, HasLen, 2)
+	for _, user := range coord.users {
+		c.Assert(user, Equals, common.User(cluster.InternalClusterAdmin))
+	}
+}
+
+func (self *ServerSuite) TestChunksOfASeriesAreMerged(c *C) {
+	coord := &mockCoordinator{}
+	for _, value := range []int64{1, 2} {
+		coord.series = append(coord.series, &protocol.Series{
+			Name:   proto.String("cpu"),
+			Fields: []string{"value"},
+			Points: []*protocol.Point{&protocol.Point{
+				Values:         []*protocol.FieldValue{&protocol.FieldValue{Int64Value: proto.Int64(value)}},
+				Timestamp:      proto.Int64(value * 1000),
+				SequenceNumber: proto.Uint64(1),
+			}},
+		})
+	}
+	server := &Server{Coordinator: coord}
+	result, err := server.Query("db1", "select * from cpu")
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 1)
+	c.Assert(result[0].Points, HasLen, 2)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "code.google.com/p/log4go"
+	"github.com/goraft/raft"
+)
+
+// Sends the state to systemd, e.g. READY=1, if the server was started
+// by a unit with Type=notify. Returns false if it wasn't, systemd sets
+// NOTIFY_SOCKET for the units it expects notifications from.
+func sdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// the names of the abstract sockets start with @, net handles them
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Returns how often systemd expects WATCHDOG=1, 0 if the unit doesn't
+// have WatchdogSec or the watchdog is meant for another process
+func sdWatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid WATCHDOG_USEC %s", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// Tells systemd that the server is ready once it joined the cluster,
+// replayed the wal and listens on the api, and starts petting the
+// watchdog
+func (self *Server) notifyReady() {
+	notified, err := sdNotify("READY=1\nSTATUS=Serving the api")
+	if err != nil {
+		log.Warn("Cannot notify systemd that the server is ready: %s", err)
+		return
+	}
+	if !notified {
+		return
+	}
+	log.Info("Notified systemd that the server is ready")
+
+	interval, err := sdWatchdogInterval()
+	if err != nil {
+		log.Warn("Cannot start the systemd watchdog: %s", err)
+		return
+	}
+	if interval > 0 {
+		log.Info("Petting the systemd watchdog every %s", interval/2)
+		go self.petWatchdog(interval / 2)
+	}
+}
+
+// Sends WATCHDOG=1 every interval while the server is alive, systemd
+// restarts the server if it misses the pets
+func (self *Server) petWatchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.watchdogStop:
+			return
+		case <-ticker.C:
+		}
+		if err := self.checkAlive(interval); err != nil {
+			log.Warn("Not petting the systemd watchdog: %s", err)
+			continue
+		}
+		if _, err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Warn("Cannot pet the systemd watchdog: %s", err)
+		}
+	}
+}
+
+// Returns an error if the wal doesn't answer within the timeout or
+// can't append requests, or if raft stopped
+func (self *Server) checkAlive(timeout time.Duration) error {
+	if self.RaftServer.State() == raft.Stopped {
+		return fmt.Errorf("Raft is stopped")
+	}
+	statusChan := make(chan string, 1)
+	go func() {
+		statusChan <- self.writeLog.Status().LastError
+	}()
+	select {
+	case lastError := <-statusChan:
+		if lastError != "" {
+			return fmt.Errorf("The wal cannot append requests: %s", lastError)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("The wal didn't answer in %s", timeout)
+	}
+}
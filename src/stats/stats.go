@@ -0,0 +1,300 @@
+// Counters, rates and latency histograms of the server. They're
+// published with expvar under the influxdb map, so they're served on
+// /debug/vars with the memory stats of the runtime.
+package stats
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var registry = expvar.NewMap("influxdb")
+
+// A stat that can be read as a number or a map of numbers, for the
+// consumers that don't want to parse the expvar json
+type Stat interface {
+	expvar.Var
+	Values() map[string]float64
+}
+
+var (
+	statsLock sync.Mutex
+	allStats  = map[string]Stat{}
+)
+
+func register(name string, stat Stat) {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	if _, ok := allStats[name]; ok {
+		panic(fmt.Sprintf("The stat %s is already registered", name))
+	}
+	allStats[name] = stat
+	registry.Set(name, stat)
+}
+
+// Calls f with the name and values of every stat, sorted by name
+func Do(f func(name string, values map[string]float64)) {
+	statsLock.Lock()
+	names := make([]string, 0, len(allStats))
+	for name := range allStats {
+		names = append(names, name)
+	}
+	statsLock.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		statsLock.Lock()
+		stat := allStats[name]
+		statsLock.Unlock()
+		f(name, stat.Values())
+	}
+}
+
+// A number that only goes up
+type Counter struct {
+	value int64
+}
+
+func NewCounter(name string) *Counter {
+	counter := &Counter{}
+	register(name, counter)
+	return counter
+}
+
+func (self *Counter) Add(delta int64) {
+	atomic.AddInt64(&self.value, delta)
+}
+
+func (self *Counter) Inc() {
+	self.Add(1)
+}
+
+func (self *Counter) Value() int64 {
+	return atomic.LoadInt64(&self.value)
+}
+
+func (self *Counter) String() string {
+	return fmt.Sprintf("%d", self.Value())
+}
+
+func (self *Counter) Values() map[string]float64 {
+	return map[string]float64{"count": float64(self.Value())}
+}
+
+// A number that goes up and down, e.g. the depth of a queue
+type Gauge struct {
+	value int64
+}
+
+func NewGauge(name string) *Gauge {
+	gauge := &Gauge{}
+	register(name, gauge)
+	return gauge
+}
+
+func (self *Gauge) Set(value int64) {
+	atomic.StoreInt64(&self.value, value)
+}
+
+func (self *Gauge) Add(delta int64) {
+	atomic.AddInt64(&self.value, delta)
+}
+
+func (self *Gauge) Value() int64 {
+	return atomic.LoadInt64(&self.value)
+}
+
+func (self *Gauge) String() string {
+	return fmt.Sprintf("%d", self.Value())
+}
+
+func (self *Gauge) Values() map[string]float64 {
+	return map[string]float64{"value": float64(self.Value())}
+}
+
+// Counters by key, e.g. the points written to every database
+type Counters struct {
+	lock   sync.Mutex
+	values map[string]int64
+}
+
+func NewCounters(name string) *Counters {
+	counters := &Counters{values: map[string]int64{}}
+	register(name, counters)
+	return counters
+}
+
+func (self *Counters) Add(key string, delta int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.values[key] += delta
+}
+
+func (self *Counters) Value(key string) int64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.values[key]
+}
+
+func (self *Counters) Values() map[string]float64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	values := make(map[string]float64, len(self.values))
+	for key, value := range self.values {
+		values[key] = float64(value)
+	}
+	return values
+}
+
+func (self *Counters) String() string {
+	return jsonString(self.Values())
+}
+
+// The number of buckets of the meters, the rate is the average of the
+// last minute
+const meterBuckets = 60
+
+// A counter that also knows its rate per second over the last minute
+type Meter struct {
+	lock    sync.Mutex
+	count   int64
+	buckets [meterBuckets]int64
+	// the second of the last mark
+	last int64
+	now  func() time.Time
+}
+
+func NewMeter(name string) *Meter {
+	meter := NewUnregisteredMeter()
+	register(name, meter)
+	return meter
+}
+
+// Returns a meter that isn't published, for the rates of objects that
+// come and go, e.g. the shards
+func NewUnregisteredMeter() *Meter {
+	return &Meter{now: time.Now}
+}
+
+// clears the buckets of the seconds that passed since the last mark,
+// must be called with the lock held
+func (self *Meter) advance(second int64) {
+	if second <= self.last {
+		return
+	}
+	if second-self.last >= meterBuckets {
+		self.buckets = [meterBuckets]int64{}
+	} else {
+		for s := self.last + 1; s <= second; s++ {
+			self.buckets[s%meterBuckets] = 0
+		}
+	}
+	self.last = second
+}
+
+func (self *Meter) Mark(n int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	second := self.now().Unix()
+	self.advance(second)
+	self.count += n
+	self.buckets[second%meterBuckets] += n
+}
+
+func (self *Meter) Count() int64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.count
+}
+
+// Returns the average rate per second over the last minute
+func (self *Meter) Rate() float64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.advance(self.now().Unix())
+	var sum int64
+	for _, n := range self.buckets {
+		sum += n
+	}
+	return float64(sum) / meterBuckets
+}
+
+func (self *Meter) Values() map[string]float64 {
+	return map[string]float64{"count": float64(self.Count()), "rate": self.Rate()}
+}
+
+func (self *Meter) String() string {
+	return jsonString(self.Values())
+}
+
+// The number of latest samples the histograms keep
+const histogramSamples = 1028
+
+// The distribution of the latest durations, in milliseconds
+type Histogram struct {
+	lock    sync.Mutex
+	count   int64
+	samples []float64
+	next    int
+}
+
+func NewHistogram(name string) *Histogram {
+	histogram := &Histogram{samples: make([]float64, 0, histogramSamples)}
+	register(name, histogram)
+	return histogram
+}
+
+func (self *Histogram) Observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.count++
+	if len(self.samples) < histogramSamples {
+		self.samples = append(self.samples, ms)
+		return
+	}
+	self.samples[self.next] = ms
+	self.next = (self.next + 1) % histogramSamples
+}
+
+// Records the time since start
+func (self *Histogram) Since(start time.Time) {
+	self.Observe(time.Since(start))
+}
+
+// Returns the count and the 50th, 90th, 99th percentiles and the
+// maximum of the latest samples
+func (self *Histogram) Values() map[string]float64 {
+	self.lock.Lock()
+	count := self.count
+	samples := make([]float64, len(self.samples))
+	copy(samples, self.samples)
+	self.lock.Unlock()
+
+	values := map[string]float64{"count": float64(count)}
+	if len(samples) == 0 {
+		return values
+	}
+	sort.Float64s(samples)
+	for name, p := range map[string]float64{"p50": 0.5, "p90": 0.9, "p99": 0.99} {
+		values[name] = samples[int(p*float64(len(samples)-1))]
+	}
+	values["max"] = samples[len(samples)-1]
+	return values
+}
+
+func (self *Histogram) String() string {
+	return jsonString(self.Values())
+}
+
+func jsonString(values map[string]float64) string {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
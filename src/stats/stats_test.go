@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type StatsSuite struct{}
+
+var _ = Suite(&StatsSuite{})
+
+func (self *StatsSuite) TestCounter(c *C) {
+	counter := NewCounter("testCounter")
+	counter.Inc()
+	counter.Add(2)
+	c.Assert(counter.Value(), Equals, int64(3))
+	c.Assert(registry.Get("testCounter").String(), Equals, "3")
+}
+
+func (self *StatsSuite) TestCounters(c *C) {
+	counters := NewCounters("testCounters")
+	counters.Add("db1", 2)
+	counters.Add("db1", 3)
+	counters.Add("db2", 1)
+	c.Assert(counters.Value("db1"), Equals, int64(5))
+	c.Assert(counters.Values(), DeepEquals, map[string]float64{"db1": 5, "db2": 1})
+}
+
+func (self *StatsSuite) TestRegisteringTwicePanics(c *C) {
+	NewGauge("testGauge")
+	c.Assert(func() { NewGauge("testGauge") }, PanicMatches, ".*already registered")
+}
+
+func (self *StatsSuite) TestMeterRate(c *C) {
+	now := time.Unix(1000, 0)
+	meter := NewMeter("testMeter")
+	meter.now = func() time.Time { return now }
+
+	meter.Mark(30)
+	now = now.Add(time.Second)
+	meter.Mark(30)
+	c.Assert(meter.Count(), Equals, int64(60))
+	c.Assert(meter.Rate(), Equals, 1.0)
+
+	// the marks older than a minute don't count
+	now = now.Add(59 * time.Second)
+	c.Assert(meter.Rate(), Equals, 0.5)
+	now = now.Add(time.Minute)
+	c.Assert(meter.Rate(), Equals, 0.0)
+	c.Assert(meter.Count(), Equals, int64(60))
+}
+
+func (self *StatsSuite) TestHistogramPercentiles(c *C) {
+	histogram := NewHistogram("testHistogram")
+	c.Assert(histogram.Values(), DeepEquals, map[string]float64{"count": 0})
+
+	for i := 1; i <= 100; i++ {
+		histogram.Observe(time.Duration(i) * time.Millisecond)
+	}
+	values := histogram.Values()
+	c.Assert(values["count"], Equals, 100.0)
+	c.Assert(values["p50"], Equals, 50.0)
+	c.Assert(values["p90"], Equals, 90.0)
+	c.Assert(values["p99"], Equals, 99.0)
+	c.Assert(values["max"], Equals, 100.0)
+
+	decoded := map[string]float64{}
+	c.Assert(json.Unmarshal([]byte(histogram.String()), &decoded), IsNil)
+	c.Assert(decoded, DeepEquals, values)
+}
+
+func (self *StatsSuite) TestHistogramKeepsTheLatestSamples(c *C) {
+	histogram := NewHistogram("testHistogramSamples")
+	for i := 0; i < histogramSamples; i++ {
+		histogram.Observe(time.Second)
+	}
+	for i := 0; i < histogramSamples; i++ {
+		histogram.Observe(time.Millisecond)
+	}
+	values := histogram.Values()
+	c.Assert(values["count"], Equals, float64(2*histogramSamples))
+	c.Assert(values["max"], Equals, 1.0)
+}
@@ -0,0 +1,137 @@
+package main
+
+// Imports a dump, e.g. the result of a query with format=ndjson, into a
+// database with /db/:db/import and prints the progress of the import.
+// With -format csv it imports a csv file, every row is a point of the
+// series given by -name or -name-column and the types of the columns
+// can be given with -types, the other columns get the type of their
+// values.
+//
+//   import-dump -host localhost:8086 -db mydb -u root -p root -file dump.ndjson
+//   import-dump -db mydb -format csv -file export.csv -name cpu -time-column date -time-format "2006-01-02 15:04:05" -types load:float,host:string
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+type importProgress struct {
+	Batch     int    `json:"batch"`
+	FirstLine int    `json:"firstLine"`
+	LastLine  int    `json:"lastLine"`
+	Points    int    `json:"points"`
+	Status    int    `json:"status"`
+	Error     string `json:"error"`
+	Done      bool   `json:"done"`
+	Failed    int    `json:"failedBatches"`
+}
+
+func main() {
+	host := flag.String("host", "localhost:8086", "the address of the http api")
+	ssl := flag.Bool("ssl", false, "use https")
+	db := flag.String("db", "", "the database the dump is imported into")
+	username := flag.String("u", "root", "the user name")
+	password := flag.String("p", "root", "the password")
+	fileName := flag.String("file", "-", "the dump file, - reads the dump from stdin")
+	batchSize := flag.Int("batch-size", 5000, "the number of points written at once")
+	precision := flag.String("time-precision", "m", "the precision of the timestamps of the dump, s, m or u")
+	quiet := flag.Bool("quiet", false, "only print the failed batches and the summary")
+	format := flag.String("format", "ndjson", "the format of the file, ndjson or csv")
+	name := flag.String("name", "", "csv: the series the rows are written to")
+	nameColumn := flag.String("name-column", "", "csv: the column with the series of every row")
+	timeColumn := flag.String("time-column", "", "csv: the column with the time of the rows, time if it's empty, the time of the server if there's none")
+	timeFormat := flag.String("time-format", "epoch", "csv: epoch in the time precision, rfc3339 or a go time layout")
+	types := flag.String("types", "", "csv: the types of the columns, e.g. value:float,host:string,comment:skip")
+	columns := flag.String("columns", "", "csv: the names of the columns separated by commas if the file doesn't have a header")
+	delimiter := flag.String("delimiter", "", "csv: the delimiter of the fields, tab or a character, a comma if it's empty")
+	flag.Parse()
+
+	if *db == "" {
+		fmt.Fprintln(os.Stderr, "-db must be set")
+		os.Exit(2)
+	}
+
+	var in io.Reader = os.Stdin
+	if *fileName != "-" {
+		f, err := os.Open(*fileName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	scheme := "http"
+	if *ssl {
+		scheme = "https"
+	}
+	params := url.Values{}
+	params.Set("u", *username)
+	params.Set("p", *password)
+	params.Set("batch_size", strconv.Itoa(*batchSize))
+	params.Set("time_precision", *precision)
+	contentType := "application/x-ndjson"
+	if *format == "csv" {
+		contentType = "text/csv"
+		params.Set("format", "csv")
+		for key, value := range map[string]string{
+			"name":        *name,
+			"name_column": *nameColumn,
+			"time_column": *timeColumn,
+			"time_format": *timeFormat,
+			"types":       *types,
+			"columns":     *columns,
+			"delimiter":   *delimiter,
+		} {
+			if value != "" {
+				params.Set(key, value)
+			}
+		}
+	} else if *format != "ndjson" {
+		fmt.Fprintf(os.Stderr, "Unknown format %s\n", *format)
+		os.Exit(2)
+	}
+	addr := fmt.Sprintf("%s://%s/db/%s/import?%s", scheme, *host, url.QueryEscape(*db), params.Encode())
+
+	// the body is sent with chunked encoding as it's read from the file
+	resp, err := http.Post(addr, contentType, ioutil.NopCloser(in))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "%s: %s\n", resp.Status, body)
+		os.Exit(1)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		progress := &importProgress{}
+		if err := decoder.Decode(progress); err != nil {
+			fmt.Fprintf(os.Stderr, "The import didn't finish: %s\n", err)
+			os.Exit(1)
+		}
+		switch {
+		case progress.Done:
+			fmt.Printf("Imported %d points, %d batches failed\n", progress.Points, progress.Failed)
+			if progress.Failed > 0 {
+				os.Exit(1)
+			}
+			return
+		case progress.Error != "":
+			fmt.Printf("Lines %d-%d failed (%d): %s\n", progress.FirstLine, progress.LastLine, progress.Status, progress.Error)
+		case !*quiet:
+			fmt.Printf("Batch %d: wrote %d points from lines %d-%d\n", progress.Batch, progress.Points, progress.FirstLine, progress.LastLine)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+// influx is an interactive shell for the http api. Queries can span
+// several lines, they end with a semicolon. The commands start with a
+// backslash, \help lists them.
+//
+//   influx -host localhost:8086 -u root -p root -db mydb
+//   influx -db mydb -format csv -execute 'select * from cpu limit 10'
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+const HISTORY_FILE = ".influx_history"
+
+type series struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Points  [][]interface{} `json:"points"`
+}
+
+type shell struct {
+	host     string
+	scheme   string
+	username string
+	password string
+	db       string
+	format   string
+	out      io.Writer
+	history  *os.File
+}
+
+func main() {
+	host := flag.String("host", "localhost:8086", "the address of the http api")
+	ssl := flag.Bool("ssl", false, "use https")
+	username := flag.String("u", "root", "the user name")
+	password := flag.String("p", "root", "the password")
+	db := flag.String("db", "", "the database the queries run on, \\use changes it")
+	format := flag.String("format", "column", "the format of the results, column, csv or json")
+	execute := flag.String("execute", "", "run the query, print the results and exit")
+	flag.Parse()
+
+	self := &shell{
+		host:     *host,
+		scheme:   "http",
+		username: *username,
+		password: *password,
+		db:       *db,
+		out:      os.Stdout,
+	}
+	if *ssl {
+		self.scheme = "https"
+	}
+	if err := self.setFormat(*format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *execute != "" {
+		if err := self.query(*execute); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	self.openHistory()
+	fmt.Fprintf(self.out, "Connected to %s://%s, \\help lists the commands\n", self.scheme, self.host)
+	self.run(os.Stdin)
+}
+
+// Appends the lines to the history file in the home directory, the
+// shell works without it
+func (self *shell) openHistory() {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return
+	}
+	history, err := os.OpenFile(filepath.Join(home, HISTORY_FILE), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open the history: %s\n", err)
+		return
+	}
+	self.history = history
+}
+
+func (self *shell) prompt(continuation bool) {
+	switch {
+	case continuation:
+		fmt.Fprint(self.out, "... ")
+	case self.db != "":
+		fmt.Fprintf(self.out, "%s> ", self.db)
+	default:
+		fmt.Fprint(self.out, "> ")
+	}
+}
+
+// Reads the commands and the queries from in until it's closed or
+// \quit. The lines of a query are joined until one ends with a
+// semicolon.
+func (self *shell) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	lines := []string{}
+	self.prompt(false)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(lines) == 0 && strings.HasPrefix(line, "\\") {
+			if self.history != nil {
+				fmt.Fprintln(self.history, line)
+			}
+			if quit := self.command(line); quit {
+				return
+			}
+			self.prompt(false)
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		if len(lines) == 0 || !strings.HasSuffix(line, ";") {
+			self.prompt(len(lines) > 0)
+			continue
+		}
+
+		query := strings.Join(lines, " ")
+		lines = lines[:0]
+		if self.history != nil {
+			fmt.Fprintln(self.history, query)
+		}
+		if err := self.query(strings.TrimSuffix(query, ";")); err != nil {
+			fmt.Fprintf(self.out, "ERROR: %s\n", err)
+		}
+		self.prompt(false)
+	}
+	fmt.Fprintln(self.out)
+}
+
+// Runs the command and returns true if the shell should exit
+func (self *shell) command(line string) bool {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "\\q", "\\quit":
+		return true
+	case "\\use":
+		if len(fields) != 2 {
+			fmt.Fprintln(self.out, "Usage: \\use <database>")
+			break
+		}
+		self.db = fields[1]
+	case "\\format":
+		if len(fields) != 2 {
+			fmt.Fprintf(self.out, "The format is %s\n", self.format)
+			break
+		}
+		if err := self.setFormat(fields[1]); err != nil {
+			fmt.Fprintln(self.out, err)
+		}
+	case "\\dbs":
+		self.listDatabases()
+	case "\\history":
+		self.printHistory()
+	case "\\help":
+		fmt.Fprint(self.out, `\use <database>   run the queries on the database
+\format <format>  print the results as column, csv or json
+\dbs              list the databases
+\history          print the history
+\quit             exit, \q for short
+The queries can span several lines and end with a semicolon.
+`)
+	default:
+		fmt.Fprintf(self.out, "Unknown command %s, \\help lists the commands\n", fields[0])
+	}
+	return false
+}
+
+func (self *shell) setFormat(format string) error {
+	switch format {
+	case "column", "csv", "json":
+		self.format = format
+		return nil
+	}
+	return fmt.Errorf("Unknown format %s, it should be column, csv or json", format)
+}
+
+func (self *shell) printHistory() {
+	if self.history == nil {
+		fmt.Fprintln(self.out, "The history isn't available")
+		return
+	}
+	if _, err := self.history.Seek(0, os.SEEK_SET); err != nil {
+		fmt.Fprintln(self.out, err)
+		return
+	}
+	io.Copy(self.out, self.history)
+	self.history.Seek(0, os.SEEK_END)
+}
+
+func (self *shell) url(path string, params url.Values) string {
+	params.Set("u", self.username)
+	params.Set("p", self.password)
+	return fmt.Sprintf("%s://%s%s?%s", self.scheme, self.host, path, params.Encode())
+}
+
+// Sends the request and decodes the json response into result
+func (self *shell) get(path string, params url.Values, result interface{}) error {
+	resp, err := http.Get(self.url(path, params))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// keep the numbers as they were sent
+	decoder.UseNumber()
+	return decoder.Decode(result)
+}
+
+func (self *shell) listDatabases() {
+	databases := []map[string]interface{}{}
+	if err := self.get("/db", url.Values{}, &databases); err != nil {
+		fmt.Fprintf(self.out, "ERROR: %s\n", err)
+		return
+	}
+	for _, db := range databases {
+		fmt.Fprintln(self.out, db["name"])
+	}
+}
+
+func (self *shell) query(query string) error {
+	if self.db == "" {
+		return fmt.Errorf("No database selected, \\use <database> selects one")
+	}
+	params := url.Values{}
+	params.Set("q", query)
+	result := []*series{}
+	if err := self.get("/db/"+url.QueryEscape(self.db)+"/series", params, &result); err != nil {
+		return err
+	}
+	switch self.format {
+	case "json":
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(self.out, string(data))
+	case "csv":
+		writeCsv(self.out, result)
+	default:
+		writeColumns(self.out, result)
+	}
+	return nil
+}
+
+func formatValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// Prints every series as a table with its name above it
+func writeColumns(out io.Writer, result []*series) {
+	for i, s := range result {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintln(out, s.Name)
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(s.Columns, "\t"))
+		dashes := make([]string, len(s.Columns))
+		for j, column := range s.Columns {
+			dashes[j] = strings.Repeat("-", len(column))
+		}
+		fmt.Fprintln(w, strings.Join(dashes, "\t"))
+		for _, point := range s.Points {
+			values := make([]string, len(point))
+			for j, value := range point {
+				values[j] = formatValue(value)
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t"))
+		}
+		w.Flush()
+	}
+	if len(result) == 0 {
+		fmt.Fprintln(out, "No results")
+	}
+}
+
+// Prints the points of all the series with the name of their series as
+// the first column, a header precedes the points of every series
+func writeCsv(out io.Writer, result []*series) {
+	w := csv.NewWriter(out)
+	for _, s := range result {
+		w.Write(append([]string{"name"}, s.Columns...))
+		for _, point := range s.Points {
+			record := []string{s.Name}
+			for _, value := range point {
+				record = append(record, formatValue(value))
+			}
+			w.Write(record)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,235 @@
+package main
+
+// influx_stress runs a workload of writes and queries against a server
+// for a while and reports the throughput and the percentiles of the
+// latency of every kind of request, so releases can be compared with
+// the same workload.
+//
+//   influx_stress -host localhost:8086 -db stress -series 10000 -batch-size 500 -concurrency 20 -duration 1m
+//   influx_stress -query-ratio 0.2 -queries 'select count(value) from /.*/ where time > now() - 1m'
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type series struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Points  [][]interface{} `json:"points"`
+}
+
+type stress struct {
+	baseUrl    string
+	params     url.Values
+	db         string
+	series     int
+	batchSize  int
+	queryRatio float64
+	queries    []string
+	client     *http.Client
+	nextSeries int
+	seriesLock sync.Mutex
+}
+
+func main() {
+	host := flag.String("host", "localhost:8086", "the address of the http api")
+	ssl := flag.Bool("ssl", false, "use https")
+	username := flag.String("u", "root", "the user name, it has to be a cluster admin to create the database")
+	password := flag.String("p", "root", "the password")
+	db := flag.String("db", "stress", "the database the workload runs on, it's created if it doesn't exist")
+	seriesCount := flag.Int("series", 1000, "the number of series that are written")
+	batchSize := flag.Int("batch-size", 1000, "the number of points of every write")
+	concurrency := flag.Int("concurrency", 10, "the number of clients that send requests at the same time")
+	duration := flag.Duration("duration", time.Minute, "how long the workload runs")
+	queryRatio := flag.Float64("query-ratio", 0, "the fraction of the requests that are queries, between 0 and 1")
+	queries := flag.String("queries", "select count(value) from /stress\\..*/ where time > now() - 1m",
+		"the queries that are run, separated by semicolons, every query is picked at random")
+	flag.Parse()
+
+	if *seriesCount <= 0 || *batchSize <= 0 || *concurrency <= 0 {
+		fmt.Fprintln(os.Stderr, "-series, -batch-size and -concurrency must be positive")
+		os.Exit(2)
+	}
+	if *queryRatio < 0 || *queryRatio > 1 {
+		fmt.Fprintln(os.Stderr, "-query-ratio must be between 0 and 1")
+		os.Exit(2)
+	}
+
+	scheme := "http"
+	if *ssl {
+		scheme = "https"
+	}
+	params := url.Values{}
+	params.Set("u", *username)
+	params.Set("p", *password)
+	self := &stress{
+		baseUrl:    fmt.Sprintf("%s://%s", scheme, *host),
+		params:     params,
+		db:         *db,
+		series:     *seriesCount,
+		batchSize:  *batchSize,
+		queryRatio: *queryRatio,
+		// every client keeps its connection open between the requests
+		client: &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}},
+	}
+	for _, query := range strings.Split(*queries, ";") {
+		if query = strings.TrimSpace(query); query != "" {
+			self.queries = append(self.queries, query)
+		}
+	}
+	if self.queryRatio > 0 && len(self.queries) == 0 {
+		fmt.Fprintln(os.Stderr, "-queries must be set if -query-ratio isn't 0")
+		os.Exit(2)
+	}
+
+	if err := self.createDatabase(); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create %s: %s\n", self.db, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Running %d clients for %s, %d series, %d points per write, %.0f%% queries\n",
+		*concurrency, *duration, self.series, self.batchSize, 100*self.queryRatio)
+	results := make(chan *result, *concurrency)
+	deadline := time.Now().Add(*duration)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			self.run(rand.New(rand.NewSource(seed)), deadline, results)
+		}(time.Now().UnixNano() + int64(i))
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	start := time.Now()
+	writes := newStats("writes")
+	reads := newStats("queries")
+	for r := range results {
+		if r.query {
+			reads.add(r)
+		} else {
+			writes.add(r)
+		}
+	}
+	elapsed := time.Since(start)
+
+	writes.report(os.Stdout, elapsed)
+	reads.report(os.Stdout, elapsed)
+	if writes.errors > 0 || reads.errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// The database is created unless it exists
+func (self *stress) createDatabase() error {
+	data, _ := json.Marshal(map[string]string{"name": self.db})
+	status, body, err := self.request("POST", "/db", nil, data)
+	if err != nil {
+		return err
+	}
+	if status/100 != 2 && !strings.Contains(body, "exists") {
+		return fmt.Errorf("%d: %s", status, body)
+	}
+	return nil
+}
+
+// Sends requests until the deadline
+func (self *stress) run(random *rand.Rand, deadline time.Time, results chan<- *result) {
+	for time.Now().Before(deadline) {
+		if self.queryRatio > 0 && random.Float64() < self.queryRatio {
+			results <- self.query(self.queries[random.Intn(len(self.queries))])
+			continue
+		}
+		results <- self.write(random)
+	}
+}
+
+// Returns the points of the next batch, the series are written in turn
+// so every one of them gets points
+func (self *stress) batch(random *rand.Rand) []*series {
+	self.seriesLock.Lock()
+	first := self.nextSeries
+	self.nextSeries = (self.nextSeries + self.batchSize) % self.series
+	self.seriesLock.Unlock()
+
+	bySeries := map[int]*series{}
+	batch := []*series{}
+	for i := 0; i < self.batchSize; i++ {
+		n := (first + i) % self.series
+		s := bySeries[n]
+		if s == nil {
+			s = &series{Name: fmt.Sprintf("stress.series_%d", n), Columns: []string{"value"}}
+			bySeries[n] = s
+			batch = append(batch, s)
+		}
+		s.Points = append(s.Points, []interface{}{random.Float64()})
+	}
+	return batch
+}
+
+func (self *stress) write(random *rand.Rand) *result {
+	data, err := json.Marshal(self.batch(random))
+	if err != nil {
+		return &result{err: err}
+	}
+	start := time.Now()
+	status, body, err := self.request("POST", "/db/"+url.QueryEscape(self.db)+"/series", nil, data)
+	r := &result{latency: time.Since(start), points: self.batchSize, err: err}
+	if err == nil && status/100 != 2 {
+		r.err = fmt.Errorf("%d: %s", status, body)
+	}
+	return r
+}
+
+func (self *stress) query(query string) *result {
+	params := url.Values{}
+	params.Set("q", query)
+	start := time.Now()
+	status, body, err := self.request("GET", "/db/"+url.QueryEscape(self.db)+"/series", params, nil)
+	r := &result{query: true, latency: time.Since(start), err: err}
+	if err == nil && status/100 != 2 {
+		r.err = fmt.Errorf("%d: %s", status, body)
+	}
+	return r
+}
+
+// Returns the status and the body of the response, the body is read
+// completely so the latency includes it
+func (self *stress) request(method, path string, params url.Values, data []byte) (int, string, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	for key, values := range self.params {
+		params[key] = values
+	}
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, self.baseUrl+path+"?"+params.Encode(), body)
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(respBody)), err
+}
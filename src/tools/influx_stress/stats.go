@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// the errors that are printed for every kind of request, the rest
+// are only counted
+const MAX_PRINTED_ERRORS = 5
+
+type result struct {
+	query   bool
+	latency time.Duration
+	points  int
+	err     error
+}
+
+type durations []time.Duration
+
+func (self durations) Len() int           { return len(self) }
+func (self durations) Less(i, j int) bool { return self[i] < self[j] }
+func (self durations) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+// The latencies and the errors of one kind of request
+type stats struct {
+	name      string
+	latencies durations
+	points    int64
+	errors    int
+	messages  []string
+}
+
+func newStats(name string) *stats {
+	return &stats{name: name}
+}
+
+func (self *stats) add(r *result) {
+	if r.err != nil {
+		self.errors++
+		if len(self.messages) < MAX_PRINTED_ERRORS {
+			self.messages = append(self.messages, r.err.Error())
+		}
+		return
+	}
+	self.latencies = append(self.latencies, r.latency)
+	self.points += int64(r.points)
+}
+
+// Returns the latency that p percent of the requests didn't exceed,
+// the latencies have to be sorted
+func (self *stats) percentile(p float64) time.Duration {
+	if len(self.latencies) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(self.latencies)))
+	if i >= len(self.latencies) {
+		i = len(self.latencies) - 1
+	}
+	return self.latencies[i]
+}
+
+func (self *stats) report(out io.Writer, elapsed time.Duration) {
+	requests := len(self.latencies) + self.errors
+	if requests == 0 {
+		return
+	}
+	sort.Sort(self.latencies)
+	seconds := elapsed.Seconds()
+	fmt.Fprintf(out, "%s: %d requests, %d errors, %.1f requests/s", self.name, requests, self.errors, float64(len(self.latencies))/seconds)
+	if self.points > 0 {
+		fmt.Fprintf(out, ", %.1f points/s", float64(self.points)/seconds)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "  latency p50 %s, p90 %s, p95 %s, p99 %s, max %s\n",
+		self.percentile(50), self.percentile(90), self.percentile(95), self.percentile(99), self.percentile(100))
+	for _, message := range self.messages {
+		fmt.Fprintf(out, "  error: %s\n", message)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+// Imports the data of OpenTSDB into a database, either from the output
+// of tsdb scan --import, which has a line for every point:
+//
+//   <metric> <timestamp> <value> <tagk1=tagv1 ...>
+//
+// or by querying the http api of OpenTSDB for every metric one window
+// of time at a time. The tags are stored in columns named after their
+// keys like the opentsdb input plugin does. The progress is saved to
+// the checkpoint file after every write, so an import that was
+// interrupted continues where it stopped when it runs again with the
+// same checkpoint.
+//
+//   opentsdb-import -db mydb -file export.txt -checkpoint export.checkpoint
+//   opentsdb-import -db mydb -opentsdb http://tsdb:4242 -start 2014-01-01 -end 2014-03-01 -checkpoint tsdb.checkpoint
+
+import (
+	"api/opentsdb"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type series struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Points  [][]interface{} `json:"points"`
+}
+
+// The progress of an import, the lines of the export that were
+// written or the end of the last window of every metric
+type checkpoint struct {
+	Line    int              `json:"line,omitempty"`
+	Metrics map[string]int64 `json:"metrics,omitempty"`
+}
+
+type importer struct {
+	writeUrl       string
+	batchSize      int
+	checkpointFile string
+	checkpoint     *checkpoint
+	batch          map[string]*series
+	points         int
+	written        int
+}
+
+func main() {
+	host := flag.String("host", "localhost:8086", "the address of the http api")
+	ssl := flag.Bool("ssl", false, "use https")
+	db := flag.String("db", "", "the database the points are written to")
+	username := flag.String("u", "root", "the user name")
+	password := flag.String("p", "root", "the password")
+	batchSize := flag.Int("batch-size", 5000, "the number of points of the export written at once, the points of a window of the http api are written at once")
+	checkpointFile := flag.String("checkpoint", "", "the file the progress is saved to and resumed from")
+	fileName := flag.String("file", "", "the output of tsdb scan --import, - reads it from stdin")
+	tsdbUrl := flag.String("opentsdb", "", "the url of the http api of OpenTSDB, e.g. http://localhost:4242")
+	metrics := flag.String("metrics", "", "the metrics to import from the http api separated by commas, all of them if it's empty")
+	start := flag.String("start", "", "the start of the import from the http api, a date like 2014-01-31 or a unix timestamp")
+	end := flag.String("end", "", "the end of the import from the http api, now if it's empty")
+	window := flag.Duration("window", time.Hour, "the time that is queried at once from the http api")
+	aggregator := flag.String("aggregator", "none", "the aggregator of the queries, none returns the points as they were written")
+	flag.Parse()
+
+	if *db == "" || (*fileName == "") == (*tsdbUrl == "") {
+		fmt.Fprintln(os.Stderr, "-db and either -file or -opentsdb must be set")
+		os.Exit(2)
+	}
+
+	scheme := "http"
+	if *ssl {
+		scheme = "https"
+	}
+	params := url.Values{}
+	params.Set("u", *username)
+	params.Set("p", *password)
+	params.Set("time_precision", "ms")
+	self := &importer{
+		writeUrl:       fmt.Sprintf("%s://%s/db/%s/series?%s", scheme, *host, url.QueryEscape(*db), params.Encode()),
+		batchSize:      *batchSize,
+		checkpointFile: *checkpointFile,
+		checkpoint:     &checkpoint{Metrics: map[string]int64{}},
+		batch:          map[string]*series{},
+	}
+	if err := self.loadCheckpoint(); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot load the checkpoint: %s\n", err)
+		os.Exit(1)
+	}
+
+	var err error
+	if *fileName != "" {
+		err = self.importExport(*fileName)
+	} else {
+		scan := &scanner{url: strings.TrimRight(*tsdbUrl, "/"), aggregator: *aggregator, window: *window}
+		err = scan.parseRange(*start, *end)
+		if err == nil && *metrics != "" {
+			scan.metrics = strings.Split(*metrics, ",")
+		}
+		if err == nil {
+			err = scan.run(self)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if self.checkpointFile != "" {
+			fmt.Fprintln(os.Stderr, "Run the import again with the same checkpoint to continue it")
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d points\n", self.written)
+}
+
+func (self *importer) loadCheckpoint() error {
+	if self.checkpointFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(self.checkpointFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, self.checkpoint); err != nil {
+		return err
+	}
+	if self.checkpoint.Metrics == nil {
+		self.checkpoint.Metrics = map[string]int64{}
+	}
+	return nil
+}
+
+func (self *importer) saveCheckpoint() error {
+	if self.checkpointFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(self.checkpoint)
+	if err != nil {
+		return err
+	}
+	// the checkpoint is replaced at once so it's never half written
+	tmp := self.checkpointFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, self.checkpointFile)
+}
+
+// Imports the lines of the export after the line of the checkpoint
+func (self *importer) importExport(fileName string) error {
+	var in io.Reader = os.Stdin
+	if fileName != "-" {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if self.checkpoint.Line > 0 {
+		fmt.Printf("Resuming after line %d\n", self.checkpoint.Line)
+	}
+
+	reader := bufio.NewScanner(in)
+	line := 0
+	for reader.Scan() {
+		line++
+		if line <= self.checkpoint.Line {
+			continue
+		}
+		text := strings.TrimSpace(reader.Text())
+		if text == "" {
+			continue
+		}
+		point, err := opentsdb.ParsePutCommand("put " + text)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		if err := self.add(point); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		if self.points >= self.batchSize {
+			self.checkpoint.Line = line
+			if err := self.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return err
+	}
+	self.checkpoint.Line = line
+	return self.flush()
+}
+
+// Adds the point to the batch, the points of a metric with the same tag
+// keys go in the same series
+func (self *importer) add(point *opentsdb.Point) error {
+	keys := make([]string, 0, len(point.Tags))
+	for key := range point.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	value, err := point.Value.Float64()
+	if err != nil {
+		return fmt.Errorf("Invalid value '%s'", point.Value)
+	}
+	timestamp := point.Timestamp
+	if timestamp <= opentsdb.MAX_SECONDS_TIMESTAMP {
+		timestamp *= 1000
+	}
+	values := []interface{}{timestamp}
+	for _, key := range keys {
+		values = append(values, point.Tags[key])
+	}
+	if i, err := point.Value.Int64(); err == nil {
+		values = append(values, i)
+	} else {
+		values = append(values, value)
+	}
+
+	key := point.Metric + "\x00" + strings.Join(keys, "\x00")
+	s := self.batch[key]
+	if s == nil {
+		s = &series{Name: point.Metric, Columns: append(append([]string{"time"}, keys...), "value")}
+		self.batch[key] = s
+	}
+	s.Points = append(s.Points, values)
+	self.points++
+	return nil
+}
+
+// Writes the batch and saves the checkpoint
+func (self *importer) flush() error {
+	if self.points > 0 {
+		batch := make([]*series, 0, len(self.batch))
+		for _, s := range self.batch {
+			batch = append(batch, s)
+		}
+		data, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+		resp, err := http.Post(self.writeUrl, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s: %s", resp.Status, body)
+		}
+		self.written += self.points
+		fmt.Printf("Wrote %d points\n", self.written)
+		self.batch = map[string]*series{}
+		self.points = 0
+	}
+	return self.saveCheckpoint()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"api/opentsdb"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The time series that /api/query returns, the keys of the points are
+// their timestamps
+type queryResult struct {
+	Metric string                 `json:"metric"`
+	Tags   map[string]string      `json:"tags"`
+	Points map[string]json.Number `json:"dps"`
+}
+
+// Queries the metrics of OpenTSDB one window at a time
+type scanner struct {
+	url        string
+	aggregator string
+	window     time.Duration
+	metrics    []string
+	start      int64
+	end        int64
+}
+
+// Parses a date like 2014-01-31 or a unix timestamp in seconds
+func parseTime(s string) (int64, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Unix(), nil
+	}
+	timestamp, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time '%s', it should be a date like 2014-01-31 or a unix timestamp", s)
+	}
+	return timestamp, nil
+}
+
+func (self *scanner) parseRange(start, end string) error {
+	if start == "" {
+		return fmt.Errorf("-start must be set to import from the http api")
+	}
+	var err error
+	if self.start, err = parseTime(start); err != nil {
+		return err
+	}
+	self.end = time.Now().Unix()
+	if end != "" {
+		if self.end, err = parseTime(end); err != nil {
+			return err
+		}
+	}
+	if self.end <= self.start {
+		return fmt.Errorf("The end of the import has to be after its start")
+	}
+	if self.window < time.Second {
+		return fmt.Errorf("The window has to be at least a second")
+	}
+	return nil
+}
+
+func (self *scanner) get(path string, params url.Values, result interface{}) error {
+	resp, err := http.Get(self.url + path + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OpenTSDB returned %s: %s", resp.Status, body)
+	}
+	return json.Unmarshal(body, result)
+}
+
+// Imports the windows of every metric after the end of its checkpoint
+func (self *scanner) run(importer *importer) error {
+	if len(self.metrics) == 0 {
+		params := url.Values{}
+		params.Set("type", "metrics")
+		params.Set("max", "1000000")
+		if err := self.get("/api/suggest", params, &self.metrics); err != nil {
+			return fmt.Errorf("Cannot list the metrics: %s", err)
+		}
+		sort.Strings(self.metrics)
+	}
+
+	window := int64(self.window / time.Second)
+	for _, metric := range self.metrics {
+		start := self.start
+		if done := importer.checkpoint.Metrics[metric]; done > start {
+			start = done
+		}
+		if start >= self.end {
+			continue
+		}
+		fmt.Printf("Importing %s from %s\n", metric, time.Unix(start, 0).UTC().Format(time.RFC3339))
+		for ; start < self.end; start += window {
+			end := start + window
+			if end > self.end {
+				end = self.end
+			}
+			if err := self.importWindow(importer, metric, start, end); err != nil {
+				return fmt.Errorf("%s: %s", metric, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Imports the points of the metric from start up to end, end excluded.
+// The points of a window are written at once, so the windows of the
+// checkpoint are never written twice.
+func (self *scanner) importWindow(importer *importer, metric string, start, end int64) error {
+	params := url.Values{}
+	params.Set("start", strconv.FormatInt(start, 10))
+	// the end of a query is included
+	params.Set("end", strconv.FormatInt(end-1, 10))
+	params.Set("m", self.aggregator+":"+metric)
+	results := []*queryResult{}
+	if err := self.get("/api/query", params, &results); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		for timestamp, value := range result.Points {
+			t, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Invalid timestamp '%s'", timestamp)
+			}
+			point := &opentsdb.Point{Metric: result.Metric, Timestamp: t, Value: value, Tags: result.Tags}
+			if err := importer.add(point); err != nil {
+				return err
+			}
+		}
+	}
+	importer.checkpoint.Metrics[metric] = end
+	return importer.flush()
+}
@@ -0,0 +1,205 @@
+package main
+
+// Tails the wal of a server and writes the points of its writes to
+// another InfluxDB, e.g. a staging cluster that runs a new version, so
+// the writes of the applications go to both without changing them. The
+// points keep their time and sequence number, writing one twice
+// overwrites it, so the replay is resumed from the checkpoint after a
+// restart and the last batch is written again.
+//
+// The wal of a server has the writes that were sent to it, the tool has
+// to run for every server that gets writes. The wal removes the log
+// files once the other servers have their requests, the tool has to
+// keep up or the requests of the removed files are lost.
+//
+//   wal-replay -wal /opt/influxdb/shared/data/wal -host staging:8086 -checkpoint /var/lib/influxdb/wal-replay.checkpoint
+
+import (
+	"client"
+	"common"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"protocol"
+	"strings"
+	"time"
+	"wal"
+)
+
+type replayer struct {
+	config         client.ClientConfig
+	clients        map[string]*client.Client
+	databases      map[string]bool
+	batchSize      int
+	checkpointFile string
+	// the position after the last request that was written
+	position wal.TailPosition
+	batch    map[string][]*client.Series
+	points   int
+	written  int
+}
+
+func main() {
+	walDir := flag.String("wal", "", "the wal directory of the server")
+	host := flag.String("host", "", "the address of the http api of the other InfluxDB, or several separated by commas")
+	ssl := flag.Bool("ssl", false, "use https")
+	username := flag.String("u", "root", "the name of a cluster admin of the other InfluxDB")
+	password := flag.String("p", "root", "the password")
+	databases := flag.String("db", "", "the databases to replay separated by commas, all of them if it's empty")
+	batchSize := flag.Int("batch-size", 5000, "the number of points written at once")
+	checkpointFile := flag.String("checkpoint", "wal-replay.checkpoint", "the file the position in the wal is saved to and resumed from")
+	poll := flag.Duration("poll", time.Second, "how often the wal is read once the replay caught up")
+	once := flag.Bool("once", false, "exit once the replay caught up instead of tailing the wal")
+	flag.Parse()
+
+	if *walDir == "" || *host == "" {
+		fmt.Fprintln(os.Stderr, "-wal and -host must be set")
+		os.Exit(2)
+	}
+
+	self := &replayer{
+		config: client.ClientConfig{
+			Hosts:    strings.Split(*host, ","),
+			Username: *username,
+			Password: *password,
+			IsSecure: *ssl,
+			Retries:  client.DEFAULT_RETRIES,
+		},
+		clients:        map[string]*client.Client{},
+		databases:      map[string]bool{},
+		batchSize:      *batchSize,
+		checkpointFile: *checkpointFile,
+		batch:          map[string][]*client.Series{},
+	}
+	if *databases != "" {
+		for _, db := range strings.Split(*databases, ",") {
+			self.databases[db] = true
+		}
+	}
+	if err := self.loadCheckpoint(); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot load the checkpoint: %s\n", err)
+		os.Exit(1)
+	}
+	if self.position.Suffix > 0 {
+		fmt.Printf("Resuming at offset %d of log.%d\n", self.position.Offset, self.position.Suffix)
+	}
+
+	if err := self.run(*walDir, *poll, *once); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func (self *replayer) run(walDir string, poll time.Duration, once bool) error {
+	tailer := wal.NewTailer(walDir, self.position)
+	defer tailer.Close()
+	for {
+		request, position, err := tailer.Next()
+		switch err {
+		case nil:
+			self.add(request)
+			if self.points >= self.batchSize {
+				self.flush(position, poll)
+			}
+			continue
+		case wal.ErrLogFileRemoved:
+			fmt.Fprintln(os.Stderr, "A log file was removed before it was replayed, its remaining writes are lost")
+			continue
+		case io.EOF:
+			self.flush(position, poll)
+			if once {
+				fmt.Printf("Replayed %d points\n", self.written)
+				return nil
+			}
+			time.Sleep(poll)
+		default:
+			return err
+		}
+	}
+}
+
+func (self *replayer) add(request *protocol.Request) {
+	db := request.GetDatabase()
+	if request.GetType() != protocol.Request_WRITE || (len(self.databases) > 0 && !self.databases[db]) {
+		return
+	}
+	series := map[string]*protocol.Series{}
+	for i, s := range request.MultiSeries {
+		series[fmt.Sprint(i)] = s
+	}
+	// with the sequence numbers, so a point that is written again
+	// replaces itself
+	for _, s := range common.SerializeSeries(series, common.MicrosecondPrecision) {
+		self.batch[db] = append(self.batch[db], &client.Series{Name: s.Name, Columns: s.Columns, Points: s.Points})
+		self.points += len(s.Points)
+	}
+}
+
+// Writes the batch and saves the position after it, the writes are
+// retried until they succeed so no request is skipped
+func (self *replayer) flush(position wal.TailPosition, retryInterval time.Duration) {
+	for db, series := range self.batch {
+		for {
+			err := self.write(db, series)
+			if err == nil {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "Cannot write to %s, retrying: %s\n", db, err)
+			time.Sleep(retryInterval)
+		}
+		delete(self.batch, db)
+	}
+	if self.points > 0 {
+		self.written += self.points
+		self.points = 0
+		fmt.Printf("Replayed %d points\n", self.written)
+	}
+	if position == self.position {
+		return
+	}
+	self.position = position
+	if err := self.saveCheckpoint(); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot save the checkpoint: %s\n", err)
+	}
+}
+
+func (self *replayer) write(db string, series []*client.Series) error {
+	c := self.clients[db]
+	if c == nil {
+		config := self.config
+		config.Database = db
+		var err error
+		if c, err = client.NewClient(&config); err != nil {
+			return err
+		}
+		self.clients[db] = c
+	}
+	return c.WriteSeriesWithTimePrecision(series, client.Microsecond)
+}
+
+func (self *replayer) loadCheckpoint() error {
+	data, err := ioutil.ReadFile(self.checkpointFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &self.position)
+}
+
+func (self *replayer) saveCheckpoint() error {
+	data, err := json.Marshal(self.position)
+	if err != nil {
+		return err
+	}
+	// the checkpoint is replaced at once so it's never half written
+	tmp := self.checkpointFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, self.checkpointFile)
+}
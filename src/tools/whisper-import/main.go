@@ -0,0 +1,214 @@
+package main
+
+// Imports the whisper files of a carbon data directory into a
+// database. The metric name of a file is its path in the directory
+// with dots instead of slashes, e.g. servers/host1/cpu.wsp is
+// servers.host1.cpu, and it's mapped to a series with the same
+// templates as the graphite input plugin. The first template that
+// matches is used, the metrics that don't match any are written to a
+// series named after the metric.
+//
+//   whisper-import -dir /var/lib/carbon/whisper -db graphite -template 'servers.<host>.cpu cpu'
+
+import (
+	"api/graphite"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type series struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Points  [][]interface{} `json:"points"`
+}
+
+type templateFlags []string
+
+func (self *templateFlags) String() string {
+	return strings.Join(*self, ", ")
+}
+
+func (self *templateFlags) Set(value string) error {
+	*self = append(*self, value)
+	return nil
+}
+
+type importer struct {
+	writeUrl  string
+	templates []*graphite.Template
+	batchSize int
+	dryRun    bool
+	now       int64
+	batch     []*series
+	points    int
+	written   int
+}
+
+func main() {
+	dir := flag.String("dir", "", "the whisper data directory")
+	host := flag.String("host", "localhost:8086", "the address of the http api")
+	ssl := flag.Bool("ssl", false, "use https")
+	db := flag.String("db", "", "the database the points are written to")
+	username := flag.String("u", "root", "the user name")
+	password := flag.String("p", "root", "the password")
+	batchSize := flag.Int("batch-size", 5000, "the number of points written at once")
+	dryRun := flag.Bool("dry-run", false, "print the series every file is written to without writing them")
+	var templates templateFlags
+	flag.Var(&templates, "template", "a template that maps the metric names to series, can be given more than once")
+	flag.Parse()
+
+	if *dir == "" || *db == "" {
+		fmt.Fprintln(os.Stderr, "-dir and -db must be set")
+		os.Exit(2)
+	}
+	parsed, err := graphite.ParseTemplates(templates)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	scheme := "http"
+	if *ssl {
+		scheme = "https"
+	}
+	params := url.Values{}
+	params.Set("u", *username)
+	params.Set("p", *password)
+	params.Set("time_precision", "s")
+	self := &importer{
+		writeUrl:  fmt.Sprintf("%s://%s/db/%s/series?%s", scheme, *host, url.QueryEscape(*db), params.Encode()),
+		templates: parsed,
+		batchSize: *batchSize,
+		dryRun:    *dryRun,
+		now:       time.Now().Unix(),
+	}
+
+	failed := 0
+	err = filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".wsp" {
+			return nil
+		}
+		if err := self.importFile(*dir, path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			failed++
+		}
+		return nil
+	})
+	if err == nil {
+		err = self.flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d points, %d files failed\n", self.written, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Returns the metric name of the whisper file
+func metricName(dir, path string) (string, error) {
+	relative, err := filepath.Rel(dir, path)
+	if err != nil {
+		return "", err
+	}
+	relative = strings.TrimSuffix(relative, ".wsp")
+	return strings.Replace(filepath.ToSlash(relative), "/", ".", -1), nil
+}
+
+// Returns the series of the metric without its points
+func (self *importer) series(metric string) *series {
+	for _, template := range self.templates {
+		name, columns, values, ok := template.Apply(metric)
+		if !ok {
+			continue
+		}
+		s := &series{Name: name, Columns: append([]string{"time", "value"}, columns...)}
+		// the columns of the template have the same value in every point
+		point := make([]interface{}, 0, len(s.Columns))
+		point = append(point, nil, nil)
+		for _, value := range values {
+			point = append(point, value)
+		}
+		s.Points = [][]interface{}{point}
+		return s
+	}
+	return &series{Name: metric, Columns: []string{"time", "value"}, Points: [][]interface{}{{nil, nil}}}
+}
+
+func (self *importer) importFile(dir, path string) error {
+	metric, err := metricName(dir, path)
+	if err != nil {
+		return err
+	}
+	points, err := readWhisper(path, self.now)
+	if err != nil {
+		return err
+	}
+	template := self.series(metric)
+	if self.dryRun {
+		fmt.Printf("%s -> %s %v, %d points\n", metric, template.Name, template.Columns, len(points))
+		return nil
+	}
+
+	for len(points) > 0 {
+		n := self.batchSize - self.points
+		if n > len(points) {
+			n = len(points)
+		}
+		s := &series{Name: template.Name, Columns: template.Columns}
+		for _, p := range points[:n] {
+			point := make([]interface{}, len(template.Points[0]))
+			copy(point, template.Points[0])
+			point[0] = p.timestamp
+			point[1] = p.value
+			s.Points = append(s.Points, point)
+		}
+		self.batch = append(self.batch, s)
+		self.points += n
+		points = points[n:]
+		if self.points >= self.batchSize {
+			if err := self.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (self *importer) flush() error {
+	if self.points == 0 {
+		return nil
+	}
+	data, err := json.Marshal(self.batch)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(self.writeUrl, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	self.written += self.points
+	fmt.Printf("Wrote %d points\n", self.written)
+	self.batch = nil
+	self.points = 0
+	return nil
+}
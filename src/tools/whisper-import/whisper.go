@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// the sizes of the parts of a whisper file, all the numbers are big
+// endian
+const (
+	WHISPER_METADATA_SIZE = 16
+	WHISPER_ARCHIVE_SIZE  = 12
+	WHISPER_POINT_SIZE    = 12
+)
+
+type whisperArchive struct {
+	offset          uint32
+	secondsPerPoint uint32
+	points          uint32
+}
+
+func (self *whisperArchive) retention() int64 {
+	return int64(self.secondsPerPoint) * int64(self.points)
+}
+
+type whisperPoint struct {
+	timestamp int64
+	value     float64
+}
+
+type whisperPoints []whisperPoint
+
+func (self whisperPoints) Len() int           { return len(self) }
+func (self whisperPoints) Less(i, j int) bool { return self[i].timestamp < self[j].timestamp }
+func (self whisperPoints) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+// Reads the points of a whisper file, oldest first. The archives are
+// ordered from the most precise to the least precise, the points of an
+// archive are only used for the time that the more precise archives
+// don't cover. The slots of the archives that weren't written or that
+// are older than the retention of their archive are skipped.
+func readWhisper(fileName string, now int64) (whisperPoints, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	metadata := make([]byte, WHISPER_METADATA_SIZE)
+	if _, err := io.ReadFull(f, metadata); err != nil {
+		return nil, fmt.Errorf("Cannot read the header: %s", err)
+	}
+	archiveCount := binary.BigEndian.Uint32(metadata[12:16])
+	if archiveCount == 0 || archiveCount > 64 {
+		return nil, fmt.Errorf("Invalid number of archives %d", archiveCount)
+	}
+
+	archives := make([]*whisperArchive, archiveCount)
+	info := make([]byte, WHISPER_ARCHIVE_SIZE)
+	for i := range archives {
+		if _, err := io.ReadFull(f, info); err != nil {
+			return nil, fmt.Errorf("Cannot read archive %d: %s", i, err)
+		}
+		archives[i] = &whisperArchive{
+			offset:          binary.BigEndian.Uint32(info[0:4]),
+			secondsPerPoint: binary.BigEndian.Uint32(info[4:8]),
+			points:          binary.BigEndian.Uint32(info[8:12]),
+		}
+		if archives[i].secondsPerPoint == 0 {
+			return nil, fmt.Errorf("Invalid archive %d, it has no precision", i)
+		}
+	}
+
+	points := whisperPoints{}
+	// the oldest time covered by the archives that were read
+	covered := int64(math.MaxInt64)
+	for i, archive := range archives {
+		data := make([]byte, int(archive.points)*WHISPER_POINT_SIZE)
+		if _, err := f.ReadAt(data, int64(archive.offset)); err != nil {
+			return nil, fmt.Errorf("Cannot read the points of archive %d: %s", i, err)
+		}
+		oldest := now - archive.retention()
+		for j := 0; j < len(data); j += WHISPER_POINT_SIZE {
+			timestamp := int64(binary.BigEndian.Uint32(data[j : j+4]))
+			value := math.Float64frombits(binary.BigEndian.Uint64(data[j+4 : j+12]))
+			if timestamp == 0 || timestamp <= oldest || timestamp >= covered || math.IsNaN(value) {
+				continue
+			}
+			points = append(points, whisperPoint{timestamp, value})
+		}
+		if oldest < covered {
+			covered = oldest
+		}
+	}
+	sort.Sort(points)
+	return points, nil
+}
@@ -0,0 +1,50 @@
+package wal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The version of the bookmark and the index files, it's their first line
+const FORMAT_VERSION = 1
+
+// Checks that the bookmark and the index files in the wal directory
+// can be read by this version, without opening the wal
+func CheckVersion(dir string) error {
+	files, err := filepath.Glob(path.Join(dir, "index.*"))
+	if err != nil {
+		return err
+	}
+	files = append(files, path.Join(dir, "bookmark"))
+	for _, file := range files {
+		version, err := readVersion(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot read the version of %s: %s", file, err)
+		}
+		if version > FORMAT_VERSION {
+			return fmt.Errorf("%s has version %d, this version of InfluxDB supports up to %d", file, version, FORMAT_VERSION)
+		}
+	}
+	return nil
+}
+
+func readVersion(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(line))
+}
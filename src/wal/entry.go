@@ -25,3 +25,7 @@ type appendEntry struct {
 	request      *protocol.Request
 	shardId      uint32
 }
+
+type statusEntry struct {
+	status chan *Status
+}
@@ -77,7 +77,7 @@ func (self *GlobalState) writeToFile() error {
 }
 
 func (self *GlobalState) write(w io.Writer) error {
-	fmt.Fprintf(w, "%d\n", 1) // write the version
+	fmt.Fprintf(w, "%d\n", FORMAT_VERSION) // write the version
 	return gob.NewEncoder(w).Encode(self)
 }
 
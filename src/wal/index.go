@@ -37,8 +37,8 @@ func newIndex(path string) (*index, error) {
 		return nil, err
 	}
 	if stat.Size() == 0 {
-		// append the version, which is 1 right now
-		fmt.Fprintf(f, "%d\n", 1)
+		// append the version
+		fmt.Fprintf(f, "%d\n", FORMAT_VERSION)
 	}
 	if _, err = f.Seek(0, os.SEEK_SET); err != nil {
 		return nil, err
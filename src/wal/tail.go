@@ -0,0 +1,167 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"protocol"
+	"strconv"
+	"strings"
+
+	"code.google.com/p/goprotobuf/proto"
+)
+
+// Returned by Tailer.Next when the log file it was reading was removed
+// by the wal before all its requests were read, the requests that
+// weren't read are lost and the tailer continues with the next file
+var ErrLogFileRemoved = errors.New("The log file was removed before it was read")
+
+// A position in the log files of a wal, the suffix of the log file and
+// the offset of the next request in it. The zero position is the start
+// of the oldest log file.
+type TailPosition struct {
+	Suffix int   `json:"suffix"`
+	Offset int64 `json:"offset"`
+}
+
+// Reads the requests as they're appended to the log files of a wal,
+// e.g. the wal of a running server. It only reads the files, so it can
+// run in another process.
+type Tailer struct {
+	dir      string
+	position TailPosition
+	file     *os.File
+}
+
+func NewTailer(dir string, position TailPosition) *Tailer {
+	return &Tailer{dir: dir, position: position}
+}
+
+// Returns the next request of the log files with its request number and
+// its shard id set, and the position after it. Returns io.EOF if all
+// the requests were read, the requests that are appended later are
+// returned by the next calls.
+func (self *Tailer) Next() (*protocol.Request, TailPosition, error) {
+	for {
+		if self.file == nil {
+			if err := self.open(); err != nil {
+				return nil, self.position, err
+			}
+		}
+		request, err := self.read()
+		if err != io.EOF {
+			return request, self.position, err
+		}
+		// the wal only writes to the last log file, but it appends the
+		// last request of a file right before it creates the next one.
+		// The file is read to its end once more after the newer file
+		// shows up, nothing is appended to it after that.
+		next, err := self.nextSuffix(self.position.Suffix)
+		if err != nil {
+			return nil, self.position, err
+		}
+		if next == 0 {
+			return nil, self.position, io.EOF
+		}
+		request, err = self.read()
+		if err != io.EOF {
+			return request, self.position, err
+		}
+		self.Close()
+		self.position = TailPosition{next, 0}
+	}
+}
+
+func (self *Tailer) Close() error {
+	if self.file == nil {
+		return nil
+	}
+	err := self.file.Close()
+	self.file = nil
+	return err
+}
+
+func (self *Tailer) open() error {
+	if self.position.Suffix == 0 {
+		first, err := self.nextSuffix(0)
+		if err != nil {
+			return err
+		}
+		if first == 0 {
+			return io.EOF
+		}
+		self.position = TailPosition{first, 0}
+	}
+	file, err := os.Open(path.Join(self.dir, fmt.Sprintf("log.%d", self.position.Suffix)))
+	if os.IsNotExist(err) {
+		next, err := self.nextSuffix(self.position.Suffix)
+		if err != nil {
+			return err
+		}
+		self.position = TailPosition{next, 0}
+		return ErrLogFileRemoved
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(self.position.Offset, os.SEEK_SET); err != nil {
+		file.Close()
+		return err
+	}
+	self.file = file
+	return nil
+}
+
+// Reads the request at the position, a request that is being written
+// is read again by the next call
+func (self *Tailer) read() (*protocol.Request, error) {
+	hdr := &entryHeader{}
+	n, err := hdr.Read(self.file)
+	if err == nil {
+		data := make([]byte, hdr.length)
+		_, err = io.ReadFull(self.file, data)
+		if err == nil {
+			request := &protocol.Request{}
+			if err := request.Decode(data); err != nil {
+				return nil, fmt.Errorf("Cannot decode the request at %d in log.%d: %s", self.position.Offset, self.position.Suffix, err)
+			}
+			request.RequestNumber = proto.Uint32(hdr.requestNumber)
+			request.ShardId = proto.Uint32(hdr.shardId)
+			self.position.Offset += int64(n) + int64(hdr.length)
+			return request, nil
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err = self.file.Seek(self.position.Offset, os.SEEK_SET)
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	return nil, err
+}
+
+// Returns the smallest suffix of the log files that is larger than the
+// given one, 0 if there's none
+func (self *Tailer) nextSuffix(suffix int) (int, error) {
+	infos, err := ioutil.ReadDir(self.dir)
+	if err != nil {
+		return 0, err
+	}
+	next := 0
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), "log.") {
+			continue
+		}
+		s, err := strconv.Atoi(strings.TrimPrefix(info.Name(), "log."))
+		if err != nil {
+			continue
+		}
+		if s > suffix && (next == 0 || s < next) {
+			next = s
+		}
+	}
+	return next, nil
+}
@@ -8,12 +8,19 @@ import (
 	"path"
 	"protocol"
 	"sort"
+	"stats"
 	"strings"
+	"time"
 
 	"code.google.com/p/goprotobuf/proto"
 	logger "code.google.com/p/log4go"
 )
 
+var (
+	walAppends       = stats.NewCounter("walAppends")
+	walAppendLatency = stats.NewHistogram("walAppendLatencyMs")
+)
+
 type WAL struct {
 	state             *GlobalState
 	config            *configuration.Configuration
@@ -22,6 +29,9 @@ type WAL struct {
 	serverId          uint32
 	nextLogFileSuffix int
 	entries           chan interface{}
+	// the error of the last append that failed, cleared by the next
+	// append that succeeds
+	lastAppendError error
 
 	// counters to force index creation, bookmark and flushing
 	requestsSinceLastFlush    int
@@ -252,6 +262,8 @@ func (self *WAL) processEntries() {
 				continue
 			}
 			x.confirmation <- &confirmation{0, self.index()}
+		case *statusEntry:
+			x.status <- self.status()
 		case *closeEntry:
 			x.confirmation <- &confirmation{0, self.processClose(x.shouldBookmark)}
 			logger.Info("Closing wal")
@@ -285,6 +297,7 @@ func (self *WAL) processAppendEntry(e *appendEntry) {
 
 	if len(self.logFiles) == 0 {
 		if _, err := self.createNewLog(nextRequestNumber); err != nil {
+			self.lastAppendError = err
 			e.confirmation <- &confirmation{0, err}
 			return
 		}
@@ -296,6 +309,7 @@ func (self *WAL) processAppendEntry(e *appendEntry) {
 	logger.Debug("appending request %d", e.request.GetRequestNumber())
 	err := lastLogFile.appendRequest(e.request, e.shardId)
 	if err != nil {
+		self.lastAppendError = err
 		e.confirmation <- &confirmation{0, err}
 		return
 	}
@@ -306,7 +320,11 @@ func (self *WAL) processAppendEntry(e *appendEntry) {
 	self.requestsSinceLastFlush++
 	self.requestsSinceRotation++
 	logger.Debug("requestsSinceRotation: %d", self.requestsSinceRotation)
+	self.lastAppendError = nil
 	if rotated, err := self.rotateTheLogFile(nextRequestNumber); err != nil || rotated {
+		if err != nil {
+			self.lastAppendError = err
+		}
 		e.confirmation <- &confirmation{e.request.GetRequestNumber(), err}
 		return
 	}
@@ -385,6 +403,8 @@ func (self *WAL) openLog(logFileName string) (*log, *index, error) {
 // Will assign sequence numbers if null. Returns a unique id that
 // should be marked as committed for each server as it gets confirmed.
 func (self *WAL) AssignSequenceNumbersAndLog(request *protocol.Request, shard Shard) (uint32, error) {
+	walAppends.Inc()
+	defer walAppendLatency.Since(time.Now())
 	confirmationChan := make(chan *confirmation)
 	self.entries <- &appendEntry{confirmationChan, request, shard.Id()}
 	confirmation := <-confirmationChan
@@ -396,6 +416,32 @@ func (self *WAL) AssignSequenceNumbersAndLog(request *protocol.Request, shard Sh
 	return confirmation.requestNumber, confirmation.err
 }
 
+// The state of the wal as it's reported by the health checks
+type Status struct {
+	LogFiles             int    `json:"logFiles"`
+	LargestRequestNumber uint32 `json:"largestRequestNumber"`
+	LastError            string `json:"lastError,omitempty"`
+}
+
+// Returns the current status, blocks until the wal processed the
+// entries that were queued before the call
+func (self *WAL) Status() *Status {
+	statusChan := make(chan *Status)
+	self.entries <- &statusEntry{statusChan}
+	return <-statusChan
+}
+
+func (self *WAL) status() *Status {
+	status := &Status{
+		LogFiles:             len(self.logFiles),
+		LargestRequestNumber: self.state.LargestRequestNumber,
+	}
+	if self.lastAppendError != nil {
+		status.LastError = self.lastAppendError.Error()
+	}
+	return status
+}
+
 // returns the first log file that contains the given request number
 func (self *WAL) firstLogFile() int {
 	for idx, logIndex := range self.logIndex {
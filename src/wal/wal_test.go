@@ -4,6 +4,8 @@ import (
 	. "checkers"
 	"configuration"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
 	"path"
@@ -116,6 +118,16 @@ func (_ *WalSuite) TestRequestNumberAssignmentRecovery(c *C) {
 	c.Assert(id, Equals, uint32(3))
 }
 
+func (_ *WalSuite) TestStatus(c *C) {
+	wal := newWal(c)
+	_, err := wal.AssignSequenceNumbersAndLog(generateRequest(2), &MockShard{id: 1})
+	c.Assert(err, IsNil)
+	status := wal.Status()
+	c.Assert(status.LogFiles, Equals, 1)
+	c.Assert(status.LastError, Equals, "")
+	c.Assert(status.LargestRequestNumber, Equals, uint32(1))
+}
+
 func (_ *WalSuite) TestLogFilesCompaction(c *C) {
 	wal := newWal(c)
 	wal.config.WalRequestsPerLogFile = 2000
@@ -188,6 +200,19 @@ func (_ *WalSuite) TestAutoBookmark(c *C) {
 	c.Assert(s.LargestRequestNumber, Equals, uint32(2))
 }
 
+func (_ *WalSuite) TestCheckVersion(c *C) {
+	wal := newWal(c)
+	wal.config.WalBookmarkAfterRequests = 1
+	_, err := wal.AssignSequenceNumbersAndLog(generateRequest(2), &MockShard{id: 1})
+	c.Assert(err, IsNil)
+	c.Assert(CheckVersion(wal.config.WalDir), IsNil)
+
+	// a bookmark written by a newer version
+	bookmarkPath := path.Join(wal.config.WalDir, "bookmark")
+	c.Assert(ioutil.WriteFile(bookmarkPath, []byte(fmt.Sprintf("%d\n", FORMAT_VERSION+1)), 0644), IsNil)
+	c.Assert(CheckVersion(wal.config.WalDir), NotNil)
+}
+
 func (_ *WalSuite) TestSequenceNumberRecovery(c *C) {
 	wal := newWal(c)
 	serverId := uint32(10)
@@ -531,3 +556,78 @@ func (_ *WalSuite) TestSequenceNumberAssignmentPerServer(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(request.MultiSeries[0].Points[0].GetSequenceNumber(), Not(Equals), anotherRequest.MultiSeries[0].Points[0].GetSequenceNumber())
 }
+
+func (_ *WalSuite) TestTailer(c *C) {
+	wal := newWal(c)
+	wal.config.WalRequestsPerLogFile = 10
+	tailer := NewTailer(wal.config.WalDir, TailPosition{})
+	defer tailer.Close()
+	_, _, err := tailer.Next()
+	c.Assert(err, Equals, io.EOF)
+
+	var position TailPosition
+	for i := 0; i < 25; i++ {
+		_, err := wal.AssignSequenceNumbersAndLog(generateRequest(2), &MockShard{id: 3})
+		c.Assert(err, IsNil)
+		if i == 14 {
+			// reads the requests of the first and the second log file
+			for j := 0; j <= i; j++ {
+				request, p, err := tailer.Next()
+				c.Assert(err, IsNil)
+				c.Assert(request.GetRequestNumber(), Equals, uint32(j+1))
+				c.Assert(request.GetShardId(), Equals, uint32(3))
+				c.Assert(request.MultiSeries[0].Points[0].SequenceNumber, NotNil)
+				position = p
+			}
+			_, _, err = tailer.Next()
+			c.Assert(err, Equals, io.EOF)
+		}
+	}
+
+	// a tailer that starts from a position reads the requests after it
+	tailer = NewTailer(wal.config.WalDir, position)
+	defer tailer.Close()
+	for i := 16; i <= 25; i++ {
+		request, _, err := tailer.Next()
+		c.Assert(err, IsNil)
+		c.Assert(request.GetRequestNumber(), Equals, uint32(i))
+	}
+	_, _, err = tailer.Next()
+	c.Assert(err, Equals, io.EOF)
+}
+
+// The wal appends the last request of a log file right before it
+// creates the next one, the tailer reads it even if it got to the end
+// of the file before the request was appended
+func (_ *WalSuite) TestTailerReadsTheRequestsAppendedBeforeARollover(c *C) {
+	wal := newWal(c)
+	wal.config.WalRequestsPerLogFile = 2
+	requests := 500
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < requests; i++ {
+			wal.AssignSequenceNumbersAndLog(generateRequest(1), &MockShard{id: 1})
+		}
+	}()
+
+	tailer := NewTailer(wal.config.WalDir, TailPosition{})
+	defer tailer.Close()
+	writing := true
+	for next := 1; next <= requests; {
+		request, _, err := tailer.Next()
+		if err == io.EOF {
+			c.Assert(writing, Equals, true, Commentf("request %d is missing", next))
+			select {
+			case <-done:
+				writing = false
+			default:
+			}
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(request.GetRequestNumber(), Equals, uint32(next))
+		next++
+	}
+	<-done
+}